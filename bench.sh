@@ -0,0 +1,33 @@
+#!/bin/bash
+
+# Runs the core and store benchmarks and saves the results, optionally comparing them against a previous run.
+# Usage: ./bench.sh [BASELINE_FILE]
+
+baseline=$1
+count=${COUNT:-5}
+
+output_dir="build"
+
+# Ensure the output directory exists
+mkdir -p $output_dir
+
+output_name=$output_dir/bench-$(git rev-parse --short HEAD 2>/dev/null || echo local).txt
+
+echo "Running benchmarks ($count runs)..."
+go test -run '^$' -bench . -benchmem -count $count ./core ./store | tee $output_name
+
+if [ ${PIPESTATUS[0]} -ne 0 ]; then
+  echo "Benchmarks failed"
+  exit 1
+fi
+
+echo "Results saved to '$output_name'."
+
+# Compare against the baseline
+if [ -n "$baseline" ]; then
+  if ! command -v benchstat > /dev/null; then
+    echo "benchstat not found, install it with: go install golang.org/x/perf/cmd/benchstat@latest"
+    exit 1
+  fi
+  benchstat $baseline $output_name
+fi
@@ -36,4 +36,13 @@ for target in "${targets[@]}"; do
   fi
 done
 
+# Build the client core for browser wallets. (See wasm/main.go)
+echo "Building for js/wasm..."
+GOOS=js GOARCH=wasm go build -o $output_dir/ziba.wasm ./wasm
+
+if [ $? -ne 0 ]; then
+  echo "Failed to build for js/wasm"
+  exit 1
+fi
+
 echo "Build completed. Binaries are in the '$output_dir' directory."
@@ -1,11 +1,28 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"math/big"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 	"ziba/core"
 	"ziba/network"
 	"ziba/store"
@@ -16,18 +33,330 @@ import (
 // flags
 var (
 	flags struct {
-		address  string
-		bank     string
-		identity string
-		user     string
-		inspect  bool
+		address              string
+		bank                 string
+		identity             string
+		user                 string
+		inspect              bool
+		escrow               time.Duration
+		memo                 string
+		release              string
+		coin                 uint32
+		account              uint32
+		reason               string
+		file                 string
+		passphrase           string
+		custody              bool
+		removeMain           bool
+		accgenPassphrase     string
+		withdrawalPassphrase string
+		threshold            int
+		shares               int
+		share                []string
+		mintShare            []string
+		nodes                []string
+		rejectLegacy         bool
+		requireBinding       bool
+		currency             string
+		value                int64
+		amount               int64
+		denominations        []int64
+		bankServer           string
+		to                   string
+		rate                 string
+		token                string
+		pool                 int
+		bits                 int
+		workers              int
+		timeout              time.Duration
+		tlsMinVersion        string
+		tlsCipherSuites      []string
+		tlsServerName        string
+		tlsSystemRoots       bool
+		acmeDomains          []string
+		acmeEmail            string
+		acmeListen           string
+		certCheck            time.Duration
+		certWarning          time.Duration
+		hosts                []string
+		admin                string
+		adminCert            string
+		client               uint32
+		reject               bool
+		admission            string
+		policy               string
+		alert                int64
+		evidence             string
+		requireToken         bool
+		work                 int
+		quota                int
+		quotaWindow          time.Duration
+		count                int
+		parallel             int
+		note                 string
+		otlpEndpoint         string
+		otlpInsecure         bool
+		otlpService          string
+		transcripts          string
+		fix                  bool
+		purge                time.Duration
+		format               string
+		table                string
+		exportFormat         string
+		params               string
+		collector            string
+		beneficiary          string
+		subAccount           string
+		limit                int64
+		period               time.Duration
+		refill               string
+		refillThreshold      int
+		refillTarget         int
+		refillValue          int64
+		disable              bool
+		interval             time.Duration
+		expiring             time.Duration
+		once                 bool
+		webSocket            bool
+		relay                bool
+		relayServer          string
+		autoAccept           int64
+		yes                  bool
+		dbMaxConns           int
+		dbIdleConns          int
+		dbBusyTimeout        time.Duration
+		dbPragmas            []string
+		operationLog         time.Duration
+		cryptoWorkers        int
+		cryptoQueue          int
+		coinValidity         time.Duration
+		coinValidities       []string
+		exchangeGrace        time.Duration
+		expiringWithin       time.Duration
+		quiet                bool
+		quarantine           bool
+		since                time.Duration
+		bucket               time.Duration
+		dispute              int64
 	}
+
+	// flushTraces flushes the remaining spans, when tracing is enabled.
+	flushTraces func(context.Context) error
+
+	// walletLock is the lock of the user's wallet, held by commands modifying it. (See lockWallet)
+	walletLock *store.WalletLock
+
+	// agentClient is the client of the user's running wallet agent, through which proxied commands run. (See proxyAgent)
+	agentClient *network.AgentClient
 )
 
+// walletAnnotation marks the commands modifying the user's wallet.
+const walletAnnotation = "wallet"
+
+// lockWallet marks cmds as modifying the user's wallet, so that its lock is held while they run.
+func lockWallet(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		if cmd.Annotations == nil {
+			cmd.Annotations = make(map[string]string)
+		}
+		cmd.Annotations[walletAnnotation] = "lock"
+	}
+}
+
+// agentAnnotation marks the commands proxied through the user's wallet agent, when it's running.
+const agentAnnotation = "agent"
+
+// proxyAgent marks cmds as proxied through the user's wallet agent, which holds the wallet while it runs. Without a
+// running agent, they access the wallet directly.
+func proxyAgent(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		if cmd.Annotations == nil {
+			cmd.Annotations = make(map[string]string)
+		}
+		cmd.Annotations[agentAnnotation] = "proxy"
+	}
+}
+
+// requestAgent executes request through the user's wallet agent, and prints its output.
+func requestAgent(request network.AgentRequest) {
+	output, err := agentClient.Execute(&request)
+	fmt.Print(output)
+	if err != nil {
+		log.Fatalf("wallet agent failed: %v", err)
+	}
+}
+
+// ageValue is a time.Duration flag also taking days, e.g. 5d.
+type ageValue time.Duration
+
+// newAgeValue returns the flag of the duration at p, set to value.
+func newAgeValue(value time.Duration, p *time.Duration) *ageValue {
+	*p = value
+	return (*ageValue)(p)
+}
+
+// Set.
+func (age *ageValue) Set(s string) error {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid age %q", s)
+		}
+		*age = ageValue(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*age = ageValue(duration)
+	return nil
+}
+
+// String prints whole days as such, e.g. 5d.
+func (age *ageValue) String() string {
+	duration := time.Duration(*age)
+	if duration == 0 {
+		return "0"
+	}
+	if duration%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", duration/(24*time.Hour))
+	}
+	return duration.String()
+}
+
+// Type.
+func (age *ageValue) Type() string {
+	return "age"
+}
+
+// UnmarshalJSON takes the age as a string, e.g. "5d" or "1h".
+func (age *ageValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return age.Set(s)
+}
+
+// expiryWarning is how long before their expiration the coins of the user's wallet are warned of. (See warnExpiring)
+const expiryWarning = 5 * 24 * time.Hour
+
+// warnExpiring warns of the coins of the user's wallet expiring within expiryWarning, if any. The check is skipped if
+// the wallet can't be read, e.g. before it's created.
+func warnExpiring() {
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		return
+	}
+	dbPath := store.DatabasePath(directory, flags.user)
+	if _, err := os.Stat(dbPath); err != nil {
+		return
+	}
+	clientStore, err := new(store.ClientStore).NewReadOnly(dbPath)
+	if err != nil {
+		return
+	}
+	defer clientStore.Close()
+
+	count, err := clientStore.CountExpiringCoins(core.Now().Add(expiryWarning))
+	if err != nil || count == 0 {
+		return
+	}
+	coins := fmt.Sprintf("%d coins expire", count)
+	if count == 1 {
+		coins = "1 coin expires"
+	}
+	age := newAgeValue(expiryWarning, new(time.Duration))
+	fmt.Fprintf(os.Stderr, "%s within %d days — run `ziba user exchange --user %s --server SERVER --expiring %s`\n",
+		coins, expiryWarning/(24*time.Hour), flags.user, age)
+}
+
 // ziba
 var ziba = &cobra.Command{
 	Use:   "ziba command",
 	Short: "A cryptographic-based CLI payment application.",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Apply TLS policy.
+		if cmd.Flags().Changed("tls-min-version") {
+			version, err := network.ParseTLSVersion(flags.tlsMinVersion)
+			if err != nil {
+				return err
+			}
+			network.Policy.MinVersion = version
+		}
+		if cmd.Flags().Changed("tls-cipher-suites") {
+			suites, err := network.ParseCipherSuites(flags.tlsCipherSuites)
+			if err != nil {
+				return err
+			}
+			network.Policy.CipherSuites = suites
+		}
+		network.Policy.ServerName = flags.tlsServerName
+		network.Policy.SystemRoots = flags.tlsSystemRoots
+
+		// Enable tracing.
+		if flags.otlpEndpoint != "" {
+			flush, err := network.EnableTracing(context.Background(), flags.otlpEndpoint, flags.otlpService, flags.otlpInsecure)
+			if err != nil {
+				return err
+			}
+			flushTraces = flush
+		}
+
+		// Record the payment sessions.
+		if len(flags.transcripts) > 0 {
+			if err := network.EnableTranscripts(flags.transcripts); err != nil {
+				return err
+			}
+		}
+
+		// Warn of the wallet's expiring coins.
+		if len(flags.user) > 0 && !flags.quiet {
+			warnExpiring()
+		}
+
+		// Proxy through the user's wallet agent, when it's running. (It holds the wallet's lock)
+		if cmd.Annotations[agentAnnotation] != "" && len(flags.user) > 0 {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			if client := new(network.AgentClient).New(agentSocket(directory, flags.user)); client.Running() {
+				agentClient = client
+				return nil
+			}
+		}
+
+		// Lock the user's wallet.
+		if cmd.Annotations[walletAnnotation] != "" && len(flags.user) > 0 {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			lock, err := store.LockWallet(store.DatabasePath(directory, flags.user))
+			if err != nil {
+				return err
+			}
+			walletLock = lock
+		}
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		// Unlock the user's wallet.
+		if walletLock != nil {
+			if err := walletLock.Unlock(); err != nil {
+				log.Printf("failed to unlock wallet: %v", err)
+			}
+		}
+
+		// Flush traces.
+		if flushTraces != nil {
+			if err := flushTraces(context.Background()); err != nil {
+				log.Printf("failed to flush traces: %v", err)
+			}
+		}
+	},
 }
 
 // user
@@ -54,11 +383,21 @@ var userInit = &cobra.Command{
 		}
 
 		// Create local database.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to open database: %v", err)
+		}
 
 		// Create certificates.
-		network.CreateCertificate(directory, flags.user)
+		cert, key, err := network.GenerateCertificate(flags.hosts...)
+		if err != nil {
+			log.Fatalf("failed to create certificate: %v", err)
+		}
+		own := &store.Certificate{Role: store.Role_Own, Name: flags.user, Cert: cert, Key: key}
+		if err := clientStore.Certificates().Write(own); err != nil {
+			log.Fatalf("failed to write certificate: %v", err)
+		}
 	},
 }
 
@@ -75,7 +414,7 @@ var accgen = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
@@ -96,27 +435,26 @@ var accgen = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
 
 		// Execute SetupClient.
-		setupClient := new(network.SetupClient).New(flags.address, store)
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
 		if err := setupClient.Execute(); err != nil {
 			log.Fatal(err)
 		}
 
 		// Load TLS client configuration.
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.address))
-		config, err := network.GetClientTLSConfig(certPath)
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
 		if err != nil {
 			log.Fatalf("failed to load certificate (client): %v", err)
 		}
 
 		// Execute AccgenClient.
-		client := new(network.AccgenClient).New(flags.address, store, config)
+		client := new(network.AccgenClient).New(flags.address, clientStore, config).Evidence(flags.evidence).Token(flags.token)
 		if err := client.Execute(); err != nil {
 			log.Fatal(err)
 		}
@@ -125,8 +463,8 @@ var accgen = &cobra.Command{
 
 // user withdraw
 var withdraw = &cobra.Command{
-	Use:   "withdraw --user USER --server SERVER",
-	Short: "Withdraw 1 coin from USER's client account at SERVER.",
+	Use:   "withdraw --user USER --server SERVER [--count N] [--parallel SESSIONS]",
+	Short: "Withdraw 1 coin (or an amount as coins) from USER's client account at SERVER.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Check that database file exists.
 		if len(flags.user) == 0 {
@@ -136,7 +474,7 @@ var withdraw = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
@@ -147,9 +485,31 @@ var withdraw = &cobra.Command{
 			return fmt.Errorf("required \"server\" flag not set")
 		}
 
+		if flags.count < 1 {
+			return fmt.Errorf("invalid \"count\" flag: %d", flags.count)
+		}
+		if flags.parallel < 1 {
+			return fmt.Errorf("invalid \"parallel\" flag: %d", flags.parallel)
+		}
+
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// Withdraw the amount as coins of the denominations, or count coins of value.
+		values := slices.Repeat([]int64{flags.value}, flags.count)
+		if flags.amount > 0 {
+			if err := core.ValidateDenominations(flags.denominations); err != nil {
+				log.Fatalf("invalid \"denominations\" flag: %v", flags.denominations)
+			}
+			values = core.Breakdown(flags.amount, flags.denominations)
+		}
+
+		// Proxy through the wallet agent.
+		if agentClient != nil {
+			requestAgent(network.AgentRequest{Operation: network.AgentWithdraw, Server: flags.address, Currency: flags.currency, Values: values})
+			return
+		}
+
 		// Get ziba directory.
 		directory, err := store.GetZibaDir()
 		if err != nil {
@@ -157,29 +517,53 @@ var withdraw = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
 
 		// Execute SetupClient.
-		setupClient := new(network.SetupClient).New(flags.address, store)
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
 		if err := setupClient.Execute(); err != nil {
 			log.Fatal(err)
 		}
 
 		// Load TLS client configuration.
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.address))
-		config, err := network.GetClientTLSConfig(certPath)
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
 		if err != nil {
 			log.Fatalf("failed to load certificate (client): %v", err)
 		}
 
 		// Execute WithdrawClient.
-		client := new(network.WithdrawalClient).New(flags.address, store, config)
-		if err := client.Execute(); err != nil {
-			log.Fatal(err)
+		if flags.parallel == 1 {
+			for _, value := range values {
+				client := new(network.WithdrawalClient).New(flags.address, clientStore, config).Currency(flags.currency).Value(value)
+				if err := client.Execute(); err != nil {
+					log.Fatal(err)
+				}
+			}
+			return
+		}
+
+		// Stop starting sessions on interrupt.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Run the sessions of each value concurrently.
+		counts := make(map[int64]int)
+		for _, value := range values {
+			counts[value]++
+		}
+		for _, value := range values {
+			if counts[value] == 0 {
+				continue
+			}
+			client := new(network.WithdrawalClient).New(flags.address, clientStore, config).Currency(flags.currency).Value(value)
+			if err := client.WithdrawN(ctx, counts[value], flags.parallel); err != nil {
+				log.Fatal(err)
+			}
+			counts[value] = 0
 		}
 	},
 }
@@ -189,7 +573,7 @@ var wgUser sync.WaitGroup
 
 // user charge
 var charge = &cobra.Command{
-	Use:   "charge  --user USER --bank BANKNAME",
+	Use:   "charge  --user USER --bank BANKNAME --bank-server SERVER [--amount AMOUNT [--currency CODE]] [--once] [--timeout TIMEOUT] [--auto-accept MAX] [--yes] [--websocket] [--relay RELAY]",
 	Short: "USER starts payment server.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Check that database file exists.
@@ -200,7 +584,7 @@ var charge = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
@@ -212,9 +596,17 @@ var charge = &cobra.Command{
 			return fmt.Errorf("required \"bank\" flag not set")
 		}
 
+		// Attest the certificate at the bank.
+		if len(flags.bankServer) == 0 {
+			return fmt.Errorf("required \"bank-server\" flag not set")
+		}
+
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// Legacy coins transition window.
+		core.AcceptLegacyCoins = !flags.rejectLegacy
+
 		// Get ziba directory.
 		directory, err := store.GetZibaDir()
 		if err != nil {
@@ -222,23 +614,56 @@ var charge = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
-		store.BankName = flags.bank
+		clientStore.BankName = flags.bank
+
+		// Precompute exponentiation tables.
+		client, err := clientStore.ReadClient()
+		if err != nil || client == nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+		client.Bank.Precompute()
+
+		// Keep a pool of pre-generated coins.
+		if flags.pool > 0 {
+			go func() {
+				for {
+					if _, err := pregenerateCoins(clientStore, flags.pool); err != nil {
+						log.Printf("failed to pre-generate coins: %v", err)
+					}
+					time.Sleep(time.Minute)
+				}
+			}()
+		}
 
 		// Load TLS server configuration.
-		keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", flags.user))
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.user))
-		config, err := network.GetServerTLSConfig(certPath, keyPath)
+		cert, err := clientStore.Certificates().Read(store.Role_Own, flags.user)
+		if err != nil {
+			log.Fatalf("failed to read certificate: %v", err)
+		}
+		config, err := network.GetStoredServerTLSConfig(cert)
 		if err != nil {
 			log.Fatalf("failed to load certificate (server): %v", err)
 		}
 
+		// Load TLS client configuration.
+		bankConfig, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.bankServer)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute AttestationClient. (Payers only trust the certificate along with the bank's attestation)
+		attestation, err := new(network.AttestationClient).New(flags.bankServer, clientStore, bankConfig).Execute(cert)
+		if err != nil {
+			log.Fatalf("failed to execute AttestationClient: %v", err)
+		}
+
 		// Start GetServer.
-		getServer := new(network.GetServer).New(certPath)
+		getServer := new(network.GetServer).New(cert.Cert, attestation)
 		wgUser.Add(1)
 		go func() {
 			defer wgUser.Done()
@@ -249,7 +674,39 @@ var charge = &cobra.Command{
 
 		// Start PaymentServer.
 		wgUser.Add(1)
-		paymentServer := new(network.PaymentServer).New(store, config)
+		paid := make(chan int64, 1)
+		paymentServer := new(network.PaymentServer).New(clientStore, config).Amount(flags.currency, flags.amount).Notify(paid)
+		if flags.webSocket {
+			paymentServer.WebSocket()
+		}
+
+		// Take payments through the relay, at USER@RELAY. (Behind NAT)
+		if len(flags.relayServer) > 0 {
+			setupClient := new(network.SetupClient).New(flags.relayServer, clientStore)
+			if err := setupClient.Execute(); err != nil {
+				log.Fatal(err)
+			}
+			clientStore.BankName = flags.bank
+			relayTransport := new(network.RelayTransport).New(flags.relayServer, clientStore.Certificates(), config).Register(flags.user, cert, getServer)
+			paymentServer.Transport(relayTransport)
+		}
+
+		// Approve payments within the payer's auto-accept limit, and ask for the others. (Refused with --yes)
+		paymentServer.Approve(func(payer, currency string, amount int64) bool {
+			if flags.autoAccept > 0 {
+				err := clientStore.CheckPayerLimit(payer, currency, amount, flags.autoAccept)
+				if err == nil {
+					return true
+				}
+				log.Printf("Payment of %d %s from %s: %v", amount, currency, payer, err)
+				if flags.yes {
+					return false
+				}
+			} else if flags.yes {
+				return true
+			}
+			return confirm(fmt.Sprintf("Accept payment of %d %s from %s?", amount, currency, payer))
+		})
 		go func() {
 			defer wgUser.Done()
 			if err := paymentServer.Start(); err != nil {
@@ -257,15 +714,38 @@ var charge = &cobra.Command{
 			}
 		}()
 
-		// Don't exit main thread.
-		wgUser.Wait()
+		// Charge until the first payment (with --once) or the timeout, or forever.
+		var timeout <-chan time.Time
+		if flags.timeout > 0 {
+			timeout = time.After(flags.timeout)
+		}
+		for {
+			select {
+			case amount := <-paid:
+				log.Printf("Received payment of %d", amount)
+				if !flags.once {
+					continue
+				}
+			case <-timeout:
+				if flags.once {
+					log.Fatalf("no payment received within %s", flags.timeout)
+				}
+				log.Printf("Charge stopped after %s", flags.timeout)
+			}
+
+			// Let the payments being received finish.
+			if err := paymentServer.Shutdown(); err != nil {
+				log.Printf("failed to stop PaymentServer: %v", err)
+			}
+			return
+		}
 	},
 }
 
 // user pay
 var pay = &cobra.Command{
-	Use:   "pay --user USER --server SERVER --bank BANKNAME",
-	Short: "USER sends 1 coin to another user at SERVER.",
+	Use:   "pay --user USER --server SERVER --bank BANKNAME [--refill BANKSERVER]",
+	Short: "USER sends 1 coin (or an amount) to another user at SERVER, or MERCHANT@RELAY.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Check that database file exists.
 		if len(flags.user) == 0 {
@@ -275,7 +755,7 @@ var pay = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
@@ -293,6 +773,31 @@ var pay = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// Proxy through the wallet agent, confirming the payment up front unless --yes. (The agent can't ask)
+		if agentClient != nil {
+			paid := "a coin"
+			if flags.amount > 0 {
+				paid = strings.TrimSpace(fmt.Sprintf("%d %s", flags.amount, flags.currency))
+			}
+			if !flags.yes && !confirm(fmt.Sprintf("Pay %s to %s?", paid, flags.address)) {
+				log.Fatal(core.ErrPaymentDeclined)
+			}
+
+			requestAgent(network.AgentRequest{
+				Operation:     network.AgentPay,
+				Server:        flags.address,
+				Bank:          flags.bank,
+				Currency:      flags.currency,
+				Account:       flags.subAccount,
+				Memo:          flags.memo,
+				Amount:        flags.amount,
+				Escrow:        flags.escrow,
+				Denominations: flags.denominations,
+				BankServer:    flags.bankServer,
+			})
+			return
+		}
+
 		// Get ziba directory.
 		directory, err := store.GetZibaDir()
 		if err != nil {
@@ -300,38 +805,99 @@ var pay = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
-		store.BankName = flags.bank
+		clientStore.BankName = flags.bank
+
+		// Trust the relay of relay addresses, USER@RELAY.
+		if _, relayAddr, ok := strings.Cut(flags.address, "@"); ok {
+			setupClient := new(network.SetupClient).New(relayAddr, clientStore)
+			if err := setupClient.Execute(); err != nil {
+				log.Fatal(err)
+			}
+			clientStore.BankName = flags.bank
+		}
 
 		// Execute GetClient.
-		setupClient := new(network.GetClient).New(flags.address)
+		setupClient := new(network.GetClient).New(flags.address, clientStore)
 		if err := setupClient.Execute(); err != nil {
 			log.Fatal(err)
 		}
 
 		// Load TLS client configuration.
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.address))
-		config, err := network.GetClientTLSConfig(certPath)
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Merchant, flags.address)
 		if err != nil {
 			log.Fatalf("failed to load certificate (client): %v", err)
 		}
 
+		// Refill the wallet first, following its refill policies.
+		if len(flags.refill) > 0 {
+			if err := refillWallet(clientStore, flags.refill); err != nil {
+				log.Fatalf("failed to refill wallet: %v", err)
+			}
+			clientStore.BankName = flags.bank
+		}
+
+		// Execute AutoPaymentClient. (Non-escrowed payments of an amount)
+		if flags.amount > 0 && flags.escrow == 0 {
+			autoClient := new(network.AutoPaymentClient).New(flags.address, clientStore, config).Memo(flags.memo).Currency(flags.currency).Amount(flags.amount).Denominations(flags.denominations).Account(flags.subAccount)
+
+			// Exchange larger coins at the bank.
+			if len(flags.bankServer) > 0 {
+				setupClient := new(network.SetupClient).New(flags.bankServer, clientStore)
+				if err := setupClient.Execute(); err != nil {
+					log.Fatal(err)
+				}
+				bankConfig, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.bankServer)
+				if err != nil {
+					log.Fatalf("failed to load certificate (client): %v", err)
+				}
+				autoClient.Bank(flags.bankServer, bankConfig)
+			}
+
+			if !flags.yes {
+				autoClient.Confirm(confirmPayment)
+			}
+			if err := autoClient.Execute(); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
 		// Execute PaymentClient.
-		paymentClient := new(network.PaymentClient).New(flags.address, store, config)
+		paymentClient := new(network.PaymentClient).New(flags.address, clientStore, config).Escrow(flags.escrow).Memo(flags.memo).Currency(flags.currency).Amount(flags.amount).Account(flags.subAccount)
+		if !flags.yes {
+			paymentClient.Confirm(confirmPayment)
+		}
 		if err := paymentClient.Execute(); err != nil {
 			log.Fatal(err)
 		}
 	},
 }
 
+// confirmPayment shows the details of a payment stamped by the merchant, and asks the payer to confirm it.
+func confirmPayment(details *network.PaymentDetails) bool {
+	confirmMutex.Lock()
+	fmt.Printf("Merchant: %s (%s)\n", flags.address, details.Payee.Text(16))
+	fmt.Printf("Amount:   %d %s\n", details.Amount, details.Currency)
+	if len(details.Memo) > 0 {
+		fmt.Printf("Memo:     %q\n", details.Memo)
+	}
+	if !details.Escrow.IsZero() {
+		fmt.Printf("Escrow:   until %s\n", details.Escrow.Format(time.DateTime))
+	}
+	fmt.Printf("Date:     %s\n", details.Date.Format(time.DateTime))
+	confirmMutex.Unlock()
+	return confirm("Sign the coin? (Irreversible)")
+}
+
 // user deposit
 var deposit = &cobra.Command{
-	Use:   "deposit --user USER --server SERVER",
-	Short: "Deposit 1 coin to USER's client account at SERVER.",
+	Use:   "deposit --user USER --server SERVER [--beneficiary TOKEN]",
+	Short: "Deposit 1 coin to USER's client account at SERVER, or to the account that authorized USER.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Check that database file exists.
 		if len(flags.user) == 0 {
@@ -341,7 +907,7 @@ var deposit = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
@@ -355,6 +921,12 @@ var deposit = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// Proxy through the wallet agent.
+		if agentClient != nil {
+			requestAgent(network.AgentRequest{Operation: network.AgentDeposit, Server: flags.address, Currency: flags.currency, Release: flags.release, Beneficiary: flags.beneficiary})
+			return
+		}
+
 		// Get ziba directory.
 		directory, err := store.GetZibaDir()
 		if err != nil {
@@ -362,37 +934,50 @@ var deposit = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
 
 		// Execute SetupClient.
-		setupClient := new(network.SetupClient).New(flags.address, store)
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
 		if err := setupClient.Execute(); err != nil {
 			log.Fatal(err)
 		}
 
 		// Load TLS client configuration.
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.address))
-		config, err := network.GetClientTLSConfig(certPath)
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
 		if err != nil {
 			log.Fatalf("failed to load certificate (client): %v", err)
 		}
 
 		// Execute DepositClient.
-		depositClient := new(network.DepositClient).New(flags.address, store, config)
+		depositClient := new(network.DepositClient).New(flags.address, clientStore, config).Currency(flags.currency)
+		if len(flags.release) > 0 {
+			release, ok := new(big.Int).SetString(flags.release, 10)
+			if !ok {
+				log.Fatalf("invalid release secret: %s", flags.release)
+			}
+			depositClient.Release(release)
+		}
+		if len(flags.beneficiary) > 0 {
+			auth, err := core.ParseAuthorizationToken(flags.beneficiary)
+			if err != nil {
+				log.Fatalf("failed to import authorization token: %v", err)
+			}
+			depositClient.Beneficiary(auth)
+		}
 		if err := depositClient.Execute(); err != nil {
 			log.Fatal(err)
 		}
 	},
 }
 
-// user exchange
-var exchange = &cobra.Command{
-	Use:   "exchange --user USER --server SERVER",
-	Short: "Exchanges an old coin for a new one.",
+// user release
+var release = &cobra.Command{
+	Use:   "release --user USER --bank BANKNAME [--coin HASH]",
+	Short: "List escrowed payments, or reveal the release secret of the escrowed coin HASH.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Check that database file exists.
 		if len(flags.user) == 0 {
@@ -402,15 +987,15 @@ var exchange = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
 			}
 		}
 
-		if len(flags.address) == 0 {
-			return fmt.Errorf("required \"server\" flag not set")
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
 		}
 
 		return nil
@@ -423,37 +1008,53 @@ var exchange = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
+		clientStore.BankName = flags.bank
 
-		// Execute SetupClient.
-		setupClient := new(network.SetupClient).New(flags.address, store)
-		if err := setupClient.Execute(); err != nil {
-			log.Fatal(err)
+		// Read Client.
+		if _, err := clientStore.ReadClient(); err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
 		}
 
-		// Load TLS client configuration.
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.address))
-		config, err := network.GetClientTLSConfig(certPath)
+		// Read escrowed coins.
+		escrows, err := clientStore.ReadEscrows()
 		if err != nil {
-			log.Fatalf("failed to load certificate (client): %v", err)
+			log.Fatalf("failed to read escrowed coins from database: %v", err)
 		}
 
-		// Execute ExchangeClient.
-		exchangeClient := new(network.ExchangeClient).New(flags.address, store, config)
-		if err := exchangeClient.Execute(); err != nil {
-			log.Fatal(err)
+		// List escrowed payments made by this user.
+		if flags.coin == 0 {
+			fmt.Printf("%-10s %-23s\n", "CoinHash", "Timeout")
+			for _, escrowCoin := range escrows {
+				if escrowCoin.Release != nil {
+					fmt.Printf("%-10.10d %-23.23s\n", escrowCoin.Coin.Profile().Hash(), escrowCoin.Escrow.Timeout.String())
+				}
+			}
+			return
 		}
+
+		// Reveal the release secret, the coin now belongs to the payee.
+		for _, escrowCoin := range escrows {
+			if escrowCoin.Coin.Profile().Hash() == flags.coin && escrowCoin.Release != nil {
+				if err := clientStore.DeleteCoin(&escrowCoin.Coin, store.Operation_Payment); err != nil {
+					log.Fatalf("failed to delete coin from database: %v", err)
+				}
+				fmt.Printf("Release secret: %s\n", escrowCoin.Release)
+				return
+			}
+		}
+		log.Fatalf("no escrowed payment for coin %d", flags.coin)
 	},
 }
 
-// user inspect
-var userInspect = &cobra.Command{
-	Use:   "inspect [-f]",
-	Short: "View database information.",
+// user reclaim
+var reclaim = &cobra.Command{
+	Use:   "reclaim --user USER --server SERVER",
+	Short: "Reclaims an escrowed coin whose timeout has passed.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Check that database file exists.
 		if len(flags.user) == 0 {
@@ -463,12 +1064,17 @@ var userInspect = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
+			dbPath := store.DatabasePath(directory, flags.user)
 			_, err = os.Stat(dbPath)
 			if os.IsNotExist(err) {
 				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
 			}
 		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -479,95 +1085,3778 @@ var userInspect = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.user))
-		store, err := new(store.ClientStore).New(dbPath)
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
 
-		// Inspect.
-		if flags.inspect {
-			store.InspectFull()
-		} else {
-			store.Inspect()
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
 		}
-	},
-}
 
-// bank
-var bank = &cobra.Command{
-	Use:   "bank operation",
-	Short: "Perform bank operations.",
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute ReclaimClient.
+		reclaimClient := new(network.ReclaimClient).New(flags.address, clientStore, config)
+		if err := reclaimClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
 }
 
-// bank init
-var bankInit = &cobra.Command{
-	Use:   "init",
-	Short: "Initialize ziba system in current computer (as a bank).",
+// user change
+var change = &cobra.Command{
+	Use:   "change --user USER --server SERVER",
+	Short: "Collects the change of partial payments.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		if len(flags.bank) == 0 {
-			return fmt.Errorf("required \"bank\" flag not set")
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
 		}
-		if len(flags.identity) == 0 {
-			flags.identity = "main"
-			// return fmt.Errorf("required \"identity\" flag not set")
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
 		}
+
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get ziba directory.
 		directory, err := store.GetZibaDir()
 		if err != nil {
-			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
 		}
 
-		// Create Bank.
-		bank := new(core.Bank).New(core.Params)
-
-		// Create local database.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.bank))
-		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
 		if err != nil {
-			log.Fatalf("failed to open database: %v", err)
+			log.Fatalf("failed to create store: %v", err)
 		}
 
-		// Write Bank into database.
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute ChangeClient.
+		changeClient := new(network.ChangeClient).New(flags.address, clientStore, config)
+		if err := changeClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user revocations
+var revocations = &cobra.Command{
+	Use:   "revocations --user USER --server SERVER",
+	Short: "Fetches the bank's revocation list, checked before accepting payments.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute RevocationClient.
+		revocationClient := new(network.RevocationClient).New(flags.address, clientStore, config)
+		if err := revocationClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user review
+var review = &cobra.Command{
+	Use:   "review --user USER --server SERVER --id N",
+	Short: "Polls the bank's review of an operation of USER held by its fraud rules.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+		if flags.alert <= 0 {
+			return fmt.Errorf("required \"id\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute ReviewClient.
+		review, err := new(network.ReviewClient).New(flags.address, clientStore, config).Alert(flags.alert).Execute()
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch review.Status {
+		case store.Alert_Pending:
+			log.Printf("Alert %d: pending review", review.Alert)
+		case store.Alert_Approved:
+			log.Printf("Alert %d: approved, try the operation again", review.Alert)
+		default:
+			log.Printf("Alert %d: %s", review.Alert, review.Status)
+		}
+	},
+}
+
+// user status
+var status = &cobra.Command{
+	Use:   "status --user USER --server SERVER",
+	Short: "Polls the outcome of the withdrawals of USER cut short, finishing the coins issued meanwhile.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute StatusClient.
+		if err := new(network.StatusClient).New(flags.address, clientStore, config).Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user resume
+var resume = &cobra.Command{
+	Use:   "resume --user USER --bank BANKNAME",
+	Short: "Settles the payments of USER cut short: the unsigned ones are aborted, the signed ones resumed.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		clientStore.BankName = flags.bank
+
+		// Execute ResumeClient.
+		if err := new(network.ResumeClient).New(clientStore).Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user close
+var closeAccount = &cobra.Command{
+	Use:   "close --user USER --bank BANKNAME --server SERVER [--yes]",
+	Short: "Closes the bank account of USER: its coins are surrendered and its balances paid out. (Irreversible)",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if !flags.yes && !confirm(fmt.Sprintf("Close the account of %s at %s? (Irreversible)", flags.user, flags.bank)) {
+			return
+		}
+
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		clientStore.BankName = flags.bank
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute ClosureClient.
+		if err := new(network.ClosureClient).New(flags.address, clientStore, config).Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user transfer
+var transfer = &cobra.Command{
+	Use:   "transfer --user USER --bank BANKNAME [--server SERVER --account HASH --amount AMOUNT [--currency CODE] [--yes]]",
+	Short: "Transfer AMOUNT of USER's balance to the account HASH at the same bank, without coins, or print USER's account hash.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		// Print the account hash otherwise.
+		if flags.account == 0 {
+			return nil
+		}
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+		if flags.amount <= 0 {
+			return fmt.Errorf("\"amount\" must be positive")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		clientStore.BankName = flags.bank
+
+		// Print the account hash, for payers to transfer to.
+		if flags.account == 0 {
+			client, err := clientStore.ReadClient()
+			if err != nil {
+				log.Fatalf("failed to read Client from database: %v", err)
+			} else if client == nil {
+				log.Fatalf("no account for bank %s", flags.bank)
+			}
+			fmt.Println(client.Profile().Hash())
+			return
+		}
+
+		transferred := strings.TrimSpace(fmt.Sprintf("%d %s", flags.amount, flags.currency))
+		if !flags.yes && !confirm(fmt.Sprintf("Transfer %s to account %d?", transferred, flags.account)) {
+			return
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute TransferClient.
+		transferClient := new(network.TransferClient).New(flags.address, clientStore, config).
+			Payee(flags.account).Currency(flags.currency).Value(flags.amount)
+		if err := transferClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user gift
+var gift = &cobra.Command{
+	Use:   "gift --user USER --bank BANKNAME [--value VALUE] [--file FILE]",
+	Short: "Export a coin of USER as a one-time claim token, for another user to claim.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		// Bind to a bank account.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		if len(flags.file) > 0 {
+			if _, err := os.Stat(flags.file); err == nil {
+				return fmt.Errorf("file already exists: %s", flags.file)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		clientStore.BankName = flags.bank
+
+		// Read client and coins.
+		if _, err := clientStore.ReadClient(); err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+		coins, err := clientStore.ReadCoins()
+		if err != nil {
+			log.Fatalf("failed to read coins from database: %v", err)
+		}
+
+		// Grab 1 coin of the currency and value.
+		var coin *core.Coin
+		for i := range coins {
+			if core.NormalizeCurrency(coins[i].Params.Currency) != core.NormalizeCurrency(flags.currency) {
+				continue
+			}
+			if flags.value == 0 || core.NormalizeValue(coins[i].Params.Value) == flags.value {
+				coin = &coins[i]
+				break
+			}
+		}
+		if coin == nil {
+			log.Fatalf("no coin of value %d in %s", flags.value, core.NormalizeCurrency(flags.currency))
+		}
+
+		// Export claim token.
+		token, err := core.NewGiftToken(coin)
+		if err != nil {
+			log.Fatalf("failed to export coin: %v", err)
+		}
+		if len(flags.file) > 0 {
+			if err := os.WriteFile(flags.file, []byte(token+"\n"), 0600); err != nil { // rw- --- ---
+				log.Fatalf("failed to write claim token: %v", err)
+			}
+		} else {
+			fmt.Println(token)
+		}
+
+		// Delete gifted coin.
+		if err := clientStore.DeleteCoin(coin, store.Operation_Gift); err != nil {
+			log.Fatalf("failed to delete coin from database: %v", err)
+		}
+
+		log.Printf("Gifted coin %d of value %d", coin.Profile().Hash(), core.NormalizeValue(coin.Params.Value))
+	},
+}
+
+// user claim
+var claim = &cobra.Command{
+	Use:   "claim --user USER --server SERVER (--file FILE | --token TOKEN)",
+	Short: "Claim a gifted coin, exchanging it at SERVER for a coin of USER.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
+		if len(flags.file) == 0 && len(flags.token) == 0 {
+			return fmt.Errorf("required \"file\" or \"token\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read claim token.
+		token := flags.token
+		if len(flags.file) > 0 {
+			data, err := os.ReadFile(flags.file)
+			if err != nil {
+				log.Fatalf("failed to read claim token: %v", err)
+			}
+			token = string(data)
+		}
+		coin, err := core.ParseGiftToken(token)
+		if err != nil {
+			log.Fatalf("failed to import claim token: %v", err)
+		}
+
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Verify the coin was signed by the bank.
+		client, err := clientStore.ReadClient()
+		if err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+		mint, err := clientStore.ReadMint(client, coin.Params.Currency)
+		if err != nil {
+			log.Fatalf("failed to read mint from database: %v", err)
+		}
+		if err := coin.VerifyGift(mint); err != nil {
+			log.Fatalf("invalid gifted coin: %v", err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute ExchangeClient.
+		exchangeClient := new(network.ExchangeClient).New(flags.address, clientStore, config).Claim(coin)
+		if err := exchangeClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user authorize
+var authorize = &cobra.Command{
+	Use:   "authorize --user USER --bank BANKNAME [--collector HASH] [--file FILE]",
+	Short: "Authorize the account HASH to deposit coins to USER's balance, or print USER's identity hash.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		// Bind to a bank account.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		if len(flags.file) > 0 {
+			if _, err := os.Stat(flags.file); err == nil {
+				return fmt.Errorf("file already exists: %s", flags.file)
+			}
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		clientStore.BankName = flags.bank
+
+		// Read client.
+		client, err := clientStore.ReadClient()
+		if err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+
+		// Print the identity hash, for beneficiaries to authorize.
+		if len(flags.collector) == 0 {
+			fmt.Println(client.Profile().IdentityHash.Text(16))
+			return
+		}
+		collector, ok := new(big.Int).SetString(flags.collector, 16)
+		if !ok {
+			log.Fatalf("invalid collector's identity hash: %s", flags.collector)
+		}
+
+		// Export authorization token.
+		auth := client.AuthorizeDeposit(&core.ClientProfile{IdentityHash: collector})
+		token, err := core.NewAuthorizationToken(auth)
+		if err != nil {
+			log.Fatalf("failed to export authorization: %v", err)
+		}
+		if len(flags.file) > 0 {
+			if err := os.WriteFile(flags.file, []byte(token+"\n"), 0600); err != nil { // rw- --- ---
+				log.Fatalf("failed to write authorization token: %v", err)
+			}
+		} else {
+			fmt.Println(token)
+		}
+
+		log.Printf("Authorized %s to deposit to account %d", flags.collector, auth.Beneficiary.Hash())
+	},
+}
+
+// user renew
+var renew = &cobra.Command{
+	Use:   "renew --user USER --server SERVER",
+	Short: "Renews the account credentials.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute RenewalClient.
+		renewalClient := new(network.RenewalClient).New(flags.address, clientStore, config)
+		if err := renewalClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user exchange
+var exchange = &cobra.Command{
+	Use:   "exchange --user USER --server SERVER [--expiring AGE]",
+	Short: "Exchanges old coins for new ones, possibly of other values or currency.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Proxy through the wallet agent.
+		if agentClient != nil {
+			request := network.AgentRequest{
+				Operation: network.AgentExchange,
+				Server:    flags.address,
+				Currency:  flags.currency,
+				Account:   flags.subAccount,
+				To:        flags.to,
+				Value:     flags.value,
+				Amount:    flags.amount,
+				Expiring:  flags.expiringWithin,
+			}
+			if cmd.Flags().Changed("denominations") {
+				request.Denominations = flags.denominations
+			}
+			requestAgent(request)
+			return
+		}
+
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+
+		// Load TLS client configuration.
+		config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute AutoExchangeClient, for expiring coins.
+		if flags.expiringWithin > 0 {
+			exchanged, err := new(network.AutoExchangeClient).New(flags.address, clientStore, config).Expiring(flags.expiringWithin).Execute()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Exchanged %d expiring coins\n", exchanged)
+			return
+		}
+
+		// Execute ExchangeClient.
+		exchangeClient := new(network.ExchangeClient).New(flags.address, clientStore, config).Currency(flags.currency).Split(flags.value).To(flags.to).Consolidate(flags.amount).Account(flags.subAccount)
+		if cmd.Flags().Changed("denominations") {
+			exchangeClient.Denominations(flags.denominations)
+		}
+		if err := exchangeClient.Execute(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// user export-identity
+var exportIdentity = &cobra.Command{
+	Use:   "export-identity --user USER --file FILE",
+	Short: "Export USER's keys, credentials, coins and certificates into an encrypted bundle.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		}
+		if len(flags.file) == 0 {
+			return fmt.Errorf("required \"file\" flag not set")
+		}
+		if _, err := os.Stat(flags.file); err == nil {
+			return fmt.Errorf("file already exists: %s", flags.file)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Export identity.
+		bundle, err := store.ExportIdentity(flags.user, readPassphrase(flags.passphrase, "Passphrase"))
+		if err != nil {
+			log.Fatalf("failed to export identity: %v", err)
+		}
+
+		// Write bundle.
+		if err := os.WriteFile(flags.file, bundle, 0600); err != nil { // rw- --- ---
+			log.Fatalf("failed to write identity bundle: %v", err)
+		}
+
+		log.Printf("Identity of %s exported to %s", flags.user, flags.file)
+	},
+}
+
+// user import-identity
+var importIdentity = &cobra.Command{
+	Use:   "import-identity --file FILE",
+	Short: "Restore a user from an encrypted bundle created by export-identity.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.file) == 0 {
+			return fmt.Errorf("required \"file\" flag not set")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read bundle.
+		bundle, err := os.ReadFile(flags.file)
+		if err != nil {
+			log.Fatalf("failed to read identity bundle: %v", err)
+		}
+
+		// Import identity.
+		identity, err := store.ImportIdentity(bundle, readPassphrase(flags.passphrase, "Passphrase"))
+		if err != nil {
+			log.Fatalf("failed to import identity: %v", err)
+		}
+
+		log.Printf("Identity of %s (exported %s) imported", identity.User, identity.Created.Format(time.RFC3339))
+	},
+}
+
+// stdin.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase returns value, or prompts for a passphrase using prompt if value is not set.
+func readPassphrase(value, prompt string) string {
+	if len(value) > 0 {
+		return value
+	}
+	fmt.Printf("%s: ", prompt)
+	passphrase, err := stdin.ReadString('\n')
+	if err != nil {
+		log.Fatalf("failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(passphrase, "\r\n")
+}
+
+// confirmMutex serializes the prompts of concurrent confirmations.
+var confirmMutex sync.Mutex
+
+// confirm asks the user to confirm prompt, and reports whether they answered yes.
+func confirm(prompt string) bool {
+	confirmMutex.Lock()
+	defer confirmMutex.Unlock()
+
+	fmt.Printf("%s [y/N]: ", prompt)
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		log.Printf("failed to read confirmation: %v", err)
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// user pregenerate
+var pregenerate = &cobra.Command{
+	Use:   "pregenerate --user USER --bank BANKNAME --count N",
+	Short: "Prepare coins in advance to speed up withdrawals.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		// Bind to a bank account.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		store, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		store.BankName = flags.bank
+
+		// Pre-generate coins.
+		count, err := pregenerateCoins(store, flags.pool)
+		if err != nil {
+			log.Fatalf("failed to pre-generate coins: %v", err)
+		}
+		log.Printf("Pre-generated %d coins", count)
+	},
+}
+
+// pregenerateCoins fills the pool of pre-generated coins of clientStore up to size coins, and returns the number of
+// coins generated. The client is read on every call, so that coins are bound to its current contract.
+func pregenerateCoins(clientStore *store.ClientStore, size int) (int, error) {
+	client, err := clientStore.ReadClient()
+	if err != nil {
+		return 0, err
+	} else if client == nil {
+		return 0, fmt.Errorf("no account for bank %s", clientStore.BankName)
+	}
+
+	count, err := clientStore.CountPartialCoins()
+	if err != nil {
+		return 0, err
+	}
+
+	var coins []*core.Coin
+	for i := count; i < size; i++ {
+		coin := client.NewPartialCoin(nil)
+		if coin == nil {
+			return 0, fmt.Errorf("failed to generate coin")
+		}
+		coins = append(coins, coin)
+	}
+
+	return len(coins), clientStore.WritePartialCoins(coins)
+}
+
+// inspectionFormats are the output formats of the inspect commands.
+var inspectionFormats = []string{"table", "json", "csv"}
+
+// exportFormats are the output formats of the export commands.
+var exportFormats = []string{"csv", "json"}
+
+// checkFormat checks that format is one of formats.
+func checkFormat(format string, formats []string) error {
+	for _, f := range formats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, expected one of: %s", format, strings.Join(formats, ", "))
+}
+
+// checkExport checks the flags of the export commands.
+func checkExport() error {
+	if len(flags.table) == 0 {
+		return fmt.Errorf("required \"table\" flag not set")
+	}
+	if len(flags.file) > 0 {
+		if _, err := os.Stat(flags.file); err == nil {
+			return fmt.Errorf("file already exists: %s", flags.file)
+		}
+	}
+	return checkFormat(flags.exportFormat, exportFormats)
+}
+
+// inspectionTable is a table of an inspection, as printed.
+type inspectionTable struct {
+	Name    string     `json:"name"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+	widths  []int      // Printed width of each column, as a text table.
+}
+
+// inspectionTables returns the tables of inspection, a store.BankInspection or store.ClientInspection. The tables and
+// columns tagged `inspect:"full"` are only included if full.
+func inspectionTables(inspection interface{}, full bool) []inspectionTable {
+	value := reflect.Indirect(reflect.ValueOf(inspection))
+	var tables []inspectionTable
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !full && field.Tag.Get("inspect") == "full" {
+			continue
+		}
+
+		// Columns.
+		table := inspectionTable{Name: tableName(field.Name)}
+		var columns []int
+		for j := 0; j < field.Type.Elem().NumField(); j++ {
+			column := field.Type.Elem().Field(j)
+			if !full && column.Tag.Get("inspect") == "full" {
+				continue
+			}
+			columns = append(columns, j)
+			table.Columns = append(table.Columns, column.Name)
+			switch {
+			case j == 0:
+				table.widths = append(table.widths, 5)
+			case column.Type == reflect.TypeOf(time.Time{}):
+				table.widths = append(table.widths, len(time.DateTime))
+			default:
+				table.widths = append(table.widths, 10)
+			}
+		}
+
+		// Rows.
+		rows := value.Field(i)
+		for j := 0; j < rows.Len(); j++ {
+			row := make([]string, len(columns))
+			for k, column := range columns {
+				row[k] = inspectionValue(rows.Index(j).Field(column).Interface())
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// tableName returns the printed name of an inspection's table, e.g. "COIN PARAMS" for CoinParams.
+func tableName(field string) string {
+	var name strings.Builder
+	for i, r := range field {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			name.WriteByte(' ')
+		}
+		name.WriteRune(r)
+	}
+	return strings.ToUpper(name.String())
+}
+
+// inspectionValue returns the printed value of an inspection's column. (Numbers in decimal)
+func inspectionValue(value interface{}) string {
+	switch value := value.(type) {
+	case *big.Int:
+		if value == nil {
+			return ""
+		}
+		return value.String()
+	case time.Time:
+		return value.UTC().Format(time.DateTime)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// printInspection prints the tables of inspection to w, in format. (See inspectionFormats)
+func printInspection(w io.Writer, inspection interface{}, full bool, format string) error {
+	tables := inspectionTables(inspection, full)
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tables)
+
+	case "csv":
+		// Each table is its name, its header and its rows, followed by an empty line.
+		writer := csv.NewWriter(w)
+		for _, table := range tables {
+			writer.Write([]string{table.Name})
+			writer.Write(table.Columns)
+			writer.WriteAll(table.Rows)
+			fmt.Fprintln(w)
+		}
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		// Numbers are truncated to fit their columns.
+		for _, table := range tables {
+			fmt.Fprintf(w, "\n%s\n", table.Name)
+			for i, column := range table.Columns {
+				fmt.Fprintf(w, "%-*.*s ", table.widths[i], table.widths[i], column)
+			}
+			fmt.Fprintln(w)
+			for _, row := range table.Rows {
+				for i, value := range row {
+					fmt.Fprintf(w, "%-*.*s ", table.widths[i], table.widths[i], value)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+		return nil
+	}
+}
+
+// exportInspection writes the --table table of inspection to the --file file, or to the standard output, in the
+// --format format. (See exportFormats)
+func exportInspection(inspection interface{}) error {
+	tables := inspectionTables(inspection, flags.inspect)
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = strings.ToLower(strings.ReplaceAll(table.Name, " ", "-"))
+		if names[i] != flags.table {
+			continue
+		}
+
+		// Open file.
+		w := io.Writer(os.Stdout)
+		if len(flags.file) > 0 {
+			file, err := os.OpenFile(flags.file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // rw- --- ---
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+
+		// Write table.
+		if flags.exportFormat == "json" {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(table)
+		}
+		writer := csv.NewWriter(w)
+		writer.Write(table.Columns)
+		writer.WriteAll(table.Rows)
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown table %q, expected one of: %s", flags.table, strings.Join(names, ", "))
+}
+
+// user inspect
+var userInspect = &cobra.Command{
+	Use:   "inspect [-f] [--format table|json|csv]",
+	Short: "View database information.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkFormat(flags.format, inspectionFormats); err != nil {
+			return err
+		}
+		return requireUserDatabase(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		inspection := inspectUser()
+		if err := printInspection(os.Stdout, inspection, flags.inspect, flags.format); err != nil {
+			log.Fatalf("failed to print inspection: %v", err)
+		}
+	},
+}
+
+// requireUserDatabase checks that the user's database exists.
+func requireUserDatabase(cmd *cobra.Command, args []string) error {
+	// Check that database file exists.
+	if len(flags.user) == 0 {
+		return fmt.Errorf("required \"user\" flag not set")
+	} else {
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			return err
+		}
+		dbPath := store.DatabasePath(directory, flags.user)
+		_, err = os.Stat(dbPath)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+		}
+	}
+	return nil
+}
+
+// inspectUser returns the inspection of the user's database, full with the --full flag.
+func inspectUser() *store.ClientInspection {
+	// Get ziba directory.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		log.Fatalf("failed to retrieve ziba directory: %v", err)
+	}
+
+	// Create store.
+	dbPath := store.DatabasePath(directory, flags.user)
+	store, err := new(store.ClientStore).NewReadOnly(dbPath)
+	if err != nil {
+		log.Fatalf("failed to create store: %v", err)
+	}
+
+	// Inspect.
+	inspect := store.Inspect
+	if flags.inspect {
+		inspect = store.InspectFull
+	}
+	inspection, err := inspect()
+	if err != nil {
+		log.Fatalf("failed to inspect database: %v", err)
+	}
+	return inspection
+}
+
+// user export
+var userExport = &cobra.Command{
+	Use:   "export --user USER --table TABLE [-f] [--format csv|json] [--file FILE]",
+	Short: "Export a table of the database, e.g. coins, for spreadsheets.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkExport(); err != nil {
+			return err
+		}
+		return requireUserDatabase(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportInspection(inspectUser()); err != nil {
+			log.Fatalf("failed to export table: %v", err)
+		}
+	},
+}
+
+// user coins
+var userCoins = &cobra.Command{
+	Use:   "coins --user USER --bank BANKNAME",
+	Short: "Report the denomination breakdown of USER's coins.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		// Bind to a bank account.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		store, err := new(store.ClientStore).NewReadOnly(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		store.BankName = flags.bank
+
+		// Read client, coins and uncollected change.
+		if _, err := store.ReadClient(); err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+		coins, err := store.ReadCoins()
+		if err != nil {
+			log.Fatalf("failed to read coins from database: %v", err)
+		}
+		changes, err := store.ReadChanges()
+		if err != nil {
+			log.Fatalf("failed to read change from database: %v", err)
+		}
+
+		// Count coins per currency and value.
+		type denomination struct {
+			currency string
+			value    int64
+		}
+		counts := make(map[denomination]int)
+		for _, coin := range coins {
+			counts[denomination{core.NormalizeCurrency(coin.Params.Currency), core.NormalizeValue(coin.Params.Value)}]++
+		}
+		denominations := make([]denomination, 0, len(counts))
+		for d := range counts {
+			denominations = append(denominations, d)
+		}
+		sort.Slice(denominations, func(i, j int) bool {
+			if denominations[i].currency != denominations[j].currency {
+				return denominations[i].currency < denominations[j].currency
+			}
+			return denominations[i].value > denominations[j].value
+		})
+
+		// Report.
+		fmt.Printf("\nCOINS\n")
+		fmt.Printf("%-8s %-10s %-6s %-10s\n", "Currency", "Value", "Count", "Subtotal")
+		totals := make(map[string]int64)
+		for _, d := range denominations {
+			subtotal := d.value * int64(counts[d])
+			totals[d.currency] += subtotal
+			fmt.Printf("%-8s %-10d %-6d %-10d\n", d.currency, d.value, counts[d], subtotal)
+		}
+		fmt.Printf("\nTOTAL\n")
+		for _, d := range denominations {
+			if total, ok := totals[d.currency]; ok {
+				fmt.Printf("%-8s %-10d\n", d.currency, total)
+				delete(totals, d.currency)
+			}
+		}
+		if len(changes) > 0 {
+			fmt.Printf("\n%d uncollected change\n", len(changes))
+		}
+	},
+}
+
+// requireUserBank checks that the user's database exists, and that a bank account is bound to.
+func requireUserBank(cmd *cobra.Command, args []string) error {
+	if err := requireUserDatabase(cmd, args); err != nil {
+		return err
+	}
+
+	// Bind to a bank account.
+	if len(flags.bank) == 0 {
+		return fmt.Errorf("required \"bank\" flag not set")
+	}
+
+	return nil
+}
+
+// openWallet opens the user's database, bound to the bank's account.
+func openWallet() *store.ClientStore {
+	// Get ziba directory.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		log.Fatalf("failed to retrieve ziba directory: %v", err)
+	}
+
+	// Create store.
+	dbPath := store.DatabasePath(directory, flags.user)
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		log.Fatalf("failed to create store: %v", err)
+	}
+	clientStore.BankName = flags.bank
+
+	// Read client.
+	if _, err := clientStore.ReadClient(); err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+	}
+	return clientStore
+}
+
+// user account
+var userAccount = &cobra.Command{
+	Use:   "account operation",
+	Short: "Partition the wallet's coins into sub-accounts, with their own spending limits.",
+}
+
+// user account list
+var accountList = &cobra.Command{
+	Use:     "list --user USER --bank BANKNAME",
+	Short:   "List the sub-accounts of USER, with their spending limits and balances.",
+	PreRunE: requireUserBank,
+	Run: func(cmd *cobra.Command, args []string) {
+		clientStore := openWallet()
+
+		// Read sub-accounts, the main account first.
+		accounts, err := clientStore.ReadSubAccounts()
+		if err != nil {
+			log.Fatalf("failed to read sub-accounts from database: %v", err)
+		}
+		accounts = append([]store.SubAccount{{}}, accounts...)
+
+		// Report.
+		fmt.Printf("%-16s %-10s %-10s %s\n", "Account", "Limit", "Period", "Balance")
+		for _, account := range accounts {
+			coins, err := clientStore.ReadAccountCoins(account.Name)
+			if err != nil {
+				log.Fatalf("failed to read coins from database: %v", err)
+			}
+			totals := make(map[string]int64)
+			for _, coin := range coins {
+				totals[core.NormalizeCurrency(coin.Params.Currency)] += core.NormalizeValue(coin.Params.Value)
+			}
+			currencies := make([]string, 0, len(totals))
+			for currency := range totals {
+				currencies = append(currencies, currency)
+			}
+			sort.Strings(currencies)
+			balances := make([]string, 0, len(currencies))
+			for _, currency := range currencies {
+				balances = append(balances, fmt.Sprintf("%d %s", totals[currency], currency))
+			}
+
+			name, limit, period := account.Name, "-", "-"
+			if name == "" {
+				name = "(main)"
+			}
+			if account.Limit > 0 {
+				limit, period = fmt.Sprint(account.Limit), account.Period.String()
+			}
+			fmt.Printf("%-16s %-10s %-10s %s\n", name, limit, period, strings.Join(balances, ", "))
+		}
+	},
+}
+
+// user account set
+var accountSet = &cobra.Command{
+	Use:   "set --user USER --bank BANKNAME --name NAME [--limit AMOUNT] [--period PERIOD]",
+	Short: "Create the sub-account NAME of USER, or update its spending limit.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.subAccount) == 0 {
+			return fmt.Errorf("required \"name\" flag not set")
+		}
+		if flags.limit < 0 {
+			return fmt.Errorf("invalid spending limit: %d", flags.limit)
+		}
+		if flags.period <= 0 {
+			return fmt.Errorf("invalid spending period: %s", flags.period)
+		}
+		return requireUserBank(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		clientStore := openWallet()
+
+		account := &store.SubAccount{Name: flags.subAccount, Limit: flags.limit, Period: flags.period}
+		if err := clientStore.WriteSubAccount(account); err != nil {
+			log.Fatalf("failed to write sub-account into database: %v", err)
+		}
+
+		if account.Limit > 0 {
+			log.Printf("Sub-account %s pays up to %d every %s", account.Name, account.Limit, account.Period)
+		} else {
+			log.Printf("Sub-account %s has no spending limit", account.Name)
+		}
+	},
+}
+
+// user account delete
+var accountDelete = &cobra.Command{
+	Use:   "delete --user USER --bank BANKNAME --name NAME",
+	Short: "Delete the sub-account NAME of USER, moving its coins back to the main account.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.subAccount) == 0 {
+			return fmt.Errorf("required \"name\" flag not set")
+		}
+		return requireUserBank(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		clientStore := openWallet()
+
+		if err := clientStore.DeleteSubAccount(flags.subAccount); err != nil {
+			log.Fatalf("failed to delete sub-account %s: %v", flags.subAccount, err)
+		}
+
+		log.Printf("Deleted sub-account %s", flags.subAccount)
+	},
+}
+
+// user account move
+var accountMove = &cobra.Command{
+	Use:   "move --user USER --bank BANKNAME --coin HASH [--to NAME]",
+	Short: "Move the coin HASH of USER into the sub-account NAME, or back to the main account.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("coin") {
+			return fmt.Errorf("required \"coin\" flag not set")
+		}
+		return requireUserBank(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		clientStore := openWallet()
+
+		// Grab the coin.
+		coins, err := clientStore.ReadCoins()
+		if err != nil {
+			log.Fatalf("failed to read coins from database: %v", err)
+		}
+		var coin *core.Coin
+		for i := range coins {
+			if coins[i].Profile().Hash() == flags.coin {
+				coin = &coins[i]
+				break
+			}
+		}
+		if coin == nil {
+			log.Fatalf("no coin %d in the wallet", flags.coin)
+		}
+
+		if err := clientStore.MoveCoin(coin, flags.subAccount); err != nil {
+			log.Fatalf("failed to move coin %d: %v", flags.coin, err)
+		}
+
+		if flags.subAccount == "" {
+			log.Printf("Moved coin %d to the main account", flags.coin)
+		} else {
+			log.Printf("Moved coin %d to sub-account %s", flags.coin, flags.subAccount)
+		}
+	},
+}
+
+// refillWallet runs the refill policies of the wallet in clientStore at the bank server. (See network.RefillClient)
+func refillWallet(clientStore *store.ClientStore, server string) error {
+	// Execute SetupClient.
+	setupClient := new(network.SetupClient).New(server, clientStore)
+	if err := setupClient.Execute(); err != nil {
+		return err
+	}
+
+	// Load TLS client configuration.
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, server)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate (client): %w", err)
+	}
+
+	// Execute RefillClient.
+	return new(network.RefillClient).New(server, clientStore, config).Execute()
+}
+
+// user refill
+var refill = &cobra.Command{
+	Use:   "refill --user USER --bank BANKNAME [--currency CODE] [--threshold N --target M [--value VALUE] | --disable]",
+	Short: "Show the refill policies of USER, or set the refill policy of a currency.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("threshold") {
+			if flags.refillThreshold < 1 {
+				return fmt.Errorf("invalid refill threshold: %d", flags.refillThreshold)
+			}
+			if flags.refillTarget < flags.refillThreshold {
+				return fmt.Errorf("refill target %d is below the threshold %d", flags.refillTarget, flags.refillThreshold)
+			}
+			if err := core.ValidateValue(flags.refillValue); err != nil {
+				return fmt.Errorf("invalid refill value: %d", flags.refillValue)
+			}
+		}
+		return requireUserBank(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		clientStore := openWallet()
+		currency := core.NormalizeCurrency(flags.currency)
+
+		// Disable the refill policy.
+		if flags.disable {
+			if err := clientStore.DeleteRefillPolicy(currency); err != nil {
+				log.Fatalf("failed to delete refill policy from database: %v", err)
+			}
+			log.Printf("Refill of %s disabled", currency)
+			return
+		}
+
+		// Set the refill policy.
+		if cmd.Flags().Changed("threshold") {
+			policy := &store.RefillPolicy{
+				Currency:  currency,
+				Threshold: flags.refillThreshold,
+				Target:    flags.refillTarget,
+				Value:     flags.refillValue,
+			}
+			if err := clientStore.WriteRefillPolicy(policy); err != nil {
+				log.Fatalf("failed to write refill policy into database: %v", err)
+			}
+			log.Printf("Below %d coins, %s is refilled up to %d coins of value %d", policy.Threshold, currency, policy.Target, policy.Value)
+			return
+		}
+
+		// Report.
+		policies, err := clientStore.ReadRefillPolicies()
+		if err != nil {
+			log.Fatalf("failed to read refill policies from database: %v", err)
+		}
+		fmt.Printf("%-8s %-10s %-10s %-10s\n", "Currency", "Threshold", "Target", "Value")
+		for _, policy := range policies {
+			fmt.Printf("%-8s %-10d %-10d %-10d\n", policy.Currency, policy.Threshold, policy.Target, policy.Value)
+		}
+	},
+}
+
+// agentSocket returns the path of the control socket of user's wallet agent.
+func agentSocket(directory, user string) string {
+	return filepath.Join(directory, fmt.Sprintf("%s.sock", user))
+}
+
+// user agent
+var agent = &cobra.Command{
+	Use:   "agent --user USER --bank BANKNAME --server SERVER [--interval INTERVAL] [--expiring AGE]",
+	Short: "Run the wallet agent of USER: receive payments, refill the wallet and exchange expiring coins at SERVER.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+		if flags.interval <= 0 {
+			return fmt.Errorf("invalid interval: %s", flags.interval)
+		}
+		return requireUserBank(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Stop on interrupt.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+		clientStore := openWallet()
+
+		// Execute SetupClient.
+		setupClient := new(network.SetupClient).New(flags.address, clientStore)
+		if err := setupClient.Execute(); err != nil {
+			log.Fatalf("failed to execute SetupClient: %v", err)
+		}
+
+		// Precompute exponentiation tables.
+		client, err := clientStore.ReadClient()
+		if err != nil || client == nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+		client.Bank.Precompute()
+
+		// Load TLS server configuration.
+		cert, err := clientStore.Certificates().Read(store.Role_Own, flags.user)
+		if err != nil {
+			log.Fatalf("failed to read certificate: %v", err)
+		}
+		config, err := network.GetStoredServerTLSConfig(cert)
+		if err != nil {
+			log.Fatalf("failed to load certificate (server): %v", err)
+		}
+
+		// Load TLS client configuration.
+		bankConfig, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+		if err != nil {
+			log.Fatalf("failed to load certificate (client): %v", err)
+		}
+
+		// Execute AttestationClient.
+		attestation, err := new(network.AttestationClient).New(flags.address, clientStore, bankConfig).Execute(cert)
+		if err != nil {
+			log.Fatalf("failed to execute AttestationClient: %v", err)
+		}
+
+		// Start GetServer.
+		getServer := new(network.GetServer).New(cert.Cert, attestation)
+		go func() {
+			if err := getServer.Start(); err != nil {
+				log.Fatalf("failed to start GetServer: %v", err)
+			}
+		}()
+
+		// Start PaymentServer.
+		var servers sync.WaitGroup
+		paymentServer := new(network.PaymentServer).New(clientStore, config)
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := paymentServer.Start(); err != nil {
+				log.Fatalf("failed to start PaymentServer: %v", err)
+			}
+		}()
+
+		// Start AgentServer.
+		agentServer := new(network.AgentServer).New(clientStore, agentSocket(directory, flags.user), flags.address, bankConfig)
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := agentServer.Start(); err != nil {
+				log.Fatalf("failed to start AgentServer: %v", err)
+			}
+		}()
+
+		log.Printf("Wallet agent of %s running, every %s", flags.user, flags.interval)
+		for {
+			// Refill.
+			if err := refillWallet(clientStore, flags.address); err != nil {
+				log.Printf("failed to refill wallet: %v", err)
+			}
+
+			// Exchange expiring coins.
+			if flags.expiring > 0 {
+				if _, err := new(network.AutoExchangeClient).New(flags.address, clientStore, bankConfig).Expiring(flags.expiring).Execute(); err != nil {
+					log.Printf("failed to exchange expiring coins: %v", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				// Stop serving, letting the payments being received finish.
+				log.Print("Stopping wallet agent")
+				if err := agentServer.Shutdown(); err != nil {
+					log.Printf("failed to stop AgentServer: %v", err)
+				}
+				if err := paymentServer.Shutdown(); err != nil {
+					log.Printf("failed to stop PaymentServer: %v", err)
+				}
+				servers.Wait()
+				log.Print("Wallet agent stopped")
+				return
+			case <-time.After(flags.interval):
+			}
+		}
+	},
+}
+
+// user balance
+var userBalance = &cobra.Command{
+	Use:   "balance --user USER",
+	Short: "Show the balances of USER, as known to its running wallet agent.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Ask the agent.
+		output, err := new(network.AgentClient).New(agentSocket(directory, flags.user)).Execute(&network.AgentRequest{Operation: network.AgentBalance})
+		if err != nil {
+			log.Fatalf("failed to query wallet agent: %v", err)
+		}
+		var balances []store.Balance
+		if err := json.Unmarshal([]byte(output), &balances); err != nil {
+			log.Fatalf("failed to decode balances: %v", err)
+		}
+
+		// Report.
+		fmt.Printf("%-8s %-10s %-10s\n", "Currency", "Local", "Remote")
+		for _, balance := range balances {
+			fmt.Printf("%-8s %-10d %-10d\n", balance.Currency, balance.Local, balance.Remote)
+		}
+	},
+}
+
+// user spent
+var userSpent = &cobra.Command{
+	Use:   "spent --user USER --bank BANKNAME [--purge AGE]",
+	Short: "List the spent-coins archive of USER.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.user) == 0 {
+			return fmt.Errorf("required \"user\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.user)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given user: %s", flags.user)
+			}
+		}
+
+		// Bind to a bank account.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+
+		// Check purge age.
+		if cmd.Flags().Changed("purge") && flags.purge <= 0 {
+			return fmt.Errorf("\"purge\" must be positive")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		store, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		store.BankName = flags.bank
+
+		// Read client.
+		if _, err := store.ReadClient(); err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+
+		// Purge coins spent before the purge age.
+		if flags.purge > 0 {
+			purged, err := store.PurgeSpentCoins(time.Now().Add(-flags.purge))
+			if err != nil {
+				log.Fatalf("failed to purge spent coins: %v", err)
+			}
+			log.Printf("Purged %d spent coins", purged)
+		}
+
+		// Read spent coins.
+		spent, err := store.ReadSpentCoins()
+		if err != nil {
+			log.Fatalf("failed to read spent coins from database: %v", err)
+		}
+
+		// Report.
+		fmt.Printf("\nSPENT COINS\n")
+		fmt.Printf("%-23s %-10s %-8s %-10s %-10s %s\n", "Date", "Operation", "Currency", "Value", "CoinHash", "Memo")
+		for _, coin := range spent {
+			fmt.Printf("%-23.23s %-10s %-8s %-10d %-10d %s\n", coin.Date.Local().String(), coin.Operation,
+				core.NormalizeCurrency(coin.Coin.Params.Currency), core.NormalizeValue(coin.Coin.Params.Value),
+				coin.Coin.Profile().Hash(), coin.Memo)
+		}
+	},
+}
+
+// user certificates
+var userCertificates = &cobra.Command{
+	Use:     "certificates --user USER",
+	Short:   "List the TLS certificates kept by USER: its own, and the banks' and merchants' it connected to.",
+	PreRunE: requireUserDatabase,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+		certs, err := clientStore.Certificates().ReadAll()
+		if err != nil {
+			log.Fatalf("failed to read certificates from database: %v", err)
+		}
+
+		// Report.
+		fmt.Printf("%-9s %-30s %-23s %s\n", "Role", "Name", "Date", "Fingerprint")
+		for _, cert := range certs {
+			fingerprint := cert.Fingerprint
+			if len(cert.Cert) == 0 {
+				fingerprint = "(publicly trusted)"
+			}
+			fmt.Printf("%-9s %-30s %-23.23s %s\n", cert.Role, cert.Name, cert.Date.Local().String(), fingerprint)
+		}
+	},
+}
+
+// user receipts
+var userReceipts = &cobra.Command{
+	Use:     "receipts --user USER --bank BANKNAME",
+	Short:   "List the receipts of the payments of USER.",
+	PreRunE: requireUserBank,
+	Run: func(cmd *cobra.Command, args []string) {
+		receipts, err := openWallet().ReadReceipts()
+		if err != nil {
+			log.Fatalf("failed to read receipts from database: %v", err)
+		}
+
+		// Report.
+		fmt.Printf("%-23s %-20s %-8s %-10s %-10s %s\n", "Date", "Merchant", "Currency", "Amount", "CoinHash", "Memo")
+		for i := range receipts {
+			receipt := &receipts[i]
+			fmt.Printf("%-23.23s %-20s %-8s %-10d %-10d %s\n", receipt.Date.Local().String(), receipt.Merchant,
+				core.NormalizeCurrency(receipt.Coin.Params.Currency), receipt.Amount(), receipt.Hash, receipt.Memo.Text)
+		}
+	},
+}
+
+// user receipts verify
+var receiptsVerify = &cobra.Command{
+	Use:     "verify --user USER --bank BANKNAME",
+	Short:   "Verify the receipts of USER against the bank's mints and the merchants' certificates, flagging tampered ones.",
+	PreRunE: requireUserBank,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read receipts.
+		clientStore := openWallet()
+		client, err := clientStore.ReadClient()
+		if err != nil {
+			log.Fatalf("failed to read Client from database: %v", err)
+		}
+		receipts, err := clientStore.ReadReceipts()
+		if err != nil {
+			log.Fatalf("failed to read receipts from database: %v", err)
+		}
+
+		// Verify each receipt.
+		tampered := 0
+		for i := range receipts {
+			receipt := &receipts[i]
+			mint, err := clientStore.ReadMint(client, receipt.Coin.Params.Currency)
+			var cert *store.Certificate
+			if err == nil {
+				cert, err = clientStore.Certificates().Read(store.Role_Merchant, receipt.Merchant)
+			}
+			if err == nil {
+				err = network.VerifyReceipt(receipt, mint, cert)
+			}
+			if err != nil {
+				tampered++
+				fmt.Printf("TAMPERED  %-10d %-20s %s: %v\n", receipt.Hash, receipt.Merchant, receipt.Date.Local().Format(time.DateTime), err)
+				continue
+			}
+			fmt.Printf("OK        %-10d %-20s %s\n", receipt.Hash, receipt.Merchant, receipt.Date.Local().Format(time.DateTime))
+		}
+
+		if tampered > 0 {
+			log.Fatalf("%d out of %d receipts failed verification", tampered, len(receipts))
+		}
+		log.Printf("%d receipts verified", len(receipts))
+	},
+}
+
+// statsFormats are the output formats of the stats and operations commands.
+var statsFormats = []string{"table", "json"}
+
+// user stats
+var userStats = &cobra.Command{
+	Use:   "stats --user USER --bank BANKNAME [--format table|json]",
+	Short: "Show the spending statistics of USER: per merchant, per month, and the average lifetime of its coins.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkFormat(flags.format, statsFormats); err != nil {
+			return err
+		}
+		return requireUserBank(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		stats, err := openWallet().Stats()
+		if err != nil {
+			log.Fatalf("failed to compute statistics: %v", err)
+		}
+
+		// Report.
+		if flags.format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(stats); err != nil {
+				log.Fatalf("failed to print statistics: %v", err)
+			}
+			return
+		}
+		fmt.Printf("\nPER MERCHANT\n")
+		fmt.Printf("%-30s %-8s %-10s %-10s\n", "Merchant", "Currency", "Payments", "Amount")
+		for _, entry := range stats.Merchants {
+			fmt.Printf("%-30s %-8s %-10d %-10d\n", entry.Merchant, entry.Currency, entry.Payments, entry.Amount)
+		}
+		fmt.Printf("\nPER MONTH\n")
+		fmt.Printf("%-8s %-8s %-10s %-10s\n", "Month", "Currency", "Payments", "Amount")
+		for _, entry := range stats.Months {
+			fmt.Printf("%-8s %-8s %-10d %-10d\n", entry.Month, entry.Currency, entry.Payments, entry.Amount)
+		}
+		fmt.Printf("\nSPENT COINS\n")
+		fmt.Printf("%-8s %-10s %-10s %s\n", "Currency", "Coins", "Amount", "Lifetime")
+		for _, entry := range stats.Currencies {
+			fmt.Printf("%-8s %-10d %-10d %s\n", entry.Currency, entry.Coins, entry.Amount, entry.AverageLifetime.Round(time.Minute))
+		}
+	},
+}
+
+// Coin verification problems. (See verifyCoins)
+const (
+	coinInvalid     = "invalid"
+	coinExpired     = "expired"
+	coinUnknownBank = "unknown bank"
+	coinRevoked     = "revoked"
+)
+
+// verifyCoins returns the problem of each of coins, held at the account of client in clientStore, "" if none. Coins are
+// verified against the mint of their currency, and the bank's revocation list if fetched.
+func verifyCoins(clientStore *store.ClientStore, client *core.Client, coins []core.Coin) ([]string, error) {
+	problems := make([]string, len(coins))
+
+	// Verify the coins of each currency at once, against its mint.
+	currencies := make(map[string][]int)
+	for i := range coins {
+		currency := core.NormalizeCurrency(coins[i].Params.Currency)
+		currencies[currency] = append(currencies[currency], i)
+	}
+	for currency, indices := range currencies {
+		mint, err := clientStore.ReadMint(client, currency)
+		if err == store.ErrUnknownCurrency {
+			for _, i := range indices {
+				problems[i] = coinUnknownBank
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		profiles := make([]*core.CoinProfile, len(indices))
+		for j, i := range indices {
+			profiles[j] = coins[i].Profile()
+		}
+		for j, valid := range core.VerifyCoinBatch(mint, profiles) {
+			if !valid {
+				problems[indices[j]] = coinInvalid
+			}
+		}
+	}
+
+	// Revoked and expired coins.
+	revocations, err := clientStore.ReadRevocations()
+	if err != nil {
+		return nil, err
+	}
+	now := core.Now()
+	for i := range coins {
+		switch {
+		case problems[i] != "":
+		case revocations != nil && revocations.Revoked(coins[i].Profile()):
+			problems[i] = coinRevoked
+		case core.CheckUnexpired(coins[i].Params.Expiration, now) != nil:
+			problems[i] = coinExpired
+		}
+	}
+	return problems, nil
+}
+
+// user verify
+var userVerify = &cobra.Command{
+	Use:     "verify --user USER [--bank BANKNAME] [--server SERVER] [--quarantine] [--fix]",
+	Short:   "Verify every coin and the balances of USER, at BANKNAME or at every bank.",
+	PreRunE: requireUserDatabase,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.user)
+		clientStore, err := new(store.ClientStore).New(dbPath)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Fetch the revocation list of the bank at SERVER.
+		if len(flags.address) > 0 {
+			setupClient := new(network.SetupClient).New(flags.address, clientStore)
+			if err := setupClient.Execute(); err != nil {
+				log.Fatal(err)
+			}
+			config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, flags.address)
+			if err != nil {
+				log.Fatalf("failed to load certificate (client): %v", err)
+			}
+			if err := new(network.RevocationClient).New(flags.address, clientStore, config).Execute(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// Banks.
+		banks := []string{flags.bank}
+		if len(flags.bank) == 0 {
+			if banks, err = clientStore.ReadBanks(); err != nil {
+				log.Fatalf("failed to read banks from database: %v", err)
+			}
+		}
+
+		for _, bank := range banks {
+			// Read client and coins.
+			clientStore.BankName = bank
+			client, err := clientStore.ReadClient()
+			if err != nil {
+				log.Fatalf("failed to read Client from database: %v", err)
+			} else if client == nil {
+				log.Printf("No account at bank %s", bank)
+				continue
+			}
+			coins, err := clientStore.ReadCoins()
+			if err != nil {
+				log.Fatalf("failed to read coins from database: %v", err)
+			}
+
+			// Verify coins.
+			problems, err := verifyCoins(clientStore, client, coins)
+			if err != nil {
+				log.Fatalf("failed to verify coins: %v", err)
+			}
+
+			// Report, quarantining the bad coins. (Expired coins may still be exchanged)
+			counts := make(map[string]int)
+			for i, problem := range problems {
+				if problem == "" {
+					continue
+				}
+				counts[problem]++
+				profile := coins[i].Profile()
+				log.Printf("%s coin %d: %d %s, expiring %s", problem, profile.Hash(), profile.Value, profile.Currency, profile.Expiration.Format(time.DateTime))
+				if flags.quarantine && problem != coinExpired {
+					if err := clientStore.QuarantineCoin(&coins[i], problem); err != nil {
+						log.Fatalf("failed to quarantine coin: %v", err)
+					}
+					log.Printf("Quarantined coin %d", profile.Hash())
+				}
+			}
+			log.Printf("Verified %d coins at %s: %d invalid, %d expired, %d of an unknown bank, %d revoked", len(coins), bank,
+				counts[coinInvalid], counts[coinExpired], counts[coinUnknownBank], counts[coinRevoked])
+
+			// Reconcile balances.
+			drifts, err := clientStore.ReconcileBalances(flags.fix)
+			if err != nil {
+				log.Fatalf("failed to reconcile balances: %v", err)
+			}
+			for _, drift := range drifts {
+				log.Printf("%s balance drifted: recorded %d, coins hold %d", drift.Currency, drift.Recorded, drift.Actual)
+			}
+			if len(drifts) > 0 && flags.fix {
+				log.Printf("Fixed %d balances", len(drifts))
+			} else if len(drifts) > 0 {
+				log.Print("Run with --fix to correct them")
+			}
+		}
+	},
+}
+
+// bank
+var bank = &cobra.Command{
+	Use:   "bank operation",
+	Short: "Perform bank operations.",
+}
+
+// bank init
+var bankInit = &cobra.Command{
+	Use:   "init [--params FILE]",
+	Short: "Initialize ziba system in current computer (as a bank).",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+			// return fmt.Errorf("required \"identity\" flag not set")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Load scheme parameters. (Stored along with the bank's identity)
+		scheme, provenance := core.Params, "built-in"
+		if len(flags.params) > 0 {
+			scheme, err = core.LoadSchemeParams(flags.params)
+			if err != nil {
+				log.Fatalf("failed to load scheme parameters: %v", err)
+			}
+			if provenance, err = filepath.Abs(flags.params); err != nil {
+				provenance = flags.params
+			}
+			log.Printf("Using scheme parameters from %s", flags.params)
+		}
+
+		// Create Bank.
+		bank := new(core.Bank).New(nil, scheme)
+
+		// Create local database.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to open database: %v", err)
+		}
+
+		// Write Bank into database.
 		store.WriteBank(bank, flags.bank)
+		if err := store.WriteProvenance(provenance); err != nil {
+			log.Fatalf("failed to write scheme parameters provenance: %v", err)
+		}
+		log.Printf("Scheme parameters: %s", scheme.Fingerprint())
+
+		// Create certificates.
+		network.CreateCertificate(directory, flags.bank, flags.hosts...)
+	},
+}
+
+// bank certificate
+var bankCertificate = &cobra.Command{
+	Use:   "certificate --bank BANK [--host HOST ...]",
+	Short: "Create a new certificate for the bank, valid for localhost and the given hosts.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create certificates. (Picked up by a running server, clients must run Setup again)
+		if err := network.CreateCertificate(directory, flags.bank, flags.hosts...); err != nil {
+			log.Fatalf("failed to create certificate: %v", err)
+		}
+		log.Printf("Certificate created for localhost %s", strings.Join(flags.hosts, " "))
+	},
+}
+
+// bank mint
+var bankMint = &cobra.Command{
+	Use:   "mint --bank BANK --currency CODE",
+	Short: "Create a mint key issuing coins in another currency.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		// Check currency code.
+		if err := core.ValidateCurrency(flags.currency); err != nil {
+			return fmt.Errorf("invalid \"currency\" flag: %q", flags.currency)
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Read Bank.
+		bank, err := store.ReadBank()
+		if err != nil {
+			log.Fatalf("failed to read Bank from database: %v", err)
+		}
+
+		// Create mint.
+		mint, err := bank.NewMint(nil, flags.currency)
+		if err != nil {
+			log.Fatalf("failed to create mint: %v", err)
+		}
+
+		// Write mint into database.
+		if err := store.WriteMint(mint); err != nil {
+			log.Fatalf("failed to write mint into database: %v", err)
+		}
+
+		log.Printf("Mint %s created", mint.Currency)
+	},
+}
+
+// bank rate
+var bankRate = &cobra.Command{
+	Use:   "rate --bank BANK --from CODE --to CODE --rate NUM/DEN",
+	Short: "Set the exchange rate between two currencies of the bank.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		// Check currency codes.
+		if err := core.ValidateCurrency(flags.currency); err != nil {
+			return fmt.Errorf("invalid \"from\" flag: %q", flags.currency)
+		}
+		if err := core.ValidateCurrency(flags.to); err != nil {
+			return fmt.Errorf("invalid \"to\" flag: %q", flags.to)
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Parse rate.
+		num, den, found := strings.Cut(flags.rate, "/")
+		if !found {
+			den = "1"
+		}
+		n, errNum := strconv.ParseInt(num, 10, 64)
+		d, errDen := strconv.ParseInt(den, 10, 64)
+		if errNum != nil || errDen != nil {
+			log.Fatalf("invalid \"rate\" flag: %q", flags.rate)
+		}
+		rate, err := core.NewRate(flags.currency, flags.to, n, d)
+		if err != nil {
+			log.Fatalf("invalid exchange rate: %v", err)
+		}
+
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Check that the bank issues both currencies.
+		for _, currency := range []string{rate.From, rate.To} {
+			if _, err := store.ReadMint(currency); err != nil {
+				log.Fatalf("failed to read mint for %s: %v", currency, err)
+			}
+		}
+
+		// Write rate into database.
+		if err := store.WriteRate(rate); err != nil {
+			log.Fatalf("failed to write exchange rate into database: %v", err)
+		}
+
+		log.Printf("1 %s = %d/%d %s", rate.From, rate.Num, rate.Den, rate.To)
+	},
+}
+
+// bank approve
+var bankApprove = &cobra.Command{
+	Use:   "approve --bank BANK [--client HASH [--reject]]",
+	Short: "Approve or reject a pending account application. (Lists them if no client is given)",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		bankStore, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// List pending applications.
+		if flags.client == 0 {
+			applications, err := bankStore.ReadApplications(store.Admission_Pending)
+			if err != nil {
+				log.Fatalf("failed to read applications from database: %v", err)
+			}
+			fmt.Printf("%-10s %-23s %s\n", "ClientHash", "Date", "Evidence")
+			for _, application := range applications {
+				fmt.Printf("%-10d %-23s %s\n", application.Hash, application.Date.Format(time.DateTime), application.Evidence)
+			}
+			return
+		}
+
+		// Decide application.
+		admission := store.Admission_Approved
+		if flags.reject {
+			admission = store.Admission_Rejected
+		}
+		if err := bankStore.UpdateAdmission(flags.client, admission); err != nil {
+			log.Fatalf("failed to update application: %v", err)
+		}
+		log.Printf("Client %d: %s", flags.client, admission)
+	},
+}
+
+// bank pending
+var bankPending = &cobra.Command{
+	Use:   "pending operation",
+	Short: "Approve or reject the operations held for review by the fraud rules.",
+}
+
+// decideAlert sets the status of the pending alert of the --id flag.
+func decideAlert(status store.Alert_Type) {
+	// Get ziba directory.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		log.Fatalf("failed to retrieve Ziba directory: %v", err)
+	}
+
+	// Create store.
+	dbPath := store.DatabasePath(directory, flags.bank)
+	bankStore, err := new(store.BankStore).New(dbPath, flags.identity)
+	if err != nil {
+		log.Fatalf("failed to create store: %v", err)
+	}
+
+	// Decide alert.
+	if err := bankStore.UpdateFraudAlert(flags.alert, status); err != nil {
+		log.Fatalf("failed to update fraud alert: %v", err)
+	}
+	log.Printf("Alert %d: %s", flags.alert, status)
+}
+
+// requireAlert checks that the --id flag is set, and that the bank's database exists.
+func requireAlert(cmd *cobra.Command, args []string) error {
+	if flags.alert <= 0 {
+		return fmt.Errorf("required \"id\" flag not set")
+	}
+	return requireBankDatabase(cmd, args)
+}
+
+// bank pending list
+var pendingList = &cobra.Command{
+	Use:     "list --bank BANK",
+	Short:   "List the operations pending review, oldest first.",
+	PreRunE: requireBankDatabase,
+	Run: func(cmd *cobra.Command, args []string) {
+		alerts, err := openBankReadOnly().ReadFraudAlerts(store.Alert_Pending)
+		if err != nil {
+			log.Fatalf("failed to read fraud alerts from database: %v", err)
+		}
+
+		fmt.Printf("%-6s %-10s %-10s %-8s %-10s %-19s %s\n", "ID", "Operation", "Client", "Currency", "Value", "Date", "Rule")
+		for _, alert := range alerts {
+			fmt.Printf("%-6d %-10s %-10d %-8s %-10d %-19s %s\n", alert.ID, alert.Operation, alert.Client, alert.Currency,
+				alert.Value, alert.Date.Local().Format(time.DateTime), alert.Rule)
+		}
+	},
+}
+
+// bank pending approve
+var pendingApprove = &cobra.Command{
+	Use:     "approve --bank BANK --id N",
+	Short:   "Approve pending operation N, served the next time its client tries.",
+	PreRunE: requireAlert,
+	Run: func(cmd *cobra.Command, args []string) {
+		decideAlert(store.Alert_Approved)
+	},
+}
+
+// bank pending reject
+var pendingReject = &cobra.Command{
+	Use:     "reject --bank BANK --id N",
+	Short:   "Reject pending operation N, refused from now on.",
+	PreRunE: requireAlert,
+	Run: func(cmd *cobra.Command, args []string) {
+		decideAlert(store.Alert_Denied)
+	},
+}
+
+// bank token
+var bankToken = &cobra.Command{
+	Use:   "token --bank BANK [--count N] [--note NOTE]",
+	Short: "Issue one-time registration tokens, required by \"bank serve --require-token\".",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if flags.count < 1 {
+			return fmt.Errorf("invalid \"count\" flag: %d", flags.count)
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Issue tokens.
+		for i := 0; i < flags.count; i++ {
+			token, err := store.NewRegistrationToken(flags.note)
+			if err != nil {
+				log.Fatalf("failed to write registration token into database: %v", err)
+			}
+			fmt.Println(token)
+		}
+	},
+}
+
+// bank revoke
+var bankRevoke = &cobra.Command{
+	Use:   "revoke --bank BANK (--coin HASH | --account HASH) [--reason REASON]",
+	Short: "Revoke a coin or freeze an account, published on the revocation feed.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if (flags.coin == 0) == (flags.account == 0) {
+			return fmt.Errorf("exactly one of \"coin\" and \"account\" flags must be set")
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Write revocation into database.
+		if flags.coin != 0 {
+			if err := store.RevokeCoin(flags.coin, flags.reason); err != nil {
+				log.Fatalf("failed to revoke coin: %v", err)
+			}
+			log.Printf("Revoked coin %d", flags.coin)
+			return
+		}
+		if err := store.FreezeAccount(flags.account, flags.reason); err != nil {
+			log.Fatalf("failed to freeze account: %v", err)
+		}
+		log.Printf("Froze account %d", flags.account)
+	},
+}
+
+// wgBank.
+var wgBank sync.WaitGroup
+
+// bank serve
+var serve = &cobra.Command{
+	Use:   "serve",
+	Short: "Start servers.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+			// return fmt.Errorf("required \"identity\" flag not set")
+		}
+
+		// Check database options.
+		if _, err := store.ParsePragmas(flags.dbPragmas); err != nil {
+			return err
+		}
+
+		// Check the operation log's retention.
+		if flags.operationLog < 0 {
+			return fmt.Errorf("\"operation-log\" must not be negative")
+		}
+
+		// Check the coin validity policy.
+		if _, err := coinValidity(); err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		bankStore, err := new(store.BankStore).Options(serverOptions()).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Legacy coins transition window.
+		core.AcceptLegacyCoins = !flags.rejectLegacy
+
+		// Validity periods of the issued coins.
+		core.Validity, _ = coinValidity() // Checked by PreRunE.
+
+		// Custody mode. (Account generation and withdrawals use separate identities)
+		accgenStore, withdrawalStore := bankStore, bankStore
+		if flags.custody {
+			accgenStore = openCustody(dbPath, custodyAccgen, flags.accgenPassphrase)
+			withdrawalStore = openCustody(dbPath, custodyWithdrawal, flags.withdrawalPassphrase)
+			bankStore = withdrawalStore
+		}
+
+		log.Printf("Bank's Name is: %s", bankStore.Name)
+
+		// Drop the cached Bank and ClientInfo entries on SIGHUP. (Changes of other replicas are read at once anyway)
+		invalidate := make(chan os.Signal, 1)
+		signal.Notify(invalidate, syscall.SIGHUP)
+		go func() {
+			for range invalidate {
+				for _, cached := range []*store.BankStore{bankStore, accgenStore, withdrawalStore} {
+					cached.InvalidateCache()
+				}
+			}
+		}()
+
+		// Record the served protocol runs.
+		if flags.operationLog > 0 {
+			network.EnableOperationLog(bankStore, flags.operationLog)
+		}
+
+		// Bound the coin responses computed at once.
+		if flags.cryptoWorkers < 1 || flags.cryptoQueue < 0 {
+			log.Fatalf("invalid crypto pool: %d workers, queue of %d", flags.cryptoWorkers, flags.cryptoQueue)
+		}
+		network.ConfigureCryptoPool(flags.cryptoWorkers, flags.cryptoQueue)
+
+		// Precompute exponentiation tables.
+		if bank, err := bankStore.ReadBank(); err == nil {
+			bank.Profile().Precompute()
+		}
+
+		// Load TLS server configuration.
+		keyPath := store.KeyPath(directory, flags.bank)
+		certPath := store.CertPath(directory, flags.bank)
+		var config *tls.Config
+		acme := len(flags.acmeDomains) > 0
+		if acme {
+			// ACME mode. (Publicly trusted certificates for the bank's domains)
+			cacheDir := store.ACMEPath(directory, flags.bank)
+			acmeConfig, manager := network.GetACMEServerTLSConfig(flags.acmeDomains, flags.acmeEmail, cacheDir)
+			config = acmeConfig
+
+			// Start ACME challenge server.
+			wgBank.Add(1)
+			go func() {
+				defer wgBank.Done()
+				if err := network.ServeACMEChallenges(flags.acmeListen, manager); err != nil {
+					log.Fatalf("failed to start ACME challenge server: %v", err)
+				}
+			}()
+		} else {
+			// Reloaded when renewed, without restarting the servers.
+			certManager, err := new(network.CertManager).New(certPath, keyPath)
+			if err != nil {
+				log.Fatalf("failed to load certificate and key (server): %v", err)
+			}
+			config = certManager.TLSConfig()
+			go certManager.Watch(flags.certCheck, flags.certWarning)
+
+			// Reload on SIGHUP. (Renewal hook)
+			hangup := make(chan os.Signal, 1)
+			signal.Notify(hangup, syscall.SIGHUP)
+			go func() {
+				for range hangup {
+					if err := certManager.Reload(); err != nil {
+						log.Printf("failed to reload certificate: %v", err)
+						continue
+					}
+					log.Printf("Certificate reloaded, expires %s", certManager.Expiry().Format(time.DateOnly))
+				}
+			}()
+		}
+
+		// Threshold mode. (Coin responses are computed by the bank nodes)
+		var threshold *network.ThresholdClient
+		if len(flags.nodes) > 0 {
+			nodeCertPaths := make([]string, len(flags.nodes))
+			for i, node := range flags.nodes {
+				nodeCertPaths[i] = store.CertPath(directory, node)
+			}
+			nodeConfig, err := network.GetMutualClientTLSConfig(certPath, keyPath, nodeCertPaths...)
+			if err != nil {
+				log.Fatalf("failed to load certificates (threshold): %v", err)
+			}
+			threshold = new(network.ThresholdClient).New(flags.nodes, nodeConfig)
+		}
+
+		// Start SetupServer.
+		setupServer := new(network.SetupServer).New(bankStore).ACME(acme)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := setupServer.Start(); err != nil {
+				log.Fatalf("failed to start SetupServer: %v", err)
+			}
+		}()
+
+		// Admission policy of account applications.
+		var admission network.AdmissionPolicy
+		switch {
+		case flags.admission == "auto":
+			admission = network.AutoApproval{}
+		case flags.admission == "manual":
+			admission = new(network.ManualApproval).New(accgenStore)
+		case strings.HasPrefix(flags.admission, "http://") || strings.HasPrefix(flags.admission, "https://"):
+			admission = new(network.HTTPApproval).New(flags.admission)
+		default:
+			log.Fatalf("invalid \"admission\" flag: %q", flags.admission)
+		}
+
+		// Start AccgenServer.
+		accgenPolicy := network.AccgenPolicy{
+			RequireToken: flags.requireToken,
+			Work:         flags.work,
+			Quota:        flags.quota,
+			QuotaWindow:  flags.quotaWindow,
+		}
+		accgenServer := new(network.AccgenServer).New(accgenStore, config).Policy(accgenPolicy).Admission(admission)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := accgenServer.Start(); err != nil {
+				log.Fatalf("failed to start AccgenServer: %v", err)
+			}
+		}()
+
+		// Fraud rules and operation limits of the bank policy file.
+		policy, err := readBankPolicy()
+		if err != nil {
+			log.Fatalf("failed to load bank policy: %v", err)
+		}
+		fraud, err := loadFraudRules(bankStore, policy)
+		if err != nil {
+			log.Fatalf("failed to load bank policy: %v", err)
+		}
+		limits, err := loadOperationLimits(bankStore, policy)
+		if err != nil {
+			log.Fatalf("failed to load bank policy: %v", err)
+		}
+
+		// Start WithdrawalServer.
+		withdrawalServer := new(network.WithdrawalServer).New(withdrawalStore, config).Threshold(threshold).Fraud(fraud).
+			Limits(limits)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := withdrawalServer.Start(); err != nil {
+				log.Fatalf("failed to start WithdrawalServer: %v", err)
+			}
+		}()
+
+		// Start DepositServer.
+		depositServer := new(network.DepositServer).New(bankStore, config).RequireBinding(flags.requireBinding).Fraud(fraud).
+			Limits(limits)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := depositServer.Start(); err != nil {
+				log.Fatalf("failed to start DepositServer: %v", err)
+			}
+		}()
+
+		// Start ExchangeServer.
+		exchangeServer := new(network.ExchangeServer).New(withdrawalStore, config).Threshold(threshold)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := exchangeServer.Start(); err != nil {
+				log.Fatalf("failed to start ExchangeServer: %v", err)
+			}
+		}()
+
+		// Start ReclaimServer.
+		reclaimServer := new(network.ReclaimServer).New(withdrawalStore, config).Threshold(threshold)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := reclaimServer.Start(); err != nil {
+				log.Fatalf("failed to start ReclaimServer: %v", err)
+			}
+		}()
+
+		// Start ChangeServer.
+		changeServer := new(network.ChangeServer).New(bankStore, config).Threshold(threshold)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := changeServer.Start(); err != nil {
+				log.Fatalf("failed to start ChangeServer: %v", err)
+			}
+		}()
+
+		// Start RevocationServer.
+		revocationServer := new(network.RevocationServer).New(bankStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := revocationServer.Start(); err != nil {
+				log.Fatalf("failed to start RevocationServer: %v", err)
+			}
+		}()
+
+		// Start ReviewServer.
+		reviewServer := new(network.ReviewServer).New(bankStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := reviewServer.Start(); err != nil {
+				log.Fatalf("failed to start ReviewServer: %v", err)
+			}
+		}()
+
+		// Start RelayServer. (Merchants behind NAT)
+		if flags.relay {
+			relayServer := new(network.RelayServer).New(config)
+			wgBank.Add(1)
+			go func() {
+				defer wgBank.Done()
+				if err := relayServer.Start(); err != nil {
+					log.Fatalf("failed to start RelayServer: %v", err)
+				}
+			}()
+		}
+
+		// Start StatusServer.
+		statusServer := new(network.StatusServer).New(bankStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := statusServer.Start(); err != nil {
+				log.Fatalf("failed to start StatusServer: %v", err)
+			}
+		}()
+
+		// Start ClosureServer.
+		closureServer := new(network.ClosureServer).New(bankStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := closureServer.Start(); err != nil {
+				log.Fatalf("failed to start ClosureServer: %v", err)
+			}
+		}()
+
+		// Start TransferServer.
+		transferServer := new(network.TransferServer).New(bankStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := transferServer.Start(); err != nil {
+				log.Fatalf("failed to start TransferServer: %v", err)
+			}
+		}()
+
+		// Start AttestationServer.
+		attestationServer := new(network.AttestationServer).New(bankStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := attestationServer.Start(); err != nil {
+				log.Fatalf("failed to start AttestationServer: %v", err)
+			}
+		}()
+
+		// Start AdminServer. (Only the admin's certificate is accepted)
+		if len(flags.adminCert) > 0 {
+			adminConfig, err := network.GetMutualServerTLSConfig(certPath, keyPath, flags.adminCert)
+			if err != nil {
+				log.Fatalf("failed to load certificates (admin): %v", err)
+			}
+			adminServer := new(network.AdminServer).New(bankStore, adminConfig)
+			wgBank.Add(1)
+			go func() {
+				defer wgBank.Done()
+				if err := adminServer.Start(); err != nil {
+					log.Fatalf("failed to start AdminServer: %v", err)
+				}
+			}()
+		}
+
+		// Start RenewalServer.
+		renewalServer := new(network.RenewalServer).New(accgenStore, config)
+		wgBank.Add(1)
+		go func() {
+			defer wgBank.Done()
+			if err := renewalServer.Start(); err != nil {
+				log.Fatalf("failed to start RenewalServer: %v", err)
+			}
+		}()
+
+		// Don't exit main thread.
+		wgBank.Wait()
+	},
+}
+
+// Custody roles.
+const (
+	custodyAccgen     = "accgen"
+	custodyWithdrawal = "withdrawal"
+)
+
+// openCustody opens and unlocks the identity holding role's keys.
+func openCustody(dbPath, role, passphrase string) *store.BankStore {
+	identity := fmt.Sprintf("%s-%s", flags.identity, role)
+	custody, err := new(store.BankStore).Options(serverOptions()).New(dbPath, identity)
+	if err != nil {
+		log.Fatalf("failed to create store: %v", err)
+	}
+	if _, err := custody.ReadBank(); err != nil && err != store.ErrLockedBank {
+		log.Fatalf("failed to read %s identity, run \"bank custody\" first: %v", identity, err)
+	}
+	if err := custody.Unlock(readPassphrase(passphrase, fmt.Sprintf("Passphrase (%s)", role))); err != nil {
+		log.Fatalf("failed to unlock %s identity: %v", identity, err)
+	}
+	return custody
+}
+
+// serverOptions returns the database options of the bank servers, store.ServerOptions along with the database flags.
+func serverOptions() store.DatabaseOptions {
+	options := store.ServerOptions
+	options.MaxOpenConns = flags.dbMaxConns
+	options.MaxIdleConns = flags.dbIdleConns
+	options.BusyTimeout = flags.dbBusyTimeout
+	options.Pragmas, _ = store.ParsePragmas(flags.dbPragmas) // Checked by PreRunE.
+	return options
+}
+
+// bankPolicy is the bank policy file, e.g.
+//
+//	{"fraud": [
+//		{"rule": "velocity", "count": 10, "window": "1h", "action": "reject"},
+//		{"rule": "amount", "max": 10000, "currency": "EUR", "operations": ["withdrawal"], "action": "approval"},
+//		{"rule": "new-account", "age": "3d", "max": 500, "action": "flag"}
+//	],
+//	"limits": {"withdrawals": {"count": 50, "window": "1d"}, "deposits": {"count": 20, "window": "1h"}}}
+type bankPolicy struct {
+	Fraud  []fraudRuleConfig `json:"fraud"`
+	Limits struct {
+		Withdrawals *limitConfig `json:"withdrawals"` // Coins withdrawn.
+		Deposits    *limitConfig `json:"deposits"`    // Coins deposited.
+	} `json:"limits"`
+}
+
+// limitConfig is an operation limit of the bank policy file, per client.
+type limitConfig struct {
+	Count  int      `json:"count"`  // Operations allowed within the window.
+	Window ageValue `json:"window"` // Rolling window.
+}
+
+// fraudRuleConfig is a fraud rule of the bank policy file.
+type fraudRuleConfig struct {
+	Rule       string   `json:"rule"`       // velocity, amount or new-account
+	Count      int      `json:"count"`      // velocity: operations allowed within the window
+	Window     ageValue `json:"window"`     // velocity
+	Age        ageValue `json:"age"`        // new-account: accounts opened within this age are new
+	Max        int64    `json:"max"`        // amount, new-account: largest value allowed
+	Currency   string   `json:"currency"`   // amount: every currency if empty
+	Operations []string `json:"operations"` // withdrawal and/or deposit, both if empty
+	Action     string   `json:"action"`     // reject, flag or approval
+}
+
+// readBankPolicy returns the bank policy file (--policy), nil if there's none.
+func readBankPolicy() (*bankPolicy, error) {
+	path := flags.policy
+	if len(path) == 0 {
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			return nil, err
+		}
+		path = store.ConfigPath(directory, flags.bank+"_policy.json")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy bankPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &policy, nil
+}
+
+// loadFraudRules returns the fraud rules of policy, nil if there's none.
+func loadFraudRules(bankStore *store.BankStore, policy *bankPolicy) (*network.FraudRules, error) {
+	if policy == nil || len(policy.Fraud) == 0 {
+		return nil, nil
+	}
+
+	rules := new(network.FraudRules).New(bankStore)
+	for i, config := range policy.Fraud {
+		var rule network.FraudRule
+		switch config.Rule {
+		case "velocity":
+			if config.Count <= 0 || config.Window <= 0 {
+				return nil, fmt.Errorf("fraud rule %d: velocity requires a count and a window", i+1)
+			}
+			rule = new(network.VelocityRule).New(config.Count, time.Duration(config.Window))
+		case "amount":
+			rule = new(network.AmountRule).New(config.Currency, config.Max)
+		case "new-account":
+			if config.Age <= 0 {
+				return nil, fmt.Errorf("fraud rule %d: new-account requires an age", i+1)
+			}
+			rule = new(network.NewAccountRule).New(bankStore, time.Duration(config.Age), config.Max)
+		default:
+			return nil, fmt.Errorf("fraud rule %d: unknown rule %q", i+1, config.Rule)
+		}
+
+		var action network.FraudAction
+		switch config.Action {
+		case "reject":
+			action = network.FraudReject
+		case "flag":
+			action = network.FraudFlag
+		case "approval":
+			action = network.FraudApproval
+		default:
+			return nil, fmt.Errorf("fraud rule %d: unknown action %q", i+1, config.Action)
+		}
+
+		var operations []store.Operation_Type
+		for _, operation := range config.Operations {
+			switch operation {
+			case "withdrawal":
+				operations = append(operations, store.Operation_Withdrawal)
+			case "deposit":
+				operations = append(operations, store.Operation_Deposit)
+			default:
+				return nil, fmt.Errorf("fraud rule %d: unknown operation %q", i+1, operation)
+			}
+		}
+
+		rules.Add(rule, action, operations...)
+		log.Printf("Fraud rule %s: %s", rule.Name(), config.Action)
+	}
+	return rules, nil
+}
+
+// loadOperationLimits returns the operation limits of policy, nil if there's none.
+func loadOperationLimits(bankStore *store.BankStore, policy *bankPolicy) (*network.OperationLimits, error) {
+	if policy == nil || (policy.Limits.Withdrawals == nil && policy.Limits.Deposits == nil) {
+		return nil, nil
+	}
+
+	limits := new(network.OperationLimits).New(bankStore)
+	for _, entry := range []struct {
+		name      string
+		operation store.Operation_Type
+		config    *limitConfig
+	}{
+		{"withdrawals", store.Operation_Withdrawal, policy.Limits.Withdrawals},
+		{"deposits", store.Operation_Deposit, policy.Limits.Deposits},
+	} {
+		if entry.config == nil {
+			continue
+		}
+		if entry.config.Count <= 0 || entry.config.Window <= 0 {
+			return nil, fmt.Errorf("%s limit requires a count and a window", entry.name)
+		}
+		limits.Limit(entry.operation, entry.config.Count, time.Duration(entry.config.Window))
+		log.Printf("Limit of %s: %d per %s and client", entry.name, entry.config.Count, time.Duration(entry.config.Window))
+	}
+
+	// Forget the operations beyond the windows.
+	if n, err := limits.Prune(); err != nil {
+		return nil, err
+	} else if n > 0 {
+		log.Printf("Deleted %d expired operation counts", n)
+	}
+	return limits, nil
+}
+
+// coinValidity returns the coin validity policy of the serve command's flags.
+func coinValidity() (core.CoinValidity, error) {
+	periods, err := core.ParseValidityPeriods(flags.coinValidities)
+	if err != nil {
+		return core.CoinValidity{}, err
+	}
+	validity := core.CoinValidity{Default: flags.coinValidity, Periods: periods, Grace: flags.exchangeGrace}
+	return validity, validity.Validate()
+}
+
+// bank threshold
+var bankThreshold = &cobra.Command{
+	Use:   "threshold operation",
+	Short: "Share the bank's signing keys between bank nodes.",
+}
+
+// bank threshold split
+var thresholdSplit = &cobra.Command{
+	Use:   "split --bank BANK --threshold T --shares N",
+	Short: "Split the bank's signing keys into N node shares, any T nodes answer coin requests together.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		bankStore, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Read Bank.
+		bank, err := bankStore.ReadBank()
+		if err != nil {
+			log.Fatalf("failed to read Bank from database: %v", err)
+		}
+
+		// Split.
+		shares, err := bank.SplitThreshold(nil, flags.threshold, flags.shares)
+		if err != nil {
+			log.Fatalf("failed to split Bank: %v", err)
+		}
+
+		// Write one file per node.
+		for _, share := range shares {
+			sharePath := store.ConfigPath(directory, fmt.Sprintf("%s_threshold_%d.json", flags.bank, share.Index))
+			if err := core.SaveToFile(&share, sharePath); err != nil {
+				log.Fatalf("failed to write share: %v", err)
+			}
+			if err := os.Chmod(sharePath, 0600); err != nil { // rw- --- ---
+				log.Fatalf("failed to write share: %v", err)
+			}
+			log.Printf("Node share %d/%d written to %s", share.Index, len(shares), sharePath)
+		}
+	},
+}
+
+// bank threshold node
+var thresholdNode = &cobra.Command{
+	Use:   "node --bank NODE --share FILE --server COORDINATOR",
+	Short: "Start a bank node answering the coordinator's coin requests using a node share.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+		if len(flags.share) != 1 {
+			return fmt.Errorf("required \"share\" flag not set")
+		}
+		if len(flags.address) == 0 {
+			return fmt.Errorf("required \"server\" flag not set")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Load share.
+		file, err := os.Open(flags.share[0])
+		if err != nil {
+			log.Fatalf("failed to open share: %v", err)
+		}
+		var share core.ThresholdShare
+		if err := core.LoadFromFile(&share, file); err != nil {
+			log.Fatalf("failed to load share: %v", err)
+		}
+
+		// Create node certificates.
+		keyPath := store.KeyPath(directory, flags.bank)
+		certPath := store.CertPath(directory, flags.bank)
+		if _, err := os.Stat(certPath); os.IsNotExist(err) {
+			network.CreateCertificate(directory, flags.bank, flags.hosts...)
+		}
+
+		// Load TLS server configuration. (Only the coordinator is accepted)
+		coordinatorCertPath := store.CertPath(directory, flags.address)
+		config, err := network.GetMutualServerTLSConfig(certPath, keyPath, coordinatorCertPath)
+		if err != nil {
+			log.Fatalf("failed to load certificates (node): %v", err)
+		}
+
+		// Start ThresholdServer.
+		thresholdServer := new(network.ThresholdServer).New(&share, config)
+		if err := thresholdServer.Start(); err != nil {
+			log.Fatalf("failed to start ThresholdServer: %v", err)
+		}
+	},
+}
+
+// bank custody
+var custody = &cobra.Command{
+	Use:   "custody --bank BANK",
+	Short: "Separate the account generation and withdrawal keys into identities with their own passphrase.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		mainStore, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Read Bank.
+		bank, err := mainStore.ReadBank()
+		if err != nil {
+			log.Fatalf("failed to read Bank from database: %v", err)
+		}
+
+		// Read every mint. (Written into each custody identity)
+		profiles, err := mainStore.ReadMints()
+		if err != nil {
+			log.Fatalf("failed to read mints from database: %v", err)
+		}
+		var mints []*core.Bank
+		for _, profile := range profiles[1:] {
+			mint, err := mainStore.ReadMint(profile.Currency)
+			if err != nil {
+				log.Fatalf("failed to read mint %s from database: %v", profile.Currency, err)
+			}
+			mints = append(mints, mint)
+		}
+
+		// Write each custody identity.
+		custodies := []struct {
+			role       string
+			bank       *core.Bank
+			passphrase string
+		}{
+			{custodyAccgen, bank.AccgenCustody(), flags.accgenPassphrase},
+			{custodyWithdrawal, bank.WithdrawalCustody(), flags.withdrawalPassphrase},
+		}
+		for _, c := range custodies {
+			identity := fmt.Sprintf("%s-%s", flags.identity, c.role)
+			custodyStore, err := new(store.BankStore).New(dbPath, identity)
+			if err != nil {
+				log.Fatalf("failed to create store: %v", err)
+			}
+			passphrase := readPassphrase(c.passphrase, fmt.Sprintf("Passphrase (%s)", c.role))
+			if err := custodyStore.WriteSealedBank(c.bank, mainStore.Name, passphrase); err != nil {
+				log.Fatalf("failed to write %s identity: %v", identity, err)
+			}
+			for _, mint := range mints {
+				if c.role == custodyAccgen {
+					err = custodyStore.WriteMint(mint.AccgenCustody())
+				} else {
+					err = custodyStore.WriteSealedMint(mint.WithdrawalCustody(), passphrase)
+				}
+				if err != nil {
+					log.Fatalf("failed to write mint %s into %s identity: %v", mint.Currency, identity, err)
+				}
+			}
+			log.Printf("Identity %s written", identity)
+		}
+
+		// Remove the identity holding every key.
+		if flags.removeMain {
+			if err := mainStore.DeleteBank(); err != nil {
+				log.Fatalf("failed to delete %s identity: %v", flags.identity, err)
+			}
+			log.Printf("Identity %s removed", flags.identity)
+		}
+	},
+}
+
+// bank key
+var bankKey = &cobra.Command{
+	Use:   "key operation",
+	Short: "Back up and restore the bank's private identity.",
+}
+
+// bank key split
+var keySplit = &cobra.Command{
+	Use:   "split --bank BANK --threshold K --shares N",
+	Short: "Split the bank's private identity and its mints into N shares, any K of them restore them.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Check that database file exists.
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		} else {
+			directory, err := store.GetZibaDir()
+			if err != nil {
+				return err
+			}
+			dbPath := store.DatabasePath(directory, flags.bank)
+			_, err = os.Stat(dbPath)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+			}
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		bankStore, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Read Bank.
+		bank, err := bankStore.ReadBank()
+		if err != nil {
+			log.Fatalf("failed to read Bank from database: %v", err)
+		}
+
+		// Read every mint. (Sealed mints must be unlocked)
+		profiles, err := bankStore.ReadMints()
+		if err != nil {
+			log.Fatalf("failed to read mints from database: %v", err)
+		}
+		var mints []*core.Bank
+		for _, profile := range profiles[1:] {
+			mint, err := bankStore.ReadMint(profile.Currency)
+			if err != nil {
+				log.Fatalf("failed to read mint %s from database: %v", profile.Currency, err)
+			}
+			mints = append(mints, mint)
+		}
+
+		// Split the bank, then each mint.
+		shares, err := bank.Split(nil, flags.threshold, flags.shares)
+		if err != nil {
+			log.Fatalf("failed to split Bank: %v", err)
+		}
+		writeShares(directory, fmt.Sprintf("%s_share", flags.bank), shares)
+		for _, mint := range mints {
+			shares, err := mint.Split(nil, flags.threshold, flags.shares)
+			if err != nil {
+				log.Fatalf("failed to split mint %s: %v", mint.Currency, err)
+			}
+			writeShares(directory, fmt.Sprintf("%s_%s_share", flags.bank, mint.Currency), shares)
+		}
+	},
+}
+
+// writeShares writes one file per share into directory, named prefix followed by the share's index.
+func writeShares(directory, prefix string, shares []core.BankShare) {
+	for _, share := range shares {
+		sharePath := store.ConfigPath(directory, fmt.Sprintf("%s_%d.json", prefix, share.Index))
+		if err := core.SaveToFile(&share, sharePath); err != nil {
+			log.Fatalf("failed to write share: %v", err)
+		}
+		if err := os.Chmod(sharePath, 0600); err != nil { // rw- --- ---
+			log.Fatalf("failed to write share: %v", err)
+		}
+		log.Printf("Share %d/%d written to %s", share.Index, len(shares), sharePath)
+	}
+}
+
+// bank key restore
+var keyRestore = &cobra.Command{
+	Use:   "restore --bank BANK --share FILE --share FILE ... [--mint-share FILE ...]",
+	Short: "Restore the bank's private identity and its mints from their shares.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.bank) == 0 {
+			return fmt.Errorf("required \"bank\" flag not set")
+		}
+		if len(flags.share) == 0 {
+			return fmt.Errorf("required \"share\" flag not set")
+		}
+
+		if len(flags.identity) == 0 {
+			flags.identity = "main"
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get ziba directory.
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		}
+
+		// Restore Bank.
+		bank, err := core.RestoreBank(loadShares(flags.share))
+		if err != nil {
+			log.Fatalf("failed to restore Bank: %v", err)
+		}
+
+		// Restore each mint, its shares grouped by currency.
+		mintShares := make(map[string][]core.BankShare)
+		var currencies []string
+		for _, share := range loadShares(flags.mintShare) {
+			currency := core.NormalizeCurrency(share.Profile.Currency)
+			if _, ok := mintShares[currency]; !ok {
+				currencies = append(currencies, currency)
+			}
+			mintShares[currency] = append(mintShares[currency], share)
+		}
+		var mints []*core.Bank
+		for _, currency := range currencies {
+			mint, err := core.RestoreBank(mintShares[currency])
+			if err != nil {
+				log.Fatalf("failed to restore mint %s: %v", currency, err)
+			}
+			if mint.Pub.Cmp(bank.Pub) != 0 || currency == bank.Profile().Currency {
+				log.Fatalf("mint %s doesn't belong to the restored bank", currency)
+			}
+			mints = append(mints, mint)
+		}
+
+		// Create store.
+		dbPath := store.DatabasePath(directory, flags.bank)
+		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		if err != nil {
+			log.Fatalf("failed to create store: %v", err)
+		}
+
+		// Check that the identity is free.
+		if _, err := store.ReadBank(); err == nil {
+			log.Fatalf("a bank already exists for identity %s", flags.identity)
+		}
+
+		// Write Bank into database.
+		if err := store.WriteBank(bank, flags.bank); err != nil {
+			log.Fatalf("failed to write Bank into database: %v", err)
+		}
+
+		// Write mints into database.
+		for _, mint := range mints {
+			if err := store.WriteMint(mint); err != nil {
+				log.Fatalf("failed to write mint %s into database: %v", mint.Currency, err)
+			}
+			log.Printf("Mint %s restored", mint.Currency)
+		}
+
+		log.Printf("Bank %s restored", flags.bank)
+	},
+}
+
+// loadShares loads the share of each file of paths.
+func loadShares(paths []string) []core.BankShare {
+	shares := make([]core.BankShare, len(paths))
+	for i, sharePath := range paths {
+		file, err := os.Open(sharePath)
+		if err != nil {
+			log.Fatalf("failed to open share: %v", err)
+		}
+		if err := core.LoadFromFile(&shares[i], file); err != nil {
+			log.Fatalf("failed to load share %s: %v", sharePath, err)
+		}
+	}
+	return shares
+}
+
+// bank inspect
+var bankInspect = &cobra.Command{
+	Use:   "inspect [-f] [--format table|json|csv]",
+	Short: "View database information.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkFormat(flags.format, inspectionFormats); err != nil {
+			return err
+		}
+		return requireBankDatabase(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		inspection := inspectBank()
+		if err := printInspection(os.Stdout, inspection, flags.inspect, flags.format); err != nil {
+			log.Fatalf("failed to print inspection: %v", err)
+		}
+	},
+}
+
+// requireBankDatabase checks that the bank's database exists.
+func requireBankDatabase(cmd *cobra.Command, args []string) error {
+	// Check that database file exists.
+	if len(flags.bank) == 0 {
+		return fmt.Errorf("required \"bank\" flag not set")
+	} else {
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			return err
+		}
+		dbPath := store.DatabasePath(directory, flags.bank)
+		_, err = os.Stat(dbPath)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
+		}
+	}
 
-		// Create certificates.
-		network.CreateCertificate(directory, flags.bank)
-	},
-}
+	if len(flags.identity) == 0 {
+		flags.identity = "main"
+		// return fmt.Errorf("required \"identity\" flag not set")
+	}
 
-// wgBank.
-var wgBank sync.WaitGroup
+	return nil
+}
 
-// bank serve
-var serve = &cobra.Command{
-	Use:   "serve",
-	Short: "Start servers.",
+// bank operations
+var bankOperations = &cobra.Command{
+	Use:   "operations --bank BANK [--since AGE] [--bucket DURATION] [--format table|json]",
+	Short: "View the throughput of each operation served by the bank, from its operation log.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		// Check that database file exists.
-		if len(flags.bank) == 0 {
-			return fmt.Errorf("required \"bank\" flag not set")
-		} else {
-			directory, err := store.GetZibaDir()
-			if err != nil {
-				return err
-			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.bank))
-			_, err = os.Stat(dbPath)
-			if os.IsNotExist(err) {
-				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
-			}
+		if err := checkFormat(flags.format, statsFormats); err != nil {
+			return err
 		}
-
-		if len(flags.identity) == 0 {
-			flags.identity = "main"
-			// return fmt.Errorf("required \"identity\" flag not set")
+		if flags.since <= 0 || flags.bucket <= 0 {
+			return fmt.Errorf("\"since\" and \"bucket\" must be positive")
 		}
-
-		return nil
+		return requireBankDatabase(cmd, args)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get ziba directory.
@@ -577,104 +4866,261 @@ var serve = &cobra.Command{
 		}
 
 		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.bank))
-		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		dbPath := store.DatabasePath(directory, flags.bank)
+		bankStore, err := new(store.BankStore).NewReadOnly(dbPath, flags.identity)
 		if err != nil {
 			log.Fatalf("failed to create store: %v", err)
 		}
+		operations, err := bankStore.ReadOperationStats(time.Now().Add(-flags.since), flags.bucket)
+		if err != nil {
+			log.Fatalf("failed to read operation log from database: %v", err)
+		}
 
-		log.Printf("Bank's Name is: %s", store.Name)
+		// Report.
+		if flags.format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(operations); err != nil {
+				log.Fatalf("failed to print operation log: %v", err)
+			}
+			return
+		}
+		fmt.Printf("%-19s %-18s %-8s %-12s %-12s %-12s %-12s\n", "Start", "Operation", "Runs", "Mean", "Max", "Received", "Sent")
+		for _, entry := range operations {
+			fmt.Printf("%-19s %-18s %-8d %-12s %-12s %-12d %-12d\n", entry.Start.Local().Format(time.DateTime), entry.Name,
+				entry.Runs, entry.Mean.Round(time.Microsecond), entry.Max.Round(time.Microsecond), entry.Received, entry.Sent)
+		}
+	},
+}
 
-		// Load TLS server configuration.
-		keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", flags.bank))
-		certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", flags.bank))
-		config, err := network.GetServerTLSConfig(certPath, keyPath)
+// bank closures
+var bankClosures = &cobra.Command{
+	Use:     "closures --bank BANK",
+	Short:   "List the closed accounts, along with the balances paid out to them.",
+	PreRunE: requireBankDatabase,
+	Run: func(cmd *cobra.Command, args []string) {
+		closures, err := openBankReadOnly().ReadAccountClosures()
 		if err != nil {
-			log.Printf("failed to load certificate and key (server): %v", err)
+			log.Fatalf("failed to read account closures from database: %v", err)
 		}
 
-		// Start SetupServer.
-		setupServer := new(network.SetupServer).New(store)
-		wgBank.Add(1)
-		go func() {
-			defer wgBank.Done()
-			if err := setupServer.Start(); err != nil {
-				log.Fatalf("failed to start SetupServer: %v", err)
+		fmt.Printf("%-10s %-19s %s\n", "Client", "Date", "Paid out")
+		for _, closure := range closures {
+			var paid []string
+			for _, currency := range slices.Sorted(maps.Keys(closure.Balances)) {
+				paid = append(paid, strings.TrimSpace(fmt.Sprintf("%d %s", closure.Balances[currency], currency)))
 			}
-		}()
+			fmt.Printf("%-10d %-19s %s\n", closure.Client, closure.Date.Local().Format(time.DateTime), strings.Join(paid, ", "))
+		}
+	},
+}
 
-		// Start AccgenServer.
-		accgenServer := new(network.AccgenServer).New(store, config)
-		wgBank.Add(1)
-		go func() {
-			defer wgBank.Done()
-			if err := accgenServer.Start(); err != nil {
-				log.Fatalf("failed to start AccgenServer: %v", err)
-			}
-		}()
+// bank transfers
+var bankTransfers = &cobra.Command{
+	Use:   "transfers --bank BANK --account HASH",
+	Short: "List the transfers paid or received by the account HASH.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if flags.account == 0 {
+			return fmt.Errorf("required \"account\" flag not set")
+		}
+		return requireBankDatabase(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		transfers, err := openBankReadOnly().ReadTransfers(flags.account)
+		if err != nil {
+			log.Fatalf("failed to read transfers from database: %v", err)
+		}
 
-		// Start WithdrawalServer.
-		withdrawalServer := new(network.WithdrawalServer).New(store, config)
-		wgBank.Add(1)
-		go func() {
-			defer wgBank.Done()
-			if err := withdrawalServer.Start(); err != nil {
-				log.Fatalf("failed to start WithdrawalServer: %v", err)
-			}
-		}()
+		fmt.Printf("%-6s %-10s %-10s %-8s %-10s %s\n", "ID", "Payer", "Payee", "Currency", "Value", "Date")
+		for _, entry := range transfers {
+			fmt.Printf("%-6d %-10d %-10d %-8s %-10d %s\n", entry.ID, entry.Payer, entry.Payee, entry.Currency,
+				entry.Value, entry.Date.Local().Format(time.DateTime))
+		}
+	},
+}
 
-		// Start DepositServer.
-		depositServer := new(network.DepositServer).New(store, config)
-		wgBank.Add(1)
-		go func() {
-			defer wgBank.Done()
-			if err := depositServer.Start(); err != nil {
-				log.Fatalf("failed to start DepositServer: %v", err)
-			}
-		}()
+// openBankReadOnly returns the bank's store, read-only.
+func openBankReadOnly() *store.BankStore {
+	// Get ziba directory.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		log.Fatalf("failed to retrieve Ziba directory: %v", err)
+	}
 
-		// Start ExchangeServer.
-		exchangeServer := new(network.ExchangeServer).New(store, config)
-		wgBank.Add(1)
-		go func() {
-			defer wgBank.Done()
-			if err := exchangeServer.Start(); err != nil {
-				log.Fatalf("failed to start ExchangeServer: %v", err)
+	// Create store.
+	dbPath := store.DatabasePath(directory, flags.bank)
+	bankStore, err := new(store.BankStore).NewReadOnly(dbPath, flags.identity)
+	if err != nil {
+		log.Fatalf("failed to create store: %v", err)
+	}
+	return bankStore
+}
+
+// bank verify
+var bankVerify = &cobra.Command{
+	Use:   "verify --bank BANK [--format table|json]",
+	Short: "Verify the signatures of the bank's stored coins, and its balances against their audit log.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkFormat(flags.format, statsFormats); err != nil {
+			return err
+		}
+		return requireBankDatabase(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := openBankReadOnly().VerifyIntegrity()
+		if err != nil {
+			log.Fatalf("failed to verify database: %v", err)
+		}
+
+		// Report.
+		if flags.format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				log.Fatalf("failed to print report: %v", err)
 			}
-		}()
+		} else {
+			for _, problem := range report.Problems {
+				fmt.Println(problem)
+			}
+			fmt.Printf("Verified %d coins and %d balances: %d inconsistencies\n", report.Coins, report.Balances, len(report.Problems))
+		}
+		if len(report.Problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
 
-		// Don't exit main thread.
-		wgBank.Wait()
+// bank dispute
+var bankDispute = &cobra.Command{
+	Use:   "dispute operation",
+	Short: "List and export the evidence of double spends and invalid coins.",
+}
+
+// disputeKind returns the name of a dispute's kind.
+func disputeKind(kind int) string {
+	switch kind {
+	case core.DisputeDoubleSpend:
+		return "double spend"
+	case core.DisputeInvalidCoin:
+		return "invalid coin"
+	}
+	return fmt.Sprintf("kind %d", kind)
+}
+
+// bank dispute list
+var disputeList = &cobra.Command{
+	Use:     "list --bank BANK",
+	Short:   "List the disputes recorded by the bank.",
+	PreRunE: requireBankDatabase,
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := openBankReadOnly().ReadDisputes()
+		if err != nil {
+			log.Fatalf("failed to read disputes: %v", err)
+		}
+
+		fmt.Printf("%-6s %-13s %-10s %-10s %-10s %-19s\n", "ID", "Kind", "Operation", "Client", "Coin", "Date")
+		for _, record := range records {
+			dispute := &record.Dispute
+			fmt.Printf("%-6d %-13s %-10s %-10d %-10d %-19s\n", record.ID, disputeKind(dispute.Kind), record.Operation,
+				record.Client, dispute.Coins[0].Hash(), dispute.Date.Local().Format(time.DateTime))
+		}
 	},
 }
 
-// bank inspect
-var bankInspect = &cobra.Command{
-	Use:   "inspect",
-	Short: "View database information.",
+// bank dispute export
+var disputeExport = &cobra.Command{
+	Use:   "export --bank BANK --id N --file FILE",
+	Short: "Export dispute N into a self-contained evidence file, verified with the core package's Dispute.Verify.",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		// Check that database file exists.
-		if len(flags.bank) == 0 {
-			return fmt.Errorf("required \"bank\" flag not set")
-		} else {
-			directory, err := store.GetZibaDir()
-			if err != nil {
-				return err
-			}
-			dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.bank))
-			_, err = os.Stat(dbPath)
-			if os.IsNotExist(err) {
-				return fmt.Errorf("a database file does not exists for given name: %s", flags.bank)
-			}
+		if flags.dispute <= 0 {
+			return fmt.Errorf("required \"id\" flag not set")
+		}
+		if len(flags.file) == 0 {
+			return fmt.Errorf("required \"file\" flag not set")
+		}
+		if _, err := os.Stat(flags.file); err == nil {
+			return fmt.Errorf("file already exists: %s", flags.file)
+		}
+		return requireBankDatabase(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		record, err := openBankReadOnly().ReadDispute(flags.dispute)
+		if err != nil {
+			log.Fatalf("failed to read dispute: %v", err)
+		}
+		if err := record.Dispute.Verify(); err != nil {
+			log.Printf("dispute %d doesn't verify: %v", record.ID, err)
 		}
 
-		if len(flags.identity) == 0 {
-			flags.identity = "main"
-			// return fmt.Errorf("required \"identity\" flag not set")
+		// Write evidence.
+		evidence, err := record.Dispute.MarshalBinary()
+		if err != nil {
+			log.Fatalf("failed to encode dispute: %v", err)
+		}
+		if err := os.WriteFile(flags.file, evidence, 0644); err != nil { // rw- r-- r--
+			log.Fatalf("failed to write dispute: %v", err)
 		}
 
-		return nil
+		log.Printf("Dispute %d (%s of coin %d) exported to %s", record.ID, disputeKind(record.Dispute.Kind),
+			record.Dispute.Coins[0].Hash(), flags.file)
+	},
+}
+
+// inspectBank returns the inspection of the bank's database, full with the --full flag.
+func inspectBank() *store.BankInspection {
+	// Get ziba directory.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		log.Fatalf("failed to retrieve Ziba directory: %v", err)
+	}
+
+	// Create store.
+	dbPath := store.DatabasePath(directory, flags.bank)
+	store, err := new(store.BankStore).NewReadOnly(dbPath, flags.identity)
+	if err != nil {
+		log.Fatalf("failed to create store: %v", err)
+	}
+
+	// Inspect.
+	inspect := store.Inspect
+	if flags.inspect {
+		inspect = store.InspectFull
+	}
+	inspection, err := inspect()
+	if err != nil {
+		log.Fatalf("failed to inspect database: %v", err)
+	}
+	return inspection
+}
+
+// bank export
+var bankExport = &cobra.Command{
+	Use:   "export --bank BANKNAME --table TABLE [-f] [--format csv|json] [--file FILE]",
+	Short: "Export a table of the database, e.g. coins, for accountants.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkExport(); err != nil {
+			return err
+		}
+		return requireBankDatabase(cmd, args)
 	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportInspection(inspectBank()); err != nil {
+			log.Fatalf("failed to export table: %v", err)
+		}
+	},
+}
+
+// bankadmin
+var bankAdmin = &cobra.Command{
+	Use:   "bankadmin operation",
+	Short: "Perform bank operations remotely.",
+}
+
+// bankadmin init
+var adminInit = &cobra.Command{
+	Use:   "init --admin ADMIN",
+	Short: "Create the admin's certificate, trusted by the bank with \"bank serve --admin-cert\".",
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get ziba directory.
 		directory, err := store.GetZibaDir()
@@ -682,18 +5128,224 @@ var bankInspect = &cobra.Command{
 			log.Fatalf("failed to retrieve Ziba directory: %v", err)
 		}
 
-		// Create store.
-		dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", flags.bank))
-		store, err := new(store.BankStore).New(dbPath, flags.identity)
+		// Create certificates.
+		if err := network.CreateCertificate(directory, flags.admin); err != nil {
+			log.Fatalf("failed to create certificate: %v", err)
+		}
+		log.Printf("Copy %s to the bank's host", store.CertPath(directory, flags.admin))
+	},
+}
+
+// requireAdminServer checks the flags of remote admin operations.
+func requireAdminServer(cmd *cobra.Command, args []string) error {
+	if len(flags.address) == 0 {
+		return fmt.Errorf("required \"server\" flag not set")
+	}
+
+	// Check that certificates exist.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{flags.admin, flags.address} {
+		certPath := store.CertPath(directory, name)
+		if _, err := os.Stat(certPath); os.IsNotExist(err) {
+			return fmt.Errorf("certificate not found: %s", certPath)
+		}
+	}
+	return nil
+}
+
+// executeAdmin executes request on the bank's admin server and prints its output.
+func executeAdmin(request network.AdminRequest) {
+	output, err := requestAdmin(request)
+	fmt.Print(output)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// requestAdmin executes request on the bank's admin server and returns its output.
+func requestAdmin(request network.AdminRequest) (string, error) {
+	// Get ziba directory.
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		log.Fatalf("failed to retrieve Ziba directory: %v", err)
+	}
+
+	// Load TLS client configuration. (The bank's certificate is copied from the bank's host)
+	certPath := store.CertPath(directory, flags.admin)
+	keyPath := store.KeyPath(directory, flags.admin)
+	bankCertPath := store.CertPath(directory, flags.address)
+	config, err := network.GetMutualClientTLSConfig(certPath, keyPath, bankCertPath)
+	if err != nil {
+		log.Fatalf("failed to load certificates (admin): %v", err)
+	}
+
+	// Execute AdminClient.
+	return new(network.AdminClient).New(flags.address, config).Execute(request)
+}
+
+// bankadmin inspect
+var adminInspect = &cobra.Command{
+	Use:   "inspect --admin ADMIN --server SERVER [--format table|json|csv]",
+	Short: "View the bank's database information.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkFormat(flags.format, inspectionFormats); err != nil {
+			return err
+		}
+		return requireAdminServer(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, err := requestAdmin(network.AdminRequest{Operation: network.AdminInspect})
 		if err != nil {
-			log.Fatalf("failed to create store: %v", err)
+			log.Fatal(err)
 		}
 
-		// Inspect.
-		if flags.inspect {
-			store.InspectFull()
-		} else {
-			store.Inspect()
+		// Print inspection.
+		var inspection store.BankInspection
+		if err := json.Unmarshal([]byte(output), &inspection); err != nil {
+			log.Fatalf("failed to decode inspection: %v", err)
+		}
+		if err := printInspection(os.Stdout, &inspection, false, flags.format); err != nil {
+			log.Fatalf("failed to print inspection: %v", err)
+		}
+	},
+}
+
+// bankadmin report
+var adminReport = &cobra.Command{
+	Use:     "report --admin ADMIN --server SERVER",
+	Short:   "View a summary of the bank's accounts and coins.",
+	PreRunE: requireAdminServer,
+	Run: func(cmd *cobra.Command, args []string) {
+		executeAdmin(network.AdminRequest{Operation: network.AdminReport})
+	},
+}
+
+// bankadmin stats
+var adminStats = &cobra.Command{
+	Use:     "stats --admin ADMIN --server SERVER",
+	Short:   "View the bank's aggregate statistics as JSON, e.g. for a dashboard.",
+	PreRunE: requireAdminServer,
+	Run: func(cmd *cobra.Command, args []string) {
+		executeAdmin(network.AdminRequest{Operation: network.AdminStats})
+	},
+}
+
+// bankadmin pool
+var adminPool = &cobra.Command{
+	Use:     "pool --admin ADMIN --server SERVER",
+	Short:   "View the load of the bank's crypto pool: its workers, queue depth and waits.",
+	PreRunE: requireAdminServer,
+	Run: func(cmd *cobra.Command, args []string) {
+		executeAdmin(network.AdminRequest{Operation: network.AdminPool})
+	},
+}
+
+// bankadmin freeze
+var adminFreeze = &cobra.Command{
+	Use:   "freeze --admin ADMIN --server SERVER --account HASH [--reason REASON]",
+	Short: "Freeze an account, published on the revocation feed.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if flags.account == 0 {
+			return fmt.Errorf("required \"account\" flag not set")
+		}
+		return requireAdminServer(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		executeAdmin(network.AdminRequest{Operation: network.AdminFreeze, Account: flags.account, Reason: flags.reason})
+	},
+}
+
+// bankadmin fund
+var adminFund = &cobra.Command{
+	Use:   "fund --admin ADMIN --server SERVER --account HASH --amount AMOUNT [--currency CODE]",
+	Short: "Add an amount to an account's balance.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if flags.account == 0 {
+			return fmt.Errorf("required \"account\" flag not set")
+		}
+		if flags.amount == 0 {
+			return fmt.Errorf("required \"amount\" flag not set")
+		}
+		return requireAdminServer(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		executeAdmin(network.AdminRequest{Operation: network.AdminFund, Account: flags.account, Currency: flags.currency, Amount: flags.amount})
+	},
+}
+
+// params
+var params = &cobra.Command{
+	Use:   "params operation",
+	Short: "Perform scheme parameters operations.",
+}
+
+// params generate
+var paramsGenerate = &cobra.Command{
+	Use:   "generate --file FILE [--bits BITS]",
+	Short: "Generate new scheme parameters into FILE.",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(flags.file) == 0 {
+			return fmt.Errorf("required \"file\" flag not set")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// Stop on interrupt or timeout.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if flags.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, flags.timeout)
+			defer cancel()
+		}
+
+		// Generate.
+		log.Printf("Searching for a %d bits safe prime using %d workers...", flags.bits, flags.workers)
+		start := time.Now()
+		scheme, err := new(core.SchemeParams).Generate(ctx, nil, flags.bits, flags.workers)
+		if err != nil {
+			log.Fatalf("failed to generate scheme parameters: %v", err)
+		}
+		log.Printf("Found in %s", time.Since(start).Round(time.Millisecond))
+
+		// Save.
+		if err := core.SaveToFile(scheme, flags.file); err != nil {
+			log.Fatalf("failed to save scheme parameters: %v", err)
+		}
+		log.Printf("Scheme parameters written to %s", flags.file)
+	},
+}
+
+// debug
+var debug = &cobra.Command{
+	Use:   "debug operation",
+	Short: "Diagnose protocol failures.",
+}
+
+// debug replay
+var debugReplay = &cobra.Command{
+	Use:   "replay FILE",
+	Short: "Re-run the verifications of the payment session recorded at FILE. (See --transcripts)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read transcript.
+		entries, err := network.ReadTranscript(args[0])
+		if err != nil {
+			log.Fatalf("failed to read transcript: %v", err)
+		}
+
+		// Show the messages.
+		for _, entry := range entries {
+			fmt.Printf("%s  %-14s %-4s %-12s %d bytes\n", entry.Time.Local().Format("15:04:05.000"), entry.Role, entry.Direction, entry.Message, len(entry.Data))
+		}
+		fmt.Println()
+
+		// Verify.
+		if failed := network.ReplayTranscript(entries, os.Stdout); failed > 0 {
+			log.Fatalf("%d verifications failed", failed)
 		}
 	},
 }
@@ -706,36 +5358,350 @@ func init() {
 	ziba.PersistentFlags().StringVarP(&flags.address, "server", "s", "", "Remote server address.")
 	ziba.PersistentFlags().StringVarP(&flags.bank, "bank", "b", "", "Bank's name.")
 	ziba.PersistentFlags().StringVarP(&flags.user, "user", "u", "", "User's name.")
+	ziba.PersistentFlags().StringVar(&store.ZibaDir, "data-dir", "", "Directory of the keys, certificates and databases. ($ZIBA_DIR, or the OS's data directory if not set)")
+	ziba.PersistentFlags().StringVar(&flags.tlsMinVersion, "tls-min-version", "1.2", "Lowest accepted TLS version. (1.2 or 1.3)")
+	ziba.PersistentFlags().StringSliceVar(&flags.tlsCipherSuites, "tls-cipher-suites", nil, "Accepted TLS 1.2 cipher suites. (ECDSA suites if not set, Go's defaults if empty)")
+	ziba.PersistentFlags().StringVar(&flags.tlsServerName, "tls-server-name", "", "Expected server name of the bank's certificate. (The server address if not set)")
+	ziba.PersistentFlags().BoolVar(&flags.tlsSystemRoots, "tls-system-roots", false, "Trust the system's root CAs along with the bank's certificate.")
+	ziba.PersistentFlags().StringVar(&flags.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to export protocol traces to. (No tracing if not set)")
+	ziba.PersistentFlags().BoolVar(&flags.otlpInsecure, "otlp-insecure", false, "Export traces over plain HTTP.")
+	ziba.PersistentFlags().StringVar(&flags.otlpService, "otlp-service", "ziba", "Service name of the exported traces.")
+	ziba.PersistentFlags().BoolVarP(&flags.quiet, "quiet", "q", false, "Don't warn of the wallet's expiring coins.")
+	ziba.PersistentFlags().StringVar(&flags.transcripts, "transcripts", "", "Record the payment sessions in this directory, secrets redacted. (See \"debug replay\")")
 
 	// ziba user
 	ziba.AddCommand(user)
+	lockWallet(userInit, accgen, withdraw, charge, pay, deposit, exchange, release, reclaim, change, revocations, status, resume,
+		closeAccount, transfer, gift, claim, renew, importIdentity, userVerify, userSpent, pregenerate, accountSet, accountDelete, accountMove, refill,
+		agent)
+	proxyAgent(withdraw, pay, deposit, exchange)
 	// ziba user init
 	user.AddCommand(userInit)
+	userInit.Flags().StringSliceVar(&flags.hosts, "host", nil, "IP address or DNS name of the user's server certificate. (Repeat for each host)")
 	// ziba user accgen
 	user.AddCommand(accgen)
+	accgen.Flags().StringVar(&flags.token, "token", "", "Registration token, issued by the bank.")
+	accgen.Flags().StringVar(&flags.evidence, "evidence", "", "Evidence sent along with the account application, e.g. a KYC reference.")
 	// ziba user withdraw
 	user.AddCommand(withdraw)
+	withdraw.Flags().StringVar(&flags.currency, "currency", "", "Currency of the withdrawn coin. (Bank's primary currency if not set)")
+	withdraw.Flags().Int64Var(&flags.value, "value", 1, "Value of the withdrawn coin.")
+	withdraw.Flags().Int64Var(&flags.amount, "amount", 0, "Withdraw this amount as coins of the denominations instead.")
+	withdraw.Flags().Int64SliceVar(&flags.denominations, "denominations", core.DefaultDenominations, "Coin values to withdraw an amount as.")
+	withdraw.Flags().IntVar(&flags.count, "count", 1, "Number of coins of value to withdraw.")
+	withdraw.Flags().IntVar(&flags.parallel, "parallel", 1, "Number of withdrawal sessions run at once.")
 	// ziba user charge
 	user.AddCommand(charge)
+	charge.Flags().StringVar(&flags.bankServer, "bank-server", "", "Bank server attesting the certificate handed out to payers.")
+	charge.Flags().IntVar(&flags.pool, "pool", 0, "Keep this many pre-generated coins ready for withdrawals.")
+	charge.Flags().BoolVar(&flags.rejectLegacy, "reject-legacy-coins", false, "Reject coins signed with the legacy (textbook RSA) encoding.")
+	charge.Flags().Int64Var(&flags.amount, "amount", 0, "Reject payments worth less than this amount.")
+	charge.Flags().StringVar(&flags.currency, "currency", "", "Reject payments in other currencies than this one. (Any currency if not set)")
+	charge.Flags().BoolVar(&flags.once, "once", false, "Exit after the first accepted payment.")
+	charge.Flags().Int64Var(&flags.autoAccept, "auto-accept", 0, "Accept without asking up to this amount per payer per day.")
+	charge.Flags().BoolVarP(&flags.yes, "yes", "y", false, "Don't ask: accept every payment, or refuse those beyond --auto-accept.")
+	charge.Flags().DurationVar(&flags.timeout, "timeout", 0, "Stop charging after this duration, failing with --once if no payment was accepted. (Never if 0)")
+	charge.Flags().BoolVar(&flags.webSocket, "websocket", false, "Also take payments from browser wallets, over WebSocket on port 9106.")
+	charge.Flags().StringVar(&flags.relayServer, "relay", "", "Take payments through this relay, at USER@RELAY, instead of on port 9093. (Behind NAT)")
 	// ziba user pay
 	user.AddCommand(pay)
+	pay.Flags().DurationVar(&flags.escrow, "escrow", 0, "Escrow the payment for the given duration.")
+	pay.Flags().BoolVarP(&flags.yes, "yes", "y", false, "Pay without showing the payment's details and asking for confirmation.")
+	pay.Flags().StringVar(&flags.memo, "memo", "", "Attach a short reference (order id, note) to the payment.")
+	pay.Flags().StringVar(&flags.currency, "currency", "", "Currency of the paid coin. (Bank's primary currency if not set)")
+	pay.Flags().Int64Var(&flags.amount, "amount", 0, "Amount to pay, with as many coins as needed. (A whole coin if not set)")
+	pay.Flags().Int64SliceVar(&flags.denominations, "denominations", core.DefaultDenominations, "Coin values to exchange a larger coin for.")
+	pay.Flags().StringVar(&flags.bankServer, "bank-server", "", "Exchange a larger coin at this bank server when the amount can't be covered exactly. (A larger coin is spent partially if not set)")
+	pay.Flags().StringVar(&flags.subAccount, "account", "", "Pay with the coins of this sub-account. (The main account if not set)")
+	pay.Flags().StringVar(&flags.refill, "refill", "", "Refill the wallet at this bank server before paying, following its refill policies.")
 	// ziba user deposit
 	user.AddCommand(deposit)
+	deposit.Flags().StringVar(&flags.release, "release", "", "Deposit the escrowed coin released by this secret.")
+	deposit.Flags().StringVar(&flags.currency, "currency", "", "Currency of the deposited coin. (Bank's primary currency if not set)")
+	deposit.Flags().StringVar(&flags.beneficiary, "beneficiary", "", "Credit the account that issued this authorization token instead.")
 	// ziba user exchange
 	user.AddCommand(exchange)
+	exchange.Flags().StringVar(&flags.currency, "currency", "", "Currency of the exchanged coin. (Bank's primary currency if not set)")
+	exchange.Flags().Int64Var(&flags.value, "split", 0, "Split the exchanged coin into a coin of this value and one of the remaining value.")
+	exchange.Flags().Int64SliceVar(&flags.denominations, "denominations", core.DefaultDenominations, "Break the new coins down into coins of these values.")
+	exchange.Flags().StringVar(&flags.to, "to", "", "Currency of the new coins, at the bank's exchange rate. (The exchanged coin's currency if not set)")
+	exchange.Flags().Int64Var(&flags.amount, "consolidate", 0, "Exchange coins worth this amount exactly for a single coin instead.")
+	exchange.Flags().StringVar(&flags.subAccount, "account", "", "Exchange the coins of this sub-account, keeping the new coins in it. (The main account if not set)")
+	exchange.Flags().Var(newAgeValue(0, &flags.expiringWithin), "expiring", "Exchange each coin expiring within this age, e.g. 5d, for a fresh one instead.")
+	// ziba user release
+	user.AddCommand(release)
+	release.Flags().Uint32Var(&flags.coin, "coin", 0, "Escrowed coin's hash.")
+	// ziba user reclaim
+	user.AddCommand(reclaim)
+	// ziba user change
+	user.AddCommand(change)
+	// ziba user revocations
+	user.AddCommand(revocations)
+	// ziba user status
+	user.AddCommand(status)
+	// ziba user resume
+	user.AddCommand(resume)
+	// ziba user close
+	user.AddCommand(closeAccount)
+	closeAccount.Flags().BoolVarP(&flags.yes, "yes", "y", false, "Close the account without asking for confirmation.")
+	// ziba user transfer
+	user.AddCommand(transfer)
+	transfer.Flags().Uint32Var(&flags.account, "account", 0, "Credited account's hash. (Print USER's own if not set)")
+	transfer.Flags().Int64Var(&flags.amount, "amount", 0, "Transferred amount.")
+	transfer.Flags().StringVar(&flags.currency, "currency", "", "Currency of the transfer. (Bank's primary currency if not set)")
+	transfer.Flags().BoolVarP(&flags.yes, "yes", "y", false, "Transfer without asking for confirmation.")
+	// ziba user review
+	user.AddCommand(review)
+	review.Flags().Int64Var(&flags.alert, "id", 0, "Alert number of the held operation.")
+	// ziba user gift
+	user.AddCommand(gift)
+	gift.Flags().Int64Var(&flags.value, "value", 0, "Value of the gifted coin. (Any coin if not set)")
+	gift.Flags().StringVar(&flags.currency, "currency", "", "Currency of the gifted coin. (Bank's primary currency if not set)")
+	gift.Flags().StringVar(&flags.file, "file", "", "Write the claim token to this file. (Printed if not set)")
+	// ziba user claim
+	user.AddCommand(claim)
+	claim.Flags().StringVar(&flags.file, "file", "", "Claim token's path.")
+	claim.Flags().StringVar(&flags.token, "token", "", "Claim token.")
+	// ziba user authorize
+	user.AddCommand(authorize)
+	authorize.Flags().StringVar(&flags.collector, "collector", "", "Identity hash (hexadecimal) of the account depositing on USER's behalf. (USER's own is printed if not set)")
+	authorize.Flags().StringVar(&flags.file, "file", "", "Write the authorization token to this file. (Printed if not set)")
+	// ziba user renew
+	user.AddCommand(renew)
+	// ziba user export-identity
+	user.AddCommand(exportIdentity)
+	exportIdentity.Flags().StringVar(&flags.file, "file", "", "Identity bundle's path.")
+	exportIdentity.Flags().StringVar(&flags.passphrase, "passphrase", "", "Identity bundle's passphrase. (Prompted if not set)")
+	// ziba user import-identity
+	user.AddCommand(importIdentity)
+	importIdentity.Flags().StringVar(&flags.file, "file", "", "Identity bundle's path.")
+	importIdentity.Flags().StringVar(&flags.passphrase, "passphrase", "", "Identity bundle's passphrase. (Prompted if not set)")
 	// ziba user inspect
 	user.AddCommand(userInspect)
 	userInspect.Flags().BoolVarP(&flags.inspect, "full", "f", false, "Show all fields.")
+	userInspect.Flags().StringVar(&flags.format, "format", "table", "Output format: table, json or csv.")
+	// ziba user export
+	user.AddCommand(userExport)
+	userExport.Flags().StringVar(&flags.table, "table", "", "Exported table, e.g. coins.")
+	userExport.Flags().BoolVarP(&flags.inspect, "full", "f", false, "Export all fields.")
+	userExport.Flags().StringVar(&flags.exportFormat, "format", "csv", "Output format: csv or json.")
+	userExport.Flags().StringVar(&flags.file, "file", "", "Exported file's path. (Standard output if not set)")
+	// ziba user verify
+	user.AddCommand(userVerify)
+	userVerify.Flags().BoolVar(&flags.fix, "fix", false, "Recompute drifted local balances from the coins held.")
+	userVerify.Flags().BoolVar(&flags.quarantine, "quarantine", false, "Move the invalid, revoked and unknown bank's coins out of the wallet, into its quarantine.")
+	// ziba user coins
+	user.AddCommand(userCoins)
+	// ziba user spent
+	user.AddCommand(userSpent)
+	userSpent.Flags().DurationVar(&flags.purge, "purge", 0, "Delete the coins spent longer ago than this age from the archive.")
+	// ziba user certificates
+	user.AddCommand(userCertificates)
+	// ziba user receipts
+	user.AddCommand(userReceipts)
+	// ziba user receipts verify
+	userReceipts.AddCommand(receiptsVerify)
+	// ziba user stats
+	user.AddCommand(userStats)
+	userStats.Flags().StringVar(&flags.format, "format", "table", "Output format: table or json.")
+	// ziba user pregenerate
+	user.AddCommand(pregenerate)
+	pregenerate.Flags().IntVarP(&flags.pool, "count", "n", 10, "Number of pre-generated coins to keep.")
+	// ziba user refill
+	user.AddCommand(refill)
+	refill.Flags().StringVar(&flags.currency, "currency", "", "Currency of the refill policy. (Bank's primary currency if not set)")
+	refill.Flags().IntVar(&flags.refillThreshold, "threshold", 0, "Refill the wallet when it holds fewer coins than this.")
+	refill.Flags().IntVar(&flags.refillTarget, "target", 0, "Coin count the wallet is refilled up to.")
+	refill.Flags().Int64Var(&flags.refillValue, "value", 1, "Value of the withdrawn coins.")
+	refill.Flags().BoolVar(&flags.disable, "disable", false, "Disable the refill policy.")
+	// ziba user agent
+	user.AddCommand(agent)
+	agent.Flags().DurationVar(&flags.interval, "interval", time.Minute, "Time between refills and exchanges.")
+	agent.Flags().Var(newAgeValue(24*time.Hour, &flags.expiring), "expiring", "Exchange the coins expiring within this age, e.g. 5d. (Never if 0)")
+	// ziba user balance
+	user.AddCommand(userBalance)
+	// ziba user account
+	user.AddCommand(userAccount)
+	// ziba user account list
+	userAccount.AddCommand(accountList)
+	// ziba user account set
+	userAccount.AddCommand(accountSet)
+	accountSet.Flags().StringVar(&flags.subAccount, "name", "", "Sub-account's name.")
+	accountSet.Flags().Int64Var(&flags.limit, "limit", 0, "Most the sub-account pays within the period, in each currency. (No limit if 0)")
+	accountSet.Flags().DurationVar(&flags.period, "period", 24*time.Hour, "Sliding window of the spending limit.")
+	// ziba user account delete
+	userAccount.AddCommand(accountDelete)
+	accountDelete.Flags().StringVar(&flags.subAccount, "name", "", "Sub-account's name.")
+	// ziba user account move
+	userAccount.AddCommand(accountMove)
+	accountMove.Flags().Uint32Var(&flags.coin, "coin", 0, "Moved coin's hash.")
+	accountMove.Flags().StringVar(&flags.subAccount, "to", "", "Destination sub-account. (The main account if not set)")
 
 	// ziba bank
 	ziba.AddCommand(bank)
 	// ziba bank init
 	bank.AddCommand(bankInit)
+	bankInit.Flags().StringSliceVar(&flags.hosts, "host", nil, "IP address or DNS name of the bank's certificate. (Repeat for each host)")
+	bankInit.Flags().StringVar(&flags.params, "params", "", "Scheme parameters file, see \"params generate\". (Built-in parameters if not set)")
+	// ziba bank certificate
+	bank.AddCommand(bankCertificate)
+	bankCertificate.Flags().StringSliceVar(&flags.hosts, "host", nil, "IP address or DNS name of the bank's certificate. (Repeat for each host)")
+	// ziba bank mint
+	bank.AddCommand(bankMint)
+	bankMint.Flags().StringVar(&flags.currency, "currency", "", "Currency code of the new mint. (Three uppercase letters)")
+	// ziba bank rate
+	bank.AddCommand(bankRate)
+	bankRate.Flags().StringVar(&flags.currency, "from", "", "Currency code exchanged from.")
+	bankRate.Flags().StringVar(&flags.to, "to", "", "Currency code exchanged to.")
+	bankRate.Flags().StringVar(&flags.rate, "rate", "", "Value in \"to\" of 1 in \"from\". (NUM/DEN)")
+	// ziba bank approve
+	bank.AddCommand(bankApprove)
+	bankApprove.Flags().Uint32Var(&flags.client, "client", 0, "Applying client's hash.")
+	bankApprove.Flags().BoolVar(&flags.reject, "reject", false, "Reject the application instead.")
+	// ziba bank pending
+	bank.AddCommand(bankPending)
+	// ziba bank pending list
+	bankPending.AddCommand(pendingList)
+	// ziba bank pending approve
+	bankPending.AddCommand(pendingApprove)
+	pendingApprove.Flags().Int64Var(&flags.alert, "id", 0, "Approved operation's number, see \"bank pending list\".")
+	// ziba bank pending reject
+	bankPending.AddCommand(pendingReject)
+	pendingReject.Flags().Int64Var(&flags.alert, "id", 0, "Rejected operation's number, see \"bank pending list\".")
+	// ziba bank token
+	bank.AddCommand(bankToken)
+	bankToken.Flags().IntVarP(&flags.count, "count", "n", 1, "Number of tokens to issue.")
+	bankToken.Flags().StringVar(&flags.note, "note", "", "Note kept with the tokens, e.g. whom they were handed to.")
+	// ziba bank revoke
+	bank.AddCommand(bankRevoke)
+	bankRevoke.Flags().Uint32Var(&flags.coin, "coin", 0, "Revoked coin's hash.")
+	bankRevoke.Flags().Uint32Var(&flags.account, "account", 0, "Frozen account's hash.")
+	bankRevoke.Flags().StringVar(&flags.reason, "reason", "", "Reason of the revocation.")
 	// ziba bank serve
 	bank.AddCommand(serve)
+	serve.Flags().StringSliceVar(&flags.nodes, "node", nil, "Bank node address, enables threshold mode. (Repeat for each node)")
+	serve.Flags().BoolVar(&flags.rejectLegacy, "reject-legacy-coins", false, "Reject coins signed with the legacy (textbook RSA) encoding.")
+	serve.Flags().BoolVar(&flags.requireBinding, "require-payee-binding", false, "Reject coins that aren't bound to the depositing account.")
+	serve.Flags().BoolVar(&flags.custody, "custody", false, "Use the separate account generation and withdrawal identities.")
+	serve.Flags().StringSliceVar(&flags.acmeDomains, "acme-domain", nil, "Bank's domain, enables ACME (Let's Encrypt) certificates. (Repeat for each domain)")
+	serve.Flags().StringVar(&flags.acmeEmail, "acme-email", "", "Contact email of the ACME account.")
+	serve.Flags().StringVar(&flags.acmeListen, "acme-listen", ":80", "Address answering the ACME HTTP challenges.")
+	serve.Flags().BoolVar(&flags.requireToken, "require-token", false, "Only open accounts for clients presenting a registration token. (See \"bank token\")")
+	serve.Flags().IntVar(&flags.work, "work", 0, "Proof of work difficulty of account applications, in bits. (About 2^BITS hashes per account)")
+	serve.Flags().IntVar(&flags.quota, "quota", 0, "Accounts opened per host within the quota window. (Unlimited if 0)")
+	serve.Flags().DurationVar(&flags.quotaWindow, "quota-window", 24*time.Hour, "Window of the accounts quota.")
+	serve.Flags().StringVar(&flags.admission, "admission", "auto", "Admission policy of account applications: auto, manual (see \"bank approve\") or the URL of an approval service.")
+	serve.Flags().StringVar(&flags.policy, "policy", "", "Bank policy file: fraud rules and operation limits. (config/BANK_policy.json if present)")
+	serve.Flags().StringVar(&flags.adminCert, "admin-cert", "", "Admin's certificate, enables remote administration.")
+	serve.Flags().BoolVar(&flags.relay, "relay", false, "Relay payments to merchants behind NAT, on port 9107. (See \"user charge --relay\")")
+	serve.Flags().DurationVar(&flags.certCheck, "cert-check", time.Minute, "Interval between checks of the certificate files for renewal.")
+	serve.Flags().DurationVar(&flags.certWarning, "cert-warning", 30*24*time.Hour, "Warn when the certificate expires within this duration.")
+	serve.Flags().StringVar(&flags.accgenPassphrase, "accgen-passphrase", "", "Account generation identity's passphrase. (Prompted if not set)")
+	serve.Flags().StringVar(&flags.withdrawalPassphrase, "withdrawal-passphrase", "", "Withdrawal identity's passphrase. (Prompted if not set)")
+	serve.Flags().IntVar(&flags.dbMaxConns, "db-max-conns", store.ServerOptions.MaxOpenConns, "Maximum number of open database connections. (Unlimited if 0)")
+	serve.Flags().IntVar(&flags.dbIdleConns, "db-idle-conns", store.ServerOptions.MaxIdleConns, "Database connections kept open between requests.")
+	serve.Flags().DurationVar(&flags.dbBusyTimeout, "db-busy-timeout", store.ServerOptions.BusyTimeout, "How long a request waits for the locked database.")
+	serve.Flags().StringSliceVar(&flags.dbPragmas, "db-pragma", nil, "SQLite pragma override, e.g. synchronous=FULL. (Repeat for each pragma)")
+	serve.Flags().DurationVar(&flags.coinValidity, "coin-validity", 0, "Validity period of the issued coins. (One month and one day if not set)")
+	serve.Flags().StringSliceVar(&flags.coinValidities, "coin-validity-for", nil, "Validity period of the coins of a value, e.g. 100=2160h. (Repeat for each value)")
+	serve.Flags().DurationVar(&flags.exchangeGrace, "exchange-grace", 0, "Grace period after their expiration during which coins are still exchanged. (Never if 0)")
+	serve.Flags().IntVar(&flags.cryptoWorkers, "crypto-workers", runtime.NumCPU(), "Coin responses computed at once.")
+	serve.Flags().IntVar(&flags.cryptoQueue, "crypto-queue", network.DefaultCryptoQueue, "Operations waiting to compute their coin responses at most, refused beyond.")
+	serve.Flags().DurationVar(&flags.operationLog, "operation-log", 7*24*time.Hour, "Retention of the operation log, the timing and payload sizes of the served runs. (Disabled if 0)")
+	// ziba bank operations
+	bank.AddCommand(bankOperations)
+	bankOperations.Flags().DurationVar(&flags.since, "since", 24*time.Hour, "Show the runs served within this age.")
+	bankOperations.Flags().DurationVar(&flags.bucket, "bucket", time.Hour, "Aggregate the runs over buckets of this duration.")
+	bankOperations.Flags().StringVar(&flags.format, "format", "table", "Output format: table or json.")
+	// ziba bank closures
+	bank.AddCommand(bankClosures)
+	// ziba bank transfers
+	bank.AddCommand(bankTransfers)
+	bankTransfers.Flags().Uint32Var(&flags.account, "account", 0, "Account's hash.")
+	// ziba bank verify
+	bank.AddCommand(bankVerify)
+	bankVerify.Flags().StringVar(&flags.format, "format", "table", "Output format: table or json.")
+	// ziba bank dispute
+	bank.AddCommand(bankDispute)
+	// ziba bank dispute list
+	bankDispute.AddCommand(disputeList)
+	// ziba bank dispute export
+	bankDispute.AddCommand(disputeExport)
+	disputeExport.Flags().Int64Var(&flags.dispute, "id", 0, "Exported dispute's number, see \"bank dispute list\".")
+	disputeExport.Flags().StringVar(&flags.file, "file", "", "Evidence file's path.")
 	// ziba bank inspect
 	bank.AddCommand(bankInspect)
 	bankInspect.Flags().BoolVarP(&flags.inspect, "full", "f", false, "Show all fields.")
+	bankInspect.Flags().StringVar(&flags.format, "format", "table", "Output format: table, json or csv.")
+	// ziba bank export
+	bank.AddCommand(bankExport)
+	bankExport.Flags().StringVar(&flags.table, "table", "", "Exported table, e.g. coins.")
+	bankExport.Flags().BoolVarP(&flags.inspect, "full", "f", false, "Export all fields.")
+	bankExport.Flags().StringVar(&flags.exportFormat, "format", "csv", "Output format: csv or json.")
+	bankExport.Flags().StringVar(&flags.file, "file", "", "Exported file's path. (Standard output if not set)")
+	// ziba bank threshold
+	bank.AddCommand(bankThreshold)
+	// ziba bank threshold split
+	bankThreshold.AddCommand(thresholdSplit)
+	thresholdSplit.Flags().IntVarP(&flags.threshold, "threshold", "t", 2, "Number of nodes needed to answer a coin request.")
+	thresholdSplit.Flags().IntVarP(&flags.shares, "shares", "n", 3, "Number of node shares to create.")
+	// ziba bank threshold node
+	bankThreshold.AddCommand(thresholdNode)
+	thresholdNode.Flags().StringSliceVar(&flags.share, "share", nil, "Node share file.")
+	thresholdNode.Flags().StringSliceVar(&flags.hosts, "host", nil, "IP address or DNS name of the node's certificate, if created. (Repeat for each host)")
+	// ziba bank custody
+	bank.AddCommand(custody)
+	custody.Flags().BoolVar(&flags.removeMain, "remove-main", false, "Remove the identity holding every key. (Back it up with \"bank key split\" first)")
+	custody.Flags().StringVar(&flags.accgenPassphrase, "accgen-passphrase", "", "Account generation identity's passphrase. (Prompted if not set)")
+	custody.Flags().StringVar(&flags.withdrawalPassphrase, "withdrawal-passphrase", "", "Withdrawal identity's passphrase. (Prompted if not set)")
+	// ziba bank key
+	bank.AddCommand(bankKey)
+	// ziba bank key split
+	bankKey.AddCommand(keySplit)
+	keySplit.Flags().IntVarP(&flags.threshold, "threshold", "k", 2, "Number of shares needed to restore.")
+	keySplit.Flags().IntVarP(&flags.shares, "shares", "n", 3, "Number of shares to create.")
+	// ziba bank key restore
+	bankKey.AddCommand(keyRestore)
+	keyRestore.Flags().StringSliceVar(&flags.share, "share", nil, "Share file. (Repeat for each share)")
+	keyRestore.Flags().StringSliceVar(&flags.mintShare, "mint-share", nil, "Share file of a mint. (Repeat for each share, of every mint)")
+
+	// ziba bankadmin
+	ziba.AddCommand(bankAdmin)
+	bankAdmin.PersistentFlags().StringVar(&flags.admin, "admin", "admin", "Admin's name.")
+	// ziba bankadmin init
+	bankAdmin.AddCommand(adminInit)
+	// ziba bankadmin inspect
+	bankAdmin.AddCommand(adminInspect)
+	adminInspect.Flags().StringVar(&flags.format, "format", "table", "Output format: table, json or csv.")
+	// ziba bankadmin report
+	bankAdmin.AddCommand(adminReport)
+	// ziba bankadmin stats
+	bankAdmin.AddCommand(adminStats)
+	// ziba bankadmin pool
+	bankAdmin.AddCommand(adminPool)
+	// ziba bankadmin freeze
+	bankAdmin.AddCommand(adminFreeze)
+	adminFreeze.Flags().Uint32Var(&flags.account, "account", 0, "Frozen account's hash.")
+	adminFreeze.Flags().StringVar(&flags.reason, "reason", "", "Reason of the freeze.")
+	// ziba bankadmin fund
+	bankAdmin.AddCommand(adminFund)
+	adminFund.Flags().Uint32Var(&flags.account, "account", 0, "Funded account's hash.")
+	adminFund.Flags().Int64Var(&flags.amount, "amount", 0, "Amount added to the balance. (Negative to withdraw funds)")
+	adminFund.Flags().StringVar(&flags.currency, "currency", "", "Currency of the balance. (Bank's primary currency if not set)")
+
+	// ziba params
+	ziba.AddCommand(params)
+	// ziba params generate
+	params.AddCommand(paramsGenerate)
+	paramsGenerate.Flags().StringVar(&flags.file, "file", "", "Scheme parameters file.")
+	paramsGenerate.Flags().IntVar(&flags.bits, "bits", 1024, "Bit length of the Sophie-Germain prime (q).")
+	paramsGenerate.Flags().IntVar(&flags.workers, "workers", runtime.NumCPU(), "Number of parallel searches.")
+	paramsGenerate.Flags().DurationVar(&flags.timeout, "timeout", 0, "Give up after this duration.")
+
+	// ziba debug
+	ziba.AddCommand(debug)
+	// ziba debug replay
+	debug.AddCommand(debugReplay)
 }
 
 func Execute() {
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"io"
+	"log"
+	"math/big"
+)
+
+//
+// ATTESTATION
+//
+
+// 1. A merchant asks its bank to attest the fingerprint of its TLS certificate, signing the request with its RSA key.
+// 2. The Bank checks the merchant's account and signs the merchant's public identity, the fingerprint and the date
+//		with its private identity number. (A Schnorr signature, like the revocation list's)
+// 3. The merchant hands its certificate out along with the attestation. A payer of the same bank verifies it before
+//		trusting the certificate, so that a certificate can't be swapped on the way.
+
+// attestationRequestDigest computes the digest of fingerprint and the merchant, the request's signed message.
+func attestationRequestDigest(merchant *ClientProfile, fingerprint string) *big.Int {
+	return newTranscript("ziba/attestation/request").number(merchant.Digest()).field([]byte(fingerprint)).digest()
+}
+
+// attestationDigest computes the digest of att's contents, the bank's signed message.
+func attestationDigest(att *Attestation) *big.Int {
+	return newTranscript("ziba/attestation").number(att.Merchant.Digest()).field([]byte(att.Fingerprint)).date(att.Issued).digest()
+}
+
+// RequestAttestation returns client's request for the bank to attest the certificate of fingerprint.
+func (client *Client) RequestAttestation(fingerprint string) *AttestationRequest {
+	merchant := client.Profile()
+	digest := attestationRequestDigest(merchant, fingerprint)
+	return &AttestationRequest{
+		Merchant:    *merchant,
+		Fingerprint: fingerprint,
+		Signature:   client.Key.sign(digest),
+	}
+}
+
+// Verify verifies req was signed by its merchant.
+func (req *AttestationRequest) Verify() error {
+	if req.Signature == nil || req.Merchant.N == nil || req.Merchant.E == nil || len(req.Fingerprint) == 0 {
+		return ErrAttestation
+	}
+	if req.Signature.Sign() <= 0 || req.Signature.Cmp(req.Merchant.N) >= 0 {
+		return ErrAttestation
+	}
+
+	// Check s^e = H(merchant, fingerprint) mod n.
+	digest := attestationRequestDigest(&req.Merchant, req.Fingerprint)
+	signed := new(big.Int).Exp(req.Signature, req.Merchant.E, req.Merchant.N)
+	if !equal(signed, new(big.Int).Mod(digest, req.Merchant.N), req.Merchant.N) {
+		return ErrAttestation
+	}
+	return nil
+}
+
+// Attest signs the attestation of req's merchant and fingerprint, dated now, and returns it. The request and the
+// merchant's account must have been checked.
+func (bank *Bank) Attest(random io.Reader, req *AttestationRequest) *Attestation {
+	att := &Attestation{
+		Merchant:    req.Merchant,
+		Fingerprint: req.Fingerprint,
+		Issued:      Now().UTC(),
+	}
+
+	R, S, err := bank.schnorrSign(random, "ziba/attestation/challenge", attestationDigest(att))
+	if err != nil {
+		log.Printf("failed to generate nonce for Attestation")
+		return nil
+	}
+	att.R, att.S = R, S
+
+	return att
+}
+
+// Verify verifies att was signed by bank for the certificate of fingerprint.
+func (att *Attestation) Verify(bank *BankProfile, fingerprint string) error {
+	if att.Merchant.N == nil || att.Merchant.E == nil || att.Fingerprint != fingerprint {
+		return ErrAttestation
+	}
+	if !bank.schnorrVerify("ziba/attestation/challenge", attestationDigest(att), att.R, att.S) {
+		return ErrAttestation
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"runtime"
+	"sync"
+)
+
+// VerifyCoinBatch verifies the properties of every coin concurrently, using one worker per GOMAXPROCS. Returns whether
+// each coin is valid, in the same order as coins.
+func VerifyCoinBatch(bank *BankProfile, coins []*CoinProfile) []bool {
+	valid := make([]bool, len(coins))
+
+	// Feed coin indices to the workers.
+	indices := make(chan int)
+	go func() {
+		for i := range coins {
+			indices <- i
+		}
+		close(indices)
+	}()
+
+	// Start workers.
+	workers := min(runtime.GOMAXPROCS(0), len(coins))
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				valid[i] = coins[i] != nil && coins[i].VerifyProperties(bank)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return valid
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"encoding/base64"
+	"math/big"
+	"strings"
+)
+
+//
+// BENEFICIARY
+//
+
+// 1. The Beneficiary signs, with its RSA key, an authorization naming the Collector's account (its identity hash) and
+//		its own public identity, and hands it to the Collector as a printable token.
+// 2. The Collector deposits its coins along with the authorization. The coins' memos, escrows and bindings are still
+//		checked against the Collector, who collected them.
+// 3. The Bank verifies the authorization against the Beneficiary's stored account and credits the Beneficiary's
+//		balance instead of the Collector's.
+// The authorization names the Collector, another wallet can't use it. It stands until the Beneficiary's key changes.
+
+// authorizationPrefix tags authorization tokens.
+const authorizationPrefix = "ziba-authorization:"
+
+// authorizationDigest computes the digest of the collector's identity hash and the beneficiary, the signed message.
+func authorizationDigest(collector *big.Int, beneficiary *ClientProfile) *big.Int {
+	return newTranscript("ziba/deposit/beneficiary").number(collector).number(beneficiary.Digest()).digest()
+}
+
+// AuthorizeDeposit returns client's authorization for collector to deposit coins to client's balance.
+func (client *Client) AuthorizeDeposit(collector *ClientProfile) *DepositAuthorization {
+	beneficiary := client.Profile()
+	digest := authorizationDigest(collector.IdentityHash, beneficiary)
+	return &DepositAuthorization{
+		Collector:   collector.IdentityHash,
+		Beneficiary: *beneficiary,
+		Signature:   client.Key.sign(digest),
+	}
+}
+
+// Verify verifies auth was signed by its beneficiary for collector.
+func (auth *DepositAuthorization) Verify(collector *ClientProfile) error {
+	if auth.Collector == nil || auth.Signature == nil || auth.Beneficiary.N == nil || auth.Beneficiary.E == nil {
+		return ErrAuthorization
+	}
+	if auth.Collector.Cmp(collector.IdentityHash) != 0 {
+		return ErrAuthorization
+	}
+	if auth.Signature.Sign() <= 0 || auth.Signature.Cmp(auth.Beneficiary.N) >= 0 {
+		return ErrAuthorization
+	}
+
+	// Check s^e = H(collector, beneficiary) mod n.
+	digest := authorizationDigest(auth.Collector, &auth.Beneficiary)
+	signed := new(big.Int).Exp(auth.Signature, auth.Beneficiary.E, auth.Beneficiary.N)
+	if !equal(signed, new(big.Int).Mod(digest, auth.Beneficiary.N), auth.Beneficiary.N) {
+		return ErrAuthorization
+	}
+	return nil
+}
+
+// NewAuthorizationToken returns the printable token of auth.
+func NewAuthorizationToken(auth *DepositAuthorization) (string, error) {
+	data, err := auth.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return authorizationPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseAuthorizationToken returns the authorization of a token. Surrounding whitespace is ignored.
+func ParseAuthorizationToken(token string) (*DepositAuthorization, error) {
+	encoded, found := strings.CutPrefix(strings.TrimSpace(token), authorizationPrefix)
+	if !found {
+		return nil, ErrAuthorization
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrAuthorization
+	}
+
+	var auth DepositAuthorization
+	if err := auth.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
@@ -0,0 +1,573 @@
+package core
+
+import (
+	"encoding/binary"
+	"math/big"
+	"time"
+)
+
+//
+// BINARY ENCODING
+//
+// Every type is encoded as a version byte followed by its fields, in the order they are declared:
+//
+//	integer:  signed varint
+//	number:   0 (nil) | 1 (non-negative), uvarint length, big-endian magnitude | 2 (negative), idem
+//	date:     uvarint length, time.Time.MarshalBinary
+//	string:   uvarint length, bytes
+//	struct:   its fields, without version byte
+//
+// Version 1 encodings, before the poly-currency support, have no Currency fields. They decode in DefaultCurrency.
+// Version 2 encodings, before the divisible coins, have no Value fields. They decode as coins of value 1.
+//
+
+// binaryVersion is the version of the binary encoding.
+const binaryVersion = 3
+
+// binaryCurrencyVersion is the first version encoding currencies.
+const binaryCurrencyVersion = 2
+
+// binaryValueVersion is the first version encoding coin values.
+const binaryValueVersion = 3
+
+// Number tags.
+const (
+	binaryNil      = 0
+	binaryPositive = 1
+	binaryNegative = 2
+)
+
+// binaryWriter appends encoded fields to a buffer.
+type binaryWriter struct {
+	buf []byte
+}
+
+// newBinaryWriter returns a binaryWriter whose buffer starts with the version byte.
+func newBinaryWriter() *binaryWriter {
+	return &binaryWriter{buf: []byte{binaryVersion}}
+}
+
+// int.
+func (w *binaryWriter) int(n int) {
+	w.buf = binary.AppendVarint(w.buf, int64(n))
+}
+
+// bytes.
+func (w *binaryWriter) bytes(b []byte) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// number.
+func (w *binaryWriter) number(x *big.Int) {
+	switch {
+	case x == nil:
+		w.buf = append(w.buf, binaryNil)
+		return
+	case x.Sign() < 0:
+		w.buf = append(w.buf, binaryNegative)
+	default:
+		w.buf = append(w.buf, binaryPositive)
+	}
+	w.bytes(x.Bytes())
+}
+
+// date.
+func (w *binaryWriter) date(t time.Time) {
+	b, _ := t.MarshalBinary()
+	w.bytes(b)
+}
+
+// string.
+func (w *binaryWriter) string(s string) {
+	w.bytes([]byte(s))
+}
+
+// scheme.
+func (w *binaryWriter) scheme(scheme *SchemeParams) {
+	w.number(scheme.Q)
+	w.number(scheme.P)
+	w.number(scheme.G)
+}
+
+// key.
+func (w *binaryWriter) key(key *RsaKey) {
+	w.number(key.P)
+	w.number(key.Q)
+	w.number(key.N)
+	w.number(key.D)
+	w.number(key.E)
+}
+
+// bankProfile.
+func (w *binaryWriter) bankProfile(bank *BankProfile) {
+	w.scheme(&bank.Scheme)
+	w.number(bank.Pub)
+	w.number(bank.N)
+	w.number(bank.E)
+	w.string(bank.Currency)
+}
+
+// clientProfile.
+func (w *binaryWriter) clientProfile(client *ClientProfile) {
+	w.number(client.PrivStamp)
+	w.number(client.IdentityHash)
+	w.number(client.TradeId)
+	w.number(client.Pub)
+	w.number(client.N)
+	w.number(client.E)
+}
+
+// coinProfile.
+func (w *binaryWriter) coinProfile(coin *CoinProfile) {
+	w.number(coin.Pub)
+	w.number(coin.First)
+	w.number(coin.A)
+	w.number(coin.R)
+	w.number(coin.A2)
+	w.date(coin.Expiration)
+	w.number(coin.Second)
+	w.number(coin.Msg)
+	w.int(coin.Version)
+	w.string(coin.Currency)
+	w.int(int(coin.Value))
+}
+
+// binaryReader reads encoded fields from data. The first error is kept, and every later read is a no-op.
+type binaryReader struct {
+	data    []byte
+	version byte
+	err     error
+}
+
+// newBinaryReader returns a binaryReader past data's version byte.
+func newBinaryReader(data []byte) *binaryReader {
+	if len(data) == 0 {
+		return &binaryReader{err: ErrEncoding}
+	}
+	if data[0] < 1 || data[0] > binaryVersion {
+		return &binaryReader{err: ErrEncodingVersion}
+	}
+	return &binaryReader{data: data[1:], version: data[0]}
+}
+
+// close returns the first error, or ErrEncoding if some data wasn't read.
+func (r *binaryReader) close() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = ErrEncoding
+	}
+	return r.err
+}
+
+// int.
+func (r *binaryReader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(r.data)
+	if size <= 0 {
+		r.err = ErrEncoding
+		return 0
+	}
+	r.data = r.data[size:]
+	return int(n)
+}
+
+// bytes.
+func (r *binaryReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+	length, size := binary.Uvarint(r.data)
+	if size <= 0 || uint64(len(r.data)-size) < length {
+		r.err = ErrEncoding
+		return nil
+	}
+	b := r.data[size : size+int(length)]
+	r.data = r.data[size+int(length):]
+	return b
+}
+
+// number.
+func (r *binaryReader) number() *big.Int {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) == 0 {
+		r.err = ErrEncoding
+		return nil
+	}
+	tag := r.data[0]
+	r.data = r.data[1:]
+	switch tag {
+	case binaryNil:
+		return nil
+	case binaryPositive:
+		return new(big.Int).SetBytes(r.bytes())
+	case binaryNegative:
+		return new(big.Int).Neg(new(big.Int).SetBytes(r.bytes()))
+	default:
+		r.err = ErrEncoding
+		return nil
+	}
+}
+
+// date.
+func (r *binaryReader) date() time.Time {
+	var t time.Time
+	b := r.bytes()
+	if r.err != nil {
+		return t
+	}
+	if err := t.UnmarshalBinary(b); err != nil {
+		r.err = ErrEncoding
+	}
+	return t
+}
+
+// currency returns an empty currency for encodings before binaryCurrencyVersion.
+func (r *binaryReader) currency() string {
+	if r.version < binaryCurrencyVersion {
+		return ""
+	}
+	return string(r.bytes())
+}
+
+// value returns a zero value for encodings before binaryValueVersion.
+func (r *binaryReader) value() int64 {
+	if r.version < binaryValueVersion {
+		return 0
+	}
+	return int64(r.int())
+}
+
+// scheme.
+func (r *binaryReader) scheme() SchemeParams {
+	return SchemeParams{Q: r.number(), P: r.number(), G: r.number()}
+}
+
+// key.
+func (r *binaryReader) key() RsaKey {
+	return RsaKey{P: r.number(), Q: r.number(), N: r.number(), D: r.number(), E: r.number()}
+}
+
+// bankProfile.
+func (r *binaryReader) bankProfile() BankProfile {
+	return BankProfile{Scheme: r.scheme(), Pub: r.number(), N: r.number(), E: r.number(), Currency: r.currency()}
+}
+
+// clientProfile.
+func (r *binaryReader) clientProfile() ClientProfile {
+	return ClientProfile{
+		PrivStamp:    r.number(),
+		IdentityHash: r.number(),
+		TradeId:      r.number(),
+		Pub:          r.number(),
+		N:            r.number(),
+		E:            r.number(),
+	}
+}
+
+// coinProfile.
+func (r *binaryReader) coinProfile() CoinProfile {
+	return CoinProfile{
+		Pub:        r.number(),
+		First:      r.number(),
+		A:          r.number(),
+		R:          r.number(),
+		A2:         r.number(),
+		Expiration: r.date(),
+		Second:     r.number(),
+		Msg:        r.number(),
+		Version:    r.int(),
+		Currency:   r.currency(),
+		Value:      r.value(),
+	}
+}
+
+// MarshalBinary.
+func (bank Bank) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.scheme(&bank.Scheme)
+	w.key(&bank.Key)
+	w.number(bank.Priv)
+	w.number(bank.Pub)
+	w.string(bank.Currency)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (bank *Bank) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := Bank{
+		Scheme:   r.scheme(),
+		Key:      r.key(),
+		Priv:     r.number(),
+		Pub:      r.number(),
+		Currency: r.currency(),
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	*bank = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (client Client) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.bankProfile(&client.Bank)
+	w.key(&client.Key)
+	w.number(client.TradeId)
+	w.number(client.Priv)
+	w.number(client.Pub)
+	w.number(client.Credential)
+	w.number(client.Contract)
+	w.date(client.Expiration)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (client *Client) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := Client{
+		Bank:       r.bankProfile(),
+		Key:        r.key(),
+		TradeId:    r.number(),
+		Priv:       r.number(),
+		Pub:        r.number(),
+		Credential: r.number(),
+		Contract:   r.number(),
+		Expiration: r.date(),
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	*client = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (client ClientProfile) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.clientProfile(&client)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (client *ClientProfile) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := r.clientProfile()
+	if err := r.close(); err != nil {
+		return err
+	}
+	*client = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (auth DepositAuthorization) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.number(auth.Collector)
+	w.clientProfile(&auth.Beneficiary)
+	w.number(auth.Signature)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (auth *DepositAuthorization) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := DepositAuthorization{
+		Collector:   r.number(),
+		Beneficiary: r.clientProfile(),
+		Signature:   r.number(),
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	*auth = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (bundle SetupBundle) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.string(bundle.Name)
+	w.string(bundle.Cert)
+	w.string(bundle.Params)
+	w.number(bundle.R)
+	w.number(bundle.S)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (bundle *SetupBundle) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := SetupBundle{
+		Name:   string(r.bytes()),
+		Cert:   string(r.bytes()),
+		Params: string(r.bytes()),
+		R:      r.number(),
+		S:      r.number(),
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	*bundle = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (client ClientInfo) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.clientProfile(&client.Profile)
+	w.number(client.K)
+	w.number(client.S)
+	w.number(client.Credential)
+	w.number(client.Contract)
+	w.date(client.Expiration)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (client *ClientInfo) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := ClientInfo{
+		Profile:    r.clientProfile(),
+		K:          r.number(),
+		S:          r.number(),
+		Credential: r.number(),
+		Contract:   r.number(),
+		Expiration: r.date(),
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	*client = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (coin Coin) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.number(coin.Random.E)
+	w.number(coin.Random.L)
+	w.number(coin.Random.LInv)
+	w.number(coin.Random.Beta1)
+	w.number(coin.Random.Beta1Inv)
+	w.number(coin.Random.Beta2)
+	w.number(coin.Random.Y)
+	w.number(coin.Random.YInv)
+	w.number(coin.Elgamal.Priv)
+	w.number(coin.Elgamal.Pub)
+	w.number(coin.Elgamal.First)
+	w.number(coin.Elgamal.Second)
+	w.number(coin.Elgamal.Msg)
+	w.number(coin.Params.A)
+	w.number(coin.Params.ALower)
+	w.number(coin.Params.C)
+	w.date(coin.Params.Expiration)
+	w.number(coin.Params.A1)
+	w.number(coin.Params.C1)
+	w.number(coin.Params.A2)
+	w.number(coin.Params.R)
+	w.int(coin.Params.Version)
+	w.string(coin.Params.Currency)
+	w.int(int(coin.Params.Value))
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (coin *Coin) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := Coin{
+		Random: CoinRandom{
+			E:        r.number(),
+			L:        r.number(),
+			LInv:     r.number(),
+			Beta1:    r.number(),
+			Beta1Inv: r.number(),
+			Beta2:    r.number(),
+			Y:        r.number(),
+			YInv:     r.number(),
+		},
+		Elgamal: CoinElgamal{
+			Priv:   r.number(),
+			Pub:    r.number(),
+			First:  r.number(),
+			Second: r.number(),
+			Msg:    r.number(),
+		},
+		Params: CoinParams{
+			A:          r.number(),
+			ALower:     r.number(),
+			C:          r.number(),
+			Expiration: r.date(),
+			A1:         r.number(),
+			C1:         r.number(),
+			A2:         r.number(),
+			R:          r.number(),
+			Version:    r.int(),
+			Currency:   r.currency(),
+			Value:      r.value(),
+		},
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	*coin = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (coin CoinProfile) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.coinProfile(&coin)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (coin *CoinProfile) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := r.coinProfile()
+	if err := r.close(); err != nil {
+		return err
+	}
+	*coin = decoded
+	return nil
+}
+
+// MarshalBinary.
+func (dispute Dispute) MarshalBinary() ([]byte, error) {
+	w := newBinaryWriter()
+	w.int(dispute.Kind)
+	w.bankProfile(&dispute.Mint)
+	w.int(len(dispute.Coins))
+	for i := range dispute.Coins {
+		w.coinProfile(&dispute.Coins[i])
+	}
+	w.date(dispute.Date)
+	return w.buf, nil
+}
+
+// UnmarshalBinary.
+func (dispute *Dispute) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data)
+	decoded := Dispute{
+		Kind: r.int(),
+		Mint: r.bankProfile(),
+	}
+	count := r.int()
+	if count < 0 || count > len(r.data) { // Every coin takes some bytes.
+		return ErrEncoding
+	}
+	for i := 0; i < count; i++ {
+		decoded.Coins = append(decoded.Coins, r.coinProfile())
+	}
+	decoded.Date = r.date()
+	if err := r.close(); err != nil {
+		return err
+	}
+	*dispute = decoded
+	return nil
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"io"
+	"math/big"
+)
+
+//
+// WITHDRAWAL BINDING
+//
+
+// 1. The Bank sends a fresh nonce along with its profile at each withdrawal.
+// 2. The Client signs the nonce, its public identity and its coin request (a, c, the amount and the idempotency
+//		token) with its RSA key, the one of its ClientProfile.
+// 3. The Bank verifies the signature before debiting the account: a request relayed under another account's profile,
+//		or replayed from another session, is refused.
+
+// withdrawalNonceSize is the size of a withdrawal nonce, in bytes.
+const withdrawalNonceSize = 16
+
+// NewWithdrawalNonce returns a fresh nonce for a withdrawal session. (Or a closure, transfer or renewal session, see
+// SignClosure, SignTransfer and SignRenewal)
+func NewWithdrawalNonce(random io.Reader) ([]byte, error) {
+	nonce := make([]byte, withdrawalNonceSize)
+	if _, err := io.ReadFull(source(random), nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// withdrawalDigest computes the digest of nonce, client and req, the request's signed message.
+func withdrawalDigest(nonce []byte, client *ClientProfile, req *WithdrawalRequest) *big.Int {
+	return newTranscript("ziba/withdrawal/request").
+		field(nonce).
+		number(client.Digest()).
+		number(req.ALower).
+		number(req.C).
+		field([]byte(NormalizeCurrency(req.Currency))).
+		number(big.NewInt(NormalizeValue(req.Value))).
+		field([]byte(req.Token)).
+		digest()
+}
+
+// SignWithdrawal signs req for the withdrawal session of nonce, and returns it.
+func (client *Client) SignWithdrawal(nonce []byte, req *WithdrawalRequest) *WithdrawalRequest {
+	req.Signature = client.Key.sign(withdrawalDigest(nonce, client.Profile(), req))
+	return req
+}
+
+// Verify verifies req was signed by client for the withdrawal session of nonce.
+func (req *WithdrawalRequest) Verify(nonce []byte, client *ClientProfile) error {
+	if len(nonce) != withdrawalNonceSize || req.Signature == nil || client.N == nil || client.E == nil {
+		return ErrWithdrawalSigned
+	}
+	if req.Signature.Sign() <= 0 || req.Signature.Cmp(client.N) >= 0 {
+		return ErrWithdrawalSigned
+	}
+
+	// Check s^e = H(nonce, client, request) mod n.
+	digest := withdrawalDigest(nonce, client, req)
+	signed := new(big.Int).Exp(req.Signature, client.E, client.N)
+	if !equal(signed, new(big.Int).Mod(digest, client.N), client.N) {
+		return ErrWithdrawalSigned
+	}
+	return nil
+}
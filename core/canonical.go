@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"time"
+)
+
+//
+// CANONICAL SERIALIZATION
+//
+// Every hashed or signed input is serialized as a domain tag followed by its fields, each prefixed by its length:
+//
+//	len(tag) (4) | tag | len(field_1) (4) | field_1 | ... | len(field_n) (4) | field_n
+//
+// Lengths are big-endian, numbers are big-endian magnitudes (nil is empty) and dates are time.Time.MarshalBinary of
+// the date in UTC. Unlike a plain concatenation, different splits of the same bytes into fields can't collide.
+//
+// Coins before CoinVersionCanonical, and identity hashes computed before, were hashed over plain concatenations. They
+// are still verified with the legacy serialization.
+//
+
+// transcript is a canonical serialization being built.
+type transcript struct {
+	buffer bytes.Buffer
+}
+
+// newTranscript returns a transcript starting with tag.
+func newTranscript(tag string) *transcript {
+	t := new(transcript)
+	t.field([]byte(tag))
+	return t
+}
+
+// field appends b.
+func (t *transcript) field(b []byte) *transcript {
+	binary.Write(&t.buffer, binary.BigEndian, uint32(len(b)))
+	t.buffer.Write(b)
+	return t
+}
+
+// number appends x.
+func (t *transcript) number(x *big.Int) *transcript {
+	if x == nil {
+		return t.field(nil)
+	}
+	return t.field(x.Bytes())
+}
+
+// date appends x.
+func (t *transcript) date(x time.Time) *transcript {
+	b, _ := x.UTC().MarshalBinary()
+	return t.field(b)
+}
+
+// sum returns the SHA-256 digest of the transcript.
+func (t *transcript) sum() [sha256.Size]byte {
+	return sha256.Sum256(t.buffer.Bytes())
+}
+
+// digest returns the SHA-256 digest of the transcript as a number.
+func (t *transcript) digest() *big.Int {
+	sum := t.sum()
+	return new(big.Int).SetBytes(sum[:])
+}
+
+// Fingerprint returns the hexadecimal digest of scheme's group (q, p, alpha), identifying it across sessions.
+func (scheme *SchemeParams) Fingerprint() string {
+	sum := newTranscript("ziba/scheme").number(scheme.Q).number(scheme.P).number(scheme.G).sum()
+	return hex.EncodeToString(sum[:])
+}
+
+// concatenationDigest returns the SHA-256 digest of the plain concatenation of xs, the legacy serialization.
+func concatenationDigest(xs ...[]byte) *big.Int {
+	var buffer bytes.Buffer
+	for _, x := range xs {
+		buffer.Write(x)
+	}
+	hashBytes := sha256.Sum256(buffer.Bytes())
+	return new(big.Int).SetBytes(hashBytes[:])
+}
+
+// identityHash computes a client's identity hash from its public identity number and private stamp number.
+func identityHash(pub, privStamp *big.Int) *big.Int {
+	return newTranscript("ziba/client/identity").number(pub).number(privStamp).digest()
+}
+
+// legacyIdentityHash computes the identity hash of clients created before the canonical serialization.
+func legacyIdentityHash(pub, privStamp *big.Int) *big.Int {
+	return concatenationDigest(pub.Bytes(), privStamp.Bytes())
+}
+
+// coinDigest computes the digest of some coin parameters (u, alpha and A) signed into c, for the given coin version.
+func coinDigest(version int, first, pub, A *big.Int) *big.Int {
+	if version < CoinVersionCanonical {
+		return concatenationDigest(first.Bytes(), pub.Bytes(), A.Bytes())
+	}
+	return newTranscript("ziba/coin/c").number(first).number(pub).number(A).digest()
+}
+
+// stampDigest computes the digest of some coin parameters and the transaction parameters, the Elgamal's message.
+func stampDigest(coin *CoinProfile, tradeId *big.Int, t time.Time) *big.Int {
+	if coin.Version < CoinVersionCanonical {
+		tBytes, _ := t.MarshalBinary()
+		return concatenationDigest(coin.Pub.Bytes(), coin.First.Bytes(), tradeId.Bytes(), tBytes)
+	}
+	return newTranscript("ziba/payment").number(coin.Pub).number(coin.First).number(tradeId).date(t).digest()
+}
+
+// escrowMsgDigest computes the digest of some coin parameters and the escrow conditions.
+func escrowMsgDigest(coin *CoinProfile, escrow *Escrow) *big.Int {
+	if coin.Version < CoinVersionCanonical {
+		dateBytes, _ := escrow.Date.UTC().MarshalBinary()
+		timeoutBytes, _ := escrow.Timeout.UTC().MarshalBinary()
+		return concatenationDigest(
+			coin.Pub.Bytes(),
+			coin.First.Bytes(),
+			escrow.Payee.Bytes(),
+			dateBytes,
+			escrow.Release.Bytes(),
+			escrow.Refund.Bytes(),
+			timeoutBytes,
+		)
+	}
+	return newTranscript("ziba/payment/escrow").
+		number(coin.Pub).
+		number(coin.First).
+		number(escrow.Payee).
+		date(escrow.Date).
+		number(escrow.Release).
+		number(escrow.Refund).
+		date(escrow.Timeout).
+		digest()
+}
@@ -0,0 +1,57 @@
+package core
+
+import "math/big"
+
+//
+// ACCOUNT CLOSURE
+//
+
+// 1. The Client closes its account by signing a fresh nonce sent by the Bank (see NewWithdrawalNonce), its public
+//		identity and the coins it surrenders, with the RSA key of its ClientProfile: only the owner of the account
+//		closes it, and a request can't be replayed.
+// 2. The Bank credits the surrendered coins to the account, then pays its balances out of band and refuses its
+//		further operations.
+
+// closureDigest computes the digest of nonce, client and req, the request's signed message.
+func closureDigest(nonce []byte, client *ClientProfile, req *ClosureRequest) *big.Int {
+	t := newTranscript("ziba/closure/request").
+		field(nonce).
+		number(client.Digest()).
+		number(big.NewInt(int64(len(req.Coins))))
+	for i := range req.Coins {
+		coin := &req.Coins[i]
+		t.number(coin.Pub).
+			number(coin.First).
+			number(coin.A).
+			number(coin.R).
+			number(coin.A2).
+			date(coin.Expiration).
+			field([]byte(NormalizeCurrency(coin.Currency))).
+			number(big.NewInt(NormalizeValue(coin.Value)))
+	}
+	return t.digest()
+}
+
+// SignClosure signs req for the closure session of nonce, and returns it.
+func (client *Client) SignClosure(nonce []byte, req *ClosureRequest) *ClosureRequest {
+	req.Signature = client.Key.sign(closureDigest(nonce, client.Profile(), req))
+	return req
+}
+
+// Verify verifies req was signed by client for the closure session of nonce.
+func (req *ClosureRequest) Verify(nonce []byte, client *ClientProfile) error {
+	if len(nonce) != withdrawalNonceSize || req.Signature == nil || client.N == nil || client.E == nil {
+		return ErrClosureSigned
+	}
+	if req.Signature.Sign() <= 0 || req.Signature.Cmp(client.N) >= 0 {
+		return ErrClosureSigned
+	}
+
+	// Check s^e = H(nonce, client, request) mod n.
+	digest := closureDigest(nonce, client, req)
+	signed := new(big.Int).Exp(req.Signature, client.E, client.N)
+	if !equal(signed, new(big.Int).Mod(digest, client.N), client.N) {
+		return ErrClosureSigned
+	}
+	return nil
+}
@@ -3,30 +3,29 @@ package core
 import (
 	"bytes"
 	"crypto/sha256"
-	"embed"
+	_ "embed"
 	"encoding/json"
 	"io"
 	"log"
-	"os"
+	"math/big"
 )
 
+// The package doesn't touch the file system, but for the helpers of files.go: it builds for js/wasm, where a browser
+// wallet runs it and those helpers fail, there being no file system. (See the wasm directory)
+
+// paramsJSON is the contents of params.json, compiled in.
+//
 //go:embed params.json
-var files embed.FS
+var paramsJSON []byte
 
 // Params.
 var Params *SchemeParams
 
 // init.
 func init() {
-	// Open params file.
-	paramsFile, err := files.Open("params.json")
-	if err != nil {
-		log.Fatalf("failed to load params.json: %v", err)
-	}
-
 	// Load into variable.
 	scheme := new(SchemeParams)
-	err = LoadFromFile(scheme, paramsFile)
+	err := LoadFromFile(scheme, io.NopCloser(bytes.NewReader(paramsJSON)))
 	if err != nil {
 		log.Fatalf("failed to load SchemeParams from file: %v", err)
 	}
@@ -34,22 +33,35 @@ func init() {
 	Params = scheme
 }
 
-// Hash computes the digest of the contents of coin and returns a truncated result.
+// Hash computes the digest of the contents of coin and returns a truncated result. Coins before CoinVersionCanonical
+// keep the legacy serialization, since they are stored under this hash.
 func (coin *CoinProfile) Hash() uint32 {
-	// Date to bytes.
-	expirationBytes, _ := coin.Expiration.MarshalBinary()
-
-	// Helper byte buffer.
-	var buffer bytes.Buffer
-	buffer.Write(coin.Pub.Bytes())
-	buffer.Write(coin.First.Bytes())
-	buffer.Write(coin.A.Bytes())
-	buffer.Write(coin.R.Bytes())
-	buffer.Write(coin.A2.Bytes())
-	buffer.Write(expirationBytes)
-
-	// Actually compute the digest from the buffer.
-	hashBytes := sha256.Sum256(buffer.Bytes())
+	var hashBytes [sha256.Size]byte
+	if coin.Version < CoinVersionCanonical {
+		// Date to bytes.
+		expirationBytes, _ := coin.Expiration.MarshalBinary()
+
+		// Helper byte buffer.
+		var buffer bytes.Buffer
+		buffer.Write(coin.Pub.Bytes())
+		buffer.Write(coin.First.Bytes())
+		buffer.Write(coin.A.Bytes())
+		buffer.Write(coin.R.Bytes())
+		buffer.Write(coin.A2.Bytes())
+		buffer.Write(expirationBytes)
+
+		// Actually compute the digest from the buffer.
+		hashBytes = sha256.Sum256(buffer.Bytes())
+	} else {
+		hashBytes = newTranscript("ziba/coin/id").
+			number(coin.Pub).
+			number(coin.First).
+			number(coin.A).
+			number(coin.R).
+			number(coin.A2).
+			date(coin.Expiration).
+			sum()
+	}
 
 	// Truncate the result to fit into an int64.
 	hash := int64(hashBytes[0]) | int64(hashBytes[1])<<8 | int64(hashBytes[2])<<16 |
@@ -59,7 +71,42 @@ func (coin *CoinProfile) Hash() uint32 {
 	return uint32(hash)
 }
 
-// Hash computes the digest of the contents of client and returns a truncated result.
+// Digest computes the digest of the contents of client.
+func (client *ClientProfile) Digest() *big.Int {
+	return newTranscript("ziba/client/profile").
+		number(client.PrivStamp).
+		number(client.IdentityHash).
+		number(client.TradeId).
+		number(client.Pub).
+		number(client.N).
+		number(client.E).
+		digest()
+}
+
+// Equal reports whether client and other are the same profile. Profiles sharing a Hash aren't necessarily equal.
+func (client *ClientProfile) Equal(other *ClientProfile) bool {
+	pairs := [][2]*big.Int{
+		{client.PrivStamp, other.PrivStamp},
+		{client.IdentityHash, other.IdentityHash},
+		{client.TradeId, other.TradeId},
+		{client.Pub, other.Pub},
+		{client.N, other.N},
+		{client.E, other.E},
+	}
+	for _, pair := range pairs {
+		if pair[0] == nil || pair[1] == nil {
+			if pair[0] != pair[1] {
+				return false
+			}
+		} else if pair[0].Cmp(pair[1]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash computes the digest of the contents of client and returns a truncated result. It keeps the legacy
+// serialization, since banks store every account under this hash.
 func (client *ClientProfile) Hash() uint32 {
 	// Helper byte buffer.
 	var buffer bytes.Buffer
@@ -81,20 +128,6 @@ func (client *ClientProfile) Hash() uint32 {
 	return uint32(hash)
 }
 
-// Save to .json.
-func SaveToFile(data json.Marshaler, filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Printf("failed to create file")
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
-}
-
 // Load from .json.
 func LoadFromFile(target json.Unmarshaler, file io.ReadCloser) error {
 	// file, err := os.Open(filename)
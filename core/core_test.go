@@ -1,7 +1,22 @@
 package core_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
 	"testing"
+	"time"
 	"ziba/core"
 )
 
@@ -12,7 +27,7 @@ func TestCore(t *testing.T) {
 	// SETUP
 
 	// Create bank.
-	bank := new(core.Bank).New(scheme)
+	bank := new(core.Bank).New(nil, scheme)
 	bankProfile := bank.Profile()
 	t.Log(bank)
 	t.Log(bankProfile)
@@ -20,13 +35,13 @@ func TestCore(t *testing.T) {
 	// ACCOUNT GENERATION
 
 	// Create client.
-	client := new(core.Client).New(bankProfile)
+	client := new(core.Client).New(nil, bankProfile)
 	clientProfile := client.Profile()
 	t.Log(client)
 	t.Log(clientProfile)
 
 	// Create client account.
-	clientInfo, err := bank.NewClient(clientProfile)
+	clientInfo, err := bank.NewClient(nil, clientProfile)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,7 +54,7 @@ func TestCore(t *testing.T) {
 	// WITHDRAWAL
 
 	// Create request.
-	coin := client.NewCoinRequest()
+	coin := client.NewCoinRequest(nil)
 	t.Log(coin)
 
 	// Create response.
@@ -73,3 +88,2011 @@ func TestCore(t *testing.T) {
 	t.Log("Valid Elgamal's signature")
 
 }
+
+func TestEscrow(t *testing.T) {
+	// Get scheme parameters.
+	scheme := core.Params
+
+	// Create bank, spender and merchant.
+	bank := new(core.Bank).New(nil, scheme)
+	bankProfile := bank.Profile()
+
+	spender := new(core.Client).New(nil, bankProfile)
+	spenderInfo, err := bank.NewClient(nil, spender.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spender.SetCredentials(spenderInfo.Credential, spenderInfo.Contract)
+
+	merchant := new(core.Client).New(nil, bankProfile)
+	merchantProfile := merchant.Profile()
+
+	// Withdraw coin.
+	coin := spender.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(spenderInfo, coin.Params.ALower, coin.Params.C)
+	spender.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+
+	// ESCROWED PAYMENT
+
+	timeout := time.Now().Add(time.Hour)
+	escrow, release, refund := core.NewEscrow(nil, timeout)
+	if escrow == nil {
+		t.Fatal("failed to create escrow")
+	}
+
+	msg := coinProfile.StampEscrow(bankProfile, merchantProfile, escrow)
+	if !core.IsEscrowMsg(msg) {
+		t.Fatal("escrow message is not tagged")
+	}
+	if msg.Cmp(escrow.Msg(coinProfile)) != 0 {
+		t.Fatal("escrow message mismatch")
+	}
+	t.Log(escrow)
+
+	second := spender.SignCoin(coin, msg)
+	if valid := coinProfile.VerifyElgamal(bankProfile, second); !valid {
+		t.Fatal("invalid Elgamal's signature")
+	}
+	if valid := coinProfile.VerifyEscrow(bankProfile, escrow); !valid {
+		t.Fatal("invalid escrow")
+	}
+
+	// Release before timeout, refund after timeout.
+	if err := escrow.VerifyRelease(release, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := escrow.VerifyRelease(refund, time.Now()); err != core.ErrEscrowRelease {
+		t.Fatalf("expected %v, got %v", core.ErrEscrowRelease, err)
+	}
+	if err := escrow.VerifyRelease(release, timeout); err != core.ErrEscrowExpired {
+		t.Fatalf("expected %v, got %v", core.ErrEscrowExpired, err)
+	}
+	if err := escrow.VerifyRefund(refund, time.Now()); err != core.ErrEscrowPending {
+		t.Fatalf("expected %v, got %v", core.ErrEscrowPending, err)
+	}
+	if err := escrow.VerifyRefund(refund, timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// Altered conditions no longer match the signed message.
+	escrow.Timeout = timeout.Add(time.Hour)
+	if valid := coinProfile.VerifyEscrow(bankProfile, escrow); valid {
+		t.Fatal("altered escrow verified")
+	}
+
+	// Plain stamps are never tagged.
+	if core.IsEscrowMsg(coinProfile.Stamp(bankProfile, merchantProfile)) {
+		t.Fatal("plain message is tagged")
+	}
+}
+
+func TestMemo(t *testing.T) {
+	// Create bank, spender and merchant.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+
+	spender := new(core.Client).New(nil, bankProfile)
+	spenderInfo, err := bank.NewClient(nil, spender.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spender.SetCredentials(spenderInfo.Credential, spenderInfo.Contract)
+
+	merchant := new(core.Client).New(nil, bankProfile)
+	merchantProfile := merchant.Profile()
+
+	// Withdraw coin.
+	coin := spender.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(spenderInfo, coin.Params.ALower, coin.Params.C)
+	spender.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+
+	// PAYMENT WITH MEMO
+
+	if _, err := core.NewMemo(strings.Repeat("x", core.MaxMemoLength+1)); err != core.ErrMemoLength {
+		t.Fatalf("expected %v, got %v", core.ErrMemoLength, err)
+	}
+	memo, err := core.NewMemo("order #1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := coinProfile.StampMemo(bankProfile, merchantProfile, memo)
+	if core.IsEscrowMsg(msg) {
+		t.Fatal("memo message is tagged as escrowed")
+	}
+	second := spender.SignCoin(coin, msg)
+	if valid := coinProfile.VerifyElgamal(bankProfile, second); !valid {
+		t.Fatal("invalid Elgamal's signature")
+	}
+
+	// DEPOSIT
+
+	if valid := coinProfile.VerifyMemo(bankProfile, memo); !valid {
+		t.Fatal("invalid memo")
+	}
+
+	// Altered memos no longer match the signed message.
+	altered := *memo
+	altered.Text = "order #1235"
+	if valid := coinProfile.VerifyMemo(bankProfile, &altered); valid {
+		t.Fatal("altered memo verified")
+	}
+	if valid := coinProfile.VerifyMemo(bankProfile, nil); valid {
+		t.Fatal("missing memo verified")
+	}
+
+	// The memo binds the merchant's account, a thief's account doesn't match it.
+	if valid := memo.VerifyAccount(merchantProfile); !valid {
+		t.Fatal("memo not bound to the merchant's account")
+	}
+	thief := new(core.Client).New(nil, bankProfile)
+	if valid := memo.VerifyAccount(thief.Profile()); valid {
+		t.Fatal("memo bound to the thief's account")
+	}
+	rebound := *memo
+	rebound.Account = thief.Profile().Digest()
+	if valid := coinProfile.VerifyMemo(bankProfile, &rebound); valid {
+		t.Fatal("rebound memo verified")
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	// Create bank, its mint and client.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	if bankProfile.Currency != core.DefaultCurrency {
+		t.Fatalf("expected %s, got %s", core.DefaultCurrency, bankProfile.Currency)
+	}
+
+	if _, err := bank.NewMint(nil, "eur"); err != core.ErrCurrency {
+		t.Fatalf("expected %v, got %v", core.ErrCurrency, err)
+	}
+	if _, err := bank.NewMint(nil, core.DefaultCurrency); err != core.ErrMintMismatch {
+		t.Fatalf("expected %v, got %v", core.ErrMintMismatch, err)
+	}
+	mint, err := bank.NewMint(nil, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mintProfile := mint.Profile()
+
+	client := new(core.Client).New(nil, bankProfile)
+	clientInfo, err := bank.NewClient(nil, client.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+
+	// Mints of other banks are rejected.
+	other := new(core.Bank).New(nil, core.Params)
+	if _, err := client.Mint(other.Profile()); err != core.ErrMintMismatch {
+		t.Fatalf("expected %v, got %v", core.ErrMintMismatch, err)
+	}
+
+	// Withdraw coin in EUR.
+	minted, err := client.Mint(mintProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coin := minted.NewCoinRequest(nil)
+	if coin.Params.Currency != "EUR" {
+		t.Fatalf("expected EUR, got %s", coin.Params.Currency)
+	}
+	if err := mintProfile.ValidateCoinRequest(coin.Params.ALower, coin.Params.C); err != nil {
+		t.Fatal(err)
+	}
+	Expiration, A1, C1 := mint.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	minted.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+
+	// The coin verifies with the mint of its currency only.
+	if err := mintProfile.ValidateCoin(coinProfile); err != nil {
+		t.Fatal(err)
+	}
+	if valid := coinProfile.VerifyProperties(mintProfile); !valid {
+		t.Fatal("invalid coin")
+	}
+	var validationErr *core.ValidationError
+	if err := bankProfile.ValidateCoin(coinProfile); !errors.As(err, &validationErr) || validationErr.Err != core.ErrCurrency {
+		t.Fatalf("expected %v, got %v", core.ErrCurrency, err)
+	}
+	relabeled := *coinProfile
+	relabeled.Currency = core.DefaultCurrency
+	if valid := relabeled.VerifyProperties(bankProfile); valid {
+		t.Fatal("relabeled coin verified")
+	}
+
+	// Exchange rates.
+	if _, err := core.NewRate("EUR", "ZIB", 0, 1); err != core.ErrRate {
+		t.Fatalf("expected %v, got %v", core.ErrRate, err)
+	}
+	rate, err := core.NewRate("EUR", "", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := rate.Convert(4); err != nil || value != 6 {
+		t.Fatalf("expected 6, got %d (%v)", value, err)
+	}
+	if _, err := rate.Convert(3); err != core.ErrConversion {
+		t.Fatalf("expected %v, got %v", core.ErrConversion, err)
+	}
+}
+
+func TestDivisible(t *testing.T) {
+	// Create bank, spender and merchant.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+
+	spender := new(core.Client).New(nil, bankProfile)
+	spenderInfo, err := bank.NewClient(nil, spender.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spender.SetCredentials(spenderInfo.Credential, spenderInfo.Contract)
+
+	merchant := new(core.Client).New(nil, bankProfile)
+	merchantProfile := merchant.Profile()
+
+	// Withdraw coin of value 5.
+	if err := core.ValidateValue(0); err != core.ErrCoinValue {
+		t.Fatalf("expected %v, got %v", core.ErrCoinValue, err)
+	}
+	coin := spender.NewCoinRequest(nil).SetValue(5)
+	Expiration, A1, C1 := bank.NewValuedCoinResponse(spenderInfo, coin.Params.ALower, coin.Params.C, 5)
+	spender.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+	if valid := coinProfile.VerifyProperties(bankProfile); !valid {
+		t.Fatal("invalid coin")
+	}
+
+	// The value is signed into the coin.
+	relabeled := *coinProfile
+	relabeled.Value = 50
+	if valid := relabeled.VerifyProperties(bankProfile); valid {
+		t.Fatal("relabeled coin verified")
+	}
+
+	// PARTIAL PAYMENT
+
+	remainder := spender.NewCoinRequest(nil).SetValue(2)
+	if err := (&core.Change{Amount: 5}).Verify(coinProfile); err != core.ErrChangeAmount {
+		t.Fatalf("expected %v, got %v", core.ErrChangeAmount, err)
+	}
+	change, claim := core.NewChange(nil, 3, remainder)
+	if err := change.Verify(coinProfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankProfile.ValidateChange(change); err != nil {
+		t.Fatal(err)
+	}
+
+	memo, err := core.NewMemo("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memo.Change = change
+	msg := coinProfile.StampMemo(bankProfile, merchantProfile, memo)
+	second := spender.SignCoin(coin, msg)
+	if valid := coinProfile.VerifyElgamal(bankProfile, second); !valid {
+		t.Fatal("invalid Elgamal's signature")
+	}
+
+	// DEPOSIT
+
+	if valid := coinProfile.VerifyMemo(bankProfile, memo); !valid {
+		t.Fatal("invalid memo")
+	}
+	if credit := coinProfile.Credit(memo); credit != 3 {
+		t.Fatalf("expected 3, got %d", credit)
+	}
+
+	// The change is bound to the signed message.
+	altered := *memo
+	altered.Change = &core.Change{Amount: 1, ALower: change.ALower, C: change.C, Claim: change.Claim}
+	if valid := coinProfile.VerifyMemo(bankProfile, &altered); valid {
+		t.Fatal("altered change verified")
+	}
+
+	// COLLECT CHANGE
+
+	if err := change.VerifyClaim(big.NewInt(1)); err != core.ErrChangeClaim {
+		t.Fatalf("expected %v, got %v", core.ErrChangeClaim, err)
+	}
+	if err := change.VerifyClaim(claim); err != nil {
+		t.Fatal(err)
+	}
+	value := change.Remainder(coinProfile)
+	if value != 2 {
+		t.Fatalf("expected 2, got %d", value)
+	}
+	Expiration, A1, C1 = bank.NewValuedCoinResponse(spenderInfo, change.ALower, change.C, value)
+	spender.FinishCoin(remainder, Expiration, A1, C1)
+	if valid := remainder.Profile().VerifyProperties(bankProfile); !valid {
+		t.Fatal("invalid remainder coin")
+	}
+}
+
+func TestDenominations(t *testing.T) {
+	if err := core.ValidateDenominations([]int64{5, 10}); err != core.ErrDenominations {
+		t.Fatalf("expected %v, got %v", core.ErrDenominations, err)
+	}
+	if err := core.ValidateDenominations([]int64{1, 5, 5}); err != core.ErrDenominations {
+		t.Fatalf("expected %v, got %v", core.ErrDenominations, err)
+	}
+
+	// Breakdown.
+	values := core.Breakdown(27, core.DefaultDenominations)
+	if len(values) != 5 || values[0] != 10 || values[1] != 10 || values[2] != 5 || values[3] != 1 || values[4] != 1 {
+		t.Fatalf("unexpected breakdown: %v", values)
+	}
+
+	// Cover. (Fewest coins, each coin used once)
+	cover := core.Cover([]int64{6, 5, 5, 1}, 10)
+	if len(cover) != 2 || cover[0] != 1 || cover[1] != 2 {
+		t.Fatalf("unexpected cover: %v", cover)
+	}
+	if cover := core.Cover([]int64{10, 10}, 5); cover != nil {
+		t.Fatalf("unexpected cover: %v", cover)
+	}
+	if cover := core.Cover([]int64{5}, 10); cover != nil {
+		t.Fatalf("unexpected cover: %v", cover)
+	}
+
+	// SplitPoint.
+	if split := core.SplitPoint(10, 3, core.DefaultDenominations); split != 3 {
+		t.Fatalf("expected 3, got %d", split)
+	}
+	if split := core.SplitPoint(10, 12, core.DefaultDenominations); split != 5 {
+		t.Fatalf("expected 5, got %d", split)
+	}
+	if split := core.SplitPoint(1, 12, core.DefaultDenominations); split != 0 {
+		t.Fatalf("expected 0, got %d", split)
+	}
+}
+
+func TestGift(t *testing.T) {
+	// Create bank and giver.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+
+	giver := new(core.Client).New(nil, bankProfile)
+	giverInfo, err := bank.NewClient(nil, giver.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	giver.SetCredentials(giverInfo.Credential, giverInfo.Contract)
+
+	// Withdraw coin.
+	coin := giver.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(giverInfo, coin.Params.ALower, coin.Params.C)
+	giver.FinishCoin(coin, Expiration, A1, C1)
+
+	// Export and import claim token.
+	token, err := core.NewGiftToken(coin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := core.ParseGiftToken(" " + token + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed.Profile().Hash() != coin.Profile().Hash() {
+		t.Fatal("claimed coin differs from gifted coin")
+	}
+	if err := claimed.VerifyGift(bankProfile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Coins of other banks and malformed tokens are rejected.
+	other := new(core.Bank).New(nil, core.Params)
+	if err := claimed.VerifyGift(other.Profile()); err == nil {
+		t.Fatal("coin of another bank verified")
+	}
+	if _, err := core.ParseGiftToken(token[len("ziba-gift:"):]); err != core.ErrGiftToken {
+		t.Fatalf("expected %v, got %v", core.ErrGiftToken, err)
+	}
+	if _, err := core.ParseGiftToken("ziba-gift:!"); err != core.ErrGiftToken {
+		t.Fatalf("expected %v, got %v", core.ErrGiftToken, err)
+	}
+}
+
+func TestBeneficiary(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+
+	collector := new(core.Client).New(nil, bankProfile)
+	beneficiary := new(core.Client).New(nil, bankProfile)
+
+	// Authorize, export and import token.
+	auth := beneficiary.AuthorizeDeposit(collector.Profile())
+	token, err := core.NewAuthorizationToken(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := core.ParseAuthorizationToken(" " + token + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Beneficiary.Hash() != beneficiary.Profile().Hash() {
+		t.Fatal("imported beneficiary differs from authorizing client")
+	}
+	if err := imported.Verify(collector.Profile()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other collectors, forged beneficiaries and malformed tokens are rejected.
+	other := new(core.Client).New(nil, bankProfile)
+	if err := imported.Verify(other.Profile()); err != core.ErrAuthorization {
+		t.Fatalf("expected %v, got %v", core.ErrAuthorization, err)
+	}
+	forged := *imported
+	forged.Beneficiary = *other.Profile()
+	if err := forged.Verify(collector.Profile()); err != core.ErrAuthorization {
+		t.Fatalf("expected %v, got %v", core.ErrAuthorization, err)
+	}
+	if _, err := core.ParseAuthorizationToken(token[len("ziba-authorization:"):]); err != core.ErrAuthorization {
+		t.Fatalf("expected %v, got %v", core.ErrAuthorization, err)
+	}
+}
+
+func TestWithdrawalBinding(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+
+	// Sign a request over the session's nonce.
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := client.SignWithdrawal(nonce, &core.WithdrawalRequest{ALower: big.NewInt(2), C: big.NewInt(3), Value: 5, Token: "token"})
+	if err := request.Verify(nonce, client.Profile()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests relayed under another account, replayed in another session or altered are rejected.
+	other := new(core.Client).New(nil, bankProfile)
+	if err := request.Verify(nonce, other.Profile()); err != core.ErrWithdrawalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrWithdrawalSigned, err)
+	}
+	replayed, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.Verify(replayed, client.Profile()); err != core.ErrWithdrawalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrWithdrawalSigned, err)
+	}
+	altered := *request
+	altered.Value = 50
+	if err := altered.Verify(nonce, client.Profile()); err != core.ErrWithdrawalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrWithdrawalSigned, err)
+	}
+
+	// Requests are checked against the account's stored profile: another key signing for it is told apart.
+	forged := other.SignWithdrawal(nonce, &core.WithdrawalRequest{ALower: big.NewInt(2), C: big.NewInt(3), Value: 5, Token: "token"})
+	if err := forged.Verify(nonce, client.Profile()); err != core.ErrWithdrawalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrWithdrawalSigned, err)
+	}
+	stored := *client.Profile()
+	if !stored.Equal(client.Profile()) || stored.Equal(other.Profile()) {
+		t.Fatal("Equal doesn't tell profiles apart")
+	}
+	stored.E = new(big.Int).Add(stored.E, big.NewInt(2))
+	if stored.Equal(client.Profile()) {
+		t.Fatal("Equal ignores the key")
+	}
+}
+
+func TestClosureBinding(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	coins := []core.CoinProfile{
+		{Pub: big.NewInt(2), First: big.NewInt(3), A: big.NewInt(5), R: big.NewInt(7), A2: big.NewInt(11), Value: 5},
+	}
+
+	// Sign a request over the session's nonce.
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := client.SignClosure(nonce, &core.ClosureRequest{Coins: coins})
+	if err := request.Verify(nonce, client.Profile()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests of another account, replayed in another session or surrendering other coins are rejected.
+	other := new(core.Client).New(nil, bankProfile)
+	if err := request.Verify(nonce, other.Profile()); err != core.ErrClosureSigned {
+		t.Fatalf("expected %v, got %v", core.ErrClosureSigned, err)
+	}
+	replayed, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.Verify(replayed, client.Profile()); err != core.ErrClosureSigned {
+		t.Fatalf("expected %v, got %v", core.ErrClosureSigned, err)
+	}
+	altered := core.ClosureRequest{Signature: request.Signature}
+	if err := altered.Verify(nonce, client.Profile()); err != core.ErrClosureSigned {
+		t.Fatalf("expected %v, got %v", core.ErrClosureSigned, err)
+	}
+
+	// Another key signing for the account is rejected against its stored profile.
+	forged := other.SignClosure(nonce, &core.ClosureRequest{Coins: coins})
+	if err := forged.Verify(nonce, client.Profile()); err != core.ErrClosureSigned {
+		t.Fatalf("expected %v, got %v", core.ErrClosureSigned, err)
+	}
+}
+
+func TestTransferBinding(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	payee := new(core.Client).New(nil, bankProfile)
+
+	// Sign a request over the session's nonce.
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := client.SignTransfer(nonce, &core.TransferRequest{Payee: payee.Profile().Hash(), Value: 5})
+	if err := request.Verify(nonce, client.Profile()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests of another account, replayed in another session, or altered are rejected.
+	if err := request.Verify(nonce, payee.Profile()); err != core.ErrTransferSigned {
+		t.Fatalf("expected %v, got %v", core.ErrTransferSigned, err)
+	}
+	replayed, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.Verify(replayed, client.Profile()); err != core.ErrTransferSigned {
+		t.Fatalf("expected %v, got %v", core.ErrTransferSigned, err)
+	}
+	altered := *request
+	altered.Value = 50
+	if err := altered.Verify(nonce, client.Profile()); err != core.ErrTransferSigned {
+		t.Fatalf("expected %v, got %v", core.ErrTransferSigned, err)
+	}
+	altered = *request
+	altered.Payee++
+	if err := altered.Verify(nonce, client.Profile()); err != core.ErrTransferSigned {
+		t.Fatalf("expected %v, got %v", core.ErrTransferSigned, err)
+	}
+
+	// Another key signing for the account is rejected against its stored profile.
+	forged := payee.SignTransfer(nonce, &core.TransferRequest{Payee: payee.Profile().Hash(), Value: 5})
+	if err := forged.Verify(nonce, client.Profile()); err != core.ErrTransferSigned {
+		t.Fatalf("expected %v, got %v", core.ErrTransferSigned, err)
+	}
+
+	// Non-positive amounts are rejected, even signed.
+	negative := client.SignTransfer(nonce, &core.TransferRequest{Payee: payee.Profile().Hash(), Value: -5})
+	if err := negative.Verify(nonce, client.Profile()); err != core.ErrTransferSigned {
+		t.Fatalf("expected %v, got %v", core.ErrTransferSigned, err)
+	}
+}
+
+func TestRenewalBinding(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+
+	// Sign a request over the session's nonce.
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := client.SignRenewal(nonce)
+	if err := request.Verify(nonce, client.Profile()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests of another account, replayed in another session or signed by another key are rejected.
+	other := new(core.Client).New(nil, bankProfile)
+	if err := request.Verify(nonce, other.Profile()); err != core.ErrRenewalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrRenewalSigned, err)
+	}
+	replayed, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.Verify(replayed, client.Profile()); err != core.ErrRenewalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrRenewalSigned, err)
+	}
+	if err := other.SignRenewal(nonce).Verify(nonce, client.Profile()); err != core.ErrRenewalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrRenewalSigned, err)
+	}
+	if err := (&core.RenewalRequest{}).Verify(nonce, client.Profile()); err != core.ErrRenewalSigned {
+		t.Fatalf("expected %v, got %v", core.ErrRenewalSigned, err)
+	}
+}
+
+func TestBlindedSignatures(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	collector := new(core.Client).New(nil, bankProfile)
+
+	// Blinded signatures are the same as unblinded ones, with or without the primes of the key.
+	for _, primes := range []bool{true, false} {
+		beneficiary := new(core.Client).New(nil, bankProfile)
+		if !primes {
+			beneficiary.Key.P, beneficiary.Key.Q = nil, nil
+		}
+		first := beneficiary.AuthorizeDeposit(collector.Profile())
+		second := beneficiary.AuthorizeDeposit(collector.Profile())
+		if first.Signature.Cmp(second.Signature) != 0 {
+			t.Fatalf("signatures differ (primes %v)", primes)
+		}
+		if err := first.Verify(collector.Profile()); err != nil {
+			t.Fatalf("primes %v: %v", primes, err)
+		}
+
+		// A signature off by one is rejected.
+		first.Signature.Add(first.Signature, big.NewInt(1))
+		if err := first.Verify(collector.Profile()); err != core.ErrAuthorization {
+			t.Fatalf("expected %v, got %v", core.ErrAuthorization, err)
+		}
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	// Get scheme parameters.
+	scheme := core.Params
+
+	// Create bank.
+	bank := new(core.Bank).New(nil, scheme)
+	bankProfile := bank.Profile()
+
+	// Create client account.
+	client := new(core.Client).New(nil, bankProfile)
+	clientProfile := client.Profile()
+	clientInfo, err := bank.NewClient(nil, clientProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract).SetExpiration(clientInfo.Expiration)
+
+	// Withdraw a coin using the current credentials.
+	coin := client.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+
+	// RENEWAL
+
+	renewed, err := bank.RenewClient(nil, clientInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(renewed)
+
+	if renewed.Profile.Hash() != clientInfo.Profile.Hash() {
+		t.Fatal("renewed credentials are bound to a different profile")
+	}
+	if renewed.Credential.Cmp(clientInfo.Credential) == 0 || renewed.Contract.Cmp(clientInfo.Contract) == 0 {
+		t.Fatal("credentials were not renewed")
+	}
+	if !renewed.Expiration.After(time.Now()) {
+		t.Fatal("renewed credentials are already expired")
+	}
+
+	// Replace credentials.
+	client.SetCredentials(renewed.Credential, renewed.Contract).SetExpiration(renewed.Expiration)
+
+	// Coins withdrawn before the renewal remain valid.
+	if !coin.Profile().VerifyProperties(bankProfile) {
+		t.Fatal("coin withdrawn before renewal is invalid")
+	}
+
+	// Coins withdrawn after the renewal are valid.
+	coin = client.NewCoinRequest(nil)
+	Expiration, A1, C1 = bank.NewCoinResponse(renewed, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	if !coin.Profile().VerifyProperties(bankProfile) {
+		t.Fatal("coin withdrawn after renewal is invalid")
+	}
+
+	// Accounts created before the canonical serialization are renewed with their legacy identity hash.
+	legacyHash := sha256.Sum256(append(clientProfile.Pub.Bytes(), clientProfile.PrivStamp.Bytes()...))
+	legacyInfo := *clientInfo
+	legacyInfo.Profile.IdentityHash = new(big.Int).SetBytes(legacyHash[:])
+	if _, err := bank.RenewClient(nil, &legacyInfo); err != nil {
+		t.Fatal(err)
+	}
+	legacyInfo.Profile.IdentityHash = new(big.Int).Add(legacyInfo.Profile.IdentityHash, big.NewInt(1))
+	if _, err := bank.RenewClient(nil, &legacyInfo); err != core.ErrIdentityMismatch {
+		t.Fatalf("got %v, want %v", err, core.ErrIdentityMismatch)
+	}
+}
+
+func TestShamir(t *testing.T) {
+	// Create bank.
+	bank := new(core.Bank).New(nil, core.Params)
+
+	// Split 3-of-5.
+	shares, err := bank.Split(nil, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(shares[0])
+
+	// Restore using any 3 shares.
+	restored, err := core.RestoreBank([]core.BankShare{shares[4], shares[0], shares[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Priv.Cmp(bank.Priv) != 0 || restored.Key.D.Cmp(bank.Key.D) != 0 ||
+		restored.Key.P.Cmp(bank.Key.P) != 0 || restored.Key.Q.Cmp(bank.Key.Q) != 0 ||
+		restored.Currency != bank.Profile().Currency {
+		t.Fatal("restored bank differs from original")
+	}
+
+	// Not enough shares. (Duplicates don't count)
+	_, err = core.RestoreBank([]core.BankShare{shares[1], shares[3], shares[1]})
+	if err != core.ErrShareCount {
+		t.Fatalf("expected ErrShareCount, got %v", err)
+	}
+
+	// Corrupted share.
+	corrupted := shares[1]
+	corrupted.Priv = new(big.Int).Add(corrupted.Priv, big.NewInt(1))
+	_, err = core.RestoreBank([]core.BankShare{shares[0], corrupted, shares[2]})
+	if err != core.ErrShareInvalid {
+		t.Fatalf("expected ErrShareInvalid, got %v", err)
+	}
+
+	// JSON round trip.
+	data, err := json.Marshal(&shares[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded core.BankShare
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.RestoreBank([]core.BankShare{shares[0], decoded, shares[2]}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid threshold.
+	if _, err := bank.Split(nil, 4, 3); err != core.ErrShareThreshold {
+		t.Fatalf("expected ErrShareThreshold, got %v", err)
+	}
+
+	// Secret outside of the field.
+	oversized := *bank
+	oversized.Key.D = new(big.Int).Lsh(big.NewInt(1), 2281)
+	if _, err := oversized.Split(nil, 3, 5); err != core.ErrShareSecret {
+		t.Fatalf("expected ErrShareSecret, got %v", err)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	// Create bank and client account.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	clientInfo, err := bank.NewClient(nil, client.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+
+	// Split 3-of-5.
+	shares, err := bank.SplitThreshold(nil, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create request.
+	coin := client.NewCoinRequest(nil)
+	Expiration := core.NewCoinExpiration(1)
+
+	// Partial responses of nodes 2, 4 and 5.
+	var partials []core.PartialResponse
+	for _, i := range []int{1, 3, 4} {
+		partials = append(partials, *shares[i].NewPartialResponse(Expiration, coin.Params.ALower, coin.Params.C))
+	}
+
+	// Combine.
+	A1, C1, err := bankProfile.CombineCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C, Expiration, partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Build final coin.
+	client.FinishCoin(coin, Expiration, A1, C1)
+	if !coin.Profile().VerifyProperties(bankProfile) {
+		t.Fatal("coin from combined response is invalid")
+	}
+
+	// Not enough partials.
+	_, _, err = bankProfile.CombineCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C, Expiration, partials[:2])
+	if err != core.ErrShareCount {
+		t.Fatalf("expected ErrShareCount, got %v", err)
+	}
+
+	// Corrupted partials.
+	corrupted := append([]core.PartialResponse(nil), partials...)
+	corrupted[0].A1 = new(big.Int).Add(corrupted[0].A1, big.NewInt(1))
+	_, _, err = bankProfile.CombineCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C, Expiration, corrupted)
+	if err != core.ErrPartialInvalid {
+		t.Fatalf("expected ErrPartialInvalid, got %v", err)
+	}
+	corrupted = append([]core.PartialResponse(nil), partials...)
+	corrupted[1].C1 = new(big.Int).Add(corrupted[1].C1, big.NewInt(1))
+	_, _, err = bankProfile.CombineCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C, Expiration, corrupted)
+	if err != core.ErrPartialInvalid {
+		t.Fatalf("expected ErrPartialInvalid, got %v", err)
+	}
+}
+
+func TestCoinVersion(t *testing.T) {
+	// Setup.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	clientInfo, err := bank.NewClient(nil, client.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+
+	// Full-domain hash coin, with canonical serialization.
+	coin := client.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+	if coinProfile.Version != core.CoinVersionCanonical {
+		t.Fatalf("unexpected coin version %d", coinProfile.Version)
+	}
+	if !coinProfile.VerifyProperties(bankProfile) {
+		t.Fatal("invalid coin")
+	}
+
+	// The signature doesn't verify under the previous encodings.
+	coinProfile.Version = core.CoinVersionFDH
+	if coinProfile.VerifyProperties(bankProfile) {
+		t.Fatal("coin verified without canonical serialization")
+	}
+	coinProfile.Version = core.CoinVersionLegacy
+	if coinProfile.VerifyProperties(bankProfile) {
+		t.Fatal("coin verified as legacy")
+	}
+	coinProfile.Version = 7
+	if coinProfile.VerifyProperties(bankProfile) {
+		t.Fatal("coin verified with unknown version")
+	}
+
+	// Legacy coin. (Signed with textbook RSA on a * H(t), commitment over the plain concatenation)
+	legacy := client.NewCoinRequest(nil)
+	legacy.Params.ALower = new(big.Int).Mod(
+		new(big.Int).Mul(legacy.Params.A, new(big.Int).Exp(legacy.Random.L, bankProfile.E, bankProfile.N)),
+		bankProfile.N,
+	)
+	legacy.Params.Version = core.CoinVersionLegacy
+	commitmentBytes := sha256.Sum256(append(append(legacy.Elgamal.First.Bytes(), legacy.Elgamal.Pub.Bytes()...), legacy.Params.A.Bytes()...))
+	legacy.Params.C = new(big.Int).Mod(
+		new(big.Int).Mul(legacy.Random.Beta1Inv, new(big.Int).SetBytes(commitmentBytes[:])),
+		bankProfile.Scheme.Q,
+	)
+	_, _, C1 = bank.NewCoinResponse(clientInfo, legacy.Params.ALower, legacy.Params.C)
+	expirationBytes, _ := Expiration.MarshalBinary()
+	hashBytes := sha256.Sum256(expirationBytes)
+	msg := new(big.Int).Mul(legacy.Params.ALower, new(big.Int).SetBytes(hashBytes[:]))
+	A1 = new(big.Int).Exp(msg, bank.Key.D, bank.Key.N)
+	client.FinishCoin(legacy, Expiration, A1, C1)
+	legacyProfile := legacy.Profile()
+	if !legacyProfile.VerifyProperties(bankProfile) {
+		t.Fatal("invalid legacy coin")
+	}
+
+	// Legacy coins are rejected once the transition window is closed.
+	core.AcceptLegacyCoins = false
+	defer func() { core.AcceptLegacyCoins = true }()
+	if legacyProfile.VerifyProperties(bankProfile) {
+		t.Fatal("legacy coin verified after the transition window")
+	}
+	if !coin.Profile().VerifyProperties(bankProfile) {
+		t.Fatal("invalid coin")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	// Setup.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	clientProfile := client.Profile()
+	clientInfo, err := bank.NewClient(nil, clientProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+
+	// Honest values are accepted.
+	coin := client.NewCoinRequest(nil)
+	if err := bankProfile.ValidateClient(clientProfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankProfile.ValidateCredentials(clientInfo.Credential, clientInfo.Contract); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankProfile.ValidateCoinRequest(coin.Params.ALower, coin.Params.C); err != nil {
+		t.Fatal(err)
+	}
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+	if err := bankProfile.ValidateCoin(coinProfile); err != nil {
+		t.Fatal(err)
+	}
+	msg := coinProfile.Stamp(bankProfile, clientProfile)
+	second := client.SignCoin(coin, msg)
+	if err := bankProfile.ValidateSecond(second); err != nil {
+		t.Fatal(err)
+	}
+	coinProfile.Second = second
+	if err := bankProfile.ValidateCoin(coinProfile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rejected values.
+	pMinus1 := new(big.Int).Sub(bankProfile.Scheme.P, big.NewInt(1))
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"missing ALower", bankProfile.ValidateCoinRequest(nil, coin.Params.C), core.ErrMissingValue},
+		{"zero ALower", bankProfile.ValidateCoinRequest(big.NewInt(0), coin.Params.C), core.ErrOutOfRange},
+		{"large ALower", bankProfile.ValidateCoinRequest(bankProfile.N, coin.Params.C), core.ErrOutOfRange},
+		{"zero C", bankProfile.ValidateCoinRequest(coin.Params.ALower, big.NewInt(0)), core.ErrOutOfRange},
+		{"large C", bankProfile.ValidateCoinRequest(coin.Params.ALower, bankProfile.Scheme.Q), core.ErrOutOfRange},
+		{"identity credential", bankProfile.ValidateCredentials(big.NewInt(1), clientInfo.Contract), core.ErrOutOfRange},
+		{"non-residue contract", bankProfile.ValidateCredentials(clientInfo.Credential, pMinus1), core.ErrNonResidue},
+		{"large second", bankProfile.ValidateSecond(pMinus1), core.ErrOutOfRange},
+	}
+	for _, test := range tests {
+		var validationErr *core.ValidationError
+		if !errors.As(test.err, &validationErr) || !errors.Is(test.err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.err, test.want)
+		}
+	}
+
+	// Non-residue coin parameter.
+	forged := *coinProfile
+	forged.A = pMinus1
+	if err := bankProfile.ValidateCoin(&forged); !errors.Is(err, core.ErrNonResidue) {
+		t.Fatalf("got %v, want %v", err, core.ErrNonResidue)
+	}
+
+	// Missing client field.
+	forgedClient := *clientProfile
+	forgedClient.PrivStamp = nil
+	if err := bankProfile.ValidateClient(&forgedClient); !errors.Is(err, core.ErrMissingValue) {
+		t.Fatalf("got %v, want %v", err, core.ErrMissingValue)
+	}
+
+	// Client modulus longer than the bank's.
+	forgedClient = *clientProfile
+	forgedClient.N = new(big.Int).Lsh(bankProfile.N, 1)
+	if err := bankProfile.ValidateClient(&forgedClient); !errors.Is(err, core.ErrValueSize) {
+		t.Fatalf("got %v, want %v", err, core.ErrValueSize)
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	// Setup.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	clientProfile := client.Profile()
+	clientInfo, err := bank.NewClient(nil, clientProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+	coin := client.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+
+	// Complete messages are accepted. (Second and Msg are optional, unknown before the payment)
+	escrow, _, _ := core.NewEscrow(nil, time.Now().Add(time.Hour))
+	memo, _ := core.NewMemo("order")
+	request := struct {
+		Escrow *core.Escrow
+		Memo   *core.Memo
+		Change *core.Change
+	}{Escrow: escrow, Memo: memo}
+	for _, message := range []any{coinProfile, clientProfile, bankProfile, &request, []core.CoinProfile{*coinProfile}} {
+		if err := core.CheckMessage(message); err != nil {
+			t.Fatalf("%T: %v", message, err)
+		}
+	}
+
+	// Missing and oversized values are rejected, at any depth.
+	forged := *coinProfile
+	forged.Pub = nil
+	oversized := *coinProfile
+	oversized.Second = new(big.Int).Lsh(big.NewInt(1), core.MaxModulusBits)
+	forgedEscrow := *escrow
+	forgedEscrow.Refund = nil
+	forgedBank := *bankProfile
+	forgedBank.Scheme.G = nil
+	var second *big.Int
+	tests := []struct {
+		name    string
+		message any
+		field   string
+		want    error
+	}{
+		{"coin", &forged, "CoinProfile.Pub", core.ErrMissingValue},
+		{"coins", []core.CoinProfile{*coinProfile, forged}, "CoinProfile[1].Pub", core.ErrMissingValue},
+		{"escrow", &struct{ Escrow *core.Escrow }{&forgedEscrow}, "message.Escrow.Refund", core.ErrMissingValue},
+		{"scheme", &forgedBank, "BankProfile.Scheme.G", core.ErrMissingValue},
+		{"number", &second, "message", core.ErrMissingValue},
+		{"oversized", &oversized, "CoinProfile.Second", core.ErrValueSize},
+	}
+	for _, test := range tests {
+		var validationErr *core.ValidationError
+		err := core.CheckMessage(test.message)
+		if !errors.As(err, &validationErr) || !errors.Is(err, test.want) || validationErr.Field != test.field {
+			t.Errorf("%s: got %v, want %v (%s)", test.name, err, test.want, test.field)
+		}
+	}
+}
+
+func TestVerifyCoinBatch(t *testing.T) {
+	// Setup.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	client := new(core.Client).New(nil, bankProfile)
+	clientInfo, err := bank.NewClient(nil, client.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+
+	// Withdraw coins, corrupting every third one.
+	coins := make([]*core.CoinProfile, 10)
+	for i := range coins {
+		coin := client.NewCoinRequest(nil)
+		Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+		client.FinishCoin(coin, Expiration, A1, C1)
+		coins[i] = coin.Profile()
+		if i%3 == 0 {
+			coins[i].R = new(big.Int).Add(coins[i].R, big.NewInt(1))
+		}
+	}
+
+	valid := core.VerifyCoinBatch(bankProfile, coins)
+	for i := range coins {
+		if valid[i] != (i%3 != 0) {
+			t.Errorf("coin %d: got %v", i, valid[i])
+		}
+	}
+	if len(core.VerifyCoinBatch(bankProfile, nil)) != 0 {
+		t.Fatal("unexpected results for empty batch")
+	}
+}
+
+func TestSafePrime(t *testing.T) {
+	// GenerateSafePrime.
+	p, q, err := core.GenerateSafePrime(context.Background(), nil, 256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.BitLen() != 256 || !q.ProbablyPrime(20) || !p.ProbablyPrime(20) {
+		t.Fatalf("invalid safe prime: p = %s, q = %s", p, q)
+	}
+	if new(big.Int).Add(new(big.Int).Lsh(q, 1), big.NewInt(1)).Cmp(p) != 0 {
+		t.Fatal("p != 2q + 1")
+	}
+
+	// Generate. (alpha must have order q)
+	scheme, err := new(core.SchemeParams).Generate(context.Background(), nil, 256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme.G.Cmp(big.NewInt(1)) == 0 || new(big.Int).Exp(scheme.G, scheme.Q, scheme.P).Cmp(big.NewInt(1)) != 0 {
+		t.Fatal("alpha doesn't have order q")
+	}
+
+	// Cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := core.GenerateSafePrime(ctx, nil, 4096, 2); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	// Bit length out of range.
+	for _, bits := range []int{8, core.MaxModulusBits} {
+		if _, _, err := core.GenerateSafePrime(context.Background(), nil, bits, 2); err != core.ErrSafePrimeBits {
+			t.Fatalf("%d bits: got %v, want %v", bits, err, core.ErrSafePrimeBits)
+		}
+	}
+}
+
+func TestFixedBase(t *testing.T) {
+	p, q, g := core.Params.P, core.Params.Q, core.Params.G
+
+	// Table exponentiations are those of big.Int.Exp, over the edges of the exponents and random ones.
+	exponents := []*big.Int{big.NewInt(0), big.NewInt(1), new(big.Int).Sub(q, big.NewInt(1)), new(big.Int).Set(q)}
+	for i := 0; i < 32; i++ {
+		x, err := rand.Int(rand.Reader, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exponents = append(exponents, x)
+	}
+	for _, x := range exponents {
+		if got := core.FixedBaseExp(g, p, q, x); got == nil || got.Cmp(new(big.Int).Exp(g, x, p)) != 0 {
+			t.Fatalf("alpha^%s differs from big.Int.Exp", x)
+		}
+	}
+
+	// Tables keep their own copy of the base, callers changing theirs afterwards don't change them.
+	base := new(big.Int).Exp(g, big.NewInt(2), p)
+	core.FixedBaseExp(base, p, q, exponents[4])
+	base.Exp(g, big.NewInt(3), p)
+	if got := core.FixedBaseExp(base, p, q, exponents[4]); got.Cmp(new(big.Int).Exp(base, exponents[4], p)) != 0 {
+		t.Fatal("table of a changed base used")
+	}
+
+	// Bases outside of the subgroup of order q don't use a table.
+	if core.FixedBaseExp(new(big.Int).Sub(p, big.NewInt(1)), p, q, big.NewInt(1)) != nil {
+		t.Fatal("table built for a base outside of the subgroup")
+	}
+}
+
+func TestSchemeValidate(t *testing.T) {
+	// Validate. (Built-in parameters)
+	if err := core.Params.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// LoadSchemeParams.
+	filename := t.TempDir() + "/params.json"
+	if err := core.SaveToFile(core.Params, filename); err != nil {
+		t.Fatal(err)
+	}
+	scheme, err := core.LoadSchemeParams(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme.P.Cmp(core.Params.P) != 0 || scheme.G.Cmp(core.Params.G) != 0 {
+		t.Fatal("loaded parameters differ")
+	}
+
+	// The compiled-in parameters are those of params.json.
+	source, err := core.LoadSchemeParams("params.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Q.Cmp(core.Params.Q) != 0 || source.P.Cmp(core.Params.P) != 0 || source.G.Cmp(core.Params.G) != 0 {
+		t.Fatal("compiled-in parameters differ from params.json")
+	}
+
+	// Validate. (Invalid parameters)
+	invalid := []struct {
+		scheme core.SchemeParams
+		err    error
+	}{
+		{core.SchemeParams{Q: core.Params.Q, G: core.Params.G}, core.ErrMissingValue},
+		{core.SchemeParams{Q: core.Params.Q, P: new(big.Int).Lsh(big.NewInt(1), core.MaxModulusBits), G: core.Params.G}, core.ErrValueSize},
+		{core.SchemeParams{Q: core.Params.Q, P: new(big.Int).Add(core.Params.P, big.NewInt(2)), G: core.Params.G}, core.ErrSafePrime},
+		{core.SchemeParams{Q: new(big.Int).Add(core.Params.Q, big.NewInt(1)), P: core.Params.P, G: core.Params.G}, core.ErrSafePrime},
+		{core.SchemeParams{Q: core.Params.Q, P: core.Params.P, G: big.NewInt(1)}, core.ErrOutOfRange},
+		{core.SchemeParams{Q: core.Params.Q, P: core.Params.P, G: new(big.Int).Sub(core.Params.P, big.NewInt(1))}, core.ErrNonResidue},
+	}
+	for i, test := range invalid {
+		if err := test.scheme.Validate(); !errors.Is(err, test.err) {
+			t.Errorf("scheme %d: got %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func TestValidateIdentity(t *testing.T) {
+	bankProfile := new(core.Bank).New(nil, core.Params).Profile()
+
+	// ValidateIdentity. (Same bank)
+	live := *bankProfile
+	if err := bankProfile.ValidateIdentity(&live); err != nil {
+		t.Fatal(err)
+	}
+
+	// ValidateIdentity. (Switched scheme parameters)
+	live.Scheme.G = new(big.Int).Exp(core.Params.G, big.NewInt(2), core.Params.P)
+	if err := bankProfile.ValidateIdentity(&live); err != core.ErrSchemeMismatch {
+		t.Fatalf("got %v, want %v", err, core.ErrSchemeMismatch)
+	}
+
+	// ValidateIdentity. (Switched keys)
+	other := new(core.Bank).New(nil, core.Params).Profile()
+	if err := bankProfile.ValidateIdentity(other); err != core.ErrBankMismatch {
+		t.Fatalf("got %v, want %v", err, core.ErrBankMismatch)
+	}
+}
+
+// updateVectors regenerates testdata/vectors.json, keeping its inputs.
+var updateVectors = flag.Bool("update", false, "regenerate testdata/vectors.json")
+
+// vectorsFile is the path of the protocol test vectors.
+const vectorsFile = "testdata/vectors.json"
+
+// testVectors are the inputs and expected outputs of a full run of the protocols. Numbers are hexadecimal strings and
+// dates RFC 3339 strings, so that other implementations can read them.
+type testVectors struct {
+	Description string            `json:"description"`
+	Seed        string            `json:"seed"`
+	Now         string            `json:"now"`
+	Inputs      map[string]string `json:"inputs"`
+	Outputs     map[string]string `json:"outputs"`
+}
+
+// vectorKey reads the RSA key named name from inputs.
+func vectorKey(t *testing.T, inputs map[string]string, name string) *core.RsaKey {
+	key := &core.RsaKey{}
+	for field, x := range map[string]**big.Int{"p": &key.P, "q": &key.Q, "n": &key.N, "d": &key.D, "e": &key.E} {
+		value, ok := new(big.Int).SetString(inputs[name+"."+field], 16)
+		if !ok {
+			t.Fatalf("invalid input %s.%s", name, field)
+		}
+		*x = value
+	}
+	return key
+}
+
+// runVectors runs the protocols with the RSA keys of inputs, a random reader seeded by seed and the clock fixed at now,
+// and returns every computed value.
+func runVectors(t *testing.T, seed []byte, now time.Time, inputs map[string]string) map[string]string {
+	random := core.NewDeterministicReader(seed)
+	clock := core.Now
+	core.Now = func() time.Time { return now }
+	defer func() { core.Now = clock }()
+
+	outputs := make(map[string]string)
+	number := func(name string, x *big.Int) { outputs[name] = x.Text(16) }
+	date := func(name string, x time.Time) { outputs[name] = x.Format(time.RFC3339Nano) }
+
+	// SETUP
+	bank := new(core.Bank).NewFromKey(random, core.Params, vectorKey(t, inputs, "bank.key"))
+	bankProfile := bank.Profile()
+	number("bank.priv", bank.Priv)
+	number("bank.pub", bank.Pub)
+
+	// ACCOUNT GENERATION
+	client := new(core.Client).NewFromKey(random, bankProfile, vectorKey(t, inputs, "client.key"))
+	clientProfile := client.Profile()
+	number("client.priv", client.Priv)
+	number("client.pub", client.Pub)
+	number("client.tradeId", client.TradeId)
+	number("client.privStamp", clientProfile.PrivStamp)
+	number("client.identityHash", clientProfile.IdentityHash)
+
+	clientInfo, err := bank.NewClient(random, clientProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract).SetExpiration(clientInfo.Expiration)
+	number("client.k", clientInfo.K)
+	number("client.s", clientInfo.S)
+	number("client.credential", clientInfo.Credential)
+	number("client.contract", clientInfo.Contract)
+	date("client.expiration", clientInfo.Expiration)
+
+	// WITHDRAWAL
+	coin := client.NewCoinRequest(random)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	number("coin.random.e", coin.Random.E)
+	number("coin.random.l", coin.Random.L)
+	number("coin.random.beta1", coin.Random.Beta1)
+	number("coin.random.beta2", coin.Random.Beta2)
+	number("coin.random.y", coin.Random.Y)
+	number("coin.elgamal.priv", coin.Elgamal.Priv)
+	number("coin.elgamal.pub", coin.Elgamal.Pub)
+	number("coin.elgamal.first", coin.Elgamal.First)
+	number("coin.params.A", coin.Params.A)
+	number("coin.params.a", coin.Params.ALower)
+	number("coin.params.C", coin.Params.C)
+	date("coin.params.expiration", coin.Params.Expiration)
+	number("coin.params.A1", coin.Params.A1)
+	number("coin.params.C1", coin.Params.C1)
+	number("coin.params.A2", coin.Params.A2)
+	number("coin.params.R", coin.Params.R)
+	number("coin.params.version", big.NewInt(int64(coin.Params.Version)))
+
+	// PAYMENT
+	coinProfile := coin.Profile()
+	if !coinProfile.VerifyProperties(bankProfile) {
+		t.Fatal("invalid coin properties")
+	}
+	msg := coinProfile.Stamp(bankProfile, clientProfile)
+	second := client.SignCoin(coin, msg)
+	if !coinProfile.VerifyElgamal(bankProfile, second) {
+		t.Fatal("invalid Elgamal's signature")
+	}
+	number("coin.elgamal.msg", msg)
+	number("coin.elgamal.second", second)
+
+	return outputs
+}
+
+func TestVectors(t *testing.T) {
+	var vectors testVectors
+	data, err := os.ReadFile(vectorsFile)
+	if err == nil {
+		err = json.Unmarshal(data, &vectors)
+	}
+	if err != nil && !(*updateVectors && errors.Is(err, os.ErrNotExist)) {
+		t.Fatal(err)
+	}
+
+	// Regenerate the vectors, with new RSA keys if there are none.
+	if *updateVectors {
+		if vectors.Inputs == nil {
+			vectors = testVectors{
+				Description: "Full run of the protocols with core.Params, fixed RSA keys, NewDeterministicReader(seed) as random reader and core.Now = now.",
+				Seed:        hex.EncodeToString([]byte("ziba test vectors")),
+				Now:         "2025-01-01T00:00:00Z",
+				Inputs: map[string]string{
+					"scheme.p": core.Params.P.Text(16),
+					"scheme.q": core.Params.Q.Text(16),
+					"scheme.g": core.Params.G.Text(16),
+				},
+			}
+			for _, name := range []string{"bank.key", "client.key"} {
+				key := new(core.RsaKey).New(nil)
+				vectors.Inputs[name+".p"] = key.P.Text(16)
+				vectors.Inputs[name+".q"] = key.Q.Text(16)
+				vectors.Inputs[name+".n"] = key.N.Text(16)
+				vectors.Inputs[name+".d"] = key.D.Text(16)
+				vectors.Inputs[name+".e"] = key.E.Text(16)
+			}
+		}
+	}
+
+	// Check the scheme parameters.
+	for name, x := range map[string]*big.Int{"scheme.p": core.Params.P, "scheme.q": core.Params.Q, "scheme.g": core.Params.G} {
+		if vectors.Inputs[name] != x.Text(16) {
+			t.Fatalf("%s doesn't match core.Params", name)
+		}
+	}
+
+	seed, err := hex.DecodeString(vectors.Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, err := time.Parse(time.RFC3339Nano, vectors.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := runVectors(t, seed, now, vectors.Inputs)
+
+	if *updateVectors {
+		vectors.Outputs = outputs
+		data, err := json.MarshalIndent(vectors, "", "\t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(vectorsFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// Compare bit-for-bit.
+	for name, want := range vectors.Outputs {
+		if got := outputs[name]; got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	for name := range outputs {
+		if _, ok := vectors.Outputs[name]; !ok {
+			t.Errorf("%s missing from vectors", name)
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	bank := new(core.Bank).New(nil, core.Params)
+	client := new(core.Client).New(nil, bank.Profile())
+	clientProfile := client.Profile()
+	clientInfo, err := bank.NewClient(nil, clientProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract).SetExpiration(clientInfo.Expiration)
+	coin := client.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+
+	// The Elgamal's signature of the coin profile is not set, it must be decoded as nil.
+	coinProfile := coin.Profile()
+
+	type value interface {
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+		json.Marshaler
+		json.Unmarshaler
+	}
+	values := map[string][2]value{
+		"Bank":          {bank, new(core.Bank)},
+		"Client":        {client, new(core.Client)},
+		"ClientProfile": {clientProfile, new(core.ClientProfile)},
+		"ClientInfo":    {clientInfo, new(core.ClientInfo)},
+		"Coin":          {coin, new(core.Coin)},
+		"CoinProfile":   {coinProfile, new(core.CoinProfile)},
+	}
+	for name, pair := range values {
+		original, decoded := pair[0], pair[1]
+
+		// Binary.
+		data, err := original.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if again, _ := decoded.MarshalBinary(); !bytes.Equal(data, again) {
+			t.Errorf("%s: binary round trip mismatch", name)
+		}
+		if err := decoded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, core.ErrEncoding) {
+			t.Errorf("%s: truncated data: got %v, want %v", name, err, core.ErrEncoding)
+		}
+		if err := decoded.UnmarshalBinary(append([]byte{0}, data[1:]...)); !errors.Is(err, core.ErrEncodingVersion) {
+			t.Errorf("%s: unknown version: got %v, want %v", name, err, core.ErrEncodingVersion)
+		}
+
+		// JSON.
+		data, err = original.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.UnmarshalJSON(data); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if again, _ := decoded.MarshalJSON(); !bytes.Equal(data, again) {
+			t.Errorf("%s: JSON round trip mismatch", name)
+		}
+	}
+
+	// Nil values.
+	var decoded core.CoinProfile
+	data, _ := coinProfile.MarshalBinary()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Second != nil || decoded.Msg != nil || decoded.A2.Cmp(coinProfile.A2) != 0 {
+		t.Fatal("nil values not preserved")
+	}
+
+	// Gob uses the binary encoding, also for values as sent by the network package.
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(*coinProfile); err != nil {
+		t.Fatal(err)
+	}
+	decoded = core.CoinProfile{}
+	if err := gob.NewDecoder(&buffer).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != coinProfile.Hash() || !decoded.VerifyProperties(bank.Profile()) {
+		t.Fatal("gob round trip mismatch")
+	}
+}
+
+// newBenchmarkCoin returns a bank, a client with credentials, its account and a finished coin.
+func newBenchmarkCoin(b testing.TB) (*core.Bank, *core.Client, *core.ClientInfo, *core.Coin) {
+	b.Helper()
+	bank := new(core.Bank).New(nil, core.Params)
+	client := new(core.Client).New(nil, bank.Profile())
+	clientInfo, err := bank.NewClient(nil, client.Profile())
+	if err != nil {
+		b.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+	coin := client.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	return bank, client, clientInfo, coin
+}
+
+func BenchmarkBankNew(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		new(core.Bank).New(nil, core.Params)
+	}
+}
+
+func BenchmarkNewCoinRequest(b *testing.B) {
+	_, client, _, _ := newBenchmarkCoin(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.NewCoinRequest(nil)
+	}
+}
+
+func BenchmarkNewCoinResponse(b *testing.B) {
+	bank, _, clientInfo, coin := newBenchmarkCoin(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	}
+}
+
+func BenchmarkVerifyProperties(b *testing.B) {
+	bank, _, _, coin := newBenchmarkCoin(b)
+	bankProfile := bank.Profile()
+	coinProfile := coin.Profile()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !coinProfile.VerifyProperties(bankProfile) {
+			b.Fatal("invalid coin")
+		}
+	}
+}
+
+func BenchmarkVerifyCoinBatch(b *testing.B) {
+	bank, _, _, coin := newBenchmarkCoin(b)
+	bankProfile := bank.Profile()
+	coins := make([]*core.CoinProfile, 64)
+	for i := range coins {
+		coins[i] = coin.Profile()
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		core.VerifyCoinBatch(bankProfile, coins)
+	}
+}
+
+func BenchmarkStamp(b *testing.B) {
+	bank, client, _, coin := newBenchmarkCoin(b)
+	bankProfile := bank.Profile()
+	clientProfile := client.Profile()
+	coinProfile := coin.Profile()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		coinProfile.Stamp(bankProfile, clientProfile)
+	}
+}
+
+func BenchmarkSignCoin(b *testing.B) {
+	bank, client, _, coin := newBenchmarkCoin(b)
+	msg := coin.Profile().Stamp(bank.Profile(), client.Profile())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.SignCoin(coin, msg)
+	}
+}
+
+func BenchmarkVerifyElgamal(b *testing.B) {
+	bank, client, _, coin := newBenchmarkCoin(b)
+	bankProfile := bank.Profile()
+	coinProfile := coin.Profile()
+	msg := coinProfile.Stamp(bankProfile, client.Profile())
+	second := client.SignCoin(coin, msg)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !coinProfile.VerifyElgamal(bankProfile, second) {
+			b.Fatal("invalid Elgamal's signature")
+		}
+	}
+}
+
+func BenchmarkRevocationsVerify(b *testing.B) {
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile().Precompute()
+	list := bank.SignRevocations(nil, &core.Revocations{Coins: []uint32{1, 2, 3}})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !list.Verify(bankProfile) {
+			b.Fatal("invalid revocation list signature")
+		}
+	}
+}
+
+func TestVerifyAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector drops recycled values")
+	}
+	bank, client, _, coin := newBenchmarkCoin(t)
+	bankProfile := bank.Profile().Precompute()
+	coinProfile := coin.Profile()
+	msg := coinProfile.Stamp(bankProfile, client.Profile())
+	second := client.SignCoin(coin, msg)
+
+	// The temporaries are recycled, only the digests and the results of the exponentiations are allocated.
+	if allocs := testing.AllocsPerRun(20, func() { coinProfile.VerifyProperties(bankProfile) }); allocs > 100 {
+		t.Fatalf("VerifyProperties: %.0f allocations per run", allocs)
+	}
+	if allocs := testing.AllocsPerRun(20, func() { coinProfile.VerifyElgamal(bankProfile, second) }); allocs > 100 {
+		t.Fatalf("VerifyElgamal: %.0f allocations per run", allocs)
+	}
+}
+
+func TestRecycledInts(t *testing.T) {
+	bank, client, _, coin := newBenchmarkCoin(t)
+	bankProfile := bank.Profile().Precompute()
+	scheme := bankProfile.Scheme
+	coinProfile := coin.Profile()
+	second := client.SignCoin(coin, coinProfile.Stamp(bankProfile, client.Profile()))
+	tampered := *coinProfile
+	tampered.R = new(big.Int).Add(coinProfile.R, big.NewInt(1))
+
+	// Exponents and their powers, computed without the recycled temporaries.
+	exponents := make([]*big.Int, 16)
+	powers := make([]*big.Int, len(exponents))
+	for i := range exponents {
+		x, err := rand.Int(rand.Reader, scheme.Q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exponents[i], powers[i] = x, new(big.Int).Exp(scheme.G, x, scheme.P)
+	}
+
+	// The same computations, run over and over in parallel, never see a value left by another one.
+	for w := 0; w < 8; w++ {
+		t.Run(fmt.Sprintf("worker%d", w), func(t *testing.T) {
+			t.Parallel()
+			for i := 0; i < 4*len(exponents); i++ {
+				x := exponents[i%len(exponents)]
+				if got := core.FixedBaseExp(scheme.G, scheme.P, scheme.Q, x); got.Cmp(powers[i%len(exponents)]) != 0 {
+					t.Fatalf("alpha^%s differs from big.Int.Exp", x)
+				}
+				if !coinProfile.VerifyProperties(bankProfile) || !coinProfile.VerifyElgamal(bankProfile, second) {
+					t.Fatal("valid coin rejected")
+				}
+				if tampered.VerifyProperties(bankProfile) {
+					t.Fatal("tampered coin accepted")
+				}
+			}
+		})
+	}
+}
+
+func TestRevocations(t *testing.T) {
+	// Create bank and client.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+
+	client := new(core.Client).New(nil, bankProfile)
+	clientInfo, err := bank.NewClient(nil, client.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
+
+	// Withdraw coin.
+	coin := client.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
+	client.FinishCoin(coin, Expiration, A1, C1)
+	coinProfile := coin.Profile()
+
+	// Sign an empty list.
+	list := bank.SignRevocations(nil, &core.Revocations{})
+	if valid := list.Verify(bankProfile); !valid {
+		t.Fatal("invalid revocation list signature")
+	}
+	if list.Revoked(coinProfile) || list.Frozen(client.Profile()) {
+		t.Fatal("coin or account revoked by an empty list")
+	}
+
+	// Sign a list revoking the coin and freezing the account.
+	list = bank.SignRevocations(nil, &core.Revocations{
+		Coins:    []uint32{coinProfile.Hash() + 1, coinProfile.Hash()},
+		Accounts: []uint32{client.Profile().Hash()},
+	})
+	if valid := list.Verify(bankProfile); !valid {
+		t.Fatal("invalid revocation list signature")
+	}
+	if !list.Revoked(coinProfile) || !list.Frozen(client.Profile()) {
+		t.Fatal("coin or account not revoked")
+	}
+
+	// Tampered lists and lists of other banks are rejected.
+	other := new(core.Bank).New(nil, core.Params)
+	if valid := list.Verify(other.Profile()); valid {
+		t.Fatal("revocation list verified with another bank")
+	}
+	list.Accounts = nil
+	if valid := list.Verify(bankProfile); valid {
+		t.Fatal("tampered revocation list verified")
+	}
+}
+
+func TestSetupBundle(t *testing.T) {
+	// Create bank.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	fingerprint := strings.Repeat("ab", sha256.Size)
+
+	// Sign a bundle, publicly trusted certificates too.
+	for _, cert := range []string{fingerprint, ""} {
+		bundle := bank.SignSetup(nil, "bank", cert)
+		if err := bundle.Verify(bankProfile); err != nil {
+			t.Fatal(err)
+		}
+
+		// Binary round trip.
+		data, err := bundle.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded core.SetupBundle
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(bankProfile); err != nil || decoded.Name != "bank" || decoded.Cert != cert {
+			t.Fatalf("unexpected decoded bundle: %+v (%v)", decoded, err)
+		}
+	}
+
+	// Other banks and tampered bundles are rejected.
+	bundle := bank.SignSetup(nil, "bank", fingerprint)
+	other := new(core.Bank).New(nil, core.Params)
+	if err := bundle.Verify(other.Profile()); !errors.Is(err, core.ErrSetupSignature) {
+		t.Fatalf("bundle verified with another bank: %v", err)
+	}
+	bundle.Cert = strings.Repeat("cd", sha256.Size)
+	if err := bundle.Verify(bankProfile); !errors.Is(err, core.ErrSetupSignature) {
+		t.Fatalf("tampered bundle verified: %v", err)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	// Create bank and merchant.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	merchant := new(core.Client).New(nil, bankProfile)
+	fingerprint := strings.Repeat("ab", sha256.Size)
+
+	// Request and sign an attestation.
+	request := merchant.RequestAttestation(fingerprint)
+	if err := request.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	attestation := bank.Attest(nil, request)
+	if err := attestation.Verify(bankProfile, fingerprint); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests for another fingerprint than the signed one are rejected.
+	forged := *request
+	forged.Fingerprint = strings.Repeat("cd", sha256.Size)
+	if err := forged.Verify(); !errors.Is(err, core.ErrAttestation) {
+		t.Fatalf("forged request verified: %v", err)
+	}
+
+	// Other certificates, other banks and tampered attestations are rejected.
+	if err := attestation.Verify(bankProfile, forged.Fingerprint); !errors.Is(err, core.ErrAttestation) {
+		t.Fatalf("attestation verified for another certificate: %v", err)
+	}
+	other := new(core.Bank).New(nil, core.Params)
+	if err := attestation.Verify(other.Profile(), fingerprint); !errors.Is(err, core.ErrAttestation) {
+		t.Fatalf("attestation verified with another bank: %v", err)
+	}
+	attestation.Merchant = *new(core.Client).New(nil, bankProfile).Profile()
+	if err := attestation.Verify(bankProfile, fingerprint); !errors.Is(err, core.ErrAttestation) {
+		t.Fatalf("tampered attestation verified: %v", err)
+	}
+}
+
+func TestDispute(t *testing.T) {
+	// Create bank, spender and merchants.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankProfile := bank.Profile()
+	spender := new(core.Client).New(nil, bankProfile)
+	spenderInfo, err := bank.NewClient(nil, spender.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spender.SetCredentials(spenderInfo.Credential, spenderInfo.Contract)
+	first := new(core.Client).New(nil, bankProfile).Profile()
+	second := new(core.Client).New(nil, bankProfile).Profile()
+
+	// Withdraw coin, pay both merchants with it.
+	coin := spender.NewCoinRequest(nil)
+	Expiration, A1, C1 := bank.NewCoinResponse(spenderInfo, coin.Params.ALower, coin.Params.C)
+	spender.FinishCoin(coin, Expiration, A1, C1)
+	spent, attempt := coin.Profile(), coin.Profile()
+	spent.Second = spender.SignCoin(coin, spent.Stamp(bankProfile, first))
+	attempt.Second = spender.SignCoin(coin, attempt.Stamp(bankProfile, second))
+
+	// Double spend.
+	dispute := core.NewDoubleSpend(bankProfile, spent, attempt)
+	if err := dispute.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Binary round trip.
+	data, err := dispute.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded core.Dispute
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(); err != nil || decoded.Kind != core.DisputeDoubleSpend || len(decoded.Coins) != 2 {
+		t.Fatalf("unexpected decoded dispute: %+v (%v)", decoded, err)
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, core.ErrEncoding) {
+		t.Fatalf("expected ErrEncoding, got %v", err)
+	}
+
+	// Other coins, forged stamps and other mints are rejected.
+	other := spender.NewCoinRequest(nil)
+	Expiration, A1, C1 = bank.NewCoinResponse(spenderInfo, other.Params.ALower, other.Params.C)
+	spender.FinishCoin(other, Expiration, A1, C1)
+	if err := core.NewDoubleSpend(bankProfile, spent, other.Profile()).Verify(); !errors.Is(err, core.ErrDispute) {
+		t.Fatalf("dispute of two coins verified: %v", err)
+	}
+	forged := *attempt
+	forged.Msg = new(big.Int).Add(attempt.Msg, big.NewInt(2))
+	if err := core.NewDoubleSpend(bankProfile, spent, &forged).Verify(); !errors.Is(err, core.ErrDispute) {
+		t.Fatalf("dispute of a forged stamp verified: %v", err)
+	}
+	otherBank := new(core.Bank).New(nil, core.Params)
+	if err := core.NewDoubleSpend(otherBank.Profile(), spent, attempt).Verify(); !errors.Is(err, core.ErrDispute) {
+		t.Fatalf("dispute verified with another mint: %v", err)
+	}
+
+	// Invalid coin. (Only if its signature doesn't verify)
+	invalid := *spent
+	invalid.A2 = new(big.Int).Add(spent.A2, big.NewInt(1))
+	if err := core.NewInvalidCoin(bankProfile, &invalid).Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.NewInvalidCoin(bankProfile, spent).Verify(); !errors.Is(err, core.ErrDispute) {
+		t.Fatalf("dispute of a valid coin verified: %v", err)
+	}
+}
+
+func TestGraceExchange(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	validity := core.CoinValidity{Grace: 72 * time.Hour}
+
+	// Unexpired coins are spent, expired ones are not.
+	if err := core.CheckUnexpired(now.Add(time.Hour), now); err != nil {
+		t.Fatalf("unexpired coin: %v", err)
+	}
+	if err := core.CheckUnexpired(now, now); !errors.Is(err, core.ErrCoinExpired) {
+		t.Fatalf("expired coin: got %v, want %v", err, core.ErrCoinExpired)
+	}
+
+	// Expired coins are exchanged during their grace period only.
+	for _, check := range []struct {
+		expiration time.Time
+		grace      bool
+		err        error
+	}{
+		{now.Add(time.Hour), false, nil},
+		{now.Add(-time.Hour), true, nil},
+		{now.Add(-72 * time.Hour), true, nil},
+		{now.Add(-73 * time.Hour), false, core.ErrCoinExpired},
+	} {
+		grace, err := validity.CheckExchange(check.expiration, now)
+		if grace != check.grace || !errors.Is(err, check.err) {
+			t.Errorf("%s: got %v, %v, want %v, %v", check.expiration, grace, err, check.grace, check.err)
+		}
+	}
+
+	// Without a grace period.
+	if _, err := new(core.CoinValidity).CheckExchange(now.Add(-time.Hour), now); !errors.Is(err, core.ErrCoinExpired) {
+		t.Fatalf("no grace period: got %v, want %v", err, core.ErrCoinExpired)
+	}
+}
+
+func TestCoinValidity(t *testing.T) {
+	now := time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC)
+
+	// Default period. (Issuance is approximate across months of different lengths)
+	var validity core.CoinValidity
+	expiration := validity.Expiration(5, now)
+	if !expiration.Equal(now.AddDate(0, 1, 1)) || validity.Period(5, now) != expiration.Sub(now) {
+		t.Fatalf("unexpected default expiration: %s", expiration)
+	}
+	if mid := now.AddDate(0, 0, -15); !validity.Issuance(validity.Expiration(5, mid), 5).Equal(mid) {
+		t.Fatalf("unexpected default issuance: %s", validity.Issuance(validity.Expiration(5, mid), 5))
+	}
+
+	// Periods by value.
+	periods, err := core.ParseValidityPeriods([]string{"1=168h", " 100 = 2160h "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validity = core.CoinValidity{Default: 720 * time.Hour, Periods: periods}
+	if err := validity.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for value, period := range map[int64]time.Duration{0: 168 * time.Hour, 1: 168 * time.Hour, 5: 720 * time.Hour, 100: 2160 * time.Hour} {
+		expiration := validity.Expiration(value, now)
+		if expiration.Sub(now) != period || validity.Period(value, now) != period || !validity.Issuance(expiration, value).Equal(now) {
+			t.Errorf("value %d: unexpected expiration %s", value, expiration)
+		}
+		if err := core.CheckExpiration(expiration, period, now.Add(time.Minute)); err != nil {
+			t.Errorf("value %d: %v", value, err)
+		}
+	}
+
+	// Invalid policies.
+	for _, settings := range [][]string{{"1"}, {"x=1h"}, {"1=forever"}} {
+		if _, err := core.ParseValidityPeriods(settings); !errors.Is(err, core.ErrCoinValidity) {
+			t.Errorf("%q: got %v, want %v", settings, err, core.ErrCoinValidity)
+		}
+	}
+	for _, invalid := range []core.CoinValidity{
+		{Default: -time.Hour},
+		{Default: core.MaxCoinValidity + time.Hour},
+		{Periods: map[int64]time.Duration{1: 0}},
+		{Periods: map[int64]time.Duration{-1: time.Hour}},
+		{Grace: -time.Hour},
+	} {
+		if err := invalid.Validate(); !errors.Is(err, core.ErrCoinValidity) {
+			t.Errorf("%+v: got %v, want %v", invalid, err, core.ErrCoinValidity)
+		}
+	}
+
+	// Expirations disagreeing with their period or the clock.
+	expiration = now.Add(168 * time.Hour)
+	for _, check := range []struct {
+		period time.Duration
+		now    time.Time
+	}{
+		{720 * time.Hour, now},
+		{168 * time.Hour, now.Add(time.Hour)},
+		{core.MaxCoinValidity + time.Hour, now},
+		{0, expiration.Add(time.Second)},
+	} {
+		if err := core.CheckExpiration(expiration, check.period, check.now); !errors.Is(err, core.ErrCoinValidity) {
+			t.Errorf("%s at %s: got %v, want %v", check.period, check.now, err, core.ErrCoinValidity)
+		}
+	}
+	if err := core.CheckExpiration(expiration, 0, now); err != nil {
+		t.Errorf("earlier version: %v", err)
+	}
+}
+
+func TestWork(t *testing.T) {
+	// Create bank and client.
+	bank := new(core.Bank).New(nil, core.Params)
+	client := new(core.Client).New(nil, bank.Profile())
+
+	// No work.
+	challenge, err := core.NewWorkChallenge(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.Verify(client.Profile(), 0) {
+		t.Fatal("challenge without work not verified")
+	}
+
+	// Solve & Verify.
+	challenge, err = core.NewWorkChallenge(nil, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	solution := challenge.Solve(client.Profile())
+	if !challenge.Verify(client.Profile(), solution) {
+		t.Fatal("solution not verified")
+	}
+
+	// Harder challenges aren't solved by the solution.
+	harder := &core.WorkChallenge{Nonce: challenge.Nonce, Bits: 257}
+	if harder.Verify(client.Profile(), solution) {
+		t.Fatal("solution verified for a harder challenge")
+	}
+}
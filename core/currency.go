@@ -0,0 +1,123 @@
+package core
+
+import (
+	"io"
+	"math/big"
+)
+
+//
+// CURRENCY
+//
+
+// 1. A Bank issues coins in several currencies, each signed with its own mint key (an RSA key). Every mint shares the
+//		bank's identity (Priv, Pub), so a client's credentials are valid for all of them.
+// 2. A Client computes its coin requests using the mint profile of the wanted currency.
+// 3. A coin is verified using the mint profile of its currency, a coin of one currency fails the verification of any
+//		other.
+// 4. At exchange, coins of one currency are exchanged for coins of another at the bank's Rate.
+// Banks, coins and profiles created before the poly-currency support are in DefaultCurrency.
+
+// DefaultCurrency is the currency of a bank's primary key.
+const DefaultCurrency = "ZIB"
+
+// NormalizeCurrency returns code, or DefaultCurrency if code is empty.
+func NormalizeCurrency(code string) string {
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
+}
+
+// ValidateCurrency validates a currency code, three uppercase letters. (ISO 4217 style)
+func ValidateCurrency(code string) error {
+	if len(code) != 3 {
+		return ErrCurrency
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ErrCurrency
+		}
+	}
+	return nil
+}
+
+// NewMint allocates and returns a new Bank sharing bank's identity, with a new RSA key issuing coins in currency.
+func (bank *Bank) NewMint(random io.Reader, currency string) (*Bank, error) {
+	// Check for valid currency.
+	if err := ValidateCurrency(currency); err != nil {
+		return nil, err
+	}
+	if currency == NormalizeCurrency(bank.Currency) {
+		return nil, ErrMintMismatch
+	}
+
+	// Generate RSA key.
+	key := new(RsaKey).New(random)
+	if key == nil {
+		return nil, ErrMintKey
+	}
+
+	return &Bank{
+		Scheme:   bank.Scheme,
+		Key:      *key,
+		Priv:     bank.Priv,
+		Pub:      bank.Pub,
+		Currency: currency,
+	}, nil
+}
+
+// Mint allocates and returns a copy of client computing coin requests using mint, a mint profile of client's bank.
+func (client *Client) Mint(mint *BankProfile) (*Client, error) {
+	// Check that mint shares the bank's identity.
+	if mint.Pub == nil || mint.Pub.Cmp(client.Bank.Pub) != 0 || mint.Scheme.P.Cmp(client.Bank.Scheme.P) != 0 {
+		return nil, ErrMintMismatch
+	}
+
+	minted := *client
+	minted.Bank = *mint
+	return &minted, nil
+}
+
+// NewRate allocates and returns a new Rate from currency from to currency to, num/den.
+func NewRate(from, to string, num, den int64) (*Rate, error) {
+	rate := &Rate{From: NormalizeCurrency(from), To: NormalizeCurrency(to), Num: num, Den: den}
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
+
+// Identity returns the rate of currency to itself, 1/1.
+func Identity(currency string) *Rate {
+	return &Rate{From: NormalizeCurrency(currency), To: NormalizeCurrency(currency), Num: 1, Den: 1}
+}
+
+// Validate validates rate, between two valid currencies with a positive ratio.
+func (rate *Rate) Validate() error {
+	if err := ValidateCurrency(rate.From); err != nil {
+		return err
+	}
+	if err := ValidateCurrency(rate.To); err != nil {
+		return err
+	}
+	if rate.Num < 1 || rate.Den < 1 {
+		return ErrRate
+	}
+	return nil
+}
+
+// Convert returns value in From converted to To. Returns ErrConversion if it isn't worth a whole value in To.
+func (rate *Rate) Convert(value int64) (int64, error) {
+	if rate.Num < 1 || rate.Den < 1 {
+		return 0, ErrRate
+	}
+	converted, remainder := new(big.Int).QuoRem(
+		new(big.Int).Mul(big.NewInt(value), big.NewInt(rate.Num)),
+		big.NewInt(rate.Den),
+		new(big.Int),
+	)
+	if remainder.Sign() != 0 || !converted.IsInt64() {
+		return 0, ErrConversion
+	}
+	return converted.Int64(), nil
+}
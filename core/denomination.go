@@ -0,0 +1,105 @@
+package core
+
+import (
+	"sort"
+)
+
+//
+// DENOMINATIONS
+//
+
+// 1. A wallet keeps its coins in a mix of denominations, e.g. 1s/5s/10s. An amount is withdrawn as coins of those
+//		denominations. (See Breakdown)
+// 2. A payment of an amount is covered exactly by a subset of the wallet's coins, whenever there is one. (See Cover)
+// 3. Otherwise a larger coin is exchanged for coins of the denominations first, so that the payment can be covered
+//		exactly.
+
+// DefaultDenominations is the mix of coin values a wallet keeps unless told otherwise.
+var DefaultDenominations = []int64{1, 5, 10}
+
+// ValidateDenominations validates a mix of coin values, distinct positive values including 1. (So that every amount
+// can be broken down)
+func ValidateDenominations(denominations []int64) error {
+	seen := make(map[int64]bool, len(denominations))
+	for _, denomination := range denominations {
+		if denomination < 1 || seen[denomination] {
+			return ErrDenominations
+		}
+		seen[denomination] = true
+	}
+	if !seen[1] {
+		return ErrDenominations
+	}
+	return nil
+}
+
+// Breakdown returns the coin values amount is withdrawn as, largest first, using as many of the largest denominations
+// as possible. Returns nil if denominations is invalid.
+func Breakdown(amount int64, denominations []int64) []int64 {
+	if ValidateDenominations(denominations) != nil {
+		return nil
+	}
+
+	// Largest denomination first.
+	sorted := append([]int64(nil), denominations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	var values []int64
+	for _, denomination := range sorted {
+		for ; amount >= denomination; amount -= denomination {
+			values = append(values, denomination)
+		}
+	}
+	return values
+}
+
+// Cover returns the indices of the fewest values summing to amount exactly. Returns nil if no subset of values does.
+func Cover(values []int64, amount int64) []int {
+	var total int64
+	for _, value := range values {
+		total += NormalizeValue(value)
+	}
+	if amount < 1 || amount > total {
+		return nil
+	}
+
+	// cover[s] is the fewest values summing to s found so far. (0/1 knapsack over the reachable sums)
+	cover := map[int64][]int{0: {}}
+	for i, value := range values {
+		value = NormalizeValue(value)
+
+		// Extend the sums reached before this value only, each value is used once.
+		sums := make([]int64, 0, len(cover))
+		for s := range cover {
+			sums = append(sums, s)
+		}
+		sort.Slice(sums, func(i, j int) bool { return sums[i] > sums[j] })
+		for _, s := range sums {
+			prev := cover[s]
+			if s+value > amount {
+				continue
+			}
+			if next, ok := cover[s+value]; ok && len(next) <= len(prev)+1 {
+				continue
+			}
+			cover[s+value] = append(append([]int(nil), prev...), i)
+		}
+	}
+	return cover[amount]
+}
+
+// SplitPoint returns the split to exchange a coin of value for, to be closer to covering amount: amount itself if
+// value is larger, otherwise the largest denomination below value. Returns 0 if a coin of value can't be split.
+func SplitPoint(value, amount int64, denominations []int64) int64 {
+	value = NormalizeValue(value)
+	if value > amount && amount > 0 {
+		return amount
+	}
+	var split int64
+	for _, denomination := range denominations {
+		if denomination < value && denomination > split {
+			split = denomination
+		}
+	}
+	return split
+}
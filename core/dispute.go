@@ -0,0 +1,68 @@
+package core
+
+//
+// DISPUTE
+//
+
+// 1. When a coin is surrendered again, the bank keeps both versions of it: the one accepted first and the other one.
+//		Both are signed by its mint, and each stamp (Msg) is signed by the coin's Elgamal key (Second).
+// 2. When a coin isn't signed by its mint, the bank keeps the coin refused.
+// 3. Along with the public profile of the mint, the dispute is self-contained: a third party checks the mint against
+//		the bank's published profile, and the coins against the mint with Verify.
+
+// Dispute kinds.
+const (
+	// DisputeDoubleSpend disputes hold the two versions of a coin surrendered twice.
+	DisputeDoubleSpend = 1
+
+	// DisputeInvalidCoin disputes hold a coin whose signature by its mint doesn't verify.
+	DisputeInvalidCoin = 2
+)
+
+// NewDoubleSpend returns the dispute of the coin signed by mint, surrendered as spent and again as attempt.
+func NewDoubleSpend(mint *BankProfile, spent, attempt *CoinProfile) *Dispute {
+	return &Dispute{
+		Kind:  DisputeDoubleSpend,
+		Mint:  *mint,
+		Coins: []CoinProfile{*spent, *attempt},
+		Date:  Now().UTC(),
+	}
+}
+
+// NewInvalidCoin returns the dispute of coin, not signed by mint.
+func NewInvalidCoin(mint *BankProfile, coin *CoinProfile) *Dispute {
+	return &Dispute{
+		Kind:  DisputeInvalidCoin,
+		Mint:  *mint,
+		Coins: []CoinProfile{*coin},
+		Date:  Now().UTC(),
+	}
+}
+
+// Verify verifies dispute against its mint: a double spend's versions are the same coin, signed by the mint, with
+// valid stamps; an invalid coin isn't signed by the mint.
+func (dispute *Dispute) Verify() error {
+	switch dispute.Kind {
+	case DisputeDoubleSpend:
+		if len(dispute.Coins) != 2 || dispute.Coins[0].Hash() != dispute.Coins[1].Hash() {
+			return ErrDispute
+		}
+		for _, coin := range dispute.Coins {
+			if !coin.VerifyProperties(&dispute.Mint) {
+				return ErrDispute
+			}
+			// Unstamped coins are surrendered at Exchange.
+			if coin.Msg != nil && !coin.VerifyElgamal(&dispute.Mint, coin.Second) {
+				return ErrDispute
+			}
+		}
+		return nil
+	case DisputeInvalidCoin:
+		if len(dispute.Coins) != 1 || dispute.Coins[0].VerifyProperties(&dispute.Mint) {
+			return ErrDispute
+		}
+		return nil
+	default:
+		return ErrDispute
+	}
+}
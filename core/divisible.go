@@ -0,0 +1,106 @@
+package core
+
+import (
+	"crypto/rand"
+	"io"
+	"log"
+	"math/big"
+	"time"
+)
+
+//
+// DIVISIBLE COINS
+//
+
+// 1. A Client withdraws a coin of value V, the Bank signs the value into the coin along with its expiration date.
+// 2. At payment, the Spender may spend part of the value. It computes a request for the remainder coin and sends a
+//		Change, the spent amount, the remainder request and the digest of a claim secret, which the Merchant stamps into
+//		the Elgamal's message along with the memo.
+// 3. At deposit, the Bank credits the Merchant with the spent amount and keeps the remainder request.
+// 4. The Spender collects the remainder coin, of value V - amount, at the Bank by presenting the claim secret.
+// The remainder request is blinded like any other, the remainder coin is unlinkable to the spent coin.
+// Coins of value 1 are signed as before, coins withdrawn before the divisible coins have value 1.
+
+// NormalizeValue returns value, or 1 if value is 0. (Coins before the divisible coins)
+func NormalizeValue(value int64) int64 {
+	if value == 0 {
+		return 1
+	}
+	return value
+}
+
+// valueDigest computes the full-domain hash of a coin's expiration date and value. Coins of value 1 use
+// expirationDigest alone.
+func valueDigest(Expiration time.Time, value int64, N *big.Int) *big.Int {
+	if NormalizeValue(value) == 1 {
+		return expirationDigest(Expiration, N)
+	}
+	t := newTranscript("ziba/coin/value").date(Expiration).number(big.NewInt(value))
+	return fullDomainHash("ziba/coin/tv", t.buffer.Bytes(), N)
+}
+
+// SetValue sets the value of coin, to be signed by the bank, and returns it.
+func (coin *Coin) SetValue(value int64) *Coin {
+	coin.Params.Value = value
+	return coin
+}
+
+// ValidateValue validates a coin value requested from bank.
+func ValidateValue(value int64) error {
+	if value < 1 {
+		return ErrCoinValue
+	}
+	return nil
+}
+
+// NewChange allocates and returns a new Change spending amount, for the remainder coin request remainder, along with
+// its claim secret.
+func NewChange(random io.Reader, amount int64, remainder *Coin) (change *Change, claim *big.Int) {
+	// Upper bound for secrets (2^256).
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	// Generate claim secret.
+	claim, err := rand.Int(source(random), max)
+	if err != nil {
+		log.Printf("failed to generate claim secret")
+		return nil, nil
+	}
+
+	change = &Change{
+		Amount: amount,
+		ALower: remainder.Params.ALower,
+		C:      remainder.Params.C,
+		Claim:  escrowDigest(claim),
+	}
+
+	return change, claim
+}
+
+// Verify verifies that change spends part of coin's value.
+func (change *Change) Verify(coin *CoinProfile) error {
+	if change.Amount < 1 || change.Amount >= NormalizeValue(coin.Value) {
+		return ErrChangeAmount
+	}
+	return nil
+}
+
+// Remainder returns the value of the remainder coin of change, for coin.
+func (change *Change) Remainder(coin *CoinProfile) int64 {
+	return NormalizeValue(coin.Value) - change.Amount
+}
+
+// VerifyClaim verifies claim is the claim secret of change.
+func (change *Change) VerifyClaim(claim *big.Int) error {
+	if claim == nil || escrowDigest(claim).Cmp(change.Claim) != 0 {
+		return ErrChangeClaim
+	}
+	return nil
+}
+
+// Credit returns the value the payee of coin is credited with, given the memo coin was paid with (if any).
+func (coin *CoinProfile) Credit(memo *Memo) int64 {
+	if memo != nil && memo.Change != nil {
+		return memo.Change.Amount
+	}
+	return NormalizeValue(coin.Value)
+}
@@ -2,8 +2,70 @@ package core
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
 	ErrIdentityMismatch = errors.New("ziba/core: verification error at IdentityHash")
+	ErrEscrowRelease    = errors.New("ziba/core: verification error at Escrow release")
+	ErrEscrowRefund     = errors.New("ziba/core: verification error at Escrow refund")
+	ErrEscrowExpired    = errors.New("ziba/core: escrow timeout has passed")
+	ErrEscrowPending    = errors.New("ziba/core: escrow timeout has not passed")
+	ErrShareThreshold   = errors.New("ziba/core: invalid share threshold")
+	ErrShareCount       = errors.New("ziba/core: not enough shares")
+	ErrShareMismatch    = errors.New("ziba/core: shares belong to different banks")
+	ErrShareInvalid     = errors.New("ziba/core: verification error at reconstructed bank")
+	ErrShareSecret      = errors.New("ziba/core: secret too large to be shared")
+	ErrPartialInvalid   = errors.New("ziba/core: verification error at combined coin response")
+	ErrMissingValue     = errors.New("ziba/core: missing value")
+	ErrOutOfRange       = errors.New("ziba/core: value out of range")
+	ErrNonResidue       = errors.New("ziba/core: value outside the subgroup of order q")
+	ErrValueSize        = errors.New("ziba/core: value too large")
+	ErrSafePrimeBits    = errors.New("ziba/core: safe prime bit length out of range")
+	ErrSafePrime        = errors.New("ziba/core: not a safe prime")
+	ErrSchemeMismatch   = errors.New("ziba/core: scheme parameters don't match their fingerprint")
+	ErrBankMismatch     = errors.New("ziba/core: bank's keys don't match its stored profile")
+	ErrEncoding         = errors.New("ziba/core: invalid encoding")
+	ErrEncodingVersion  = errors.New("ziba/core: unknown encoding version")
+	ErrMemoLength       = errors.New("ziba/core: memo too long")
+	ErrCurrency         = errors.New("ziba/core: invalid currency code")
+	ErrMintMismatch     = errors.New("ziba/core: mint doesn't belong to the bank")
+	ErrMintKey          = errors.New("ziba/core: failed to generate mint key")
+	ErrCoinValue        = errors.New("ziba/core: invalid coin value")
+	ErrChangeAmount     = errors.New("ziba/core: invalid change amount")
+	ErrChangeClaim      = errors.New("ziba/core: verification error at Change claim")
+	ErrDenominations    = errors.New("ziba/core: invalid denominations")
+	ErrRate             = errors.New("ziba/core: invalid exchange rate")
+	ErrConversion       = errors.New("ziba/core: value isn't worth a whole value in the target currency")
+	ErrGiftToken        = errors.New("ziba/core: invalid claim token")
+	ErrAuthorization    = errors.New("ziba/core: verification error at Deposit authorization")
+	ErrPaymentDeclined  = errors.New("ziba/core: payment declined")
+	ErrAttestation      = errors.New("ziba/core: verification error at certificate Attestation")
+	ErrWithdrawalSigned = errors.New("ziba/core: verification error at Withdrawal request")
+	ErrClosureSigned    = errors.New("ziba/core: verification error at Closure request")
+	ErrTransferSigned   = errors.New("ziba/core: verification error at Transfer request")
+	ErrRenewalSigned    = errors.New("ziba/core: verification error at Renewal request")
+	ErrSetupSignature   = errors.New("ziba/core: verification error at Setup bundle")
+	ErrCoinValidity     = errors.New("ziba/core: invalid coin validity period")
+	ErrCoinExpired      = errors.New("ziba/core: coin has expired")
+	ErrDispute          = errors.New("ziba/core: verification error at Dispute")
 )
+
+// ValidationError records a received value rejected by the validation layer.
+type ValidationError struct {
+	// Field is the name of the rejected value.
+	Field string
+
+	// Err is the reason: ErrMissingValue, ErrValueSize, ErrOutOfRange, ErrNonResidue, ErrSafePrime or ErrCurrency.
+	Err error
+}
+
+// Error.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v (%s)", e.Err, e.Field)
+}
+
+// Unwrap.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"log"
+	"math/big"
+	"time"
+)
+
+//
+// ESCROWED PAYMENT
+//
+
+// 1. The Spender chooses a release secret and a refund secret, and sends their digests along with a coin to the Merchant.
+// 2. The Merchant stamps the coin binding the escrow conditions into the Elgamal's message.
+// 3. The Spender checks the message against the escrow conditions and signs the coin.
+// 4. The Merchant deposits the coin only once the Spender reveals the release secret, before the timeout.
+// 5. After the timeout the Spender can reclaim the coin at the Bank by presenting the refund secret.
+
+// escrowDigest computes the digest of an escrow secret.
+func escrowDigest(secret *big.Int) *big.Int {
+	hashBytes := sha256.Sum256(secret.Bytes())
+	return new(big.Int).SetBytes(hashBytes[:])
+}
+
+// NewEscrow allocates and returns a new Escrow expiring at timeout, along with its release and refund secrets.
+func NewEscrow(random io.Reader, timeout time.Time) (escrow *Escrow, release *big.Int, refund *big.Int) {
+	// Upper bound for secrets (2^256).
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	// Generate release secret.
+	release, err := rand.Int(source(random), max)
+	if err != nil {
+		log.Printf("failed to generate release secret")
+		return nil, nil, nil
+	}
+
+	// Generate refund secret.
+	refund, err = rand.Int(source(random), max)
+	if err != nil {
+		log.Printf("failed to generate refund secret")
+		return nil, nil, nil
+	}
+
+	escrow = &Escrow{
+		Release: escrowDigest(release),
+		Refund:  escrowDigest(refund),
+		Timeout: timeout,
+	}
+
+	return escrow, release, refund
+}
+
+// IsEscrowMsg reports whether msg is an Elgamal's message bound to escrow conditions.
+func IsEscrowMsg(msg *big.Int) bool {
+	return msg != nil && msg.Bit(0) == 1
+}
+
+// Msg computes the Elgamal's message binding coin to the escrow conditions and returns it.
+func (escrow *Escrow) Msg(coin *CoinProfile) *big.Int {
+	// Compute the hash of some coin parameters and the escrow conditions.
+	msg := escrowMsgDigest(coin, escrow)
+
+	// Tag the message as escrowed (lowest bit set).
+	return msg.SetBit(msg, 0, 1)
+}
+
+// StampEscrow computes the Elgamal's message using some transaction parameters and escrow, and returns it.
+func (coin *CoinProfile) StampEscrow(bank *BankProfile, client *ClientProfile, escrow *Escrow) (msg *big.Int) {
+	// Compute the current time as the transaction date (t).
+	escrow.Payee = client.TradeId
+	escrow.Date = Now()
+
+	// Compute the Elgamal message (d).
+	msg = escrow.Msg(coin)
+
+	coin.Msg = msg
+
+	return
+}
+
+// VerifyEscrow verifies that coin is signed to escrow and returns a success bool.
+func (coin *CoinProfile) VerifyEscrow(bank *BankProfile, escrow *Escrow) bool {
+	// Check for complete escrow conditions.
+	if escrow == nil || escrow.Release == nil || escrow.Refund == nil || escrow.Payee == nil {
+		return false
+	}
+
+	// Check the message binds the escrow conditions.
+	if coin.Msg == nil || coin.Msg.Cmp(escrow.Msg(coin)) != 0 {
+		return false
+	}
+
+	// Check the Elgamal's signature on the message.
+	return coin.Second != nil && coin.VerifyElgamal(bank, coin.Second)
+}
+
+// VerifyRelease verifies release is the release secret of escrow at date now.
+func (escrow *Escrow) VerifyRelease(release *big.Int, now time.Time) error {
+	if release == nil || escrowDigest(release).Cmp(escrow.Release) != 0 {
+		return ErrEscrowRelease
+	}
+	if !now.Before(escrow.Timeout) {
+		return ErrEscrowExpired
+	}
+	return nil
+}
+
+// VerifyRefund verifies refund is the refund secret of escrow at date now.
+func (escrow *Escrow) VerifyRefund(refund *big.Int, now time.Time) error {
+	if refund == nil || escrowDigest(refund).Cmp(escrow.Refund) != 0 {
+		return ErrEscrowRefund
+	}
+	if now.Before(escrow.Timeout) {
+		return ErrEscrowPending
+	}
+	return nil
+}
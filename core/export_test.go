@@ -0,0 +1,12 @@
+package core
+
+import "math/big"
+
+// FixedBaseExp computes base^x (mod p) over the cached table of base, nil if base can't use a table.
+func FixedBaseExp(base, p, q, x *big.Int) *big.Int {
+	fb := lookupFixedBase(base, p, q)
+	if fb == nil {
+		return nil
+	}
+	return fb.exp(x)
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// Save to .json.
+func SaveToFile(data json.Marshaler, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Printf("failed to create file")
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// LoadSchemeParams loads scheme parameters from a .json file, as written by "ziba params generate", and validates them.
+func LoadSchemeParams(filename string) (*SchemeParams, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := new(SchemeParams)
+	if err := LoadFromFile(scheme, file); err != nil {
+		return nil, err
+	}
+	if err := scheme.Validate(); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+//
+// FIXED-BASE EXPONENTIATION
+//
+
+// Most protocol steps raise one of a few fixed bases (alpha, and the bank's z) to a varying exponent mod p. For a fixed
+// base the powers base^(j * 2^(w*i)) can be precomputed once, then any exponentiation takes one multiplication per
+// w-bit window of the exponent instead of a full square-and-multiply.
+//
+// Both alpha and z lie in the subgroup of order q, so exponents are reduced mod q first. Tables are only built for
+// bases verified to lie in that subgroup, other bases fall back to big.Int.Exp.
+
+const (
+	// fixedBaseWindow is the window size in bits.
+	fixedBaseWindow = 6
+
+	// fixedBaseCacheSize bounds the number of cached tables. (One for alpha, one per known bank)
+	fixedBaseCacheSize = 16
+)
+
+// fixedBase is a precomputed table for exponentiations of base mod p, with exponents reduced mod q.
+type fixedBase struct {
+	base *big.Int
+	p    *big.Int
+	q    *big.Int
+	mod  *modulus // p
+
+	// table[i][j] = base^(j * 2^(w*i)) (mod p).
+	table [][]*big.Int
+}
+
+// fixedBaseCache holds the tables built so far.
+var fixedBaseCache struct {
+	sync.Mutex
+	tables []*fixedBase
+}
+
+// newFixedBase allocates and returns the table for base, or nil if base isn't in the subgroup of order q. The table
+// keeps copies of base, p and q, its cache keys, so that callers changing theirs don't change it.
+func newFixedBase(base, p, q *big.Int) *fixedBase {
+	base, p, q = new(big.Int).Set(base), new(big.Int).Set(p), new(big.Int).Set(q)
+
+	// Verify base^q = 1 (mod p).
+	if base.Sign() <= 0 || base.Cmp(p) >= 0 || new(big.Int).Exp(base, q, p).Cmp(big.NewInt(1)) != 0 {
+		return nil
+	}
+
+	rows := (q.BitLen() + fixedBaseWindow - 1) / fixedBaseWindow
+	fb := &fixedBase{base: base, p: p, q: q, mod: lookupModulus(p), table: make([][]*big.Int, rows)}
+	power := new(big.Int).Set(base) // base^(2^(w*i))
+	for i := range fb.table {
+		row := make([]*big.Int, 1<<fixedBaseWindow)
+		row[0] = big.NewInt(1)
+		for j := 1; j < len(row); j++ {
+			row[j] = fb.mod.mulMod(new(big.Int), row[j-1], power)
+		}
+		fb.table[i] = row
+		power = fb.mod.mulMod(new(big.Int), row[len(row)-1], power)
+	}
+
+	return fb
+}
+
+// exp computes base^x (mod p).
+func (fb *fixedBase) exp(x *big.Int) *big.Int {
+	exponent := getInt()
+	defer putInt(exponent)
+
+	words := exponent.Mod(x, fb.q).Bits()
+	result := big.NewInt(1)
+	mask := big.Word(1<<fixedBaseWindow - 1)
+	for i := range fb.table {
+		// Extract the i-th window of the exponent.
+		word, offset := i*fixedBaseWindow/bits.UintSize, uint(i*fixedBaseWindow%bits.UintSize)
+		if word >= len(words) {
+			break
+		}
+		j := words[word] >> offset
+		if offset+fixedBaseWindow > bits.UintSize && word+1 < len(words) {
+			j |= words[word+1] << (bits.UintSize - offset)
+		}
+		if j &= mask; j != 0 {
+			fb.mod.mulMod(result, result, fb.table[i][j])
+		}
+	}
+	return result
+}
+
+// cachedFixedBase returns the cached table for base mod p, nil if there's none.
+func cachedFixedBase(base, p *big.Int) *fixedBase {
+	fixedBaseCache.Lock()
+	defer fixedBaseCache.Unlock()
+	for _, fb := range fixedBaseCache.tables {
+		if fb.base.Cmp(base) == 0 && fb.p.Cmp(p) == 0 {
+			return fb
+		}
+	}
+	return nil
+}
+
+// lookupFixedBase returns the cached table for base mod p, building it if needed. Returns nil if base can't use a
+// table.
+func lookupFixedBase(base, p, q *big.Int) *fixedBase {
+	if base == nil || p == nil || q == nil {
+		return nil
+	}
+
+	fixedBaseCache.Lock()
+	defer fixedBaseCache.Unlock()
+	for _, fb := range fixedBaseCache.tables {
+		if fb.base.Cmp(base) == 0 && fb.p.Cmp(p) == 0 && fb.q.Cmp(q) == 0 {
+			return fb
+		}
+	}
+	if len(fixedBaseCache.tables) >= fixedBaseCacheSize {
+		return nil
+	}
+	fb := newFixedBase(base, p, q)
+	if fb != nil {
+		fixedBaseCache.tables = append(fixedBaseCache.tables, fb)
+	}
+	return fb
+}
+
+// expG computes alpha^x (mod p).
+func (scheme *SchemeParams) expG(x *big.Int) *big.Int {
+	if fb := lookupFixedBase(scheme.G, scheme.P, scheme.Q); fb != nil {
+		return fb.exp(x)
+	}
+	return new(big.Int).Exp(scheme.G, x, scheme.P)
+}
+
+// expPub computes z^x (mod p).
+func (bank *BankProfile) expPub(x *big.Int) *big.Int {
+	if fb := lookupFixedBase(bank.Pub, bank.Scheme.P, bank.Scheme.Q); fb != nil {
+		return fb.exp(x)
+	}
+	return new(big.Int).Exp(bank.Pub, x, bank.Scheme.P)
+}
+
+// Precompute builds the exponentiation tables for scheme, so that the first protocol step doesn't pay for them.
+func (scheme *SchemeParams) Precompute() *SchemeParams {
+	lookupFixedBase(scheme.G, scheme.P, scheme.Q)
+	return scheme
+}
+
+// Precompute builds the exponentiation tables for bank, so that the first protocol step doesn't pay for them.
+func (bank *BankProfile) Precompute() *BankProfile {
+	bank.Scheme.Precompute()
+	lookupFixedBase(bank.Pub, bank.Scheme.P, bank.Scheme.Q)
+	return bank
+}
+
+// intPool recycles the temporary big.Ints of the hot paths, along with their backing arrays, to spare the bank's
+// garbage collector under load.
+var intPool = sync.Pool{
+	New: func() any { return new(big.Int) },
+}
+
+// getInt returns a temporary big.Int of intPool, of any value. To be put back with putInt once no longer used.
+func getInt() *big.Int {
+	return intPool.Get().(*big.Int)
+}
+
+// putInt puts the temporary big.Ints xs back into intPool.
+func putInt(xs ...*big.Int) {
+	for _, x := range xs {
+		intPool.Put(x)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"time"
 )
 
 //
@@ -59,6 +60,7 @@ func (bank Bank) String() string {
 	b.WriteString(bank.Key.String())
 	b.WriteString(fmt.Sprintf("# Priv: %s\n", formatBigInt(bank.Priv, 100)))
 	b.WriteString(fmt.Sprintf("# Pub:  %s\n", formatBigInt(bank.Pub, 100)))
+	b.WriteString(fmt.Sprintf("# Currency: %s\n", NormalizeCurrency(bank.Currency)))
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -71,6 +73,7 @@ func (profile BankProfile) String() string {
 	b.WriteString(fmt.Sprintf("# Pub: %s\n", formatBigInt(profile.Pub, 100)))
 	b.WriteString(fmt.Sprintf("# N:   %s\n", formatBigInt(profile.N, 100)))
 	b.WriteString(fmt.Sprintf("# E:   %s\n", formatBigInt(profile.E, 100)))
+	b.WriteString(fmt.Sprintf("# Currency: %s\n", NormalizeCurrency(profile.Currency)))
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -86,6 +89,7 @@ func (client Client) String() string {
 	b.WriteString(fmt.Sprintf("# Pub:        %s\n", formatBigInt(client.Pub, 100)))
 	b.WriteString(fmt.Sprintf("# Credential: %s\n", formatBigInt(client.Credential, 100)))
 	b.WriteString(fmt.Sprintf("# Contract:   %s\n", formatBigInt(client.Contract, 100)))
+	b.WriteString(fmt.Sprintf("# Expiration: %s\n", client.Expiration))
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -113,6 +117,7 @@ func (client ClientInfo) String() string {
 	b.WriteString(fmt.Sprintf("# S:          %s\n", formatBigInt(client.S, 100)))
 	b.WriteString(fmt.Sprintf("# Credential: %s\n", formatBigInt(client.Credential, 100)))
 	b.WriteString(fmt.Sprintf("# Contract:   %s\n", formatBigInt(client.Contract, 100)))
+	b.WriteString(fmt.Sprintf("# Expiration: %s\n", client.Expiration))
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -158,6 +163,9 @@ func (params CoinParams) String() string {
 	b.WriteString(fmt.Sprintf("# Expiration: %s\n", params.Expiration))
 	b.WriteString(fmt.Sprintf("# R:          %s\n", formatBigInt(params.R, 100)))
 	b.WriteString(fmt.Sprintf("# A2:         %s\n", formatBigInt(params.A2, 100)))
+	b.WriteString(fmt.Sprintf("# Version:    %d\n", params.Version))
+	b.WriteString(fmt.Sprintf("# Currency:   %s\n", NormalizeCurrency(params.Currency)))
+	b.WriteString(fmt.Sprintf("# Value:      %d\n", NormalizeValue(params.Value)))
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -185,6 +193,64 @@ func (profile CoinProfile) String() string {
 	b.WriteString(fmt.Sprintf("# Expiration: %s\n", profile.Expiration))
 	b.WriteString(fmt.Sprintf("# Second:     %s\n", formatBigInt(profile.Second, 100)))
 	b.WriteString(fmt.Sprintf("# Msg:        %s\n", formatBigInt(profile.Msg, 100)))
+	b.WriteString(fmt.Sprintf("# Version:    %d\n", profile.Version))
+	b.WriteString(fmt.Sprintf("# Currency:   %s\n", NormalizeCurrency(profile.Currency)))
+	b.WriteString(fmt.Sprintf("# Value:      %d\n", NormalizeValue(profile.Value)))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// String satisfies the fmt.Stringer interface for Escrow.
+func (escrow Escrow) String() string {
+	var b strings.Builder
+	b.WriteString("Escrow {\n")
+	b.WriteString(fmt.Sprintf("# Release: %s\n", formatBigInt(escrow.Release, 100)))
+	b.WriteString(fmt.Sprintf("# Refund:  %s\n", formatBigInt(escrow.Refund, 100)))
+	b.WriteString(fmt.Sprintf("# Timeout: %s\n", escrow.Timeout))
+	b.WriteString(fmt.Sprintf("# Payee:   %s\n", formatBigInt(escrow.Payee, 100)))
+	b.WriteString(fmt.Sprintf("# Date:    %s\n", escrow.Date))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// String satisfies the fmt.Stringer interface for BankShare.
+func (share BankShare) String() string {
+	var b strings.Builder
+	b.WriteString("BankShare {\n")
+	b.WriteString(fmt.Sprintf("# Index:     %d\n", share.Index))
+	b.WriteString(fmt.Sprintf("# Threshold: %d\n", share.Threshold))
+	b.WriteString(share.Profile.String())
+	b.WriteString(fmt.Sprintf("# Priv:      %s\n", formatBigInt(share.Priv, 100)))
+	b.WriteString(fmt.Sprintf("# P:         %s\n", formatBigInt(share.P, 100)))
+	b.WriteString(fmt.Sprintf("# Q:         %s\n", formatBigInt(share.Q, 100)))
+	b.WriteString(fmt.Sprintf("# D:         %s\n", formatBigInt(share.D, 100)))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// String satisfies the fmt.Stringer interface for ThresholdShare.
+func (share ThresholdShare) String() string {
+	var b strings.Builder
+	b.WriteString("ThresholdShare {\n")
+	b.WriteString(fmt.Sprintf("# Index:     %d\n", share.Index))
+	b.WriteString(fmt.Sprintf("# Threshold: %d\n", share.Threshold))
+	b.WriteString(fmt.Sprintf("# Total:     %d\n", share.Total))
+	b.WriteString(share.Profile.String())
+	b.WriteString(fmt.Sprintf("# Priv:      %s\n", formatBigInt(share.Priv, 100)))
+	b.WriteString(fmt.Sprintf("# D:         %s\n", formatBigInt(share.D, 100)))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// String satisfies the fmt.Stringer interface for PartialResponse.
+func (partial PartialResponse) String() string {
+	var b strings.Builder
+	b.WriteString("PartialResponse {\n")
+	b.WriteString(fmt.Sprintf("# Index:     %d\n", partial.Index))
+	b.WriteString(fmt.Sprintf("# Threshold: %d\n", partial.Threshold))
+	b.WriteString(fmt.Sprintf("# Total:     %d\n", partial.Total))
+	b.WriteString(fmt.Sprintf("# A1:        %s\n", formatBigInt(partial.A1, 100)))
+	b.WriteString(fmt.Sprintf("# C1:        %s\n", formatBigInt(partial.C1, 100)))
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -221,3 +287,553 @@ func (s *SchemeParams) UnmarshalJSON(data []byte) error {
 	s.G, _ = new(big.Int).SetString(wrapper.G, 10)
 	return nil
 }
+
+// bankShareJSON represents the JSON-friendly structure for BankShare.
+type bankShareJSON struct {
+	Index     int          `json:"Index"`
+	Threshold int          `json:"Threshold"`
+	Scheme    SchemeParams `json:"Scheme"`
+	Pub       string       `json:"Pub"`
+	N         string       `json:"N"`
+	E         string       `json:"E"`
+	Priv      string       `json:"Priv"`
+	P         string       `json:"P"`
+	Q         string       `json:"Q"`
+	D         string       `json:"D"`
+	Currency  string       `json:"Currency,omitempty"`
+}
+
+// MarshalJSON converts BankShare to JSON format.
+func (share *BankShare) MarshalJSON() ([]byte, error) {
+	wrapper := &bankShareJSON{
+		Index:     share.Index,
+		Threshold: share.Threshold,
+		Scheme:    share.Profile.Scheme,
+		Pub:       share.Profile.Pub.String(),
+		N:         share.Profile.N.String(),
+		E:         share.Profile.E.String(),
+		Priv:      share.Priv.String(),
+		P:         share.P.String(),
+		Q:         share.Q.String(),
+		D:         share.D.String(),
+		Currency:  share.Profile.Currency,
+	}
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates BankShare from JSON data.
+func (share *BankShare) UnmarshalJSON(data []byte) error {
+	var wrapper bankShareJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	share.Index = wrapper.Index
+	share.Threshold = wrapper.Threshold
+	share.Profile.Scheme = wrapper.Scheme
+	share.Profile.Pub, _ = new(big.Int).SetString(wrapper.Pub, 10)
+	share.Profile.N, _ = new(big.Int).SetString(wrapper.N, 10)
+	share.Profile.E, _ = new(big.Int).SetString(wrapper.E, 10)
+	share.Profile.Currency = wrapper.Currency
+	share.Priv, _ = new(big.Int).SetString(wrapper.Priv, 10)
+	share.P, _ = new(big.Int).SetString(wrapper.P, 10)
+	share.Q, _ = new(big.Int).SetString(wrapper.Q, 10)
+	share.D, _ = new(big.Int).SetString(wrapper.D, 10)
+	return nil
+}
+
+// thresholdShareJSON represents the JSON-friendly structure for ThresholdShare.
+type thresholdShareJSON struct {
+	Index     int          `json:"Index"`
+	Threshold int          `json:"Threshold"`
+	Total     int          `json:"Total"`
+	Scheme    SchemeParams `json:"Scheme"`
+	Pub       string       `json:"Pub"`
+	N         string       `json:"N"`
+	E         string       `json:"E"`
+	Priv      string       `json:"Priv"`
+	D         string       `json:"D"`
+}
+
+// MarshalJSON converts ThresholdShare to JSON format.
+func (share *ThresholdShare) MarshalJSON() ([]byte, error) {
+	wrapper := &thresholdShareJSON{
+		Index:     share.Index,
+		Threshold: share.Threshold,
+		Total:     share.Total,
+		Scheme:    share.Profile.Scheme,
+		Pub:       share.Profile.Pub.String(),
+		N:         share.Profile.N.String(),
+		E:         share.Profile.E.String(),
+		Priv:      share.Priv.String(),
+		D:         share.D.String(),
+	}
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates ThresholdShare from JSON data.
+func (share *ThresholdShare) UnmarshalJSON(data []byte) error {
+	var wrapper thresholdShareJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	share.Index = wrapper.Index
+	share.Threshold = wrapper.Threshold
+	share.Total = wrapper.Total
+	share.Profile.Scheme = wrapper.Scheme
+	share.Profile.Pub, _ = new(big.Int).SetString(wrapper.Pub, 10)
+	share.Profile.N, _ = new(big.Int).SetString(wrapper.N, 10)
+	share.Profile.E, _ = new(big.Int).SetString(wrapper.E, 10)
+	share.Priv, _ = new(big.Int).SetString(wrapper.Priv, 10)
+	share.D, _ = new(big.Int).SetString(wrapper.D, 10)
+	return nil
+}
+
+// jsonNumber converts x to a decimal string, empty if x is nil.
+func jsonNumber(x *big.Int) string {
+	if x == nil {
+		return ""
+	}
+	return x.String()
+}
+
+// jsonParser parses decimal strings. The first error is kept.
+type jsonParser struct {
+	err error
+}
+
+// number parses s, returning nil if s is empty.
+func (p *jsonParser) number(s string) *big.Int {
+	if s == "" || p.err != nil {
+		return nil
+	}
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		p.err = ErrEncoding
+		return nil
+	}
+	return x
+}
+
+// rsaKeyJSON represents the JSON-friendly structure for RsaKey.
+type rsaKeyJSON struct {
+	P string `json:"P,omitempty"`
+	Q string `json:"Q,omitempty"`
+	N string `json:"N,omitempty"`
+	D string `json:"D,omitempty"`
+	E string `json:"E,omitempty"`
+}
+
+// newRsaKeyJSON.
+func newRsaKeyJSON(key *RsaKey) rsaKeyJSON {
+	return rsaKeyJSON{
+		P: jsonNumber(key.P),
+		Q: jsonNumber(key.Q),
+		N: jsonNumber(key.N),
+		D: jsonNumber(key.D),
+		E: jsonNumber(key.E),
+	}
+}
+
+// parse.
+func (wrapper *rsaKeyJSON) parse(p *jsonParser) RsaKey {
+	return RsaKey{
+		P: p.number(wrapper.P),
+		Q: p.number(wrapper.Q),
+		N: p.number(wrapper.N),
+		D: p.number(wrapper.D),
+		E: p.number(wrapper.E),
+	}
+}
+
+// bankProfileJSON represents the JSON-friendly structure for BankProfile.
+type bankProfileJSON struct {
+	Scheme   SchemeParams `json:"Scheme"`
+	Pub      string       `json:"Pub,omitempty"`
+	N        string       `json:"N,omitempty"`
+	E        string       `json:"E,omitempty"`
+	Currency string       `json:"Currency,omitempty"`
+}
+
+// newBankProfileJSON.
+func newBankProfileJSON(bank *BankProfile) bankProfileJSON {
+	return bankProfileJSON{
+		Scheme:   bank.Scheme,
+		Pub:      jsonNumber(bank.Pub),
+		N:        jsonNumber(bank.N),
+		E:        jsonNumber(bank.E),
+		Currency: bank.Currency,
+	}
+}
+
+// parse.
+func (wrapper *bankProfileJSON) parse(p *jsonParser) BankProfile {
+	return BankProfile{
+		Scheme:   wrapper.Scheme,
+		Pub:      p.number(wrapper.Pub),
+		N:        p.number(wrapper.N),
+		E:        p.number(wrapper.E),
+		Currency: wrapper.Currency,
+	}
+}
+
+// clientProfileJSON represents the JSON-friendly structure for ClientProfile.
+type clientProfileJSON struct {
+	PrivStamp    string `json:"PrivStamp,omitempty"`
+	IdentityHash string `json:"IdentityHash,omitempty"`
+	TradeId      string `json:"TradeId,omitempty"`
+	Pub          string `json:"Pub,omitempty"`
+	N            string `json:"N,omitempty"`
+	E            string `json:"E,omitempty"`
+}
+
+// newClientProfileJSON.
+func newClientProfileJSON(client *ClientProfile) clientProfileJSON {
+	return clientProfileJSON{
+		PrivStamp:    jsonNumber(client.PrivStamp),
+		IdentityHash: jsonNumber(client.IdentityHash),
+		TradeId:      jsonNumber(client.TradeId),
+		Pub:          jsonNumber(client.Pub),
+		N:            jsonNumber(client.N),
+		E:            jsonNumber(client.E),
+	}
+}
+
+// parse.
+func (wrapper *clientProfileJSON) parse(p *jsonParser) ClientProfile {
+	return ClientProfile{
+		PrivStamp:    p.number(wrapper.PrivStamp),
+		IdentityHash: p.number(wrapper.IdentityHash),
+		TradeId:      p.number(wrapper.TradeId),
+		Pub:          p.number(wrapper.Pub),
+		N:            p.number(wrapper.N),
+		E:            p.number(wrapper.E),
+	}
+}
+
+// MarshalJSON converts ClientProfile to JSON format.
+func (client *ClientProfile) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newClientProfileJSON(client))
+}
+
+// UnmarshalJSON populates ClientProfile from JSON data.
+func (client *ClientProfile) UnmarshalJSON(data []byte) error {
+	var wrapper clientProfileJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	var p jsonParser
+	decoded := wrapper.parse(&p)
+	if p.err != nil {
+		return p.err
+	}
+	*client = decoded
+	return nil
+}
+
+// bankJSON represents the JSON-friendly structure for Bank.
+type bankJSON struct {
+	Scheme   SchemeParams `json:"Scheme"`
+	Key      rsaKeyJSON   `json:"Key"`
+	Priv     string       `json:"Priv,omitempty"`
+	Pub      string       `json:"Pub,omitempty"`
+	Currency string       `json:"Currency,omitempty"`
+}
+
+// MarshalJSON converts Bank to JSON format.
+func (bank *Bank) MarshalJSON() ([]byte, error) {
+	wrapper := &bankJSON{
+		Scheme:   bank.Scheme,
+		Key:      newRsaKeyJSON(&bank.Key),
+		Priv:     jsonNumber(bank.Priv),
+		Pub:      jsonNumber(bank.Pub),
+		Currency: bank.Currency,
+	}
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates Bank from JSON data.
+func (bank *Bank) UnmarshalJSON(data []byte) error {
+	var wrapper bankJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	var p jsonParser
+	decoded := Bank{
+		Scheme:   wrapper.Scheme,
+		Key:      wrapper.Key.parse(&p),
+		Priv:     p.number(wrapper.Priv),
+		Pub:      p.number(wrapper.Pub),
+		Currency: wrapper.Currency,
+	}
+	if p.err != nil {
+		return p.err
+	}
+	*bank = decoded
+	return nil
+}
+
+// clientJSON represents the JSON-friendly structure for Client.
+type clientJSON struct {
+	Bank       bankProfileJSON `json:"Bank"`
+	Key        rsaKeyJSON      `json:"Key"`
+	TradeId    string          `json:"TradeId,omitempty"`
+	Priv       string          `json:"Priv,omitempty"`
+	Pub        string          `json:"Pub,omitempty"`
+	Credential string          `json:"Credential,omitempty"`
+	Contract   string          `json:"Contract,omitempty"`
+	Expiration time.Time       `json:"Expiration"`
+}
+
+// MarshalJSON converts Client to JSON format.
+func (client *Client) MarshalJSON() ([]byte, error) {
+	wrapper := &clientJSON{
+		Bank:       newBankProfileJSON(&client.Bank),
+		Key:        newRsaKeyJSON(&client.Key),
+		TradeId:    jsonNumber(client.TradeId),
+		Priv:       jsonNumber(client.Priv),
+		Pub:        jsonNumber(client.Pub),
+		Credential: jsonNumber(client.Credential),
+		Contract:   jsonNumber(client.Contract),
+		Expiration: client.Expiration,
+	}
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates Client from JSON data.
+func (client *Client) UnmarshalJSON(data []byte) error {
+	var wrapper clientJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	var p jsonParser
+	decoded := Client{
+		Bank:       wrapper.Bank.parse(&p),
+		Key:        wrapper.Key.parse(&p),
+		TradeId:    p.number(wrapper.TradeId),
+		Priv:       p.number(wrapper.Priv),
+		Pub:        p.number(wrapper.Pub),
+		Credential: p.number(wrapper.Credential),
+		Contract:   p.number(wrapper.Contract),
+		Expiration: wrapper.Expiration,
+	}
+	if p.err != nil {
+		return p.err
+	}
+	*client = decoded
+	return nil
+}
+
+// clientInfoJSON represents the JSON-friendly structure for ClientInfo.
+type clientInfoJSON struct {
+	Profile    clientProfileJSON `json:"Profile"`
+	K          string            `json:"K,omitempty"`
+	S          string            `json:"S,omitempty"`
+	Credential string            `json:"Credential,omitempty"`
+	Contract   string            `json:"Contract,omitempty"`
+	Expiration time.Time         `json:"Expiration"`
+}
+
+// MarshalJSON converts ClientInfo to JSON format.
+func (client *ClientInfo) MarshalJSON() ([]byte, error) {
+	wrapper := &clientInfoJSON{
+		Profile:    newClientProfileJSON(&client.Profile),
+		K:          jsonNumber(client.K),
+		S:          jsonNumber(client.S),
+		Credential: jsonNumber(client.Credential),
+		Contract:   jsonNumber(client.Contract),
+		Expiration: client.Expiration,
+	}
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates ClientInfo from JSON data.
+func (client *ClientInfo) UnmarshalJSON(data []byte) error {
+	var wrapper clientInfoJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	var p jsonParser
+	decoded := ClientInfo{
+		Profile:    wrapper.Profile.parse(&p),
+		K:          p.number(wrapper.K),
+		S:          p.number(wrapper.S),
+		Credential: p.number(wrapper.Credential),
+		Contract:   p.number(wrapper.Contract),
+		Expiration: wrapper.Expiration,
+	}
+	if p.err != nil {
+		return p.err
+	}
+	*client = decoded
+	return nil
+}
+
+// coinJSON represents the JSON-friendly structure for Coin.
+type coinJSON struct {
+	Random struct {
+		E        string `json:"E,omitempty"`
+		L        string `json:"L,omitempty"`
+		LInv     string `json:"LInv,omitempty"`
+		Beta1    string `json:"Beta1,omitempty"`
+		Beta1Inv string `json:"Beta1Inv,omitempty"`
+		Beta2    string `json:"Beta2,omitempty"`
+		Y        string `json:"Y,omitempty"`
+		YInv     string `json:"YInv,omitempty"`
+	} `json:"Random"`
+	Elgamal struct {
+		Priv   string `json:"Priv,omitempty"`
+		Pub    string `json:"Pub,omitempty"`
+		First  string `json:"First,omitempty"`
+		Second string `json:"Second,omitempty"`
+		Msg    string `json:"Msg,omitempty"`
+	} `json:"Elgamal"`
+	Params struct {
+		A          string    `json:"A,omitempty"`
+		ALower     string    `json:"ALower,omitempty"`
+		C          string    `json:"C,omitempty"`
+		Expiration time.Time `json:"Expiration"`
+		A1         string    `json:"A1,omitempty"`
+		C1         string    `json:"C1,omitempty"`
+		A2         string    `json:"A2,omitempty"`
+		R          string    `json:"R,omitempty"`
+		Version    int       `json:"Version"`
+		Currency   string    `json:"Currency,omitempty"`
+		Value      int64     `json:"Value,omitempty"`
+	} `json:"Params"`
+}
+
+// MarshalJSON converts Coin to JSON format.
+func (coin *Coin) MarshalJSON() ([]byte, error) {
+	wrapper := new(coinJSON)
+	wrapper.Random.E = jsonNumber(coin.Random.E)
+	wrapper.Random.L = jsonNumber(coin.Random.L)
+	wrapper.Random.LInv = jsonNumber(coin.Random.LInv)
+	wrapper.Random.Beta1 = jsonNumber(coin.Random.Beta1)
+	wrapper.Random.Beta1Inv = jsonNumber(coin.Random.Beta1Inv)
+	wrapper.Random.Beta2 = jsonNumber(coin.Random.Beta2)
+	wrapper.Random.Y = jsonNumber(coin.Random.Y)
+	wrapper.Random.YInv = jsonNumber(coin.Random.YInv)
+	wrapper.Elgamal.Priv = jsonNumber(coin.Elgamal.Priv)
+	wrapper.Elgamal.Pub = jsonNumber(coin.Elgamal.Pub)
+	wrapper.Elgamal.First = jsonNumber(coin.Elgamal.First)
+	wrapper.Elgamal.Second = jsonNumber(coin.Elgamal.Second)
+	wrapper.Elgamal.Msg = jsonNumber(coin.Elgamal.Msg)
+	wrapper.Params.A = jsonNumber(coin.Params.A)
+	wrapper.Params.ALower = jsonNumber(coin.Params.ALower)
+	wrapper.Params.C = jsonNumber(coin.Params.C)
+	wrapper.Params.Expiration = coin.Params.Expiration
+	wrapper.Params.A1 = jsonNumber(coin.Params.A1)
+	wrapper.Params.C1 = jsonNumber(coin.Params.C1)
+	wrapper.Params.A2 = jsonNumber(coin.Params.A2)
+	wrapper.Params.R = jsonNumber(coin.Params.R)
+	wrapper.Params.Version = coin.Params.Version
+	wrapper.Params.Currency = coin.Params.Currency
+	wrapper.Params.Value = coin.Params.Value
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates Coin from JSON data.
+func (coin *Coin) UnmarshalJSON(data []byte) error {
+	var wrapper coinJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	var p jsonParser
+	decoded := Coin{
+		Random: CoinRandom{
+			E:        p.number(wrapper.Random.E),
+			L:        p.number(wrapper.Random.L),
+			LInv:     p.number(wrapper.Random.LInv),
+			Beta1:    p.number(wrapper.Random.Beta1),
+			Beta1Inv: p.number(wrapper.Random.Beta1Inv),
+			Beta2:    p.number(wrapper.Random.Beta2),
+			Y:        p.number(wrapper.Random.Y),
+			YInv:     p.number(wrapper.Random.YInv),
+		},
+		Elgamal: CoinElgamal{
+			Priv:   p.number(wrapper.Elgamal.Priv),
+			Pub:    p.number(wrapper.Elgamal.Pub),
+			First:  p.number(wrapper.Elgamal.First),
+			Second: p.number(wrapper.Elgamal.Second),
+			Msg:    p.number(wrapper.Elgamal.Msg),
+		},
+		Params: CoinParams{
+			A:          p.number(wrapper.Params.A),
+			ALower:     p.number(wrapper.Params.ALower),
+			C:          p.number(wrapper.Params.C),
+			Expiration: wrapper.Params.Expiration,
+			A1:         p.number(wrapper.Params.A1),
+			C1:         p.number(wrapper.Params.C1),
+			A2:         p.number(wrapper.Params.A2),
+			R:          p.number(wrapper.Params.R),
+			Version:    wrapper.Params.Version,
+			Currency:   wrapper.Params.Currency,
+			Value:      wrapper.Params.Value,
+		},
+	}
+	if p.err != nil {
+		return p.err
+	}
+	*coin = decoded
+	return nil
+}
+
+// coinProfileJSON represents the JSON-friendly structure for CoinProfile.
+type coinProfileJSON struct {
+	Pub        string    `json:"Pub,omitempty"`
+	First      string    `json:"First,omitempty"`
+	A          string    `json:"A,omitempty"`
+	R          string    `json:"R,omitempty"`
+	A2         string    `json:"A2,omitempty"`
+	Expiration time.Time `json:"Expiration"`
+	Second     string    `json:"Second,omitempty"`
+	Msg        string    `json:"Msg,omitempty"`
+	Version    int       `json:"Version"`
+	Currency   string    `json:"Currency,omitempty"`
+	Value      int64     `json:"Value,omitempty"`
+}
+
+// MarshalJSON converts CoinProfile to JSON format.
+func (coin *CoinProfile) MarshalJSON() ([]byte, error) {
+	wrapper := &coinProfileJSON{
+		Pub:        jsonNumber(coin.Pub),
+		First:      jsonNumber(coin.First),
+		A:          jsonNumber(coin.A),
+		R:          jsonNumber(coin.R),
+		A2:         jsonNumber(coin.A2),
+		Expiration: coin.Expiration,
+		Second:     jsonNumber(coin.Second),
+		Msg:        jsonNumber(coin.Msg),
+		Version:    coin.Version,
+		Currency:   coin.Currency,
+		Value:      coin.Value,
+	}
+	return json.Marshal(wrapper)
+}
+
+// UnmarshalJSON populates CoinProfile from JSON data.
+func (coin *CoinProfile) UnmarshalJSON(data []byte) error {
+	var wrapper coinProfileJSON
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	var p jsonParser
+	decoded := CoinProfile{
+		Pub:        p.number(wrapper.Pub),
+		First:      p.number(wrapper.First),
+		A:          p.number(wrapper.A),
+		R:          p.number(wrapper.R),
+		A2:         p.number(wrapper.A2),
+		Expiration: wrapper.Expiration,
+		Second:     p.number(wrapper.Second),
+		Msg:        p.number(wrapper.Msg),
+		Version:    wrapper.Version,
+		Currency:   wrapper.Currency,
+		Value:      wrapper.Value,
+	}
+	if p.err != nil {
+		return p.err
+	}
+	*coin = decoded
+	return nil
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+//
+// GIFT
+//
+
+// 1. The Giver exports a coin, along with its secrets, as a claim token (a printable string, to be sent as a file or
+//		rendered as a QR code) and deletes the coin from its wallet.
+// 2. The Receiver imports the token and exchanges the coin at the bank right away, for a coin of its own.
+// 3. Only the first exchange of the coin succeeds, the bank rejects it as already spent afterwards. The token is
+//		one-time.
+// Anyone holding the token can claim the coin, it must be kept secret until claimed.
+
+// giftPrefix tags claim tokens.
+const giftPrefix = "ziba-gift:"
+
+// NewGiftToken returns the claim token of coin.
+func NewGiftToken(coin *Coin) (string, error) {
+	data, err := coin.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return giftPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseGiftToken returns the coin of a claim token. Surrounding whitespace is ignored.
+func ParseGiftToken(token string) (*Coin, error) {
+	encoded, found := strings.CutPrefix(strings.TrimSpace(token), giftPrefix)
+	if !found {
+		return nil, ErrGiftToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrGiftToken
+	}
+
+	var coin Coin
+	if err := coin.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &coin, nil
+}
+
+// VerifyGift verifies that coin, parsed from a claim token, is a coin signed by bank that can be claimed.
+func (coin *Coin) VerifyGift(bank *BankProfile) error {
+	if coin.Elgamal.Priv == nil || coin.Random.YInv == nil {
+		return ErrGiftToken
+	}
+	profile := coin.Profile()
+	if err := bank.ValidateCoin(profile); err != nil {
+		return err
+	}
+	if valid := profile.VerifyProperties(bank); !valid {
+		return ErrGiftToken
+	}
+	return nil
+}
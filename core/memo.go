@@ -0,0 +1,88 @@
+package core
+
+import (
+	"math/big"
+)
+
+//
+// MEMO
+//
+
+// 1. The Spender attaches a memo to a payment. (Possibly empty)
+// 2. The Merchant stamps the coin with the memo's text, its transaction identifier, the transaction date and the digest
+//		of its ClientProfile.
+// 3. At deposit, the Merchant presents the memo and the Bank verifies it is the one the coin was signed to, and that
+//		it binds the depositing account. A coin stolen after the payment can't be deposited by anyone else.
+// The memo is only known to the Merchant and the Bank from the payment on, the withdrawal remains unlinkable.
+
+// MaxMemoLength is the maximum length of a memo's text, in bytes.
+const MaxMemoLength = 140
+
+// NewMemo allocates and returns a new Memo with text.
+func NewMemo(text string) (*Memo, error) {
+	if len(text) > MaxMemoLength {
+		return nil, ErrMemoLength
+	}
+	return &Memo{Text: text}, nil
+}
+
+// Msg computes the Elgamal's message binding coin to the memo and returns it.
+func (memo *Memo) Msg(coin *CoinProfile) *big.Int {
+	// Compute the hash of some coin parameters, the transaction parameters and the memo.
+	t := newTranscript("ziba/payment/memo").
+		number(coin.Pub).
+		number(coin.First).
+		number(memo.Payee).
+		date(memo.Date).
+		field([]byte(memo.Text))
+	if memo.Account != nil {
+		t.number(memo.Account)
+	}
+	if memo.Change != nil {
+		t.number(big.NewInt(memo.Change.Amount)).
+			number(memo.Change.ALower).
+			number(memo.Change.C).
+			number(memo.Change.Claim)
+	}
+	msg := t.digest()
+
+	// Tag the message as not escrowed (lowest bit cleared).
+	return msg.SetBit(msg, 0, 0)
+}
+
+// StampMemo computes the Elgamal's message using some transaction parameters and memo, and returns it.
+func (coin *CoinProfile) StampMemo(bank *BankProfile, client *ClientProfile, memo *Memo) (msg *big.Int) {
+	// Compute the current time as the transaction date (t).
+	memo.Payee = client.TradeId
+	memo.Date = Now()
+	memo.Account = client.Digest()
+
+	// Compute the Elgamal message (d).
+	msg = memo.Msg(coin)
+
+	coin.Msg = msg
+
+	return
+}
+
+// VerifyMemo verifies that coin is signed to memo and returns a success bool.
+func (coin *CoinProfile) VerifyMemo(bank *BankProfile, memo *Memo) bool {
+	// Check for a complete memo.
+	if memo == nil || memo.Payee == nil || len(memo.Text) > MaxMemoLength {
+		return false
+	}
+
+	// Check the message binds the memo.
+	if coin.Msg == nil || coin.Msg.Cmp(memo.Msg(coin)) != 0 {
+		return false
+	}
+
+	// Check the Elgamal's signature on the message.
+	return coin.Second != nil && coin.VerifyElgamal(bank, coin.Second)
+}
+
+// VerifyAccount verifies that memo binds the payment to client's account.
+func (memo *Memo) VerifyAccount(client *ClientProfile) bool {
+	return memo.Payee != nil && memo.Payee.Cmp(client.TradeId) == 0 &&
+		memo.Account != nil && memo.Account.Cmp(client.Digest()) == 0
+}
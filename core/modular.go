@@ -0,0 +1,123 @@
+package core
+
+import (
+	"math/big"
+	"sync"
+)
+
+//
+// MODULAR ARITHMETIC
+//
+
+// Every exponentiation of the protocols is mod one of a few moduli: the scheme's p and q, and the banks' N. A modulus
+// keeps what its reductions share, cached by value for the lifetime of the process.
+//
+// big.Int.Exp already works in Montgomery form for odd moduli, setting up its context (a single reduction) on every
+// call, which math/big doesn't let us keep. The products reduced outside of it are reduced using Barrett's method
+// instead, with mu = floor(4^k / m) computed once: two multiplications instead of a division, faster for the moduli of
+// barrettBits and more. (The banks' N, not p)
+//
+// expMod raises the bases of a cached fixed-base table (alpha, the banks' z) using the table, and others using
+// big.Int.Exp.
+
+const (
+	// barrettBits is the size of the smallest modulus reduced using Barrett's method, divided below.
+	barrettBits = 1536
+
+	// moduliCacheSize bounds the number of cached moduli. (p, q, and one N per known bank)
+	moduliCacheSize = 16
+)
+
+// modulus is a modulus m, along with its Barrett reduction context.
+type modulus struct {
+	m  *big.Int
+	mu *big.Int // floor(4^k / m), nil if m is reduced by division.
+	k  uint     // Size of m, in bits.
+}
+
+// moduliCache holds the moduli built so far.
+var moduliCache struct {
+	sync.RWMutex
+	moduli []*modulus
+}
+
+// newModulus allocates and returns the modulus m.
+func newModulus(m *big.Int) *modulus {
+	mod := &modulus{m: m, k: uint(m.BitLen())}
+	if mod.k >= barrettBits {
+		mod.mu = new(big.Int).Lsh(big.NewInt(1), 2*mod.k)
+		mod.mu.Quo(mod.mu, m)
+	}
+	return mod
+}
+
+// lookupModulus returns the cached modulus m, building it if needed.
+func lookupModulus(m *big.Int) *modulus {
+	moduliCache.RLock()
+	for _, mod := range moduliCache.moduli {
+		if mod.m.Cmp(m) == 0 {
+			moduliCache.RUnlock()
+			return mod
+		}
+	}
+	moduliCache.RUnlock()
+
+	moduliCache.Lock()
+	defer moduliCache.Unlock()
+	for _, mod := range moduliCache.moduli {
+		if mod.m.Cmp(m) == 0 {
+			return mod
+		}
+	}
+	mod := newModulus(new(big.Int).Set(m))
+	if len(moduliCache.moduli) < moduliCacheSize {
+		moduliCache.moduli = append(moduliCache.moduli, mod)
+	}
+	return mod
+}
+
+// reduce sets z to x mod m and returns z. (z and x must not alias)
+func (mod *modulus) reduce(z, x *big.Int) *big.Int {
+	if x.Sign() < 0 {
+		return z.Mod(x, mod.m)
+	}
+	q, t := getInt(), getInt()
+	defer putInt(q, t)
+	if mod.mu == nil || uint(x.BitLen()) > 2*mod.k {
+		q.QuoRem(x, mod.m, z) // Non-negative, the remainder is the modulus.
+		return z
+	}
+
+	// q = floor(floor(x / 2^(k-1)) * mu / 2^(k+1)), off by at most 2 from floor(x / m).
+	q.Rsh(x, mod.k-1)
+	t.Mul(q, mod.mu)
+	q.Rsh(t, mod.k+1)
+	t.Mul(q, mod.m)
+	z.Sub(x, t)
+	for z.Cmp(mod.m) >= 0 {
+		z.Sub(z, mod.m)
+	}
+	return z
+}
+
+// mulMod sets z to x * y mod m and returns z.
+func (mod *modulus) mulMod(z, x, y *big.Int) *big.Int {
+	product := getInt()
+	defer putInt(product)
+	return mod.reduce(z, product.Mul(x, y))
+}
+
+// expMod returns x^e mod m, using the cached fixed-base table of x mod m if any. (Not building one)
+func expMod(x, e, m *big.Int) *big.Int {
+	if e.Sign() >= 0 {
+		if fb := cachedFixedBase(x, m); fb != nil {
+			return fb.exp(e)
+		}
+	}
+	return new(big.Int).Exp(x, e, m)
+}
+
+// mulMod returns x * y mod m.
+func mulMod(x, y, m *big.Int) *big.Int {
+	return lookupModulus(m).mulMod(new(big.Int), x, y)
+}
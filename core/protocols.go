@@ -1,12 +1,14 @@
 package core
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"io"
 	"log"
 	"math/big"
+	"runtime"
 	"time"
 )
 
@@ -19,63 +21,52 @@ import (
 // 2. A Bank joins the scheme by creating an identity (from which its public identity can be computed).
 
 // New allocates and returns a new SchemeParams.
-func (scheme *SchemeParams) New() *SchemeParams {
-	// Variables to set.
-	var p, q, g *big.Int
-	var err error
+func (scheme *SchemeParams) New(random io.Reader) *SchemeParams {
+	if _, err := scheme.Generate(context.Background(), random, 1024, runtime.NumCPU()); err != nil {
+		log.Printf("failed to generate scheme parameters: %v", err)
+		return nil
+	}
+	return scheme
+}
 
+// Generate sets scheme to new parameters with a Sophie-Germain prime (q) of the given bit length, searching with
+// workers goroutines, and returns it. Returns ctx's error if ctx is done first.
+func (scheme *SchemeParams) Generate(ctx context.Context, random io.Reader, bits, workers int) (*SchemeParams, error) {
 	// Find Sophie-Germain prime (q) and its related safe prime (p).
+	p, q, err := GenerateSafePrime(ctx, random, bits, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find generator (alpha) of the subgroup of order q, the quadratic residues mod p. Squaring any h other than 1 and
+	// p - 1 yields one, since q is prime.
+	pMinus1 := new(big.Int).Sub(p, big.NewInt(1))
+	var g *big.Int
 	for {
-		// Generate a random prime number of length 1024 bits.
-		q, err = rand.Prime(rand.Reader, 1024)
+		h, err := rand.Int(source(random), pMinus1)
 		if err != nil {
-			log.Printf("failed to generate random number q")
-			return nil
+			return nil, err
 		}
-
-		// Compute p = 2q + 1 and check if its a prime number.
-		p = new(big.Int).Mul(q, big.NewInt(2))
-		p.Add(p, big.NewInt(1))
-
-		if ok := p.ProbablyPrime(20); ok {
+		if h.Cmp(big.NewInt(2)) < 0 {
+			continue
+		}
+		g = new(big.Int).Exp(h, big.NewInt(2), p)
+		if g.Cmp(big.NewInt(1)) != 0 {
 			break
 		}
 	}
 
-	// Find generator (g) in Z_p^*.
-	g, err = rand.Prime(rand.Reader, 1024)
-	if err != nil {
-		return nil
-	}
-
-	// for {
-	// 	h, err := rand.Prime(rand.Reader, 1024)
-	// 	if err != nil {
-	// 		continue
-	// 	}
-	// 	// Test primitive element h by checking h^alpha != 1 mod p.
-	// 	// Where alpha is { factors of p - 1 }.
-	// 	// By p being p = 2q + 1 -> p - 1 = 2q.
-	// 	// Factors of p - 1 are 2 and q.
-	// 	h2 := new(big.Int).Exp(h, big.NewInt(2), p)
-	// 	hq := new(big.Int).Exp(h, q, p)
-	// 	if h2.Cmp(big.NewInt(1)) != 0 && hq.Cmp(big.NewInt(1)) != 0 {
-	// 		g = h
-	// 		break
-	// 	}
-	// }
-
 	scheme.Q = q
 	scheme.P = p
 	scheme.G = g
 
-	return scheme
+	return scheme, nil
 }
 
 // New allocates an returns a new RsaKey.
-func (key *RsaKey) New() *RsaKey {
+func (key *RsaKey) New(random io.Reader) *RsaKey {
 	// Generate RSA key of length 2048 bits.
-	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	rsaKey, err := rsa.GenerateKey(source(random), 2048)
 	if err != nil {
 		log.Printf("failed to generate RSA key")
 		return nil
@@ -91,14 +82,30 @@ func (key *RsaKey) New() *RsaKey {
 }
 
 // New allocates and returns a new Bank computed using scheme.
-func (bank *Bank) New(scheme *SchemeParams) *Bank {
+func (bank *Bank) New(random io.Reader, scheme *SchemeParams) *Bank {
 	// Check for valid SchemeParams.
 	if scheme == nil {
 		return nil
 	}
 
+	// Generate RSA key.
+	key := new(RsaKey).New(random)
+	if key == nil {
+		return nil
+	}
+
+	return bank.NewFromKey(random, scheme, key)
+}
+
+// NewFromKey allocates and returns a new Bank computed using scheme and an existing RSA key.
+func (bank *Bank) NewFromKey(random io.Reader, scheme *SchemeParams, key *RsaKey) *Bank {
+	// Check for valid SchemeParams and RsaKey.
+	if scheme == nil || key == nil {
+		return nil
+	}
+
 	// Generate private identity number (x).
-	priv, err := rand.Int(rand.Reader, scheme.P)
+	priv, err := rand.Int(source(random), scheme.P)
 	if err != nil {
 		log.Printf("failed to generate private identity number for Bank")
 		return nil
@@ -107,12 +114,6 @@ func (bank *Bank) New(scheme *SchemeParams) *Bank {
 	// Compute public identity number (z).
 	pub := new(big.Int).Exp(scheme.G, priv, scheme.P)
 
-	// Generate RSA key.
-	key := new(RsaKey).New()
-	if key == nil {
-		return nil
-	}
-
 	bank.Scheme = *scheme
 	bank.Key = *key
 	bank.Priv = priv
@@ -124,10 +125,35 @@ func (bank *Bank) New(scheme *SchemeParams) *Bank {
 // Profile allocates and returns a new BankProfile using bank.
 func (bank *Bank) Profile() *BankProfile {
 	return &BankProfile{
-		Scheme: bank.Scheme,
-		Pub:    bank.Pub,
-		N:      bank.Key.N,
-		E:      bank.Key.E,
+		Scheme:   bank.Scheme,
+		Pub:      bank.Pub,
+		N:        bank.Key.N,
+		E:        bank.Key.E,
+		Currency: NormalizeCurrency(bank.Currency),
+	}
+}
+
+// AccgenCustody allocates and returns a copy of bank holding only the secrets needed to generate and renew client
+// accounts. (Priv)
+func (bank *Bank) AccgenCustody() *Bank {
+	return &Bank{
+		Scheme:   bank.Scheme,
+		Key:      RsaKey{N: bank.Key.N, E: bank.Key.E},
+		Priv:     bank.Priv,
+		Pub:      bank.Pub,
+		Currency: bank.Currency,
+	}
+}
+
+// WithdrawalCustody allocates and returns a copy of bank holding only the secrets needed to answer coin requests.
+// (Priv and the RSA private exponent)
+func (bank *Bank) WithdrawalCustody() *Bank {
+	return &Bank{
+		Scheme:   bank.Scheme,
+		Key:      RsaKey{N: bank.Key.N, D: bank.Key.D, E: bank.Key.E},
+		Priv:     bank.Priv,
+		Pub:      bank.Pub,
+		Currency: bank.Currency,
 	}
 }
 
@@ -140,39 +166,49 @@ func (bank *Bank) Profile() *BankProfile {
 // 2. The Bank accepts the client's public identity and issues a credential and contract for this client.
 
 // New allocates and returns a new Client computed using bank.
-func (client *Client) New(bank *BankProfile) *Client {
+func (client *Client) New(random io.Reader, bank *BankProfile) *Client {
 	// Check for valid BankProfile.
 	if bank == nil {
 		return nil
 	}
 
+	// Generate RSA key.
+	key := new(RsaKey).New(random)
+	if key == nil {
+		return nil
+	}
+
+	return client.NewFromKey(random, bank, key)
+}
+
+// NewFromKey allocates and returns a new Client computed using bank and an existing RSA key.
+func (client *Client) NewFromKey(random io.Reader, bank *BankProfile, key *RsaKey) *Client {
+	// Check for valid BankProfile and RsaKey.
+	if bank == nil || key == nil {
+		return nil
+	}
+
 	// Generate private identity number (r_m).
-	priv, err := rand.Int(rand.Reader, bank.Scheme.P)
+	priv, err := rand.Int(source(random), bank.Scheme.P)
 	if err != nil {
 		log.Printf("failed to generate private identity number for Client")
 		return nil
 	}
 
 	// Generate public identity number (m).
-	pub, err := rand.Int(rand.Reader, bank.N)
+	pub, err := rand.Int(source(random), bank.N)
 	if err != nil {
 		log.Printf("failed to generate public identity number for Client")
 		return nil
 	}
 
 	// Generate transaction identifier (ID_M).
-	tradeId, err := rand.Int(rand.Reader, new(big.Int).Sub(bank.N, big.NewInt(1)))
+	tradeId, err := rand.Int(source(random), new(big.Int).Sub(bank.N, big.NewInt(1)))
 	if err != nil {
 		log.Printf("failed to generate transaction identifier for Client")
 		return nil
 	}
 
-	// Generate RSA key.
-	key := new(RsaKey).New()
-	if key == nil {
-		return nil
-	}
-
 	client.Bank = *bank
 	client.Key = *key
 	client.TradeId = tradeId
@@ -185,15 +221,11 @@ func (client *Client) New(bank *BankProfile) *Client {
 // Profile allocates and returns a new ClientProfile using client.
 func (client *Client) Profile() *ClientProfile {
 	// Compute private identity stamp number.
-	privStamp := new(big.Int).Exp(client.Bank.Scheme.G, client.Priv, client.Bank.Scheme.P)
-
-	// Compute identity hash number.
-	identityHashBytes := sha256.Sum256(append(client.Pub.Bytes(), privStamp.Bytes()...))
-	identityHash := new(big.Int).SetBytes(identityHashBytes[:])
+	privStamp := client.Bank.Scheme.expG(client.Priv)
 
 	return &ClientProfile{
 		PrivStamp:    privStamp,
-		IdentityHash: identityHash,
+		IdentityHash: identityHash(client.Pub, privStamp),
 		TradeId:      client.TradeId,
 		Pub:          client.Pub,
 		N:            client.Key.N,
@@ -211,16 +243,15 @@ func concatenateBigInts(first, second *big.Int) *big.Int {
 }
 
 // NewClient allocates and returns a new ClientInfo using profile.
-func (bank *Bank) NewClient(profile *ClientProfile) (*ClientInfo, error) {
-	// Verify client's identity.
-	computedIdentityHashBytes := sha256.Sum256(append(profile.Pub.Bytes(), profile.PrivStamp.Bytes()...))
-	computedIdentityHash := new(big.Int).SetBytes(computedIdentityHashBytes[:])
-	if profile.IdentityHash.Cmp(computedIdentityHash) != 0 {
+func (bank *Bank) NewClient(random io.Reader, profile *ClientProfile) (*ClientInfo, error) {
+	// Verify client's identity. (Profiles created before the canonical serialization carry a legacy identity hash)
+	if profile.IdentityHash.Cmp(identityHash(profile.Pub, profile.PrivStamp)) != 0 &&
+		profile.IdentityHash.Cmp(legacyIdentityHash(profile.Pub, profile.PrivStamp)) != 0 {
 		return nil, ErrIdentityMismatch
 	}
 
 	// Generate randomizing number (k).
-	k, err := rand.Int(rand.Reader, bank.Scheme.P)
+	k, err := rand.Int(source(random), bank.Scheme.P)
 	if err != nil {
 		log.Printf("failed to generate random number")
 		return nil, err
@@ -230,22 +261,32 @@ func (bank *Bank) NewClient(profile *ClientProfile) (*ClientInfo, error) {
 	s := new(big.Int).Mod(concatenateBigInts(profile.Pub, k), bank.Scheme.P)
 
 	// Compute the client's credential (v).
-	credential := new(big.Int).Exp(bank.Scheme.G, s, bank.Scheme.P)
+	credential := bank.Scheme.expG(s)
 
 	// Compute the client's contract (R).
 	contract := new(big.Int).Exp(credential, bank.Priv, bank.Scheme.P)
 
+	// Choose an expiration date for the credentials. In this case is one year from the current time.
+	expiration := Now().AddDate(1, 0, 0)
+
 	client := &ClientInfo{
 		Profile:    *profile,
 		K:          k,
 		S:          s,
 		Credential: credential,
 		Contract:   contract,
+		Expiration: expiration,
 	}
 
 	return client, nil
 }
 
+// RenewClient allocates and returns a new ClientInfo with fresh credentials for the same profile as client.
+// Coins computed using the previous credentials remain valid.
+func (bank *Bank) RenewClient(random io.Reader, client *ClientInfo) (*ClientInfo, error) {
+	return bank.NewClient(random, &client.Profile)
+}
+
 // AddCredentials sets Credential, Contract for client and returns it.
 func (client *Client) SetCredentials(credential *big.Int, contract *big.Int) *Client {
 	client.Credential = credential
@@ -253,6 +294,12 @@ func (client *Client) SetCredentials(credential *big.Int, contract *big.Int) *Cl
 	return client
 }
 
+// SetExpiration sets the Expiration of client's credentials and returns it.
+func (client *Client) SetExpiration(expiration time.Time) *Client {
+	client.Expiration = expiration
+	return client
+}
+
 //
 // WITHDRAWAL (3/6)
 //
@@ -263,13 +310,49 @@ func (client *Client) SetCredentials(credential *big.Int, contract *big.Int) *Cl
 // 3. The Client uses the Bank's issued parameters to compute some final coin parameters, therefore
 //		completing the coin.
 
+const (
+	// CoinVersionLegacy coins are signed with textbook RSA on A * H(t), which is malleable. Verified while
+	// AcceptLegacyCoins is set.
+	CoinVersionLegacy = 0
+
+	// CoinVersionFDH coins are signed on FDH(A) * FDH(t), where FDH is a full-domain hash onto Z_N.
+	CoinVersionFDH = 1
+
+	// CoinVersionCanonical coins are CoinVersionFDH coins whose hashed inputs use the canonical serialization.
+	CoinVersionCanonical = 2
+)
+
+// AcceptLegacyCoins enables the verification of CoinVersionLegacy coins. It should be disabled once every legacy coin
+// has expired.
+var AcceptLegacyCoins = true
+
+// fullDomainHash hashes data onto Z_N using SHA-256 in counter mode (MGF1). The tag separates the domains of different
+// uses. (The output has 128 extra bits before the reduction to make the bias negligible)
+func fullDomainHash(tag string, data []byte, N *big.Int) *big.Int {
+	size := (N.BitLen()+7)/8 + 16
+	output := make([]byte, 0, size+sha256.Size)
+	for counter := uint32(0); len(output) < size; counter++ {
+		h := sha256.New()
+		h.Write([]byte(tag))
+		h.Write([]byte{byte(counter >> 24), byte(counter >> 16), byte(counter >> 8), byte(counter)})
+		h.Write(data)
+		output = h.Sum(output)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(output[:size]), N)
+}
+
+// commitmentDigest computes the full-domain hash of the client's blinded credential A.
+func commitmentDigest(A *big.Int, N *big.Int) *big.Int {
+	return fullDomainHash("ziba/coin/A", A.Bytes(), N)
+}
+
 // random sets coin.Random to a new CoinRandom.
-func (coin *Coin) random(client *Client) error {
+func (coin *Coin) random(random io.Reader, client *Client) error {
 	// Helper
 	var err error
 
 	// Generate random number (e).
-	e, err := rand.Int(rand.Reader, client.Bank.Scheme.P)
+	e, err := rand.Int(source(random), client.Bank.Scheme.P)
 	if err != nil {
 		log.Printf("failed to generate random number")
 		return err
@@ -278,7 +361,7 @@ func (coin *Coin) random(client *Client) error {
 	// Generate random number (l) such that its inverse exists (l^-1).
 	var l, lInv *big.Int
 	for {
-		l, err = rand.Int(rand.Reader, client.Bank.N)
+		l, err = rand.Int(source(random), client.Bank.N)
 		if err != nil {
 			log.Printf("failed to generate random number")
 			return err
@@ -293,7 +376,7 @@ func (coin *Coin) random(client *Client) error {
 	// Generate random number (beta_1) such that its inverse exists (beta_1^-1).
 	var beta1, beta1Inv *big.Int
 	for {
-		beta1, err = rand.Int(rand.Reader, client.Bank.Scheme.Q)
+		beta1, err = rand.Int(source(random), client.Bank.Scheme.Q)
 		if err != nil {
 			log.Printf("failed to generate random number")
 			return err
@@ -309,7 +392,7 @@ func (coin *Coin) random(client *Client) error {
 	var y, yInv *big.Int
 	pMinus1 := new(big.Int).Sub(client.Bank.Scheme.P, big.NewInt(1))
 	for {
-		y, err = rand.Int(rand.Reader, pMinus1)
+		y, err = rand.Int(source(random), pMinus1)
 		if err != nil {
 			log.Printf("failed to generate random number")
 			return err
@@ -322,7 +405,7 @@ func (coin *Coin) random(client *Client) error {
 	}
 
 	// Generate random number (beta_2).
-	beta2, err := rand.Int(rand.Reader, client.Bank.Scheme.P)
+	beta2, err := rand.Int(source(random), client.Bank.Scheme.P)
 	if err != nil {
 		log.Printf("failed to generate random number")
 		return err
@@ -348,10 +431,10 @@ func (coin *Coin) elgamal(client *Client) {
 	priv := new(big.Int).Mod(concatenateBigInts(client.Contract, coin.Random.E), client.Bank.Scheme.P)
 
 	// Compute Elgamal public key (alpha).
-	pub := new(big.Int).Exp(client.Bank.Scheme.G, priv, client.Bank.Scheme.P)
+	pub := client.Bank.Scheme.expG(priv)
 
 	// Compute Elgamal first component (u).
-	first := new(big.Int).Exp(client.Bank.Scheme.G, coin.Random.Y, client.Bank.Scheme.P)
+	first := client.Bank.Scheme.expG(coin.Random.Y)
 
 	coin.Elgamal = CoinElgamal{
 		Priv:  priv,
@@ -362,52 +445,52 @@ func (coin *Coin) elgamal(client *Client) {
 
 // params sets coin.Params to a new CoinParams.
 func (coin *Coin) params(client *Client) {
+	// Temporaries, computed in place.
+	power, product := getInt(), getInt()
+	defer putInt(power, product)
+
 	// Compute client's blinded credential (A).
-	A := new(big.Int).Mod(
-		new(big.Int).Mul(
-			new(big.Int).Exp(client.Credential, coin.Random.Beta1, client.Bank.Scheme.P),
-			new(big.Int).Exp(client.Bank.Scheme.G, coin.Random.Beta2, client.Bank.Scheme.P),
-		),
-		client.Bank.Scheme.P,
-	)
+	power.Exp(client.Credential, coin.Random.Beta1, client.Bank.Scheme.P)
+	A := new(big.Int).Mod(product.Mul(power, client.Bank.Scheme.expG(coin.Random.Beta2)), client.Bank.Scheme.P)
 
-	// Compute blind signature envelope for A (a).
-	a := new(big.Int).Mod(
-		new(big.Int).Mul(
-			A,
-			new(big.Int).Exp(coin.Random.L, client.Bank.E, client.Bank.N),
-		),
-		client.Bank.N,
-	)
+	// Compute blind signature envelope for FDH(A) (a).
+	power.Exp(coin.Random.L, client.Bank.E, client.Bank.N)
+	a := new(big.Int).Mod(product.Mul(commitmentDigest(A, client.Bank.N), power), client.Bank.N)
 
 	// Compute digest of some coin parameters.
-	var buffer bytes.Buffer
-	buffer.Write(coin.Elgamal.First.Bytes())
-	buffer.Write(coin.Elgamal.Pub.Bytes())
-	buffer.Write(A.Bytes())
-	hashBytes := sha256.Sum256(buffer.Bytes())
-	hash := new(big.Int).SetBytes(hashBytes[:])
+	hash := coinDigest(CoinVersionCanonical, coin.Elgamal.First, coin.Elgamal.Pub, A)
 
 	// Compute signature envelope for some coin parameters (C).
-	C := new(big.Int).Mod(
-		new(big.Int).Mul(coin.Random.Beta1Inv, hash),
-		client.Bank.Scheme.Q,
-	)
+	C := new(big.Int).Mod(product.Mul(coin.Random.Beta1Inv, hash), client.Bank.Scheme.Q)
 
 	coin.Params = CoinParams{
-		A:      A,
-		ALower: a,
-		C:      C,
+		A:        A,
+		ALower:   a,
+		C:        C,
+		Version:  CoinVersionCanonical,
+		Currency: NormalizeCurrency(client.Bank.Currency),
+		Value:    1,
 	}
 }
 
 // NewCoinRequest generates a partial coin to be used for a withdrawal request.
-func (client *Client) NewCoinRequest() *Coin {
+func (client *Client) NewCoinRequest(random io.Reader) *Coin {
+	coin := client.NewPartialCoin(random)
+	if coin == nil {
+		return nil
+	}
+	return client.CompleteCoinRequest(coin)
+}
+
+// NewPartialCoin generates a coin with only its random and Elgamal's parameters, the most expensive part of a coin
+// request. It can be generated in advance and later completed by CompleteCoinRequest, while client's contract doesn't
+// change.
+func (client *Client) NewPartialCoin(random io.Reader) *Coin {
 	// Empty Coin object.
 	coin := new(Coin)
 
 	// Fill Coin.Random.
-	err := coin.random(client)
+	err := coin.random(random, client)
 	if err != nil {
 		return nil
 	}
@@ -415,28 +498,45 @@ func (client *Client) NewCoinRequest() *Coin {
 	// Fill Coin.Elgamal.
 	coin.elgamal(client)
 
+	return coin
+}
+
+// CompleteCoinRequest computes the parameters of a partial coin generated by NewPartialCoin, to be used for a
+// withdrawal request.
+func (client *Client) CompleteCoinRequest(coin *Coin) *Coin {
 	// Fill Coin.Params.
 	coin.params(client)
 
 	return coin
 }
 
-// NewCoinResponse computes some of the final coin parameters as a withdrawal response.
-func (bank *Bank) NewCoinResponse(client *ClientInfo, ALower *big.Int, C *big.Int) (Expiration time.Time, A1 *big.Int, C1 *big.Int) {
-	// Choose an expiration date for the coin (t). In this case is one month and one day from the current time.
-	Expiration = time.Now().AddDate(0, 1, 1)
+// NewCoinExpiration returns the expiration date for a new coin (t) of value, after its period of Validity from the
+// current time.
+func NewCoinExpiration(value int64) time.Time {
+	return Validity.Expiration(value, Now())
+}
+
+// expirationDigest computes the full-domain hash of a coin's expiration date.
+func expirationDigest(Expiration time.Time, N *big.Int) *big.Int {
 	expirationBytes, _ := Expiration.MarshalBinary()
+	return fullDomainHash("ziba/coin/t", expirationBytes, N)
+}
+
+// NewCoinResponse computes some of the final coin parameters as a withdrawal response, for a coin of value 1.
+func (bank *Bank) NewCoinResponse(client *ClientInfo, ALower *big.Int, C *big.Int) (Expiration time.Time, A1 *big.Int, C1 *big.Int) {
+	return bank.NewValuedCoinResponse(client, ALower, C, 1)
+}
 
-	// Compute digest of expiration date.
-	hashBytes := sha256.Sum256(expirationBytes)
-	hash := new(big.Int).SetBytes(hashBytes[:])
+// NewValuedCoinResponse computes some of the final coin parameters as a withdrawal response, for a coin of value.
+func (bank *Bank) NewValuedCoinResponse(client *ClientInfo, ALower *big.Int, C *big.Int, value int64) (Expiration time.Time, A1 *big.Int, C1 *big.Int) {
+	// Choose an expiration date for the coin (t).
+	Expiration = NewCoinExpiration(value)
 
-	// Compute a blind signature on A (A').
-	A1 = new(big.Int).Exp(
-		new(big.Int).Mul(ALower, hash),
-		bank.Key.D,
-		bank.Key.N,
-	)
+	// Compute digest of expiration date and value.
+	hash := valueDigest(Expiration, value, bank.Key.N)
+
+	// Compute a blind signature on FDH(A) (A').
+	A1 = bank.Key.sign(new(big.Int).Mul(ALower, hash))
 
 	// Compute a signature on c (c').
 	C1 = new(big.Int).Mod(
@@ -487,6 +587,9 @@ func (coin *Coin) Profile() *CoinProfile {
 		Expiration: coin.Params.Expiration,
 		Second:     coin.Elgamal.Second,
 		Msg:        coin.Elgamal.Msg,
+		Version:    coin.Params.Version,
+		Currency:   coin.Params.Currency,
+		Value:      coin.Params.Value,
 	}
 }
 
@@ -501,61 +604,56 @@ func (coin *Coin) Profile() *CoinProfile {
 
 // VerifyProperties verifies both of the Coin's properties and returns a success bool.
 func (coin *CoinProfile) VerifyProperties(bank *BankProfile) bool {
-	// Compute digest of expiration date.
-	expirationBytes, _ := coin.Expiration.MarshalBinary()
-	hashBytes := sha256.Sum256(expirationBytes)
-	hash := new(big.Int).SetBytes(hashBytes[:])
+	// Temporaries, computed in place.
+	left, right, product := getInt(), getInt(), getInt()
+	defer putInt(left, right, product)
 
 	// Compute left-side of first property.
-	left := new(big.Int).Mod(new(big.Int).Mul(coin.A, hash), bank.N)
+	switch coin.Version {
+	case CoinVersionFDH, CoinVersionCanonical:
+		product.Mul(commitmentDigest(coin.A, bank.N), valueDigest(coin.Expiration, coin.Value, bank.N))
+	case CoinVersionLegacy:
+		if !AcceptLegacyCoins || NormalizeValue(coin.Value) != 1 {
+			return false
+		}
+		expirationBytes, _ := coin.Expiration.MarshalBinary()
+		hashBytes := sha256.Sum256(expirationBytes)
+		product.Mul(coin.A, right.SetBytes(hashBytes[:]))
+	default:
+		return false
+	}
+	lookupModulus(bank.N).reduce(left, product)
 
 	// Compute right-side of first property.
-	right := new(big.Int).Exp(coin.A2, bank.E, bank.N)
+	right.Exp(coin.A2, bank.E, bank.N)
 
 	// Verify first property.
-	if left.Cmp(right) != 0 {
+	if !equal(left, right, bank.N) {
 		return false
 	}
 
 	// Compute left-side of second property.
-	left = new(big.Int).Exp(bank.Scheme.G, coin.R, bank.Scheme.P)
+	alpha := bank.Scheme.expG(coin.R)
 
 	// Compute digest of some coin parameters.
-	var buffer bytes.Buffer
-	buffer.Write(coin.First.Bytes())
-	buffer.Write(coin.Pub.Bytes())
-	buffer.Write(coin.A.Bytes())
-	hashBytes = sha256.Sum256(buffer.Bytes())
-	hash = new(big.Int).SetBytes(hashBytes[:])
+	hash := coinDigest(coin.Version, coin.First, coin.Pub, coin.A)
 
 	// Compute right-side of second property.
-	right = new(big.Int).Mod(
-		new(big.Int).Mul(
-			coin.A,
-			new(big.Int).Exp(bank.Pub, hash, bank.Scheme.P),
-		),
-		bank.Scheme.P,
-	)
+	lookupModulus(bank.Scheme.P).mulMod(right, coin.A, bank.expPub(hash))
 
-	return left.Cmp(right) == 0
+	return equal(alpha, right, bank.Scheme.P)
 }
 
 // Stamp computes the Elgamal's message using some transaction parameters and returns it.
 func (coin *CoinProfile) Stamp(bank *BankProfile, client *ClientProfile) (msg *big.Int) {
 	// Compute the current time as the transaction date (t).
-	t := time.Now()
-	tBytes, _ := t.MarshalBinary()
+	t := Now()
 
-	// Compute the hash of some coin parameters.
-	var buffer bytes.Buffer
-	buffer.Write(coin.Pub.Bytes())
-	buffer.Write(coin.First.Bytes())
-	buffer.Write(client.TradeId.Bytes())
-	buffer.Write(tBytes)
+	// Compute the Elgamal message as the digest of the coin and transaction parameters (d).
+	msg = stampDigest(coin, client.TradeId, t)
 
-	// Compute the Elgamal message as the digest of the coin parameters (d).
-	hashBytes := sha256.Sum256(buffer.Bytes())
-	msg = new(big.Int).SetBytes(hashBytes[:])
+	// Tag the message as not escrowed (lowest bit cleared).
+	msg.SetBit(msg, 0, 0)
 
 	coin.Msg = msg
 
@@ -592,17 +690,17 @@ func (coin *CoinProfile) VerifyElgamal(bank *BankProfile, second *big.Int) bool
 	// Set second on coin.
 	coin.Second = second
 
+	// Temporaries, computed in place.
+	left, power, product := getInt(), getInt(), getInt()
+	defer putInt(left, power, product)
+
 	// Compute left-side of Elgamal's identity.
-	left := new(big.Int).Mod(
-		new(big.Int).Mul(
-			new(big.Int).Exp(coin.Pub, coin.First, bank.Scheme.P),
-			new(big.Int).Exp(coin.First, coin.Second, bank.Scheme.P),
-		),
-		bank.Scheme.P,
-	)
+	left.Exp(coin.Pub, coin.First, bank.Scheme.P)
+	power.Exp(coin.First, coin.Second, bank.Scheme.P)
+	lookupModulus(bank.Scheme.P).reduce(left, product.Mul(left, power))
 
 	// Compute right-side of Elgamal's identity.
-	right := new(big.Int).Exp(bank.Scheme.G, coin.Msg, bank.Scheme.P)
+	right := bank.Scheme.expG(coin.Msg)
 
-	return left.Cmp(right) == 0
+	return equal(left, right, bank.Scheme.P)
 }
@@ -0,0 +1,6 @@
+//go:build race
+
+package core_test
+
+// raceEnabled reports whether the tests run with the race detector, which makes sync.Pool drop recycled values.
+const raceEnabled = true
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+//
+// RANDOMNESS AND TIME
+//
+
+// Every function generating random numbers takes a random reader: a deterministic one for tests, a hardware module's,
+// or nil for Rand. Every date of the protocols is taken from Now. With deterministic sources (NewDeterministicReader
+// and a fixed clock) the protocols are reproducible, which is how the test vectors in testdata/vectors.json are
+// generated and checked.
+
+// Rand is the default source of randomness of the protocols, used when a function is given a nil random reader.
+// Defaults to crypto/rand.
+//
+// RSA key generation isn't reproducible even with a deterministic reader: crypto/rsa randomizes how much it reads.
+var Rand io.Reader = rand.Reader
+
+// source returns random, or Rand if random is nil.
+func source(random io.Reader) io.Reader {
+	if random == nil {
+		return Rand
+	}
+	return random
+}
+
+// Now returns the current time, used to date credentials, coins and transactions. Defaults to time.Now.
+var Now = time.Now
+
+// deterministicReader expands a seed into an infinite stream of bytes, SHA-256(seed || counter) for counter = 0, 1, ...
+type deterministicReader struct {
+	mutex   sync.Mutex
+	seed    []byte
+	counter uint64
+	block   []byte
+}
+
+// NewDeterministicReader returns a reader expanding seed into a reproducible stream of bytes. Only meant for tests and
+// test vectors, never for real keys or coins.
+func NewDeterministicReader(seed []byte) io.Reader {
+	return &deterministicReader{seed: append([]byte(nil), seed...)}
+}
+
+// Read.
+func (r *deterministicReader) Read(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for n < len(p) {
+		if len(r.block) == 0 {
+			h := sha256.New()
+			h.Write(r.seed)
+			binary.Write(h, binary.BigEndian, r.counter)
+			r.block = h.Sum(nil)
+			r.counter++
+		}
+		copied := copy(p[n:], r.block)
+		r.block = r.block[copied:]
+		n += copied
+	}
+	return n, nil
+}
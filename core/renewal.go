@@ -0,0 +1,43 @@
+package core
+
+import "math/big"
+
+//
+// CREDENTIAL RENEWAL
+//
+
+// 1. The Client asks for fresh credentials by signing a fresh nonce sent by the Bank (see NewWithdrawalNonce) and its
+//		public identity, with the RSA key of its ClientProfile: only the owner of the account renews it, even once its
+//		credentials expired, and a request can't be replayed.
+// 2. The Bank issues fresh credentials for the same profile. (See RenewClient)
+
+// renewalDigest computes the digest of nonce and client, the request's signed message.
+func renewalDigest(nonce []byte, client *ClientProfile) *big.Int {
+	return newTranscript("ziba/renewal/request").
+		field(nonce).
+		number(client.Digest()).
+		digest()
+}
+
+// SignRenewal signs a renewal request for the renewal session of nonce, and returns it.
+func (client *Client) SignRenewal(nonce []byte) *RenewalRequest {
+	return &RenewalRequest{Signature: client.Key.sign(renewalDigest(nonce, client.Profile()))}
+}
+
+// Verify verifies req was signed by client for the renewal session of nonce.
+func (req *RenewalRequest) Verify(nonce []byte, client *ClientProfile) error {
+	if len(nonce) != withdrawalNonceSize || req.Signature == nil || client.N == nil || client.E == nil {
+		return ErrRenewalSigned
+	}
+	if req.Signature.Sign() <= 0 || req.Signature.Cmp(client.N) >= 0 {
+		return ErrRenewalSigned
+	}
+
+	// Check s^e = H(nonce, client) mod n.
+	digest := renewalDigest(nonce, client)
+	signed := new(big.Int).Exp(req.Signature, client.E, client.N)
+	if !equal(signed, new(big.Int).Mod(digest, client.N), client.N) {
+		return ErrRenewalSigned
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"crypto/rand"
+	"io"
+	"log"
+	"math/big"
+	"slices"
+)
+
+//
+// REVOCATIONS
+//
+
+// 1. The Bank keeps a list of revoked coins and frozen accounts, and signs it with its private identity number (a
+//		Schnorr signature, so that the RSA key only ever signs coins).
+// 2. Merchants fetch the signed list from the bank's feed and verify it with the bank's public identity number.
+// 3. Before accepting a payment, possibly offline, a merchant rejects revoked coins, and any payment while its own
+//		account is frozen.
+
+// revocationsDigest computes the digest of list's contents, the signed message.
+func revocationsDigest(list *Revocations) *big.Int {
+	t := newTranscript("ziba/revocations").date(list.Issued)
+	t.number(big.NewInt(int64(len(list.Coins))))
+	for _, hash := range list.Coins {
+		t.number(big.NewInt(int64(hash)))
+	}
+	t.number(big.NewInt(int64(len(list.Accounts))))
+	for _, hash := range list.Accounts {
+		t.number(big.NewInt(int64(hash)))
+	}
+	return t.digest()
+}
+
+// challengeDigest computes the Schnorr challenge of the commitment R for digest, under tag.
+func challengeDigest(tag string, R, digest *big.Int) *big.Int {
+	return newTranscript(tag).number(R).number(digest).digest()
+}
+
+// schnorrSign signs digest under tag with bank's private identity number, and returns the commitment and response.
+func (bank *Bank) schnorrSign(random io.Reader, tag string, digest *big.Int) (R, S *big.Int, err error) {
+	// Order of the group. (p - 1)
+	pMinus1 := new(big.Int).Sub(bank.Scheme.P, big.NewInt(1))
+
+	// Generate nonce (k).
+	k, err := rand.Int(source(random), pMinus1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Commitment R = alpha^k, challenge e, response s = k + e * x.
+	R = new(big.Int).Exp(bank.Scheme.G, k, bank.Scheme.P)
+	e := challengeDigest(tag, R, digest)
+	S = new(big.Int).Mod(new(big.Int).Add(k, new(big.Int).Mul(e, bank.Priv)), pMinus1)
+	return R, S, nil
+}
+
+// schnorrVerify reports whether (R, S) is bank's signature of digest under tag.
+func (bank *BankProfile) schnorrVerify(tag string, digest, R, S *big.Int) bool {
+	if R == nil || S == nil || R.Sign() <= 0 || R.Cmp(bank.Scheme.P) >= 0 {
+		return false
+	}
+
+	// Check alpha^s = R * z^e.
+	e := challengeDigest(tag, R, digest)
+	left := expMod(bank.Scheme.G, S, bank.Scheme.P)
+	right := mulMod(R, expMod(bank.Pub, e, bank.Scheme.P), bank.Scheme.P)
+	return equal(left, right, bank.Scheme.P)
+}
+
+// SignRevocations signs list, dated now, and returns it. The hashes are sorted.
+func (bank *Bank) SignRevocations(random io.Reader, list *Revocations) *Revocations {
+	slices.Sort(list.Coins)
+	slices.Sort(list.Accounts)
+	list.Issued = Now().UTC()
+
+	R, S, err := bank.schnorrSign(random, "ziba/revocations/challenge", revocationsDigest(list))
+	if err != nil {
+		log.Printf("failed to generate nonce for Revocations")
+		return nil
+	}
+	list.R, list.S = R, S
+
+	return list
+}
+
+// Verify verifies list was signed by bank, with its hashes sorted.
+func (list *Revocations) Verify(bank *BankProfile) bool {
+	if !slices.IsSorted(list.Coins) || !slices.IsSorted(list.Accounts) {
+		return false
+	}
+	return bank.schnorrVerify("ziba/revocations/challenge", revocationsDigest(list), list.R, list.S)
+}
+
+// Revoked reports whether coin is revoked by list.
+func (list *Revocations) Revoked(coin *CoinProfile) bool {
+	_, found := slices.BinarySearch(list.Coins, coin.Hash())
+	return found
+}
+
+// Frozen reports whether client's account is frozen by list.
+func (list *Revocations) Frozen(client *ClientProfile) bool {
+	_, found := slices.BinarySearch(list.Accounts, client.Hash())
+	return found
+}
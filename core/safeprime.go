@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"math/big"
+)
+
+//
+// SAFE PRIME GENERATION
+//
+
+// A safe prime p = 2q + 1 is found by sieving a window of candidates q = q0 + 6k, starting from a random q0 = 5
+// (mod 6) so that neither q nor p is a multiple of 2 or 3. Candidates where q or p have a small factor are discarded
+// without a primality test, only the rest are tested: first q and p with a single Miller-Rabin round, then both with
+// the full test. Several workers search independent windows at once.
+
+const (
+	// sieveWindow is the number of candidates sieved at once.
+	sieveWindow = 1 << 14
+
+	// sieveBound is the bound of the small primes used for sieving.
+	sieveBound = 1 << 16
+)
+
+// sievePrimes are the odd primes lower than sieveBound, except 3.
+var sievePrimes = func() []uint64 {
+	composite := make([]bool, sieveBound)
+	var primes []uint64
+	for i := 2; i < sieveBound; i++ {
+		if composite[i] {
+			continue
+		}
+		if i > 3 {
+			primes = append(primes, uint64(i))
+		}
+		for j := i * i; j < sieveBound; j += i {
+			composite[j] = true
+		}
+	}
+	return primes
+}()
+
+// GenerateSafePrime returns a Sophie-Germain prime q of the given bit length and its safe prime p = 2q + 1, searching
+// with workers goroutines. Returns ctx's error if ctx is done before a safe prime is found.
+func GenerateSafePrime(ctx context.Context, random io.Reader, bits, workers int) (p, q *big.Int, err error) {
+	if bits < 16 || bits >= MaxModulusBits {
+		return nil, nil, ErrSafePrimeBits
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		q   *big.Int
+		err error
+	}
+	results := make(chan result, workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			q, err := searchSafePrime(ctx, random, bits)
+			results <- result{q, err}
+		}()
+	}
+
+	// Wait for the first worker to finish, the rest are cancelled.
+	res := <-results
+	if res.err != nil {
+		return nil, nil, res.err
+	}
+	p = new(big.Int).Lsh(res.q, 1)
+	p.Add(p, big.NewInt(1))
+	return p, res.q, nil
+}
+
+// searchSafePrime sieves random windows until it finds a Sophie-Germain prime of the given bit length, or ctx is done.
+func searchSafePrime(ctx context.Context, random io.Reader, bits int) (*big.Int, error) {
+	six := big.NewInt(6)
+	composite := make([]bool, sieveWindow)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Random start q0 = 5 (mod 6), with the top two bits set so that every candidate of the window has the right
+		// length.
+		q0, err := rand.Int(source(random), new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+		if err != nil {
+			return nil, err
+		}
+		q0.SetBit(q0, bits-1, 1)
+		q0.SetBit(q0, bits-2, 1)
+		q0.Sub(q0, new(big.Int).Mod(q0, six))
+		q0.Sub(q0, big.NewInt(1))
+
+		// Sieve candidates q = q0 + 6k where r divides q or 2q + 1.
+		clear(composite)
+		rem := new(big.Int)
+		for _, r := range sievePrimes {
+			m := rem.Mod(q0, new(big.Int).SetUint64(r)).Uint64()
+			// Step between candidates is 6 (mod r), first k such that r | q and such that r | 2q + 1.
+			step := 6 % r
+			inv := modInverse(step, r)
+			kq := (r - m) % r * inv % r
+			kp := (r - (2*m+1)%r) % r * modInverse(2*step%r, r) % r
+			for k := kq; k < sieveWindow; k += r {
+				composite[k] = true
+			}
+			for k := kp; k < sieveWindow; k += r {
+				composite[k] = true
+			}
+		}
+
+		// Test the remaining candidates.
+		q := new(big.Int)
+		p := new(big.Int)
+		for k := range composite {
+			if composite[k] {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			q.Add(q0, big.NewInt(int64(6*k)))
+			if q.BitLen() != bits {
+				break
+			}
+			p.Lsh(q, 1)
+			p.Add(p, big.NewInt(1))
+			if !q.ProbablyPrime(1) || !p.ProbablyPrime(1) {
+				continue
+			}
+			if q.ProbablyPrime(20) && p.ProbablyPrime(20) {
+				return q, nil
+			}
+		}
+	}
+}
+
+// modInverse returns the inverse of a mod the prime r, for 0 < a < r.
+func modInverse(a, r uint64) uint64 {
+	return new(big.Int).ModInverse(new(big.Int).SetUint64(a), new(big.Int).SetUint64(r)).Uint64()
+}
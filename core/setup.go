@@ -0,0 +1,52 @@
+package core
+
+import (
+	"io"
+	"log"
+	"math/big"
+)
+
+//
+// SETUP
+//
+
+// 1. The Bank signs its name, the fingerprint of its TLS certificate and the fingerprint of its scheme parameters
+//		with its private identity number, and sends the signed bundle along with its certificate at Setup.
+// 2. The client keeps the bundle, and checks that its certificate matches it.
+// 3. At Accgen, the client verifies the bundle against the BankProfile it receives, and the certificate presented by
+//		the server against the bundle, so that a certificate swapped at the first (plaintext) contact is detected.
+
+// setupDigest computes the digest of bundle's contents, the signed message.
+func setupDigest(bundle *SetupBundle) *big.Int {
+	return newTranscript("ziba/setup").field([]byte(bundle.Name)).field([]byte(bundle.Cert)).field([]byte(bundle.Params)).digest()
+}
+
+// SignSetup signs the bundle of bank's name and the fingerprint of its certificate ("" if publicly trusted), along
+// with the fingerprint of its scheme parameters, and returns it.
+func (bank *Bank) SignSetup(random io.Reader, name, cert string) *SetupBundle {
+	bundle := &SetupBundle{
+		Name:   name,
+		Cert:   cert,
+		Params: bank.Scheme.Fingerprint(),
+	}
+
+	R, S, err := bank.schnorrSign(random, "ziba/setup/challenge", setupDigest(bundle))
+	if err != nil {
+		log.Printf("failed to generate nonce for SetupBundle")
+		return nil
+	}
+	bundle.R, bundle.S = R, S
+
+	return bundle
+}
+
+// Verify verifies bundle was signed by bank, for its scheme parameters.
+func (bundle *SetupBundle) Verify(bank *BankProfile) error {
+	if bundle.Params != bank.Scheme.Fingerprint() {
+		return ErrSetupSignature
+	}
+	if !bank.schnorrVerify("ziba/setup/challenge", setupDigest(bundle), bundle.R, bundle.S) {
+		return ErrSetupSignature
+	}
+	return nil
+}
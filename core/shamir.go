@@ -0,0 +1,217 @@
+package core
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+)
+
+//
+// KEY SHARING
+//
+
+// 1. The Bank splits its private identity (Priv and the RSA key) into n shares using Shamir's secret sharing, any k of
+// 		them allow to reconstruct it.
+// 2. The shares are handed to different custodians.
+// 3. The Bank is reconstructed by interpolating k shares, the result is verified against the bank's public identity.
+
+// shamirPrime is the Mersenne prime 2^2281 - 1, the field used for sharing. Every shared secret must be lower than it.
+var shamirPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 2281), big.NewInt(1))
+
+// splitSecret splits secret into n shares over the field of order prime, any k of them reconstruct it. Share i is the
+// polynomial evaluated at i + 1.
+func splitSecret(random io.Reader, secret *big.Int, k, n int, prime *big.Int) ([]*big.Int, error) {
+	// Random polynomial of degree k - 1 with secret as constant term.
+	coefficients := make([]*big.Int, k)
+	coefficients[0] = secret
+	for i := 1; i < k; i++ {
+		c, err := rand.Int(source(random), prime)
+		if err != nil {
+			return nil, err
+		}
+		coefficients[i] = c
+	}
+
+	// Evaluate the polynomial at x = 1, ..., n. (Horner's method)
+	shares := make([]*big.Int, n)
+	for i := range shares {
+		x := big.NewInt(int64(i + 1))
+		y := new(big.Int)
+		for j := k - 1; j >= 0; j-- {
+			y.Mul(y, x)
+			y.Add(y, coefficients[j])
+			y.Mod(y, prime)
+		}
+		shares[i] = y
+	}
+
+	return shares, nil
+}
+
+// lagrange returns the numerator and denominator of the Lagrange basis polynomial for xs[i], evaluated at x = 0.
+func lagrange(xs []int, i int) (num, den *big.Int) {
+	num = big.NewInt(1)
+	den = big.NewInt(1)
+	for j := range xs {
+		if i == j {
+			continue
+		}
+		num.Mul(num, big.NewInt(int64(-xs[j])))
+		den.Mul(den, big.NewInt(int64(xs[i]-xs[j])))
+	}
+	return num, den
+}
+
+// combineShares reconstructs the secret from the shares ys evaluated at xs over the field of order prime, using
+// Lagrange interpolation at x = 0.
+func combineShares(xs []int, ys []*big.Int, prime *big.Int) *big.Int {
+	secret := new(big.Int)
+	for i := range xs {
+		num, den := lagrange(xs, i)
+		num.Mod(num, prime)
+		den.Mod(den, prime)
+		den.ModInverse(den, prime)
+
+		term := new(big.Int).Mul(ys[i], num)
+		term.Mul(term, den)
+		secret.Add(secret, term)
+		secret.Mod(secret, prime)
+	}
+
+	return secret
+}
+
+// Split splits bank's private identity into n shares, any k of them reconstruct the bank. Secrets that don't fit the
+// sharing field (keys above 2281 bits) are refused with ErrShareSecret.
+func (bank *Bank) Split(random io.Reader, k, n int) ([]BankShare, error) {
+	// Check threshold.
+	if k < 1 || n < k || n > 255 {
+		return nil, ErrShareThreshold
+	}
+
+	// Split each secret. (Secrets must lie in the field)
+	secrets := []*big.Int{bank.Priv, bank.Key.P, bank.Key.Q, bank.Key.D}
+	split := make([][]*big.Int, len(secrets))
+	for i, secret := range secrets {
+		if secret.Sign() < 0 || secret.Cmp(shamirPrime) >= 0 {
+			return nil, ErrShareSecret
+		}
+		ys, err := splitSecret(random, secret, k, n, shamirPrime)
+		if err != nil {
+			return nil, err
+		}
+		split[i] = ys
+	}
+
+	// Build shares.
+	profile := bank.Profile()
+	shares := make([]BankShare, n)
+	for i := range shares {
+		shares[i] = BankShare{
+			Index:     i + 1,
+			Threshold: k,
+			Profile:   *profile,
+			Priv:      split[0][i],
+			P:         split[1][i],
+			Q:         split[2][i],
+			D:         split[3][i],
+		}
+	}
+
+	return shares, nil
+}
+
+// RestoreBank allocates and returns the bank reconstructed from shares. The reconstruction is verified against the
+// bank's public identity contained in the shares.
+func RestoreBank(shares []BankShare) (*Bank, error) {
+	if len(shares) == 0 {
+		return nil, ErrShareCount
+	}
+
+	// Check that all shares belong to the same bank, and discard duplicates.
+	threshold := shares[0].Threshold
+	profile := shares[0].Profile
+	seen := make(map[int]bool)
+	var selected []BankShare
+	for _, share := range shares {
+		if !share.complete() {
+			return nil, ErrShareInvalid
+		}
+		if share.Threshold != threshold || !sameBankProfile(&share.Profile, &profile) {
+			return nil, ErrShareMismatch
+		}
+		if share.Index < 1 || seen[share.Index] {
+			continue
+		}
+		seen[share.Index] = true
+		selected = append(selected, share)
+	}
+	if threshold < 1 || len(selected) < threshold {
+		return nil, ErrShareCount
+	}
+	selected = selected[:threshold]
+
+	// Interpolate each secret.
+	xs := make([]int, threshold)
+	ys := make([][]*big.Int, 4)
+	for i, share := range selected {
+		xs[i] = share.Index
+		ys[0] = append(ys[0], share.Priv)
+		ys[1] = append(ys[1], share.P)
+		ys[2] = append(ys[2], share.Q)
+		ys[3] = append(ys[3], share.D)
+	}
+	bank := &Bank{
+		Scheme: profile.Scheme,
+		Key: RsaKey{
+			P: combineShares(xs, ys[1], shamirPrime),
+			Q: combineShares(xs, ys[2], shamirPrime),
+			N: profile.N,
+			D: combineShares(xs, ys[3], shamirPrime),
+			E: profile.E,
+		},
+		Priv:     combineShares(xs, ys[0], shamirPrime),
+		Pub:      profile.Pub,
+		Currency: profile.Currency,
+	}
+
+	// Verify z = alpha^x (mod p).
+	pub := new(big.Int).Exp(bank.Scheme.G, bank.Priv, bank.Scheme.P)
+	if !equal(pub, bank.Pub, bank.Scheme.P) {
+		return nil, ErrShareInvalid
+	}
+
+	// Verify N = P * Q.
+	n := new(big.Int).Mul(bank.Key.P, bank.Key.Q)
+	if n.Cmp(bank.Key.N) != 0 {
+		return nil, ErrShareInvalid
+	}
+
+	// Verify (m^e)^d = m (mod N).
+	m := big.NewInt(2)
+	c := new(big.Int).Exp(m, bank.Key.E, bank.Key.N)
+	if !equal(c.Exp(c, bank.Key.D, bank.Key.N), m, bank.Key.N) {
+		return nil, ErrShareInvalid
+	}
+
+	return bank, nil
+}
+
+// complete reports whether every field of share is set.
+func (share *BankShare) complete() bool {
+	profile := &share.Profile
+	return share.Priv != nil && share.P != nil && share.Q != nil && share.D != nil &&
+		profile.Pub != nil && profile.N != nil && profile.E != nil &&
+		profile.Scheme.Q != nil && profile.Scheme.P != nil && profile.Scheme.G != nil
+}
+
+// sameBankProfile reports whether a and b are the same bank's public identity.
+func sameBankProfile(a, b *BankProfile) bool {
+	return a.Pub.Cmp(b.Pub) == 0 &&
+		a.N.Cmp(b.N) == 0 &&
+		a.E.Cmp(b.E) == 0 &&
+		a.Scheme.Q.Cmp(b.Scheme.Q) == 0 &&
+		a.Scheme.P.Cmp(b.Scheme.P) == 0 &&
+		a.Scheme.G.Cmp(b.Scheme.G) == 0 &&
+		a.Currency == b.Currency
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"log"
+	"math/big"
+	"sync"
+)
+
+//
+// SIDE CHANNELS
+//
+
+// 1. The private RSA operations (the bank's blind signatures, the clients' signatures) are blinded: x is signed as
+//		x * r^e, for a fresh random r, and the signature unblinded by r^-1. The time taken doesn't depend on x, chosen by
+//		the client. The key is precomputed by crypto/rsa (its CRT values), which has no raw private operation: the
+//		exponentiations themselves are math/big's, and the signature is checked against the public key before being
+//		returned. The blinding factors are read from crypto/rand, not Rand: they don't change the signature, the test
+//		vectors stay reproducible.
+// 2. The signature checks compare numbers derived from secrets in constant time, using equal.
+// 3. The partial responses of threshold nodes aren't blinded: a share's exponent isn't the inverse of e, r^e isn't
+//		unblinded by it.
+
+// signersCacheSize bounds the number of cached signers. (The bank's keys, and the wallet's)
+const signersCacheSize = 16
+
+// signer is an RSA key, along with its CRT values if known.
+type signer struct {
+	key  *RsaKey
+	priv *rsa.PrivateKey // nil if the primes of key are unknown or invalid.
+}
+
+// signersCache holds the signers built so far.
+var signersCache struct {
+	sync.RWMutex
+	signers []*signer
+}
+
+// newSigner allocates and returns the signer of key.
+func newSigner(key *RsaKey) *signer {
+	s := &signer{key: key}
+	if key.P == nil || key.Q == nil || !key.E.IsInt64() {
+		return s
+	}
+	priv := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: key.N, E: int(key.E.Int64())},
+		D:         key.D,
+		Primes:    []*big.Int{key.P, key.Q},
+	}
+	priv.Precompute()
+	if priv.Precomputed.Dp != nil && priv.Precomputed.Dq != nil && priv.Precomputed.Qinv != nil {
+		s.priv = priv
+	}
+	return s
+}
+
+// lookupSigner returns the cached signer of key, building it if needed.
+func lookupSigner(key *RsaKey) *signer {
+	signersCache.RLock()
+	for _, s := range signersCache.signers {
+		if s.key.N.Cmp(key.N) == 0 && s.key.D.Cmp(key.D) == 0 {
+			signersCache.RUnlock()
+			return s
+		}
+	}
+	signersCache.RUnlock()
+
+	signersCache.Lock()
+	defer signersCache.Unlock()
+	for _, s := range signersCache.signers {
+		if s.key.N.Cmp(key.N) == 0 && s.key.D.Cmp(key.D) == 0 {
+			return s
+		}
+	}
+	s := newSigner(&RsaKey{P: key.P, Q: key.Q, N: key.N, D: key.D, E: key.E})
+	if len(signersCache.signers) < signersCacheSize {
+		signersCache.signers = append(signersCache.signers, s)
+	}
+	return s
+}
+
+// exp returns c^d mod N, using the CRT values if known.
+func (s *signer) exp(c *big.Int) *big.Int {
+	if s.priv == nil {
+		return new(big.Int).Exp(c, s.key.D, s.key.N)
+	}
+	p, q := s.priv.Primes[0], s.priv.Primes[1]
+
+	// m1 = c^dP mod p, m2 = c^dQ mod q, m = m2 + q * (qInv * (m1 - m2) mod p).
+	m1 := new(big.Int).Exp(c, s.priv.Precomputed.Dp, p)
+	m2 := new(big.Int).Exp(c, s.priv.Precomputed.Dq, q)
+	m1.Sub(m1, m2)
+	m1.Mul(m1, s.priv.Precomputed.Qinv)
+	m1.Mod(m1, p)
+	m1.Mul(m1, q)
+	return m1.Add(m1, m2)
+}
+
+// sign returns x^d mod N, blinded.
+func (key *RsaKey) sign(x *big.Int) *big.Int {
+	s := lookupSigner(key)
+	x = new(big.Int).Mod(x, key.N)
+
+	// Choose a blinding factor (r), a unit mod N.
+	var r, rInv *big.Int
+	for rInv == nil {
+		var err error
+		r, err = rand.Int(rand.Reader, key.N)
+		if err != nil {
+			log.Fatalf("failed to generate blinding factor: %v", err)
+		}
+		rInv = new(big.Int).ModInverse(r, key.N)
+	}
+
+	// Sign x * r^e, and unblind by r^-1.
+	blinded := new(big.Int).Exp(r, key.E, key.N)
+	blinded.Mul(blinded, x)
+	blinded.Mod(blinded, key.N)
+	signature := s.exp(blinded)
+	signature.Mul(signature, rInv)
+	signature.Mod(signature, key.N)
+
+	// Check the signature, against faults of the CRT.
+	if !equal(new(big.Int).Exp(signature, key.E, key.N), x, key.N) {
+		log.Printf("== ALERT: RSA signature failed its check, signing without the CRT")
+		return new(big.Int).Exp(x, key.D, key.N)
+	}
+	return signature
+}
+
+// equal reports whether x and y, numbers mod m, are equal, in a time depending only on the size of m.
+func equal(x, y, m *big.Int) bool {
+	if x.Sign() < 0 || y.Sign() < 0 || x.BitLen() > m.BitLen() || y.BitLen() > m.BitLen() {
+		return false
+	}
+	size := (m.BitLen() + 7) / 8
+	return subtle.ConstantTimeCompare(x.FillBytes(make([]byte, size)), y.FillBytes(make([]byte, size))) == 1
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+	"time"
+)
+
+//
+// THRESHOLD WITHDRAWAL
+//
+
+// 1. The Bank splits its signing keys between n bank nodes, any t of them can answer a coin request together:
+// 		Priv is shared over Z_q, and D is shared over the integers (Shoup's threshold RSA).
+// 2. The coordinating server chooses the coin's expiration date and sends the coin request to t nodes.
+// 3. Each node answers with a partial response computed using its share.
+// 4. The coordinating server combines the partial responses into A' and c', and verifies them before answering.
+
+// factorial returns n!.
+func factorial(n int) *big.Int {
+	return new(big.Int).MulRange(1, int64(n))
+}
+
+// SplitThreshold splits bank's signing keys into n shares, any t of them answer coin requests together.
+func (bank *Bank) SplitThreshold(random io.Reader, t, n int) ([]ThresholdShare, error) {
+	// Check threshold. (The combination requires gcd(n!, e) = 1)
+	if t < 1 || n < t || n > 255 {
+		return nil, ErrShareThreshold
+	}
+	if new(big.Int).GCD(nil, nil, factorial(n), bank.Key.E).Cmp(big.NewInt(1)) != 0 {
+		return nil, ErrShareThreshold
+	}
+
+	// Share Priv over Z_q.
+	priv := new(big.Int).Mod(bank.Priv, bank.Scheme.Q)
+	privShares, err := splitSecret(random, priv, t, n, bank.Scheme.Q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share D over the integers. (Coefficients are 128 bits larger than N to statistically hide D)
+	bound := new(big.Int).Lsh(bank.Key.N, 128)
+	coefficients := make([]*big.Int, t)
+	coefficients[0] = bank.Key.D
+	for i := 1; i < t; i++ {
+		c, err := rand.Int(source(random), bound)
+		if err != nil {
+			return nil, err
+		}
+		coefficients[i] = c
+	}
+
+	// Build shares.
+	profile := bank.Profile()
+	shares := make([]ThresholdShare, n)
+	for i := range shares {
+		x := big.NewInt(int64(i + 1))
+		d := new(big.Int)
+		for j := t - 1; j >= 0; j-- {
+			d.Mul(d, x)
+			d.Add(d, coefficients[j])
+		}
+		shares[i] = ThresholdShare{
+			Index:     i + 1,
+			Threshold: t,
+			Total:     n,
+			Profile:   *profile,
+			Priv:      privShares[i],
+			D:         d,
+		}
+	}
+
+	return shares, nil
+}
+
+// NewPartialResponse computes this node's partial response to a coin request, for the expiration date chosen by the
+// coordinating server.
+func (share *ThresholdShare) NewPartialResponse(Expiration time.Time, ALower *big.Int, C *big.Int) *PartialResponse {
+	// Compute the message signed by A' (a * FDH(t)).
+	msg := new(big.Int).Mod(new(big.Int).Mul(ALower, expirationDigest(Expiration, share.Profile.N)), share.Profile.N)
+
+	return &PartialResponse{
+		Index:     share.Index,
+		Threshold: share.Threshold,
+		Total:     share.Total,
+		A1:        new(big.Int).Exp(msg, share.D, share.Profile.N),
+		C1:        new(big.Int).Mod(new(big.Int).Mul(C, share.Priv), share.Profile.Scheme.Q),
+	}
+}
+
+// CombineCoinResponse combines the partial responses of the bank nodes into the final coin response, and verifies it.
+// Expiration must be the date sent to the nodes.
+func (bank *BankProfile) CombineCoinResponse(client *ClientInfo, ALower *big.Int, C *big.Int, Expiration time.Time, partials []PartialResponse) (A1 *big.Int, C1 *big.Int, err error) {
+	if len(partials) == 0 {
+		return nil, nil, ErrShareCount
+	}
+
+	// Check that all partials use the same sharing, and discard duplicates.
+	threshold := partials[0].Threshold
+	total := partials[0].Total
+	seen := make(map[int]bool)
+	var selected []PartialResponse
+	for _, partial := range partials {
+		if partial.Threshold != threshold || partial.Total != total {
+			return nil, nil, ErrShareMismatch
+		}
+		if partial.A1 == nil || partial.C1 == nil {
+			return nil, nil, ErrPartialInvalid
+		}
+		if partial.Index < 1 || partial.Index > total || seen[partial.Index] {
+			continue
+		}
+		seen[partial.Index] = true
+		selected = append(selected, partial)
+	}
+	if threshold < 1 || len(selected) < threshold {
+		return nil, nil, ErrShareCount
+	}
+	selected = selected[:threshold]
+	xs := make([]int, threshold)
+	for i, partial := range selected {
+		xs[i] = partial.Index
+	}
+
+	// Combine A' partials into w = m^(n! * D) (mod N).
+	delta := factorial(total)
+	w := big.NewInt(1)
+	for i, partial := range selected {
+		num, den := lagrange(xs, i)
+		lambda := new(big.Int).Mul(delta, num)
+		lambda.Quo(lambda, den)
+		term := new(big.Int).Exp(partial.A1, lambda, bank.N)
+		if term == nil {
+			return nil, nil, ErrPartialInvalid
+		}
+		w = mulMod(w, term, bank.N)
+	}
+
+	// Compute A' = w^a * m^b (mod N), where a * n! + b * e = 1.
+	msg := new(big.Int).Mod(new(big.Int).Mul(ALower, expirationDigest(Expiration, bank.N)), bank.N)
+	a := new(big.Int)
+	b := new(big.Int)
+	new(big.Int).GCD(a, b, delta, bank.E)
+	left := new(big.Int).Exp(w, a, bank.N)
+	right := new(big.Int).Exp(msg, b, bank.N)
+	if left == nil || right == nil {
+		return nil, nil, ErrPartialInvalid
+	}
+	A1 = mulMod(left, right, bank.N)
+
+	// Verify (A')^e = m (mod N).
+	if !equal(new(big.Int).Exp(A1, bank.E, bank.N), msg, bank.N) {
+		return nil, nil, ErrPartialInvalid
+	}
+
+	// Combine c' partials over Z_q and add s.
+	C1 = new(big.Int).Mod(client.S, bank.Scheme.Q)
+	for i, partial := range selected {
+		num, den := lagrange(xs, i)
+		num.Mod(num, bank.Scheme.Q)
+		den.Mod(den, bank.Scheme.Q)
+		den.ModInverse(den, bank.Scheme.Q)
+		term := new(big.Int).Mul(partial.C1, num)
+		term.Mul(term, den)
+		C1.Add(C1, term)
+		C1.Mod(C1, bank.Scheme.Q)
+	}
+
+	// Verify alpha^c' = z^c * v (mod p).
+	left = bank.Scheme.expG(C1)
+	right = bank.expPub(C)
+	right.Mul(right, client.Credential)
+	right.Mod(right, bank.Scheme.P)
+	if !equal(left, right, bank.Scheme.P) {
+		return nil, nil, ErrPartialInvalid
+	}
+
+	return A1, C1, nil
+}
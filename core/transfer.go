@@ -0,0 +1,49 @@
+package core
+
+import "math/big"
+
+//
+// TRANSFER
+//
+
+// 1. The Client transfers part of its balance to another account of the Bank, named by the hash of its ClientProfile,
+//		without handling coins: e.g. when the payee doesn't run a wallet.
+// 2. The Client signs a fresh nonce sent by the Bank (see NewWithdrawalNonce), its public identity, the payee and the
+//		amount, with the RSA key of its ClientProfile: only the owner of the account debits it, and a request can't be
+//		replayed.
+// 3. The Bank debits the Client's balance and credits the payee's at once.
+
+// transferDigest computes the digest of nonce, client and req, the request's signed message.
+func transferDigest(nonce []byte, client *ClientProfile, req *TransferRequest) *big.Int {
+	return newTranscript("ziba/transfer/request").
+		field(nonce).
+		number(client.Digest()).
+		number(new(big.Int).SetUint64(uint64(req.Payee))).
+		field([]byte(NormalizeCurrency(req.Currency))).
+		number(big.NewInt(req.Value)).
+		digest()
+}
+
+// SignTransfer signs req for the transfer session of nonce, and returns it.
+func (client *Client) SignTransfer(nonce []byte, req *TransferRequest) *TransferRequest {
+	req.Signature = client.Key.sign(transferDigest(nonce, client.Profile(), req))
+	return req
+}
+
+// Verify verifies req was signed by client for the transfer session of nonce.
+func (req *TransferRequest) Verify(nonce []byte, client *ClientProfile) error {
+	if len(nonce) != withdrawalNonceSize || req.Signature == nil || client.N == nil || client.E == nil {
+		return ErrTransferSigned
+	}
+	if req.Value <= 0 || req.Signature.Sign() <= 0 || req.Signature.Cmp(client.N) >= 0 {
+		return ErrTransferSigned
+	}
+
+	// Check s^e = H(nonce, client, request) mod n.
+	digest := transferDigest(nonce, client, req)
+	signed := new(big.Int).Exp(req.Signature, client.E, client.N)
+	if !equal(signed, new(big.Int).Mod(digest, client.N), client.N) {
+		return ErrTransferSigned
+	}
+	return nil
+}
@@ -48,6 +48,9 @@ type Bank struct {
 
 	// Pub (z) represents a bank's public identity number.
 	Pub *big.Int
+
+	// Currency is the currency of the coins signed with Key, see DefaultCurrency.
+	Currency string
 }
 
 // BankProfile represents a bank's public identity inside the scheme. Used by clients to perform protocols with this bank.
@@ -63,6 +66,9 @@ type BankProfile struct {
 
 	// E is the bank's RSA key public exponent.
 	E *big.Int
+
+	// Currency is the currency of the coins signed with the RSA key (N, E), see DefaultCurrency.
+	Currency string
 }
 
 // Client represents a client's identity inside the scheme. Only used by a client.
@@ -87,6 +93,9 @@ type Client struct {
 
 	// Contract (R) represents an identifier issued and signed by a bank for this client.
 	Contract *big.Int
+
+	// Expiration is the date after which the credentials must be renewed.
+	Expiration time.Time
 }
 
 // ClientProfile represents a client's public identity inside the scheme. Used by a bank to generate a client's account.
@@ -126,6 +135,9 @@ type ClientInfo struct {
 
 	// Contract (R) is an identifier issue for this client's public identity.
 	Contract *big.Int
+
+	// Expiration is the date after which the credentials must be renewed.
+	Expiration time.Time
 }
 
 // CoinRandom contains all random parameters generated during a withdrawal protocol.
@@ -198,6 +210,15 @@ type CoinParams struct {
 
 	// R is a parameter computed by the client.
 	R *big.Int
+
+	// Version is the encoding of the coin's signed values, see CoinVersionCanonical.
+	Version int
+
+	// Currency is the currency of the bank's mint that signed the coin.
+	Currency string
+
+	// Value is the coin's value signed by the bank, see NormalizeValue.
+	Value int64
 }
 
 // Coin represents a complete coin and its associated parameters.
@@ -232,9 +253,292 @@ type CoinProfile struct {
 	// Expiration (t) is the coin's expiration date.
 	Expiration time.Time
 
-	// Second (gamma) is the Elgamal's signature second component.
-	Second *big.Int
+	// Second (gamma) is the Elgamal's signature second component. Nil before the payment.
+	Second *big.Int `ziba:"optional"`
 
-	// Msg (d) is the Elgamal's signature message.
-	Msg *big.Int
+	// Msg (d) is the Elgamal's signature message. Nil before the payment.
+	Msg *big.Int `ziba:"optional"`
+
+	// Version is the encoding of the coin's signed values, see CoinVersionCanonical.
+	Version int
+
+	// Currency is the currency of the bank's mint that signed the coin.
+	Currency string
+
+	// Value is the coin's value signed by the bank, see NormalizeValue.
+	Value int64
+}
+
+// Escrow contains the conditions a coin is signed to during an escrowed payment.
+type Escrow struct {
+	// Release is the digest of the release secret. Its preimage allows the payee to deposit the coin.
+	Release *big.Int
+
+	// Refund is the digest of the refund secret. Its preimage allows the payer to reclaim the coin after Timeout.
+	Refund *big.Int
+
+	// Timeout is the date after which the payee can no longer deposit the coin.
+	Timeout time.Time
+
+	// Payee is the payee's transaction identifier (ID_M). Nil until stamped.
+	Payee *big.Int `ziba:"optional"`
+
+	// Date is the transaction date (t) choosen by the payee.
+	Date time.Time
+}
+
+// Memo is a short reference (an order id, a note) attached by the payer to a payment, and the payment's binding to the
+// payee's account.
+type Memo struct {
+	// Text is the reference, at most MaxMemoLength bytes.
+	Text string
+
+	// Payee is the payee's transaction identifier (ID_M). Nil until stamped.
+	Payee *big.Int `ziba:"optional"`
+
+	// Date is the transaction date (t) choosen by the payee.
+	Date time.Time
+
+	// Account is the digest of the payee's ClientProfile, so that only the payee's account can deposit the coin. Nil
+	// for memos stamped before the account binding.
+	Account *big.Int `ziba:"optional"`
+
+	// Change is set if the payer spent only part of the coin's value.
+	Change *Change
+}
+
+// Change contains the conditions of a partial payment: the spent part of a coin's value and the payer's request for
+// the remainder coin.
+type Change struct {
+	// Amount is the spent part of the coin's value, the payee is credited with it.
+	Amount int64
+
+	// ALower (a) is the remainder coin request's blind signature envelope.
+	ALower *big.Int
+
+	// C (c) is the remainder coin request's signature envelope.
+	C *big.Int
+
+	// Claim is the digest of the claim secret. Its preimage allows the payer to collect the remainder coin.
+	Claim *big.Int
+}
+
+// Revocations is a bank's signed list of revoked coins and frozen accounts, published to merchants.
+type Revocations struct {
+	// Coins are the hashes of the revoked coins. (See CoinProfile.Hash)
+	Coins []uint32
+
+	// Accounts are the hashes of the frozen accounts. (See ClientProfile.Hash)
+	Accounts []uint32
+
+	// Issued is the date the list was signed.
+	Issued time.Time
+
+	// R is the Schnorr signature's commitment, computed with the bank's private identity number.
+	R *big.Int
+
+	// S is the Schnorr signature's response.
+	S *big.Int
+}
+
+// Rate is a bank's exchange rate between two of its currencies, a value in From is worth Num/Den times the value in
+// To.
+type Rate struct {
+	From string
+	To   string
+	Num  int64
+	Den  int64
+}
+
+// BankShare is one of the n shares of a bank's private identity, any Threshold of them reconstruct it.
+type BankShare struct {
+	// Index is the share's evaluation point, in the range [1, n].
+	Index int
+
+	// Threshold (k) is the number of shares needed to reconstruct the bank.
+	Threshold int
+
+	// Profile is the bank's public identity. Used to verify the reconstruction.
+	Profile BankProfile
+
+	// Priv is the share of the bank's private identity number.
+	Priv *big.Int
+
+	// P is the share of the RSA key's first prime.
+	P *big.Int
+
+	// Q is the share of the RSA key's second prime.
+	Q *big.Int
+
+	// D is the share of the RSA key's private exponent.
+	D *big.Int
+}
+
+// ThresholdShare is the share of a bank's signing keys held by one of n bank nodes, any Threshold of them answer coin
+// requests together.
+type ThresholdShare struct {
+	// Index is the node's evaluation point, in the range [1, Total].
+	Index int
+
+	// Threshold (t) is the number of nodes needed to answer a coin request.
+	Threshold int
+
+	// Total (n) is the number of nodes.
+	Total int
+
+	// Profile is the bank's public identity.
+	Profile BankProfile
+
+	// Priv is the share of the bank's private identity number, over Z_q.
+	Priv *big.Int
+
+	// D is the share of the RSA key's private exponent, over the integers.
+	D *big.Int
+}
+
+// PartialResponse is a bank node's contribution to a coin response.
+type PartialResponse struct {
+	// Index is the node's evaluation point.
+	Index int
+
+	// Threshold (t) is the number of nodes needed to answer a coin request.
+	Threshold int
+
+	// Total (n) is the number of nodes.
+	Total int
+
+	// A1 is the node's partial blind signature on A.
+	A1 *big.Int
+
+	// C1 is the node's partial signature on c.
+	C1 *big.Int
+}
+
+// DepositAuthorization is a client's consent to have the coins deposited by another account (the collector) credited
+// to its own balance.
+type DepositAuthorization struct {
+	// Collector is the identity hash of the depositing client.
+	Collector *big.Int
+
+	// Beneficiary is the public identity of the credited client.
+	Beneficiary ClientProfile
+
+	// Signature is the beneficiary's RSA signature on the collector and itself.
+	Signature *big.Int
+}
+
+// SetupBundle is a bank's signed name and certificate, sent at Setup and verified once its BankProfile is received.
+type SetupBundle struct {
+	// Name is the bank's name.
+	Name string
+
+	// Cert is the SHA-256 fingerprint of the bank's TLS certificate, in hex. (Empty if publicly trusted)
+	Cert string
+
+	// Params is the fingerprint of the bank's scheme parameters. (See SchemeParams.Fingerprint)
+	Params string
+
+	// R is the Schnorr signature's commitment, computed with the bank's private identity number.
+	R *big.Int
+
+	// S is the Schnorr signature's response.
+	S *big.Int
+}
+
+// WithdrawalRequest is a client's coin request, bound to its account by its signature over the bank's nonce.
+type WithdrawalRequest struct {
+	// ALower (a) is the coin request's blind signature envelope.
+	ALower *big.Int
+
+	// C (c) is the coin request's signature envelope.
+	C *big.Int
+
+	// Currency and Value are the withdrawn amount.
+	Currency string
+	Value    int64
+
+	// Token is the withdrawal's idempotency token.
+	Token string
+
+	// Signature is the client's RSA signature on the nonce, its public identity and the request.
+	Signature *big.Int
+}
+
+// ClosureRequest is a client's request to close its account, surrendering its unspent coins, bound to the account by
+// its signature over the bank's nonce.
+type ClosureRequest struct {
+	// Coins are the surrendered coins, credited to the account before its balances are paid out.
+	Coins []CoinProfile
+
+	// Signature is the client's RSA signature on the nonce, its public identity and the surrendered coins.
+	Signature *big.Int
+}
+
+// TransferRequest is a client's request to transfer part of its balance to another account of the bank, bound to the
+// account by its signature over the bank's nonce.
+type TransferRequest struct {
+	// Payee is the hash of the credited account's ClientProfile.
+	Payee uint32
+
+	// Currency and Value are the amount transferred, debited from the client's balance in Currency.
+	Currency string
+	Value    int64
+
+	// Signature is the client's RSA signature on the nonce, its public identity, the payee and the amount.
+	Signature *big.Int
+}
+
+// RenewalRequest is a client's request for fresh credentials, bound to the account by its signature over the bank's
+// nonce.
+type RenewalRequest struct {
+	// Signature is the client's RSA signature on the nonce and its public identity.
+	Signature *big.Int
+}
+
+// AttestationRequest is a merchant's request for its bank to attest the fingerprint of its TLS certificate.
+type AttestationRequest struct {
+	// Merchant is the public identity of the merchant's account.
+	Merchant ClientProfile
+
+	// Fingerprint is the SHA-256 fingerprint of the merchant's certificate, in hex.
+	Fingerprint string
+
+	// Signature is the merchant's RSA signature on itself and the fingerprint.
+	Signature *big.Int
+}
+
+// Attestation is a bank's statement that a TLS certificate belongs to the merchant of one of its accounts, handed out
+// by the merchant along with its certificate.
+type Attestation struct {
+	// Merchant is the public identity of the merchant's account.
+	Merchant ClientProfile
+
+	// Fingerprint is the SHA-256 fingerprint of the merchant's certificate, in hex.
+	Fingerprint string
+
+	// Issued is the date the attestation was signed.
+	Issued time.Time
+
+	// R is the Schnorr signature's commitment, computed with the bank's private identity number.
+	R *big.Int
+
+	// S is the Schnorr signature's response.
+	S *big.Int
+}
+
+// Dispute is a bank's evidence against a coin surrendered twice, or against an invalid coin, verifiable by anyone
+// holding the bank's public profile.
+type Dispute struct {
+	// Kind is DisputeDoubleSpend or DisputeInvalidCoin.
+	Kind int
+
+	// Mint is the public profile of the bank's mint of the coin's currency.
+	Mint BankProfile
+
+	// Coins are the versions of the coin: the one surrendered first and the other one for DisputeDoubleSpend, the
+	// coin refused for DisputeInvalidCoin.
+	Coins []CoinProfile
+
+	// Date is the date the bank detected the dispute.
+	Date time.Time
 }
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+//
+// VALIDATION
+//
+
+// Every value received from the network is checked before being used in a computation:
+// 	- Group elements (credentials, Elgamal's keys, A) must be quadratic residues mod p other than 1, that is, members
+// 		of the subgroup of prime order q. (The Jacobi symbol is much cheaper than checking x^q = 1)
+// 	- Exponents and RSA values must lie in their range.
+// 	- Messages are checked for missing values as they are decoded, before any computation. (See CheckMessage)
+// 	- No value is larger than the largest modulus, MaxModulusBits as decoded, the bank's p or N once validated: a peer
+// 		can't make Exp run on megabytes.
+
+// MaxModulusBits is the bit length of the largest modulus of a scheme, p or an RSA N.
+const MaxModulusBits = 8192
+
+// digestBound is the upper bound of a SHA256 digest (2^256).
+var digestBound = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// checkPresent returns a ValidationError if x is nil.
+func checkPresent(field string, x *big.Int) error {
+	if x == nil {
+		return &ValidationError{Field: field, Err: ErrMissingValue}
+	}
+	return nil
+}
+
+// checkSize returns a ValidationError if x is nil or longer than bits.
+func checkSize(field string, x *big.Int, bits int) error {
+	if err := checkPresent(field, x); err != nil {
+		return err
+	}
+	if x.BitLen() > bits {
+		return &ValidationError{Field: field, Err: ErrValueSize}
+	}
+	return nil
+}
+
+// checkRange returns a ValidationError unless lower <= x < upper.
+func checkRange(field string, x *big.Int, lower *big.Int, upper *big.Int) error {
+	if err := checkPresent(field, x); err != nil {
+		return err
+	}
+	if x.Cmp(lower) < 0 || x.Cmp(upper) >= 0 {
+		return &ValidationError{Field: field, Err: ErrOutOfRange}
+	}
+	return nil
+}
+
+// checkResidue returns a ValidationError unless x is a non-trivial member of the subgroup of order q in Z_p^*.
+func checkResidue(field string, x *big.Int, scheme *SchemeParams) error {
+	if err := checkRange(field, x, big.NewInt(2), scheme.P); err != nil {
+		return err
+	}
+	if big.Jacobi(x, scheme.P) != 1 {
+		return &ValidationError{Field: field, Err: ErrNonResidue}
+	}
+	return nil
+}
+
+// bigIntType is the type of the values checked by CheckMessage.
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// CheckMessage checks message, as decoded from the network, for missing and oversized values: a peer can leave any
+// *big.Int nil, or send it megabytes long. Each one must be set, at any depth, unless its field is tagged
+// `ziba:"optional"`, and at most MaxModulusBits long. Structures behind nil pointers, and the zero structures of optional
+// fields, are absent rather than missing. Returns a ValidationError naming the first rejected value.
+func CheckMessage(message any) error {
+	v := reflect.ValueOf(message)
+	name := "message"
+	for t := v.Type(); t != nil; t = t.Elem() {
+		if t.Kind() != reflect.Pointer && t.Kind() != reflect.Slice {
+			if t != bigIntType && len(t.Name()) > 0 {
+				name = t.Name()
+			}
+			break
+		}
+	}
+	return checkMessage(v, name)
+}
+
+// checkMessage returns a ValidationError if a required value of v, named field, is missing or oversized.
+func checkMessage(v reflect.Value, field string) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.Type().Elem() == bigIntType {
+			return checkSize(field, v.Interface().(*big.Int), MaxModulusBits)
+		}
+		if v.IsNil() {
+			return nil
+		}
+		return checkMessage(v.Elem(), field)
+	case reflect.Struct:
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if !f.IsExported() || (f.Tag.Get("ziba") == "optional" && v.Field(i).IsZero()) {
+				continue
+			}
+			if err := checkMessage(v.Field(i), field+"."+f.Name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if err := checkMessage(v.Index(i), fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for iter := v.MapRange(); iter.Next(); {
+			if err := checkMessage(iter.Value(), fmt.Sprintf("%s[%v]", field, iter.Key())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate validates scheme parameters loaded from a file: p = 2q + 1 must be a safe prime, and alpha a generator of
+// the subgroup of order q.
+func (scheme *SchemeParams) Validate() error {
+	if err := checkSize("SchemeParams.Q", scheme.Q, MaxModulusBits); err != nil {
+		return err
+	}
+	if err := checkSize("SchemeParams.P", scheme.P, MaxModulusBits); err != nil {
+		return err
+	}
+	if !scheme.Q.ProbablyPrime(20) {
+		return &ValidationError{Field: "SchemeParams.Q", Err: ErrSafePrime}
+	}
+	p := new(big.Int).Add(new(big.Int).Lsh(scheme.Q, 1), big.NewInt(1))
+	if scheme.P.Cmp(p) != 0 || !scheme.P.ProbablyPrime(20) {
+		return &ValidationError{Field: "SchemeParams.P", Err: ErrSafePrime}
+	}
+	return checkResidue("SchemeParams.G", scheme.G, scheme)
+}
+
+// ValidateIdentity validates live, the profile sent by bank in a later session, against bank, the profile stored by a
+// client at Accgen. Returns ErrSchemeMismatch if the bank switched scheme parameters, ErrBankMismatch if it switched
+// keys.
+func (bank *BankProfile) ValidateIdentity(live *BankProfile) error {
+	if err := checkPresent("BankProfile.Pub", live.Pub); err != nil {
+		return err
+	}
+	if err := checkPresent("BankProfile.N", live.N); err != nil {
+		return err
+	}
+	if err := checkPresent("BankProfile.E", live.E); err != nil {
+		return err
+	}
+	if live.Scheme.Fingerprint() != bank.Scheme.Fingerprint() {
+		return ErrSchemeMismatch
+	}
+	if live.Pub.Cmp(bank.Pub) != 0 || live.N.Cmp(bank.N) != 0 || live.E.Cmp(bank.E) != 0 {
+		return ErrBankMismatch
+	}
+	return nil
+}
+
+// ValidateClient validates a client profile received by bank.
+func (bank *BankProfile) ValidateClient(client *ClientProfile) error {
+	if err := checkResidue("ClientProfile.PrivStamp", client.PrivStamp, &bank.Scheme); err != nil {
+		return err
+	}
+	if err := checkRange("ClientProfile.IdentityHash", client.IdentityHash, big.NewInt(0), digestBound); err != nil {
+		return err
+	}
+	if err := checkRange("ClientProfile.TradeId", client.TradeId, big.NewInt(0), bank.N); err != nil {
+		return err
+	}
+	if err := checkRange("ClientProfile.Pub", client.Pub, big.NewInt(0), bank.N); err != nil {
+		return err
+	}
+	if err := checkSize("ClientProfile.N", client.N, bank.modulusBits()); err != nil {
+		return err
+	}
+	if err := checkRange("ClientProfile.E", client.E, big.NewInt(3), client.N); err != nil {
+		return err
+	}
+	return nil
+}
+
+// modulusBits returns the bit length of the largest modulus of bank, p or N: no value it validates is longer.
+func (bank *BankProfile) modulusBits() int {
+	return max(bank.Scheme.P.BitLen(), bank.N.BitLen())
+}
+
+// ValidateCredentials validates the credentials of a client received by bank.
+func (bank *BankProfile) ValidateCredentials(credential *big.Int, contract *big.Int) error {
+	if err := checkResidue("Credential", credential, &bank.Scheme); err != nil {
+		return err
+	}
+	return checkResidue("Contract", contract, &bank.Scheme)
+}
+
+// ValidateCoinRequest validates the parameters of a coin request received by bank.
+func (bank *BankProfile) ValidateCoinRequest(ALower *big.Int, C *big.Int) error {
+	// a must be a unit of Z_N.
+	if err := checkRange("ALower", ALower, big.NewInt(1), bank.N); err != nil {
+		return err
+	}
+	if new(big.Int).GCD(nil, nil, ALower, bank.N).Cmp(big.NewInt(1)) != 0 {
+		return &ValidationError{Field: "ALower", Err: ErrOutOfRange}
+	}
+
+	// c = 0 would reveal s in c'.
+	return checkRange("C", C, big.NewInt(1), bank.Scheme.Q)
+}
+
+// ValidateCoin validates a coin profile received by bank (or a merchant of bank). Second and Msg are only checked if
+// set, they are unknown before the payment.
+func (bank *BankProfile) ValidateCoin(coin *CoinProfile) error {
+	if NormalizeCurrency(coin.Currency) != NormalizeCurrency(bank.Currency) {
+		return &ValidationError{Field: "CoinProfile.Currency", Err: ErrCurrency}
+	}
+	if err := checkResidue("CoinProfile.Pub", coin.Pub, &bank.Scheme); err != nil {
+		return err
+	}
+	if err := checkResidue("CoinProfile.First", coin.First, &bank.Scheme); err != nil {
+		return err
+	}
+	if err := checkResidue("CoinProfile.A", coin.A, &bank.Scheme); err != nil {
+		return err
+	}
+	if err := checkRange("CoinProfile.R", coin.R, big.NewInt(0), bank.Scheme.Q); err != nil {
+		return err
+	}
+	if err := checkRange("CoinProfile.A2", coin.A2, big.NewInt(1), bank.N); err != nil {
+		return err
+	}
+	if coin.Value < 0 {
+		return &ValidationError{Field: "CoinProfile.Value", Err: ErrOutOfRange}
+	}
+	if coin.Second != nil {
+		if err := bank.ValidateSecond(coin.Second); err != nil {
+			return err
+		}
+	}
+	if coin.Msg != nil {
+		if err := checkRange("CoinProfile.Msg", coin.Msg, big.NewInt(0), digestBound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateChange validates the remainder coin request of a change received by bank (or a merchant of bank).
+func (bank *BankProfile) ValidateChange(change *Change) error {
+	if err := bank.ValidateCoinRequest(change.ALower, change.C); err != nil {
+		return err
+	}
+	return checkRange("Change.Claim", change.Claim, big.NewInt(0), digestBound)
+}
+
+// ValidateSecond validates the Elgamal's second component of a coin received by bank (or a merchant of bank).
+func (bank *BankProfile) ValidateSecond(second *big.Int) error {
+	pMinus1 := new(big.Int).Sub(bank.Scheme.P, big.NewInt(1))
+	return checkRange("CoinProfile.Second", second, big.NewInt(0), pMinus1)
+}
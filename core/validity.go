@@ -0,0 +1,141 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// COIN VALIDITY
+//
+
+// 1. The bank issues each coin with an expiration date (t), a validity period after its issuance. The period is the
+//		bank's policy, and may differ by value: e.g. shorter for small coins, longer for large ones.
+// 2. Banks without a policy issue coins valid for one month and one day.
+// 3. The period is sent along with the expiration in the bank's coin responses. The wallet checks that both agree
+//		with its own clock, and that the period is at most MaxCoinValidity, before finishing the coin.
+// 4. Expired coins can't be deposited nor paid. The bank may still exchange them for fresh ones during a grace period
+//		after their expiration, so that a wallet left offline doesn't lose them.
+
+// CoinValidity is a policy of coin validity periods.
+type CoinValidity struct {
+	Default time.Duration           // Of the values without their own period, one month and one day if 0.
+	Periods map[int64]time.Duration // By coin value.
+	Grace   time.Duration           // Of the expired coins still exchanged, none if 0.
+}
+
+// Validity is the policy of the coins issued by this process.
+var Validity CoinValidity
+
+// Coin validity bounds.
+const (
+	// MaxCoinValidity is the longest validity period of a coin.
+	MaxCoinValidity = 2 * 365 * 24 * time.Hour
+
+	// validitySkew is the tolerated difference between the bank's and the wallet's clocks.
+	validitySkew = 10 * time.Minute
+)
+
+// Validate validates the periods of validity, each positive and at most MaxCoinValidity. (Default and Grace may be 0)
+func (validity *CoinValidity) Validate() error {
+	if validity.Default < 0 || validity.Default > MaxCoinValidity {
+		return ErrCoinValidity
+	}
+	if validity.Grace < 0 || validity.Grace > MaxCoinValidity {
+		return ErrCoinValidity
+	}
+	for value, period := range validity.Periods {
+		if err := ValidateValue(value); err != nil || period <= 0 || period > MaxCoinValidity {
+			return ErrCoinValidity
+		}
+	}
+	return nil
+}
+
+// Expiration returns the expiration date of a coin of value issued at now.
+func (validity *CoinValidity) Expiration(value int64, now time.Time) time.Time {
+	if period, found := validity.Periods[NormalizeValue(value)]; found {
+		return now.Add(period)
+	}
+	if validity.Default > 0 {
+		return now.Add(validity.Default)
+	}
+	return now.AddDate(0, 1, 1)
+}
+
+// Issuance returns the issuance date of a coin of value expiring at expiration, under this policy. (Within a few days
+// for the default period, months differ in length)
+func (validity *CoinValidity) Issuance(expiration time.Time, value int64) time.Time {
+	if period, found := validity.Periods[NormalizeValue(value)]; found {
+		return expiration.Add(-period)
+	}
+	if validity.Default > 0 {
+		return expiration.Add(-validity.Default)
+	}
+	return expiration.AddDate(0, -1, -1)
+}
+
+// Period returns the validity period of a coin of value issued at now, under this policy.
+func (validity *CoinValidity) Period(value int64, now time.Time) time.Duration {
+	return validity.Expiration(value, now).Sub(now)
+}
+
+// CheckExpiration checks the expiration date of a coin issued now with the validity period, as sent by the bank. A
+// period of 0, sent by banks of earlier versions, only bounds the expiration.
+func CheckExpiration(expiration time.Time, period time.Duration, now time.Time) error {
+	if period < 0 || period > MaxCoinValidity {
+		return ErrCoinValidity
+	}
+	if period == 0 {
+		if !expiration.After(now) || expiration.After(now.Add(MaxCoinValidity+validitySkew)) {
+			return ErrCoinValidity
+		}
+		return nil
+	}
+	if skew := expiration.Add(-period).Sub(now); skew < -validitySkew || skew > validitySkew {
+		return ErrCoinValidity
+	}
+	return nil
+}
+
+// CheckUnexpired returns ErrCoinExpired if a coin expiring at expiration has expired at now.
+func CheckUnexpired(expiration time.Time, now time.Time) error {
+	if !expiration.After(now) {
+		return ErrCoinExpired
+	}
+	return nil
+}
+
+// CheckExchange checks a coin expiring at expiration can be exchanged at now, under this policy. Returns whether the
+// coin is exchanged during its grace period, ErrCoinExpired if past it.
+func (validity *CoinValidity) CheckExchange(expiration time.Time, now time.Time) (grace bool, err error) {
+	if CheckUnexpired(expiration, now) == nil {
+		return false, nil
+	}
+	if validity.Grace <= 0 || now.After(expiration.Add(validity.Grace)) {
+		return false, ErrCoinExpired
+	}
+	return true, nil
+}
+
+// ParseValidityPeriods returns the periods of settings, each "VALUE=DURATION", e.g. "100=2160h".
+func ParseValidityPeriods(settings []string) (map[int64]time.Duration, error) {
+	periods := make(map[int64]time.Duration, len(settings))
+	for _, setting := range settings {
+		value, duration, found := strings.Cut(setting, "=")
+		if !found {
+			return nil, ErrCoinValidity
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, ErrCoinValidity
+		}
+		period, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil {
+			return nil, ErrCoinValidity
+		}
+		periods[v] = period
+	}
+	return periods, nil
+}
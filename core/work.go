@@ -0,0 +1,75 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+//
+// PROOF OF WORK
+//
+
+// 1. The Bank sends a random challenge and a difficulty, in bits, to an applying client.
+// 2. The Client finds a solution such that SHA-256(challenge | client's hash | solution) starts with as many zero
+//		bits, about 2^difficulty hashes, and sends it along with its application.
+// 3. The Bank checks the solution with a single hash. Mass-creating accounts gets as costly as the difficulty.
+
+// WorkChallenge.
+type WorkChallenge struct {
+	Nonce []byte
+	Bits  int
+}
+
+// NewWorkChallenge returns a challenge of difficulty bits.
+func NewWorkChallenge(random io.Reader, difficulty int) (*WorkChallenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(source(random), nonce); err != nil {
+		return nil, err
+	}
+	return &WorkChallenge{Nonce: nonce, Bits: difficulty}, nil
+}
+
+// workBits returns the leading zero bits of the work hash of solution for client.
+func (challenge *WorkChallenge) workBits(client *ClientProfile, solution uint64) int {
+	buf := make([]byte, 0, len(challenge.Nonce)+12)
+	buf = append(buf, challenge.Nonce...)
+	buf = binary.BigEndian.AppendUint32(buf, client.Hash())
+	buf = binary.BigEndian.AppendUint64(buf, solution)
+	sum := sha256.Sum256(buf)
+
+	zeros := 0
+	for i := 0; i < len(sum); i += 8 {
+		word := binary.BigEndian.Uint64(sum[i : i+8])
+		zeros += bits.LeadingZeros64(word)
+		if word != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// Solve returns a solution of challenge for client.
+func (challenge *WorkChallenge) Solve(client *ClientProfile) uint64 {
+	var solution uint64
+	if challenge.Bits <= 0 {
+		return solution
+	}
+
+	// Start at a random point, so that clients don't race on the same solutions.
+	var start [8]byte
+	if _, err := rand.Read(start[:]); err == nil {
+		solution = binary.BigEndian.Uint64(start[:])
+	}
+	for challenge.workBits(client, solution) < challenge.Bits {
+		solution++
+	}
+	return solution
+}
+
+// Verify verifies solution solves challenge for client.
+func (challenge *WorkChallenge) Verify(client *ClientProfile, solution uint64) bool {
+	return challenge.Bits <= 0 || challenge.workBits(client, solution) >= challenge.Bits
+}
@@ -0,0 +1,168 @@
+// Package mobile is a bind-friendly wallet API for Android and iOS apps, built with:
+//
+//	gomobile bind -target android ./mobile
+//	gomobile bind -target ios ./mobile
+//
+// Its signatures only use strings, integers and interfaces. A Wallet is a wallet database, as used by "ziba user",
+// driven by the same network clients.
+package mobile
+
+import (
+	"ziba/core"
+	"ziba/network"
+	"ziba/store"
+)
+
+// Wallet is a wallet database.
+type Wallet struct {
+	store     *store.ClientStore
+	confirmer Confirmer
+}
+
+// Confirmer approves payments once stamped by the merchant, before their coin is signed, e.g. by asking the user.
+// Implemented by the app.
+type Confirmer interface {
+	// Confirm reports whether to pay amount in currency to the merchant of account payee (hexadecimal), stamped with
+	// memo.
+	Confirm(payee, currency string, amount int64, memo string) bool
+}
+
+// NewWallet creates the wallet database at path, with a new TLS certificate of name, and returns it.
+func NewWallet(path, name string) (*Wallet, error) {
+	clientStore, err := new(store.ClientStore).New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create certificates.
+	cert, key, err := network.GenerateCertificate()
+	if err != nil {
+		clientStore.Close()
+		return nil, err
+	}
+	own := &store.Certificate{Role: store.Role_Own, Name: name, Cert: cert, Key: key}
+	if err := clientStore.Certificates().Write(own); err != nil {
+		clientStore.Close()
+		return nil, err
+	}
+	return &Wallet{store: clientStore}, nil
+}
+
+// OpenWallet opens the wallet database at path, for its account at bank. (Its only account if empty)
+func OpenWallet(path, bank string) (*Wallet, error) {
+	clientStore, err := new(store.ClientStore).New(path)
+	if err != nil {
+		return nil, err
+	}
+	clientStore.BankName = bank
+	return &Wallet{store: clientStore}, nil
+}
+
+// SetConfirmer makes confirmer approve the payments. They are signed without asking if nil.
+func (w *Wallet) SetConfirmer(confirmer Confirmer) {
+	w.confirmer = confirmer
+}
+
+// CreateAccount requests a client account at the bank at server.
+func (w *Wallet) CreateAccount(server string) error {
+	// Execute SetupClient.
+	if err := new(network.SetupClient).New(server, w.store).Execute(); err != nil {
+		return err
+	}
+
+	// Load TLS client configuration.
+	config, err := network.GetStoredClientTLSConfig(w.store.Certificates(), store.Role_Bank, server)
+	if err != nil {
+		return err
+	}
+
+	// Execute AccgenClient.
+	return new(network.AccgenClient).New(server, w.store, config).Execute()
+}
+
+// Withdraw withdraws count coins of value in currency, DefaultCurrency if empty, from the bank at server.
+func (w *Wallet) Withdraw(server, currency string, value int64, count int) error {
+	// Execute SetupClient.
+	if err := new(network.SetupClient).New(server, w.store).Execute(); err != nil {
+		return err
+	}
+
+	// Load TLS client configuration.
+	config, err := network.GetStoredClientTLSConfig(w.store.Certificates(), store.Role_Bank, server)
+	if err != nil {
+		return err
+	}
+
+	// Execute WithdrawalClient.
+	for range count {
+		client := new(network.WithdrawalClient).New(server, w.store, config).Currency(currency).Value(value)
+		if err := client.Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pay pays amount in currency, DefaultCurrency if empty, to the merchant at server, stamped with memo. A whole coin is
+// paid if amount is 0, coins are combined otherwise.
+func (w *Wallet) Pay(server, currency string, amount int64, memo string) error {
+	// Execute GetClient.
+	if err := new(network.GetClient).New(server, w.store).Execute(); err != nil {
+		return err
+	}
+
+	// Load TLS client configuration.
+	config, err := network.GetStoredClientTLSConfig(w.store.Certificates(), store.Role_Merchant, server)
+	if err != nil {
+		return err
+	}
+
+	// Execute AutoPaymentClient. (Payments of an amount)
+	if amount > 0 {
+		client := new(network.AutoPaymentClient).New(server, w.store, config).Memo(memo).Currency(currency).Amount(amount)
+		if w.confirmer != nil {
+			client.Confirm(w.confirm)
+		}
+		return client.Execute()
+	}
+
+	// Execute PaymentClient.
+	client := new(network.PaymentClient).New(server, w.store, config).Memo(memo).Currency(currency)
+	if w.confirmer != nil {
+		client.Confirm(w.confirm)
+	}
+	return client.Execute()
+}
+
+// confirm asks the confirmer of w to approve the payment of details.
+func (w *Wallet) confirm(details *network.PaymentDetails) bool {
+	var payee string
+	if details.Payee != nil {
+		payee = details.Payee.Text(16)
+	}
+	return w.confirmer.Confirm(payee, details.Currency, details.Amount, details.Memo)
+}
+
+// Balance returns the value of the coins held in currency, DefaultCurrency if empty.
+func (w *Wallet) Balance(currency string) (int64, error) {
+	// Read Client. (Selects the account of the balances)
+	if _, err := w.store.ReadClient(); err != nil {
+		return 0, err
+	}
+
+	balances, err := w.store.ReadBalances()
+	if err != nil {
+		return 0, err
+	}
+	for _, balance := range balances {
+		if core.NormalizeCurrency(balance.Currency) == core.NormalizeCurrency(currency) {
+			return balance.Local, nil
+		}
+	}
+	return 0, nil
+}
+
+// Close closes the wallet database.
+func (w *Wallet) Close() error {
+	return w.store.Close()
+}
@@ -1,17 +1,18 @@
 package network
 
 import (
-	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math/big"
 	"net"
-	"os"
-	"path/filepath"
-	"strings"
+	"slices"
+	"sync"
 	"time"
 	"ziba/core"
 	"ziba/store"
@@ -30,8 +31,12 @@ func (c *SetupClient) New(serverAddr string, store *store.ClientStore) *SetupCli
 
 // Execute.
 func (c *SetupClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("SetupClient")
+	defer trace.End()
+
 	// Connect to server.
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, setupPort))
+	conn, err := net.Dial("tcp", hostPort(c.serverAddr, setupPort))
 	if err != nil {
 		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
@@ -41,41 +46,50 @@ func (c *SetupClient) Execute() error {
 	// Info message.
 	log.Printf("Connected to Setup server")
 
-	// Create a file to copy into the certificate.
-	directory, err := store.GetZibaDir()
-	if err != nil {
-		log.Fatalf("failed to retrieve Ziba directory: %v", err)
+	decoder := newDecoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV name, certificate and signed bundle. (Certificate empty if the bank's certificate is publicly trusted)
+	var message setupMessage
+	if err := decoder.Decode(&message); err != nil {
+		log.Printf("failed to decode Setup message: %v", err)
 		return err
 	}
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", c.serverAddr))
-	certFile, err := os.Create(certPath)
+
+	trace.Phase(phaseCrypto)
+	// Check the certificate's length and checksum.
+	cert, err := message.Cert.Certificate()
 	if err != nil {
-		log.Printf("failed to create certificate file: %v", err)
+		log.Printf("failed to receive certificate of %s: %v", c.serverAddr, err)
 		return err
 	}
-	defer certFile.Close()
 
-	// decoder := gob.NewDecoder(conn)
-	reader := bufio.NewReader(conn)
+	// Check the certificate matches the bundle. (The bundle is verified at Accgen, against the BankProfile)
+	fingerprint, err := store.PEMFingerprint(cert)
+	if err != nil || fingerprint != message.Bundle.Cert {
+		log.Printf("== ALERT: certificate of %s doesn't match its Setup bundle", c.serverAddr)
+		return core.ErrSetupSignature
+	}
+	c.store.BankName = message.Bundle.Name
+	log.Printf("\n\n  Hello,\n  Welcome to %s\n\n", c.store.BankName)
 
-	// RECV name.
-	bankName, err := reader.ReadString('\n')
-	if err != nil {
-		log.Fatalf("failed to decode Bank's name message: %v", err)
+	trace.Phase(phaseStoreWrite)
+	// Keep the bank's certificate and bundle.
+	if err := c.store.Certificates().Write(&store.Certificate{Role: store.Role_Bank, Name: c.serverAddr, Cert: cert}); err != nil {
+		log.Printf("failed to write certificate of %s: %v", c.serverAddr, err)
 		return err
 	}
-	c.store.BankName = strings.TrimSpace(bankName)
-	log.Printf("\n\n  Hello,\n  Welcome to %s\n\n", bankName)
-
-	// RECV file.
-	_, err = io.Copy(certFile, reader)
-	if err != nil {
-		log.Fatalf("failed to read certificate file message: %v", err)
+	if err := c.store.Meta().WriteBinary(setupBundleKey(c.serverAddr), &message.Bundle); err != nil {
+		log.Printf("failed to write Setup bundle of %s: %v", c.serverAddr, err)
 		return err
 	}
 
 	// Info message.
-	log.Printf("Certificate downloaded")
+	if len(cert) == 0 {
+		log.Printf("Bank's certificate is publicly trusted")
+	} else {
+		log.Printf("Certificate downloaded")
+	}
 
 	return nil
 }
@@ -92,10 +106,26 @@ func (c *AccgenClient) New(serverAddr string, store *store.ClientStore, config *
 	return c
 }
 
+// Evidence sets the evidence sent along with the account application, e.g. a KYC reference.
+func (c *AccgenClient) Evidence(evidence string) *AccgenClient {
+	c.request.Evidence = evidence
+	return c
+}
+
+// Token sets the registration token sent along with the account application, issued by the bank out of band.
+func (c *AccgenClient) Token(token string) *AccgenClient {
+	c.request.Token = token
+	return c
+}
+
 // Execute.
 func (c *AccgenClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("AccgenClient")
+	defer trace.End()
+
 	// Connect to server.
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, accgenPort), c.config)
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, accgenPort), c.config)
 	if err != nil {
 		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
@@ -105,9 +135,10 @@ func (c *AccgenClient) Execute() error {
 	// Info message.
 	log.Print("Connected to Accgen server")
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseDecode)
 	// RECV BankProfile from server.
 	var bankProfile core.BankProfile
 	if err := decoder.Decode(&bankProfile); err != nil {
@@ -115,34 +146,140 @@ func (c *AccgenClient) Execute() error {
 		return err
 	}
 
-	// Create Client.
-	client := new(core.Client).New(&bankProfile)
+	// RECV scheme fingerprint from server.
+	var fingerprint string
+	if err := decoder.Decode(&fingerprint); err != nil {
+		log.Printf("failed to decode fingerprint message: %v", err)
+		return err
+	}
+
+	// RECV WorkChallenge from server.
+	var challenge core.WorkChallenge
+	if err := decoder.Decode(&challenge); err != nil {
+		log.Printf("failed to decode WorkChallenge message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the scheme fingerprint.
+	if fingerprint != bankProfile.Scheme.Fingerprint() {
+		log.Printf("scheme parameters of BankProfile don't match fingerprint %s", fingerprint)
+		return core.ErrSchemeMismatch
+	}
+	log.Printf("Scheme parameters: %s", fingerprint)
+
+	// Verify the Setup bundle against the BankProfile, and the server's certificate against the bundle.
+	var bundle core.SetupBundle
+	if err := c.store.Meta().ReadBinary(setupBundleKey(c.serverAddr), &bundle); err != nil {
+		log.Printf("failed to read Setup bundle of %s: %v", c.serverAddr, err)
+		return err
+	}
+	if err := bundle.Verify(&bankProfile); err != nil {
+		log.Printf("== ALERT: invalid Setup bundle of %s: %v", c.serverAddr, err)
+		return err
+	}
+	if bundle.Name != c.store.BankName {
+		log.Printf("== ALERT: Setup bundle names bank %s", bundle.Name)
+		return core.ErrSetupSignature
+	}
+	if len(bundle.Cert) > 0 {
+		peer := conn.ConnectionState().PeerCertificates
+		if len(peer) == 0 || store.CertificateFingerprint(peer[0].Raw) != bundle.Cert {
+			log.Printf("== ALERT: certificate of %s doesn't match its Setup bundle", c.serverAddr)
+			return core.ErrSetupSignature
+		}
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Create Client. (The pending one if its application is pending approval by this bank)
+	client, err := c.store.ReadPendingClient()
+	if err != nil {
+		log.Printf("failed to read pending Client from database: %v", err)
+		return err
+	}
+	if client == nil || client.Bank.Pub.Cmp(bankProfile.Pub) != 0 || client.Bank.N.Cmp(bankProfile.N) != 0 ||
+		client.Bank.Scheme.Fingerprint() != fingerprint {
+		client = new(core.Client).New(nil, &bankProfile)
+	}
 	clientProfile := client.Profile()
 
+	trace.Phase(phaseEncode)
 	// SEND ClientProfile to server.
 	if err := encoder.Encode(*clientProfile); err != nil {
 		log.Fatalf("failed to encode ClientProfile message: %v", err)
 		return err
 	}
 
+	trace.Phase(phaseCrypto)
+	// Solve WorkChallenge.
+	if challenge.Bits > 0 {
+		log.Printf("Solving proof of work (%d bits)", challenge.Bits)
+	}
+	c.request.Work = challenge.Solve(clientProfile)
+
+	trace.Phase(phaseEncode)
+	// SEND request metadata to server.
+	if err := encoder.Encode(c.request); err != nil {
+		log.Printf("failed to encode Accgen request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV admission from server.
+	var admission store.Admission_Type
+	if err := decoder.Decode(&admission); err != nil {
+		log.Printf("failed to decode admission message: %v", err)
+		return err
+	}
+	switch admission {
+	case store.Admission_Pending:
+		if err := c.store.WritePendingClient(client); err != nil {
+			log.Printf("failed to write pending Client into database: %v", err)
+			return err
+		}
+		return fmt.Errorf("account %d is pending approval, run accgen again once approved", clientProfile.Hash())
+	case store.Admission_Rejected:
+		if err := c.store.DeletePendingClient(); err != nil {
+			log.Printf("failed to delete pending Client from database: %v", err)
+		}
+		return fmt.Errorf("account %d was rejected by the bank", clientProfile.Hash())
+	}
+
 	// RECV credentials from server.
 	var credentials struct {
 		Credential *big.Int
 		Contract   *big.Int
+		Expiration time.Time
+		Mints      []core.BankProfile
 	}
 	if err := decoder.Decode(&credentials); err != nil {
 		log.Fatalf("failed to decode ClientInfo message: %v", err)
 		return err
 	}
 
+	trace.Phase(phaseCrypto)
 	// Add credentials.
-	client.SetCredentials(credentials.Credential, credentials.Contract)
+	client.SetCredentials(credentials.Credential, credentials.Contract).SetExpiration(credentials.Expiration)
 
+	trace.Phase(phaseStoreWrite)
 	// Write Client into database.
 	if err := c.store.WriteClient(client); err != nil {
 		log.Fatalf("failed to write Client into database: %v", err)
 		return err
 	}
+	if err := c.store.DeletePendingClient(); err != nil {
+		log.Printf("failed to delete pending Client from database: %v", err)
+	}
+
+	// Write mint profiles into database. (Initializes the client's id of this ClientStore first)
+	if _, err := c.store.ReadClient(); err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+	if err := c.store.WriteMints(credentials.Mints); err != nil {
+		log.Fatalf("failed to write mints into database: %v", err)
+		return err
+	}
 
 	// Info message.
 	log.Printf("Client: %s", client)
@@ -163,10 +300,108 @@ func (c *WithdrawalClient) New(serverAddr string, store *store.ClientStore, conf
 	return c
 }
 
+// Currency selects the currency of the withdrawn coin, DefaultCurrency if empty.
+func (c *WithdrawalClient) Currency(currency string) *WithdrawalClient {
+	c.currency = currency
+	return c
+}
+
+// Value selects the value of the withdrawn coin, 1 if 0.
+func (c *WithdrawalClient) Value(value int64) *WithdrawalClient {
+	c.value = value
+	return c
+}
+
 // Execute.
 func (c *WithdrawalClient) Execute() error {
+	return c.execute(nil, true)
+}
+
+// WithdrawN withdraws n coins, running up to parallelism withdrawal sessions at once. Each coin request is kept as a
+// pending withdrawal, under its own idempotency token, before it's sent. The pending withdrawals of the same amount
+// are resumed first, each by a single session. No session is started once ctx is done.
+// Returns the errors of the failed sessions, joined.
+func (c *WithdrawalClient) WithdrawN(ctx context.Context, n, parallelism int) error {
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+
+	// Assign the pending withdrawals of the same amount to the first sessions.
+	minted, err := mintClient(c.store, client, c.currency)
+	if err != nil {
+		log.Printf("failed to read mint of %q: %v", c.currency, err)
+		return err
+	}
+	pendings, err := c.store.ReadPendingWithdrawals()
+	if err != nil {
+		log.Fatalf("failed to read pending withdrawals from database: %v", err)
+		return err
+	}
+	pendings = slices.DeleteFunc(pendings, func(pending store.PendingWithdrawal) bool {
+		return core.NormalizeCurrency(pending.Coin.Params.Currency) != core.NormalizeCurrency(minted.Bank.Currency) ||
+			core.NormalizeValue(pending.Coin.Params.Value) != core.NormalizeValue(c.value)
+	})
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		errs     []error
+		sessions = make(chan struct{}, max(parallelism, 1))
+	)
+	for i := range n {
+		var pending *store.PendingWithdrawal
+		if i < len(pendings) {
+			pending = &pendings[i]
+		}
+
+		// Wait for a session to end.
+		select {
+		case sessions <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			wg.Wait()
+			return errors.Join(append(errs, fmt.Errorf("withdrew %d coins out of %d: %w", i-len(errs), n, ctx.Err()))...)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sessions }()
+
+			if err := c.execute(pending, false); err != nil {
+				mutex.Lock()
+				errs = append(errs, err)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Info message.
+	log.Printf("Withdrew %d coins out of %d", n-len(errs), n)
+
+	return errors.Join(errs...)
+}
+
+// execute runs a withdrawal session, resuming pending if not nil. Otherwise the pending withdrawal of the same amount
+// is resumed if resume, a new coin request is sent if there's none.
+func (c *WithdrawalClient) execute(pending *store.PendingWithdrawal, resume bool) error {
+	// Trace protocol run.
+	trace := newTrace("WithdrawalClient")
+	defer trace.End()
+
+	trace.Phase(phaseCrypto)
+	// Check value.
+	if err := core.ValidateValue(core.NormalizeValue(c.value)); err != nil {
+		return err
+	}
+
 	// Connect to server.
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, withdrawalPort), c.config)
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, withdrawalPort), c.config)
 	if err != nil {
 		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
@@ -176,6 +411,7 @@ func (c *WithdrawalClient) Execute() error {
 	// Info message.
 	log.Print("Connected to Withdrawal server")
 
+	trace.Phase(phaseStoreRead)
 	// Read Client.
 	client, err := c.store.ReadClient()
 	if err != nil {
@@ -183,13 +419,27 @@ func (c *WithdrawalClient) Execute() error {
 		return err
 	}
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseDecode)
+	// RECV BankProfile. (Check that the bank didn't switch identity since Accgen)
+	if err := recvBankProfile(decoder, &client.Bank); err != nil {
+		return err
+	}
+
+	// RECV nonce.
+	var nonce []byte
+	if err := decoder.Decode(&nonce); err != nil {
+		log.Printf("failed to decode nonce message: %v", err)
+		return err
+	}
+
 	// Fake Client.
-	// client2 := new(core.Client).New(&client.Bank)
+	// client2 := new(core.Client).New(nil, &client.Bank)
 	// client2Profile := client2.Profile()
 
+	trace.Phase(phaseEncode)
 	// SEND client profile.
 	clientProfile := client.Profile()
 	if err := encoder.Encode(*clientProfile); err != nil {
@@ -197,41 +447,94 @@ func (c *WithdrawalClient) Execute() error {
 		return err
 	}
 
-	// Compute coin request.
-	coin := client.NewCoinRequest()
-
-	// Craft request.
-	request := struct {
-		ALower *big.Int
-		C      *big.Int
-	}{
-		ALower: coin.Params.ALower,
-		C:      coin.Params.C,
+	trace.Phase(phaseStoreRead)
+	// Resume the pending withdrawal of the same amount, if any.
+	minted, err := mintClient(c.store, client, c.currency)
+	if err != nil {
+		log.Printf("failed to read mint of %q: %v", c.currency, err)
+		return err
+	}
+	if pending == nil && resume {
+		pending, err = c.store.ReadPendingWithdrawal(minted.Bank.Currency, c.value)
+		if err != nil {
+			log.Fatalf("failed to read pending withdrawal from database: %v", err)
+			return err
+		}
 	}
 
+	if pending != nil {
+		log.Printf("Resuming withdrawal %s", pending.Token)
+	} else {
+		trace.Phase(phaseCrypto)
+		// Compute coin request.
+		coin, _, err := newCoinRequest(c.store, client, c.currency)
+		if err != nil {
+			log.Fatalf("failed to compute coin request: %v", err)
+			return err
+		}
+		coin.SetValue(core.NormalizeValue(c.value))
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+
+		trace.Phase(phaseStoreWrite)
+		// Keep the coin request until the withdrawal's outcome is known.
+		pending = &store.PendingWithdrawal{Token: token, Coin: *coin}
+		if err := c.store.WritePendingWithdrawal(pending); err != nil {
+			log.Fatalf("failed to write pending withdrawal into database: %v", err)
+			return err
+		}
+	}
+	coin := &pending.Coin
+
+	trace.Phase(phaseCrypto)
+	// Craft request, signed over the nonce.
+	request := client.SignWithdrawal(nonce, &core.WithdrawalRequest{
+		ALower:   coin.Params.ALower,
+		C:        coin.Params.C,
+		Currency: coin.Params.Currency,
+		Value:    coin.Params.Value,
+		Token:    pending.Token,
+	})
+
+	trace.Phase(phaseEncode)
 	// SEND coin request.
 	if err := encoder.Encode(request); err != nil {
 		log.Fatalf("failed to encode Withdrawal request message: %v", err)
 		return err
 	}
 
+	trace.Phase(phaseDecode)
+	// RECV fraud review.
+	var review FraudReview
+	if err := decoder.Decode(&review); err != nil {
+		log.Printf("failed to decode FraudReview message: %v", err)
+		return fmt.Errorf("withdrawal %s was cut short, check its outcome with \"user status\"", pending.Token)
+	}
+	if err := review.Err("withdrawal"); err != nil {
+		if review.Status != store.Alert_Pending {
+			if err := c.store.DeletePendingWithdrawal(pending.Token); err != nil {
+				log.Printf("failed to delete pending withdrawal from database: %v", err)
+			}
+		}
+		return err
+	}
+
 	// RECV coin response.
 	var response struct {
 		Expiration time.Time
+		Validity   time.Duration
 		A1         *big.Int
 		C1         *big.Int
 	}
 	if err := decoder.Decode(&response); err != nil {
-		log.Fatalf("failed to decode Withdrawal response message: %v", err)
-		return err
+		log.Printf("failed to decode Withdrawal response message: %v", err)
+		return fmt.Errorf("withdrawal %s was cut short, check its outcome with \"user status\"", pending.Token)
 	}
 
 	// Finish the coin using response.
-	client.FinishCoin(coin, response.Expiration, response.A1, response.C1)
-
-	// Write coin.
-	if err := c.store.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
-		log.Fatalf("failed to write Coin into database: %v", err)
+	if err := finishWithdrawal(c.store, minted, pending, response.Expiration, response.Validity, response.A1, response.C1); err != nil {
 		return err
 	}
 
@@ -251,13 +554,84 @@ func (c *PaymentClient) New(serverAddr string, store *store.ClientStore, config
 	c.serverAddr = serverAddr
 	c.store = store
 	c.config = config
+	c.transport = paymentTransport(serverAddr, store, config)
+	return c
+}
+
+// Transport pays over transport instead of TCP over TLS.
+func (c *PaymentClient) Transport(transport PaymentTransport) *PaymentClient {
+	c.transport = transport
+	return c
+}
+
+// Escrow makes the payment escrowed, the merchant must deposit the coin before timeout elapses.
+func (c *PaymentClient) Escrow(timeout time.Duration) *PaymentClient {
+	c.timeout = timeout
+	return c
+}
+
+// Memo attaches memo to the payment, the merchant stamps the coin with it and presents it to the bank at deposit.
+// Escrowed payments can't carry a memo.
+// Non-escrowed payments are always bound to the merchant's account by a (possibly empty) memo.
+func (c *PaymentClient) Memo(memo string) *PaymentClient {
+	c.memo = memo
+	return c
+}
+
+// Currency selects the currency of the paid coin, DefaultCurrency if empty.
+func (c *PaymentClient) Currency(currency string) *PaymentClient {
+	c.currency = currency
+	return c
+}
+
+// Amount selects the amount to pay. A coin worth more is spent partially, its payer collects the remainder coin at
+// the bank afterwards. (See ChangeClient) A whole coin is paid if 0.
+// Escrowed payments can't be partial.
+func (c *PaymentClient) Amount(amount int64) *PaymentClient {
+	c.amount = amount
+	return c
+}
+
+// Account pays with the coins of the wallet's sub-account named account, within its spending limit. The main
+// account's coins are used if empty.
+func (c *PaymentClient) Account(account string) *PaymentClient {
+	c.account = account
+	return c
+}
+
+// Confirm asks confirm to approve the payment, once stamped by the merchant and before the coin is signed. The
+// payment is declined unless it returns true.
+func (c *PaymentClient) Confirm(confirm func(details *PaymentDetails) bool) *PaymentClient {
+	c.confirm = confirm
 	return c
 }
 
 // Execute.
 func (c *PaymentClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("PaymentClient")
+	defer trace.End()
+
+	// Record session (if enabled).
+	transcript := newTranscript("PaymentClient")
+	defer transcript.Close()
+
+	trace.Phase(phaseCrypto)
+	// Check memo.
+	if len(c.memo) > core.MaxMemoLength {
+		return core.ErrMemoLength
+	}
+	if len(c.memo) > 0 && c.timeout > 0 {
+		return fmt.Errorf("escrowed payments can't carry a memo")
+	}
+
+	// Check amount.
+	if c.amount < 0 {
+		return core.ErrChangeAmount
+	}
+
 	// Connect to server.
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, paymentPort), c.config)
+	conn, err := c.transport.Dial(c.serverAddr)
 	if err != nil {
 		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
@@ -267,6 +641,7 @@ func (c *PaymentClient) Execute() error {
 	// Info message.
 	log.Print("Connected to Payment server")
 
+	trace.Phase(phaseStoreRead)
 	// Read Client.
 	client, err := c.store.ReadClient()
 	if err != nil {
@@ -274,116 +649,627 @@ func (c *PaymentClient) Execute() error {
 		return err
 	}
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
-	// Read coins.
-	coins, err := c.store.ReadCoins()
+	// Read the sub-account's coins. (Expired coins can only be exchanged)
+	coins, err := c.store.ReadAccountCoins(c.account)
 	if err != nil {
 		log.Fatalf("failed to read coins from database: %v", err)
 		return err
 	}
+	coins = unexpiredCoins(coinsIn(coins, c.currency), core.Now())
 
 	// Check local balance.
-	balance := len(coins)
+	balance := totalValue(coins)
 	// log.Printf("Current balance: %d", balance)
 	if balance < 1 {
 		log.Printf("No coins on local storage")
 		return nil
 	}
 
-	// Grab 1 coin.
-	coin := coins[0]
+	trace.Phase(phaseCrypto)
+	// Grab 1 coin worth the amount.
+	selected, partial := selectCoin(coins, c.amount)
+	if selected == nil {
+		log.Printf("No coin worth %d on local storage", c.amount)
+		return nil
+	}
+	if partial && c.timeout > 0 {
+		return fmt.Errorf("escrowed payments can't be partial")
+	}
+	coin := *selected
 	coinProfile := coin.Profile()
+	spent := core.NormalizeValue(coin.Params.Value)
+
+	trace.Phase(phaseStoreWrite)
+	// Reserve the coin until the payment ends.
+	unreserve, err := acquireCoins(c.store, []core.Coin{coin})
+	if err != nil {
+		log.Printf("failed to reserve coin %d: %v", coinProfile.Hash(), err)
+		return err
+	}
+	defer unreserve()
+
+	trace.Phase(phaseCrypto)
+	// Compute remainder coin request (if partial).
+	var change *core.Change
+	var remainder *core.Coin
+	var claim *big.Int
+	if partial {
+		remainder, _, err = newCoinRequest(c.store, client, coin.Params.Currency)
+		if err != nil {
+			log.Fatalf("failed to compute coin request: %v", err)
+			return err
+		}
+		remainder.SetValue(spent - c.amount)
+		change, claim = core.NewChange(nil, c.amount, remainder)
+		if change == nil {
+			return fmt.Errorf("failed to create change")
+		}
+		spent = c.amount
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Check the sub-account's spending limit.
+	if err := c.store.CheckSpendingLimit(c.account, coin.Params.Currency, spent); err != nil {
+		log.Printf("Payment of %d refused: %v", spent, err)
+		return err
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Start the payment's session, aborted unless signed. (See PAYMENT SESSIONS)
+	token, err := newToken()
+	if err != nil {
+		log.Fatalf("failed to generate idempotency token: %v", err)
+		return err
+	}
+	machine := &paymentMachine{store: c.store, record: store.PaymentSession{Token: token, Peer: c.serverAddr, Coin: coinProfile.Hash()}}
+	machine.session.Coin = &coin
+	machine.session.Remainder, machine.session.Claim = remainder, claim
+	if err := machine.advance(store.State_Started); err != nil {
+		log.Fatalf("failed to write payment session into database: %v", err)
+		return err
+	}
+	defer func() {
+		if machine.record.State < store.State_Signed {
+			if err := machine.end(); err != nil {
+				log.Printf("failed to abort payment %s: %v", token, err)
+			}
+		}
+	}()
+
+	// Note the mint, for the transcript's verifications.
+	if transcript != nil {
+		if mint, err := c.store.ReadMint(client, coin.Params.Currency); err == nil {
+			transcript.Record(TranscriptNote, "Mint", mint)
+		}
+	}
 
+	trace.Phase(phaseEncode)
 	// SEND CoinProfile.
+	transcript.Record(TranscriptSend, "CoinProfile", coinProfile)
 	if err := encoder.Encode(*coinProfile); err != nil {
 		log.Fatalf("failed to encode CoinProfile message: %v", err)
 		return err
 	}
 
+	trace.Phase(phaseCrypto)
+	// Create escrow conditions (if any).
+	var escrow *core.Escrow
+	var release, refund *big.Int
+	if c.timeout > 0 {
+		escrow, release, refund = core.NewEscrow(nil, time.Now().Add(c.timeout))
+		if escrow == nil {
+			return fmt.Errorf("failed to create escrow conditions")
+		}
+	}
+
+	machine.session.Release, machine.session.Refund = release, refund
+
+	// Craft escrow request.
+	request := paymentRequest{
+		Escrow: escrow,
+		Memo:   c.memo,
+		Change: change,
+		Token:  token,
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND escrow request.
+	transcript.Record(TranscriptSend, "Request", request)
+	if err := encoder.Encode(request); err != nil {
+		log.Fatalf("failed to encode Escrow request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
 	// RECV Elgamal's msg.
-	var msg *big.Int
-	if err := decoder.Decode(&msg); err != nil {
+	var stamp paymentStamp
+	if err := decoder.Decode(&stamp); err != nil {
 		log.Fatalf("failed to decode Elgamal's msg message: %v", err)
 		return err
 	}
+	transcript.Record(TranscriptRecv, "Stamp", stamp)
+	msg := stamp.Msg
+
+	// Check the message binds the escrow conditions before signing.
+	if escrow != nil {
+		if stamp.Escrow == nil || stamp.Escrow.Release.Cmp(escrow.Release) != 0 ||
+			stamp.Escrow.Refund.Cmp(escrow.Refund) != 0 || !stamp.Escrow.Timeout.Equal(escrow.Timeout) {
+			return fmt.Errorf("merchant altered the escrow conditions")
+		}
+		escrow = stamp.Escrow
+		if msg.Cmp(escrow.Msg(coinProfile)) != 0 {
+			return fmt.Errorf("merchant's message is not bound to the escrow conditions")
+		}
+	}
+
+	// Check the message binds the memo and the merchant's account before signing.
+	if len(c.memo) > 0 || stamp.Memo != nil {
+		if stamp.Memo == nil || stamp.Memo.Text != c.memo || msg.Cmp(stamp.Memo.Msg(coinProfile)) != 0 {
+			return fmt.Errorf("merchant's message is not bound to the memo")
+		}
+	}
+
+	// Check the message binds the change before signing.
+	if change != nil {
+		stamped := stamp.Memo.Change
+		if stamped == nil || stamped.Amount != change.Amount || stamped.ALower.Cmp(change.ALower) != 0 ||
+			stamped.C.Cmp(change.C) != 0 || stamped.Claim.Cmp(change.Claim) != 0 {
+			return fmt.Errorf("merchant's message is not bound to the change")
+		}
+	}
+
+	// Ask for approval, signing is irreversible.
+	if c.confirm != nil {
+		details := &PaymentDetails{Currency: core.NormalizeCurrency(coin.Params.Currency), Amount: spent, Memo: c.memo}
+		if escrow != nil {
+			details.Payee, details.Date, details.Escrow = escrow.Payee, escrow.Date, escrow.Timeout
+		} else if stamp.Memo != nil {
+			details.Payee, details.Date = stamp.Memo.Payee, stamp.Memo.Date
+		}
+		if !c.confirm(details) {
+			log.Printf("Payment of %d declined", spent)
+			return core.ErrPaymentDeclined
+		}
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Keep the stamp.
+	machine.session.Request, machine.session.Stamp = request, stamp
+	if err := machine.advance(store.State_Stamped); err != nil {
+		log.Fatalf("failed to write payment session into database: %v", err)
+		return err
+	}
 
+	trace.Phase(phaseCrypto)
 	// Sign coin.
 	second := client.SignCoin(&coin, msg)
 
+	trace.Phase(phaseStoreWrite)
+	// Keep the signature before sending it: the payment is resumed from now on, not aborted.
+	machine.session.Second = second
+	if err := machine.advance(store.State_Signed); err != nil {
+		log.Fatalf("failed to write payment session into database: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseEncode)
 	// SEND Elgamal's second.
+	transcript.Record(TranscriptSend, "Second", redact(second))
 	if err := encoder.Encode(second); err != nil {
 		log.Fatalf("failed to encode Elgamal's second message: %v", err)
 		return err
 	}
 
+	trace.Phase(phaseDecode)
 	// RECV acceptance.
 	var accept bool
 	if err := decoder.Decode(&accept); err != nil {
 		log.Fatalf("failed to decode acceptance message: %v", err)
 		return err
 	}
+	transcript.Record(TranscriptRecv, "Acceptance", accept)
 
-	// Delete Coin after payment.
-	if accept {
-		if err := c.store.DeleteCoin(&coin, store.Operation_Payment); err != nil {
-			log.Fatalf("failed to delete coin from database: %v", err)
-		}
+	if !accept {
+		return fmt.Errorf("merchant refused the signed coin %d, resume the payment %s", coinProfile.Hash(), token)
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Finish the payment.
+	if err := finishPayment(c.store, machine, conn); err != nil {
+		log.Printf("failed to end payment %s: %v", token, err)
+		return err
+	}
+	if escrow != nil {
+		return nil
 	}
 
 	// Info message.
-	log.Printf("Current balance: %d", balance-1)
+	log.Printf("Current balance: %d", balance-core.NormalizeValue(coin.Params.Value))
 	log.Printf("Payment Success!")
 
 	return nil
 }
 
 //
-// DEPOSIT (5/6)
+// AUTO-CHANGE
 //
 
 // New.
-func (c *DepositClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *DepositClient {
+func (c *AutoPaymentClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *AutoPaymentClient {
 	c.serverAddr = serverAddr
 	c.store = store
 	c.config = config
+	c.transport = paymentTransport(serverAddr, store, config)
+	c.denominations = core.DefaultDenominations
 	return c
 }
 
-// Execute.
-func (c *DepositClient) Execute() error {
-	// Connect to server.
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, depositPort), c.config)
-	if err != nil {
-		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
+// Transport pays over transport instead of TCP over TLS. (See PaymentClient.Transport)
+func (c *AutoPaymentClient) Transport(transport PaymentTransport) *AutoPaymentClient {
+	c.transport = transport
+	return c
+}
+
+// Bank exchanges a larger coin at the bank at bankAddr, when the wallet's coins can't cover the amount exactly.
+// Otherwise a larger coin is spent partially.
+func (c *AutoPaymentClient) Bank(bankAddr string, bankConfig *tls.Config) *AutoPaymentClient {
+	c.bankAddr = bankAddr
+	c.bankConfig = bankConfig
+	return c
+}
+
+// Memo attaches memo to every payment. (See PaymentClient.Memo)
+func (c *AutoPaymentClient) Memo(memo string) *AutoPaymentClient {
+	c.memo = memo
+	return c
+}
+
+// Currency selects the currency of the paid coins, DefaultCurrency if empty.
+func (c *AutoPaymentClient) Currency(currency string) *AutoPaymentClient {
+	c.currency = currency
+	return c
+}
+
+// Amount selects the amount to pay.
+func (c *AutoPaymentClient) Amount(amount int64) *AutoPaymentClient {
+	c.amount = amount
+	return c
+}
+
+// Denominations selects the coin values a larger coin is exchanged for, DefaultDenominations if not called.
+func (c *AutoPaymentClient) Denominations(denominations []int64) *AutoPaymentClient {
+	c.denominations = denominations
+	return c
+}
+
+// Account pays with the coins of the wallet's sub-account named account. (See PaymentClient.Account)
+func (c *AutoPaymentClient) Account(account string) *AutoPaymentClient {
+	c.account = account
+	return c
+}
+
+// Confirm asks confirm to approve the payment of each coin. (See PaymentClient.Confirm)
+func (c *AutoPaymentClient) Confirm(confirm func(details *PaymentDetails) bool) *AutoPaymentClient {
+	c.confirm = confirm
+	return c
+}
+
+// Execute pays the amount with coins covering it exactly, one payment per coin. Larger coins are exchanged for coins
+// of the denominations until the amount can be covered.
+func (c *AutoPaymentClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("AutoPaymentClient")
+	defer trace.End()
+
+	trace.Phase(phaseCrypto)
+	// Check amount and denominations.
+	if c.amount < 1 {
+		return core.ErrChangeAmount
+	}
+	if err := core.ValidateDenominations(c.denominations); err != nil {
 		return err
 	}
-	defer conn.Close()
-
-	// Info message.
-	log.Print("Connected to Deposit server")
 
+	trace.Phase(phaseStoreRead)
 	// Read Client.
-	client, err := c.store.ReadClient()
-	if err != nil {
+	if _, err := c.store.ReadClient(); err != nil {
 		log.Fatalf("failed to read Client from database: %v", err)
 		return err
 	}
 
-	decoder := gob.NewDecoder(conn)
-	encoder := gob.NewEncoder(conn)
+	// Check the sub-account's spending limit, for the whole amount.
+	if err := c.store.CheckSpendingLimit(c.account, c.currency, c.amount); err != nil {
+		log.Printf("Payment of %d refused: %v", c.amount, err)
+		return err
+	}
 
-	// Read coins.
+	for count := 0; ; {
+		// Read the sub-account's coins. (Expired coins can only be exchanged)
+		coins, err := c.store.ReadAccountCoins(c.account)
+		if err != nil {
+			log.Fatalf("failed to read coins from database: %v", err)
+			return err
+		}
+		coins = unexpiredCoins(coinsIn(coins, c.currency), core.Now())
+
+		// Check local balance.
+		if balance := totalValue(coins); balance < c.amount {
+			log.Printf("Insufficient balance: %d out of %d", balance, c.amount)
+			return nil
+		}
+
+		// Check the last exchange split a coin.
+		if count > 0 && len(coins) <= count {
+			return fmt.Errorf("failed to exchange a coin worth more than %d", c.amount)
+		}
+		count = len(coins)
+
+		// Pay with the coins covering the amount exactly (if any).
+		values := make([]int64, len(coins))
+		for i := range coins {
+			values[i] = core.NormalizeValue(coins[i].Params.Value)
+		}
+		if cover := core.Cover(values, c.amount); cover != nil {
+			for _, i := range cover {
+				payment := new(PaymentClient).New(c.serverAddr, c.store, c.config).Transport(c.transport).Memo(c.memo).Currency(c.currency).Amount(values[i]).Account(c.account).Confirm(c.confirm)
+				if err := payment.Execute(); err != nil {
+					return err
+				}
+			}
+			log.Printf("Paid %d with %d coins", c.amount, len(cover))
+			return nil
+		}
+
+		// Spend a larger coin partially without a bank to exchange it at.
+		if c.bankAddr == "" {
+			return new(PaymentClient).New(c.serverAddr, c.store, c.config).Transport(c.transport).Memo(c.memo).Currency(c.currency).Amount(c.amount).Account(c.account).Confirm(c.confirm).Execute()
+		}
+
+		// Exchange the smallest coin worth more than the amount, or the largest coin, for coins of the denominations.
+		selected, partial := selectCoin(coins, c.amount)
+		if !partial {
+			selected = &coins[0]
+			for i := range coins {
+				if values[i] > core.NormalizeValue(selected.Params.Value) {
+					selected = &coins[i]
+				}
+			}
+		}
+		split := core.SplitPoint(selected.Params.Value, c.amount, c.denominations)
+		if split == 0 {
+			return fmt.Errorf("failed to cover %d with coins of %v", c.amount, c.denominations)
+		}
+		log.Printf("Exchanging a coin of %d for change", core.NormalizeValue(selected.Params.Value))
+		exchange := new(ExchangeClient).New(c.bankAddr, c.store, c.bankConfig).Currency(c.currency).Split(split).Denominations(c.denominations).Account(c.account)
+		if err := exchange.Execute(); err != nil {
+			return err
+		}
+	}
+}
+
+//
+// AUTO-REFILL
+//
+
+// New.
+func (c *RefillClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *RefillClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute runs the wallet's refill policies: coins are withdrawn in every currency the wallet holds fewer coins of
+// than its policy's threshold, up to its target. (See store.RefillPolicy)
+func (c *RefillClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("RefillClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	if _, err := c.store.ReadClient(); err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+
+	// Read refill policies.
+	policies, err := c.store.ReadRefillPolicies()
+	if err != nil {
+		log.Fatalf("failed to read refill policies from database: %v", err)
+		return err
+	}
+
+	for _, policy := range policies {
+		// Count coins.
+		coins, err := c.store.ReadCoins()
+		if err != nil {
+			log.Fatalf("failed to read coins from database: %v", err)
+			return err
+		}
+		count := len(coinsIn(coins, policy.Currency))
+		if count >= policy.Threshold {
+			continue
+		}
+
+		// Withdraw up to the target.
+		log.Printf("Refilling %s: %d coins, below %d", policy.Currency, count, policy.Threshold)
+		for ; count < policy.Target; count++ {
+			withdrawal := new(WithdrawalClient).New(c.serverAddr, c.store, c.config).Currency(policy.Currency).Value(policy.Value)
+			if err := withdrawal.Execute(); err != nil {
+				return err
+			}
+		}
+		log.Printf("Refilled %s up to %d coins", policy.Currency, count)
+	}
+
+	return nil
+}
+
+//
+// AUTO-EXCHANGE
+//
+
+// New.
+func (c *AutoExchangeClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *AutoExchangeClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Expiring selects the coins expiring within expiring, those already expired included.
+func (c *AutoExchangeClient) Expiring(expiring time.Duration) *AutoExchangeClient {
+	c.expiring = expiring
+	return c
+}
+
+// Execute exchanges each selected coin for a fresh one, in its sub-account. Returns the number of exchanged coins.
+func (c *AutoExchangeClient) Execute() (int, error) {
+	// Trace protocol run.
+	trace := newTrace("AutoExchangeClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	if _, err := c.store.ReadClient(); err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return 0, err
+	}
+
+	// Read coins.
+	coins, err := c.store.ReadCoins()
+	if err != nil {
+		log.Fatalf("failed to read coins from database: %v", err)
+		return 0, err
+	}
+
+	// Exchange each expiring coin.
+	deadline := core.Now().Add(c.expiring)
+	var exchanged int
+	for i := range coins {
+		if coins[i].Params.Expiration.After(deadline) {
+			continue
+		}
+		if err := new(ExchangeClient).New(c.serverAddr, c.store, c.config).Coin(&coins[i]).Execute(); err != nil {
+			return exchanged, err
+		}
+		log.Printf("Exchanged coin %d, expiring %s", coins[i].Profile().Hash(), coins[i].Params.Expiration.Format(time.DateTime))
+		exchanged++
+	}
+	return exchanged, nil
+}
+
+//
+// DEPOSIT (5/6)
+//
+
+// New.
+func (c *DepositClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *DepositClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Release makes the deposit use the escrowed coin released by release.
+func (c *DepositClient) Release(release *big.Int) *DepositClient {
+	c.release = release
+	return c
+}
+
+// Beneficiary credits the deposit to the account that signed auth, instead of the depositing client's.
+func (c *DepositClient) Beneficiary(auth *core.DepositAuthorization) *DepositClient {
+	c.authorized = auth
+	return c
+}
+
+// Currency selects the currency of the deposited coin, DefaultCurrency if empty. Ignored if a release secret is
+// set.
+func (c *DepositClient) Currency(currency string) *DepositClient {
+	c.currency = currency
+	return c
+}
+
+// Execute.
+func (c *DepositClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("DepositClient")
+	defer trace.End()
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, depositPort), c.config)
+	if err != nil {
+		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	// Info message.
+	log.Print("Connected to Deposit server")
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV BankProfile. (Check that the bank didn't switch identity since Accgen)
+	if err := recvBankProfile(decoder, &client.Bank); err != nil {
+		return err
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read coins. (Expired coins can only be exchanged)
 	coins, err := c.store.ReadCoins()
 	if err != nil {
 		log.Fatalf("failed to read coins from database: %v", err)
 		return err
 	}
+	coins = unexpiredCoins(coinsIn(coins, c.currency), core.Now())
 
 	// Check local balance.
 	balance := len(coins)
-	if balance < 1 {
+
+	trace.Phase(phaseCrypto)
+	// Craft escrow release, memo and beneficiary.
+	release := struct {
+		Escrow      *core.Escrow
+		Release     *big.Int
+		Memo        *core.Memo
+		Beneficiary *core.DepositAuthorization
+	}{Beneficiary: c.authorized}
+
+	trace.Phase(phaseStoreRead)
+	// Grab the escrowed coin matching the release secret instead.
+	if c.release != nil {
+		escrows, err := c.store.ReadEscrows()
+		if err != nil {
+			log.Fatalf("failed to read escrowed coins from database: %v", err)
+			return err
+		}
+
+		coins = nil
+		for _, escrowCoin := range escrows {
+			if escrowCoin.Escrow.VerifyRelease(c.release, time.Now()) == nil {
+				coins = append(coins, escrowCoin.Coin)
+				release.Escrow = &escrowCoin.Escrow
+				release.Release = c.release
+				break
+			}
+		}
+	}
+
+	if len(coins) < 1 {
 		log.Printf("No coins on local storage")
 		return nil
 	}
@@ -392,6 +1278,24 @@ func (c *DepositClient) Execute() error {
 	coin := coins[0]
 	coinProfile := coin.Profile()
 
+	trace.Phase(phaseStoreWrite)
+	// Reserve the coin until the deposit ends.
+	unreserve, err := acquireCoins(c.store, []core.Coin{coin})
+	if err != nil {
+		log.Printf("failed to reserve coin %d: %v", coinProfile.Hash(), err)
+		return err
+	}
+	defer unreserve()
+
+	trace.Phase(phaseStoreRead)
+	// Read the memo the coin was paid with (if any).
+	release.Memo, err = c.store.ReadMemo(&coin)
+	if err != nil {
+		log.Fatalf("failed to read memo from database: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseEncode)
 	// SEND ClientProfile.
 	clientProfile := client.Profile()
 	if err := encoder.Encode(*clientProfile); err != nil {
@@ -405,6 +1309,23 @@ func (c *DepositClient) Execute() error {
 		return err
 	}
 
+	// SEND escrow release, memo and beneficiary.
+	if err := encoder.Encode(release); err != nil {
+		log.Fatalf("failed to encode Escrow release message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV fraud review.
+	var review FraudReview
+	if err := decoder.Decode(&review); err != nil {
+		log.Printf("failed to decode FraudReview message: %v", err)
+		return err
+	}
+	if err := review.Err("deposit"); err != nil {
+		return err
+	}
+
 	// RECV response.
 	var accept bool
 	if err := decoder.Decode(&accept); err != nil {
@@ -412,6 +1333,17 @@ func (c *DepositClient) Execute() error {
 		return err
 	}
 
+	// RECV spent coin. (If refused as already spent)
+	if !accept {
+		var spent spentCoin
+		if err := decoder.Decode(&spent); err != nil {
+			log.Printf("failed to decode SpentCoin message: %v", err)
+			return err
+		}
+		return spent.Err(coinProfile)
+	}
+
+	trace.Phase(phaseStoreWrite)
 	// Delete Coin after deposit.
 	if accept {
 		if err := c.store.DeleteCoin(&coin, store.Operation_Deposit); err != nil {
@@ -438,10 +1370,73 @@ func (c *ExchangeClient) New(serverAddr string, store *store.ClientStore, config
 	return c
 }
 
+// Currency selects the currency of the exchanged coin, DefaultCurrency if empty.
+func (c *ExchangeClient) Currency(currency string) *ExchangeClient {
+	c.currency = currency
+	return c
+}
+
+// Split splits the exchanged coin into two coins, of value split and the remaining value. The coin is exchanged
+// for a single coin of its value if 0.
+func (c *ExchangeClient) Split(split int64) *ExchangeClient {
+	c.split = split
+	return c
+}
+
+// Denominations breaks the new coins down into coins of denominations. (See core.Breakdown)
+func (c *ExchangeClient) Denominations(denominations []int64) *ExchangeClient {
+	c.denominations = denominations
+	return c
+}
+
+// To exchanges the coins for coins in currency, at the bank's exchange rate.
+func (c *ExchangeClient) To(currency string) *ExchangeClient {
+	c.to = currency
+	return c
+}
+
+// Consolidate surrenders coins worth amount exactly, instead of a single coin. Unless split, they are exchanged for a
+// single coin of their value.
+func (c *ExchangeClient) Consolidate(amount int64) *ExchangeClient {
+	c.consolidate = amount
+	return c
+}
+
+// Claim surrenders coin, a coin that isn't in the wallet, instead. (See core.ParseGiftToken)
+func (c *ExchangeClient) Claim(coin *core.Coin) *ExchangeClient {
+	c.claim = coin
+	return c
+}
+
+// Coin surrenders coin, a coin of the wallet, instead. Its new coins are kept in its sub-account.
+func (c *ExchangeClient) Coin(coin *core.Coin) *ExchangeClient {
+	c.coin = coin
+	return c
+}
+
+// Account exchanges coins of the wallet's sub-account named account, and keeps the new coins in it. The main
+// account's coins are used if empty.
+func (c *ExchangeClient) Account(account string) *ExchangeClient {
+	c.account = account
+	return c
+}
+
 // Execute.
 func (c *ExchangeClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("ExchangeClient")
+	defer trace.End()
+
+	trace.Phase(phaseCrypto)
+	// Check denominations.
+	if c.denominations != nil {
+		if err := core.ValidateDenominations(c.denominations); err != nil {
+			return err
+		}
+	}
+
 	// Connect to server.
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, exchangePort), c.config)
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, exchangePort), c.config)
 	if err != nil {
 		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
@@ -451,6 +1446,7 @@ func (c *ExchangeClient) Execute() error {
 	// Info message.
 	log.Print("Connected to Exchange server")
 
+	trace.Phase(phaseStoreRead)
 	// Read Client.
 	client, err := c.store.ReadClient()
 	if err != nil {
@@ -458,15 +1454,33 @@ func (c *ExchangeClient) Execute() error {
 		return err
 	}
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
-	// Read coins.
-	coins, err := c.store.ReadCoins()
+	trace.Phase(phaseDecode)
+	// RECV BankProfile. (Check that the bank didn't switch identity since Accgen)
+	if err := recvBankProfile(decoder, &client.Bank); err != nil {
+		return err
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the sub-account's coins.
+	coins, err := c.store.ReadAccountCoins(c.account)
 	if err != nil {
 		log.Fatalf("failed to read coins from database: %v", err)
 		return err
 	}
+	coins = coinsIn(coins, c.currency)
+	if c.claim != nil {
+		coins = []core.Coin{*c.claim}
+	}
+	if c.coin != nil {
+		coins = []core.Coin{*c.coin}
+		if c.account, err = c.store.ReadCoinAccount(c.coin); err != nil {
+			log.Printf("failed to read coin's sub-account from database: %v", err)
+			return err
+		}
+	}
 
 	// Check local balance.
 	balance := len(coins)
@@ -475,10 +1489,55 @@ func (c *ExchangeClient) Execute() error {
 		return nil
 	}
 
-	// Grab 1 coin.
-	coin := coins[0]
-	coinProfile := coin.Profile()
+	trace.Phase(phaseCrypto)
+	// Grab the coins to surrender.
+	var surrendered []core.Coin
+	converting := core.NormalizeCurrency(c.to) != core.NormalizeCurrency(c.currency) && c.to != ""
+	if c.claim != nil || c.coin != nil {
+		surrendered = coins
+	} else if c.consolidate > 0 {
+		// Coins covering the consolidated amount exactly.
+		values := make([]int64, len(coins))
+		for i := range coins {
+			values[i] = core.NormalizeValue(coins[i].Params.Value)
+		}
+		cover := core.Cover(values, c.consolidate)
+		if cover == nil || len(cover) > maxExchangeCoins {
+			log.Printf("No coins worth %d exactly on local storage", c.consolidate)
+			return nil
+		}
+		for _, i := range cover {
+			surrendered = append(surrendered, coins[i])
+		}
+	} else {
+		// 1 coin worth more than the split.
+		selected, _ := selectCoin(coins, c.split)
+		if c.split > 0 && !converting {
+			selected, _ = selectCoin(coins, c.split+1)
+		}
+		if selected == nil {
+			log.Printf("No coin worth more than %d on local storage", c.split)
+			return nil
+		}
+		surrendered = append(surrendered, *selected)
+	}
+	coinProfiles := make([]core.CoinProfile, len(surrendered))
+	for i := range surrendered {
+		coinProfiles[i] = *surrendered[i].Profile()
+	}
 
+	// Reserve the coins until the exchange ends. (A claimed coin was never in the wallet)
+	if c.claim == nil {
+		trace.Phase(phaseStoreWrite)
+		unreserve, err := acquireCoins(c.store, surrendered)
+		if err != nil {
+			log.Printf("failed to reserve coins: %v", err)
+			return err
+		}
+		defer unreserve()
+	}
+
+	trace.Phase(phaseEncode)
 	// SEND client profile.
 	clientProfile := client.Profile()
 	if err := encoder.Encode(*clientProfile); err != nil {
@@ -486,110 +1545,1711 @@ func (c *ExchangeClient) Execute() error {
 		return err
 	}
 
-	// SEND CoinProfile.
-	if err := encoder.Encode(*coinProfile); err != nil {
+	// SEND CoinProfiles.
+	if err := encoder.Encode(coinProfiles); err != nil {
 		log.Fatalf("failed to encode CoinProfile message: %v", err)
 		return err
 	}
 
-	// Compute coin request.
-	newCoin := client.NewCoinRequest()
+	// SEND target currency.
+	currency := surrendered[0].Params.Currency
+	if converting {
+		currency = c.to
+	}
+	if err := encoder.Encode(currency); err != nil {
+		log.Fatalf("failed to encode Exchange currency message: %v", err)
+		return err
+	}
 
-	// Craft request.
-	request := struct {
-		ALower *big.Int
-		C      *big.Int
-	}{
-		ALower: newCoin.Params.ALower,
-		C:      newCoin.Params.C,
+	trace.Phase(phaseDecode)
+	// RECV exchange rate.
+	var rate core.Rate
+	if err := decoder.Decode(&rate); err != nil {
+		log.Fatalf("failed to decode Rate message: %v", err)
+		return err
+	}
+	if rate.To != core.NormalizeCurrency(currency) {
+		return fmt.Errorf("bank sent a rate to %s, expected %s", rate.To, core.NormalizeCurrency(currency))
 	}
 
-	// SEND coin request.
-	if err := encoder.Encode(request); err != nil {
-		log.Fatalf("failed to encode Withdrawal request message: %v", err)
+	trace.Phase(phaseCrypto)
+	// Values of the new coins.
+	var total int64
+	for i := range surrendered {
+		total += core.NormalizeValue(surrendered[i].Params.Value)
+	}
+	if total, err = rate.Convert(total); err != nil {
 		return err
 	}
+	values := []int64{total}
+	if c.split > 0 {
+		if c.split >= total {
+			return fmt.Errorf("can't split %d at %d", total, c.split)
+		}
+		values = []int64{c.split, total - c.split}
+	}
+	if c.denominations != nil {
+		var broken []int64
+		for _, value := range values {
+			broken = append(broken, core.Breakdown(value, c.denominations)...)
+		}
+		values = broken
+	}
+	if len(values) > maxExchangeCoins {
+		return fmt.Errorf("can't exchange %d for more than %d coins", total, maxExchangeCoins)
+	}
 
-	// RECV coin response.
-	var response struct {
-		Expiration time.Time
-		A1         *big.Int
-		C1         *big.Int
+	// Compute coin requests.
+	newCoins := make([]*core.Coin, len(values))
+	requests := make([]coinRequest, len(values))
+	var minted *core.Client
+	for i, value := range values {
+		newCoins[i], minted, err = newCoinRequest(c.store, client, currency)
+		if err != nil {
+			log.Fatalf("failed to compute coin request: %v", err)
+			return err
+		}
+		newCoins[i].SetValue(value)
+		requests[i] = coinRequest{
+			ALower: newCoins[i].Params.ALower,
+			C:      newCoins[i].Params.C,
+			Value:  value,
+		}
 	}
-	if err := decoder.Decode(&response); err != nil {
+
+	trace.Phase(phaseEncode)
+	// SEND coin requests.
+	if err := encoder.Encode(requests); err != nil {
+		log.Fatalf("failed to encode Withdrawal request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV coin responses.
+	var responses []coinResponseMsg
+	if err := decoder.Decode(&responses); err != nil {
 		log.Fatalf("failed to decode Withdrawal response message: %v", err)
 		return err
 	}
+	if len(responses) != len(newCoins) {
+		return fmt.Errorf("expected %d coin responses, got %d", len(newCoins), len(responses))
+	}
 
-	// Finish the coin using response.
-	client.FinishCoin(newCoin, response.Expiration, response.A1, response.C1)
+	for i, response := range responses {
+		// Check the coin's expiration against its validity period.
+		if err := core.CheckExpiration(response.Expiration, response.Validity, time.Now()); err != nil {
+			log.Printf("== ALERT: invalid coin expiration %s: %v", response.Expiration, err)
+			return err
+		}
 
-	// Write coin.
-	if err := c.store.WriteCoin(newCoin, store.Operation_Exchange); err != nil {
-		log.Fatalf("failed to write Coin into database: %v", err)
-		return err
+		// Finish the coin using response.
+		minted.FinishCoin(newCoins[i], response.Expiration, response.A1, response.C1)
+
+		// Write coin.
+		if err := c.store.WriteCoin(newCoins[i], store.Operation_Exchange); err != nil {
+			log.Fatalf("failed to write Coin into database: %v", err)
+			return err
+		}
+		if c.account != "" {
+			if err := c.store.MoveCoin(newCoins[i], c.account); err != nil {
+				log.Fatalf("failed to move Coin into sub-account %q: %v", c.account, err)
+				return err
+			}
+		}
 	}
 
-	// Delete previous coin.
-	if err := c.store.DeleteCoin(&coin, store.Operation_Exchange); err != nil {
-		log.Fatalf("failed to delete coin from database: %v", err)
+	trace.Phase(phaseStoreWrite)
+	// Delete previous coins. (A claimed coin was never in the wallet)
+	if c.claim == nil {
+		for i := range surrendered {
+			if err := c.store.DeleteCoin(&surrendered[i], store.Operation_Exchange); err != nil {
+				log.Fatalf("failed to delete coin from database: %v", err)
+			}
+		}
 	}
 
 	// Info message.
-	log.Printf("Coin: %s", newCoin)
+	for _, newCoin := range newCoins {
+		log.Printf("Coin: %s", newCoin)
+	}
 	log.Printf("Exchange Success!")
 
 	return nil
 }
 
 //
-// GET
+// RECLAIM
 //
 
 // New.
-func (c *GetClient) New(serverAddr string) *GetClient {
+func (c *ReclaimClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *ReclaimClient {
 	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
 	return c
 }
 
 // Execute.
-func (c *GetClient) Execute() error {
+func (c *ReclaimClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("ReclaimClient")
+	defer trace.End()
+
 	// Connect to server.
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", c.serverAddr, getPort))
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, reclaimPort), c.config)
 	if err != nil {
-		log.Fatalf("failed to connecto to server at %s: %v", c.serverAddr, err)
+		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
 	}
 	defer conn.Close()
 
 	// Info message.
-	log.Printf("Connected to Get server")
+	log.Print("Connected to Reclaim server")
 
-	// Create file to copy into.
-	directory, err := store.GetZibaDir()
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
 	if err != nil {
-		log.Fatalf("failed to retrieve Ziba directory: %v", err)
+		log.Fatalf("failed to read Client from database: %v", err)
 		return err
 	}
-	filepath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", c.serverAddr))
-	file, err := os.Create(filepath)
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// Read escrowed coins.
+	escrows, err := c.store.ReadEscrows()
+	if err != nil {
+		log.Fatalf("failed to read escrowed coins from database: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Grab 1 escrowed coin past its timeout.
+	var escrowCoin *store.EscrowCoin
+	for i := range escrows {
+		if escrows[i].Escrow.VerifyRefund(escrows[i].Refund, time.Now()) == nil {
+			escrowCoin = &escrows[i]
+			break
+		}
+	}
+	if escrowCoin == nil {
+		log.Printf("No reclaimable coins on local storage")
+		return nil
+	}
+	coin := escrowCoin.Coin
+	coinProfile := coin.Profile()
+
+	trace.Phase(phaseEncode)
+	// SEND client profile.
+	clientProfile := client.Profile()
+	if err := encoder.Encode(*clientProfile); err != nil {
+		log.Fatalf("failed to encode ClientProfile message: %v", err)
+		return err
+	}
+
+	// SEND CoinProfile.
+	if err := encoder.Encode(*coinProfile); err != nil {
+		log.Fatalf("failed to encode CoinProfile message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Craft escrow refund.
+	refund := struct {
+		Escrow *core.Escrow
+		Refund *big.Int
+	}{
+		Escrow: &escrowCoin.Escrow,
+		Refund: escrowCoin.Refund,
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND escrow refund.
+	if err := encoder.Encode(refund); err != nil {
+		log.Fatalf("failed to encode Escrow refund message: %v", err)
+		return err
+	}
+
+	// Compute coin request.
+	newCoin, minted, err := newCoinRequest(c.store, client, coin.Params.Currency)
+	if err != nil {
+		log.Fatalf("failed to compute coin request: %v", err)
+		return err
+	}
+	newCoin.SetValue(coin.Params.Value)
+
+	// Craft request.
+	request := struct {
+		ALower *big.Int
+		C      *big.Int
+	}{
+		ALower: newCoin.Params.ALower,
+		C:      newCoin.Params.C,
+	}
+
+	// SEND coin request.
+	if err := encoder.Encode(request); err != nil {
+		log.Fatalf("failed to encode Reclaim request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV coin response.
+	var response struct {
+		Expiration time.Time
+		Validity   time.Duration
+		A1         *big.Int
+		C1         *big.Int
+	}
+	if err := decoder.Decode(&response); err != nil {
+		log.Fatalf("failed to decode Reclaim response message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the coin's expiration against its validity period.
+	if err := core.CheckExpiration(response.Expiration, response.Validity, time.Now()); err != nil {
+		log.Printf("== ALERT: invalid coin expiration %s: %v", response.Expiration, err)
+		return err
+	}
+	// Finish the coin using response.
+	minted.FinishCoin(newCoin, response.Expiration, response.A1, response.C1)
+
+	trace.Phase(phaseStoreWrite)
+	// Write coin.
+	if err := c.store.WriteCoin(newCoin, store.Operation_Reclaim); err != nil {
+		log.Fatalf("failed to write Coin into database: %v", err)
+		return err
+	}
+
+	// Delete escrowed coin.
+	if err := c.store.DeleteCoin(&coin, store.Operation_Reclaim); err != nil {
+		log.Fatalf("failed to delete coin from database: %v", err)
+	}
+
+	// Info message.
+	log.Printf("Coin: %s", newCoin)
+	log.Printf("Reclaim Success!")
+
+	return nil
+}
+
+//
+// CHANGE
+//
+
+// New.
+func (c *ChangeClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *ChangeClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute collects the remainder coins of this client's partial payments, once their payees deposited them.
+func (c *ChangeClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("ChangeClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+
+	// Read uncollected change.
+	changes, err := c.store.ReadChanges()
+	if err != nil {
+		log.Fatalf("failed to read change from database: %v", err)
+		return err
+	}
+	if len(changes) == 0 {
+		log.Printf("No change to collect")
+		return nil
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, changePort), c.config)
+	if err != nil {
+		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	// Info message.
+	log.Print("Connected to Change server")
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND client profile.
+	clientProfile := client.Profile()
+	if err := encoder.Encode(*clientProfile); err != nil {
+		log.Fatalf("failed to encode ClientProfile message: %v", err)
+		return err
+	}
+
+	// Craft change claims.
+	claims := make([]struct {
+		ALower *big.Int
+		C      *big.Int
+		Claim  *big.Int
+	}, len(changes))
+	for i, change := range changes {
+		claims[i].ALower = change.Coin.Params.ALower
+		claims[i].C = change.Coin.Params.C
+		claims[i].Claim = change.Claim
+	}
+
+	// SEND change claims.
+	if err := encoder.Encode(claims); err != nil {
+		log.Fatalf("failed to encode Change claims message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV coin responses.
+	var responses []struct {
+		Ready      bool
+		Expiration time.Time
+		Validity   time.Duration
+		A1         *big.Int `ziba:"optional"` // Ready only.
+		C1         *big.Int `ziba:"optional"`
+	}
+	if err := decoder.Decode(&responses); err != nil {
+		log.Fatalf("failed to decode Change response message: %v", err)
+		return err
+	}
+	if len(responses) != len(changes) {
+		return fmt.Errorf("expected %d coin responses, got %d", len(changes), len(responses))
+	}
+
+	collected := 0
+	for i, response := range responses {
+		// Change not deposited yet.
+		if !response.Ready {
+			continue
+		}
+		coin := &changes[i].Coin
+
+		// Check the coin's expiration against its validity period.
+		if err := core.CheckExpiration(response.Expiration, response.Validity, time.Now()); err != nil {
+			log.Printf("== ALERT: invalid coin expiration %s: %v", response.Expiration, err)
+			return err
+		}
+
+		// Finish the coin using response, with the mint of its currency.
+		mint, err := c.store.ReadMint(client, coin.Params.Currency)
+		if err != nil {
+			log.Fatalf("failed to read mint from database: %v", err)
+			return err
+		}
+		minted, err := client.Mint(mint)
+		if err != nil {
+			log.Fatalf("failed to use mint: %v", err)
+			return err
+		}
+		minted.FinishCoin(coin, response.Expiration, response.A1, response.C1)
+
+		// Write coin.
+		if err := c.store.WriteCoin(coin, store.Operation_Change); err != nil {
+			log.Fatalf("failed to write Coin into database: %v", err)
+			return err
+		}
+
+		// Delete collected change.
+		if err := c.store.DeleteChange(coin); err != nil {
+			log.Fatalf("failed to delete change from database: %v", err)
+		}
+
+		log.Printf("Coin: %s", coin)
+		collected++
+	}
+
+	// Info message.
+	log.Printf("Collected %d out of %d", collected, len(changes))
+	log.Printf("Change Success!")
+
+	return nil
+}
+
+//
+// REVOCATIONS
+//
+
+// New.
+func (c *RevocationClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *RevocationClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute fetches the bank's revocation list, consulted by the PaymentServer before accepting a payment.
+func (c *RevocationClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("RevocationClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, revocationPort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV revocation list.
+	var list core.Revocations
+	if err := decoder.Decode(&list); err != nil {
+		log.Printf("failed to decode Revocations message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Verify the list was signed by the bank.
+	if valid := list.Verify(&client.Bank); !valid {
+		return fmt.Errorf("invalid revocation list signature")
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Check the list is newer than the stored one. (A replayed list would unrevoke coins)
+	previous, err := c.store.ReadRevocations()
+	if err != nil {
+		log.Fatalf("failed to read Revocations from database: %v", err)
+		return err
+	}
+	if previous != nil && !list.Issued.After(previous.Issued) {
+		return fmt.Errorf("revocation list issued %s is older than the stored one", list.Issued)
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Write revocation list.
+	if err := c.store.WriteRevocations(&list); err != nil {
+		log.Fatalf("failed to write Revocations into database: %v", err)
+		return err
+	}
+
+	// Info message.
+	log.Printf("Revocations: %d coins, %d accounts (issued %s)", len(list.Coins), len(list.Accounts), list.Issued)
+
+	return nil
+}
+
+//
+// REVIEW
+//
+
+// New.
+func (c *ReviewClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *ReviewClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Alert selects the polled alert, the number of a FraudReview.
+func (c *ReviewClient) Alert(alert int64) *ReviewClient {
+	c.alert = alert
+	return c
+}
+
+// Execute polls the review of the operation held by the alert.
+func (c *ReviewClient) Execute() (*FraudReview, error) {
+	// Trace protocol run.
+	trace := newTrace("ReviewClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return nil, err
+	} else if client == nil {
+		return nil, fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, reviewPort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// Craft request.
+	request := struct {
+		Client core.ClientProfile
+		Alert  int64
+	}{
+		Client: *client.Profile(),
+		Alert:  c.alert,
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND client profile and alert.
+	if err := encoder.Encode(request); err != nil {
+		log.Printf("failed to encode Review request message: %v", err)
+		return nil, err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV review. (The connection is closed for an unknown alert)
+	var review FraudReview
+	if err := decoder.Decode(&review); err != nil {
+		log.Printf("failed to decode FraudReview message: %v", err)
+		return nil, fmt.Errorf("no alert %d for account %d", c.alert, client.Profile().Hash())
+	}
+
+	return &review, nil
+}
+
+//
+// STATUS
+//
+
+// New.
+func (c *StatusClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *StatusClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute polls the outcome of the wallet's pending withdrawals: the issued ones are finished into coins, the ones
+// refused or unknown to the bank (never debited) are dropped, and the ones pending review are kept.
+func (c *StatusClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("StatusClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	} else if client == nil {
+		return fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Read pending withdrawals.
+	pending, err := c.store.ReadPendingWithdrawals()
+	if err != nil {
+		log.Fatalf("failed to read pending withdrawals from database: %v", err)
+		return err
+	}
+	if len(pending) == 0 {
+		log.Print("No pending withdrawal")
+		return nil
+	}
+	if len(pending) > maxStatusTokens {
+		pending = pending[:maxStatusTokens]
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, statusPort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// Craft request.
+	request := struct {
+		Client core.ClientProfile
+		Tokens []string
+	}{
+		Client: *client.Profile(),
+	}
+	for _, withdrawal := range pending {
+		request.Tokens = append(request.Tokens, withdrawal.Token)
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND client profile and tokens.
+	if err := encoder.Encode(request); err != nil {
+		log.Printf("failed to encode Status request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV statuses.
+	var statuses []store.WithdrawalStatus
+	if err := decoder.Decode(&statuses); err != nil {
+		log.Printf("failed to decode WithdrawalStatus message: %v", err)
+		return err
+	}
+	if len(statuses) != len(pending) {
+		return fmt.Errorf("expected %d withdrawal statuses, got %d", len(pending), len(statuses))
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Settle each pending withdrawal.
+	for i := range pending {
+		withdrawal, status := &pending[i], &statuses[i]
+		params := &withdrawal.Coin.Params
+		switch status.Status {
+		case store.Status_Issued:
+			minted, err := mintClient(c.store, client, params.Currency)
+			if err != nil {
+				log.Printf("failed to read mint of %q: %v", params.Currency, err)
+				return err
+			}
+			err = finishWithdrawal(c.store, minted, withdrawal, status.Expiration, status.Validity, status.A1, status.C1)
+			if err != nil {
+				return err
+			}
+			log.Printf("Withdrawal %s: issued, coin %s", withdrawal.Token, &withdrawal.Coin)
+			continue
+		case store.Status_Pending:
+			log.Printf("Withdrawal %s of %d %s: pending review (alert %d)", withdrawal.Token, params.Value, params.Currency, status.Alert)
+			continue
+		case store.Status_Refused:
+			log.Printf("Withdrawal %s of %d %s: refused (alert %d), dropped", withdrawal.Token, params.Value, params.Currency, status.Alert)
+		default:
+			log.Printf("Withdrawal %s of %d %s: never served by the bank, dropped", withdrawal.Token, params.Value, params.Currency)
+		}
+		if err := c.store.DeletePendingWithdrawal(withdrawal.Token); err != nil {
+			log.Printf("failed to delete pending withdrawal from database: %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//
+// RESUME
+//
+
+// New.
+func (c *ResumeClient) New(store *store.ClientStore) *ResumeClient {
+	c.store = store
+	return c
+}
+
+// Execute settles the wallet's payments cut short: the unsigned ones are aborted, the signed ones are resumed at their
+// merchant. (See PAYMENT SESSIONS)
+func (c *ResumeClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("ResumeClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	} else if client == nil {
+		return fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Read payment sessions.
+	records, err := c.store.ReadPaymentSessions(false)
+	if err != nil {
+		log.Fatalf("failed to read payment sessions from database: %v", err)
+		return err
+	}
+	if len(records) == 0 {
+		log.Print("No payment to resume")
+		return nil
+	}
+
+	// Settle each payment.
+	doubtful := 0
+	for i := range records {
+		record := &records[i]
+		machine, err := loadPaymentMachine(c.store, record)
+		if err == nil && record.State < store.State_Signed {
+			log.Printf("Payment %s to %s: never signed, aborted", record.Token, record.Peer)
+			err = machine.end()
+		} else if err == nil {
+			err = c.resume(machine)
+		}
+		if err != nil {
+			log.Printf("Payment %s to %s: %v", record.Token, record.Peer, err)
+			doubtful++
+		}
+	}
+	if doubtful > 0 {
+		return fmt.Errorf("%d payments still cut short, resume them again later", doubtful)
+	}
+
+	return nil
+}
+
+// resume resumes the signed payment of machine at its merchant.
+func (c *ResumeClient) resume(machine *paymentMachine) error {
+	session := &machine.session
+	peer := machine.record.Peer
+
+	// Load TLS client configuration.
+	config, err := GetStoredClientTLSConfig(c.store.Certificates(), store.Role_Merchant, peer)
+	if err != nil {
+		return err
+	}
+
+	// Connect to server.
+	conn, err := paymentTransport(peer, c.store, config).Dial(peer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// SEND CoinProfile.
+	if err := encoder.Encode(*session.Coin.Profile()); err != nil {
+		return fmt.Errorf("failed to encode CoinProfile message: %v", err)
+	}
+
+	// SEND escrow request. (Same token)
+	if err := encoder.Encode(session.Request); err != nil {
+		return fmt.Errorf("failed to encode Escrow request message: %v", err)
+	}
+
+	// RECV Elgamal's msg.
+	var stamp paymentStamp
+	if err := decoder.Decode(&stamp); err != nil {
+		return fmt.Errorf("failed to decode Elgamal's msg message: %v", err)
+	}
+
+	// Check the merchant stamped the coin as recorded. (A new stamp is never signed)
+	if stamp.Msg == nil || stamp.Msg.Cmp(session.Stamp.Msg) != 0 {
+		log.Printf("== ALERT: merchant %s stamped the resumed payment %s anew", peer, machine.record.Token)
+		return fmt.Errorf("merchant lost the payment, the coin %d is in doubt", session.Coin.Profile().Hash())
+	}
+
+	// SEND Elgamal's second. (Same signature)
+	if err := encoder.Encode(session.Second); err != nil {
+		return fmt.Errorf("failed to encode Elgamal's second message: %v", err)
+	}
+
+	// RECV acceptance.
+	var accept bool
+	if err := decoder.Decode(&accept); err != nil {
+		return fmt.Errorf("failed to decode acceptance message: %v", err)
+	}
+	if !accept {
+		return fmt.Errorf("merchant refused the signed coin %d", session.Coin.Profile().Hash())
+	}
+
+	// Finish the payment.
+	if err := finishPayment(c.store, machine, conn); err != nil {
+		return err
+	}
+	log.Printf("Payment %s to %s: resumed, accepted", machine.record.Token, peer)
+
+	return nil
+}
+
+//
+// CLOSURE
+//
+
+// New.
+func (c *ClosureClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *ClosureClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute closes the wallet's account, surrendering its unspent coins: the bank pays out the balances, and refuses the
+// account's further operations. Escrowed coins are to be released or reclaimed first.
+func (c *ClosureClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("ClosureClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	} else if client == nil {
+		return fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Check no coin is escrowed. (Not refunded once the account is closed)
+	escrows, err := c.store.ReadEscrows()
+	if err != nil {
+		log.Fatalf("failed to read escrowed coins from database: %v", err)
+		return err
+	}
+	if len(escrows) > 0 {
+		return fmt.Errorf("%d escrowed coins are held, wait for their release or reclaim them first", len(escrows))
+	}
+
+	// Read coins.
+	coins, err := c.store.ReadCoins()
+	if err != nil {
+		log.Fatalf("failed to read coins from database: %v", err)
+		return err
+	}
+	if len(coins) > maxClosureCoins {
+		return fmt.Errorf("%d coins are held, exchange them for fewer coins first", len(coins))
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, closurePort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	// Info message.
+	log.Print("Connected to Closure server")
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV BankProfile. (Check that the bank didn't switch identity since Accgen)
+	if err := recvBankProfile(decoder, &client.Bank); err != nil {
+		return err
+	}
+
+	// RECV nonce.
+	var nonce []byte
+	if err := decoder.Decode(&nonce); err != nil {
+		log.Printf("failed to decode nonce message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Reserve the coins until the closure ends.
+	unreserve, err := acquireCoins(c.store, coins)
+	if err != nil {
+		log.Printf("failed to reserve coins: %v", err)
+		return err
+	}
+	defer unreserve()
+
+	trace.Phase(phaseCrypto)
+	// Craft request, signed over the nonce.
+	request := &core.ClosureRequest{Coins: make([]core.CoinProfile, len(coins))}
+	for i := range coins {
+		request.Coins[i] = *coins[i].Profile()
+	}
+	request = client.SignClosure(nonce, request)
+
+	trace.Phase(phaseEncode)
+	// SEND client profile.
+	if err := encoder.Encode(*client.Profile()); err != nil {
+		log.Printf("failed to encode ClientProfile message: %v", err)
+		return err
+	}
+
+	// SEND closure request.
+	if err := encoder.Encode(request); err != nil {
+		log.Printf("failed to encode Closure request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV closure statement.
+	var statement closureStatement
+	if err := decoder.Decode(&statement); err != nil {
+		log.Printf("failed to decode Closure statement message: %v", err)
+		return fmt.Errorf("closure was cut short, the account may be closed already")
+	}
+	if len(statement.Refused) > 0 {
+		return fmt.Errorf("closure was refused: %s", statement.Refused)
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Delete the surrendered coins.
+	for i := range coins {
+		if err := c.store.DeleteCoin(&coins[i], store.Operation_Closure); err != nil {
+			log.Fatalf("failed to delete coin from database: %v", err)
+		}
+	}
+
+	// Info message.
+	currencies := slices.Sorted(maps.Keys(statement.Balances))
+	for _, currency := range currencies {
+		log.Printf("Paid out: %d %s", statement.Balances[currency], currency)
+	}
+	log.Printf("Account closed on %s, surrendering %d coins", statement.Date.Local().Format(time.RFC3339), len(coins))
+	log.Print("Closure Success!")
+
+	return nil
+}
+
+//
+// TRANSFER
+//
+
+// New.
+func (c *TransferClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *TransferClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Payee selects the credited account, the hash of its ClientProfile.
+func (c *TransferClient) Payee(payee uint32) *TransferClient {
+	c.payee = payee
+	return c
+}
+
+// Currency selects the currency of the transfer, DefaultCurrency if empty.
+func (c *TransferClient) Currency(currency string) *TransferClient {
+	c.currency = currency
+	return c
+}
+
+// Value selects the amount transferred.
+func (c *TransferClient) Value(value int64) *TransferClient {
+	c.value = value
+	return c
+}
+
+// Execute transfers the amount from the wallet's account to the payee's, without coins: the bank debits the remote
+// balance and credits the payee's.
+func (c *TransferClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("TransferClient")
+	defer trace.End()
+
+	if c.value <= 0 {
+		return fmt.Errorf("invalid transfer amount: %d", c.value)
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	} else if client == nil {
+		return fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, transferPort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	// Info message.
+	log.Print("Connected to Transfer server")
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV BankProfile. (Check that the bank didn't switch identity since Accgen)
+	if err := recvBankProfile(decoder, &client.Bank); err != nil {
+		return err
+	}
+
+	// RECV nonce.
+	var nonce []byte
+	if err := decoder.Decode(&nonce); err != nil {
+		log.Printf("failed to decode nonce message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Craft request, signed over the nonce.
+	request := client.SignTransfer(nonce, &core.TransferRequest{
+		Payee:    c.payee,
+		Currency: core.NormalizeCurrency(c.currency),
+		Value:    c.value,
+	})
+
+	trace.Phase(phaseEncode)
+	// SEND client profile.
+	if err := encoder.Encode(*client.Profile()); err != nil {
+		log.Printf("failed to encode ClientProfile message: %v", err)
+		return err
+	}
+
+	// SEND transfer request.
+	if err := encoder.Encode(request); err != nil {
+		log.Printf("failed to encode Transfer request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV transfer statement.
+	var statement transferStatement
+	if err := decoder.Decode(&statement); err != nil {
+		log.Printf("failed to decode Transfer statement message: %v", err)
+		return fmt.Errorf("transfer was cut short, check the balance before transferring again")
+	}
+	if len(statement.Refused) > 0 {
+		return fmt.Errorf("transfer was refused: %s", statement.Refused)
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Debit the remote balance.
+	if err := c.store.DebitRemoteBalance(request.Currency, request.Value); err != nil {
+		log.Fatalf("failed to update balance in database: %v", err)
+	}
+
+	// Info message.
+	log.Printf("Transfer %d: %d %s to account %d, %d %s left", statement.ID, request.Value, request.Currency, request.Payee,
+		statement.Balance, request.Currency)
+	log.Print("Transfer Success!")
+
+	return nil
+}
+
+//
+// ATTESTATION
+//
+
+// New.
+func (c *AttestationClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *AttestationClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute asks the bank to attest the certificate cert, of Role_Own, and returns the verified attestation, handed out
+// by the GetServer along with the certificate.
+func (c *AttestationClient) Execute(cert *store.Certificate) (*core.Attestation, error) {
+	// Trace protocol run.
+	trace := newTrace("AttestationClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return nil, err
+	} else if client == nil {
+		return nil, fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, attestationPort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	encoder := gob.NewEncoder(conn)
+	decoder := newDecoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND attestation request.
+	if err := encoder.Encode(*client.RequestAttestation(cert.Fingerprint)); err != nil {
+		log.Printf("failed to encode AttestationRequest message: %v", err)
+		return nil, err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV attestation.
+	var attestation core.Attestation
+	if err := decoder.Decode(&attestation); err != nil {
+		log.Printf("failed to decode Attestation message: %v", err)
+		return nil, err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Verify the attestation was signed by the bank, for this account.
+	if err := attestation.Verify(&client.Bank, cert.Fingerprint); err != nil {
+		return nil, err
+	}
+	if attestation.Merchant.Digest().Cmp(client.Profile().Digest()) != 0 {
+		return nil, core.ErrAttestation
+	}
+
+	// Info message.
+	log.Printf("Attestation of certificate %s (issued %s)", cert.Fingerprint, attestation.Issued)
+
+	return &attestation, nil
+}
+
+//
+// ADMIN
+//
+
+// New.
+func (c *AdminClient) New(serverAddr string, config *tls.Config) *AdminClient {
+	c.serverAddr = serverAddr
+	c.config = config
+	return c
+}
+
+// Execute sends request to the bank's admin server and returns its output.
+func (c *AdminClient) Execute(request AdminRequest) (string, error) {
+	// Trace protocol run.
+	trace := newTrace("AdminClient")
+	defer trace.End()
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, adminPort), c.config)
+	if err != nil {
+		log.Printf("failed to connect to server at %s: %v", c.serverAddr, err)
+		return "", err
+	}
+	defer conn.Close()
+
+	encoder := gob.NewEncoder(conn)
+	decoder := newDecoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND request.
+	if err := encoder.Encode(request); err != nil {
+		log.Printf("failed to encode Admin request message: %v", err)
+		return "", err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV response.
+	var response adminResponse
+	if err := decoder.Decode(&response); err != nil {
+		log.Printf("failed to decode Admin response message: %v", err)
+		return "", err
+	}
+	if len(response.Error) > 0 {
+		return response.Output, fmt.Errorf("%s", response.Error)
+	}
+
+	return response.Output, nil
+}
+
+//
+// GET
+//
+
+// New.
+func (c *GetClient) New(serverAddr string, store *store.ClientStore) *GetClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	return c
+}
+
+// Execute fetches the merchant's certificate and keeps it once its attestation is verified.
+func (c *GetClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("GetClient")
+	defer trace.End()
+
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
+	if err != nil {
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	} else if client == nil {
+		return fmt.Errorf("no account for bank %s", c.store.BankName)
+	}
+
+	// Connect to server. (Through its relay for relay addresses)
+	var conn net.Conn
+	if _, _, ok := splitRelayAddr(c.serverAddr); ok {
+		conn, err = dialRelay(c.store.Certificates(), c.serverAddr, relayGet)
+	} else {
+		conn, err = net.Dial("tcp", hostPort(c.serverAddr, getPort))
+	}
+	if err != nil {
+		log.Fatalf("failed to connecto to server at %s: %v", c.serverAddr, err)
+		return err
+	}
+	defer conn.Close()
+
+	// Info message.
+	log.Printf("Connected to Get server")
+
+	decoder := newDecoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV certificate and attestation.
+	var message certificateMessage
+	if err := decoder.Decode(&message); err != nil {
+		log.Printf("failed to decode Certificate message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the certificate's length and checksum.
+	cert, err := message.Cert.Certificate()
+	if err != nil {
+		log.Printf("failed to receive certificate of %s: %v", c.serverAddr, err)
+		return err
+	}
+
+	// Verify the bank attested the certificate.
+	fingerprint, err := store.PEMFingerprint(cert)
+	if err != nil || len(fingerprint) == 0 {
+		log.Printf("== ALERT: malformed certificate from %s", c.serverAddr)
+		return store.ErrMalformedCertificate
+	}
+	if err := message.Attestation.Verify(&client.Bank, fingerprint); err != nil {
+		log.Printf("== ALERT: certificate of %s isn't attested by the bank: %v", c.serverAddr, err)
+		return err
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Keep the merchant's certificate.
+	if err := c.store.Certificates().Write(&store.Certificate{Role: store.Role_Merchant, Name: c.serverAddr, Cert: cert}); err != nil {
+		log.Printf("failed to write certificate of %s: %v", c.serverAddr, err)
+		return err
+	}
+
+	// Info message.
+	log.Printf("Get Success! (account %d)", message.Attestation.Merchant.Hash())
+
+	return nil
+}
+
+//
+// RELAY
+//
+
+// paymentTransport returns the transport of the payments to the merchant at serverAddr: through its relay for relay
+// addresses, TCP over TLS otherwise.
+func paymentTransport(serverAddr string, store *store.ClientStore, config *tls.Config) PaymentTransport {
+	if _, relayAddr, ok := splitRelayAddr(serverAddr); ok {
+		return new(RelayTransport).New(relayAddr, store.Certificates(), config)
+	}
+	return new(TLSTransport).New(config)
+}
+
+// New. The relay is trusted by its bank certificate in certs, the merchant by config: the payer's TLS client
+// configuration, or the merchant's server configuration.
+func (t *RelayTransport) New(relayAddr string, certs *store.Certificates, config *tls.Config) *RelayTransport {
+	t.relayAddr = relayAddr
+	t.certs = certs
+	t.config = config
+	return t
+}
+
+// Register makes Listen register the merchant name at the relay, authenticated by its certificate own, and hand out
+// its certificate and attestation through get.
+func (t *RelayTransport) Register(name string, own *store.Certificate, get *GetServer) *RelayTransport {
+	t.name = name
+	t.own = own
+	t.get = get
+	return t
+}
+
+// Dial connects to the merchant at the relay address addr (or its name), pinning its certificate kept in certs.
+func (t *RelayTransport) Dial(addr string) (io.ReadWriteCloser, error) {
+	if _, _, ok := splitRelayAddr(addr); !ok {
+		addr = fmt.Sprintf("%s@%s", addr, t.relayAddr)
+	}
+	cert, err := t.certs.Read(store.Role_Merchant, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialRelay(t.certs, addr, relayPayment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run TLS with the merchant. (Its host name is the relay's: its certificate is checked against the attested one)
+	config := t.config.Clone()
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 || store.CertificateFingerprint(state.PeerCertificates[0].Raw) != cert.Fingerprint {
+			log.Printf("== ALERT: relayed certificate of %s isn't the attested one", addr)
+			return fmt.Errorf("certificate of %s isn't the attested one", addr)
+		}
+		return nil
+	}
+	session := tls.Client(conn, config)
+	if err := session.Handshake(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// Listen registers the merchant at the relay. (See Register)
+func (t *RelayTransport) Listen() (PaymentListener, error) {
+	if t.own == nil {
+		return nil, fmt.Errorf("no merchant registered at relay %s", t.relayAddr)
+	}
+
+	// Load TLS client configuration, with the merchant's certificate.
+	config, err := relayConfig(t.certs, t.relayAddr)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(t.own.Cert, t.own.Key)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{cert}
+
+	l := &relayListener{transport: t, config: config}
+	if err := l.register(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// register registers the merchant at the relay.
+func (l *relayListener) register() error {
+	t := l.transport
+	conn, err := tls.Dial("tcp", hostPort(t.relayAddr, relayPort), l.config)
+	if err != nil {
+		return err
+	}
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// SEND relay request.
+	if err := encoder.Encode(relayRequest{Operation: relayRegister, Name: t.name}); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to encode Relay request message: %v", err)
+	}
+
+	// RECV acceptance.
+	var accept bool
+	if err := decoder.Decode(&accept); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to decode Relay acceptance message: %v", err)
+	}
+	if !accept {
+		conn.Close()
+		return fmt.Errorf("relay %s refused the name %s", t.relayAddr, t.name)
+	}
+
+	// Keep the registration for Accept and Close.
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		conn.Close()
+		return net.ErrClosed
+	}
+	l.conn = conn
+	l.decoder = decoder
+	log.Printf("Payment server registered at relay %s as %s@%s", t.relayAddr, t.name, t.relayAddr)
+	return nil
+}
+
+// Accept joins the next payment session announced by the relay. Registers again if the relay is lost.
+func (l *relayListener) Accept() (io.ReadWriteCloser, error) {
+	t := l.transport
+	for {
+		// RECV session.
+		var session relaySession
+		if err := l.decoder.Decode(&session); err != nil {
+			if l.isClosed() {
+				return nil, net.ErrClosed
+			}
+			log.Printf("lost registration at relay %s: %v", t.relayAddr, err)
+			for {
+				time.Sleep(relayRetry)
+				err := l.register()
+				if errors.Is(err, net.ErrClosed) {
+					return nil, err
+				} else if err == nil {
+					break
+				}
+				log.Printf("failed to register at relay %s: %v", t.relayAddr, err)
+			}
+			continue
+		}
+
+		// Join it.
+		conn, err := tls.Dial("tcp", hostPort(t.relayAddr, relayPort), l.config)
+		if err != nil {
+			log.Printf("failed to join relayed session: %v", err)
+			continue
+		}
+
+		// SEND relay request.
+		if err := gob.NewEncoder(conn).Encode(relayRequest{Operation: relayAccept, Session: session.Session}); err != nil {
+			log.Printf("failed to encode Relay request message: %v", err)
+			conn.Close()
+			continue
+		}
+
+		switch session.Service {
+		case relayPayment:
+			return tls.Server(conn, t.config), nil
+		case relayGet:
+			if t.get != nil {
+				go t.get.handleClient(conn)
+				continue
+			}
+		}
+		conn.Close()
+	}
+}
+
+// isClosed reports whether the listener is closed.
+func (l *relayListener) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+// Close.
+func (l *relayListener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	return l.conn.Close()
+}
+
+//
+// RENEWAL
+//
+
+// New.
+func (c *RenewalClient) New(serverAddr string, store *store.ClientStore, config *tls.Config) *RenewalClient {
+	c.serverAddr = serverAddr
+	c.store = store
+	c.config = config
+	return c
+}
+
+// Execute.
+func (c *RenewalClient) Execute() error {
+	// Trace protocol run.
+	trace := newTrace("RenewalClient")
+	defer trace.End()
+
+	// Connect to server.
+	conn, err := tls.Dial("tcp", hostPort(c.serverAddr, renewalPort), c.config)
 	if err != nil {
-		log.Printf("failed to create file: %v", err)
+		log.Fatalf("failed to connect to server at %s: %v", c.serverAddr, err)
 		return err
 	}
-	defer file.Close()
+	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
+	// Info message.
+	log.Print("Connected to Renewal server")
 
-	// RECV file.
-	_, err = io.Copy(file, reader)
+	trace.Phase(phaseStoreRead)
+	// Read Client.
+	client, err := c.store.ReadClient()
 	if err != nil {
-		log.Fatalf("failed to read file message: %v", err)
+		log.Fatalf("failed to read Client from database: %v", err)
+		return err
+	}
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV nonce.
+	var nonce []byte
+	if err := decoder.Decode(&nonce); err != nil {
+		log.Printf("failed to decode nonce message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Craft request, signed over the nonce.
+	request := client.SignRenewal(nonce)
+
+	trace.Phase(phaseEncode)
+	// SEND client profile.
+	clientProfile := client.Profile()
+	if err := encoder.Encode(*clientProfile); err != nil {
+		log.Fatalf("failed to encode ClientProfile message: %v", err)
+		return err
+	}
+
+	// SEND renewal request.
+	if err := encoder.Encode(request); err != nil {
+		log.Fatalf("failed to encode Renewal request message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV credentials from server.
+	var credentials struct {
+		Credential *big.Int
+		Contract   *big.Int
+		Expiration time.Time
+		Mints      []core.BankProfile
+	}
+	if err := decoder.Decode(&credentials); err != nil {
+		log.Fatalf("failed to decode Renewal response message: %v", err)
+		return err
+	}
+
+	trace.Phase(phaseCrypto)
+	// Replace credentials.
+	client.SetCredentials(credentials.Credential, credentials.Contract).SetExpiration(credentials.Expiration)
+
+	trace.Phase(phaseStoreWrite)
+	// Update Client into database.
+	if err := c.store.UpdateCredentials(client); err != nil {
+		log.Fatalf("failed to update Client into database: %v", err)
+		return err
+	}
+
+	// Write mint profiles into database.
+	if err := c.store.WriteMints(credentials.Mints); err != nil {
+		log.Fatalf("failed to write mints into database: %v", err)
 		return err
 	}
 
 	// Info message.
-	log.Printf("Get Success!")
+	log.Printf("Client: %s", client)
+	log.Printf("Renewal Success!")
 
 	return nil
 }
+
+//
+// THRESHOLD
+//
+
+// New.
+func (c *ThresholdClient) New(nodes []string, config *tls.Config) *ThresholdClient {
+	c.nodes = nodes
+	c.config = config
+	return c
+}
+
+// NewCoinResponse computes a coin response by combining the partial responses of the bank nodes. Nodes are contacted
+// in order until enough partial responses are collected.
+func (c *ThresholdClient) NewCoinResponse(bank *core.BankProfile, client *core.ClientInfo, ALower *big.Int, C *big.Int) (Expiration time.Time, A1 *big.Int, C1 *big.Int, err error) {
+	// Choose an expiration date for the coin.
+	Expiration = core.NewCoinExpiration(1)
+
+	// Craft request.
+	request := struct {
+		Expiration time.Time
+		ALower     *big.Int
+		C          *big.Int
+	}{
+		Expiration: Expiration,
+		ALower:     ALower,
+		C:          C,
+	}
+
+	var partials []core.PartialResponse
+	for _, node := range c.nodes {
+		partial, err := c.partialResponse(node, request)
+		if err != nil {
+			log.Printf("failed to get partial response from node %s: %v", node, err)
+			continue
+		}
+		partials = append(partials, *partial)
+
+		// Stop once the threshold is reached.
+		if len(partials) >= partial.Threshold {
+			break
+		}
+	}
+
+	// Combine.
+	A1, C1, err = bank.CombineCoinResponse(client, ALower, C, Expiration, partials)
+	return
+}
+
+// partialResponse sends request to node and returns its partial response.
+func (c *ThresholdClient) partialResponse(node string, request any) (*core.PartialResponse, error) {
+	// Connect to node.
+	conn, err := tls.Dial("tcp", hostPort(node, thresholdPort), c.config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// SEND coin request.
+	if err := encoder.Encode(request); err != nil {
+		return nil, err
+	}
+
+	// RECV partial response.
+	var partial core.PartialResponse
+	if err := decoder.Decode(&partial); err != nil {
+		return nil, err
+	}
+
+	return &partial, nil
+}
+
+//
+// AGENT
+//
+
+// New.
+func (c *AgentClient) New(socketPath string) *AgentClient {
+	c.socketPath = socketPath
+	return c
+}
+
+// Running reports whether the wallet agent is listening on the socket.
+func (c *AgentClient) Running() bool {
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Execute sends request to the wallet agent and returns its output.
+func (c *AgentClient) Execute(request *AgentRequest) (string, error) {
+	// Connect to agent.
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return "", fmt.Errorf("wallet agent isn't running: %w", err)
+	}
+	defer conn.Close()
+
+	encoder := gob.NewEncoder(conn)
+	decoder := newDecoder(conn)
+
+	// SEND request.
+	if err := encoder.Encode(*request); err != nil {
+		log.Printf("failed to encode Agent request message: %v", err)
+		return "", err
+	}
+
+	// RECV response.
+	var response agentResponse
+	if err := decoder.Decode(&response); err != nil {
+		log.Printf("failed to decode Agent response message: %v", err)
+		return "", err
+	}
+	if len(response.Error) > 0 {
+		return response.Output, fmt.Errorf("%s", response.Error)
+	}
+
+	return response.Output, nil
+}
@@ -1,41 +1,1095 @@
 package network
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"ziba/core"
+	"ziba/store"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Server ports.
+
+var (
+	setupPort       = 9090
+	accgenPort      = 9091
+	withdrawalPort  = 9092
+	paymentPort     = 9093
+	depositPort     = 9094
+	exchangePort    = 9095
+	getPort         = 9096
+	reclaimPort     = 9097
+	renewalPort     = 9098
+	thresholdPort   = 9099
+	changePort      = 9100
+	revocationPort  = 9101
+	adminPort       = 9102
+	attestationPort = 9103
+	reviewPort      = 9104
+	statusPort      = 9105
+	webSocketPort   = 9106
+	relayPort       = 9107
+	closurePort     = 9108
+	transferPort    = 9109
+)
+
+// PaymentWebSocketPath is the path of the payments taken over WebSocket, e.g. wss://merchant:9106/ziba/payment.
+const PaymentWebSocketPath = "/ziba/payment"
+
+//
+// TLS POLICY
+//
+
+// 1. A deployment chooses the lowest TLS version and the TLS 1.2 cipher suites it accepts. (TLS 1.3 suites are fixed)
+// 2. Clients check the server's certificate against the dialed host, unless a server name is set, trusting the
+//		bank's certificate and, optionally, the system's root CAs. (For banks using certificates issued by a CA)
+
+// TLSPolicy.
+type TLSPolicy struct {
+	MinVersion   uint16
+	CipherSuites []uint16
+	ServerName   string
+	SystemRoots  bool
+}
+
+// Policy is the TLS policy of the Get*TLSConfig functions. By default only the ECDSA suites matching the certificates
+// of CreateCertificate are accepted.
+var Policy = TLSPolicy{
+	MinVersion: tls.VersionTLS12,
+	CipherSuites: []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	},
+}
+
+// ParseTLSVersion returns the TLS version named name, "1.2" or "1.3".
+func ParseTLSVersion(name string) (uint16, error) {
+	switch name {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version: %q", name)
+}
+
+// ParseCipherSuites returns the cipher suites named names, as named by tls.CipherSuites, or nil (Go's defaults) if
+// names is empty. Insecure suites are not accepted.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, found := ids[name]
+		if !found {
+			return nil, fmt.Errorf("unknown or insecure cipher suite: %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// rootCAs returns the pool of root CAs trusted by clients: the certificates at certPaths, and the system's root CAs
+// if the policy says so. An empty certificate file is that of a bank using a publicly trusted certificate, the
+// system's root CAs are trusted then.
+func (policy *TLSPolicy) rootCAs(certPaths ...string) (*x509.CertPool, error) {
+	certs := make([][]byte, len(certPaths))
+	for i, certPath := range certPaths {
+		cert, err := os.ReadFile(certPath)
+		if err != nil {
+			log.Printf("failed to read certificate: %v", err)
+			return nil, err
+		}
+		certs[i] = cert
+	}
+	return policy.rootPool(certPaths, certs)
+}
+
+// rootPool is like rootCAs, but trusts the PEM encoded certificates certs, named by names.
+func (policy *TLSPolicy) rootPool(names []string, certs [][]byte) (*x509.CertPool, error) {
+	systemRoots := policy.SystemRoots
+	for _, cert := range certs {
+		systemRoots = systemRoots || len(cert) == 0
+	}
+
+	certPool := x509.NewCertPool()
+	if systemRoots {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		certPool = systemPool
+	}
+
+	for i, cert := range certs {
+		if len(cert) > 0 && !certPool.AppendCertsFromPEM(cert) {
+			return nil, fmt.Errorf("failed to append cert to pool: %s", names[i])
+		}
+	}
+	return certPool, nil
+}
+
+// hostPort returns the address of port at host. (IPv6 hosts are bracketed)
+func hostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+//
+// STRICT DECODING
+//
+
+// 1. gob leaves nil the numbers a peer omits, and a nil *big.Int crashes the first computation it reaches. Every
+//		message is received through a strictDecoder, which rejects it at decode time if a required number is missing,
+//		or longer than any modulus (core.MaxModulusBits) and only good to waste CPU in Exp. (See core.CheckMessage)
+// 2. The optional numbers are tagged `ziba:"optional"`, the optional structures are pointers. Ranges and subgroup
+//		membership are left to core's validation.
+// 3. A missing number is the peer's fault: servers drop the session, they don't exit.
+
+// strictDecoder is a gob decoder rejecting the messages missing a required number, or holding an oversized one.
+type strictDecoder struct {
+	*gob.Decoder
+}
+
+// newDecoder returns a strictDecoder reading from r.
+func newDecoder(r io.Reader) *strictDecoder {
+	return &strictDecoder{Decoder: gob.NewDecoder(r)}
+}
+
+// Decode decodes the next message into e, a pointer, and checks it for missing and oversized numbers.
+func (d *strictDecoder) Decode(e any) error {
+	if err := d.Decoder.Decode(e); err != nil {
+		return err
+	}
+	return core.CheckMessage(e)
+}
+
+//
+// TRACING
+//
+
+// 1. Each protocol run, on the server and client sides, is traced as a span named after the server or client.
+// 2. Its phases (decode, store read, crypto, store write, encode) are traced as child spans, so that a slow run shows
+//		where its time is spent.
+// 3. Spans are exported over OTLP when tracing is enabled (see EnableTracing), and discarded otherwise.
+// 4. The runs of the servers, with their duration and payload sizes, are recorded in the bank's operation log when
+//		it's enabled (see EnableOperationLog). They're written in batches, in the background, and dropped if it lags.
+
+// Protocol phases.
+const (
+	phaseDecode     = "decode"
+	phaseStoreRead  = "store read"
+	phaseCrypto     = "crypto"
+	phaseStoreWrite = "store write"
+	phaseEncode     = "encode"
+)
+
+// tracer traces protocol runs. (A no-op until tracing is enabled)
+var tracer = otel.Tracer("ziba/network")
+
+// EnableTracing exports spans over OTLP/HTTP to endpoint (host:port), as service. The returned function flushes the
+// remaining spans.
+func EnableTracing(ctx context.Context, endpoint, service string, insecure bool) (func(context.Context) error, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// operationLog receives the protocol runs to record. (Nil until the operation log is enabled)
+var operationLog chan store.OperationRun
+
+// Operation log.
+const (
+	operationQueue = 1024 // Runs waiting to be written, dropped beyond.
+	operationBatch = 256  // Runs written at once.
+)
+
+// EnableOperationLog records the protocol runs served from now on in the operation log of bankStore, keeping those of
+// the last retention. To be called before the servers start.
+func EnableOperationLog(bankStore *store.BankStore, retention time.Duration) {
+	operationLog = make(chan store.OperationRun, operationQueue)
+	go func() {
+		for run := range operationLog {
+			runs := []store.OperationRun{run}
+			for len(runs) < operationBatch && len(operationLog) > 0 {
+				runs = append(runs, <-operationLog)
+			}
+			if err := bankStore.WriteOperations(runs, retention); err != nil {
+				log.Printf("failed to write operation log: %v", err)
+			}
+		}
+	}()
+}
+
+// protocolTrace traces the phases of a protocol run.
+type protocolTrace struct {
+	ctx   context.Context
+	root  trace.Span
+	phase trace.Span
+	name  string
+	start time.Time
+	conn  *measuredConn
+}
+
+// measuredConn counts the bytes read from and written to a connection.
+type measuredConn struct {
+	net.Conn
+	received int64
+	sent     int64
+}
+
+// Read.
+func (c *measuredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received += int64(n)
+	return n, err
+}
+
+// Write.
+func (c *measuredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent += int64(n)
+	return n, err
+}
+
+// newTrace starts tracing a protocol run, named name.
+func newTrace(name string) *protocolTrace {
+	ctx, root := tracer.Start(context.Background(), name)
+	return &protocolTrace{ctx: ctx, root: root, name: name, start: time.Now()}
+}
+
+// Measure returns conn, counting the payload of the protocol run.
+func (t *protocolTrace) Measure(conn net.Conn) net.Conn {
+	t.conn = &measuredConn{Conn: conn}
+	return t.conn
+}
+
+// Phase ends the current phase, if any, and starts the next one, named name.
+func (t *protocolTrace) Phase(name string) {
+	if t.phase != nil {
+		t.phase.End()
+	}
+	_, t.phase = tracer.Start(t.ctx, name)
+}
+
+// End ends the current phase and the protocol run.
+func (t *protocolTrace) End() {
+	if t.phase != nil {
+		t.phase.End()
+	}
+	t.root.End()
+
+	// Record the run, if measured.
+	if operationLog == nil || t.conn == nil {
+		return
+	}
+	run := store.OperationRun{
+		Name:     t.name,
+		Duration: time.Since(t.start),
+		Received: t.conn.received,
+		Sent:     t.conn.sent,
+		Date:     t.start,
+	}
+	select {
+	case operationLog <- run:
+	default:
+	}
+}
+
+//
+// TRANSCRIPTS
+//
+
+// 1. The messages of the payment sessions are recorded, when enabled (see EnableTranscripts), on both sides, in a file
+//		per session: one JSON TranscriptEntry per line, in order. The mint is noted along, for the verifications.
+// 2. Secrets are redacted, replaced by their SHA-256 digest: the Elgamal's second, which spends the coin.
+// 3. ReplayTranscript re-runs the verifications of a session from its transcript, to diagnose interop failures
+//		between implementations. The signature of the redacted second can't be checked.
+
+// Transcript directions.
+const (
+	TranscriptSend = "SEND"
+	TranscriptRecv = "RECV"
+	TranscriptNote = "NOTE" // Local values of the verifications.
 )
 
-// Server ports.
+// transcriptDir is the directory of the transcripts. (Empty until transcripts are enabled)
+var transcriptDir string
+
+// EnableTranscripts records the payment sessions run from now on at dir, a file per session.
+func EnableTranscripts(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	transcriptDir = dir
+	return nil
+}
+
+// TranscriptEntry is a message of a recorded session.
+type TranscriptEntry struct {
+	Time      time.Time
+	Role      string // Recording side, e.g. PaymentServer.
+	Direction string
+	Message   string
+	Data      json.RawMessage
+}
+
+// transcript records the messages of a session. (A no-op if nil)
+type transcript struct {
+	role    string
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// redacted is a secret, as recorded in transcripts.
+type redacted struct {
+	Redacted string // SHA-256 digest, hexadecimal.
+}
+
+// redact returns the redacted secret x.
+func redact(x *big.Int) redacted {
+	if x == nil {
+		return redacted{}
+	}
+	digest := sha256.Sum256(x.Bytes())
+	return redacted{Redacted: hex.EncodeToString(digest[:])}
+}
+
+// newTranscript returns the transcript of a session of role, nil if transcripts aren't enabled.
+func newTranscript(role string) *transcript {
+	if len(transcriptDir) == 0 {
+		return nil
+	}
+	id, err := newToken()
+	if err != nil {
+		log.Printf("failed to create transcript: %v", err)
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s-%s.jsonl", time.Now().Format("20060102-150405"), role, id[:8])
+	file, err := os.OpenFile(filepath.Join(transcriptDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Printf("failed to create transcript: %v", err)
+		return nil
+	}
+	return &transcript{role: role, file: file, encoder: json.NewEncoder(file)}
+}
+
+// Record records message, data, in direction.
+func (t *transcript) Record(direction, message string, data any) {
+	if t == nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("failed to record %s message: %v", message, err)
+		return
+	}
+	entry := TranscriptEntry{Time: time.Now(), Role: t.role, Direction: direction, Message: message, Data: encoded}
+	if err := t.encoder.Encode(entry); err != nil {
+		log.Printf("failed to record %s message: %v", message, err)
+	}
+}
+
+// Close.
+func (t *transcript) Close() {
+	if t == nil {
+		return
+	}
+	if err := t.file.Close(); err != nil {
+		log.Printf("failed to close transcript: %v", err)
+	}
+}
+
+// paymentRequest is the escrow request of a payment.
+type paymentRequest struct {
+	Escrow *core.Escrow
+	Memo   string
+	Change *core.Change
+	Token  string // Idempotency token. (See PAYMENT SESSIONS)
+}
+
+// paymentStamp is the merchant's stamp of a payment.
+type paymentStamp struct {
+	Msg    *big.Int
+	Escrow *core.Escrow
+	Memo   *core.Memo
+}
+
+// ReadTranscript reads the transcript at path.
+func ReadTranscript(path string) ([]TranscriptEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []TranscriptEntry
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var entry TranscriptEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ReplayTranscript re-runs the verifications of the payment session of entries, reporting each step to out. Returns
+// the number of failed steps.
+func ReplayTranscript(entries []TranscriptEntry, out io.Writer) int {
+	failed := 0
+	report := func(step string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "FAILED   %s: %v\n", step, err)
+			return
+		}
+		fmt.Fprintf(out, "OK       %s\n", step)
+	}
+	skip := func(step, reason string) {
+		fmt.Fprintf(out, "SKIPPED  %s: %s\n", step, reason)
+	}
+
+	// Index the messages.
+	messages := make(map[string]*TranscriptEntry)
+	for i := range entries {
+		messages[entries[i].Message] = &entries[i]
+	}
+	decode := func(message string, data any) bool {
+		entry, ok := messages[message]
+		if !ok {
+			skip(message, "not recorded, the session ended before it")
+			return false
+		}
+		if err := json.Unmarshal(entry.Data, data); err != nil {
+			report(message, fmt.Errorf("malformed message: %v", err))
+			return false
+		}
+		return true
+	}
+
+	// The mint and the coin.
+	mint := new(core.BankProfile)
+	if !decode("Mint", mint) {
+		return failed
+	}
+	coin := new(core.CoinProfile)
+	if !decode("CoinProfile", coin) {
+		return failed
+	}
+	report("CoinProfile is well formed", mint.ValidateCoin(coin))
+	if !coin.VerifyProperties(mint) {
+		report("CoinProfile is signed by the bank", fmt.Errorf("invalid signature (currency %q, value %d)", coin.Currency, coin.Value))
+	} else {
+		report("CoinProfile is signed by the bank", nil)
+	}
+	report("CoinProfile is unexpired", core.CheckUnexpired(coin.Expiration, messages["CoinProfile"].Time))
+
+	// The escrow request.
+	var request paymentRequest
+	if !decode("Request", &request) {
+		return failed
+	}
+	if request.Escrow != nil && (len(request.Memo) > 0 || request.Change != nil) {
+		report("Request", fmt.Errorf("escrowed payments can't carry a memo nor be partial"))
+	}
+	if request.Change != nil {
+		err := mint.ValidateChange(request.Change)
+		if err == nil {
+			err = request.Change.Verify(coin)
+		}
+		report("Change is valid", err)
+	}
+
+	// The stamp.
+	var stamp paymentStamp
+	if !decode("Stamp", &stamp) {
+		return failed
+	}
+	switch {
+	case stamp.Msg == nil:
+		report("Stamp", fmt.Errorf("no message"))
+	case request.Escrow != nil:
+		var err error
+		if stamp.Escrow == nil || stamp.Escrow.Release.Cmp(request.Escrow.Release) != 0 ||
+			stamp.Escrow.Refund.Cmp(request.Escrow.Refund) != 0 || !stamp.Escrow.Timeout.Equal(request.Escrow.Timeout) {
+			err = fmt.Errorf("escrow conditions altered")
+		} else if stamp.Msg.Cmp(stamp.Escrow.Msg(coin)) != 0 {
+			err = fmt.Errorf("message isn't the escrow's")
+		}
+		report("Stamp binds the escrow conditions", err)
+	default:
+		var err error
+		if stamp.Memo == nil || stamp.Memo.Text != request.Memo {
+			err = fmt.Errorf("memo altered")
+		} else if stamp.Msg.Cmp(stamp.Memo.Msg(coin)) != 0 {
+			err = fmt.Errorf("message isn't the memo's")
+		} else if request.Change != nil && (stamp.Memo.Change == nil || stamp.Memo.Change.Amount != request.Change.Amount ||
+			stamp.Memo.Change.ALower.Cmp(request.Change.ALower) != 0 || stamp.Memo.Change.C.Cmp(request.Change.C) != 0 ||
+			stamp.Memo.Change.Claim.Cmp(request.Change.Claim) != 0) {
+			err = fmt.Errorf("change altered")
+		}
+		report("Stamp binds the memo", err)
+	}
+
+	// The signature, and its acceptance.
+	var second redacted
+	if !decode("Second", &second) {
+		return failed
+	}
+	skip("Second is the coin's signature of the stamp", "redacted")
+	var accept bool
+	if !decode("Acceptance", &accept) {
+		return failed
+	}
+	if !accept {
+		report("Acceptance", fmt.Errorf("refused by the merchant"))
+	} else {
+		report("Acceptance", nil)
+	}
+	return failed
+}
+
+//
+// CRYPTO POOL
+//
+
+// 1. The servers compute their coin responses, the bank's modular exponentiations, on a bounded number of workers:
+//		the handlers of a burst of operations wait for a worker in a queue instead of thrashing the CPU.
+// 2. A handler finding the queue full is refused right away, its client tries again later.
+// 3. The workers, queue depth and waits are reported to the bank's admin. (See AdminPool)
+// 4. In threshold mode the coin responses are computed by the bank nodes, not on the pool.
+
+// DefaultCryptoQueue is the number of handlers waiting for a worker at most, unless configured.
+const DefaultCryptoQueue = 256
+
+// cryptoPool bounds the coin responses computed at once.
+type cryptoPool struct {
+	slots   chan struct{} // One per worker, held while computing.
+	depth   int64         // Handlers waiting at most.
+	queued  atomic.Int64
+	peak    atomic.Int64
+	served  atomic.Int64
+	refused atomic.Int64
+	waited  atomic.Int64 // Total wait of the served handlers, in nanoseconds.
+}
+
+// CryptoPoolStats are the statistics of the crypto pool, as returned by CryptoStats.
+type CryptoPoolStats struct {
+	Workers int
+	Busy    int   // Workers computing.
+	Depth   int64 // Handlers waiting at most.
+	Queued  int64 // Handlers waiting.
+	Peak    int64 // Most handlers waiting at once.
+	Served  int64
+	Refused int64         // Handlers refused, the queue being full.
+	Wait    time.Duration // Average wait of the served handlers.
+}
+
+// pool computes the servers' coin responses.
+var pool = new(cryptoPool).New(runtime.NumCPU(), DefaultCryptoQueue)
+
+// ConfigureCryptoPool makes the servers compute their coin responses on workers, with up to depth handlers waiting
+// for one. To be called before the servers start.
+func ConfigureCryptoPool(workers, depth int) {
+	pool = new(cryptoPool).New(workers, depth)
+}
+
+// CryptoStats returns the statistics of the crypto pool since the servers started.
+func CryptoStats() CryptoPoolStats {
+	stats := CryptoPoolStats{
+		Workers: cap(pool.slots),
+		Busy:    len(pool.slots),
+		Depth:   pool.depth,
+		Queued:  pool.queued.Load(),
+		Peak:    pool.peak.Load(),
+		Served:  pool.served.Load(),
+		Refused: pool.refused.Load(),
+	}
+	if stats.Served > 0 {
+		stats.Wait = time.Duration(pool.waited.Load() / stats.Served)
+	}
+	return stats
+}
+
+// New.
+func (p *cryptoPool) New(workers, depth int) *cryptoPool {
+	p.slots = make(chan struct{}, max(workers, 1))
+	p.depth = int64(max(depth, 0))
+	return p
+}
+
+// run runs f on a worker, once one is free. Returns an error without running f if the queue is full.
+func (p *cryptoPool) run(f func()) error {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if err := p.wait(); err != nil {
+			return err
+		}
+	}
+	defer func() { <-p.slots }()
+	p.served.Add(1)
+
+	f()
+	return nil
+}
+
+// wait waits in the queue for a worker, and holds it. Returns an error if the queue is full.
+func (p *cryptoPool) wait() error {
+	queued := p.queued.Add(1)
+	if queued > p.depth {
+		p.queued.Add(-1)
+		p.refused.Add(1)
+		return fmt.Errorf("bank is busy, %d operations waiting", p.depth)
+	}
+	for {
+		peak := p.peak.Load()
+		if queued <= peak || p.peak.CompareAndSwap(peak, queued) {
+			break
+		}
+	}
+
+	start := time.Now()
+	p.slots <- struct{}{}
+	p.queued.Add(-1)
+	p.waited.Add(int64(time.Since(start)))
+	return nil
+}
+
+//
+// PAYMENT TRANSPORTS
+//
+
+// 1. The payment protocol only reads and writes a stream: PaymentClient and PaymentServer run it over a
+//		PaymentTransport, TLSTransport unless set. Integrators supply their own for other links, e.g. Bluetooth.
+// 2. A bridge authenticates the merchant itself (the receipts only record the certificates of TLS). Its payers
+//		share the auto-accept limit of "bridge", unless its connections are net.Conns, addressed by payer.
+// 3. The streams of bridges have no deadlines: a payment stalls until the bridge closes its stream.
+
+// New.
+func (t *TLSTransport) New(config *tls.Config) *TLSTransport {
+	t.port = paymentPort
+	t.config = config
+	return t
+}
+
+// Dial.
+func (t *TLSTransport) Dial(addr string) (io.ReadWriteCloser, error) {
+	return tls.Dial("tcp", hostPort(addr, t.port), t.config)
+}
+
+// Listen.
+func (t *TLSTransport) Listen() (PaymentListener, error) {
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", t.port), t.config)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Payment server listening on port %d", t.port)
+	return tlsListener{listener}, nil
+}
+
+// tlsListener is the PaymentListener of TLSTransport.
+type tlsListener struct {
+	net.Listener
+}
+
+// Accept.
+func (l tlsListener) Accept() (io.ReadWriteCloser, error) {
+	return l.Listener.Accept()
+}
+
+// bridgeAddr is the address of the peers of bridges.
+type bridgeAddr struct{}
+
+// Network.
+func (bridgeAddr) Network() string { return "bridge" }
+
+// String.
+func (bridgeAddr) String() string { return "bridge" }
+
+// bridgedConn is a stream of a bridge, as a net.Conn without addresses nor deadlines.
+type bridgedConn struct {
+	io.ReadWriteCloser
+}
+
+// LocalAddr.
+func (bridgedConn) LocalAddr() net.Addr { return bridgeAddr{} }
+
+// RemoteAddr.
+func (bridgedConn) RemoteAddr() net.Addr { return bridgeAddr{} }
+
+// SetDeadline.
+func (bridgedConn) SetDeadline(time.Time) error { return nil }
+
+// SetReadDeadline.
+func (bridgedConn) SetReadDeadline(time.Time) error { return nil }
+
+// SetWriteDeadline.
+func (bridgedConn) SetWriteDeadline(time.Time) error { return nil }
+
+// streamConn returns stream as a net.Conn: itself if it is one, a bridgedConn otherwise.
+func streamConn(stream io.ReadWriteCloser) net.Conn {
+	if conn, ok := stream.(net.Conn); ok {
+		return conn
+	}
+	return bridgedConn{stream}
+}
+
+//
+// PAYMENT SESSIONS
+//
+
+// 1. A payment is a state machine, persisted at each step by idempotency token on both sides (see
+//		store.PaymentSession): Started (the coin is offered), Stamped, Signed (the signature is kept before it's sent)
+//		and Accepted.
+// 2. A payment cut short before it's signed is aborted: its session is dropped, its coin was only reserved. A signed
+//		one can't be, the merchant may hold the signature: its coin is out of the wallet, and the payment is resumed (see
+//		ResumeClient) with the same token, coin and request. The merchant answers with the stamp it recorded, and the
+//		payer sends the same signature again. A new stamp is never signed: two signatures of a coin reveal its owner.
+// 3. The merchant keeps its sessions for a week, and accepts a resumed payment it received already without receiving
+//		the coin twice.
+// 4. Withdrawals are resumed by their own idempotency token. (See StatusClient)
+
+// paymentSession is the protocol's state of a payment, persisted as store.PaymentSession.Data.
+type paymentSession struct {
+	Request paymentRequest
+	Stamp   paymentStamp
+
+	// Payer's side.
+	Coin      *core.Coin
+	Second    *big.Int
+	Release   *big.Int // Escrow's secrets.
+	Refund    *big.Int
+	Remainder *core.Coin // Change's coin request and secret.
+	Claim     *big.Int
+}
+
+// paymentMachine is the state machine of a payment, on the payer's or the merchant's side.
+type paymentMachine struct {
+	store   *store.ClientStore
+	record  store.PaymentSession
+	session paymentSession
+}
+
+// loadPaymentMachine returns the state machine of the payment session record, of clientStore.
+func loadPaymentMachine(clientStore *store.ClientStore, record *store.PaymentSession) (*paymentMachine, error) {
+	m := &paymentMachine{store: clientStore, record: *record}
+	if err := gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&m.session); err != nil {
+		return nil, fmt.Errorf("malformed payment session %s: %v", record.Token, err)
+	}
+	return m, nil
+}
+
+// advance moves the payment to state, persisting its session. Payments don't go back.
+func (m *paymentMachine) advance(state store.State_Type) error {
+	if m.record.Data != nil && state < m.record.State {
+		return fmt.Errorf("payment %s can't go back from %s to %s", m.record.Token, m.record.State, state)
+	}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(m.session); err != nil {
+		return err
+	}
+	m.record.State = state
+	m.record.Data = data.Bytes()
+	return m.store.WritePaymentSession(&m.record)
+}
+
+// end ends the payment, deleting its session.
+func (m *paymentMachine) end() error {
+	return m.store.DeletePaymentSession(m.record.Token, m.record.Payee)
+}
+
+// finishPayment keeps what's left of the payment of machine once accepted by the merchant over conn: the escrowed coin,
+// the change to collect or the receipt. The paid coin is deleted, and the payment ended.
+func finishPayment(clientStore *store.ClientStore, machine *paymentMachine, conn io.ReadWriteCloser) error {
+	session := &machine.session
+	coin := session.Coin
+
+	// Keep escrowed Coin until released or reclaimed.
+	if escrow := session.Stamp.Escrow; escrow != nil {
+		if err := clientStore.WriteEscrow(coin, escrow, session.Release, session.Refund); err != nil {
+			log.Fatalf("failed to write escrow into database: %v", err)
+		}
+		log.Printf("Escrowed coin %d until %s", coin.Profile().Hash(), escrow.Timeout)
+		log.Printf("Release secret: %s", session.Release)
+		return machine.end()
+	}
+
+	// Keep remainder coin request until collected.
+	if session.Remainder != nil {
+		if err := clientStore.WriteChange(session.Remainder, session.Claim); err != nil {
+			log.Fatalf("failed to write change into database: %v", err)
+		}
+		log.Printf("Change of %d to collect", session.Remainder.Params.Value)
+	}
+
+	// Keep a receipt of the payment.
+	if session.Stamp.Memo != nil {
+		receipt := &store.Receipt{
+			Coin:     *coin,
+			Merchant: machine.record.Peer,
+			Memo:     *session.Stamp.Memo,
+			Date:     time.Now(),
+		}
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+				receipt.Certificate = store.CertificateFingerprint(peers[0].Raw)
+			}
+		}
+		if err := clientStore.WriteReceipt(receipt); err != nil {
+			log.Printf("failed to write receipt into database: %v", err)
+		}
+	}
+
+	// Delete Coin after payment.
+	if err := clientStore.DeleteCoin(coin, store.Operation_Payment); err != nil {
+		log.Fatalf("failed to delete coin from database: %v", err)
+	}
+	return machine.end()
+}
+
+//
+// RELAY
+//
+
+// 1. A merchant behind NAT registers its name at a relay (see RelayServer) over an outbound connection, and is paid at
+//		the relay address name@relay. The relay announces each payer's session over the registration, the merchant
+//		joins it over a new outbound connection, and the relay splices the two.
+// 2. The sessions are encrypted end to end: the payer runs TLS with the merchant inside the relayed stream, pinning the
+//		merchant's certificate attested by the bank. (The relay isn't trusted with payments)
+// 3. A name belongs to the certificate that first registered it, for the relay's lifetime.
+// 4. The relayed payers share the auto-accept limit of the relay's address.
+
+// Relay timeouts.
+const (
+	relayTimeout = 10 * time.Second // For the merchant to join a session.
+	relayRetry   = 5 * time.Second  // Between registrations, once the relay is lost.
+)
+
+// Relay operations.
+const (
+	relayRegister = iota // A merchant registers its name, and receives its sessions.
+	relayConnect         // A payer connects to a registered merchant.
+	relayAccept          // The merchant joins a session.
+)
+
+// Relayed services.
+const (
+	relayPayment = iota
+	relayGet
+)
+
+// relayRequest opens a connection to a RelayServer.
+type relayRequest struct {
+	Operation int
+	Name      string // Merchant's name. (Register, Connect)
+	Service   int    // (Connect)
+	Session   string // (Accept)
+}
+
+// relaySession announces a payer's session to its merchant.
+type relaySession struct {
+	Session string
+	Service int
+}
+
+// splitRelayAddr splits the relay address addr (name@relay) into the merchant's name and the relay's address.
+func splitRelayAddr(addr string) (name, relayAddr string, ok bool) {
+	return strings.Cut(addr, "@")
+}
+
+// relayConfig returns the TLS client configuration of the relay at relayAddr, trusted by its bank certificate in certs.
+func relayConfig(certs *store.Certificates, relayAddr string) (*tls.Config, error) {
+	return GetStoredClientTLSConfig(certs, store.Role_Bank, relayAddr)
+}
+
+// dialRelay connects to service of the merchant at the relay address addr, and returns the relayed stream.
+func dialRelay(certs *store.Certificates, addr string, service int) (net.Conn, error) {
+	name, relayAddr, _ := splitRelayAddr(addr)
+	config, err := relayConfig(certs, relayAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", hostPort(relayAddr, relayPort), config)
+	if err != nil {
+		return nil, err
+	}
+
+	// SEND relay request.
+	if err := gob.NewEncoder(conn).Encode(relayRequest{Operation: relayConnect, Name: name, Service: service}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to encode Relay request message: %v", err)
+	}
+
+	// RECV acceptance. (Nothing else is relayed until the payer writes)
+	var accept bool
+	if err := newDecoder(conn).Decode(&accept); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to decode Relay acceptance message: %v", err)
+	}
+	if !accept {
+		conn.Close()
+		return nil, fmt.Errorf("merchant %s isn't reachable at relay %s", name, relayAddr)
+	}
+	return conn, nil
+}
+
+// splice copies between a and b until either side ends, then closes both.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+//
+// ACME
+//
+
+// 1. The bank obtains publicly trusted certificates for its domains from an ACME CA (Let's Encrypt), and renews them
+//		before they expire, answering the CA's HTTP challenges. (See ServeACMEChallenges)
+// 2. The Setup server sends no certificate, clients trust the system's root CAs and check the bank's domain.
+
+// GetACMEServerTLSConfig is like GetServerTLSConfig, but with certificates for domains obtained and renewed through
+// ACME, cached at cacheDir. Returns the certificate manager along with the configuration.
+func GetACMEServerTLSConfig(domains []string, email, cacheDir string) (*tls.Config, *autocert.Manager) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+
+	// Set TLS configuration.
+	config := manager.TLSConfig()
+	config.MinVersion = Policy.MinVersion
+	config.CipherSuites = Policy.CipherSuites
+
+	return config, manager
+}
+
+// ServeACMEChallenges answers the HTTP challenges of manager's CA at addr. (Port 80 for Let's Encrypt)
+func ServeACMEChallenges(addr string, manager *autocert.Manager) error {
+	log.Printf("ACME challenge server listening on %s", addr)
+	return http.ListenAndServe(addr, manager.HTTPHandler(nil))
+}
+
+// VerifyReceipt verifies that receipt wasn't tampered with: its coin matches its hash and is signed by mint, the
+// merchant's stamp is the coin's signed message, and the merchant's certificate cert is the one of the payment.
+func VerifyReceipt(receipt *store.Receipt, mint *core.BankProfile, cert *store.Certificate) error {
+	coin := receipt.Coin.Profile()
+	if coin.Hash() != receipt.Hash {
+		return fmt.Errorf("coin doesn't match its hash %d", receipt.Hash)
+	}
+	if err := mint.ValidateCoin(coin); err != nil {
+		return fmt.Errorf("invalid coin: %w", err)
+	}
+	if !coin.VerifyProperties(mint) {
+		return fmt.Errorf("coin isn't signed by the bank")
+	}
+	if coin.Msg == nil || receipt.Memo.Payee == nil || coin.Msg.Cmp(receipt.Memo.Msg(coin)) != 0 {
+		return fmt.Errorf("merchant's stamp isn't the coin's message")
+	}
+	if coin.Second == nil || !coin.VerifyElgamal(mint, coin.Second) {
+		return fmt.Errorf("coin's signature of the stamp is invalid")
+	}
+	if cert.Fingerprint != receipt.Certificate {
+		return fmt.Errorf("merchant's certificate changed since the payment")
+	}
+	return nil
+}
+
+// CreateCertificate creates a self-signed certificate valid for localhost and hosts, IP addresses or DNS names, and
+// saves it along with its key in the Ziba directory at baseDir, under baseName.
+func CreateCertificate(baseDir string, baseName string, hosts ...string) error {
+	cert, key, err := GenerateCertificate(hosts...)
+	if err != nil {
+		return err
+	}
+
+	// Save certificate to file.
+	if err := store.WriteFileAtomic(store.CertPath(baseDir, baseName), cert, 0644); err != nil { // rw- r-- r--
+		log.Fatalf("failed to create cert.pem: %v", err)
+		return err
+	}
 
-var (
-	setupPort      = 9090
-	accgenPort     = 9091
-	withdrawalPort = 9092
-	paymentPort    = 9093
-	depositPort    = 9094
-	exchangePort   = 9095
-	getPort        = 9096
-)
+	// Save private key to file.
+	if err := store.WriteFileAtomic(store.KeyPath(baseDir, baseName), key, 0600); err != nil { // rw- --- ---
+		log.Fatalf("failed to create key.pem: %v", err)
+		return err
+	}
+
+	return nil
+}
 
-// CreateCertificate.
-func CreateCertificate(baseDir string, baseName string) error {
+// GenerateCertificate returns a self-signed certificate valid for localhost and hosts, IP addresses or DNS names, and
+// its private key, PEM encoded.
+func GenerateCertificate(hosts ...string) ([]byte, []byte, error) {
 	// Generate private key.
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		log.Fatalf("failed to create private key: %v", err)
-		return err
+		return nil, nil, err
 	}
 
 	// Use certificate template.
@@ -54,55 +1108,52 @@ func CreateCertificate(baseDir string, baseName string) error {
 		DNSNames:              []string{"localhost"},
 	}
 
+	// Add subject alternative names.
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if len(host) > 0 {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
 	// Create certificate.
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
 	if err != nil {
 		log.Fatalf("failed to create certificate: %v", err)
-		return err
-	}
-
-	// Save certificate to file.
-	certFilename := fmt.Sprintf("%s_cert.pem", baseName)
-	certPath := filepath.Join(baseDir, certFilename)
-	certFile, err := os.Create(certPath)
-	if err != nil {
-		log.Fatalf("failed to create cert.pem: %v", err)
-		return err
-	}
-	defer certFile.Close()
-
-	// Encode DER bytes.
-	err = pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	if err != nil {
-		log.Fatalf("failed to encode certificate: %v", err)
-		return err
-	}
-
-	// Save private key to file.
-	keyFilename := fmt.Sprintf("%s_key.pem", baseName)
-	keyPath := filepath.Join(baseDir, keyFilename)
-	keyFile, err := os.Create(keyPath)
-	if err != nil {
-		log.Fatalf("failed to create key.pem")
-		return err
+		return nil, nil, err
 	}
-	defer keyFile.Close()
 
 	// Read private key as DER bytes.
 	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
 		log.Fatalf("failed to marshal private key: %v", err)
-		return err
+		return nil, nil, err
 	}
 
 	// Encode DER bytes.
-	err = pem.Encode(keyFile, &pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes})
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes})
+	return cert, key, nil
+}
+
+// GetStoredServerTLSConfig is like GetServerTLSConfig, but serves own, a certificate of Role_Own along with its key.
+func GetStoredServerTLSConfig(own *store.Certificate) (*tls.Config, error) {
+	// Load certificate and private key.
+	cert, err := tls.X509KeyPair(own.Cert, own.Key)
 	if err != nil {
-		log.Fatalf("failed to encode private key bytes: %v", err)
-		return err
+		log.Printf("failed to load certificate: %v", err)
+		return nil, err
 	}
 
-	return nil
+	// Set TLS configuration.
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   Policy.MinVersion,
+		CipherSuites: Policy.CipherSuites,
+	}
+
+	return config, nil
 }
 
 // GetServerTLSConfig.
@@ -117,38 +1168,663 @@ func GetServerTLSConfig(certPath, keyPath string) (*tls.Config, error) {
 	// Set TLS configuration.
 	config := &tls.Config{
 		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
+		MinVersion:   Policy.MinVersion,
+		CipherSuites: Policy.CipherSuites,
 	}
 
 	return config, nil
 }
 
+//
+// CERTIFICATES
+//
+
+// 1. The CertManager serves the certificate at its PEM files to TLS handshakes, through GetCertificate, so that a
+//		renewed certificate is used without restarting the listeners.
+// 2. The files are reloaded when modified (see Watch), or on demand, e.g. from a renewal hook. (See Reload)
+// 3. Expiry is warned about ahead of time, daily, until the certificate is renewed.
+
+// New loads the certificate at certPath and keyPath.
+func (m *CertManager) New(certPath, keyPath string) (*CertManager, error) {
+	m.certPath = certPath
+	m.keyPath = keyPath
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload loads the certificate files again. The current certificate is kept if they are invalid.
+func (m *CertManager) Reload() error {
+	modTime, err := m.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cert = &cert
+	m.modTime = modTime
+	return nil
+}
+
+// lastModified returns the latest modification time of the certificate files.
+func (m *CertManager) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, path := range []string{m.certPath, m.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// GetCertificate returns the current certificate, see tls.Config.
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.cert, nil
+}
+
+// Expiry returns the expiration time of the current certificate.
+func (m *CertManager) Expiry() time.Time {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.cert.Leaf == nil {
+		return time.Time{}
+	}
+	return m.cert.Leaf.NotAfter
+}
+
+// Watch checks the certificate files every interval, reloading them when modified, and warns when the certificate
+// expires within warning. Never returns.
+func (m *CertManager) Watch(interval, warning time.Duration) {
+	var warned time.Time
+	for ; ; time.Sleep(interval) {
+		m.mutex.RLock()
+		current := m.modTime
+		m.mutex.RUnlock()
+
+		// Reload modified files.
+		if modTime, err := m.lastModified(); err != nil {
+			log.Printf("failed to check certificate files: %v", err)
+		} else if modTime.After(current) {
+			if err := m.Reload(); err != nil {
+				log.Printf("failed to reload certificate: %v", err)
+			} else {
+				log.Printf("Certificate reloaded, expires %s", m.Expiry().Format(time.DateOnly))
+			}
+		}
+
+		// Warn before expiry.
+		if expiry := m.Expiry(); time.Until(expiry) < warning && time.Since(warned) >= 24*time.Hour {
+			warned = time.Now()
+			log.Printf("== WARNING: certificate at %s expires %s", m.certPath, expiry.Format(time.DateOnly))
+		}
+	}
+}
+
+// TLSConfig returns the server TLS configuration serving m's current certificate.
+func (m *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     Policy.MinVersion,
+		CipherSuites:   Policy.CipherSuites,
+	}
+}
+
 // GetClientTLSConfig.
 func GetClientTLSConfig(certPath string) (*tls.Config, error) {
-	// Load certificate.
-	cert, err := os.ReadFile(certPath)
+	// Create client's certificate pool.
+	certPool, err := Policy.rootCAs(certPath)
+	if err != nil {
+		log.Fatalf("failed to load certificate: %v", err)
+		return nil, err
+	}
+
+	// Set TLS configuration. (The server name is the dialed host if not set)
+	config := &tls.Config{
+		RootCAs:      certPool,
+		MinVersion:   Policy.MinVersion,
+		CipherSuites: Policy.CipherSuites,
+		ServerName:   Policy.ServerName,
+	}
+
+	return config, nil
+}
+
+// GetStoredClientTLSConfig is like GetClientTLSConfig, but trusts the certificate of the server at serverAddr in role,
+// kept in certs.
+func GetStoredClientTLSConfig(certs *store.Certificates, role store.Role_Type, serverAddr string) (*tls.Config, error) {
+	cert, err := certs.Read(role, serverAddr)
 	if err != nil {
-		log.Fatalf("failed to read certificate: %v", err)
+		log.Printf("failed to read certificate of %s %s: %v", role, serverAddr, err)
 		return nil, err
 	}
 
 	// Create client's certificate pool.
+	certPool, err := Policy.rootPool([]string{serverAddr}, [][]byte{cert.Cert})
+	if err != nil {
+		return nil, err
+	}
+
+	// Set TLS configuration. (The server name is the dialed host if not set)
+	config := &tls.Config{
+		RootCAs:      certPool,
+		MinVersion:   Policy.MinVersion,
+		CipherSuites: Policy.CipherSuites,
+		ServerName:   Policy.ServerName,
+	}
+
+	return config, nil
+}
+
+// GetMutualServerTLSConfig is like GetServerTLSConfig, but only accepts clients presenting the certificate at
+// clientCertPath.
+func GetMutualServerTLSConfig(certPath, keyPath, clientCertPath string) (*tls.Config, error) {
+	config, err := GetServerTLSConfig(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load client's certificate.
+	cert, err := os.ReadFile(clientCertPath)
+	if err != nil {
+		log.Printf("failed to read certificate: %v", err)
+		return nil, err
+	}
 	certPool := x509.NewCertPool()
 	if !certPool.AppendCertsFromPEM(cert) {
-		log.Fatalf("failed to append cert to pool: %v", err)
+		return nil, fmt.Errorf("failed to append cert to pool: %s", clientCertPath)
+	}
+
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = certPool
+
+	return config, nil
+}
+
+// GetMutualClientTLSConfig is like GetClientTLSConfig, but trusts every certificate at serverCertPaths and presents
+// the certificate at certPath to the server.
+func GetMutualClientTLSConfig(certPath, keyPath string, serverCertPaths ...string) (*tls.Config, error) {
+	// Load certificate and private key.
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		log.Printf("failed to load certificate: %v", err)
 		return nil, err
 	}
 
-	// Set TLS configuration.
+	// Create client's certificate pool.
+	certPool, err := Policy.rootCAs(serverCertPaths...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set TLS configuration. (The server name is the dialed host if not set)
 	config := &tls.Config{
-		RootCAs:    certPool,
-		MinVersion: tls.VersionTLS12,
-		ServerName: "localhost",
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      certPool,
+		MinVersion:   Policy.MinVersion,
+		CipherSuites: Policy.CipherSuites,
+		ServerName:   Policy.ServerName,
 	}
 
 	return config, nil
 }
+
+// coinResponse computes a coin response for a coin of value using bank on the crypto pool, or using the bank nodes of
+// threshold if set.
+// In threshold mode only coins of value 1 are issued.
+func coinResponse(bank *core.Bank, threshold *ThresholdClient, client *core.ClientInfo, ALower *big.Int, C *big.Int, value int64) (time.Time, *big.Int, *big.Int, error) {
+	if threshold == nil {
+		var (
+			Expiration time.Time
+			A1, C1     *big.Int
+		)
+		err := pool.run(func() {
+			Expiration, A1, C1 = bank.NewValuedCoinResponse(client, ALower, C, value)
+		})
+		return Expiration, A1, C1, err
+	}
+	if core.NormalizeValue(value) != 1 {
+		return time.Time{}, nil, nil, fmt.Errorf("threshold mode only issues coins of value 1")
+	}
+	return threshold.NewCoinResponse(bank.Profile(), client, ALower, C)
+}
+
+// maxExchangeCoins is the most coins surrendered in a single exchange.
+const maxExchangeCoins = 64
+
+// coinRequest is a coin request for a coin of Value.
+type coinRequest struct {
+	ALower *big.Int
+	C      *big.Int
+	Value  int64
+}
+
+// coinResponseMsg is the bank's response to a coinRequest.
+type coinResponseMsg struct {
+	Expiration time.Time
+	Validity   time.Duration // Validity period of the coin, 0 from banks of earlier versions.
+	A1         *big.Int
+	C1         *big.Int
+}
+
+// spentCoin is how a deposited coin was surrendered first, sent along with the refusal of a double spend. (Not by
+// whom, the bank only records it for its audit trail)
+type spentCoin struct {
+	Operation store.Operation_Type
+	Date      time.Time
+}
+
+// Err returns the refusal of the deposit of coin, already spent as told by spent.
+func (spent *spentCoin) Err(coin *core.CoinProfile) error {
+	return fmt.Errorf("coin %d was already spent, surrendered by %s on %s", coin.Hash(), spent.Operation,
+		spent.Date.Local().Format(time.RFC3339))
+}
+
+// recvBankProfile receives the bank's live BankProfile from decoder, sent first by the Withdrawal, Deposit and
+// Exchange servers, and validates it against bank, the BankProfile stored at Accgen.
+func recvBankProfile(decoder *strictDecoder, bank *core.BankProfile) error {
+	var live core.BankProfile
+	if err := decoder.Decode(&live); err != nil {
+		log.Printf("failed to decode BankProfile message: %v", err)
+		return err
+	}
+	if err := bank.ValidateIdentity(&live); err != nil {
+		log.Printf("== ALERT: bank's identity changed since Accgen: %v", err)
+		return err
+	}
+	return nil
+}
+
+// readMint returns the bank issuing coins in currency from bankStore. In threshold mode only bank's own currency is
+// issued, the bank nodes hold shares of bank's key alone.
+func readMint(bankStore *store.BankStore, bank *core.Bank, threshold *ThresholdClient, currency string) (*core.Bank, error) {
+	if threshold != nil && core.NormalizeCurrency(currency) != core.NormalizeCurrency(bank.Currency) {
+		return nil, fmt.Errorf("threshold mode only issues coins in %s", core.NormalizeCurrency(bank.Currency))
+	}
+	return bankStore.ReadMint(currency)
+}
+
+// recordDoubleSpend records the attempt of client to surrender again, by operation, those of coins already
+// surrendered, and disputes them along with the versions surrendered first, signed by mint.
+func recordDoubleSpend(bankStore *store.BankStore, mint *core.BankProfile, coins []*core.CoinProfile, operation store.Operation_Type, client *core.ClientProfile) {
+	if err := bankStore.WriteDoubleSpend(coins, operation, client); err != nil {
+		log.Printf("failed to write DoubleSpend into database: %v", err)
+	}
+	for _, coin := range coins {
+		record, err := bankStore.ReadCoinRecord(coin)
+		if err == store.ErrUnspentCoin {
+			continue // Not surrendered before.
+		} else if err != nil {
+			log.Printf("failed to read CoinProfile from database: %v", err)
+			continue
+		}
+		log.Printf("== ALERT: coin %d was surrendered first by %s of client %d, on %s", record.Hash, record.Operation,
+			record.Client, record.Date.Format(time.RFC3339))
+
+		spent, err := bankStore.ReadSpentCoin(coin)
+		if err != nil {
+			log.Printf("failed to read CoinProfile from database: %v", err)
+			continue
+		}
+		recordDispute(bankStore, core.NewDoubleSpend(mint, spent, coin), operation, client)
+	}
+}
+
+// alreadySpent returns the record of the first of coins already surrendered to the bank of bankStore, nil if none was.
+func alreadySpent(bankStore *store.BankStore, coins []*core.CoinProfile) (*store.CoinRecord, error) {
+	for _, coin := range coins {
+		record, err := bankStore.ReadCoinRecord(coin)
+		if err == store.ErrUnspentCoin {
+			continue
+		}
+		return record, err
+	}
+	return nil, nil
+}
+
+// recordDispute records dispute, detected at operation of client.
+func recordDispute(bankStore *store.BankStore, dispute *core.Dispute, operation store.Operation_Type, client *core.ClientProfile) {
+	id, err := bankStore.WriteDispute(dispute, operation, client)
+	if err != nil {
+		log.Printf("failed to write Dispute into database: %v", err)
+		return
+	}
+	log.Printf("== ALERT: recorded dispute %d", id)
+}
+
+// newCoinRequest computes a coin request in currency for client, completing a pre-generated coin from clientStore if
+// any is available. (Pre-generated coins are computed using the client's own bank profile) Returns the client to
+// finish the coin with.
+func newCoinRequest(clientStore *store.ClientStore, client *core.Client, currency string) (*core.Coin, *core.Client, error) {
+	// Use the mint profile of currency.
+	minted, err := mintClient(clientStore, client, currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	if core.NormalizeCurrency(minted.Bank.Currency) != core.NormalizeCurrency(client.Bank.Currency) {
+		return minted.NewCoinRequest(nil), minted, nil
+	}
+
+	partial, err := clientStore.TakePartialCoin()
+	if err != nil {
+		log.Printf("failed to read pre-generated coin from database: %v", err)
+	}
+	if partial != nil {
+		return client.CompleteCoinRequest(partial), client, nil
+	}
+	return client.NewCoinRequest(nil), client, nil
+}
+
+// mintClient returns client minting coins of currency, with the mint profile of currency stored in clientStore.
+func mintClient(clientStore *store.ClientStore, client *core.Client, currency string) (*core.Client, error) {
+	mint, err := clientStore.ReadMint(client, currency)
+	if err != nil {
+		return nil, err
+	}
+	return client.Mint(mint)
+}
+
+// maxTokenLength is the longest idempotency token of a withdrawal accepted by the bank.
+const maxTokenLength = 64
+
+// maxStatusTokens is the most withdrawals polled at once from the StatusServer.
+const maxStatusTokens = 256
+
+// maxClosureCoins is the most coins surrendered at the closure of an account.
+const maxClosureCoins = 1024
+
+// closureStatement is the bank's answer to the closure of an account.
+type closureStatement struct {
+	Refused  string           // Reason the closure was refused, empty once closed.
+	Balances map[string]int64 // Balances paid out, by currency.
+	Date     time.Time        // Date of the closure.
+}
+
+// transferStatement is the bank's answer to a transfer between accounts.
+type transferStatement struct {
+	Refused string    // Reason the transfer was refused, empty once transferred.
+	ID      int64     // Number of the transfer, in the bank's records.
+	Balance int64     // Payer's balance left, in the transfer's currency.
+	Date    time.Time // Date of the transfer.
+}
+
+// readAccount reads the ClientInfo of the account of client, nil if there's none or if the profile stored for it isn't
+// client. (Accounts are stored under a truncated hash, a key colliding with it mustn't act on the account)
+func readAccount(bankStore *store.BankStore, client *core.ClientProfile) *core.ClientInfo {
+	clientInfo, err := bankStore.ReadClientInfo(client)
+	if clientInfo == nil {
+		log.Printf("== ALERT: client does not exist in database: %v", err)
+		return nil
+	} else if err != nil {
+		log.Printf("failed to read ClientInfo from database: %v", err)
+		return nil
+	}
+	if !clientInfo.Profile.Equal(client) {
+		log.Printf("== ALERT: profile colliding with account %d", client.Hash())
+		return nil
+	}
+	return clientInfo
+}
+
+// accountClosed reports whether the account of client was closed, refusing its operation. (Or if it can't be told)
+func accountClosed(bankStore *store.BankStore, client *core.ClientProfile) bool {
+	closed, err := bankStore.ReadAccountClosed(client)
+	if err != nil {
+		log.Printf("failed to read ClientInfo from database: %v", err)
+		return true
+	}
+	if !closed.IsZero() {
+		log.Printf("== ALERT: operation of account %d, closed on %s", client.Hash(), closed.Local().Format(time.RFC3339))
+		return true
+	}
+	return false
+}
+
+// credentialsExpired reports whether the credentials of clientInfo expired, refusing its operation. (Until renewed,
+// see RenewalServer)
+func credentialsExpired(clientInfo *core.ClientInfo) bool {
+	if !clientInfo.Expiration.IsZero() && time.Now().After(clientInfo.Expiration) {
+		log.Printf("Expired credentials of account %d", clientInfo.Profile.Hash())
+		return true
+	}
+	return false
+}
+
+// newToken returns a new idempotency token of a withdrawal. (See store.WithdrawalStatus)
+func newToken() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// reissuedValidity returns the validity period of the coin of the issued withdrawal of status, answered again now: the
+// period left, checked by the client as if the coin was issued now.
+func reissuedValidity(status *store.WithdrawalStatus) time.Duration {
+	return time.Until(status.Expiration)
+}
+
+// finishWithdrawal finishes the coin request of the pending withdrawal using minted and the coin response, and moves
+// the coin into the wallet of clientStore.
+func finishWithdrawal(clientStore *store.ClientStore, minted *core.Client, pending *store.PendingWithdrawal,
+	Expiration time.Time, Validity time.Duration, A1, C1 *big.Int) error {
+	// Check the coin's expiration against its validity period.
+	if err := core.CheckExpiration(Expiration, Validity, time.Now()); err != nil {
+		log.Printf("== ALERT: invalid coin expiration %s: %v", Expiration, err)
+		return err
+	}
+	coin := minted.FinishCoin(&pending.Coin, Expiration, A1, C1)
+
+	// Write coin. (Already written if the withdrawal was finished before)
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil && err != store.ErrExistingCoin {
+		log.Fatalf("failed to write Coin into database: %v", err)
+		return err
+	}
+	if err := clientStore.DeletePendingWithdrawal(pending.Token); err != nil {
+		log.Printf("failed to delete pending withdrawal from database: %v", err)
+	}
+	return nil
+}
+
+// selectCoin returns the coin of coins to pay amount with, and whether it is only partially spent. A coin of value
+// amount is preferred, otherwise the smallest coin worth more. Any coin is spent whole if amount is 0. Returns nil if
+// no coin is worth amount.
+func selectCoin(coins []core.Coin, amount int64) (*core.Coin, bool) {
+	if len(coins) > 0 && amount == 0 {
+		return &coins[0], false
+	}
+	var selected *core.Coin
+	for i := range coins {
+		value := core.NormalizeValue(coins[i].Params.Value)
+		if value == amount {
+			return &coins[i], false
+		}
+		if value > amount && (selected == nil || value < core.NormalizeValue(selected.Params.Value)) {
+			selected = &coins[i]
+		}
+	}
+	return selected, selected != nil
+}
+
+// reservationTTL is how long a protocol run reserves its coins, should it end without releasing them.
+const reservationTTL = 5 * time.Minute
+
+// acquireCoins reserves coins for a protocol run, so that other runs skip them. Returns the func releasing them, to
+// be deferred.
+func acquireCoins(s *store.ClientStore, coins []core.Coin) (func(), error) {
+	for i := range coins {
+		if err := s.AcquireCoin(&coins[i], reservationTTL); err != nil {
+			for j := 0; j < i; j++ {
+				s.ReleaseCoin(&coins[j])
+			}
+			return nil, err
+		}
+	}
+	return func() {
+		for i := range coins {
+			s.ReleaseCoin(&coins[i])
+		}
+	}, nil
+}
+
+// totalValue returns the value of coins.
+func totalValue(coins []core.Coin) int64 {
+	var total int64
+	for _, coin := range coins {
+		total += core.NormalizeValue(coin.Params.Value)
+	}
+	return total
+}
+
+// coinsIn returns the coins of coins in currency.
+func coinsIn(coins []core.Coin, currency string) []core.Coin {
+	var filtered []core.Coin
+	for _, coin := range coins {
+		if core.NormalizeCurrency(coin.Params.Currency) == core.NormalizeCurrency(currency) {
+			filtered = append(filtered, coin)
+		}
+	}
+	return filtered
+}
+
+// unexpiredCoins returns the coins of coins that haven't expired at now.
+func unexpiredCoins(coins []core.Coin, now time.Time) []core.Coin {
+	var filtered []core.Coin
+	for _, coin := range coins {
+		if core.CheckUnexpired(coin.Params.Expiration, now) == nil {
+			filtered = append(filtered, coin)
+		}
+	}
+	return filtered
+}
+
+// accgenRequest is the metadata of an account application.
+type accgenRequest struct {
+	Evidence string
+	Token    string
+	Work     uint64 // Solution of the bank's WorkChallenge.
+}
+
+// PaymentDetails is what a payment pays, as stamped by the merchant, shown to the payer before signing the coin.
+type PaymentDetails struct {
+	Payee    *big.Int  // Merchant's transaction identifier. (TradeId)
+	Date     time.Time // Transaction date, chosen by the merchant.
+	Currency string
+	Amount   int64
+	Memo     string
+	Escrow   time.Time // Escrow timeout, zero if not escrowed.
+}
+
+// Agent operations, proxied by the CLI through the wallet agent while it holds the wallet.
+const (
+	AgentBalance  = "balance"  // Output is the wallet's balances, as JSON.
+	AgentWithdraw = "withdraw" // Withdraws coins of Values from the agent's bank.
+	AgentDeposit  = "deposit"  // Deposits 1 coin to the agent's bank.
+	AgentPay      = "pay"      // Pays the merchant at Server.
+	AgentExchange = "exchange" // Exchanges coins at the agent's bank.
+)
+
+// AgentRequest is an operation requested to the wallet agent, with the parameters of the matching CLI command. Server
+// is the bank's server, which must be the agent's, except when paying.
+type AgentRequest struct {
+	Operation     string
+	Server        string
+	Bank          string
+	Currency      string
+	Account       string
+	Memo          string
+	To            string
+	Values        []int64
+	Value         int64
+	Amount        int64
+	Escrow        time.Duration
+	Expiring      time.Duration
+	Denominations []int64
+	BankServer    string
+	Release       string
+	Beneficiary   string
+}
+
+// agentResponse is the wallet agent's response to an AgentRequest.
+type agentResponse struct {
+	Output string
+	Error  string
+}
+
+// Admin operations.
+const (
+	AdminInspect = "inspect" // Output is a store.BankInspection, as JSON.
+	AdminReport  = "report"
+	AdminFreeze  = "freeze"
+	AdminFund    = "fund"
+	AdminStats   = "stats" // Output is a store.BankStats, as JSON.
+	AdminPool    = "pool"
+)
+
+// AdminRequest is a remote administration request, Operation being one of the admin operations.
+type AdminRequest struct {
+	Operation string
+	Account   uint32
+	Currency  string
+	Amount    int64
+	Reason    string
+}
+
+// adminResponse is the bank's response to an AdminRequest.
+type adminResponse struct {
+	Output string
+	Error  string
+}
+
+// certificateFrame is a TLS certificate, as PEM, sent along with its length and SHA-256 checksum. (Empty if publicly
+// trusted)
+type certificateFrame struct {
+	Length   int
+	Checksum [sha256.Size]byte
+	PEM      []byte
+}
+
+// newCertificateFrame returns the frame of cert.
+func newCertificateFrame(cert []byte) certificateFrame {
+	return certificateFrame{Length: len(cert), Checksum: sha256.Sum256(cert), PEM: cert}
+}
+
+// Certificate returns the framed certificate, once checked against its length and checksum.
+func (frame *certificateFrame) Certificate() ([]byte, error) {
+	if len(frame.PEM) != frame.Length {
+		return nil, fmt.Errorf("certificate is %d bytes long, expected %d", len(frame.PEM), frame.Length)
+	}
+	if sha256.Sum256(frame.PEM) != frame.Checksum {
+		return nil, fmt.Errorf("certificate doesn't match its checksum")
+	}
+	return frame.PEM, nil
+}
+
+// setupMessage is a bank's TLS certificate along with its signed Setup bundle.
+type setupMessage struct {
+	Cert   certificateFrame
+	Bundle core.SetupBundle
+}
+
+// setupBundleKey returns the Meta key of the Setup bundle of the bank at serverAddr.
+func setupBundleKey(serverAddr string) string {
+	return fmt.Sprintf("setup/%s", serverAddr)
+}
+
+// certificateMessage is a merchant's TLS certificate along with its bank's attestation.
+type certificateMessage struct {
+	Cert        certificateFrame
+	Attestation core.Attestation
+}
@@ -1,12 +1,18 @@
 package network_test
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 	"ziba/core"
 	"ziba/network"
 	"ziba/store"
@@ -27,17 +33,17 @@ func TestInit(t *testing.T) {
 	}
 
 	// Create BankStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", bankName))
-	store, err := new(store.BankStore).New(dbPath, "main")
+	dbPath := store.DatabasePath(directory, bankName)
+	bankStore, err := new(store.BankStore).New(dbPath, "main")
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Create Bank.
-	bank := new(core.Bank).New(core.Params)
+	bank := new(core.Bank).New(nil, core.Params)
 
 	// Write Bank into store.
-	store.WriteBank(bank, bankName)
+	bankStore.WriteBank(bank, bankName)
 
 	// Create key and certificate for Bank.
 	err = network.CreateCertificate(directory, bankName)
@@ -45,52 +51,35 @@ func TestInit(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	// Make a copy of bank's certificate.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", bankName))
-	certCopyPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-
-	certFile, err := os.Open(certPath)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer certFile.Close()
-
-	certCopyFile, err := os.Create(certCopyPath)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer certCopyFile.Close()
-
-	_, err = io.Copy(certCopyFile, certFile)
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	// Create key and certificate for User 1.
 	err = network.CreateCertificate(directory, userName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Make a copy of user's certificate.
-	certPath = filepath.Join(directory, fmt.Sprintf("%s_cert.pem", userName))
-	certCopyPath = filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", userName))
-
-	certFile, err = os.Open(certPath)
+	// Keep the bank's certificate in both users' wallets, and User 1's in User 2's. (The bank and User 1's payment
+	// server share an address, under different roles)
+	bankCert, err := os.ReadFile(store.CertPath(directory, bankName))
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer certFile.Close()
-
-	certCopyFile, err = os.Create(certCopyPath)
+	userCert, err := os.ReadFile(store.CertPath(directory, userName))
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer certCopyFile.Close()
-
-	_, err = io.Copy(certCopyFile, certFile)
-	if err != nil {
-		t.Fatal(err)
+	for _, name := range []string{userName, userName2} {
+		clientStore, err := new(store.ClientStore).New(store.DatabasePath(directory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := clientStore.Certificates().Write(&store.Certificate{Role: store.Role_Bank, Name: address, Cert: bankCert}); err != nil {
+			t.Fatal(err)
+		}
+		if name == userName2 {
+			if err := clientStore.Certificates().Write(&store.Certificate{Role: store.Role_Merchant, Name: address, Cert: userCert}); err != nil {
+				t.Fatal(err)
+			}
+		}
 	}
 }
 
@@ -106,7 +95,7 @@ func TestSetupServer(t *testing.T) {
 	}
 
 	// Create BankStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", bankName))
+	dbPath := store.DatabasePath(directory, bankName)
 	store, err := new(store.BankStore).New(dbPath, "main")
 	if err != nil {
 		t.Fatal(err)
@@ -129,7 +118,7 @@ func TestSetupClient(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName))
+	dbPath := store.DatabasePath(directory, userName)
 	store, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
@@ -156,22 +145,22 @@ func TestAccgenServer(t *testing.T) {
 	}
 
 	// Create BankStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", bankName))
-	store, err := new(store.BankStore).New(dbPath, "main")
+	dbPath := store.DatabasePath(directory, bankName)
+	bankStore, err := new(store.BankStore).New(dbPath, "main")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Load TLS server configuration.
-	keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", bankName))
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", bankName))
+	keyPath := store.KeyPath(directory, bankName)
+	certPath := store.CertPath(directory, bankName)
 	config, err := network.GetServerTLSConfig(certPath, keyPath)
 	if err != nil {
 		t.Fatalf("failed to grab TLS server configuration: %v", err)
 	}
 
 	// New.
-	server := new(network.AccgenServer).New(store, config)
+	server := new(network.AccgenServer).New(bankStore, config)
 
 	// Start.
 	if err := server.Start(); err != nil {
@@ -187,22 +176,21 @@ func TestAccgenClient(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.AccgenClient).New(address, store, config)
+	client := new(network.AccgenClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
@@ -218,22 +206,21 @@ func TestAccgenClient2(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName2))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName2)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.AccgenClient).New(address, store, config)
+	client := new(network.AccgenClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
@@ -253,22 +240,22 @@ func TestWithdrawalServer(t *testing.T) {
 	}
 
 	// Create BankStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", bankName))
-	store, err := new(store.BankStore).New(dbPath, "main")
+	dbPath := store.DatabasePath(directory, bankName)
+	bankStore, err := new(store.BankStore).New(dbPath, "main")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Load TLS server configuration.
-	keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", bankName))
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", bankName))
+	keyPath := store.KeyPath(directory, bankName)
+	certPath := store.CertPath(directory, bankName)
 	config, err := network.GetServerTLSConfig(certPath, keyPath)
 	if err != nil {
 		t.Fatalf("failed to grab TLS server configuration: %v", err)
 	}
 
 	// New.
-	server := new(network.WithdrawalServer).New(store, config)
+	server := new(network.WithdrawalServer).New(bankStore, config)
 
 	// Start.
 	if err := server.Start(); err != nil {
@@ -284,22 +271,21 @@ func TestWithdrawalClient(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.WithdrawalClient).New(address, store, config)
+	client := new(network.WithdrawalClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
@@ -315,22 +301,21 @@ func TestWithdrawalClient2(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName2))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName2)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.WithdrawalClient).New(address, store, config)
+	client := new(network.WithdrawalClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
@@ -350,23 +335,23 @@ func TestPaymentServer(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS server configuration.
-	keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", userName))
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", userName))
+	keyPath := store.KeyPath(directory, userName)
+	certPath := store.CertPath(directory, userName)
 	config, err := network.GetServerTLSConfig(certPath, keyPath)
 	if err != nil {
 		t.Fatalf("failed to grab TLS server configuration: %v", err)
 	}
 
 	// New.
-	server := new(network.PaymentServer).New(store, config)
+	server := new(network.PaymentServer).New(clientStore, config)
 
 	// Start.
 	if err := server.Start(); err != nil {
@@ -382,22 +367,21 @@ func TestPaymentClient(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName2))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName2)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", userName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Merchant, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.PaymentClient).New(address, store, config)
+	client := new(network.PaymentClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
@@ -417,22 +401,22 @@ func TestDepositServer(t *testing.T) {
 	}
 
 	// Create BankStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", bankName))
-	store, err := new(store.BankStore).New(dbPath, "main")
+	dbPath := store.DatabasePath(directory, bankName)
+	bankStore, err := new(store.BankStore).New(dbPath, "main")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Load TLS server configuration.
-	keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", bankName))
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", bankName))
+	keyPath := store.KeyPath(directory, bankName)
+	certPath := store.CertPath(directory, bankName)
 	config, err := network.GetServerTLSConfig(certPath, keyPath)
 	if err != nil {
 		t.Fatalf("failed to grab TLS server configuration: %v", err)
 	}
 
 	// New.
-	server := new(network.DepositServer).New(store, config)
+	server := new(network.DepositServer).New(bankStore, config)
 
 	// Start.
 	if err := server.Start(); err != nil {
@@ -448,22 +432,21 @@ func TestDepositClient(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.DepositClient).New(address, store, config)
+	client := new(network.DepositClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
@@ -483,22 +466,22 @@ func TestExchangeServer(t *testing.T) {
 	}
 
 	// Create BankStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", bankName))
-	store, err := new(store.BankStore).New(dbPath, "main")
+	dbPath := store.DatabasePath(directory, bankName)
+	bankStore, err := new(store.BankStore).New(dbPath, "main")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Load TLS server configuration.
-	keyPath := filepath.Join(directory, fmt.Sprintf("%s_key.pem", bankName))
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", bankName))
+	keyPath := store.KeyPath(directory, bankName)
+	certPath := store.CertPath(directory, bankName)
 	config, err := network.GetServerTLSConfig(certPath, keyPath)
 	if err != nil {
 		t.Fatalf("failed to grab TLS server configuration: %v", err)
 	}
 
 	// New.
-	server := new(network.ExchangeServer).New(store, config)
+	server := new(network.ExchangeServer).New(bankStore, config)
 
 	// Start.
 	if err := server.Start(); err != nil {
@@ -514,25 +497,251 @@ func TestExchangeClient(t *testing.T) {
 	}
 
 	// Create ClientStore.
-	dbPath := filepath.Join(directory, fmt.Sprintf("%s.db", userName))
-	store, err := new(store.ClientStore).New(dbPath)
+	dbPath := store.DatabasePath(directory, userName)
+	clientStore, err := new(store.ClientStore).New(dbPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	store.BankName = bankName
+	clientStore.BankName = bankName
 
 	// Load TLS client configuration.
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert_cpy.pem", bankName))
-	config, err := network.GetClientTLSConfig(certPath)
+	config, err := network.GetStoredClientTLSConfig(clientStore.Certificates(), store.Role_Bank, address)
 	if err != nil {
 		t.Fatalf("failed to grab TLS client configuration: %v", err)
 	}
 
 	// New.
-	client := new(network.ExchangeClient).New(address, store, config)
+	client := new(network.ExchangeClient).New(address, clientStore, config)
 
 	// Execute.
 	if err := client.Execute(); err != nil {
 		t.Fatal(err)
 	}
 }
+
+// **********
+// TRANSPORTS
+// **********
+
+// newTestCertificates returns the certificates of a temporary wallet, holding the certificate cert of name in role.
+func newTestCertificates(t *testing.T, role store.Role_Type, name string, cert []byte) *store.Certificates {
+	t.Helper()
+	clientStore, err := new(store.ClientStore).New(filepath.Join(t.TempDir(), "wallet.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := clientStore.Certificates()
+	if err := certs.Write(&store.Certificate{Role: role, Name: name, Cert: cert}); err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+// roundTrip writes a message each way between the dialed stream payer and the accepted stream merchant.
+func roundTrip(t *testing.T, payer, merchant io.ReadWriter) {
+	t.Helper()
+	for _, pair := range [][2]io.ReadWriter{{payer, merchant}, {merchant, payer}} {
+		sent := []byte("ziba")
+		go pair[0].Write(sent)
+		received := make([]byte, len(sent))
+		if _, err := io.ReadFull(pair[1], received); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, sent) {
+			t.Fatalf("expected %q, got %q", sent, received)
+		}
+	}
+}
+
+// accept accepts the next payer of listener, running the TLS handshake as a server's first read would, and sends its
+// stream. (nil if refused)
+func accept(t *testing.T, listener network.PaymentListener) <-chan io.ReadWriteCloser {
+	accepted := make(chan io.ReadWriteCloser, 1)
+	go func() {
+		stream, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			accepted <- nil
+			return
+		}
+		if conn, ok := stream.(*tls.Conn); ok {
+			if err := conn.Handshake(); err != nil {
+				conn.Close()
+				stream = nil
+			}
+		}
+		accepted <- stream
+	}()
+	return accepted
+}
+
+func TestTLSTransport(t *testing.T) {
+	// Merchant's certificate, trusted by the payer.
+	cert, key, err := network.GenerateCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig, err := network.GetStoredServerTLSConfig(&store.Certificate{Role: store.Role_Own, Cert: cert, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := newTestCertificates(t, store.Role_Merchant, address, cert)
+	clientConfig, err := network.GetStoredClientTLSConfig(certs, store.Role_Merchant, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Listen.
+	listener, err := new(network.TLSTransport).New(serverConfig).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := accept(t, listener)
+
+	// Dial, and exchange messages both ways.
+	payer, err := new(network.TLSTransport).New(clientConfig).Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer payer.Close()
+	merchant := <-accepted
+	if merchant == nil {
+		t.FailNow()
+	}
+	defer merchant.Close()
+	roundTrip(t, payer, merchant)
+
+	// The payer's end is seen by the merchant.
+	payer.Close()
+	if _, err := merchant.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the payer's disconnection")
+	}
+
+	// Closed listeners accept no more payers.
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected %v, got %v", net.ErrClosed, err)
+	}
+}
+
+// relayOnce starts the relay of the tests once, the bank's certificate being relayCert.
+var (
+	relayOnce           sync.Once
+	relayCert, relayKey []byte
+)
+
+// startRelay starts the relay of the tests, if not yet, and waits for it to listen.
+func startRelay(t *testing.T) {
+	t.Helper()
+	relayOnce.Do(func() {
+		var err error
+		if relayCert, relayKey, err = network.GenerateCertificate(); err != nil {
+			t.Fatal(err)
+		}
+		config, err := network.GetStoredServerTLSConfig(&store.Certificate{Role: store.Role_Own, Cert: relayCert, Key: relayKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go new(network.RelayServer).New(config).Start()
+	})
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", net.JoinHostPort(address, "9107"))
+		if err == nil {
+			conn.Close()
+			return
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// newRelayMerchant returns the relay transport of a new merchant registering name, and its certificate.
+func newRelayMerchant(t *testing.T, name string) (*network.RelayTransport, []byte) {
+	t.Helper()
+	cert, key, err := network.GenerateCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := &store.Certificate{Role: store.Role_Own, Name: name, Cert: cert, Key: key}
+	config, err := network.GetStoredServerTLSConfig(own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := newTestCertificates(t, store.Role_Bank, address, relayCert)
+	return new(network.RelayTransport).New(address, certs, config).Register(name, own, nil), cert
+}
+
+// newRelayPayer returns the relay transport of a payer trusting merchantCert for the merchant name.
+func newRelayPayer(t *testing.T, name string, merchantCert []byte) *network.RelayTransport {
+	t.Helper()
+	relayAddr := name + "@" + address
+	certs := newTestCertificates(t, store.Role_Bank, address, relayCert)
+	if err := certs.Write(&store.Certificate{Role: store.Role_Merchant, Name: relayAddr, Cert: merchantCert}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := network.GetStoredClientTLSConfig(certs, store.Role_Merchant, relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(network.RelayTransport).New(address, certs, config)
+}
+
+func TestRelayTransport(t *testing.T) {
+	startRelay(t)
+
+	// Register the merchant. (Under a name of its own, the relay binding names for its lifetime)
+	name := fmt.Sprintf("tienda%d", time.Now().UnixNano())
+	merchantTransport, merchantCert := newRelayMerchant(t, name)
+	listener, err := merchantTransport.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payerTransport := newRelayPayer(t, name, merchantCert)
+
+	// Payers are forwarded to the merchant, end to end.
+	accepted := accept(t, listener)
+	payer, err := payerTransport.Dial(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merchant := <-accepted
+	if merchant == nil {
+		t.FailNow()
+	}
+	roundTrip(t, payer, merchant)
+
+	// The payer's disconnection reaches the merchant through the relay.
+	payer.Close()
+	if _, err := merchant.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the payer's disconnection")
+	}
+	merchant.Close()
+
+	// A merchant presenting a certificate other than the attested one is refused by the payer.
+	_, otherCert := newRelayMerchant(t, "otra")
+	accepted = accept(t, listener)
+	if _, err := newRelayPayer(t, name, otherCert).Dial(name); err == nil {
+		t.Fatal("expected the merchant's certificate to be refused")
+	}
+	<-accepted
+
+	// Names belong to the certificate that registered them first.
+	impostor, _ := newRelayMerchant(t, name)
+	if _, err := impostor.Listen(); err == nil {
+		t.Fatal("expected the registration of a taken name to be refused")
+	}
+
+	// Unregistered merchants aren't reachable, nor are merchants once disconnected.
+	if _, err := payerTransport.Dial("nadie"); err == nil {
+		t.Fatal("expected an unregistered merchant to be unreachable")
+	}
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payerTransport.Dial(name); err == nil {
+		t.Fatal("expected a disconnected merchant to be unreachable")
+	}
+}
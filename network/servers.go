@@ -1,20 +1,27 @@
 package network
 
 import (
-	"bufio"
+	"bytes"
 	"crypto/tls"
 	"database/sql"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/big"
 	"net"
+	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 	"ziba/core"
 	"ziba/store"
+
+	"golang.org/x/net/websocket"
 )
 
 //
@@ -28,6 +35,12 @@ func (s *SetupServer) New(store *store.BankStore) *SetupServer {
 	return s
 }
 
+// ACME sets whether the bank's certificate is obtained through ACME. (Publicly trusted, no certificate is sent)
+func (s *SetupServer) ACME(acme bool) *SetupServer {
+	s.acme = acme
+	return s
+}
+
 // Start.
 func (s *SetupServer) Start() error {
 	// Start listening.
@@ -51,46 +64,58 @@ func (s *SetupServer) Start() error {
 
 // handleClient.
 func (s *SetupServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("SetupServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
 	// Info message.
 	log.Print("Serving client [Setup]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
-	// Grab certificate file.
-	directory, err := store.GetZibaDir()
-	if err != nil {
-		log.Fatalf("failed to retrieve Ziba directory: %v", err)
-		return
-	}
-	certPath := filepath.Join(directory, fmt.Sprintf("%s_cert.pem", s.store.Name))
-	file, err := os.Open(certPath)
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
 	if err != nil {
-		log.Fatalf("failed to open certificate file: %v", err)
+		log.Fatalf("failed to read Bank from database: %v", err)
 		return
 	}
-	defer file.Close()
-
-	// encoder := gob.NewEncoder(conn)
-	writer := bufio.NewWriter(conn)
 
-	// SEND name.
-	bankName := s.store.Name
-	if _, err := writer.WriteString(bankName + "\n"); err != nil {
-		log.Fatalf("failed to encode Bank's name message: %v", err)
-		return
+	// Grab certificate file. (Empty if publicly trusted)
+	var cert []byte
+	if !s.acme {
+		directory, err := store.GetZibaDir()
+		if err != nil {
+			log.Fatalf("failed to retrieve Ziba directory: %v", err)
+			return
+		}
+		if cert, err = os.ReadFile(store.CertPath(directory, s.store.Name)); err != nil {
+			log.Fatalf("failed to open certificate file: %v", err)
+			return
+		}
 	}
 
-	// SEND file.
-	_, err = io.Copy(writer, file)
+	trace.Phase(phaseCrypto)
+	// Sign name and certificate.
+	fingerprint, err := store.PEMFingerprint(cert)
 	if err != nil {
-		log.Fatalf("failed to send certificate file message: %v", err)
+		log.Fatalf("failed to read certificate file: %v", err)
 		return
 	}
+	bundle := bank.SignSetup(nil, s.store.Name, fingerprint)
+	if bundle == nil {
+		log.Print("failed to sign SetupBundle")
+		return
+	}
+
+	encoder := gob.NewEncoder(conn)
 
-	// Flush writer.
-	if err := writer.Flush(); err != nil {
-		log.Fatalf("failed to flush connection: %v", err)
+	trace.Phase(phaseEncode)
+	// SEND name, certificate and signed bundle.
+	if err := encoder.Encode(setupMessage{Cert: newCertificateFrame(cert), Bundle: *bundle}); err != nil {
+		log.Printf("failed to encode Setup message: %v", err)
 		return
 	}
 
@@ -110,6 +135,54 @@ func (s *AccgenServer) New(store *store.BankStore, config *tls.Config) *AccgenSe
 	return s
 }
 
+// Policy sets the requirements of account applications.
+func (s *AccgenServer) Policy(policy AccgenPolicy) *AccgenServer {
+	s.policy = policy
+	s.accounts = make(map[string][]time.Time)
+	return s
+}
+
+// withinQuota reports whether host may open another account.
+func (s *AccgenServer) withinQuota(host string) bool {
+	if s.policy.Quota <= 0 {
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Forget accounts opened before the window.
+	recent := s.accounts[host][:0]
+	for _, opened := range s.accounts[host] {
+		if time.Since(opened) < s.policy.QuotaWindow {
+			recent = append(recent, opened)
+		}
+	}
+	if len(recent) == 0 {
+		delete(s.accounts, host)
+	} else {
+		s.accounts[host] = recent
+	}
+	return len(recent) < s.policy.Quota
+}
+
+// countAccount counts an account opened by host against its quota.
+func (s *AccgenServer) countAccount(host string) {
+	if s.policy.Quota <= 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accounts[host] = append(s.accounts[host], time.Now())
+}
+
+// Admission sets the admission policy of account applications. (Every account is opened if not set)
+func (s *AccgenServer) Admission(policy AdmissionPolicy) *AccgenServer {
+	s.admission = policy
+	return s
+}
+
 // Start.
 func (s *AccgenServer) Start() error {
 	// Start listening.
@@ -133,12 +206,29 @@ func (s *AccgenServer) Start() error {
 
 // handleClient.
 func (s *AccgenServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("AccgenServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
 	// Info message.
 	log.Print("Serving client [Accgen]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
+	// Check the host's quota.
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		log.Printf("failed to read client address: %v", err)
+		return
+	}
+	if !s.withinQuota(host) {
+		log.Printf("== ALERT: account quota exceeded by %s", host)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
 	// Read Bank.
 	bank, err := s.store.ReadBank()
 	if err != nil {
@@ -146,9 +236,10 @@ func (s *AccgenServer) handleClient(conn net.Conn) {
 		return
 	}
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseEncode)
 	// SEND BankProfile to client.
 	bankProfile := bank.Profile()
 	if err := encoder.Encode(*bankProfile); err != nil {
@@ -156,13 +247,52 @@ func (s *AccgenServer) handleClient(conn net.Conn) {
 		return
 	}
 
+	// SEND scheme fingerprint to client.
+	if err := encoder.Encode(bankProfile.Scheme.Fingerprint()); err != nil {
+		log.Printf("failed to encode fingerprint message: %v", err)
+		return
+	}
+
+	// SEND WorkChallenge to client.
+	challenge, err := core.NewWorkChallenge(nil, s.policy.Work)
+	if err != nil {
+		log.Printf("failed to create WorkChallenge: %v", err)
+		return
+	}
+	if err := encoder.Encode(*challenge); err != nil {
+		log.Printf("failed to encode WorkChallenge message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
 	// RECV ClientProfile from client.
 	var client core.ClientProfile
 	if err := decoder.Decode(&client); err != nil {
-		log.Fatalf("failed to decode ClientProfile message: %v", err)
+		log.Printf("failed to decode ClientProfile message: %v", err)
+		return
+	}
+
+	// RECV request metadata from client.
+	var request accgenRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Accgen request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	// Verify proof of work.
+	if valid := challenge.Verify(&client, request.Work); !valid {
+		log.Printf("== ALERT: invalid proof of work from %s", host)
 		return
 	}
 
+	trace.Phase(phaseStoreRead)
 	// Read ClientInfo from database. (Check if already in database)
 	clientInfo, err := s.store.ReadClientInfo(&client)
 	if clientInfo != nil {
@@ -173,26 +303,75 @@ func (s *AccgenServer) handleClient(conn net.Conn) {
 		return
 	}
 
+	trace.Phase(phaseStoreWrite)
+	// Consume registration token. (Kept by the client until its application is decided)
+	if s.policy.RequireToken {
+		if err := s.store.ConsumeRegistrationToken(request.Token, &client); err != nil {
+			log.Printf("invalid registration token from client %d: %v", client.Hash(), err)
+			return
+		}
+	}
+
+	trace.Phase(phaseCrypto)
+	// Admit client.
+	admission := store.Admission_Approved
+	if s.admission != nil {
+		if admission, err = s.admission.Admit(&client, request.Evidence); err != nil {
+			log.Printf("failed to admit client %d: %v", client.Hash(), err)
+			return
+		}
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND admission.
+	if err := encoder.Encode(admission); err != nil {
+		log.Printf("failed to encode admission message: %v", err)
+		return
+	}
+	if admission != store.Admission_Approved {
+		log.Printf("Client %d: %s", client.Hash(), admission)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
 	// Create client account.
-	clientInfo, err = bank.NewClient(&client)
+	clientInfo, err = bank.NewClient(nil, &client)
 	if err != nil {
 		log.Fatalf("failed to create client account: %v", err)
 		return
 	}
 
+	trace.Phase(phaseStoreWrite)
 	// Write ClientInfo.
-	if err := s.store.WriteClientInfo(clientInfo); err != nil {
+	if err := s.store.WriteClientInfo(clientInfo); err == store.ErrExistingClient {
+		log.Print("client already exists")
+		return
+	} else if err != nil {
 		log.Fatalf("failed to write ClientInfo into database: %v", err)
 		return
 	}
+	s.countAccount(host)
+
+	trace.Phase(phaseStoreRead)
+	// Read the profiles of every mint.
+	mints, err := s.store.ReadMints()
+	if err != nil {
+		log.Fatalf("failed to read mints from database: %v", err)
+		return
+	}
 
-	// SEND credentials to client.
+	trace.Phase(phaseEncode)
+	// SEND credentials and mint profiles to client.
 	credentials := struct {
 		Credential *big.Int
 		Contract   *big.Int
+		Expiration time.Time
+		Mints      []core.BankProfile
 	}{
 		Credential: clientInfo.Credential,
 		Contract:   clientInfo.Contract,
+		Expiration: clientInfo.Expiration,
+		Mints:      mints,
 	}
 	if err := encoder.Encode(credentials); err != nil {
 		log.Fatalf("failed to encode ClientInfo message: %v", err)
@@ -204,6 +383,327 @@ func (s *AccgenServer) handleClient(conn net.Conn) {
 	log.Print("Finished serving client [Accgen]")
 }
 
+//
+// ADMISSION
+//
+
+// 1. The AccgenServer asks its admission policy whether to open an account for a client, given the evidence (e.g. a
+//		KYC reference) the client sent along with its profile.
+// 2. A pending client keeps its keys and applies again later with the same profile, until approved or rejected.
+// 3. Policies: AutoApproval opens every account, ManualApproval queues applications for "bank approve", HTTPApproval
+//		asks an external service.
+
+// Admit.
+func (AutoApproval) Admit(client *core.ClientProfile, evidence string) (store.Admission_Type, error) {
+	return store.Admission_Approved, nil
+}
+
+// New.
+func (p *ManualApproval) New(store *store.BankStore) *ManualApproval {
+	p.store = store
+	return p
+}
+
+// Admit queues the application of client on first contact, then returns its status.
+func (p *ManualApproval) Admit(client *core.ClientProfile, evidence string) (store.Admission_Type, error) {
+	status, err := p.store.ReadAdmission(client.Hash())
+	if err == store.ErrUnknownApplication {
+		return store.Admission_Pending, p.store.WriteApplication(client, evidence)
+	}
+	return status, err
+}
+
+// New.
+func (p *HTTPApproval) New(url string) *HTTPApproval {
+	p.url = url
+	p.client = &http.Client{Timeout: 10 * time.Second}
+	return p
+}
+
+// Admit posts the application of client to the service as JSON ({"client": hash, "evidence": evidence}). The service
+// answers 200 OK to approve it, 202 Accepted to keep it pending and 403 Forbidden to reject it.
+func (p *HTTPApproval) Admit(client *core.ClientProfile, evidence string) (store.Admission_Type, error) {
+	body, err := json.Marshal(struct {
+		Client   uint32 `json:"client"`
+		Evidence string `json:"evidence"`
+	}{client.Hash(), evidence})
+	if err != nil {
+		return store.Admission_Rejected, err
+	}
+
+	res, err := p.client.Post(p.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return store.Admission_Rejected, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return store.Admission_Approved, nil
+	case http.StatusAccepted:
+		return store.Admission_Pending, nil
+	case http.StatusForbidden:
+		return store.Admission_Rejected, nil
+	}
+	return store.Admission_Rejected, fmt.Errorf("unexpected admission service status: %s", res.Status)
+}
+
+//
+// FRAUD RULES
+//
+
+// 1. The Withdrawal and Deposit servers evaluate each operation against the bank's fraud rules, before serving it.
+// 2. A rule matching an operation raises an alert (see store.FraudAlert), and its action applies: FraudReject refuses
+//		the operation, FraudFlag serves it anyway, and FraudApproval holds it in the pending queue until an admin
+//		approves or rejects it with "bank pending". The client tries again once approved.
+// 3. The server sends its FraudReview before serving the operation: the client learns the operation is pending
+//		review, and polls its alert from the ReviewServer.
+// 4. Built-in rules: VelocityRule limits the operations of a client within a window (attempts count), AmountRule
+//		matches unusual amounts, NewAccountRule restricts the amounts of recently opened accounts.
+// 5. Operations are refused if a rule fails to evaluate.
+
+// New.
+func (rules *FraudRules) New(store *store.BankStore) *FraudRules {
+	rules.store = store
+	return rules
+}
+
+// Add adds rule, taking action on the matched operations among operations (every one if none).
+func (rules *FraudRules) Add(rule FraudRule, action FraudAction, operations ...store.Operation_Type) *FraudRules {
+	rules.entries = append(rules.entries, fraudEntry{rule: rule, action: action, operations: operations})
+	return rules
+}
+
+// Evaluate evaluates op against the rules, raising the alerts of those matching it, and returns the review of op: held
+// by the alert refusing it, a final one first. Every operation is served if rules is nil.
+func (rules *FraudRules) Evaluate(op *FraudOperation) FraudReview {
+	var review FraudReview
+	if rules == nil {
+		return review
+	}
+
+	for _, entry := range rules.entries {
+		if len(entry.operations) > 0 && !slices.Contains(entry.operations, op.Operation) {
+			continue
+		}
+		matched, err := entry.rule.Match(op)
+		if err != nil {
+			log.Printf("failed to evaluate fraud rule %s: %v", entry.rule.Name(), err)
+			return FraudReview{Held: true, Status: store.Alert_Rejected}
+		} else if !matched {
+			continue
+		}
+
+		// Raise alert.
+		alert := &store.FraudAlert{
+			Rule:      entry.rule.Name(),
+			Operation: op.Operation,
+			Client:    op.Client.Hash(),
+			Currency:  op.Currency,
+			Value:     op.Value,
+		}
+		switch entry.action {
+		case FraudReject:
+			alert.Status = store.Alert_Rejected
+			_, err = rules.store.WriteFraudAlert(alert)
+		case FraudFlag:
+			alert.Status = store.Alert_Flagged
+			_, err = rules.store.WriteFraudAlert(alert)
+		case FraudApproval:
+			alert.Status, err = rules.store.RequestApproval(alert)
+		}
+		if err != nil {
+			log.Printf("failed to write FraudAlert into database: %v", err)
+			return FraudReview{Held: true, Status: store.Alert_Rejected}
+		}
+		log.Printf("== ALERT: %s of client %d matched fraud rule %s: %s (alert %d)", op.Operation, alert.Client, alert.Rule,
+			alert.Status, alert.ID)
+		if alert.Status == store.Alert_Flagged || alert.Status == store.Alert_Released {
+			continue
+		}
+		if !review.Held || review.Status == store.Alert_Pending {
+			review = FraudReview{Held: true, Alert: alert.ID, Status: alert.Status}
+		}
+	}
+	return review
+}
+
+// Err returns the error of the operation named operation held by review, nil if served.
+func (review *FraudReview) Err(operation string) error {
+	switch {
+	case !review.Held:
+		return nil
+	case review.Limit != nil:
+		return review.Limit
+	case review.Status == store.Alert_Pending:
+		return fmt.Errorf("%s is pending review (alert %d), poll it with \"user review\" and try again once approved", operation, review.Alert)
+	case review.Alert == 0:
+		return fmt.Errorf("%s was refused by the bank's fraud rules", operation)
+	}
+	return fmt.Errorf("%s was refused by the bank's fraud rules (alert %d: %s)", operation, review.Alert, review.Status)
+}
+
+// New.
+func (rule *VelocityRule) New(count int, window time.Duration) *VelocityRule {
+	rule.count = count
+	rule.window = window
+	rule.recent = make(map[uint32][]time.Time)
+	return rule
+}
+
+// Name.
+func (rule *VelocityRule) Name() string {
+	return fmt.Sprintf("velocity(%d/%s)", rule.count, rule.window)
+}
+
+// Match matches the operations of a client beyond count within the window, this one included.
+func (rule *VelocityRule) Match(op *FraudOperation) (bool, error) {
+	rule.mutex.Lock()
+	defer rule.mutex.Unlock()
+
+	hash := op.Client.Hash()
+	recent := slices.DeleteFunc(rule.recent[hash], func(date time.Time) bool {
+		return op.Date.Sub(date) >= rule.window
+	})
+	recent = append(recent, op.Date)
+	rule.recent[hash] = recent
+	return len(recent) > rule.count, nil
+}
+
+// New.
+func (rule *AmountRule) New(currency string, max int64) *AmountRule {
+	rule.currency = currency
+	rule.max = max
+	return rule
+}
+
+// Name.
+func (rule *AmountRule) Name() string {
+	if len(rule.currency) == 0 {
+		return fmt.Sprintf("amount(>%d)", rule.max)
+	}
+	return fmt.Sprintf("amount(>%d %s)", rule.max, core.NormalizeCurrency(rule.currency))
+}
+
+// Match matches the operations worth more than max, in currency if set.
+func (rule *AmountRule) Match(op *FraudOperation) (bool, error) {
+	if len(rule.currency) > 0 && core.NormalizeCurrency(rule.currency) != core.NormalizeCurrency(op.Currency) {
+		return false, nil
+	}
+	return op.Value > rule.max, nil
+}
+
+// New.
+func (rule *NewAccountRule) New(store *store.BankStore, age time.Duration, max int64) *NewAccountRule {
+	rule.store = store
+	rule.age = age
+	rule.max = max
+	return rule
+}
+
+// Name.
+func (rule *NewAccountRule) Name() string {
+	return fmt.Sprintf("new-account(<%s, >%d)", rule.age, rule.max)
+}
+
+// Match matches the operations worth more than max of the accounts opened within age. (Accounts opened before their
+// date was recorded are never new)
+func (rule *NewAccountRule) Match(op *FraudOperation) (bool, error) {
+	if op.Value <= rule.max {
+		return false, nil
+	}
+	opened, err := rule.store.ReadAccountOpened(op.Client)
+	if err != nil {
+		return false, err
+	}
+	return !opened.IsZero() && op.Date.Sub(opened) < rule.age, nil
+}
+
+//
+// OPERATION LIMITS
+//
+
+// 1. The bank limits the operations of each client within a rolling window, e.g. 20 coins withdrawn per day: unlike a
+//		VelocityRule, the operations served are recorded in the database (see store.WriteOperationCount), the limits
+//		hold across restarts and only count the operations served.
+// 2. The Withdrawal and Deposit servers check the limits of the account debited or credited before the fraud rules,
+//		and refuse the operations beyond them with a FraudReview holding a LimitExceeded: the client learns when to
+//		try again. No alert is raised.
+// 3. The concurrent operations of a client are checked against the same count, they may exceed the limit by the
+//		ones in flight.
+
+// New.
+func (limits *OperationLimits) New(store *store.BankStore) *OperationLimits {
+	limits.store = store
+	return limits
+}
+
+// Limit allows count operations of each client within window, of operation.
+func (limits *OperationLimits) Limit(operation store.Operation_Type, count int, window time.Duration) *OperationLimits {
+	if limits.limits == nil {
+		limits.limits = make(map[store.Operation_Type]operationLimit)
+	}
+	limits.limits[operation] = operationLimit{count: count, window: window}
+	return limits
+}
+
+// Check returns the limit exceeded by count operations of client, nil if they're allowed. Every operation is allowed if
+// limits is nil.
+func (limits *OperationLimits) Check(client *core.ClientProfile, operation store.Operation_Type, count int) (*LimitExceeded, error) {
+	if limits == nil {
+		return nil, nil
+	}
+	limit, ok := limits.limits[operation]
+	if !ok {
+		return nil, nil
+	}
+
+	now := time.Now()
+	served, oldest, err := limits.store.ReadOperationCount(client.Hash(), operation, now.Add(-limit.window))
+	if err != nil {
+		return nil, err
+	}
+	if served+count <= limit.count {
+		return nil, nil
+	}
+	retry := now
+	if !oldest.IsZero() {
+		retry = oldest.Add(limit.window)
+	}
+	return &LimitExceeded{Operation: operation, Count: limit.count, Window: limit.window, Retry: retry}, nil
+}
+
+// Record records count operations of client, served.
+func (limits *OperationLimits) Record(client *core.ClientProfile, operation store.Operation_Type, count int) error {
+	if limits == nil {
+		return nil
+	}
+	if _, ok := limits.limits[operation]; !ok {
+		return nil
+	}
+	return limits.store.WriteOperationCount(client.Hash(), operation, count)
+}
+
+// Prune deletes the operations recorded before the longest window.
+func (limits *OperationLimits) Prune() (int64, error) {
+	var longest time.Duration
+	for _, limit := range limits.limits {
+		longest = max(longest, limit.window)
+	}
+	return limits.store.DeleteOperationCounts(time.Now().Add(-longest))
+}
+
+// Error.
+func (limit *LimitExceeded) Error() string {
+	return fmt.Sprintf("%s refused: limit of %d per %s exceeded, try again after %s", limit.Operation, limit.Count,
+		limit.Window, limit.Retry.Local().Format(time.DateTime))
+}
+
+// Is matches ErrLimitExceeded.
+func (limit *LimitExceeded) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
 //
 // WITHDRAWAL (3/6)
 //
@@ -216,6 +716,24 @@ func (s *WithdrawalServer) New(store *store.BankStore, config *tls.Config) *With
 	return s
 }
 
+// Threshold makes the server compute coin responses using the bank nodes of threshold.
+func (s *WithdrawalServer) Threshold(threshold *ThresholdClient) *WithdrawalServer {
+	s.threshold = threshold
+	return s
+}
+
+// Fraud makes the server evaluate each withdrawal against rules.
+func (s *WithdrawalServer) Fraud(rules *FraudRules) *WithdrawalServer {
+	s.fraud = rules
+	return s
+}
+
+// Limits makes the server refuse the withdrawals beyond limits.
+func (s *WithdrawalServer) Limits(limits *OperationLimits) *WithdrawalServer {
+	s.limits = limits
+	return s
+}
+
 // Start.
 func (s *WithdrawalServer) Start() error {
 	// Start listening.
@@ -239,81 +757,239 @@ func (s *WithdrawalServer) Start() error {
 
 // handleClient.
 func (s *WithdrawalServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("WithdrawalServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
 	// Info message.
 	log.Print("Serving client [Withdrawal]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
+	trace.Phase(phaseStoreRead)
 	// Read Bank.
 	bank, err := s.store.ReadBank()
 	if err != nil {
 		log.Fatalf("failed to read Bank from database: %v", err)
 		return
 	}
+	bankProfile := bank.Profile()
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseEncode)
+	// SEND BankProfile. (Validated against the client's stored one)
+	if err := encoder.Encode(*bankProfile); err != nil {
+		log.Printf("failed to encode BankProfile message: %v", err)
+		return
+	}
+
+	// SEND nonce. (The coin request is signed over it)
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		log.Printf("failed to generate withdrawal nonce: %v", err)
+		return
+	}
+	if err := encoder.Encode(nonce); err != nil {
+		log.Printf("failed to encode nonce message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
 	// RECV client profile.
 	var client core.ClientProfile
 	if err := decoder.Decode(&client); err != nil {
-		log.Fatalf("failed to decode ClientProfile message: %v", err)
+		log.Printf("failed to decode ClientProfile message: %v", err)
 		return
 	}
 
-	// RECV coin request.
-	var request struct {
-		ALower *big.Int
-		C      *big.Int
-	}
+	// RECV coin request. (Idempotency token none from earlier versions)
+	var request core.WithdrawalRequest
 	if err := decoder.Decode(&request); err != nil {
-		log.Fatalf("failed to decode Withdrawal request message: %v", err)
+		log.Printf("failed to decode Withdrawal request message: %v", err)
 		return
 	}
 
-	// Read ClientInfo from database. (Check that exists)
-	clientInfo, err := s.store.ReadClientInfo(&client)
+	trace.Phase(phaseStoreRead)
+	// Read the mint of the requested currency.
+	mint, err := readMint(s.store, bank, s.threshold, request.Currency)
+	if err != nil {
+		log.Printf("failed to read mint for %q: %v", request.Currency, err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+	value := core.NormalizeValue(request.Value)
+	if err := core.ValidateValue(value); err != nil {
+		log.Printf("invalid Withdrawal request: %v", err)
+		return
+	}
+	if err := mint.Profile().ValidateCoinRequest(request.ALower, request.C); err != nil {
+		log.Printf("invalid Withdrawal request: %v", err)
+		return
+	}
+	if len(request.Token) > maxTokenLength {
+		log.Print("invalid Withdrawal request: idempotency token too long")
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists, with this very profile)
+	clientInfo := readAccount(s.store, &client)
 	if clientInfo == nil {
-		log.Fatalf("== ALERT: client does not exist in database: %v", err)
 		return
-	} else if err != nil && err != sql.ErrNoRows {
-		log.Fatalf("failed to read ClientInfo from database: %v", err)
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the request is signed by the account's stored key, in this session.
+	if err := request.Verify(nonce, &clientInfo.Profile); err != nil {
+		log.Printf("== ALERT: withdrawal request not signed by account %d: %v", client.Hash(), err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Answer a withdrawal already issued again, without debiting it twice.
+	status := &store.WithdrawalStatus{Token: request.Token, Client: client.Hash(), Currency: mint.Currency, Value: value}
+	if len(request.Token) > 0 {
+		issued, err := s.store.ReadWithdrawalStatus(&client, request.Token)
+		if err != nil {
+			log.Fatalf("failed to read WithdrawalStatus from database: %v", err)
+			return
+		}
+		if issued.Status == store.Status_Issued {
+			if issued.Value != value || issued.Currency != core.NormalizeCurrency(mint.Currency) {
+				log.Printf("== ALERT: withdrawal %s retried with another amount", request.Token)
+				return
+			}
+			log.Printf("Withdrawal %s already issued, answered again", request.Token)
+			issued.Validity = reissuedValidity(issued)
+			s.sendResponse(trace, encoder, FraudReview{}, issued)
+			return
+		}
+	}
+
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
+		return
+	}
+
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
 		return
 	}
 
 	// Grab client's balance.
-	balance, err := s.store.ReadClientBalance(&client)
+	balance, err := s.store.ReadClientBalance(&client, mint.Currency)
 	if err != nil {
 		log.Fatalf("failed to read client's balance from database: %v", err)
 		return
 	}
 
 	// Check if balance is sufficient.
-	if balance < 1 {
+	if balance < value {
 		log.Print("Insufficient funds")
 		return
 	}
 
-	// Update client's balance.
-	err = s.store.UpdateClientBalance(&client, balance-1)
+	// Check the limits, then evaluate the fraud rules.
+	var review FraudReview
+	limit, err := s.limits.Check(&client, store.Operation_Withdrawal, 1)
 	if err != nil {
-		log.Fatalf("failed to update client's balance into database: %v", err)
+		log.Printf("failed to read OperationCount from database: %v", err)
 		return
 	}
+	if limit != nil {
+		review = FraudReview{Held: true, Status: store.Alert_Rejected, Limit: limit}
+	} else {
+		op := &FraudOperation{Operation: store.Operation_Withdrawal, Client: &client, Currency: mint.Currency, Value: value, Date: time.Now()}
+		review = s.fraud.Evaluate(op)
+	}
+	if review.Held {
+		// Keep the withdrawal's status.
+		if len(request.Token) > 0 {
+			status.Status, status.Alert = store.Status_Refused, review.Alert
+			if review.Status == store.Alert_Pending {
+				status.Status = store.Status_Pending
+			}
+			if err := s.store.WriteWithdrawalStatus(status); err != nil {
+				log.Printf("failed to write WithdrawalStatus into database: %v", err)
+			}
+		}
 
-	// Compute coin response.
-	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, request.ALower, request.C)
+		trace.Phase(phaseEncode)
+		// SEND fraud review.
+		if err := encoder.Encode(review); err != nil {
+			log.Printf("failed to encode FraudReview message: %v", err)
+		}
+		if review.Limit != nil {
+			log.Printf("Withdrawal of client %d beyond its limit", client.Hash())
+		} else {
+			log.Print("Withdrawal held by the fraud rules")
+		}
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Compute coin response.
+	status.Expiration, status.A1, status.C1, err = coinResponse(mint, s.threshold, clientInfo, request.ALower, request.C, value)
+	if err != nil {
+		log.Printf("failed to compute coin response: %v", err)
+		return
+	}
+	status.Validity = core.Validity.Period(value, core.Now())
+
+	trace.Phase(phaseStoreWrite)
+	// Debit client's balance. (Along with the withdrawal's status, checked again against concurrent withdrawals)
+	if len(request.Token) > 0 {
+		err = s.store.IssueWithdrawal(&client, mint.Currency, value, status)
+	} else {
+		err = s.store.DebitClientBalance(&client, mint.Currency, value)
+	}
+	if err == store.ErrInsufficientFunds {
+		log.Print("Insufficient funds")
+		return
+	} else if err != nil {
+		log.Fatalf("failed to update client's balance into database: %v", err)
+		return
+	}
+	if err := s.store.WriteIssuance(mint.Currency, 1, value); err != nil {
+		log.Printf("failed to write Issuance into database: %v", err)
+	}
+	if err := s.limits.Record(&client, store.Operation_Withdrawal, 1); err != nil {
+		log.Printf("failed to write OperationCount into database: %v", err)
+	}
+
+	s.sendResponse(trace, encoder, review, status)
+}
 
+// sendResponse sends review and the coin response of the issued withdrawal of status.
+func (s *WithdrawalServer) sendResponse(trace *protocolTrace, encoder *gob.Encoder, review FraudReview, status *store.WithdrawalStatus) {
 	// Craft response.
 	response := struct {
 		Expiration time.Time
+		Validity   time.Duration
 		A1         *big.Int
 		C1         *big.Int
 	}{
-		Expiration: Expiration,
-		A1:         A1,
-		C1:         C1,
+		Expiration: status.Expiration,
+		Validity:   status.Validity,
+		A1:         status.A1,
+		C1:         status.C1,
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND fraud review.
+	if err := encoder.Encode(review); err != nil {
+		log.Printf("failed to encode FraudReview message: %v", err)
+		return
 	}
 
 	// SEND response.
@@ -335,38 +1011,172 @@ func (s *PaymentServer) New(store *store.ClientStore, config *tls.Config) *Payme
 	s.port = paymentPort
 	s.store = store
 	s.config = config
+	s.transport = new(TLSTransport).New(config)
 	return s
 }
 
-// Start.
+// Transport serves the payments over transport instead of TCP over TLS.
+func (s *PaymentServer) Transport(transport PaymentTransport) *PaymentServer {
+	s.transport = transport
+	return s
+}
+
+// Amount makes the server reject payments worth less than amount, or in another currency than currency (if not empty).
+// A payment is worth its coin's value, or the paid amount of a partial payment.
+func (s *PaymentServer) Amount(currency string, amount int64) *PaymentServer {
+	s.currency = currency
+	s.amount = amount
+	return s
+}
+
+// Approve asks approve to accept each payment, from the payer's address, before stamping its coin. The payment is
+// refused unless it returns true. The approved payments are recorded for the store's CheckPayerLimit.
+func (s *PaymentServer) Approve(approve func(payer, currency string, amount int64) bool) *PaymentServer {
+	s.approve = approve
+	return s
+}
+
+// Notify sends the amount of each accepted payment to paid, unless paid isn't ready to receive it.
+func (s *PaymentServer) Notify(paid chan<- int64) *PaymentServer {
+	s.paid = paid
+	return s
+}
+
+// WebSocket makes the server also take payments over WebSocket, from browser wallets, at PaymentWebSocketPath on port
+// 9106. The messages are the same, one binary frame each. (See the wasm directory)
+func (s *PaymentServer) WebSocket() *PaymentServer {
+	s.webSocket = true
+	return s
+}
+
+// Start. Returns once Shutdown is called.
 func (s *PaymentServer) Start() error {
+	// Delete expired payment sessions.
+	if err := s.store.DeleteExpiredPaymentSessions(); err != nil {
+		log.Printf("failed to delete expired payment sessions: %v", err)
+	}
+
 	// Start listening.
-	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	listener, err := s.transport.Listen()
 	if err != nil {
 		log.Fatalf("failed to start Payment server: %v", err)
 		return err
 	}
 
-	log.Printf("Payment server listening on port %d", s.port)
+	// Keep listener for Shutdown.
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	// Take payments over WebSocket (if enabled).
+	if s.webSocket {
+		if err := s.serveWebSocket(); err != nil {
+			log.Printf("failed to start Payment server over WebSocket: %v", err)
+			listener.Close()
+			return err
+		}
+	}
 
 	for {
-		conn, err := listener.Accept()
-		if err != nil {
+		stream, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
 			log.Fatalf("failed to accept connection: %v", err)
 			continue
 		}
-		go s.handleClient(conn)
+		s.handlers.Add(1)
+		go func() {
+			defer s.handlers.Done()
+			s.handleClient(streamConn(stream))
+		}()
+	}
+}
+
+// Shutdown stops accepting payments, and waits for the payments being served to finish.
+func (s *PaymentServer) Shutdown() error {
+	s.mutex.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if s.webServer != nil {
+		s.webServer.Close()
+	}
+	s.mutex.Unlock()
+
+	s.handlers.Wait()
+	return err
+}
+
+// webSocketConn is a WebSocket connection, addressed by its peer's address instead of its origin.
+type webSocketConn struct {
+	*websocket.Conn
+	addr net.Addr
+}
+
+// RemoteAddr.
+func (conn *webSocketConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+// serveWebSocket serves the payments taken over WebSocket, in the background until Shutdown.
+func (s *PaymentServer) serveWebSocket() error {
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", webSocketPort), s.config)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(PaymentWebSocketPath, websocket.Handler(func(ws *websocket.Conn) {
+		s.handlers.Add(1)
+		defer s.handlers.Done()
+
+		ws.PayloadType = websocket.BinaryFrame
+		addr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr)
+		if err != nil {
+			ws.Close()
+			return
+		}
+		s.handleClient(&webSocketConn{Conn: ws, addr: addr})
+	}))
+
+	// Keep server for Shutdown.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return listener.Close()
 	}
+	s.webServer = &http.Server{Handler: mux}
+	go s.webServer.Serve(listener)
+
+	log.Printf("Payment server listening on port %d over WebSocket, at %s", webSocketPort, PaymentWebSocketPath)
+	return nil
 }
 
 // handleClient.
 func (s *PaymentServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("PaymentServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Record session (if enabled).
+	transcript := newTranscript("PaymentServer")
+	defer transcript.Close()
+
 	// Info message.
 	log.Print("Serving client [Payment]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
+	trace.Phase(phaseStoreRead)
 	// Read Client.
 	client, err := s.store.ReadClient()
 	if err != nil {
@@ -374,48 +1184,218 @@ func (s *PaymentServer) handleClient(conn net.Conn) {
 		return
 	}
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseDecode)
 	// RECV CoinProfile.
 	var coin core.CoinProfile
 	if err := decoder.Decode(&coin); err != nil {
-		log.Fatalf("failed to decode CoinProfile message: %v", err)
+		log.Printf("failed to decode CoinProfile message: %v", err)
+		return
+	}
+	transcript.Record(TranscriptRecv, "CoinProfile", &coin)
+
+	// RECV escrow conditions, memo, change (if any) and idempotency token.
+	var request paymentRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Escrow request message: %v", err)
 		return
 	}
+	transcript.Record(TranscriptRecv, "Request", request)
+
+	trace.Phase(phaseStoreRead)
+	// Read the mint profile of the coin's currency.
+	mint, err := s.store.ReadMint(client, coin.Currency)
+	if err != nil {
+		log.Printf("failed to read mint for %q: %v", coin.Currency, err)
+		return
+	}
+	transcript.Record(TranscriptNote, "Mint", mint)
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := mint.ValidateCoin(&coin); err != nil {
+		log.Printf("invalid CoinProfile: %v", err)
+		return
+	}
+	var memo *core.Memo
+	if request.Escrow != nil && len(request.Memo) > 0 {
+		log.Print("escrowed payments can't carry a memo")
+		return
+	}
+	if request.Escrow != nil && request.Change != nil {
+		log.Print("escrowed payments can't be partial")
+		return
+	}
+	if request.Escrow == nil {
+		// Non-escrowed payments are bound to this client's account by a (possibly empty) memo.
+		if memo, err = core.NewMemo(request.Memo); err != nil {
+			log.Printf("invalid memo: %v", err)
+			return
+		}
+	}
+	if request.Change != nil {
+		if err := mint.ValidateChange(request.Change); err != nil {
+			log.Printf("invalid Change: %v", err)
+			return
+		}
+		if err := request.Change.Verify(&coin); err != nil {
+			log.Printf("invalid Change: %v", err)
+			return
+		}
+		memo.Change = request.Change
+	}
 
 	// Verify coin properties.
-	if valid := coin.VerifyProperties(&client.Bank); !valid {
+	if valid := coin.VerifyProperties(mint); !valid {
 		log.Print("invalid Coin")
 		return
 	}
 
-	// Stamp coin.
-	msg := coin.Stamp(&client.Bank, client.Profile())
+	// Refuse expired coins. (The bank wouldn't take their deposit)
+	if err := core.CheckUnexpired(coin.Expiration, core.Now()); err != nil {
+		log.Printf("Payment refused: coin expired on %s", coin.Expiration.Format(time.DateTime))
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the payment's session, resumed if stamped already. (See PAYMENT SESSIONS)
+	var machine *paymentMachine
+	if len(request.Token) > 0 {
+		record, err := s.store.ReadPaymentSession(request.Token, true)
+		if err != nil {
+			log.Fatalf("failed to read payment session from database: %v", err)
+			return
+		}
+		if record != nil && record.Coin != coin.Hash() {
+			log.Printf("== ALERT: payment %s resumed with another coin %d", request.Token, coin.Hash())
+			return
+		}
+		if record != nil {
+			if machine, err = loadPaymentMachine(s.store, record); err != nil {
+				log.Printf("failed to resume payment %s: %v", request.Token, err)
+				return
+			}
+			log.Printf("Payment %s resumed (%s)", request.Token, record.State)
+		}
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the expected amount.
+	amount := core.NormalizeValue(coin.Value)
+	if request.Change != nil {
+		amount = request.Change.Amount
+	}
+	if len(s.currency) > 0 && core.NormalizeCurrency(coin.Currency) != core.NormalizeCurrency(s.currency) {
+		log.Printf("Payment refused: paid in %s, expected %s", core.NormalizeCurrency(coin.Currency), core.NormalizeCurrency(s.currency))
+		return
+	}
+	if amount < s.amount {
+		log.Printf("Payment refused: underpayment of %d out of %d", amount, s.amount)
+		return
+	}
+
+	// Ask for approval.
+	payer, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		payer = conn.RemoteAddr().String()
+	}
+	if machine == nil && s.approve != nil && !s.approve(payer, coin.Currency, amount) {
+		log.Printf("Payment of %d from %s declined", amount, payer)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Check the bank's revocation list. (If fetched)
+	revocations, err := s.store.ReadRevocations()
+	if err != nil {
+		log.Fatalf("failed to read Revocations from database: %v", err)
+		return
+	}
+	if revocations != nil {
+		if revocations.Revoked(&coin) {
+			log.Printf("== ALERT: coin %d is revoked", coin.Hash())
+			return
+		}
+		if revocations.Frozen(client.Profile()) {
+			log.Print("account is frozen, payments can't be deposited")
+			return
+		}
+	}
+
+	trace.Phase(phaseCrypto)
+	// Stamp coin. (The stamp recorded if resumed, the payer signed it)
+	var msg *big.Int
+	if machine != nil {
+		msg, memo = machine.session.Stamp.Msg, machine.session.Stamp.Memo
+	} else if request.Escrow != nil {
+		msg = coin.StampEscrow(mint, client.Profile(), request.Escrow)
+	} else {
+		msg = coin.StampMemo(mint, client.Profile(), memo)
+	}
+
+	// Craft stamp.
+	stamp := paymentStamp{
+		Msg:    msg,
+		Escrow: request.Escrow,
+		Memo:   memo,
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Keep the payment's session, to answer it again if resumed.
+	if machine == nil && len(request.Token) > 0 {
+		machine = &paymentMachine{store: s.store, record: store.PaymentSession{Token: request.Token, Payee: true, Peer: payer, Coin: coin.Hash()}}
+		machine.session.Request, machine.session.Stamp = request, stamp
+		if err := machine.advance(store.State_Stamped); err != nil {
+			log.Fatalf("failed to write payment session into database: %v", err)
+			return
+		}
+	}
 
+	trace.Phase(phaseEncode)
 	// SEND Elgamal's msg.
-	if err := encoder.Encode(msg); err != nil {
+	transcript.Record(TranscriptSend, "Stamp", stamp)
+	if err := encoder.Encode(stamp); err != nil {
 		log.Fatalf("failed to encode Elgamal's msg message: %v", err)
 		return
 	}
 
+	trace.Phase(phaseDecode)
 	// RECV Elgamal's second.
 	var second *big.Int
 	if err := decoder.Decode(&second); err != nil {
-		log.Fatalf("failed to decode Elgamal's second message: %v", err)
+		log.Printf("failed to decode Elgamal's second message: %v", err)
+		return
+	}
+	transcript.Record(TranscriptRecv, "Second", redact(second))
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := client.Bank.ValidateSecond(second); err != nil {
+		log.Printf("invalid Elgamal's second: %v", err)
 		return
 	}
 
 	// Verify Elgamal signature.
-	if valid := coin.VerifyElgamal(&client.Bank, second); !valid {
+	if valid := coin.VerifyElgamal(mint, second); !valid {
 		log.Fatalf("invalid Elgamal's signature")
 		return
 	}
 
+	trace.Phase(phaseEncode)
 	// SEND acceptance.
 	accept := true
+	transcript.Record(TranscriptSend, "Acceptance", accept)
 	encoder.Encode(accept)
 
+	// A resumed payment is received once.
+	if machine != nil && machine.record.State == store.State_Accepted {
+		log.Printf("Payment %s was received already", request.Token)
+		return
+	}
+
+	trace.Phase(phaseStoreWrite)
 	// Write coin.
 	newCoin := core.Coin{
 		Random: core.CoinRandom{},
@@ -430,13 +1410,64 @@ func (s *PaymentServer) handleClient(conn net.Conn) {
 			A2:         coin.A2,
 			R:          coin.R,
 			Expiration: coin.Expiration,
+			Version:    coin.Version,
+			Currency:   coin.Currency,
+			Value:      coin.Value,
 		},
 	}
-	if err := s.store.WriteCoin(&newCoin, store.Operation_Payment); err != nil {
+	if err := s.store.WriteCoin(&newCoin, store.Operation_Payment); err == store.ErrExistingCoin {
+		log.Print("== ALERT: paid coin was already received")
+		return
+	} else if err != nil {
 		log.Fatalf("failed to write Coin into database: %v", err)
 		return
 	}
 
+	// Write escrow conditions.
+	if request.Escrow != nil {
+		if err := s.store.WriteEscrow(&newCoin, request.Escrow, nil, nil); err != nil {
+			log.Fatalf("failed to write Escrow into database: %v", err)
+			return
+		}
+		log.Printf("Escrowed payment until %s", request.Escrow.Timeout)
+	}
+
+	// Write memo.
+	if memo != nil {
+		if err := s.store.WriteMemo(&newCoin, memo); err != nil {
+			log.Fatalf("failed to write Memo into database: %v", err)
+			return
+		}
+		if len(memo.Text) > 0 {
+			log.Printf("Payment memo: %q", memo.Text)
+		}
+		if memo.Change != nil {
+			log.Printf("Partial payment: %d out of %d", memo.Change.Amount, core.NormalizeValue(coin.Value))
+		}
+	}
+
+	// End the payment's session. (Kept to answer it again if resumed)
+	if machine != nil {
+		if err := machine.advance(store.State_Accepted); err != nil {
+			log.Printf("failed to write payment session into database: %v", err)
+		}
+	}
+
+	// Record approved payment.
+	if s.approve != nil {
+		if err := s.store.WritePayerPayment(payer, coin.Currency, amount); err != nil {
+			log.Printf("failed to write payer's payment into database: %v", err)
+		}
+	}
+
+	// Notify payment.
+	if s.paid != nil {
+		select {
+		case s.paid <- amount:
+		default:
+		}
+	}
+
 	// Info message.
 	log.Print("Finished serving client [Payment]")
 }
@@ -453,6 +1484,24 @@ func (s *DepositServer) New(store *store.BankStore, config *tls.Config) *Deposit
 	return s
 }
 
+// RequireBinding makes the server reject non-escrowed coins that aren't bound to the depositing account.
+func (s *DepositServer) RequireBinding(require bool) *DepositServer {
+	s.requireBinding = require
+	return s
+}
+
+// Fraud makes the server evaluate each deposit against rules.
+func (s *DepositServer) Fraud(rules *FraudRules) *DepositServer {
+	s.fraud = rules
+	return s
+}
+
+// Limits makes the server refuse the deposits beyond limits.
+func (s *DepositServer) Limits(limits *OperationLimits) *DepositServer {
+	s.limits = limits
+	return s
+}
+
 // Start.
 func (s *DepositServer) Start() error {
 	// Start listening.
@@ -476,12 +1525,18 @@ func (s *DepositServer) Start() error {
 
 // handleClient.
 func (s *DepositServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("DepositServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
 	// Info message.
 	log.Print("Serving client [Deposit]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
+	trace.Phase(phaseStoreRead)
 	// Read Bank.
 	bank, err := s.store.ReadBank()
 	if err != nil {
@@ -490,16 +1545,32 @@ func (s *DepositServer) handleClient(conn net.Conn) {
 	}
 	bankProfile := bank.Profile()
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseEncode)
+	// SEND BankProfile. (Validated against the client's stored one)
+	if err := encoder.Encode(*bankProfile); err != nil {
+		log.Printf("failed to encode BankProfile message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
 	// RECV client profile.
 	var client core.ClientProfile
 	if err := decoder.Decode(&client); err != nil {
-		log.Fatalf("failed to decode ClientProfile message: %v", err)
+		log.Printf("failed to decode ClientProfile message: %v", err)
 		return
 	}
 
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
 	// Read ClientInfo from database. (Check that exists)
 	clientInfo, err := s.store.ReadClientInfo(&client)
 	if clientInfo == nil {
@@ -510,50 +1581,248 @@ func (s *DepositServer) handleClient(conn net.Conn) {
 		return
 	}
 
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
+		return
+	}
+
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
+		return
+	}
+
+	trace.Phase(phaseDecode)
 	// RECV coin profile.
 	var coin core.CoinProfile
 	if err := decoder.Decode(&coin); err != nil {
-		log.Fatalf("failed to decode CoinProfile message: %v", err)
+		log.Printf("failed to decode CoinProfile message: %v", err)
+		return
+	}
+
+	// RECV escrow release, memo and beneficiary (if any).
+	var release struct {
+		Escrow      *core.Escrow
+		Release     *big.Int `ziba:"optional"`
+		Memo        *core.Memo
+		Beneficiary *core.DepositAuthorization
+	}
+	if err := decoder.Decode(&release); err != nil {
+		log.Printf("failed to decode Escrow release message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the mint of the coin's currency.
+	mint, err := s.store.ReadMint(coin.Currency)
+	if err != nil {
+		log.Printf("failed to read mint for %q: %v", coin.Currency, err)
+		return
+	}
+	mintProfile := mint.Profile()
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := mintProfile.ValidateCoin(&coin); err != nil {
+		log.Printf("invalid CoinProfile: %v", err)
+		return
 	}
 
 	// Verify coin properties.
-	if valid := coin.VerifyProperties(bankProfile); !valid {
-		log.Fatalf("invalid coin")
+	if valid := coin.VerifyProperties(mintProfile); !valid {
+		recordDispute(s.store, core.NewInvalidCoin(mintProfile, &coin), store.Operation_Deposit, &client)
+		log.Print("invalid coin")
+
+		trace.Phase(phaseEncode)
+		// SEND fraud review, refusing the deposit.
+		if err := encoder.Encode(FraudReview{Held: true, Status: store.Alert_Rejected}); err != nil {
+			log.Printf("failed to encode FraudReview message: %v", err)
+		}
 		return
 	}
 
-	// Read coin profile from database. (Check if already in database)
-	err = s.store.ReadCoinProfile(&coin)
-	if err == sql.ErrNoRows {
-		// all good
-	} else if err != nil {
-		log.Fatalf("failed to read CoinProfile from database: %v", err)
+	// Reject expired coins. (Only exchanged during their grace period)
+	if err := core.CheckUnexpired(coin.Expiration, core.Now()); err != nil {
+		log.Printf("invalid coin: %v", err)
 		return
 	}
 
-	// Write coin profile into database.
-	if err := s.store.WriteCoinProfile(&coin, store.Operation_Deposit, &client); err != nil {
-		log.Fatalf("failed to write CoinProfile into database: %v", err)
+	// Verify escrow conditions for escrowed coins.
+	if core.IsEscrowMsg(coin.Msg) {
+		if valid := coin.VerifyEscrow(mintProfile, release.Escrow); !valid {
+			log.Print("invalid escrowed coin")
+			return
+		}
+		if release.Escrow.Payee.Cmp(client.TradeId) != 0 {
+			log.Print("escrowed coin is not payable to this client")
+			return
+		}
+		if err := release.Escrow.VerifyRelease(release.Release, time.Now()); err != nil {
+			log.Printf("failed to release escrowed coin: %v", err)
+			return
+		}
+	}
+
+	// Verify the memo the coin was signed to (if any).
+	if release.Memo != nil {
+		if valid := coin.VerifyMemo(mintProfile, release.Memo); !valid {
+			log.Print("invalid memo")
+			return
+		}
+		if release.Memo.Payee.Cmp(client.TradeId) != 0 {
+			log.Print("memo is not payable to this client")
+			return
+		}
+		if release.Memo.Account != nil && !release.Memo.VerifyAccount(&client) {
+			log.Print("memo is bound to another account")
+			return
+		}
+		if change := release.Memo.Change; change != nil {
+			if err := mintProfile.ValidateChange(change); err != nil {
+				log.Printf("invalid Change: %v", err)
+				return
+			}
+			if err := change.Verify(&coin); err != nil {
+				log.Printf("invalid Change: %v", err)
+				return
+			}
+		}
+	}
+
+	// Require the coin to be bound to the depositing account.
+	if s.requireBinding && !core.IsEscrowMsg(coin.Msg) && (release.Memo == nil || release.Memo.Account == nil) {
+		log.Print("coin is not bound to this client's account")
 		return
 	}
 
-	// Grab client's balance.
-	balance, err := s.store.ReadClientBalance(&client)
-	if err != nil {
-		log.Fatalf("failed to read client's balance from database: %v", err)
+	// Credit the beneficiary's account instead, if the client deposits on its behalf.
+	credited := &client
+	if auth := release.Beneficiary; auth != nil {
+		if err := bankProfile.ValidateClient(&auth.Beneficiary); err != nil {
+			log.Printf("invalid beneficiary: %v", err)
+			return
+		}
+		if err := auth.Verify(&client); err != nil {
+			log.Printf("invalid Deposit authorization: %v", err)
+			return
+		}
+
+		trace.Phase(phaseStoreRead)
+		// Read beneficiary's ClientInfo from database. (Check that exists)
+		beneficiaryInfo, err := s.store.ReadClientInfo(&auth.Beneficiary)
+		if beneficiaryInfo == nil {
+			log.Printf("beneficiary does not exist in database: %v", err)
+			return
+		} else if err != nil && err != sql.ErrNoRows {
+			log.Fatalf("failed to read ClientInfo from database: %v", err)
+			return
+		}
+		if accountClosed(s.store, &auth.Beneficiary) {
+			return
+		}
+		credited = &auth.Beneficiary
+	}
+
+	// Check the coin wasn't already spent. (Before holding it for review, WriteCoinProfile tells for sure)
+	if spent, err := alreadySpent(s.store, []*core.CoinProfile{&coin}); err != nil {
+		log.Fatalf("failed to read CoinProfile from database: %v", err)
+		return
+	} else if spent != nil {
+		log.Print("== ALERT: deposited coin was already spent")
+		recordDoubleSpend(s.store, mintProfile, []*core.CoinProfile{&coin}, store.Operation_Deposit, &client)
+
+		trace.Phase(phaseEncode)
+		// SEND fraud review. (Not evaluated)
+		if err := encoder.Encode(FraudReview{}); err != nil {
+			log.Printf("failed to encode FraudReview message: %v", err)
+			return
+		}
+		s.refuseSpent(trace, encoder, spent)
 		return
 	}
 
-	// Update client's balance.
-	err = s.store.UpdateClientBalance(&client, balance+1)
+	// Check the limits, then evaluate the fraud rules.
+	var review FraudReview
+	limit, err := s.limits.Check(credited, store.Operation_Deposit, 1)
 	if err != nil {
+		log.Printf("failed to read OperationCount from database: %v", err)
+		return
+	}
+	if limit != nil {
+		review = FraudReview{Held: true, Status: store.Alert_Rejected, Limit: limit}
+	} else {
+		op := &FraudOperation{Operation: store.Operation_Deposit, Client: credited, Currency: mint.Currency,
+			Value: coin.Credit(release.Memo), Date: time.Now()}
+		review = s.fraud.Evaluate(op)
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND fraud review.
+	if err := encoder.Encode(review); err != nil {
+		log.Printf("failed to encode FraudReview message: %v", err)
+		return
+	}
+	if review.Limit != nil {
+		log.Printf("Deposit to client %d beyond its limit", credited.Hash())
+		return
+	} else if review.Held {
+		log.Print("Deposit held by the fraud rules")
+		return
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Write coin profile into database. (Fails if the coin was already spent)
+	err = s.store.WriteCoinProfile(&coin, store.Operation_Deposit, &client)
+	if err == store.ErrExistingCoin {
+		log.Print("== ALERT: deposited coin was already spent")
+		recordDoubleSpend(s.store, mintProfile, []*core.CoinProfile{&coin}, store.Operation_Deposit, &client)
+
+		spent, err := s.store.ReadCoinRecord(&coin)
+		if err != nil {
+			log.Printf("failed to read CoinProfile from database: %v", err)
+			return
+		}
+		s.refuseSpent(trace, encoder, spent)
+		return
+	} else if err != nil {
+		log.Fatalf("failed to write CoinProfile into database: %v", err)
+		return
+	}
+
+	// Write memo into database.
+	if release.Memo != nil {
+		if err := s.store.WriteCoinMemo(&coin, release.Memo); err != nil {
+			log.Fatalf("failed to write Memo into database: %v", err)
+			return
+		}
+	}
+
+	// Keep the remainder coin request of a partial payment, until its payer collects it.
+	if release.Memo != nil && release.Memo.Change != nil {
+		change := &store.PendingChange{
+			Change:   *release.Memo.Change,
+			Value:    release.Memo.Change.Remainder(&coin),
+			Currency: mint.Currency,
+		}
+		if err := s.store.WriteChange(change); err != nil {
+			log.Fatalf("failed to write Change into database: %v", err)
+			return
+		}
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Credit the credited client's balance. (At once, along with concurrent withdrawals and transfers)
+	if _, err := s.store.CreditClientBalance(credited, mint.Currency, coin.Credit(release.Memo)); err != nil {
 		log.Fatalf("failed to update client's balance into database: %v", err)
 		return
 	}
+	if err := s.limits.Record(credited, store.Operation_Deposit, 1); err != nil {
+		log.Printf("failed to write OperationCount into database: %v", err)
+	}
 
 	// Craft response.
 	accept := true
 
+	trace.Phase(phaseEncode)
 	// SEND response.
 	if err := encoder.Encode(accept); err != nil {
 		log.Fatalf("failed to encode Response message: %v", err)
@@ -564,6 +1833,22 @@ func (s *DepositServer) handleClient(conn net.Conn) {
 	log.Print("Finished serving client [Deposit]")
 }
 
+// refuseSpent refuses the deposit of a coin already spent, as recorded by spent, telling the client how and when it
+// was surrendered.
+func (s *DepositServer) refuseSpent(trace *protocolTrace, encoder *gob.Encoder, spent *store.CoinRecord) {
+	trace.Phase(phaseEncode)
+	// SEND response.
+	if err := encoder.Encode(false); err != nil {
+		log.Printf("failed to encode Response message: %v", err)
+		return
+	}
+
+	// SEND spent coin.
+	if err := encoder.Encode(spentCoin{Operation: spent.Operation, Date: spent.Date}); err != nil {
+		log.Printf("failed to encode SpentCoin message: %v", err)
+	}
+}
+
 //
 // EXCHANGE (6/6)
 //
@@ -576,6 +1861,12 @@ func (s *ExchangeServer) New(store *store.BankStore, config *tls.Config) *Exchan
 	return s
 }
 
+// Threshold makes the server compute coin responses using the bank nodes of threshold.
+func (s *ExchangeServer) Threshold(threshold *ThresholdClient) *ExchangeServer {
+	s.threshold = threshold
+	return s
+}
+
 // Start.
 func (s *ExchangeServer) Start() error {
 	// Start listening.
@@ -599,33 +1890,336 @@ func (s *ExchangeServer) Start() error {
 
 // handleClient.
 func (s *ExchangeServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("ExchangeServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
 	// Info message.
 	log.Print("Serving client [Exchange]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
+	if err != nil {
+		log.Fatalf("failed to read Bank from database: %v", err)
+		return
+	}
+	bankProfile := bank.Profile()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND BankProfile. (Validated against the client's stored one)
+	if err := encoder.Encode(*bankProfile); err != nil {
+		log.Printf("failed to encode BankProfile message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV client profile.
+	var client core.ClientProfile
+	if err := decoder.Decode(&client); err != nil {
+		log.Printf("failed to decode ClientProfile message: %v", err)
+		return
+	}
+
+	// RECV coin profiles. (The surrendered coins, all in one currency)
+	var coins []core.CoinProfile
+	if err := decoder.Decode(&coins); err != nil {
+		log.Printf("failed to decode CoinProfile message: %v", err)
+		return
+	}
+	if len(coins) == 0 || len(coins) > maxExchangeCoins {
+		log.Printf("invalid Exchange request: %d coins", len(coins))
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the mint of the coins' currency.
+	mint, err := readMint(s.store, bank, s.threshold, coins[0].Currency)
+	if err != nil {
+		log.Printf("failed to read mint for %q: %v", coins[0].Currency, err)
+		return
+	}
+	mintProfile := mint.Profile()
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+	var (
+		surrendered int64
+		graces      []*core.CoinProfile // Expired, exchanged during their grace period.
+	)
+	for i := range coins {
+		if err := mintProfile.ValidateCoin(&coins[i]); err != nil {
+			log.Printf("invalid CoinProfile: %v", err)
+			return
+		}
+		grace, err := core.Validity.CheckExchange(coins[i].Expiration, core.Now())
+		if err != nil {
+			log.Printf("invalid Exchange request: %v", err)
+			return
+		}
+		if grace {
+			graces = append(graces, &coins[i])
+		}
+		surrendered += core.NormalizeValue(coins[i].Value)
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV target currency.
+	var currency string
+	if err := decoder.Decode(&currency); err != nil {
+		log.Printf("failed to decode Exchange currency message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the mint of the target currency and the exchange rate.
+	target, err := readMint(s.store, bank, s.threshold, currency)
+	if err != nil {
+		log.Printf("failed to read mint for %q: %v", currency, err)
+		return
+	}
+	targetProfile := target.Profile()
+	rate, err := s.store.ReadRate(mint.Currency, target.Currency)
+	if err != nil {
+		log.Printf("failed to read exchange rate: %v", err)
+		return
+	}
+	total, err := rate.Convert(surrendered)
+	if err != nil {
+		log.Printf("invalid Exchange request: %v", err)
+		return
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND exchange rate.
+	if err := encoder.Encode(*rate); err != nil {
+		log.Printf("failed to encode Rate message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV coin requests. (The converted value is split among them)
+	var requests []coinRequest
+	if err := decoder.Decode(&requests); err != nil {
+		log.Printf("failed to decode Exchange request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values. (The requested values never exceed the converted value, summing them can't overflow)
+	if len(requests) > maxExchangeCoins {
+		log.Printf("invalid Exchange request: %d coin requests", len(requests))
+		return
+	}
+	var requested int64
+	for _, request := range requests {
+		if err := core.ValidateValue(request.Value); err != nil {
+			log.Printf("invalid Exchange request: %v", err)
+			return
+		}
+		if err := targetProfile.ValidateCoinRequest(request.ALower, request.C); err != nil {
+			log.Printf("invalid Exchange request: %v", err)
+			return
+		}
+		if request.Value > total-requested {
+			log.Printf("== ALERT: invalid Exchange request: requested more than %d", total)
+			return
+		}
+		requested += request.Value
+	}
+	if len(requests) == 0 || requested != total {
+		log.Printf("invalid Exchange request: requested %d out of %d", requested, total)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists)
+	clientInfo, err := s.store.ReadClientInfo(&client)
+	if clientInfo == nil {
+		log.Fatalf("== ALERT: client does not exist in database: %v", err)
+		return
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Fatalf("failed to read ClientInfo from database: %v", err)
+		return
+	}
+
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
+		return
+	}
+
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Verify coins.
+	profiles := make([]*core.CoinProfile, len(coins))
+	for i := range coins {
+		if valid := coins[i].VerifyProperties(mintProfile); !valid {
+			recordDispute(s.store, core.NewInvalidCoin(mintProfile, &coins[i]), store.Operation_Exchange, &client)
+			log.Printf("invalid coin")
+			return
+		}
+		profiles[i] = &coins[i]
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Check no coin was already spent. (Before computing the coin responses, WriteCoinProfiles tells for sure)
+	if spent, err := alreadySpent(s.store, profiles); err != nil {
+		log.Fatalf("failed to read CoinProfile from database: %v", err)
+		return
+	} else if spent != nil {
+		log.Print("== ALERT: exchanged coin was already spent")
+		recordDoubleSpend(s.store, mintProfile, profiles, store.Operation_Exchange, &client)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Compute coin responses.
+	responses := make([]coinResponseMsg, len(requests))
+	for i, request := range requests {
+		Expiration, A1, C1, err := coinResponse(target, s.threshold, clientInfo, request.ALower, request.C, request.Value)
+		if err != nil {
+			log.Printf("failed to compute coin response: %v", err)
+			return
+		}
+		responses[i] = coinResponseMsg{Expiration: Expiration, Validity: core.Validity.Period(request.Value, core.Now()), A1: A1, C1: C1}
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Write coin profiles into database. (Fails if any coin was already spent)
+	err = s.store.WriteCoinProfiles(profiles, store.Operation_Exchange, &client)
+	if err == store.ErrExistingCoin {
+		log.Print("== ALERT: exchanged coin was already spent")
+		recordDoubleSpend(s.store, mintProfile, profiles, store.Operation_Exchange, &client)
+		return
+	} else if err != nil {
+		log.Fatalf("failed to write CoinProfile into database: %v", err)
+		return
+	}
+	if err := s.store.WriteIssuance(target.Currency, int64(len(requests)), total); err != nil {
+		log.Printf("failed to write Issuance into database: %v", err)
+	}
+
+	// Record the coins exchanged during their grace period.
+	if len(graces) > 0 {
+		log.Printf("Exchanging %d expired coins during their grace period", len(graces))
+		if err := s.store.WriteGraceExchange(graces, &client); err != nil {
+			log.Printf("failed to write GraceExchange into database: %v", err)
+		}
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND coin responses.
+	if err := encoder.Encode(responses); err != nil {
+		log.Printf("failed to encode Exchange response message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Printf("Exchanged %d %s for %d %s", surrendered, rate.From, total, rate.To)
+	log.Print("Finished serving client [Exchange]")
+}
+
+//
+// RECLAIM
+//
+
+// New.
+func (s *ReclaimServer) New(store *store.BankStore, config *tls.Config) *ReclaimServer {
+	s.port = reclaimPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Threshold makes the server compute coin responses using the bank nodes of threshold.
+func (s *ReclaimServer) Threshold(threshold *ThresholdClient) *ReclaimServer {
+	s.threshold = threshold
+	return s
+}
+
+// Start.
+func (s *ReclaimServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Reclaim server: %v", err)
+		return err
+	}
+
+	log.Printf("Reclaim server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *ReclaimServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("ReclaimServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Reclaim]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	trace.Phase(phaseStoreRead)
 	// Read Bank.
 	bank, err := s.store.ReadBank()
 	if err != nil {
 		log.Fatalf("failed to read Bank from database: %v", err)
 		return
 	}
+	bankProfile := bank.Profile()
 
-	decoder := gob.NewDecoder(conn)
+	decoder := newDecoder(conn)
 	encoder := gob.NewEncoder(conn)
 
+	trace.Phase(phaseDecode)
 	// RECV client profile.
 	var client core.ClientProfile
 	if err := decoder.Decode(&client); err != nil {
-		log.Fatalf("failed to decode ClientProfile message: %v", err)
+		log.Printf("failed to decode ClientProfile message: %v", err)
 		return
 	}
 
 	// RECV coin profile.
 	var coin core.CoinProfile
 	if err := decoder.Decode(&coin); err != nil {
-		log.Fatalf("failed to decode CoinProfile message: %v", err)
+		log.Printf("failed to decode CoinProfile message: %v", err)
+		return
+	}
+
+	// RECV escrow refund.
+	var refund struct {
+		Escrow *core.Escrow
+		Refund *big.Int
+	}
+	if err := decoder.Decode(&refund); err != nil {
+		log.Printf("failed to decode Escrow refund message: %v", err)
 		return
 	}
 
@@ -635,97 +2229,147 @@ func (s *ExchangeServer) handleClient(conn net.Conn) {
 		C      *big.Int
 	}
 	if err := decoder.Decode(&request); err != nil {
-		log.Fatalf("failed to decode Exchange request message: %v", err)
+		log.Printf("failed to decode Reclaim request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the mint of the coin's currency. (The new coin is in the same currency)
+	mint, err := readMint(s.store, bank, s.threshold, coin.Currency)
+	if err != nil {
+		log.Printf("failed to read mint for %q: %v", coin.Currency, err)
+		return
+	}
+	mintProfile := mint.Profile()
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+	if err := mintProfile.ValidateCoin(&coin); err != nil {
+		log.Printf("invalid CoinProfile: %v", err)
+		return
+	}
+	if err := mintProfile.ValidateCoinRequest(request.ALower, request.C); err != nil {
+		log.Printf("invalid Reclaim request: %v", err)
 		return
 	}
 
+	trace.Phase(phaseStoreRead)
 	// Read ClientInfo from database. (Check that exists)
 	clientInfo, err := s.store.ReadClientInfo(&client)
 	if clientInfo == nil {
-		log.Fatalf("== ALERT: client does not exist in database: %v", err)
+		log.Printf("== ALERT: client does not exist in database: %v", err)
 		return
 	} else if err != nil && err != sql.ErrNoRows {
 		log.Fatalf("failed to read ClientInfo from database: %v", err)
 		return
 	}
 
-	// Verify coin.
-	if valid := coin.VerifyProperties(bank.Profile()); !valid {
-		log.Fatalf("invalid coin")
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
 		return
 	}
 
-	// Read coin profile from database. (Check if already in database)
-	err = s.store.ReadCoinProfile(&coin)
-	if err == sql.ErrNoRows {
-		// all good
-	} else if err != nil {
-		log.Fatalf("failed to read CoinProfile from database: %v", err)
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
 		return
 	}
 
-	// Write coin profile into database.
-	if err := s.store.WriteCoinProfile(&coin, store.Operation_Exchange, &client); err != nil {
-		log.Fatalf("failed to write CoinProfile into database: %v", err)
+	trace.Phase(phaseCrypto)
+	// Verify coin.
+	if valid := coin.VerifyProperties(mintProfile); !valid {
+		recordDispute(s.store, core.NewInvalidCoin(mintProfile, &coin), store.Operation_Reclaim, &client)
+		log.Print("invalid coin")
 		return
 	}
 
-	// Check Expiration date of coin.
-	now := time.Now()
-	if valid := coin.Expiration.After(now); valid {
-		duration := coin.Expiration.Sub(now)
-		months := int(duration.Hours()/24/30) % 12
-		days := int(duration.Hours()/24) % 30
-		hours := int(duration.Hours()) % 24
-		log.Printf("Coin is still valid for %d months, %d days, %d hours", months, days, hours)
-		// return
+	// Verify escrow conditions.
+	if valid := coin.VerifyEscrow(mintProfile, refund.Escrow); !valid {
+		log.Print("invalid escrowed coin")
+		return
+	}
+	if err := refund.Escrow.VerifyRefund(refund.Refund, time.Now()); err != nil {
+		log.Printf("failed to refund escrowed coin: %v", err)
+		return
 	}
 
 	// Compute coin response.
-	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, request.ALower, request.C)
+	Expiration, A1, C1, err := coinResponse(mint, s.threshold, clientInfo, request.ALower, request.C, coin.Value)
+	if err != nil {
+		log.Printf("failed to compute coin response: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Write coin profile into database. (Fails if the coin was already deposited)
+	err = s.store.WriteCoinProfile(&coin, store.Operation_Reclaim, &client)
+	if err == store.ErrExistingCoin {
+		log.Print("== ALERT: escrowed coin was already spent")
+		recordDoubleSpend(s.store, mintProfile, []*core.CoinProfile{&coin}, store.Operation_Reclaim, &client)
+		return
+	} else if err != nil {
+		log.Fatalf("failed to write CoinProfile into database: %v", err)
+		return
+	}
+	if err := s.store.WriteIssuance(mint.Currency, 1, core.NormalizeValue(coin.Value)); err != nil {
+		log.Printf("failed to write Issuance into database: %v", err)
+	}
 
 	// Craft response.
 	response := struct {
 		Expiration time.Time
+		Validity   time.Duration
 		A1         *big.Int
 		C1         *big.Int
 	}{
 		Expiration: Expiration,
+		Validity:   core.Validity.Period(coin.Value, core.Now()),
 		A1:         A1,
 		C1:         C1,
 	}
 
+	trace.Phase(phaseEncode)
 	// SEND coin response.
 	if err := encoder.Encode(response); err != nil {
-		log.Fatalf("failed to encode Exchange response message: %v", err)
+		log.Printf("failed to encode Reclaim response message: %v", err)
 		return
 	}
 
 	// Info message.
-	log.Print("Finished serving client [Exchange]")
+	log.Print("Finished serving client [Reclaim]")
 }
 
 //
-// GET
+// CHANGE
 //
 
 // New.
-func (s *GetServer) New(filepath string) *GetServer {
-	s.port = getPort
-	s.filepath = filepath
+func (s *ChangeServer) New(store *store.BankStore, config *tls.Config) *ChangeServer {
+	s.port = changePort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Threshold makes the server compute coin responses using the bank nodes of threshold.
+func (s *ChangeServer) Threshold(threshold *ThresholdClient) *ChangeServer {
+	s.threshold = threshold
 	return s
 }
 
 // Start.
-func (s *GetServer) Start() error {
+func (s *ChangeServer) Start() error {
 	// Start listening.
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
 	if err != nil {
-		log.Fatalf("failed to start Get server: %v", err)
+		log.Fatalf("failed to start Change server: %v", err)
 		return err
 	}
 
-	log.Printf("Get server listening on port %d", s.port)
+	log.Printf("Change server listening on port %d", s.port)
 
 	for {
 		conn, err := listener.Accept()
@@ -738,36 +2382,1845 @@ func (s *GetServer) Start() error {
 }
 
 // handleClient.
-func (s *GetServer) handleClient(conn net.Conn) {
+func (s *ChangeServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("ChangeServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
 	// Info message.
-	log.Print("Serving client [Get]")
+	log.Print("Serving client [Change]")
 
 	// Close connection when finished.
 	defer conn.Close()
 
-	// Grab file.
-	file, err := os.Open(s.filepath)
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
 	if err != nil {
-		log.Fatalf("failed to open file %s: %v", s.filepath, err)
+		log.Fatalf("failed to read Bank from database: %v", err)
 		return
 	}
-	defer file.Close()
+	bankProfile := bank.Profile()
 
-	writer := bufio.NewWriter(conn)
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
 
-	// SEND file.
-	_, err = io.Copy(writer, file)
-	if err != nil {
-		log.Fatalf("failed to send file message: %v", err)
+	trace.Phase(phaseDecode)
+	// RECV client profile.
+	var client core.ClientProfile
+	if err := decoder.Decode(&client); err != nil {
+		log.Printf("failed to decode ClientProfile message: %v", err)
 		return
 	}
 
-	// Flush writer.
-	if err := writer.Flush(); err != nil {
-		log.Fatalf("failed to flush connection: %v", err)
+	// RECV change claims.
+	var claims []struct {
+		ALower *big.Int
+		C      *big.Int
+		Claim  *big.Int
+	}
+	if err := decoder.Decode(&claims); err != nil {
+		log.Printf("failed to decode Change claims message: %v", err)
 		return
 	}
 
-	// Info message.
-	log.Print("Finished serving client [Get]")
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists)
+	clientInfo, err := s.store.ReadClientInfo(&client)
+	if clientInfo == nil {
+		log.Printf("== ALERT: client does not exist in database: %v", err)
+		return
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Fatalf("failed to read ClientInfo from database: %v", err)
+		return
+	}
+
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
+		return
+	}
+
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
+		return
+	}
+
+	// Compute a coin response for every claimed change. (Unknown or already collected change is skipped)
+	responses := make([]struct {
+		Ready      bool
+		Expiration time.Time
+		Validity   time.Duration
+		A1         *big.Int
+		C1         *big.Int
+	}, len(claims))
+	for i, claim := range claims {
+		change, err := s.store.ReadChange(claim.ALower, claim.C)
+		if err == store.ErrUnknownChange {
+			continue
+		} else if err != nil {
+			log.Fatalf("failed to read Change from database: %v", err)
+			return
+		}
+		if err := change.Change.VerifyClaim(claim.Claim); err != nil {
+			log.Printf("== ALERT: invalid Change claim: %v", err)
+			continue
+		}
+
+		// Read the mint of the change's currency.
+		mint, err := readMint(s.store, bank, s.threshold, change.Currency)
+		if err != nil {
+			log.Printf("failed to read mint for %q: %v", change.Currency, err)
+			continue
+		}
+		if err := mint.Profile().ValidateCoinRequest(claim.ALower, claim.C); err != nil {
+			log.Printf("invalid Change request: %v", err)
+			continue
+		}
+
+		// Compute coin response.
+		Expiration, A1, C1, err := coinResponse(mint, s.threshold, clientInfo, claim.ALower, claim.C, change.Value)
+		if err != nil {
+			log.Printf("failed to compute coin response: %v", err)
+			continue
+		}
+
+		// Mark change as collected. (Fails if it was collected meanwhile)
+		err = s.store.CollectChange(change)
+		if err == store.ErrUnknownChange {
+			log.Print("== ALERT: change was already collected")
+			continue
+		} else if err != nil {
+			log.Fatalf("failed to write Change into database: %v", err)
+			return
+		}
+		if err := s.store.WriteIssuance(mint.Currency, 1, change.Value); err != nil {
+			log.Printf("failed to write Issuance into database: %v", err)
+		}
+
+		responses[i].Ready = true
+		responses[i].Expiration = Expiration
+		responses[i].Validity = core.Validity.Period(change.Value, core.Now())
+		responses[i].A1 = A1
+		responses[i].C1 = C1
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND coin responses.
+	if err := encoder.Encode(responses); err != nil {
+		log.Printf("failed to encode Change response message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Change]")
+}
+
+//
+// REVOCATIONS
+//
+
+// New.
+func (s *RevocationServer) New(store *store.BankStore, config *tls.Config) *RevocationServer {
+	s.port = revocationPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *RevocationServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Revocation server: %v", err)
+		return err
+	}
+
+	log.Printf("Revocation server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *RevocationServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("RevocationServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Revocation]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
+	if err != nil {
+		log.Fatalf("failed to read Bank from database: %v", err)
+		return
+	}
+
+	encoder := gob.NewEncoder(conn)
+
+	// Read and sign the revocation list.
+	list, err := s.store.ReadRevocations()
+	if err != nil {
+		log.Fatalf("failed to read Revocations from database: %v", err)
+		return
+	}
+	if list = bank.SignRevocations(nil, list); list == nil {
+		log.Print("failed to sign Revocations")
+		return
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND revocation list.
+	if err := encoder.Encode(*list); err != nil {
+		log.Printf("failed to encode Revocations message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Revocation]")
+}
+
+//
+// REVIEW
+//
+
+// 1. A client polls the outcome of its operation held by the fraud rules, by alert, the number in its FraudReview.
+// 2. Only the alerts of the client's own account are answered.
+
+// New.
+func (s *ReviewServer) New(store *store.BankStore, config *tls.Config) *ReviewServer {
+	s.port = reviewPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *ReviewServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Review server: %v", err)
+		return err
+	}
+
+	log.Printf("Review server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *ReviewServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("ReviewServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Review]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV client profile and alert.
+	var request struct {
+		Client core.ClientProfile
+		Alert  int64
+	}
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Review request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the alert. (Check that it's the client's)
+	alert, err := s.store.ReadFraudAlert(request.Alert)
+	if err == store.ErrUnknownAlert || (err == nil && alert.Client != request.Client.Hash()) {
+		log.Printf("== ALERT: client %d polled unknown alert %d", request.Client.Hash(), request.Alert)
+		return
+	} else if err != nil {
+		log.Fatalf("failed to read FraudAlert from database: %v", err)
+		return
+	}
+
+	// Craft response.
+	review := FraudReview{
+		Held:   alert.Status != store.Alert_Flagged && alert.Status != store.Alert_Released,
+		Alert:  alert.ID,
+		Status: alert.Status,
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND review.
+	if err := encoder.Encode(review); err != nil {
+		log.Printf("failed to encode FraudReview message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Review]")
+}
+
+//
+// STATUS
+//
+
+// 1. A client polls the outcome of its withdrawals by idempotency token, e.g. after a connection cut short, instead of
+//		guessing whether it was debited. (See store.WithdrawalStatus)
+// 2. An issued withdrawal is answered along with its coin response, the client finishes the coin from it.
+// 3. Only the withdrawals of the client's own account are answered, others are of Status_Unknown.
+
+// New.
+func (s *StatusServer) New(store *store.BankStore, config *tls.Config) *StatusServer {
+	s.port = statusPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *StatusServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Status server: %v", err)
+		return err
+	}
+
+	log.Printf("Status server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *StatusServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("StatusServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Status]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV client profile and tokens.
+	var request struct {
+		Client core.ClientProfile
+		Tokens []string
+	}
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Status request message: %v", err)
+		return
+	}
+	if len(request.Tokens) > maxStatusTokens {
+		log.Printf("invalid Status request: %d tokens", len(request.Tokens))
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the withdrawals' status.
+	statuses := make([]store.WithdrawalStatus, len(request.Tokens))
+	for i, token := range request.Tokens {
+		status, err := s.store.ReadWithdrawalStatus(&request.Client, token)
+		if err != nil {
+			log.Fatalf("failed to read WithdrawalStatus from database: %v", err)
+			return
+		}
+		if status.Status == store.Status_Issued {
+			status.Validity = reissuedValidity(status)
+		}
+		statuses[i] = *status
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND statuses.
+	if err := encoder.Encode(statuses); err != nil {
+		log.Printf("failed to encode WithdrawalStatus message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Status]")
+}
+
+//
+// CLOSURE
+//
+
+// 1. A client closes its account by surrendering its unspent coins, its request signed over a nonce of the session.
+//		(See core.ClosureRequest)
+// 2. The coins are credited to the account, whose balances are paid out of band and recorded, along with the date of
+//		the closure. (See store.CloseAccount) The servers refuse the further operations of the account.
+// 3. The closure is answered with its statement, the balances paid out, or the reason it was refused.
+
+// New.
+func (s *ClosureServer) New(store *store.BankStore, config *tls.Config) *ClosureServer {
+	s.port = closurePort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *ClosureServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Closure server: %v", err)
+		return err
+	}
+
+	log.Printf("Closure server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *ClosureServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("ClosureServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Closure]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
+	if err != nil {
+		log.Fatalf("failed to read Bank from database: %v", err)
+		return
+	}
+	bankProfile := bank.Profile()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND BankProfile. (Validated against the client's stored one)
+	if err := encoder.Encode(*bankProfile); err != nil {
+		log.Printf("failed to encode BankProfile message: %v", err)
+		return
+	}
+
+	// SEND nonce. (Binds the request to this session)
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		log.Printf("failed to generate closure nonce: %v", err)
+		return
+	}
+	if err := encoder.Encode(nonce); err != nil {
+		log.Printf("failed to encode nonce message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV client profile.
+	var client core.ClientProfile
+	if err := decoder.Decode(&client); err != nil {
+		log.Printf("failed to decode ClientProfile message: %v", err)
+		return
+	}
+
+	// RECV closure request.
+	var request core.ClosureRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Closure request message: %v", err)
+		return
+	}
+	if len(request.Coins) > maxClosureCoins {
+		log.Printf("invalid Closure request: %d coins", len(request.Coins))
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists, with this very profile)
+	clientInfo := readAccount(s.store, &client)
+	if clientInfo == nil {
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the request is signed by the account's stored key, in this session.
+	if err := request.Verify(nonce, &clientInfo.Profile); err != nil {
+		log.Printf("== ALERT: closure request not signed by account %d: %v", client.Hash(), err)
+		return
+	}
+
+	// Check the account is open.
+	if accountClosed(s.store, &client) {
+		s.refuse(trace, encoder, "account is already closed")
+		return
+	}
+
+	// Validate and verify coins, against the mint of their currency.
+	coins := make([]*core.CoinProfile, len(request.Coins))
+	mints := make(map[string]*core.BankProfile)
+	for i := range request.Coins {
+		coin := &request.Coins[i]
+		currency := core.NormalizeCurrency(coin.Currency)
+		mintProfile, ok := mints[currency]
+		if !ok {
+			mint, err := readMint(s.store, bank, nil, currency)
+			if err != nil {
+				log.Printf("failed to read mint for %q: %v", currency, err)
+				return
+			}
+			mintProfile = mint.Profile()
+			mints[currency] = mintProfile
+		}
+
+		trace.Phase(phaseCrypto)
+		if err := mintProfile.ValidateCoin(coin); err != nil {
+			log.Printf("invalid CoinProfile: %v", err)
+			return
+		}
+		if _, err := core.Validity.CheckExchange(coin.Expiration, core.Now()); err != nil {
+			log.Printf("invalid Closure request: %v", err)
+			return
+		}
+		if valid := coin.VerifyProperties(mintProfile); !valid {
+			recordDispute(s.store, core.NewInvalidCoin(mintProfile, coin), store.Operation_Closure, &client)
+			log.Print("invalid coin")
+			return
+		}
+		coins[i] = coin
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Close account. (Fails if any coin was already spent, or if closed concurrently)
+	closure, err := s.store.CloseAccount(&client, coins)
+	if err == store.ErrClosedAccount {
+		s.refuse(trace, encoder, "account is already closed")
+		return
+	} else if err == store.ErrExistingCoin {
+		log.Print("== ALERT: surrendered coin was already spent")
+		for currency, mintProfile := range mints {
+			var spent []*core.CoinProfile
+			for _, coin := range coins {
+				if core.NormalizeCurrency(coin.Currency) == currency {
+					spent = append(spent, coin)
+				}
+			}
+			recordDoubleSpend(s.store, mintProfile, spent, store.Operation_Closure, &client)
+		}
+		s.refuse(trace, encoder, "a surrendered coin was already spent")
+		return
+	} else if err != nil {
+		log.Fatalf("failed to close account in database: %v", err)
+		return
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND closure statement.
+	statement := closureStatement{Balances: closure.Balances, Date: closure.Date}
+	if err := encoder.Encode(statement); err != nil {
+		log.Printf("failed to encode Closure statement message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Printf("Closed account %d, surrendering %d coins", client.Hash(), len(coins))
+	log.Print("Finished serving client [Closure]")
+}
+
+// refuse refuses the closure for reason.
+func (s *ClosureServer) refuse(trace *protocolTrace, encoder *gob.Encoder, reason string) {
+	log.Printf("Closure refused: %s", reason)
+
+	trace.Phase(phaseEncode)
+	// SEND closure statement.
+	if err := encoder.Encode(closureStatement{Refused: reason}); err != nil {
+		log.Printf("failed to encode Closure statement message: %v", err)
+	}
+}
+
+//
+// TRANSFER
+//
+
+// 1. A client transfers part of its balance to another account of the bank, named by its ClientProfile hash, its
+//		request signed over a nonce of the session. (See core.TransferRequest)
+// 2. The payer's balance is debited and the payee's credited at once, both recorded in the audit log, along with the
+//		transfer. (See store.TransferBalance)
+// 3. The transfer is answered with its statement, the payer's balance left, or the reason it was refused.
+
+// New.
+func (s *TransferServer) New(store *store.BankStore, config *tls.Config) *TransferServer {
+	s.port = transferPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *TransferServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Transfer server: %v", err)
+		return err
+	}
+
+	log.Printf("Transfer server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *TransferServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("TransferServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Transfer]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
+	if err != nil {
+		log.Fatalf("failed to read Bank from database: %v", err)
+		return
+	}
+	bankProfile := bank.Profile()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND BankProfile. (Validated against the client's stored one)
+	if err := encoder.Encode(*bankProfile); err != nil {
+		log.Printf("failed to encode BankProfile message: %v", err)
+		return
+	}
+
+	// SEND nonce. (Binds the request to this session)
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		log.Printf("failed to generate transfer nonce: %v", err)
+		return
+	}
+	if err := encoder.Encode(nonce); err != nil {
+		log.Printf("failed to encode nonce message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV client profile.
+	var client core.ClientProfile
+	if err := decoder.Decode(&client); err != nil {
+		log.Printf("failed to decode ClientProfile message: %v", err)
+		return
+	}
+
+	// RECV transfer request.
+	var request core.TransferRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Transfer request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists, with this very profile)
+	clientInfo := readAccount(s.store, &client)
+	if clientInfo == nil {
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the request is signed by the account's stored key, in this session. (Non-positive amounts aren't)
+	if err := request.Verify(nonce, &clientInfo.Profile); err != nil {
+		log.Printf("== ALERT: transfer request not signed by account %d: %v", client.Hash(), err)
+		return
+	}
+
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
+		return
+	}
+
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
+		return
+	}
+
+	// Check the payee and the currency.
+	if request.Payee == client.Hash() {
+		s.refuse(trace, encoder, "the payee is the payer's own account")
+		return
+	}
+	if _, err := s.store.ReadMint(request.Currency); err == store.ErrUnknownCurrency {
+		s.refuse(trace, encoder, "unknown currency")
+		return
+	} else if err != nil {
+		log.Printf("failed to read mint for %q: %v", request.Currency, err)
+		return
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Transfer balance. (Fails if the balance is insufficient, or if the payee can't be credited)
+	transfer, err := s.store.TransferBalance(&client, request.Payee, request.Currency, request.Value)
+	switch err {
+	case nil:
+	case store.ErrInsufficientFunds:
+		s.refuse(trace, encoder, "insufficient funds")
+		return
+	case store.ErrUnknownClient:
+		s.refuse(trace, encoder, "unknown payee")
+		return
+	case store.ErrClosedAccount:
+		s.refuse(trace, encoder, "the payee's account is closed")
+		return
+	default:
+		log.Fatalf("failed to transfer balance in database: %v", err)
+		return
+	}
+
+	// Grab the balance left.
+	balance, err := s.store.ReadClientBalance(&client, request.Currency)
+	if err != nil {
+		log.Printf("failed to read client's balance from database: %v", err)
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND transfer statement.
+	statement := transferStatement{ID: transfer.ID, Balance: balance, Date: transfer.Date}
+	if err := encoder.Encode(statement); err != nil {
+		log.Printf("failed to encode Transfer statement message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Printf("Transferred %d %s from account %d to account %d", transfer.Value, transfer.Currency, transfer.Payer,
+		transfer.Payee)
+	log.Print("Finished serving client [Transfer]")
+}
+
+// refuse refuses the transfer for reason.
+func (s *TransferServer) refuse(trace *protocolTrace, encoder *gob.Encoder, reason string) {
+	log.Printf("Transfer refused: %s", reason)
+
+	trace.Phase(phaseEncode)
+	// SEND transfer statement.
+	if err := encoder.Encode(transferStatement{Refused: reason}); err != nil {
+		log.Printf("failed to encode Transfer statement message: %v", err)
+	}
+}
+
+//
+// ATTESTATION
+//
+
+// New.
+func (s *AttestationServer) New(store *store.BankStore, config *tls.Config) *AttestationServer {
+	s.port = attestationPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *AttestationServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Attestation server: %v", err)
+		return err
+	}
+
+	log.Printf("Attestation server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *AttestationServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("AttestationServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Attestation]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
+	if err != nil {
+		log.Fatalf("failed to read Bank from database: %v", err)
+		return
+	}
+	bankProfile := bank.Profile()
+
+	encoder := gob.NewEncoder(conn)
+	decoder := newDecoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV attestation request.
+	var request core.AttestationRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode AttestationRequest message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&request.Merchant); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	// Verify the request was signed by the merchant.
+	if err := request.Verify(); err != nil {
+		log.Printf("== ALERT: invalid AttestationRequest from client %d: %v", request.Merchant.Hash(), err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists)
+	clientInfo, err := s.store.ReadClientInfo(&request.Merchant)
+	if clientInfo == nil {
+		log.Printf("== ALERT: client does not exist in database: %v", err)
+		return
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Fatalf("failed to read ClientInfo from database: %v", err)
+		return
+	}
+
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &request.Merchant) {
+		return
+	}
+
+	// Check that credentials haven't expired.
+	if credentialsExpired(clientInfo) {
+		return
+	}
+
+	// Check that the account isn't frozen.
+	list, err := s.store.ReadRevocations()
+	if err != nil {
+		log.Fatalf("failed to read Revocations from database: %v", err)
+		return
+	}
+	if list.Frozen(&request.Merchant) {
+		log.Printf("Refusing attestation of frozen account %d", request.Merchant.Hash())
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Attest the merchant's certificate.
+	attestation := bank.Attest(nil, &request)
+	if attestation == nil {
+		log.Print("failed to sign Attestation")
+		return
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND attestation.
+	if err := encoder.Encode(*attestation); err != nil {
+		log.Printf("failed to encode Attestation message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Attestation]")
+}
+
+//
+// ADMIN
+//
+
+// 1. The bank's operator runs the admin operations remotely, authenticated by its client certificate. (See
+//		GetMutualServerTLSConfig)
+// 2. Each connection carries a single request, its output or error is sent back as text.
+
+// New.
+func (s *AdminServer) New(store *store.BankStore, config *tls.Config) *AdminServer {
+	s.port = adminPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *AdminServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Admin server: %v", err)
+		return err
+	}
+
+	log.Printf("Admin server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *AdminServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("AdminServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Admin]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV request.
+	var request AdminRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Admin request message: %v", err)
+		return
+	}
+	log.Printf("== ADMIN: %s from %s", request.Operation, conn.RemoteAddr())
+
+	// Execute request.
+	var response adminResponse
+	output, err := s.execute(&request)
+	if err != nil {
+		response.Error = err.Error()
+	}
+	response.Output = output
+
+	trace.Phase(phaseEncode)
+	// SEND response.
+	if err := encoder.Encode(response); err != nil {
+		log.Printf("failed to encode Admin response message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Admin]")
+}
+
+// execute executes request and returns its output.
+func (s *AdminServer) execute(request *AdminRequest) (string, error) {
+	var output strings.Builder
+	switch request.Operation {
+	case AdminInspect:
+		// The inspection is sent as JSON, formatted by the admin.
+		inspection, err := s.store.Inspect()
+		if err != nil {
+			return "", err
+		}
+		if err := json.NewEncoder(&output).Encode(inspection); err != nil {
+			return "", err
+		}
+
+	case AdminReport:
+		report, err := s.store.Report()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&output, "Accounts: %d (%d frozen)\n", report.Clients, report.Frozen)
+		currencies := make([]string, 0, len(report.Balances))
+		for currency := range report.Balances {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			fmt.Fprintf(&output, "Balance: %d %s\n", report.Balances[currency], currency)
+		}
+		for operation := store.Operation_Withdrawal; operation <= store.Operation_Gift; operation++ {
+			if count, found := report.Coins[operation]; found {
+				fmt.Fprintf(&output, "Coins (%s): %d\n", operation, count)
+			}
+		}
+		fmt.Fprintf(&output, "Revoked coins: %d\n", report.Revoked)
+
+	case AdminStats:
+		// The statistics are sent as JSON, e.g. for a dashboard.
+		stats, err := s.store.Stats()
+		if err != nil {
+			return "", err
+		}
+		if err := json.NewEncoder(&output).Encode(stats); err != nil {
+			return "", err
+		}
+
+	case AdminPool:
+		stats := CryptoStats()
+		fmt.Fprintf(&output, "Workers: %d (%d busy)\n", stats.Workers, stats.Busy)
+		fmt.Fprintf(&output, "Queued: %d of %d (peak %d)\n", stats.Queued, stats.Depth, stats.Peak)
+		fmt.Fprintf(&output, "Served: %d (refused %d)\n", stats.Served, stats.Refused)
+		fmt.Fprintf(&output, "Average wait: %s\n", stats.Wait)
+
+	case AdminFreeze:
+		if err := s.store.FreezeAccount(request.Account, request.Reason); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&output, "Froze account %d\n", request.Account)
+
+	case AdminFund:
+		if err := core.ValidateCurrency(core.NormalizeCurrency(request.Currency)); err != nil {
+			return "", err
+		}
+		balance, err := s.store.FundAccount(request.Account, request.Currency, request.Amount)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&output, "Balance of account %d: %d %s\n", request.Account, balance, core.NormalizeCurrency(request.Currency))
+
+	default:
+		return "", fmt.Errorf("unknown admin operation: %q", request.Operation)
+	}
+	return output.String(), nil
+}
+
+//
+// RENEWAL
+//
+
+// New.
+func (s *RenewalServer) New(store *store.BankStore, config *tls.Config) *RenewalServer {
+	s.port = renewalPort
+	s.store = store
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *RenewalServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Renewal server: %v", err)
+		return err
+	}
+
+	log.Printf("Renewal server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *RenewalServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("RenewalServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Renewal]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	trace.Phase(phaseStoreRead)
+	// Read Bank.
+	bank, err := s.store.ReadBank()
+	if err != nil {
+		log.Fatalf("failed to read Bank from database: %v", err)
+		return
+	}
+	bankProfile := bank.Profile()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND nonce. (Binds the request to this session)
+	nonce, err := core.NewWithdrawalNonce(nil)
+	if err != nil {
+		log.Printf("failed to generate renewal nonce: %v", err)
+		return
+	}
+	if err := encoder.Encode(nonce); err != nil {
+		log.Printf("failed to encode nonce message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseDecode)
+	// RECV client profile.
+	var client core.ClientProfile
+	if err := decoder.Decode(&client); err != nil {
+		log.Printf("failed to decode ClientProfile message: %v", err)
+		return
+	}
+
+	// RECV renewal request.
+	var request core.RenewalRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Renewal request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := bankProfile.ValidateClient(&client); err != nil {
+		log.Printf("invalid ClientProfile: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read ClientInfo from database. (Check that exists, with this very profile)
+	clientInfo := readAccount(s.store, &client)
+	if clientInfo == nil {
+		return
+	}
+
+	// Check that the account isn't closed.
+	if accountClosed(s.store, &client) {
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Check the request is signed by the account's stored key, in this session. (Expired credentials are renewed too)
+	if err := request.Verify(nonce, &clientInfo.Profile); err != nil {
+		log.Printf("== ALERT: renewal request not signed by account %d: %v", client.Hash(), err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Issue fresh credentials.
+	renewed, err := bank.RenewClient(nil, clientInfo)
+	if err != nil {
+		log.Fatalf("failed to renew client account: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreWrite)
+	// Update ClientInfo.
+	if err := s.store.UpdateClientInfo(renewed); err != nil {
+		log.Fatalf("failed to update ClientInfo into database: %v", err)
+		return
+	}
+
+	trace.Phase(phaseStoreRead)
+	// Read the profiles of every mint.
+	mints, err := s.store.ReadMints()
+	if err != nil {
+		log.Fatalf("failed to read mints from database: %v", err)
+		return
+	}
+
+	trace.Phase(phaseEncode)
+	// SEND credentials and mint profiles to client.
+	credentials := struct {
+		Credential *big.Int
+		Contract   *big.Int
+		Expiration time.Time
+		Mints      []core.BankProfile
+	}{
+		Credential: renewed.Credential,
+		Contract:   renewed.Contract,
+		Expiration: renewed.Expiration,
+		Mints:      mints,
+	}
+	if err := encoder.Encode(credentials); err != nil {
+		log.Printf("failed to encode Renewal response message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Printf("ClientInfo: %s", renewed)
+	log.Print("Finished serving client [Renewal]")
+}
+
+//
+// THRESHOLD
+//
+
+// New.
+func (s *ThresholdServer) New(share *core.ThresholdShare, config *tls.Config) *ThresholdServer {
+	s.port = thresholdPort
+	s.share = share
+	s.config = config
+	return s
+}
+
+// Start.
+func (s *ThresholdServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Threshold server: %v", err)
+		return err
+	}
+
+	log.Printf("Threshold server (node %d/%d) listening on port %d", s.share.Index, s.share.Total, s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *ThresholdServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("ThresholdServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Threshold]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV coin request.
+	var request struct {
+		Expiration time.Time
+		ALower     *big.Int
+		C          *big.Int
+	}
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Threshold request message: %v", err)
+		return
+	}
+
+	trace.Phase(phaseCrypto)
+	// Validate received values.
+	if err := s.share.Profile.ValidateCoinRequest(request.ALower, request.C); err != nil {
+		log.Printf("invalid Threshold request: %v", err)
+		return
+	}
+
+	// Compute partial response.
+	partial := s.share.NewPartialResponse(request.Expiration, request.ALower, request.C)
+
+	trace.Phase(phaseEncode)
+	// SEND partial response.
+	if err := encoder.Encode(*partial); err != nil {
+		log.Printf("failed to encode PartialResponse message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Threshold]")
+}
+
+//
+// GET
+//
+
+// 1. A merchant hands out its TLS certificate along with its bank's attestation. (See AttestationServer)
+// 2. The payer verifies the attestation with its bank's public identity number before trusting the certificate, so
+//		the exchange needs no TLS of its own.
+
+// New.
+func (s *GetServer) New(cert []byte, attestation *core.Attestation) *GetServer {
+	s.port = getPort
+	s.cert = cert
+	s.attestation = attestation
+	return s
+}
+
+// Start.
+func (s *GetServer) Start() error {
+	// Start listening.
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	if err != nil {
+		log.Fatalf("failed to start Get server: %v", err)
+		return err
+	}
+
+	log.Printf("Get server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *GetServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("GetServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Get]")
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseEncode)
+	// SEND certificate and attestation.
+	if err := encoder.Encode(certificateMessage{Cert: newCertificateFrame(s.cert), Attestation: *s.attestation}); err != nil {
+		log.Printf("failed to encode Certificate message: %v", err)
+		return
+	}
+
+	// Info message.
+	log.Print("Finished serving client [Get]")
+}
+
+//
+// RELAY
+//
+
+// 1. The relay can be co-hosted with the bank, under its certificate: clients already trust it.
+// 2. It requests (doesn't verify) client certificates: merchants present their own, which identifies their name, payers
+//		none.
+// 3. A session is joined by the merchant that was announced it, and is spliced until either side ends. The relay only
+//		sees TLS records.
+
+// New.
+func (s *RelayServer) New(config *tls.Config) *RelayServer {
+	s.port = relayPort
+	s.config = config.Clone()
+	s.config.ClientAuth = tls.RequestClientCert
+	s.merchants = make(map[string]*relayMerchant)
+	s.owners = make(map[string]string)
+	s.sessions = make(map[string]*relayPending)
+	return s
+}
+
+// Start.
+func (s *RelayServer) Start() error {
+	// Start listening.
+	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.port), s.config)
+	if err != nil {
+		log.Fatalf("failed to start Relay server: %v", err)
+		return err
+	}
+
+	log.Printf("Relay server listening on port %d", s.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+			continue
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// handleClient.
+func (s *RelayServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("RelayServer")
+	defer trace.End()
+
+	// Info message.
+	log.Print("Serving client [Relay]")
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV relay request.
+	var request relayRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Relay request message: %v", err)
+		conn.Close()
+		return
+	}
+
+	// The connection is closed by the operation.
+	switch request.Operation {
+	case relayRegister:
+		s.register(conn, encoder, request.Name)
+	case relayConnect:
+		s.connect(conn, encoder, request.Name, request.Service)
+	case relayAccept:
+		s.join(conn, request.Session)
+	default:
+		log.Printf("== ALERT: unknown relay operation %d", request.Operation)
+		conn.Close()
+	}
+}
+
+// register registers the merchant name, authenticated by the certificate of conn, and announces its sessions over conn
+// until it disconnects.
+func (s *RelayServer) register(conn net.Conn, encoder *gob.Encoder, name string) {
+	defer conn.Close()
+
+	// Bind the name to the certificate.
+	fingerprint := peerFingerprint(conn)
+	s.mutex.Lock()
+	owner, known := s.owners[name]
+	accept := len(name) > 0 && len(fingerprint) > 0 && (!known || owner == fingerprint)
+	if accept {
+		s.owners[name] = fingerprint
+	}
+	s.mutex.Unlock()
+	if !accept {
+		log.Printf("== ALERT: relay registration of %q refused (certificate %q)", name, fingerprint)
+	}
+
+	// SEND acceptance.
+	if err := encoder.Encode(accept); err != nil || !accept {
+		return
+	}
+
+	// Replace the merchant's previous registration.
+	merchant := &relayMerchant{conn: conn, encoder: encoder}
+	s.mutex.Lock()
+	previous := s.merchants[name]
+	s.merchants[name] = merchant
+	s.mutex.Unlock()
+	if previous != nil {
+		previous.conn.Close()
+	}
+	log.Printf("Merchant %s registered at the relay", name)
+
+	// Wait for the merchant to disconnect. (It sends nothing more)
+	io.Copy(io.Discard, conn)
+
+	s.mutex.Lock()
+	if s.merchants[name] == merchant {
+		delete(s.merchants, name)
+	}
+	s.mutex.Unlock()
+	log.Printf("Merchant %s left the relay", name)
+}
+
+// connect relays the payer of conn to service of the merchant name, once joined.
+func (s *RelayServer) connect(conn net.Conn, encoder *gob.Encoder, name string, service int) {
+	if service != relayPayment && service != relayGet {
+		log.Printf("== ALERT: unknown relayed service %d", service)
+		conn.Close()
+		return
+	}
+
+	// Open the session.
+	id, err := newToken()
+	if err != nil {
+		log.Fatalf("failed to generate session id: %v", err)
+	}
+	pending := &relayPending{name: name, joined: make(chan net.Conn, 1)}
+	s.mutex.Lock()
+	merchant := s.merchants[name]
+	if merchant != nil {
+		s.sessions[id] = pending
+	}
+	s.mutex.Unlock()
+
+	// SEND session. (To the merchant)
+	var joined net.Conn
+	if merchant != nil {
+		merchant.mutex.Lock()
+		err := merchant.encoder.Encode(relaySession{Session: id, Service: service})
+		merchant.mutex.Unlock()
+		if err != nil {
+			log.Printf("failed to encode Relay session message: %v", err)
+		}
+
+		// Wait for the merchant to join.
+		select {
+		case joined = <-pending.joined:
+		case <-time.After(relayTimeout):
+		}
+		s.mutex.Lock()
+		delete(s.sessions, id)
+		s.mutex.Unlock()
+		select {
+		case late := <-pending.joined:
+			late.Close()
+		default:
+		}
+	}
+
+	// SEND acceptance.
+	if err := encoder.Encode(joined != nil); err != nil || joined == nil {
+		log.Printf("Merchant %s isn't reachable at the relay", name)
+		if joined != nil {
+			joined.Close()
+		}
+		conn.Close()
+		return
+	}
+
+	// Splice the payer and the merchant.
+	splice(conn, joined)
+
+	// Info message.
+	log.Print("Finished serving client [Relay]")
+}
+
+// join hands the merchant's connection conn to the session id.
+func (s *RelayServer) join(conn net.Conn, id string) {
+	fingerprint := peerFingerprint(conn)
+	s.mutex.Lock()
+	pending, ok := s.sessions[id]
+	if ok && s.owners[pending.name] == fingerprint {
+		delete(s.sessions, id)
+	} else {
+		ok = false
+	}
+	s.mutex.Unlock()
+	if !ok {
+		log.Printf("== ALERT: unknown relay session %q (certificate %q)", id, fingerprint)
+		conn.Close()
+		return
+	}
+	pending.joined <- conn
+}
+
+// peerFingerprint returns the fingerprint of the client certificate of conn, "" if none.
+func peerFingerprint(conn net.Conn) string {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	return store.CertificateFingerprint(certs[0].Raw)
+}
+
+//
+// AGENT
+//
+
+// New. serverAddr is the bank's server, with TLS configuration config.
+func (s *AgentServer) New(store *store.ClientStore, socketPath, serverAddr string, config *tls.Config) *AgentServer {
+	s.socketPath = socketPath
+	s.store = store
+	s.serverAddr = serverAddr
+	s.config = config
+	return s
+}
+
+// Start. Returns once Shutdown is called.
+func (s *AgentServer) Start() error {
+	// Remove the socket of an agent that didn't shut down. (The wallet's lock keeps a single agent running)
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Start listening, to the wallet's owner only.
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		log.Printf("failed to start Agent server: %v", err)
+		return err
+	}
+	if err := os.Chmod(s.socketPath, 0600); err != nil { // rw- --- ---
+		listener.Close()
+		return err
+	}
+
+	// Keep listener for Shutdown.
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	log.Printf("Agent server listening on %s", s.socketPath)
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			log.Printf("failed to accept connection: %v", err)
+			continue
+		}
+		s.handlers.Add(1)
+		go func() {
+			defer s.handlers.Done()
+			s.handleClient(conn)
+		}()
+	}
+}
+
+// Shutdown stops the server, waits for the operations being served to finish, and removes its socket.
+func (s *AgentServer) Shutdown() error {
+	s.mutex.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mutex.Unlock()
+
+	s.handlers.Wait()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// handleClient.
+func (s *AgentServer) handleClient(conn net.Conn) {
+	// Trace protocol run.
+	trace := newTrace("AgentServer")
+	conn = trace.Measure(conn)
+	defer trace.End()
+
+	// Close connection when finished.
+	defer conn.Close()
+
+	decoder := newDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	trace.Phase(phaseDecode)
+	// RECV request.
+	var request AgentRequest
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("failed to decode Agent request message: %v", err)
+		return
+	}
+	log.Printf("== AGENT: %s", request.Operation)
+
+	// Execute request.
+	var response agentResponse
+	output, err := s.execute(&request)
+	if err != nil {
+		response.Error = err.Error()
+	}
+	response.Output = output
+
+	trace.Phase(phaseEncode)
+	// SEND response.
+	if err := encoder.Encode(response); err != nil {
+		log.Printf("failed to encode Agent response message: %v", err)
+	}
+}
+
+// execute executes request on the wallet and returns its output.
+func (s *AgentServer) execute(request *AgentRequest) (string, error) {
+	// The agent's store is bound to a single bank.
+	if len(request.Bank) > 0 && request.Bank != s.store.BankName {
+		return "", fmt.Errorf("wallet agent serves bank %s, not %s", s.store.BankName, request.Bank)
+	}
+	if request.Operation != AgentBalance && request.Operation != AgentPay && request.Server != s.serverAddr {
+		return "", fmt.Errorf("wallet agent serves bank server %s, not %s", s.serverAddr, request.Server)
+	}
+
+	var output strings.Builder
+	switch request.Operation {
+	case AgentBalance:
+		// The balances are sent as JSON, formatted by the CLI.
+		balances, err := s.store.ReadBalances()
+		if err != nil {
+			return "", err
+		}
+		if err := json.NewEncoder(&output).Encode(balances); err != nil {
+			return "", err
+		}
+
+	case AgentWithdraw:
+		for _, value := range request.Values {
+			client := new(WithdrawalClient).New(s.serverAddr, s.store, s.config).Currency(request.Currency).Value(value)
+			if err := client.Execute(); err != nil {
+				return output.String(), err
+			}
+			fmt.Fprintf(&output, "Withdrew a coin of value %d\n", value)
+		}
+
+	case AgentDeposit:
+		client := new(DepositClient).New(s.serverAddr, s.store, s.config).Currency(request.Currency)
+		if len(request.Release) > 0 {
+			release, ok := new(big.Int).SetString(request.Release, 10)
+			if !ok {
+				return "", fmt.Errorf("invalid release secret: %s", request.Release)
+			}
+			client.Release(release)
+		}
+		if len(request.Beneficiary) > 0 {
+			auth, err := core.ParseAuthorizationToken(request.Beneficiary)
+			if err != nil {
+				return "", err
+			}
+			client.Beneficiary(auth)
+		}
+		if err := client.Execute(); err != nil {
+			return "", err
+		}
+
+	case AgentPay:
+		config, err := s.clientConfig(request.Server)
+		if err != nil {
+			return "", err
+		}
+
+		// Non-escrowed payments of an amount.
+		if request.Amount > 0 && request.Escrow == 0 {
+			client := new(AutoPaymentClient).New(request.Server, s.store, config).Memo(request.Memo).Currency(request.Currency).Amount(request.Amount).Denominations(request.Denominations).Account(request.Account)
+			if len(request.BankServer) > 0 {
+				if request.BankServer != s.serverAddr {
+					return "", fmt.Errorf("wallet agent serves bank server %s, not %s", s.serverAddr, request.BankServer)
+				}
+				client.Bank(s.serverAddr, s.config)
+			}
+			if err := client.Execute(); err != nil {
+				return "", err
+			}
+			break
+		}
+
+		client := new(PaymentClient).New(request.Server, s.store, config).Escrow(request.Escrow).Memo(request.Memo).Currency(request.Currency).Amount(request.Amount).Account(request.Account)
+		if err := client.Execute(); err != nil {
+			return "", err
+		}
+
+	case AgentExchange:
+		// Expiring coins.
+		if request.Expiring > 0 {
+			exchanged, err := new(AutoExchangeClient).New(s.serverAddr, s.store, s.config).Expiring(request.Expiring).Execute()
+			if err != nil {
+				return output.String(), err
+			}
+			fmt.Fprintf(&output, "Exchanged %d expiring coins\n", exchanged)
+			break
+		}
+
+		client := new(ExchangeClient).New(s.serverAddr, s.store, s.config).Currency(request.Currency).Split(request.Value).To(request.To).Consolidate(request.Amount).Account(request.Account)
+		if request.Denominations != nil {
+			client.Denominations(request.Denominations)
+		}
+		if err := client.Execute(); err != nil {
+			return "", err
+		}
+
+	default:
+		return "", fmt.Errorf("unknown agent operation: %q", request.Operation)
+	}
+	return output.String(), nil
+}
+
+// clientConfig returns the TLS configuration of the merchant at serverAddr, fetching its certificate.
+func (s *AgentServer) clientConfig(serverAddr string) (*tls.Config, error) {
+	if serverAddr == s.serverAddr {
+		return s.config, nil
+	}
+
+	// Execute GetClient.
+	if err := new(GetClient).New(serverAddr, s.store).Execute(); err != nil {
+		return nil, err
+	}
+
+	// Load TLS client configuration.
+	return GetStoredClientTLSConfig(s.store.Certificates(), store.Role_Merchant, serverAddr)
 }
@@ -2,6 +2,15 @@ package network
 
 import (
 	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"ziba/core"
 	"ziba/store"
 )
 
@@ -13,6 +22,7 @@ import (
 type SetupServer struct {
 	port  int
 	store *store.BankStore
+	acme  bool
 }
 
 // SetupClient.
@@ -27,9 +37,40 @@ type SetupClient struct {
 
 // AccgenServer.
 type AccgenServer struct {
-	port   int
-	store  *store.BankStore
-	config *tls.Config
+	port      int
+	store     *store.BankStore
+	config    *tls.Config
+	admission AdmissionPolicy
+	policy    AccgenPolicy
+	mutex     sync.Mutex
+	accounts  map[string][]time.Time // Accounts opened by host, within the quota window.
+}
+
+// AccgenPolicy.
+type AccgenPolicy struct {
+	RequireToken bool          // Only open accounts for clients presenting a registration token.
+	Work         int           // Proof of work difficulty, in bits. (No work if 0)
+	Quota        int           // Accounts opened per host within QuotaWindow, by each replica. (Unlimited if 0)
+	QuotaWindow  time.Duration // Window of the quota.
+}
+
+// AdmissionPolicy decides whether to open an account for client.
+type AdmissionPolicy interface {
+	Admit(client *core.ClientProfile, evidence string) (store.Admission_Type, error)
+}
+
+// AutoApproval.
+type AutoApproval struct{}
+
+// ManualApproval.
+type ManualApproval struct {
+	store *store.BankStore
+}
+
+// HTTPApproval.
+type HTTPApproval struct {
+	url    string
+	client *http.Client
 }
 
 // AccgenClient.
@@ -37,6 +78,107 @@ type AccgenClient struct {
 	serverAddr string
 	store      *store.ClientStore
 	config     *tls.Config
+	request    accgenRequest
+}
+
+//
+// FRAUD RULES
+//
+
+// FraudRule matches suspicious withdrawals and deposits.
+type FraudRule interface {
+	// Name identifies the rule, along with its parameters, in the fraud alerts.
+	Name() string
+
+	// Match returns whether op is suspicious.
+	Match(op *FraudOperation) (bool, error)
+}
+
+// FraudOperation is a withdrawal or a deposit evaluated by the FraudRules.
+type FraudOperation struct {
+	Operation store.Operation_Type // Operation_Withdrawal or Operation_Deposit.
+	Client    *core.ClientProfile  // Client whose account is debited or credited.
+	Currency  string
+	Value     int64
+	Date      time.Time
+}
+
+// FraudAction is the action taken on the operations matched by a FraudRule.
+type FraudAction int
+
+const (
+	FraudReject   FraudAction = iota // Refuse the operation.
+	FraudFlag                        // Serve the operation, raising an alert.
+	FraudApproval                    // Refuse the operation until an admin approves it.
+)
+
+// FraudReview is the bank's answer to an operation evaluated by the FraudRules, sent before serving it.
+type FraudReview struct {
+	Held   bool             // The operation isn't served.
+	Alert  int64            // Alert holding the operation, 0 if none. (See ReviewClient)
+	Status store.Alert_Type // Status of the alert, Alert_Pending while under review.
+	Limit  *LimitExceeded   // Limit holding the operation, nil if none. (See OperationLimits)
+}
+
+// FraudRules.
+type FraudRules struct {
+	store   *store.BankStore
+	entries []fraudEntry
+}
+
+// fraudEntry is a rule of FraudRules, with its action, evaluated on operations. (Every one if empty)
+type fraudEntry struct {
+	rule       FraudRule
+	action     FraudAction
+	operations []store.Operation_Type
+}
+
+// VelocityRule.
+type VelocityRule struct {
+	count  int
+	window time.Duration
+	mutex  sync.Mutex
+	recent map[uint32][]time.Time // Operations by client, within the window. (Served by this replica)
+}
+
+// AmountRule.
+type AmountRule struct {
+	currency string
+	max      int64
+}
+
+// NewAccountRule.
+type NewAccountRule struct {
+	store *store.BankStore
+	age   time.Duration
+	max   int64
+}
+
+//
+// OPERATION LIMITS
+//
+
+// ErrLimitExceeded is the error of the operations refused by the bank's OperationLimits. (Matched by LimitExceeded)
+var ErrLimitExceeded = errors.New("ziba/network: operation limit exceeded")
+
+// LimitExceeded is the limit an operation exceeds, sent in its FraudReview.
+type LimitExceeded struct {
+	Operation store.Operation_Type
+	Count     int // Operations allowed within the window.
+	Window    time.Duration
+	Retry     time.Time // Date the operation is allowed again.
+}
+
+// OperationLimits.
+type OperationLimits struct {
+	store  *store.BankStore
+	limits map[store.Operation_Type]operationLimit
+}
+
+// operationLimit is the number of operations allowed within a rolling window.
+type operationLimit struct {
+	count  int
+	window time.Duration
 }
 
 //
@@ -45,9 +187,12 @@ type AccgenClient struct {
 
 // WithdrawalServer.
 type WithdrawalServer struct {
-	port   int
-	store  *store.BankStore
-	config *tls.Config
+	port      int
+	store     *store.BankStore
+	config    *tls.Config
+	threshold *ThresholdClient
+	fraud     *FraudRules
+	limits    *OperationLimits
 }
 
 // WithdrawalClient.
@@ -55,27 +200,109 @@ type WithdrawalClient struct {
 	serverAddr string
 	store      *store.ClientStore
 	config     *tls.Config
+	currency   string
+	value      int64
 }
 
-// PaymentServer.
-type PaymentServer struct {
+// PaymentTransport carries payments between payers and merchants: TCP over TLS (TLSTransport) by default, or bridges
+// supplied by integrators, e.g. over Bluetooth, serial lines or NFC.
+type PaymentTransport interface {
+	// Dial connects to the merchant at addr.
+	Dial(addr string) (io.ReadWriteCloser, error)
+
+	// Listen starts accepting payers.
+	Listen() (PaymentListener, error)
+}
+
+// PaymentListener accepts the payers of a PaymentTransport.
+type PaymentListener interface {
+	// Accept waits for the next payer. Returns net.ErrClosed once the listener is closed.
+	// The payer is known by its address if the connection is a net.Conn, as "bridge" otherwise.
+	Accept() (io.ReadWriteCloser, error)
+
+	// Close stops accepting payers.
+	Close() error
+}
+
+// TLSTransport is the PaymentTransport of TCP over TLS, on the payment port.
+type TLSTransport struct {
 	port   int
-	store  *store.ClientStore
 	config *tls.Config
 }
 
+// PaymentServer.
+type PaymentServer struct {
+	port      int
+	store     *store.ClientStore
+	config    *tls.Config
+	transport PaymentTransport
+	currency  string
+	amount    int64
+	paid      chan<- int64
+	approve   func(payer, currency string, amount int64) bool
+	mutex     sync.Mutex
+	listener  PaymentListener
+	closed    bool
+	handlers  sync.WaitGroup
+
+	// WebSocket payments.
+	webSocket bool
+	webServer *http.Server
+}
+
 // PaymentClient.
 type PaymentClient struct {
 	serverAddr string
 	store      *store.ClientStore
 	config     *tls.Config
+	transport  PaymentTransport
+	timeout    time.Duration
+	memo       string
+	currency   string
+	amount     int64
+	account    string
+	confirm    func(details *PaymentDetails) bool
+}
+
+// AutoPaymentClient.
+type AutoPaymentClient struct {
+	serverAddr    string
+	store         *store.ClientStore
+	config        *tls.Config
+	transport     PaymentTransport
+	bankAddr      string
+	bankConfig    *tls.Config
+	memo          string
+	currency      string
+	amount        int64
+	denominations []int64
+	account       string
+	confirm       func(details *PaymentDetails) bool
 }
 
 // DepositServer.
 type DepositServer struct {
-	port   int
-	store  *store.BankStore
-	config *tls.Config
+	port           int
+	store          *store.BankStore
+	config         *tls.Config
+	requireBinding bool
+	fraud          *FraudRules
+	limits         *OperationLimits
+}
+
+// RefillClient.
+type RefillClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// AutoExchangeClient.
+type AutoExchangeClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+	expiring   time.Duration
 }
 
 // DepositClient.
@@ -83,17 +310,192 @@ type DepositClient struct {
 	serverAddr string
 	store      *store.ClientStore
 	config     *tls.Config
+	release    *big.Int
+	currency   string
+	authorized *core.DepositAuthorization
 }
 
 // ExchangeServer.
 type ExchangeServer struct {
+	port      int
+	store     *store.BankStore
+	config    *tls.Config
+	threshold *ThresholdClient
+}
+
+// ExchangeClient.
+type ExchangeClient struct {
+	serverAddr    string
+	store         *store.ClientStore
+	config        *tls.Config
+	currency      string
+	split         int64
+	denominations []int64
+	to            string
+	consolidate   int64
+	claim         *core.Coin
+	coin          *core.Coin
+	account       string
+}
+
+// ReclaimServer.
+type ReclaimServer struct {
+	port      int
+	store     *store.BankStore
+	config    *tls.Config
+	threshold *ThresholdClient
+}
+
+// ReclaimClient.
+type ReclaimClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// ChangeServer.
+type ChangeServer struct {
+	port      int
+	store     *store.BankStore
+	config    *tls.Config
+	threshold *ThresholdClient
+}
+
+// ChangeClient.
+type ChangeClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// RevocationServer.
+type RevocationServer struct {
 	port   int
 	store  *store.BankStore
 	config *tls.Config
 }
 
-// ExchangeClient.
-type ExchangeClient struct {
+// RevocationClient.
+type RevocationClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// ReviewServer.
+type ReviewServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// ReviewClient.
+type ReviewClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+	alert      int64
+}
+
+// StatusServer.
+type StatusServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// StatusClient.
+type StatusClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// ClosureServer.
+type ClosureServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// ClosureClient.
+type ClosureClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// TransferServer.
+type TransferServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// TransferClient.
+type TransferClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+	payee      uint32
+	currency   string
+	value      int64
+}
+
+// ResumeClient.
+type ResumeClient struct {
+	store *store.ClientStore
+}
+
+// AdminServer.
+type AdminServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// AdminClient.
+type AdminClient struct {
+	serverAddr string
+	config     *tls.Config
+}
+
+// RenewalServer.
+type RenewalServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// RenewalClient.
+type RenewalClient struct {
+	serverAddr string
+	store      *store.ClientStore
+	config     *tls.Config
+}
+
+// ThresholdServer.
+type ThresholdServer struct {
+	port   int
+	share  *core.ThresholdShare
+	config *tls.Config
+}
+
+// ThresholdClient.
+type ThresholdClient struct {
+	nodes  []string
+	config *tls.Config
+}
+
+// AttestationServer.
+type AttestationServer struct {
+	port   int
+	store  *store.BankStore
+	config *tls.Config
+}
+
+// AttestationClient.
+type AttestationClient struct {
 	serverAddr string
 	store      *store.ClientStore
 	config     *tls.Config
@@ -101,11 +503,90 @@ type ExchangeClient struct {
 
 // GetServer.
 type GetServer struct {
-	port     int
-	filepath string
+	port        int
+	cert        []byte
+	attestation *core.Attestation
 }
 
 // GetClient.
 type GetClient struct {
 	serverAddr string
+	store      *store.ClientStore
+}
+
+// CertManager.
+type CertManager struct {
+	certPath string
+	keyPath  string
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+//
+// RELAY
+//
+
+// RelayServer.
+type RelayServer struct {
+	port      int
+	config    *tls.Config
+	mutex     sync.Mutex
+	merchants map[string]*relayMerchant // Registered merchants, by name.
+	owners    map[string]string         // Fingerprints of the names' certificates.
+	sessions  map[string]*relayPending  // Sessions awaiting their merchant, by id.
+}
+
+// relayMerchant is a merchant registered at a RelayServer, announced its sessions over conn.
+type relayMerchant struct {
+	conn    net.Conn
+	mutex   sync.Mutex
+	encoder *gob.Encoder
+}
+
+// relayPending is a payer's session awaiting its merchant.
+type relayPending struct {
+	name   string
+	joined chan net.Conn
+}
+
+// RelayTransport is the PaymentTransport of merchants reached through a RelayServer, at name@relay.
+type RelayTransport struct {
+	relayAddr string
+	certs     *store.Certificates
+	config    *tls.Config // End to end, with the merchant.
+	name      string
+	own       *store.Certificate
+	get       *GetServer
+}
+
+// relayListener is the PaymentListener of RelayTransport, registered at the relay.
+type relayListener struct {
+	transport *RelayTransport
+	config    *tls.Config // Of the relay, with the merchant's certificate.
+	mutex     sync.Mutex
+	conn      net.Conn
+	decoder   *strictDecoder
+	closed    bool
+}
+
+//
+// AGENT
+//
+
+// AgentServer.
+type AgentServer struct {
+	socketPath string
+	store      *store.ClientStore
+	serverAddr string
+	config     *tls.Config
+	mutex      sync.Mutex
+	listener   net.Listener
+	closed     bool
+	handlers   sync.WaitGroup
+}
+
+// AgentClient.
+type AgentClient struct {
+	socketPath string
 }
@@ -1,23 +1,39 @@
 package store
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
-	"fmt"
+	"encoding/hex"
 	"log"
+	"math/big"
+	"sort"
+	"strings"
 	"time"
 	"ziba/core"
 
 	_ "modernc.org/sqlite"
 )
 
+// Options sets the options of the database opened by New or NewReadOnly.
+func (store *BankStore) Options(options DatabaseOptions) *BankStore {
+	store.options = &options
+	return store
+}
+
 // New allocates and returns a new Bankstore for a certain identity.
 func (store *BankStore) New(dbPath, identity string) (*BankStore, error) {
 	// Get database connection.
-	db, err := openDatabase(dbPath)
+	db, err := openDatabase(dbPath, false, store.options)
 	if err != nil {
 		log.Printf("failed to open database: %v", err)
 		return nil, err
 	}
+	if err := checkRole(db, "Bank"); err != nil {
+		db.Close()
+		log.Printf("failed to open Bank's database at %s: %v", dbPath, err)
+		return nil, err
+	}
 
 	// Grab name.
 	var name string
@@ -25,6 +41,8 @@ func (store *BankStore) New(dbPath, identity string) (*BankStore, error) {
 
 	// Keep values.
 	store.db = db
+	store.statements = new(statementCache).New(db)
+	store.cache = new(recordCache).New()
 	store.Name = name
 	store.identity = identity
 
@@ -39,6 +57,54 @@ func (store *BankStore) New(dbPath, identity string) (*BankStore, error) {
 	return store, nil
 }
 
+// NewReadOnly is like New, but opens the existing database at dbPath read-only, for inspecting and reporting. (Safe to
+// use against a live serving bank)
+func (store *BankStore) NewReadOnly(dbPath, identity string) (*BankStore, error) {
+	// Get database connection.
+	db, err := openDatabase(dbPath, true, store.options)
+	if err != nil {
+		log.Printf("failed to open database: %v", err)
+		return nil, err
+	}
+
+	// Grab name.
+	var name string
+	db.QueryRow(`SELECT name FROM Bank WHERE identity = ?`, identity).Scan(&name)
+
+	// Keep values.
+	store.db = db
+	store.statements = new(statementCache).New(db)
+	store.cache = new(recordCache).New()
+	store.Name = name
+	store.identity = identity
+
+	// Create store.
+	return store, nil
+}
+
+// bankBlobColumns are the big.Int columns of a bank's local database, by table.
+var bankBlobColumns = map[string][]string{
+	"Bank":        {"Priv", "Pub", "scheme_Q", "scheme_P", "scheme_G", "key_P", "key_Q", "key_D", "key_N", "key_E"},
+	"Mint":        {"key_P", "key_Q", "key_D", "key_N", "key_E"},
+	"ClientInfo":  {"K", "S", "Credential", "Contract", "PrivStamp", "IdentityHash", "TradeId", "Pub", "N", "E"},
+	"CoinProfile": {"Pub", "First", "A", "R", "A2", "Second", "Msg"},
+	"CoinChange":  {"ALower", "C", "Claim"},
+}
+
+// bankIndices are the indices of a bank's local database, by name. Lookups by hash use the indices of the UNIQUE
+// constraints.
+var bankIndices = map[string]string{
+	"CoinProfileClient":     "CoinProfile (client, date)",
+	"CoinProfileOperation":  "CoinProfile (operation)",
+	"ClientBalanceCurrency": "ClientBalance (currency)",
+	"ApplicationStatus":     "Application (status)",
+	"OperationLogDate":      "OperationLog (date)",
+	"BalanceLogClient":      "BalanceLog (client, currency, id)",
+	"OperationCountClient":  "OperationCount (client, operation, date)",
+	"TransferPayer":         "Transfer (payer, date)",
+	"TransferPayee":         "Transfer (payee, date)",
+}
+
 // CreateTables creates the database schema for a bank's local database.
 // Only creates the tables if they don't previously exist.
 func (store *BankStore) createTables() error {
@@ -68,7 +134,52 @@ func (store *BankStore) createTables() error {
 	key_Q TEXT NOT NULL,
 	key_D TEXT NOT NULL,
 	key_N TEXT NOT NULL,
-	key_E TEXT NOT NULL
+	key_E TEXT NOT NULL,
+
+	currency TEXT NOT NULL DEFAULT '', -- RsaKey's currency
+
+	sealed TEXT NOT NULL DEFAULT '', -- Priv, key_P, key_Q, key_D encrypted with a passphrase
+
+	fingerprint TEXT NOT NULL DEFAULT '', -- SchemeParams fingerprint
+	provenance  TEXT NOT NULL DEFAULT ''  -- SchemeParams origin, e.g. the file they were loaded from
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "Bank", "sealed", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "Bank", "currency", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "Bank", "fingerprint", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "Bank", "provenance", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+
+	table = `CREATE TABLE IF NOT EXISTS Mint (
+	-- keys
+	id 	 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	identity TEXT NOT NULL,
+	currency TEXT NOT NULL,
+
+	---- RsaKey
+	key_P TEXT NOT NULL,
+	key_Q TEXT NOT NULL,
+	key_D TEXT NOT NULL,
+	key_N TEXT NOT NULL,
+	key_E TEXT NOT NULL,
+
+	sealed TEXT NOT NULL DEFAULT '', -- key_P, key_Q, key_D encrypted with a passphrase
+
+	UNIQUE (identity, currency) ON CONFLICT IGNORE
 	);`
 	_, err = tx.Exec(table)
 	if err != nil {
@@ -91,9 +202,39 @@ func (store *BankStore) createTables() error {
 	TradeId			 TEXT NOT NULL,
 	Pub 				 TEXT NOT NULL,
 	N 					 TEXT NOT NULL,
-	E 					 TEXT NOT NULL, 
-	
-	balance INTEGER NOT NULL
+	E 					 TEXT NOT NULL,
+
+	balance 	 INTEGER NOT NULL, -- DefaultCurrency balance
+	expiration DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z',
+	opened 		 DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z', -- Zero if opened before it was recorded
+	closed 		 DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z'  -- Zero while open
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "ClientInfo", "expiration", `DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z'`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "ClientInfo", "opened", `DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z'`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "ClientInfo", "closed", `DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z'`)
+	if err != nil {
+		return err
+	}
+
+	table = `CREATE TABLE IF NOT EXISTS ClientBalance (
+	-- keys
+	id 	 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client 	 INTEGER NOT NULL, -- ClientProfile hash
+	currency TEXT NOT NULL,
+
+	balance INTEGER NOT NULL,
+
+	UNIQUE (client, currency) ON CONFLICT REPLACE
 	);`
 	_, err = tx.Exec(table)
 	if err != nil {
@@ -114,6 +255,10 @@ func (store *BankStore) createTables() error {
 	Expiration DATETIME NOT NULL,
 	Second 		 TEXT NOT NULL,
 	Msg 			 TEXT NOT NULL,
+	Version 	 INTEGER NOT NULL DEFAULT 0,
+	Memo 			 TEXT NOT NULL DEFAULT '',
+	Currency 	 TEXT NOT NULL DEFAULT '',
+	Value 		 INTEGER NOT NULL DEFAULT 0,
 
 	operation INTEGER NOT NULL,
 	client 	 	INTEGER NOT NULL, -- ClientProfile hash
@@ -123,254 +268,254 @@ func (store *BankStore) createTables() error {
 	if err != nil {
 		return err
 	}
+	err = addColumn(tx, "CoinProfile", "Version", `INTEGER NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "CoinProfile", "Memo", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "CoinProfile", "Currency", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "CoinProfile", "Value", `INTEGER NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
 
-	return tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS CoinChange (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
 
-// WriteBank attempts to write bank into the local database.
-// If an entry exists for this BankStore's identity nothing is written into the database.
-func (store *BankStore) WriteBank(bank *core.Bank, name string) error {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- Change
+	ALower TEXT NOT NULL,
+	C 		 TEXT NOT NULL,
+	Claim  TEXT NOT NULL,
+	Amount INTEGER NOT NULL,
+
+	value 		INTEGER NOT NULL, -- remainder coin's value
+	currency 	TEXT NOT NULL,
+	date 	 		DATETIME NOT NULL,
+	collected DATETIME, -- NULL until collected
+
+	UNIQUE (ALower, C) ON CONFLICT IGNORE
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
 		return err
 	}
-	defer tx.Rollback()
 
-	// Associate Bank's name.
-	store.Name = name
+	table = `CREATE TABLE IF NOT EXISTS ExchangeRate (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
 
-	// Check if an identity already exists.
-	var id int64
-	err = tx.QueryRow(`SELECT id FROM Bank WHERE identity = ?`, store.identity).Scan(&id)
-	if err != sql.ErrNoRows {
-		log.Printf("a bank (id: %d) already exists for identity %s", id, store.identity)
-		return nil
-	}
+	-- Rate
+	fromCurrency TEXT NOT NULL,
+	toCurrency 	 TEXT NOT NULL,
+	Num 				 INTEGER NOT NULL,
+	Den 				 INTEGER NOT NULL,
 
-	stmt := `INSERT INTO
-	Bank 	 (identity, name, Priv, Pub, scheme_Q, scheme_P, scheme_G, key_P, key_Q, key_D, key_N, key_E)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		store.identity,
-		store.Name,
-		toString(bank.Priv),
-		toString(bank.Pub),
-		toString(bank.Scheme.Q),
-		toString(bank.Scheme.P),
-		toString(bank.Scheme.G),
-		toString(bank.Key.P),
-		toString(bank.Key.Q),
-		toString(bank.Key.D),
-		toString(bank.Key.N),
-		toString(bank.Key.E),
-	)
+	date 	 			 DATETIME NOT NULL,
+
+	UNIQUE (fromCurrency, toCurrency) ON CONFLICT REPLACE
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS Revocation (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
 
-// ReadBank attempts to read the entry for this BankStore's identity.
-// If no entry exists the return value is nil.
-func (store *BankStore) ReadBank() (*core.Bank, error) {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- Revocation
+	kind 	 INTEGER NOT NULL, -- 0: coin, 1: account
+	hash 	 INTEGER NOT NULL, -- CoinProfile or ClientProfile hash
+	reason TEXT NOT NULL,
+
+	date 	 DATETIME NOT NULL,
+
+	UNIQUE (kind, hash) ON CONFLICT IGNORE
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
-		return nil, err
+		return err
 	}
-	defer tx.Rollback()
 
-	stmt := `SELECT Priv, Pub, scheme_Q, scheme_P, scheme_G, key_P, key_Q, key_D, key_N, key_E FROM Bank WHERE identity = ?`
-	scanner := new(rowScanner).New(10)
-	err = tx.QueryRow(stmt, store.identity).Scan(scanner.dest...)
-	if err == sql.ErrNoRows {
-		return nil, sql.ErrNoRows
-	} else if err != nil {
-		return nil, err
-	}
-	vals := scanner.Strings()
-	bank := &core.Bank{
-		Priv: fromString(vals[0]),
-		Pub:  fromString(vals[1]),
-		Scheme: core.SchemeParams{
-			Q: fromString(vals[2]),
-			P: fromString(vals[3]),
-			G: fromString(vals[4]),
-		},
-		Key: core.RsaKey{
-			P: fromString(vals[5]),
-			Q: fromString(vals[6]),
-			D: fromString(vals[7]),
-			N: fromString(vals[8]),
-			E: fromString(vals[9]),
-		},
-	}
+	table = `CREATE TABLE IF NOT EXISTS RegistrationToken (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash TEXT UNIQUE NOT NULL, -- SHA-256 of the token
 
-	return bank, tx.Commit()
-}
+	-- RegistrationToken
+	note 	 TEXT NOT NULL,
+	client INTEGER, -- ClientProfile hash, once used
 
-// WriteClientInfo attempts to write client into the local database.
-// If an entry exists for the client's profile hash, ErrExistingClient is returned.
-func (store *BankStore) WriteClientInfo(client *core.ClientInfo) error {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	date 	 DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
 		return err
 	}
-	defer tx.Rollback()
 
-	// Check if this client already exists.
-	var id int64
-	err = tx.QueryRow(`SELECT id FROM ClientInfo WHERE hash = ?`, client.Profile.Hash()).Scan(&id)
-	if err != sql.ErrNoRows {
-		log.Printf("a client (id: %d) already exists", id)
-		return ErrExistingClient
-	}
+	table = `CREATE TABLE IF NOT EXISTS Application (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash INTEGER UNIQUE ON CONFLICT IGNORE NOT NULL, -- ClientProfile hash
 
-	stmt := `INSERT INTO
-	ClientInfo (hash, K, S, Credential, Contract, PrivStamp, IdentityHash, TradeId, Pub, N, E, balance)
-	VALUES 		 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		client.Profile.Hash(),
-		toString(client.K),
-		toString(client.S),
-		toString(client.Credential),
-		toString(client.Contract),
-		toString(client.Profile.PrivStamp),
-		toString(client.Profile.IdentityHash),
-		toString(client.Profile.TradeId),
-		toString(client.Profile.Pub),
-		toString(client.Profile.N),
-		toString(client.Profile.E),
-		100,
-	)
+	-- Application
+	evidence TEXT NOT NULL,
+	status 	 INTEGER NOT NULL, -- Admission_Type
+
+	date 	 DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS Issuance (
+	-- keys
+	currency TEXT PRIMARY KEY,
 
-// ReadClientInfo attempts to read the entry for this client's profile hash.
-// Returns sql.ErrNoRows if no entry exists.
-func (store *BankStore) ReadClientInfo(client *core.ClientProfile) (*core.ClientInfo, error) {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- Issuance
+	coins INTEGER NOT NULL,
+	value INTEGER NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
-		return nil, err
+		return err
 	}
-	defer tx.Rollback()
 
-	// Check if this client already exists.
-	var id int64
-	err = tx.QueryRow(`SELECT id FROM ClientInfo WHERE hash = ?`, client.Hash()).Scan(&id)
-	if err == sql.ErrNoRows {
-		return nil, sql.ErrNoRows
-	} else if err != nil {
-		return nil, err
-	}
+	table = `CREATE TABLE IF NOT EXISTS DoubleSpend (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash INTEGER NOT NULL, -- CoinProfile hash
 
-	stmt := `SELECT K, S, Credential, Contract FROM ClientInfo WHERE hash = ?`
-	scanner := new(rowScanner).New(4)
-	err = tx.QueryRow(stmt, client.Hash()).Scan(scanner.dest...)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-	vals := scanner.Strings()
-	clientInfo := &core.ClientInfo{
-		Profile:    *client,
-		K:          fromString(vals[0]),
-		S:          fromString(vals[1]),
-		Credential: fromString(vals[2]),
-		Contract:   fromString(vals[3]),
+	-- DoubleSpend
+	operation INTEGER NOT NULL,
+	client 		INTEGER NOT NULL, -- ClientProfile hash
+
+	date DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
 	}
 
-	return clientInfo, tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS GraceExchange (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash INTEGER NOT NULL, -- CoinProfile hash
 
-// ReadClientBalance.
-func (store *BankStore) ReadClientBalance(client *core.ClientProfile) (int64, error) {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- GraceExchange
+	currency 		TEXT NOT NULL,
+	value 			INTEGER NOT NULL,
+	expiration 	DATETIME NOT NULL,
+	client 			INTEGER NOT NULL, -- ClientProfile hash
+
+	date DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
-		return 0, err
+		return err
 	}
-	defer tx.Rollback()
 
-	var balance int64
-	stmt := `SELECT balance FROM ClientInfo WHERE hash = ?`
-	err = tx.QueryRow(stmt, client.Hash()).Scan(&balance)
+	table = `CREATE TABLE IF NOT EXISTS BalanceLog (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER NOT NULL, -- ClientProfile hash
+
+	-- BalanceLog
+	currency TEXT NOT NULL,
+	amount 	 INTEGER NOT NULL,
+	balance  INTEGER NOT NULL,
+
+	date DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return balance, tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS OperationLog (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
 
-// UpdateClientBalance.
-func (store *BankStore) UpdateClientBalance(client *core.ClientProfile, balance int64) error {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- OperationRun
+	operation TEXT NOT NULL,
+	duration 	INTEGER NOT NULL, -- Nanoseconds
+	received 	INTEGER NOT NULL, -- Bytes
+	sent 			INTEGER NOT NULL, -- Bytes
+
+	date DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
 		return err
 	}
-	defer tx.Rollback()
 
-	stmt := `UPDATE ClientInfo SET balance = ? WHERE hash = ?`
-	_, err = tx.Exec(stmt, balance, client.Hash())
+	err = createDisputeTable(tx)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
-
-// WriteCoinProfile attempts to write coin into the local database.
-// If an entry exists for the coin's profile hash, ErrExistingCoin is returned.
-func (store *BankStore) WriteCoinProfile(coin *core.CoinProfile, operation Operation_Type, client *core.ClientProfile) error {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	err = createFraudAlertTable(tx)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
 		return err
 	}
-	defer tx.Rollback()
 
-	// Check if this coin already exists.
-	var id int64
-	err = tx.QueryRow(`SELECT id FROM CoinProfile WHERE hash = ?`, coin.Hash()).Scan(&id)
-	if err != sql.ErrNoRows {
-		log.Printf("a coin (id: %d) already exists", id)
-		return ErrExistingCoin
+	err = createOperationCountTable(tx)
+	if err != nil {
+		return err
 	}
 
-	stmt := `INSERT INTO
-	CoinProfile (hash, Pub, First, A, R, A2, Expiration, Second, Msg, operation, client, date)
-	VALUES			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		coin.Hash(),
-		toString(coin.Pub),
-		toString(coin.First),
-		toString(coin.A),
-		toString(coin.R),
-		toString(coin.A2),
-		coin.Expiration,
-		toString(coin.Second),
-		toString(coin.Msg),
-		operation,
-		client.Hash(),
-		time.Now(),
-	)
+	err = createAccountClosureTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createTransferTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createWithdrawalStatusTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createMetaTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createCacheGenerationTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createIndices(tx, bankIndices)
+	if err != nil {
+		return err
+	}
+
+	// Migrate big.Int columns to blobs.
+	err = migrateBlobs(tx, bankBlobColumns)
+	if err != nil {
+		return err
+	}
+
+	// Fingerprint the scheme parameters of banks written before.
+	err = backfillFingerprints(tx, "Bank", "scheme_Q", "scheme_P", "scheme_G")
+	if err != nil {
+		return err
+	}
+
+	// Nothing else to upgrade.
+	err = upgradeSchemaVersion(tx, currentVersion)
 	if err != nil {
 		return err
 	}
@@ -378,232 +523,1644 @@ func (store *BankStore) WriteCoinProfile(coin *core.CoinProfile, operation Opera
 	return tx.Commit()
 }
 
-// ReadCoinProfile attempts to read the entry for this coin's profile hash.
-// Returns sql.ErrNoRows if no entry exists.
-func (store *BankStore) ReadCoinProfile(coin *core.CoinProfile) error {
+// WriteBank attempts to write bank into the local database.
+// If an entry exists for this BankStore's identity nothing is written into the database.
+func (store *BankStore) WriteBank(bank *core.Bank, name string) error {
+	return store.writeBank(bank, name, "")
+}
+
+// WriteSealedBank attempts to write bank into the local database with its secrets encrypted using passphrase.
+// The entry must be unlocked with the same passphrase before reading it.
+// If an entry exists for this BankStore's identity nothing is written into the database.
+func (store *BankStore) WriteSealedBank(bank *core.Bank, name, passphrase string) error {
+	// Encrypt secrets.
+	secrets := strings.Join([]string{
+		toString(bank.Priv),
+		toString(bank.Key.P),
+		toString(bank.Key.Q),
+		toString(bank.Key.D),
+	}, "\n")
+	sealed, err := seal([]byte(secrets), passphrase)
+	if err != nil {
+		return err
+	}
+
+	// Public part.
+	public := &core.Bank{
+		Scheme:   bank.Scheme,
+		Key:      core.RsaKey{N: bank.Key.N, E: bank.Key.E},
+		Pub:      bank.Pub,
+		Currency: bank.Currency,
+	}
+
+	return store.writeBank(public, name, sealed)
+}
+
+// writeBank.
+func (store *BankStore) writeBank(bank *core.Bank, name, sealed string) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Associate Bank's name.
+	store.Name = name
+
+	stmt := `INSERT INTO
+	Bank 	 (identity, name, Priv, Pub, scheme_Q, scheme_P, scheme_G, key_P, key_Q, key_D, key_N, key_E, currency, sealed, fingerprint)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	res, err := tx.Exec(stmt,
+		store.identity,
+		store.Name,
+		toBlob(bank.Priv),
+		toBlob(bank.Pub),
+		toBlob(bank.Scheme.Q),
+		toBlob(bank.Scheme.P),
+		toBlob(bank.Scheme.G),
+		toBlob(bank.Key.P),
+		toBlob(bank.Key.Q),
+		toBlob(bank.Key.D),
+		toBlob(bank.Key.N),
+		toBlob(bank.Key.E),
+		core.NormalizeCurrency(bank.Currency),
+		sealed,
+		bank.Scheme.Fingerprint(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Nothing is written if an identity already exists. (identity is UNIQUE ON CONFLICT IGNORE)
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		log.Printf("a bank already exists for identity %s", store.identity)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	store.cache.forgetBank()
+
+	return nil
+}
+
+// WriteProvenance records the origin of the scheme parameters of the entry for this BankStore's identity, e.g. the
+// file they were loaded from.
+func (store *BankStore) WriteProvenance(provenance string) error {
+	_, err := store.db.Exec(`UPDATE Bank SET provenance = ? WHERE identity = ?`, provenance, store.identity)
+	return err
+}
+
+// Meta returns the settings table of the bank's database.
+func (store *BankStore) Meta() *Meta {
+	return &Meta{db: store.db}
+}
+
+// ReadBank attempts to read the entry for this BankStore's identity.
+// If no entry exists the return value is nil. If the entry is sealed and wasn't unlocked, ErrLockedBank is returned.
+func (store *BankStore) ReadBank() (*core.Bank, error) {
+	// Use the unlocked entry.
+	if store.unlocked != nil {
+		return store.unlocked, nil
+	}
+
+	// Use the cached entry, unless the cached tables changed since.
+	generation, err := store.readGeneration()
+	if err != nil {
+		return nil, err
+	}
+	if bank := store.cache.readBank(generation); bank != nil {
+		return bank, nil
+	}
+
+	bank, sealed, err := store.readBank()
+	if err != nil {
+		return nil, err
+	}
+	if sealed != "" {
+		return nil, ErrLockedBank
+	}
+	store.cache.keepBank(generation, bank)
+
+	return bank, nil
+}
+
+// Unlock decrypts the secrets of this BankStore's identity using passphrase. Following ReadBank calls return the
+// decrypted bank. Returns ErrPassphrase if passphrase is wrong, unsealed entries are left as is.
+func (store *BankStore) Unlock(passphrase string) error {
+	bank, sealed, err := store.readBank()
+	if err != nil {
+		return err
+	}
+	if sealed == "" {
+		return nil
+	}
+
+	// Decrypt secrets.
+	secrets, err := unseal(sealed, passphrase)
+	if err != nil {
+		return err
+	}
+	vals := strings.Split(string(secrets), "\n")
+	if len(vals) != 4 {
+		return ErrPassphrase
+	}
+	bank.Priv = fromString(vals[0])
+	bank.Key.P = fromString(vals[1])
+	bank.Key.Q = fromString(vals[2])
+	bank.Key.D = fromString(vals[3])
+
+	// Decrypt the secrets of every mint.
+	mints, err := store.readMints()
+	if err != nil {
+		return err
+	}
+	unlockedMints := make(map[string]core.RsaKey)
+	for currency, mint := range mints {
+		if mint.sealed == "" {
+			continue
+		}
+		secrets, err := unseal(mint.sealed, passphrase)
+		if err != nil {
+			return err
+		}
+		vals := strings.Split(string(secrets), "\n")
+		if len(vals) != 3 {
+			return ErrPassphrase
+		}
+		unlockedMints[currency] = core.RsaKey{
+			P: fromString(vals[0]),
+			Q: fromString(vals[1]),
+			D: fromString(vals[2]),
+			N: mint.key.N,
+			E: mint.key.E,
+		}
+	}
+
+	store.unlocked = bank
+	store.unlockedMints = unlockedMints
+	return nil
+}
+
+// DeleteBank deletes the entry for this BankStore's identity.
+func (store *BankStore) DeleteBank() error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM Bank WHERE identity = ?`, store.identity)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM Mint WHERE identity = ?`, store.identity)
+	if err != nil {
+		return err
+	}
+	store.unlocked = nil
+	store.unlockedMints = nil
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	store.cache.forgetBank()
+
+	return nil
+}
+
+// readBank reads the entry for this BankStore's identity along with its sealed secrets.
+func (store *BankStore) readBank() (*core.Bank, string, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT Priv, Pub, scheme_Q, scheme_P, scheme_G, key_P, key_Q, key_D, key_N, key_E, currency, sealed, fingerprint FROM Bank WHERE identity = ?`
+	scanner := new(rowScanner).New(13)
+	err = store.statements.queryRow(tx, stmt, store.identity).Scan(scanner.dest...)
+	if err == sql.ErrNoRows {
+		return nil, "", sql.ErrNoRows
+	} else if err != nil {
+		return nil, "", err
+	}
+	vals := scanner.Strings()
+	bank := &core.Bank{
+		Priv: fromBlob(vals[0]),
+		Pub:  fromBlob(vals[1]),
+		Scheme: core.SchemeParams{
+			Q: fromBlob(vals[2]),
+			P: fromBlob(vals[3]),
+			G: fromBlob(vals[4]),
+		},
+		Key: core.RsaKey{
+			P: fromBlob(vals[5]),
+			Q: fromBlob(vals[6]),
+			D: fromBlob(vals[7]),
+			N: fromBlob(vals[8]),
+			E: fromBlob(vals[9]),
+		},
+		Currency: core.NormalizeCurrency(vals[10]),
+	}
+
+	// Check the scheme parameters weren't switched.
+	if err := checkFingerprint(&bank.Scheme, vals[12]); err != nil {
+		return nil, "", err
+	}
+
+	return bank, vals[11], tx.Commit()
+}
+
+// WriteMint attempts to write the key of mint, a mint of this BankStore's identity, into the local database.
+// If an entry exists for the mint's currency nothing is written into the database.
+func (store *BankStore) WriteMint(mint *core.Bank) error {
+	return store.writeMint(mint, "")
+}
+
+// WriteSealedMint attempts to write the key of mint into the local database with its secrets encrypted using
+// passphrase, the passphrase of this BankStore's identity.
+// If an entry exists for the mint's currency nothing is written into the database.
+func (store *BankStore) WriteSealedMint(mint *core.Bank, passphrase string) error {
+	// Encrypt secrets.
+	secrets := strings.Join([]string{
+		toString(mint.Key.P),
+		toString(mint.Key.Q),
+		toString(mint.Key.D),
+	}, "\n")
+	sealed, err := seal([]byte(secrets), passphrase)
+	if err != nil {
+		return err
+	}
+
+	// Public part.
+	public := &core.Bank{
+		Key:      core.RsaKey{N: mint.Key.N, E: mint.Key.E},
+		Currency: mint.Currency,
+	}
+
+	return store.writeMint(public, sealed)
+}
+
+// writeMint.
+func (store *BankStore) writeMint(mint *core.Bank, sealed string) error {
+	stmt := `INSERT INTO
+	Mint 	 (identity, currency, key_P, key_Q, key_D, key_N, key_E, sealed)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt,
+		store.identity,
+		core.NormalizeCurrency(mint.Currency),
+		toBlob(mint.Key.P),
+		toBlob(mint.Key.Q),
+		toBlob(mint.Key.D),
+		toBlob(mint.Key.N),
+		toBlob(mint.Key.E),
+		sealed,
+	)
+	return err
+}
+
+// ReadMint returns the bank issuing coins in currency: this BankStore's identity, with the mint's key if currency
+// isn't the identity's own. Returns ErrUnknownCurrency if the identity has no mint for currency, or ErrLockedBank if
+// the mint is sealed and wasn't unlocked.
+func (store *BankStore) ReadMint(currency string) (*core.Bank, error) {
+	bank, err := store.ReadBank()
+	if err != nil {
+		return nil, err
+	}
+	currency = core.NormalizeCurrency(currency)
+	if currency == bank.Currency {
+		return bank, nil
+	}
+
+	// Read mint.
+	mints, err := store.readMints()
+	if err != nil {
+		return nil, err
+	}
+	mint, ok := mints[currency]
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	key := mint.key
+	if mint.sealed != "" {
+		if key, ok = store.unlockedMints[currency]; !ok {
+			return nil, ErrLockedBank
+		}
+	}
+
+	minted := *bank
+	minted.Key = key
+	minted.Currency = currency
+	return &minted, nil
+}
+
+// ReadMints returns the profiles of every mint of this BankStore's identity, starting with the identity's own.
+// Secrets are not needed, sealed mints are returned as well.
+func (store *BankStore) ReadMints() ([]core.BankProfile, error) {
+	bank, _, err := store.readBank()
+	if err != nil {
+		return nil, err
+	}
+	mints, err := store.readMints()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []core.BankProfile{*bank.Profile()}
+	for currency, mint := range mints {
+		profile := bank.Profile()
+		profile.N = mint.key.N
+		profile.E = mint.key.E
+		profile.Currency = currency
+		profiles = append(profiles, *profile)
+	}
+	sort.Slice(profiles[1:], func(i, j int) bool { return profiles[1+i].Currency < profiles[1+j].Currency })
+
+	return profiles, nil
+}
+
+// storedMint is a mint's entry along with its sealed secrets.
+type storedMint struct {
+	key    core.RsaKey
+	sealed string
+}
+
+// readMints reads the mint entries for this BankStore's identity, by currency.
+func (store *BankStore) readMints() (map[string]storedMint, error) {
+	stmt := `SELECT currency, key_P, key_Q, key_D, key_N, key_E, sealed FROM Mint WHERE identity = ?`
+	rows, err := store.db.Query(stmt, store.identity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mints := make(map[string]storedMint)
+	for rows.Next() {
+		scanner := new(rowScanner).New(7)
+		if err := rows.Scan(scanner.dest...); err != nil {
+			return nil, err
+		}
+		vals := scanner.Strings()
+		mints[vals[0]] = storedMint{
+			key: core.RsaKey{
+				P: fromBlob(vals[1]),
+				Q: fromBlob(vals[2]),
+				D: fromBlob(vals[3]),
+				N: fromBlob(vals[4]),
+				E: fromBlob(vals[5]),
+			},
+			sealed: vals[6],
+		}
+	}
+
+	return mints, rows.Err()
+}
+
+// WriteClientInfo attempts to write client into the local database.
+// If an entry exists for the client's profile hash, ErrExistingClient is returned.
+func (store *BankStore) WriteClientInfo(client *core.ClientInfo) error {
+	return retryBusy(func() error {
+		return store.writeClientInfo(client)
+	})
+}
+
+// writeClientInfo is WriteClientInfo, run once.
+func (store *BankStore) writeClientInfo(client *core.ClientInfo) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO
+	ClientInfo (hash, K, S, Credential, Contract, PrivStamp, IdentityHash, TradeId, Pub, N, E, balance, expiration, opened)
+	VALUES 		 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	res, err := store.statements.exec(tx, stmt,
+		client.Profile.Hash(),
+		toBlob(client.K),
+		toBlob(client.S),
+		toBlob(client.Credential),
+		toBlob(client.Contract),
+		toBlob(client.Profile.PrivStamp),
+		toBlob(client.Profile.IdentityHash),
+		toBlob(client.Profile.TradeId),
+		toBlob(client.Profile.Pub),
+		toBlob(client.Profile.N),
+		toBlob(client.Profile.E),
+		initialBalance,
+		client.Expiration,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrExistingClient
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	store.cache.forgetClient(client.Profile.Hash())
+
+	return nil
+}
+
+// UpdateClientInfo attempts to replace the credentials of the entry for this client's profile hash.
+// Returns sql.ErrNoRows if no entry exists.
+func (store *BankStore) UpdateClientInfo(client *core.ClientInfo) error {
+	return retryBusy(func() error {
+		return store.updateClientInfo(client)
+	})
+}
+
+// updateClientInfo is UpdateClientInfo, run once.
+func (store *BankStore) updateClientInfo(client *core.ClientInfo) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE ClientInfo SET K = ?, S = ?, Credential = ?, Contract = ?, expiration = ? WHERE hash = ?`
+	res, err := store.statements.exec(tx, stmt,
+		toBlob(client.K),
+		toBlob(client.S),
+		toBlob(client.Credential),
+		toBlob(client.Contract),
+		client.Expiration,
+		client.Profile.Hash(),
+	)
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return sql.ErrNoRows
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	store.cache.forgetClient(client.Profile.Hash())
+
+	return nil
+}
+
+// ReadClientInfo attempts to read the entry for this client's profile hash, along with the profile stored in it.
+// Returns sql.ErrNoRows if no entry exists.
+func (store *BankStore) ReadClientInfo(client *core.ClientProfile) (*core.ClientInfo, error) {
+	// Use the cached entry, unless the cached tables changed since.
+	generation, err := store.readGeneration()
+	if err != nil {
+		return nil, err
+	}
+	if clientInfo := store.cache.readClient(generation, client); clientInfo != nil {
+		return clientInfo, nil
+	}
+
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Check if this client already exists.
+	var id int64
+	err = store.statements.queryRow(tx, `SELECT id FROM ClientInfo WHERE hash = ?`, client.Hash()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, sql.ErrNoRows
+	} else if err != nil {
+		return nil, err
+	}
+
+	stmt := `SELECT K, S, Credential, Contract, PrivStamp, IdentityHash, TradeId, Pub, N, E, expiration FROM ClientInfo WHERE hash = ?`
+	scanner := new(rowScanner).New(10)
+	var expiration time.Time
+	err = store.statements.queryRow(tx, stmt, client.Hash()).Scan(append(scanner.dest, &expiration)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	vals := scanner.Strings()
+	clientInfo := &core.ClientInfo{
+		Profile: core.ClientProfile{
+			PrivStamp:    fromBlob(vals[4]),
+			IdentityHash: fromBlob(vals[5]),
+			TradeId:      fromBlob(vals[6]),
+			Pub:          fromBlob(vals[7]),
+			N:            fromBlob(vals[8]),
+			E:            fromBlob(vals[9]),
+		},
+		K:          fromBlob(vals[0]),
+		S:          fromBlob(vals[1]),
+		Credential: fromBlob(vals[2]),
+		Contract:   fromBlob(vals[3]),
+		Expiration: expiration,
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	store.cache.keepClient(generation, clientInfo)
+
+	return clientInfo, nil
+}
+
+// ReadClientBalance returns client's balance in currency. Balances in currencies other than DefaultCurrency start at
+// initialBalance.
+func (store *BankStore) ReadClientBalance(client *core.ClientProfile, currency string) (int64, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	balance, err := store.clientBalance(tx, client, currency)
+	if err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit()
+}
+
+// clientBalance is ReadClientBalance, using tx.
+func (store *BankStore) clientBalance(tx *sql.Tx, client *core.ClientProfile, currency string) (int64, error) {
+	var balance int64
+	stmt := `SELECT balance FROM ClientInfo WHERE hash = ?`
+	err := store.statements.queryRow(tx, stmt, client.Hash()).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+
+	if currency = core.NormalizeCurrency(currency); currency != core.DefaultCurrency {
+		stmt = `SELECT balance FROM ClientBalance WHERE client = ? AND currency = ?`
+		err = store.statements.queryRow(tx, stmt, client.Hash(), currency).Scan(&balance)
+		if err == sql.ErrNoRows {
+			balance = initialBalance
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return balance, nil
+}
+
+// UpdateClientBalance sets client's balance in currency.
+func (store *BankStore) UpdateClientBalance(client *core.ClientProfile, currency string, balance int64) error {
+	return retryBusy(func() error {
+		return store.updateClientBalance(client, currency, balance)
+	})
+}
+
+// updateClientBalance is UpdateClientBalance, run once.
+func (store *BankStore) updateClientBalance(client *core.ClientProfile, currency string, balance int64) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.setClientBalance(tx, client, currency, balance); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DebitClientBalance debits value from client's balance in currency, read and set at once so that concurrent
+// withdrawals don't debit the same balance. Returns ErrInsufficientFunds if the balance is lower than value.
+func (store *BankStore) DebitClientBalance(client *core.ClientProfile, currency string, value int64) error {
+	return retryBusy(func() error {
+		return store.debitClientBalance(client, currency, value)
+	})
+}
+
+// debitClientBalance is DebitClientBalance, run once.
+func (store *BankStore) debitClientBalance(client *core.ClientProfile, currency string, value int64) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.debit(tx, client, currency, value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreditClientBalance adds value to client's balance in currency at once, appending the change to the audit log, and
+// returns the new balance. Returns ErrUnknownClient if there's no such client.
+func (store *BankStore) CreditClientBalance(client *core.ClientProfile, currency string, value int64) (int64, error) {
+	var balance int64
+	err := retryBusy(func() (err error) {
+		balance, err = store.creditClientBalance(client, currency, value)
+		return err
+	})
+	return balance, err
+}
+
+// creditClientBalance is CreditClientBalance, run once.
+func (store *BankStore) creditClientBalance(client *core.ClientProfile, currency string, value int64) (int64, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	balance, err := store.credit(tx, client.Hash(), currency, value)
+	if err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit()
+}
+
+// debit debits value from client's balance in currency using tx, appending the change to the audit log. Returns
+// ErrInsufficientFunds if the balance is lower than value.
+// (The balance is checked by the update itself: a transaction writing first holds the database's write lock before it
+// reads anything, concurrent debits can't read the same balance)
+func (store *BankStore) debit(tx *sql.Tx, client *core.ClientProfile, currency string, value int64) error {
+	var (
+		res sql.Result
+		err error
+	)
+	if currency = core.NormalizeCurrency(currency); currency == core.DefaultCurrency {
+		stmt := `UPDATE ClientInfo SET balance = balance - ? WHERE hash = ? AND balance >= ?`
+		res, err = store.statements.exec(tx, stmt, value, client.Hash(), value)
+	} else {
+		// Balances in other currencies start at initialBalance.
+		stmt := `INSERT OR IGNORE INTO ClientBalance (client, currency, balance) VALUES (?, ?, ?)`
+		if _, err := store.statements.exec(tx, stmt, client.Hash(), currency, initialBalance); err != nil {
+			return err
+		}
+		stmt = `UPDATE ClientBalance SET balance = balance - ? WHERE client = ? AND currency = ? AND balance >= ?`
+		res, err = store.statements.exec(tx, stmt, value, client.Hash(), currency, value)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInsufficientFunds
+	}
+
+	balance, err := store.clientBalance(tx, client, currency)
+	if err != nil {
+		return err
+	}
+	return store.logBalance(tx, client.Hash(), currency, -value, balance)
+}
+
+// setClientBalance sets the balance in currency of client using tx, appending the change to the audit log.
+func (store *BankStore) setClientBalance(tx *sql.Tx, client *core.ClientProfile, currency string, balance int64) error {
+	// Grab the previous balance, for the audit log.
+	var (
+		previous int64
+		err      error
+	)
+	if currency = core.NormalizeCurrency(currency); currency == core.DefaultCurrency {
+		stmt := `SELECT balance FROM ClientInfo WHERE hash = ?`
+		err = store.statements.queryRow(tx, stmt, client.Hash()).Scan(&previous)
+	} else {
+		stmt := `SELECT balance FROM ClientBalance WHERE client = ? AND currency = ?`
+		err = store.statements.queryRow(tx, stmt, client.Hash(), currency).Scan(&previous)
+		if err == sql.ErrNoRows {
+			previous, err = initialBalance, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if currency == core.DefaultCurrency {
+		stmt := `UPDATE ClientInfo SET balance = ? WHERE hash = ?`
+		_, err = store.statements.exec(tx, stmt, balance, client.Hash())
+	} else {
+		stmt := `INSERT INTO ClientBalance (client, currency, balance) VALUES (?, ?, ?)`
+		_, err = store.statements.exec(tx, stmt, client.Hash(), currency, balance)
+	}
+	if err != nil {
+		return err
+	}
+	return store.logBalance(tx, client.Hash(), currency, balance-previous, balance)
+}
+
+// WriteCoinProfile attempts to write coin into the local database.
+// If an entry exists for the coin's profile hash, ErrExistingCoin is returned.
+func (store *BankStore) WriteCoinProfile(coin *core.CoinProfile, operation Operation_Type, client *core.ClientProfile) error {
+	return store.WriteCoinProfiles([]*core.CoinProfile{coin}, operation, client)
+}
+
+// WriteCoinProfiles is like WriteCoinProfile for several coins at once. Either every coin is written or none is.
+func (store *BankStore) WriteCoinProfiles(coins []*core.CoinProfile, operation Operation_Type, client *core.ClientProfile) error {
+	return retryBusy(func() error {
+		return store.writeCoinProfiles(coins, operation, client)
+	})
+}
+
+// writeCoinProfiles is WriteCoinProfiles, run once.
+func (store *BankStore) writeCoinProfiles(coins []*core.CoinProfile, operation Operation_Type, client *core.ClientProfile) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.insertCoinProfiles(tx, coins, operation, client); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertCoinProfiles inserts coins using tx. Returns ErrExistingCoin if an entry exists for any coin's profile hash.
+func (store *BankStore) insertCoinProfiles(tx *sql.Tx, coins []*core.CoinProfile, operation Operation_Type, client *core.ClientProfile) error {
+	for _, coin := range coins {
+		stmt := `INSERT INTO
+		CoinProfile (hash, Pub, First, A, R, A2, Expiration, Second, Msg, Version, Currency, Value, operation, client, date)
+		VALUES			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+		res, err := store.statements.exec(tx, stmt,
+			coin.Hash(),
+			toBlob(coin.Pub),
+			toBlob(coin.First),
+			toBlob(coin.A),
+			toBlob(coin.R),
+			toBlob(coin.A2),
+			coin.Expiration,
+			toBlob(coin.Second),
+			toBlob(coin.Msg),
+			coin.Version,
+			core.NormalizeCurrency(coin.Currency),
+			core.NormalizeValue(coin.Value),
+			operation,
+			client.Hash(),
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+		if count, err := res.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return ErrExistingCoin
+		}
+	}
+	return nil
+}
+
+// WriteRate writes the exchange rate rate, replacing any previous rate between the same currencies.
+func (store *BankStore) WriteRate(rate *core.Rate) error {
+	stmt := `INSERT INTO
+	ExchangeRate (fromCurrency, toCurrency, Num, Den, date)
+	VALUES 			 (?, ?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt,
+		core.NormalizeCurrency(rate.From),
+		core.NormalizeCurrency(rate.To),
+		rate.Num,
+		rate.Den,
+		time.Now(),
+	)
+	return err
+}
+
+// ReadRate returns the exchange rate from currency from to currency to, 1/1 for the same currency. Returns
+// ErrUnknownRate if there is none.
+func (store *BankStore) ReadRate(from, to string) (*core.Rate, error) {
+	from, to = core.NormalizeCurrency(from), core.NormalizeCurrency(to)
+	if from == to {
+		return core.Identity(from), nil
+	}
+
+	rate := core.Rate{From: from, To: to}
+	stmt := `SELECT Num, Den FROM ExchangeRate WHERE fromCurrency = ? AND toCurrency = ?`
+	err := store.db.QueryRow(stmt, from, to).Scan(&rate.Num, &rate.Den)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownRate
+	} else if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// Revocation kinds.
+const (
+	revokedCoin = iota
+	frozenAccount
+)
+
+// RevokeCoin adds the coin of hash to the revocation list, published to merchants.
+func (store *BankStore) RevokeCoin(hash uint32, reason string) error {
+	return store.revoke(revokedCoin, hash, reason)
+}
+
+// FreezeAccount adds the account of hash to the revocation list, published to merchants.
+func (store *BankStore) FreezeAccount(hash uint32, reason string) error {
+	return store.revoke(frozenAccount, hash, reason)
+}
+
+// revoke adds hash to the revocation list as kind.
+func (store *BankStore) revoke(kind int, hash uint32, reason string) error {
+	stmt := `INSERT INTO Revocation (kind, hash, reason, date) VALUES (?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt, kind, hash, reason, time.Now())
+	return err
+}
+
+// ReadRevocations returns the (unsigned) revocation list.
+func (store *BankStore) ReadRevocations() (*core.Revocations, error) {
+	rows, err := store.db.Query(`SELECT kind, hash FROM Revocation ORDER BY hash`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := new(core.Revocations)
+	for rows.Next() {
+		var (
+			kind int
+			hash uint32
+		)
+		if err := rows.Scan(&kind, &hash); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case revokedCoin:
+			list.Coins = append(list.Coins, hash)
+		case frozenAccount:
+			list.Accounts = append(list.Accounts, hash)
+		}
+	}
+
+	return list, rows.Err()
+}
+
+// FundAccount adds amount to the balance in currency of the client of hash, and returns the new balance.
+func (store *BankStore) FundAccount(hash uint32, currency string, amount int64) (int64, error) {
+	var balance int64
+	err := retryBusy(func() (err error) {
+		balance, err = store.fundAccount(hash, currency, amount)
+		return err
+	})
+	return balance, err
+}
+
+// fundAccount is FundAccount, run once.
+func (store *BankStore) fundAccount(hash uint32, currency string, amount int64) (int64, error) {
 	// Begin a transaction.
 	tx, err := store.db.Begin()
 	if err != nil {
 		log.Printf("failed to initiate transaction: %v", err)
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
 
-	// Check if this coin already exists.
-	var id int64
-	err = tx.QueryRow(`SELECT id FROM CoinProfile WHERE hash = ?`, coin.Hash()).Scan(&id)
+	balance, err := store.credit(tx, hash, currency, amount)
+	if err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit()
+}
+
+// credit adds amount to the balance in currency of the client of hash using tx, appending the change to the audit log,
+// and returns the new balance. Returns ErrUnknownClient if there's no such client.
+func (store *BankStore) credit(tx *sql.Tx, hash uint32, currency string, amount int64) (int64, error) {
+	var balance int64
+	stmt := `SELECT balance FROM ClientInfo WHERE hash = ?`
+	err := tx.QueryRow(stmt, hash).Scan(&balance)
 	if err == sql.ErrNoRows {
-		return sql.ErrNoRows
+		return 0, ErrUnknownClient
+	} else if err != nil {
+		return 0, err
+	}
+
+	if currency = core.NormalizeCurrency(currency); currency == core.DefaultCurrency {
+		balance += amount
+		_, err = tx.Exec(`UPDATE ClientInfo SET balance = ? WHERE hash = ?`, balance, hash)
 	} else {
+		stmt = `SELECT balance FROM ClientBalance WHERE client = ? AND currency = ?`
+		err = tx.QueryRow(stmt, hash, currency).Scan(&balance)
+		if err == sql.ErrNoRows {
+			balance = initialBalance
+		} else if err != nil {
+			return 0, err
+		}
+		balance += amount
+		stmt = `INSERT INTO ClientBalance (client, currency, balance) VALUES (?, ?, ?)`
+		_, err = tx.Exec(stmt, hash, currency, balance)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := store.logBalance(tx, hash, currency, amount, balance); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// NewRegistrationToken creates a one-time registration token, to be handed out of band. Only its hash is stored.
+func (store *BankStore) NewRegistrationToken(note string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+
+	stmt := `INSERT INTO RegistrationToken (hash, note, date) VALUES (?, ?, ?);`
+	if _, err := store.db.Exec(stmt, tokenHash(token), note, time.Now()); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeRegistrationToken marks token as used by client. A token can't be used by other clients afterwards, but
+// can be presented again by client. (While its application is pending)
+func (store *BankStore) ConsumeRegistrationToken(token string, client *core.ClientProfile) error {
+	return retryBusy(func() error {
+		return store.consumeRegistrationToken(token, client)
+	})
+}
+
+// consumeRegistrationToken is ConsumeRegistrationToken, run once.
+func (store *BankStore) consumeRegistrationToken(token string, client *core.ClientProfile) error {
+	stmt := `UPDATE RegistrationToken SET client = ? WHERE hash = ? AND (client IS NULL OR client = ?)`
+	res, err := store.db.Exec(stmt, client.Hash(), tokenHash(token), client.Hash())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRegistrationToken
+	}
+	return nil
+}
+
+// tokenHash returns the stored hash of a registration token.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Application is an account application awaiting the bank's approval.
+type Application struct {
+	Hash     uint32 // ClientProfile hash
+	Evidence string
+	Status   Admission_Type
+	Date     time.Time
+}
+
+// WriteApplication records an application for an account for client, pending approval. Nothing is written if client
+// already applied.
+func (store *BankStore) WriteApplication(client *core.ClientProfile, evidence string) error {
+	stmt := `INSERT INTO Application (hash, evidence, status, date) VALUES (?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt, client.Hash(), evidence, Admission_Pending, time.Now())
+	return err
+}
+
+// ReadAdmission returns the status of the application of the client of hash.
+func (store *BankStore) ReadAdmission(hash uint32) (Admission_Type, error) {
+	var status Admission_Type
+	err := store.db.QueryRow(`SELECT status FROM Application WHERE hash = ?`, hash).Scan(&status)
+	if err == sql.ErrNoRows {
+		return Admission_Pending, ErrUnknownApplication
+	}
+	return status, err
+}
+
+// UpdateAdmission sets the status of the application of the client of hash.
+func (store *BankStore) UpdateAdmission(hash uint32, status Admission_Type) error {
+	res, err := store.db.Exec(`UPDATE Application SET status = ? WHERE hash = ?`, status, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
 		return err
+	} else if n == 0 {
+		return ErrUnknownApplication
+	}
+	return nil
+}
+
+// ReadAccountOpened returns the date the account of client was opened, zero if opened before it was recorded.
+// Returns ErrUnknownClient if client has no account.
+func (store *BankStore) ReadAccountOpened(client *core.ClientProfile) (time.Time, error) {
+	var opened time.Time
+	err := store.db.QueryRow(`SELECT opened FROM ClientInfo WHERE hash = ?`, client.Hash()).Scan(&opened)
+	if err == sql.ErrNoRows {
+		return opened, ErrUnknownClient
+	}
+	return opened, err
+}
+
+// ReadApplications returns the applications with status, oldest first.
+func (store *BankStore) ReadApplications(status Admission_Type) ([]Application, error) {
+	rows, err := store.db.Query(`SELECT hash, evidence, status, date FROM Application WHERE status = ? ORDER BY id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applications []Application
+	for rows.Next() {
+		var application Application
+		if err := rows.Scan(&application.Hash, &application.Evidence, &application.Status, &application.Date); err != nil {
+			return nil, err
+		}
+		applications = append(applications, application)
 	}
+	return applications, rows.Err()
+}
+
+// BankReport summarizes the bank's accounts and surrendered coins.
+type BankReport struct {
+	Clients  int64
+	Balances map[string]int64         // Sum of balances, by currency.
+	Coins    map[Operation_Type]int64 // Surrendered coins, by operation.
+	Revoked  int64
+	Frozen   int64
 }
 
-// Inspect.
-func (store *BankStore) Inspect() {
+// Report returns a summary of the bank's accounts and surrendered coins.
+func (store *BankStore) Report() (*BankReport, error) {
 	// Begin a transaction.
 	tx, err := store.db.Begin()
 	if err != nil {
-		log.Fatalf("failed to initiate transaction: %v", err)
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	// Bank.
-	fmt.Printf("\nBANK\n")
-	rows, err := tx.Query(`SELECT id, name, identity FROM Bank`)
+	report := &BankReport{
+		Balances: make(map[string]int64),
+		Coins:    make(map[Operation_Type]int64),
+	}
+
+	// Accounts.
+	var balance int64
+	stmt := `SELECT COUNT(*), COALESCE(SUM(balance), 0) FROM ClientInfo`
+	if err := tx.QueryRow(stmt).Scan(&report.Clients, &balance); err != nil {
+		return nil, err
+	}
+	report.Balances[core.DefaultCurrency] = balance
+
+	// Balances in other currencies. (Accounts without a row hold initialBalance)
+	rows, err := tx.Query(`SELECT currency, COUNT(*), SUM(balance) FROM ClientBalance GROUP BY currency`)
 	if err != nil {
-		log.Fatalf("failed to query Bank table: %v", err)
+		return nil, err
 	}
-	fmt.Printf("%-5s %-10s %-10s\n", "ID", "Name", "Identity")
 	for rows.Next() {
-		// Scanner variables.
 		var (
-			id       int64
-			name     string
-			identity string
+			currency string
+			count    int64
 		)
-
-		err = rows.Scan(&id, &name, &identity)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+		if err := rows.Scan(&currency, &count, &balance); err != nil {
+			rows.Close()
+			return nil, err
 		}
-
-		fmt.Printf("%-5d %-10s %-10s\n", id, name, identity)
+		report.Balances[currency] = balance + (report.Clients-count)*initialBalance
 	}
+	rows.Close()
 
-	// ClientInfo.
-	fmt.Printf("\nCLIENT INFO\n")
-	rows, err = tx.Query(`SELECT id, hash, balance FROM ClientInfo`)
+	// Surrendered coins.
+	rows, err = tx.Query(`SELECT operation, COUNT(*) FROM CoinProfile GROUP BY operation`)
 	if err != nil {
-		log.Fatalf("failed to query ClientInfo table: %v", err)
+		return nil, err
 	}
-	fmt.Printf("%-5s %-10s %-10s\n", "ID", "ClientHash", "Balance")
 	for rows.Next() {
-		// Scanner variables.
 		var (
-			id      int64
-			client  int64
-			balance int64
+			operation Operation_Type
+			count     int64
 		)
+		if err := rows.Scan(&operation, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Coins[operation] = count
+	}
+	rows.Close()
 
-		err = rows.Scan(&id, &client, &balance)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	// Revocations.
+	stmt = `SELECT COUNT(*) FROM Revocation WHERE kind = ?`
+	if err := tx.QueryRow(stmt, revokedCoin).Scan(&report.Revoked); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(stmt, frozenAccount).Scan(&report.Frozen); err != nil {
+		return nil, err
+	}
+
+	return report, tx.Commit()
+}
+
+// WriteIssuance counts coins issued by the bank, worth value in currency, towards the outstanding coins of BankStats.
+func (store *BankStore) WriteIssuance(currency string, coins, value int64) error {
+	return retryBusy(func() error {
+		stmt := `INSERT INTO Issuance (currency, coins, value) VALUES (?, ?, ?)
+		ON CONFLICT (currency) DO UPDATE SET coins = coins + excluded.coins, value = value + excluded.value`
+		_, err := store.db.Exec(stmt, core.NormalizeCurrency(currency), coins, value)
+		return err
+	})
+}
+
+// WriteDoubleSpend records the attempt of client to spend again, by operation, those of coins already surrendered.
+func (store *BankStore) WriteDoubleSpend(coins []*core.CoinProfile, operation Operation_Type, client *core.ClientProfile) error {
+	return retryBusy(func() error {
+		stmt := `INSERT INTO DoubleSpend (hash, operation, client, date)
+		SELECT ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM CoinProfile WHERE hash = ?)`
+		for _, coin := range coins {
+			if _, err := store.db.Exec(stmt, coin.Hash(), operation, client.Hash(), time.Now(), coin.Hash()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteGraceExchange records the exchange by client of coins already expired, during their grace period.
+func (store *BankStore) WriteGraceExchange(coins []*core.CoinProfile, client *core.ClientProfile) error {
+	return retryBusy(func() error {
+		stmt := `INSERT INTO GraceExchange (hash, currency, value, expiration, client, date) VALUES (?, ?, ?, ?, ?, ?)`
+		for _, coin := range coins {
+			_, err := store.db.Exec(stmt,
+				coin.Hash(),
+				core.NormalizeCurrency(coin.Currency),
+				core.NormalizeValue(coin.Value),
+				coin.Expiration.UTC(),
+				client.Hash(),
+				time.Now().UTC(),
+			)
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
 
-		fmt.Printf("%-5d %-10d %-10d\n", id, client, balance)
+// BankStats are the aggregate statistics of the bank, e.g. for a dashboard, as returned by Stats.
+type BankStats struct {
+	BankReport
+	Outstanding    map[string]int64 // Value of the coins issued but not surrendered yet, by currency.
+	DepositsToday  int64            // Coins deposited since midnight. (UTC)
+	DepositedToday map[string]int64 // Value of the coins deposited since midnight, by currency.
+	DoubleSpends   int64
+	GraceExchanges int64 // Expired coins exchanged during their grace period.
+	Date           time.Time
+}
+
+// Stats returns the aggregate statistics of the bank. Coins issued before the Issuance table existed aren't counted as
+// outstanding, the value of their surrender is.
+func (store *BankStore) Stats() (*BankStats, error) {
+	report, err := store.Report()
+	if err != nil {
+		return nil, err
+	}
+	stats := &BankStats{
+		BankReport:     *report,
+		Outstanding:    make(map[string]int64),
+		DepositedToday: make(map[string]int64),
+		Date:           time.Now().UTC(),
 	}
 
-	// CoinProfile.
-	fmt.Printf("\nCOIN PROFILE\n")
-	rows, err = tx.Query(`SELECT id, hash, operation, client, date FROM CoinProfile`)
+	// Begin a transaction.
+	tx, err := store.db.Begin()
 	if err != nil {
-		log.Fatalf("failed to query CoinProfile table: %v", err)
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Outstanding coins, issued less surrendered.
+	stmt := `SELECT currency, SUM(value) FROM (
+		SELECT currency, value FROM Issuance
+		UNION ALL
+		SELECT Currency, -Value FROM CoinProfile
+	) GROUP BY currency`
+	rows, err := tx.Query(stmt)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("%-5s %-10s %-10s %-10s %-23s\n", "ID", "CoinHash", "Operation", "ClientHash", "Date")
 	for rows.Next() {
-		// Scanner variables.
 		var (
-			id         int64
-			coinHash   int64
-			operation  Operation_Type
-			clientHash int64
-			date       time.Time
+			currency string
+			value    int64
 		)
-
-		err = rows.Scan(&id, &coinHash, &operation, &clientHash, &date)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+		if err := rows.Scan(&currency, &value); err != nil {
+			rows.Close()
+			return nil, err
 		}
+		stats.Outstanding[currency] = value
+	}
+	rows.Close()
 
-		var operationStr string
-		switch operation {
-		case Operation_Deposit:
-			operationStr = "Deposit"
-		case Operation_Exchange:
-			operationStr = "Exchange"
-		default:
+	// Deposits since midnight. (Coins are dated in local time, so is midnight to compare alike)
+	midnight := stats.Date.Truncate(24 * time.Hour).Local()
+	stmt = `SELECT Currency, COUNT(*), SUM(Value) FROM CoinProfile WHERE operation = ? AND date >= ? GROUP BY Currency`
+	rows, err = tx.Query(stmt, Operation_Deposit, midnight)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			currency     string
+			count, value int64
+		)
+		if err := rows.Scan(&currency, &count, &value); err != nil {
+			rows.Close()
+			return nil, err
 		}
+		stats.DepositsToday += count
+		stats.DepositedToday[currency] = value
+	}
+	rows.Close()
 
-		fmt.Printf("%-5d %-10.10d %-10s %-10.10d %-23s\n", id, coinHash, operationStr, clientHash, date.String()[:23])
+	// Double spends.
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM DoubleSpend`).Scan(&stats.DoubleSpends); err != nil {
+		return nil, err
 	}
 
-	// Commit transaction.
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("failed to commit transaction: %v", err)
+	// Grace exchanges.
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM GraceExchange`).Scan(&stats.GraceExchanges); err != nil {
+		return nil, err
+	}
+
+	return stats, tx.Commit()
+}
+
+// WriteCoinMemo records the memo of a coin previously written by WriteCoinProfile.
+func (store *BankStore) WriteCoinMemo(coin *core.CoinProfile, memo *core.Memo) error {
+	return retryBusy(func() error {
+		return store.writeCoinMemo(coin, memo)
+	})
+}
+
+// writeCoinMemo is WriteCoinMemo, run once.
+func (store *BankStore) writeCoinMemo(coin *core.CoinProfile, memo *core.Memo) error {
+	_, err := store.db.Exec(`UPDATE CoinProfile SET Memo = ? WHERE hash = ?`, memo.Text, coin.Hash())
+	return err
+}
+
+// ReadCoinMemo returns the memo recorded for coin, empty if it has none.
+func (store *BankStore) ReadCoinMemo(coin *core.CoinProfile) (string, error) {
+	var memo string
+	err := store.db.QueryRow(`SELECT Memo FROM CoinProfile WHERE hash = ?`, coin.Hash()).Scan(&memo)
+	return memo, err
+}
+
+// WriteChange writes the remainder coin request of a partial payment, until its payer collects it.
+// If an entry exists for the remainder coin request nothing is written into the database.
+func (store *BankStore) WriteChange(change *PendingChange) error {
+	return retryBusy(func() error {
+		return store.writeChange(change)
+	})
+}
+
+// writeChange is WriteChange, run once.
+func (store *BankStore) writeChange(change *PendingChange) error {
+	stmt := `INSERT INTO
+	CoinChange (ALower, C, Claim, Amount, value, currency, date)
+	VALUES 		 (?, ?, ?, ?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt,
+		toBlob(change.Change.ALower),
+		toBlob(change.Change.C),
+		toBlob(change.Change.Claim),
+		change.Change.Amount,
+		change.Value,
+		core.NormalizeCurrency(change.Currency),
+		time.Now(),
+	)
+	return err
+}
+
+// ReadChange returns the uncollected remainder coin request (ALower, C). Returns ErrUnknownChange if there is none.
+func (store *BankStore) ReadChange(ALower, C *big.Int) (*PendingChange, error) {
+	var (
+		change        PendingChange
+		claim         string
+		currency      string
+		amount, value int64
+	)
+	stmt := `SELECT Claim, Amount, value, currency FROM CoinChange WHERE ALower = ? AND C = ? AND collected IS NULL`
+	err := store.db.QueryRow(stmt, toBlob(ALower), toBlob(C)).Scan(&claim, &amount, &value, &currency)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownChange
+	} else if err != nil {
+		return nil, err
+	}
+
+	change.Change = core.Change{
+		Amount: amount,
+		ALower: ALower,
+		C:      C,
+		Claim:  fromBlob(claim),
+	}
+	change.Value = value
+	change.Currency = currency
+	return &change, nil
+}
+
+// CollectChange marks change as collected. Returns ErrUnknownChange if it was already collected.
+func (store *BankStore) CollectChange(change *PendingChange) error {
+	return retryBusy(func() error {
+		return store.collectChange(change)
+	})
+}
+
+// collectChange is CollectChange, run once.
+func (store *BankStore) collectChange(change *PendingChange) error {
+	stmt := `UPDATE CoinChange SET collected = ? WHERE ALower = ? AND C = ? AND collected IS NULL`
+	res, err := store.db.Exec(stmt, time.Now(), toBlob(change.Change.ALower), toBlob(change.Change.C))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUnknownChange
+	}
+	return nil
+}
+
+// CoinExists reports whether an entry exists for coin's profile hash, i.e. whether coin was already surrendered.
+// (Only WriteCoinProfile tells atomically, a coin may be surrendered meanwhile)
+func (store *BankStore) CoinExists(coin *core.CoinProfile) (bool, error) {
+	var exists bool
+	err := store.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM CoinProfile WHERE hash = ?)`, coin.Hash()).Scan(&exists)
+	return exists, err
+}
+
+// ReadCoinRecord returns how and when coin was surrendered, and by whom. Returns ErrUnspentCoin if it wasn't.
+func (store *BankStore) ReadCoinRecord(coin *core.CoinProfile) (*CoinRecord, error) {
+	record := &CoinRecord{Hash: coin.Hash()}
+	stmt := `SELECT operation, client, date FROM CoinProfile WHERE hash = ?`
+	err := store.db.QueryRow(stmt, coin.Hash()).Scan(&record.Operation, &record.Client, &record.Date)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnspentCoin
+	} else if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// coinProfileColumns are the columns of a CoinProfile entry scanned by scanCoinProfile.
+const coinProfileColumns = `hash, Pub, First, A, R, A2, Expiration, Second, Msg, Version, Currency, Value`
+
+// scanCoinProfile scans the coinProfileColumns of row, and returns the hash the coin profile is stored under along
+// with it.
+func scanCoinProfile(row scannable) (int64, *core.CoinProfile, error) {
+	var (
+		hash int64
+		coin core.CoinProfile
+	)
+	scanner := new(rowScanner).New(7)
+	s := scanner.dest
+	if err := row.Scan(&hash, s[0], s[1], s[2], s[3], s[4], &coin.Expiration, s[5], s[6], &coin.Version, &coin.Currency, &coin.Value); err != nil {
+		return 0, nil, err
+	}
+	vals := scanner.Strings()
+	coin.Pub, coin.First, coin.A, coin.R, coin.A2 = fromBlob(vals[0]), fromBlob(vals[1]), fromBlob(vals[2]), fromBlob(vals[3]), fromBlob(vals[4])
+	coin.Second, coin.Msg = fromBlob(vals[5]), fromBlob(vals[6])
+	return hash, &coin, nil
+}
+
+// ReadSpentCoin returns the version of coin already surrendered, stored under its hash, with its expiration in the
+// time zone of coin's. (Digested along with its time zone, which isn't stored)
+// Returns sql.ErrNoRows if no entry exists.
+func (store *BankStore) ReadSpentCoin(coin *core.CoinProfile) (*core.CoinProfile, error) {
+	row := store.db.QueryRow(`SELECT `+coinProfileColumns+` FROM CoinProfile WHERE hash = ?`, coin.Hash())
+	_, spent, err := scanCoinProfile(row)
+	if err != nil {
+		return nil, err
+	}
+	if spent.Expiration.Equal(coin.Expiration) {
+		spent.Expiration = coin.Expiration
 	}
+	return spent, nil
+}
+
+// BankInspection is the contents of the bank's database, as returned by Inspect and InspectFull. The tables and
+// columns tagged `inspect:"full"` are only set by InspectFull.
+type BankInspection struct {
+	Banks          []BankRow
+	Mints          []MintRow
+	Clients        []ClientInfoRow
+	Balances       []ClientBalanceRow
+	Coins          []CoinProfileRow
+	GraceExchanges []GraceExchangeRow
+}
+
+// BankRow is an entry of the Bank table.
+type BankRow struct {
+	ID          int64
+	Name        string
+	Identity    string
+	Fingerprint string
+	Provenance  string
+	Priv        *big.Int `inspect:"full"`
+	Pub         *big.Int `inspect:"full"`
+	SchemeQ     *big.Int `inspect:"full"`
+	SchemeP     *big.Int `inspect:"full"`
+	SchemeG     *big.Int `inspect:"full"`
+	KeyP        *big.Int `inspect:"full"`
+	KeyQ        *big.Int `inspect:"full"`
+	KeyD        *big.Int `inspect:"full"`
+	KeyN        *big.Int `inspect:"full"`
+	KeyE        *big.Int `inspect:"full"`
+}
+
+// MintRow is an entry of the Mint table.
+type MintRow struct {
+	ID       int64
+	Identity string
+	Currency string
+}
+
+// ClientInfoRow is an entry of the ClientInfo table.
+type ClientInfoRow struct {
+	ID           int64
+	ClientHash   int64
+	Balance      int64
+	K            *big.Int `inspect:"full"`
+	S            *big.Int `inspect:"full"`
+	Credential   *big.Int `inspect:"full"`
+	Contract     *big.Int `inspect:"full"`
+	PrivStamp    *big.Int `inspect:"full"`
+	IdentityHash *big.Int `inspect:"full"`
+	TradeId      *big.Int `inspect:"full"`
+	Pub          *big.Int `inspect:"full"`
+	N            *big.Int `inspect:"full"`
+	E            *big.Int `inspect:"full"`
+}
+
+// ClientBalanceRow is an entry of the ClientBalance table.
+type ClientBalanceRow struct {
+	ID         int64
+	ClientHash int64
+	Currency   string
+	Balance    int64
+}
+
+// CoinProfileRow is an entry of the CoinProfile table.
+type CoinProfileRow struct {
+	ID         int64
+	CoinHash   int64
+	Operation  Operation_Type
+	ClientHash int64
+	Date       time.Time
+	Expiration time.Time `inspect:"full"`
+	Pub        *big.Int  `inspect:"full"`
+	First      *big.Int  `inspect:"full"`
+	A          *big.Int  `inspect:"full"`
+	R          *big.Int  `inspect:"full"`
+	A2         *big.Int  `inspect:"full"`
+	Second     *big.Int  `inspect:"full"`
+	Msg        *big.Int  `inspect:"full"`
+}
+
+// GraceExchangeRow is an entry of the GraceExchange table.
+type GraceExchangeRow struct {
+	ID         int64
+	CoinHash   int64
+	Currency   string
+	Value      int64
+	Expiration time.Time
+	ClientHash int64
+	Date       time.Time
 }
 
-// InspectFull.
-func (store *BankStore) InspectFull() {
+// Inspect returns the contents of the bank's database, without its numbers.
+func (store *BankStore) Inspect() (*BankInspection, error) {
+	return store.inspect(false)
+}
+
+// InspectFull returns the contents of the bank's database, including its numbers.
+func (store *BankStore) InspectFull() (*BankInspection, error) {
+	return store.inspect(true)
+}
+
+// inspect returns the contents of the bank's database, including its numbers if full.
+func (store *BankStore) inspect(full bool) (*BankInspection, error) {
 	// Begin a transaction.
 	tx, err := store.db.Begin()
 	if err != nil {
-		log.Fatalf("failed to initiate transaction: %v", err)
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
 	}
 	defer tx.Rollback()
 
+	inspection := new(BankInspection)
+
 	// Bank.
-	fmt.Printf("\nBANK\n")
-	rows, err := tx.Query(`SELECT id, name, identity, Priv, Pub, scheme_Q, scheme_P, scheme_G, key_P, key_Q, key_D, key_N, key_E FROM Bank`)
+	stmt := `SELECT id, name, identity, fingerprint, provenance` + fullColumns(full, `Priv, Pub, scheme_Q, scheme_P, scheme_G, key_P, key_Q, key_D, key_N, key_E`) + ` FROM Bank`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row BankRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.Name, &row.Identity, &row.Fingerprint, &row.Provenance}, &row.Priv, &row.Pub, &row.SchemeQ, &row.SchemeP, &row.SchemeG, &row.KeyP, &row.KeyQ, &row.KeyD, &row.KeyN, &row.KeyE); err != nil {
+			return err
+		}
+		inspection.Banks = append(inspection.Banks, row)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("failed to query Bank table: %v", err)
+		log.Printf("failed to query Bank table: %v", err)
+		return nil, err
 	}
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "Name", "Identity", "Priv", "Pub", "Scheme:Q", "Scheme:P", "Scheme:G", "Key:P", "Key:Q", "Key:D", "Key:N", "Key:E")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id       int64
-			name     string
-			identity string
-			numbers  [2]string
-			scheme   [3]string
-			key      [5]string
-		)
 
-		err = rows.Scan(&id, &name, &identity, &numbers[0], &numbers[1], &scheme[0], &scheme[1], &scheme[2], &key[0], &key[1], &key[2], &key[3], &key[4])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	// Mint.
+	err = inspectTable(tx, `SELECT id, identity, currency FROM Mint`, func(rows *sql.Rows) error {
+		var row MintRow
+		if err := rows.Scan(&row.ID, &row.Identity, &row.Currency); err != nil {
+			return err
 		}
-
-		fmt.Printf("%-5d %-10s %-10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, name, identity, numbers[0], numbers[1], scheme[0], scheme[1], scheme[2], key[0], key[1], key[2], key[3], key[4])
+		inspection.Mints = append(inspection.Mints, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query Mint table: %v", err)
+		return nil, err
 	}
 
 	// ClientInfo.
-	fmt.Printf("\nCLIENT INFO\n")
-	rows, err = tx.Query(`SELECT id, hash, balance, K, S, Credential, Contract, PrivStamp, IdentityHash, TradeId, Pub, N, E FROM ClientInfo`)
+	stmt = `SELECT id, hash, balance` + fullColumns(full, `K, S, Credential, Contract, PrivStamp, IdentityHash, TradeId, Pub, N, E`) + ` FROM ClientInfo`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row ClientInfoRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.ClientHash, &row.Balance}, &row.K, &row.S, &row.Credential, &row.Contract, &row.PrivStamp, &row.IdentityHash, &row.TradeId, &row.Pub, &row.N, &row.E); err != nil {
+			return err
+		}
+		inspection.Clients = append(inspection.Clients, row)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("failed to query ClientInfo table: %v", err)
+		log.Printf("failed to query ClientInfo table: %v", err)
+		return nil, err
 	}
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "ClientHash", "Balance", "K", "S", "Credential", "Contract", "PrivStamp", "IdHash", "TradeId", "Pub", "N", "E")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id         int64
-			clientHash int64
-			balance    int64
-			info       [4]string
-			profile    [6]string
-		)
 
-		err = rows.Scan(&id, &clientHash, &balance, &info[0], &info[1], &info[2], &info[3], &profile[0], &profile[1], &profile[2], &profile[3], &profile[4], &profile[5])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	// ClientBalance.
+	err = inspectTable(tx, `SELECT id, client, currency, balance FROM ClientBalance`, func(rows *sql.Rows) error {
+		var row ClientBalanceRow
+		if err := rows.Scan(&row.ID, &row.ClientHash, &row.Currency, &row.Balance); err != nil {
+			return err
 		}
-
-		fmt.Printf("%-5d %-10d %-10d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, clientHash, balance, info[0], info[1], info[2], info[3], profile[0], profile[1], profile[2], profile[3], profile[4], profile[5])
+		inspection.Balances = append(inspection.Balances, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query ClientBalance table: %v", err)
+		return nil, err
 	}
 
 	// CoinProfile.
-	fmt.Printf("\nCOIN PROFILE\n")
-	rows, err = tx.Query(`SELECT id, hash, Pub, First, A, R, A2, Expiration, Second, Msg, operation, client, date FROM CoinProfile`)
+	stmt = `SELECT id, hash, operation, client, date` + fullColumns(full, `Expiration, Pub, First, A, R, A2, Second, Msg`) + ` FROM CoinProfile`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row CoinProfileRow
+		dest := []interface{}{&row.ID, &row.CoinHash, &row.Operation, &row.ClientHash, &row.Date}
+		if full {
+			dest = append(dest, &row.Expiration)
+		}
+		if err := scanInspected(rows, full, dest, &row.Pub, &row.First, &row.A, &row.R, &row.A2, &row.Second, &row.Msg); err != nil {
+			return err
+		}
+		inspection.Coins = append(inspection.Coins, row)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("failed to query CoinProfile table: %v", err)
+		log.Printf("failed to query CoinProfile table: %v", err)
+		return nil, err
 	}
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s %-23s %-11s %-10s %-10s %-10s %-23s\n", "ID", "CoinHash", "Coin:Pub", "Coin:First", "Coin:A", "Coin:R", "Coin:A2", "Coin:Expiration", "Coin:Second", "Coin:Msg", "Operation", "ClientHash", "Date")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id         int64
-			coinHash   int64
-			profile    [7]string
-			expiration time.Time
-			operation  Operation_Type
-			clientHash int64
-			date       time.Time
-		)
-
-		err = rows.Scan(&id, &coinHash, &profile[0], &profile[1], &profile[2], &profile[3], &profile[4], &expiration, &profile[5], &profile[6], &operation, &clientHash, &date)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
-		}
 
-		var operationStr string
-		switch operation {
-		case Operation_Deposit:
-			operationStr = "Deposit"
-		case Operation_Exchange:
-			operationStr = "Exchange"
-		default:
+	// GraceExchange.
+	stmt = `SELECT id, hash, currency, value, expiration, client, date FROM GraceExchange`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row GraceExchangeRow
+		if err := rows.Scan(&row.ID, &row.CoinHash, &row.Currency, &row.Value, &row.Expiration, &row.ClientHash, &row.Date); err != nil {
+			return err
 		}
-
-		fmt.Printf("%-5d %-10.10d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-23s %-11.11s %-10.10s %-10s %-10.10d %-23s\n", id, coinHash, profile[0], profile[1], profile[2], profile[3], profile[4], expiration.String()[:23], profile[5], profile[6], operationStr, clientHash, date.String()[:23])
+		inspection.GraceExchanges = append(inspection.GraceExchanges, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query GraceExchange table: %v", err)
+		return nil, err
 	}
 
-	// Commit transaction.
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("failed to commit transaction: %v", err)
-	}
+	return inspection, nil
 }
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sync"
+	"ziba/core"
+)
+
+//
+// CACHE
+//
+
+// 1. A BankStore keeps the Bank entry and the ClientInfo entries it reads in memory, so that the hot paths of the
+//		servers (Withdrawal, Deposit, ...) don't parse their numbers again on every request.
+// 2. Reads go through the cache, writes of this BankStore drop the entries they change.
+// 3. Every change to the cached tables (Bank, ClientInfo), whichever process made it, bumps the CacheGeneration of the
+//		database. (Triggers) Reads check it first and empty the cache once it moved, the entries are read again.
+// 4. Cached values are deep copied on the way in and out, numbers included, callers can't change them.
+// 5. A ClientInfo entry keeps the profile stored in the database, never the one a caller looked it up with.
+
+//
+// REPLICAS
+//
+
+// 1. Several bank servers (e.g. "ziba bank serve" replicas behind a TCP load balancer) may share one database, each
+//		process on the same host, or sharing its volume. (sqlite locks don't hold over network file systems)
+// 2. The servers keep no state of the bank between requests beyond the read-through cache, checked against the
+//		database on every read.
+// 3. Sequences are generated by the database only: ids are AUTOINCREMENT rows, read back with LastInsertId from the
+//		same statement, and balances change within write transactions. (See retryBusy) Writes of every replica are
+//		serialized by the database's write lock.
+// 4. Rate-limiting state that isn't recorded in the database is per replica, e.g. the account quota of AccgenServer
+//		and network's VelocityRule: each replica counts the requests it served.
+
+// maxCachedClients is the number of ClientInfo entries kept before the cache is emptied.
+const maxCachedClients = 4096
+
+// recordCache is the read-through cache of a BankStore.
+type recordCache struct {
+	mutex      sync.Mutex
+	generation int64 // CacheGeneration the entries were read at.
+	bank       *core.Bank
+	clients    map[uint32]core.ClientInfo
+}
+
+// New.
+func (cache *recordCache) New() *recordCache {
+	cache.clients = make(map[uint32]core.ClientInfo)
+	return cache
+}
+
+// sync empties the cache if the entries were read at another generation than generation, the current one. Must be
+// called with the mutex held.
+func (cache *recordCache) sync(generation int64) {
+	if generation != cache.generation {
+		cache.generation = generation
+		cache.bank = nil
+		clear(cache.clients)
+	}
+}
+
+// readBank returns a copy of the cached Bank entry, nil if not cached at generation.
+func (cache *recordCache) readBank(generation int64) *core.Bank {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.sync(generation)
+	if cache.bank == nil {
+		return nil
+	}
+	return copyBank(cache.bank)
+}
+
+// keepBank caches a copy of bank, read at generation. (Not cached if the generation moved since)
+func (cache *recordCache) keepBank(generation int64, bank *core.Bank) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if generation != cache.generation {
+		return
+	}
+	cache.bank = copyBank(bank)
+}
+
+// forgetBank drops the cached Bank entry.
+func (cache *recordCache) forgetBank() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.bank = nil
+}
+
+// readClient returns a copy of the cached ClientInfo entry of client's profile hash, nil if not cached at generation.
+func (cache *recordCache) readClient(generation int64, client *core.ClientProfile) *core.ClientInfo {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.sync(generation)
+	clientInfo, found := cache.clients[client.Hash()]
+	if !found {
+		return nil
+	}
+	return copyClientInfo(&clientInfo)
+}
+
+// keepClient caches a copy of clientInfo, read at generation, emptying the cache first if full. (Not cached if the
+// generation moved since)
+func (cache *recordCache) keepClient(generation int64, clientInfo *core.ClientInfo) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if generation != cache.generation {
+		return
+	}
+	if len(cache.clients) >= maxCachedClients {
+		clear(cache.clients)
+	}
+	cache.clients[clientInfo.Profile.Hash()] = *copyClientInfo(clientInfo)
+}
+
+// forgetClient drops the cached ClientInfo entry of hash.
+func (cache *recordCache) forgetClient(hash uint32) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	delete(cache.clients, hash)
+}
+
+// copyInt returns a copy of x, nil if x is nil.
+func copyInt(x *big.Int) *big.Int {
+	if x == nil {
+		return nil
+	}
+	return new(big.Int).Set(x)
+}
+
+// copyBank returns a deep copy of bank.
+func copyBank(bank *core.Bank) *core.Bank {
+	kept := *bank
+	kept.Scheme = core.SchemeParams{Q: copyInt(bank.Scheme.Q), P: copyInt(bank.Scheme.P), G: copyInt(bank.Scheme.G)}
+	kept.Key = core.RsaKey{
+		P: copyInt(bank.Key.P),
+		Q: copyInt(bank.Key.Q),
+		N: copyInt(bank.Key.N),
+		D: copyInt(bank.Key.D),
+		E: copyInt(bank.Key.E),
+	}
+	kept.Priv, kept.Pub = copyInt(bank.Priv), copyInt(bank.Pub)
+	return &kept
+}
+
+// copyClientInfo returns a deep copy of clientInfo.
+func copyClientInfo(clientInfo *core.ClientInfo) *core.ClientInfo {
+	kept := *clientInfo
+	kept.Profile = core.ClientProfile{
+		PrivStamp:    copyInt(clientInfo.Profile.PrivStamp),
+		IdentityHash: copyInt(clientInfo.Profile.IdentityHash),
+		TradeId:      copyInt(clientInfo.Profile.TradeId),
+		Pub:          copyInt(clientInfo.Profile.Pub),
+		N:            copyInt(clientInfo.Profile.N),
+		E:            copyInt(clientInfo.Profile.E),
+	}
+	kept.K, kept.S = copyInt(clientInfo.K), copyInt(clientInfo.S)
+	kept.Credential, kept.Contract = copyInt(clientInfo.Credential), copyInt(clientInfo.Contract)
+	return &kept
+}
+
+// cacheTriggers are the triggers bumping the CacheGeneration of a bank's database, by name, on every change to the
+// cached entries. (Balances and closures aren't cached, their updates don't count)
+var cacheTriggers = map[string]string{
+	"BankUpdated":       "UPDATE ON Bank",
+	"BankDeleted":       "DELETE ON Bank",
+	"ClientInfoUpdated": "UPDATE OF K, S, Credential, Contract, PrivStamp, IdentityHash, TradeId, Pub, N, E, expiration ON ClientInfo",
+	"ClientInfoDeleted": "DELETE ON ClientInfo",
+}
+
+// createCacheGenerationTable creates the CacheGeneration table of a bank's database, and its cacheTriggers, using tx.
+func createCacheGenerationTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS CacheGeneration (
+	-- keys
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+
+	-- CacheGeneration
+	value INTEGER NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO CacheGeneration (id, value) VALUES (1, 0)`)
+	if err != nil {
+		return err
+	}
+
+	for name, event := range cacheTriggers {
+		trigger := fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %s AFTER %s
+		BEGIN
+			UPDATE CacheGeneration SET value = value + 1;
+		END;`, name, event)
+		if _, err := tx.Exec(trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGeneration returns the current CacheGeneration of the database.
+func (store *BankStore) readGeneration() (int64, error) {
+	stmt, err := store.statements.prepare(`SELECT value FROM CacheGeneration`)
+	if err != nil {
+		return 0, err
+	}
+	var generation int64
+	err = stmt.QueryRow().Scan(&generation)
+	return generation, err
+}
+
+// InvalidateCache drops every cached entry of this BankStore. (Entries changed in the database are dropped on their
+// own, see recordCache)
+func (store *BankStore) InvalidateCache() {
+	store.cache.mutex.Lock()
+	defer store.cache.mutex.Unlock()
+
+	store.cache.bank = nil
+	clear(store.cache.clients)
+}
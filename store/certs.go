@@ -0,0 +1,145 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"log"
+	"os"
+	"time"
+)
+
+//
+// CERTIFICATES
+//
+
+// 1. A wallet keeps the TLS certificates it uses in its database, each under a role and a name: its own certificate
+//		and key (Role_Own, by user), and the certificates of the bank servers (Role_Bank) and of the merchants'
+//		payment servers (Role_Merchant) it connects to, by address.
+// 2. A bank and a merchant at the same address don't collide, neither do a user and a server of the same name.
+// 3. Certificates are kept along with their SHA-256 fingerprint, e.g. to check a payment's merchant afterwards.
+// 4. The certificate files of earlier versions are imported the first time they're read.
+
+// createCertificateTable creates the Certificate table of a wallet's database using tx.
+func createCertificateTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS Certificate (
+	-- keys
+	role INTEGER NOT NULL,
+	name TEXT NOT NULL,
+
+	-- Certificate
+	fingerprint TEXT NOT NULL, -- SHA-256 (hex), empty if publicly trusted
+	cert 				TEXT NOT NULL, -- PEM
+	key 				TEXT NOT NULL, -- PEM, Role_Own only
+	date 				DATETIME NOT NULL,
+
+	PRIMARY KEY (role, name) ON CONFLICT REPLACE
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// Certificates is the table of TLS certificates of a wallet's database.
+type Certificates struct {
+	db *sql.DB
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a DER encoded certificate, in hex.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// PEMFingerprint returns the fingerprint of the PEM encoded certificate cert, "" if empty.
+func PEMFingerprint(cert []byte) (string, error) {
+	if len(cert) == 0 {
+		return "", nil
+	}
+	block, _ := pem.Decode(cert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", ErrMalformedCertificate
+	}
+	return CertificateFingerprint(block.Bytes), nil
+}
+
+// Write stores cert, dated now, replacing the certificate of its role and name. Its fingerprint is computed.
+func (certs *Certificates) Write(cert *Certificate) error {
+	fingerprint, err := PEMFingerprint(cert.Cert)
+	if err != nil {
+		return err
+	}
+	cert.Fingerprint = fingerprint
+	cert.Date = time.Now()
+
+	stmt := `INSERT INTO Certificate (role, name, fingerprint, cert, key, date) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = certs.db.Exec(stmt,
+		cert.Role,
+		cert.Name,
+		cert.Fingerprint,
+		string(cert.Cert),
+		string(cert.Key),
+		cert.Date.UTC(),
+	)
+	return err
+}
+
+// Read returns the certificate of name in role, ErrUnknownCertificate if not stored. A certificate file of earlier
+// versions is imported instead.
+func (certs *Certificates) Read(role Role_Type, name string) (*Certificate, error) {
+	cert := Certificate{Role: role, Name: name}
+	stmt := `SELECT fingerprint, cert, key, date FROM Certificate WHERE role = ? AND name = ?`
+	err := certs.db.QueryRow(stmt, role, name).Scan(&cert.Fingerprint, &cert.Cert, &cert.Key, &cert.Date)
+	if err == sql.ErrNoRows {
+		return certs.importFile(role, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ReadAll returns every certificate, by role and name.
+func (certs *Certificates) ReadAll() ([]Certificate, error) {
+	rows, err := certs.db.Query(`SELECT role, name, fingerprint, cert, key, date FROM Certificate ORDER BY role, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Certificate
+	for rows.Next() {
+		var cert Certificate
+		if err := rows.Scan(&cert.Role, &cert.Name, &cert.Fingerprint, &cert.Cert, &cert.Key, &cert.Date); err != nil {
+			return nil, err
+		}
+		list = append(list, cert)
+	}
+	return list, rows.Err()
+}
+
+// importFile stores and returns the certificate file of name, and its key file for Role_Own, from the Ziba directory.
+// Returns ErrUnknownCertificate if there's no such file.
+func (certs *Certificates) importFile(role Role_Type, name string) (*Certificate, error) {
+	directory, err := GetZibaDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(CertPath(directory, name))
+	if os.IsNotExist(err) {
+		return nil, ErrUnknownCertificate
+	} else if err != nil {
+		return nil, err
+	}
+
+	cert := &Certificate{Role: role, Name: name, Cert: data}
+	if role == Role_Own {
+		if cert.Key, err = os.ReadFile(KeyPath(directory, name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := certs.Write(cert); err != nil {
+		return nil, err
+	}
+	log.Printf("Imported certificate of %s %s", role, name)
+	return cert, nil
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"database/sql"
+	"log"
+	"slices"
+	"time"
+	"ziba/core"
+)
+
+//
+// ACCOUNT CLOSURE
+//
+
+// 1. A client closes its account by surrendering its unspent coins: they are recorded as spent (Operation_Closure)
+//		and credited to its balances, which are then paid out of band, recorded in the AccountClosure table, and set to
+//		zero in the BalanceLog.
+// 2. The account is kept, marked closed: the servers refuse its further operations. Its coins, balances and payouts
+//		stay recorded for audit.
+
+// createAccountClosureTable creates the AccountClosure table of a bank's database using tx.
+func createAccountClosureTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS AccountClosure (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+	-- AccountClosure
+	client 		INTEGER NOT NULL, -- ClientProfile hash
+	currency 	TEXT NOT NULL,
+	balance 	INTEGER NOT NULL, -- Paid out
+
+	date DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// CloseAccount closes client's account: coins are written as surrendered by client and credited to its balances,
+// which are paid out and set to zero. Returns the closure, ErrUnknownClient if there's no such account,
+// ErrClosedAccount if it was already closed and ErrExistingCoin if any coin was already spent. (Nothing is written
+// then)
+func (store *BankStore) CloseAccount(client *core.ClientProfile, coins []*core.CoinProfile) (*AccountClosure, error) {
+	var closure *AccountClosure
+	err := retryBusy(func() (err error) {
+		closure, err = store.closeAccount(client, coins)
+		return err
+	})
+	return closure, err
+}
+
+// closeAccount is CloseAccount, run once.
+func (store *BankStore) closeAccount(client *core.ClientProfile, coins []*core.CoinProfile) (*AccountClosure, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Check the account is open. (Writing first, against concurrent closures)
+	closure := &AccountClosure{Client: client.Hash(), Balances: make(map[string]int64), Date: time.Now().UTC()}
+	stmt := `UPDATE ClientInfo SET closed = ? WHERE hash = ? AND closed = '0001-01-01T00:00:00Z'`
+	res, err := tx.Exec(stmt, closure.Date, closure.Client)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		var id int64
+		if err := tx.QueryRow(`SELECT id FROM ClientInfo WHERE hash = ?`, closure.Client).Scan(&id); err == sql.ErrNoRows {
+			return nil, ErrUnknownClient
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, ErrClosedAccount
+	}
+
+	// Surrender coins.
+	if err := store.insertCoinProfiles(tx, coins, Operation_Closure, client); err != nil {
+		return nil, err
+	}
+	surrendered := make(map[string]int64)
+	for _, coin := range coins {
+		surrendered[core.NormalizeCurrency(coin.Currency)] += core.NormalizeValue(coin.Value)
+	}
+
+	// Grab the balances, along with the surrendered coins.
+	currencies := []string{core.DefaultCurrency}
+	rows, err := tx.Query(`SELECT currency FROM ClientBalance WHERE client = ?`, closure.Client)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		currencies = append(currencies, currency)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for currency := range surrendered {
+		if !slices.Contains(currencies, currency) {
+			currencies = append(currencies, currency)
+		}
+	}
+
+	// Pay out the balances.
+	for _, currency := range currencies {
+		balance, err := store.clientBalance(tx, client, currency)
+		if err != nil {
+			return nil, err
+		}
+		if value := surrendered[currency]; value > 0 {
+			balance += value
+			if err := store.setClientBalance(tx, client, currency, balance); err != nil {
+				return nil, err
+			}
+		}
+		if balance == 0 {
+			continue
+		}
+		closure.Balances[currency] = balance
+		stmt := `INSERT INTO AccountClosure (client, currency, balance, date) VALUES (?, ?, ?, ?)`
+		if _, err := tx.Exec(stmt, closure.Client, currency, balance, closure.Date); err != nil {
+			return nil, err
+		}
+		if err := store.setClientBalance(tx, client, currency, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	store.cache.forgetClient(closure.Client)
+
+	return closure, nil
+}
+
+// ReadAccountClosed returns the date client's account was closed, zero if open. Returns ErrUnknownClient if there's no
+// such account. (Read from the database, whichever process closed it)
+func (store *BankStore) ReadAccountClosed(client *core.ClientProfile) (time.Time, error) {
+	var closed time.Time
+	err := store.db.QueryRow(`SELECT closed FROM ClientInfo WHERE hash = ?`, client.Hash()).Scan(&closed)
+	if err == sql.ErrNoRows {
+		return closed, ErrUnknownClient
+	}
+	return closed, err
+}
+
+// ReadAccountClosures returns the closed accounts, along with their payouts, oldest first.
+func (store *BankStore) ReadAccountClosures() ([]AccountClosure, error) {
+	stmt := `SELECT hash, closed FROM ClientInfo WHERE closed != '0001-01-01T00:00:00Z' ORDER BY closed, hash`
+	rows, err := store.db.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var closures []AccountClosure
+	for rows.Next() {
+		closure := AccountClosure{Balances: make(map[string]int64)}
+		if err := rows.Scan(&closure.Client, &closure.Date); err != nil {
+			return nil, err
+		}
+		closures = append(closures, closure)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Grab payouts.
+	for i := range closures {
+		rows, err := store.db.Query(`SELECT currency, balance FROM AccountClosure WHERE client = ?`, closures[i].Client)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				currency string
+				balance  int64
+			)
+			if err := rows.Scan(&currency, &balance); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			closures[i].Balances[currency] = balance
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return closures, nil
+}
@@ -1,15 +1,38 @@
 package store
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding"
+	"encoding/base64"
+	"fmt"
 	"log"
+	"maps"
 	"math/big"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ziba/core"
 
 	_ "modernc.org/sqlite"
 )
 
+// passphraseIterations is the PBKDF2 iteration count used to derive keys from passphrases.
+const passphraseIterations = 600000
+
+// initialBalance is the balance of a new account, in each currency.
+const initialBalance = 100
+
 // Operation Type used for writing/deleting coins.
 type Operation_Type int
 
@@ -18,61 +41,538 @@ const (
 	Operation_Payment
 	Operation_Deposit
 	Operation_Exchange
+	Operation_Reclaim
+	Operation_Change
+	Operation_Gift
+	Operation_Closure
 )
 
-// GetZibaDir.
-func GetZibaDir() (string, error) {
-	// Get user's home directory.
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Printf("failed to get home directory: %v", err)
-		return "", err
+// Admission Type of account applications.
+type Admission_Type int
+
+const (
+	Admission_Pending Admission_Type = iota
+	Admission_Approved
+	Admission_Rejected
+)
+
+// Alert Type of fraud alerts: the action of the rule matched, and the admin's decision for approvals.
+type Alert_Type int
+
+const (
+	Alert_Flagged Alert_Type = iota
+	Alert_Rejected
+	Alert_Pending
+	Alert_Approved
+	Alert_Denied
+	Alert_Released
+)
+
+// Status Type of withdrawals, by idempotency token. (See WithdrawalStatus)
+type Status_Type int
+
+const (
+	Status_Unknown Status_Type = iota
+	Status_Pending
+	Status_Issued
+	Status_Refused
+)
+
+// State Type of payments, by idempotency token. (See PaymentSession)
+type State_Type int
+
+const (
+	State_Started  State_Type = iota // The coin was offered. (Abortable)
+	State_Stamped                    // The merchant stamped the coin. (Abortable)
+	State_Signed                     // The payer signed the stamp: irreversible, resumed until accepted.
+	State_Accepted                   // The merchant accepted the coin.
+)
+
+// Role Type of a certificate's holder. (See Certificates)
+type Role_Type int
+
+const (
+	Role_Own Role_Type = iota + 1
+	Role_Bank
+	Role_Merchant
+)
+
+// String.
+func (state State_Type) String() string {
+	switch state {
+	case State_Started:
+		return "Started"
+	case State_Stamped:
+		return "Stamped"
+	case State_Signed:
+		return "Signed"
+	case State_Accepted:
+		return "Accepted"
 	}
+	return fmt.Sprintf("State(%d)", int(state))
+}
 
+// String.
+func (role Role_Type) String() string {
+	switch role {
+	case Role_Own:
+		return "Own"
+	case Role_Bank:
+		return "Bank"
+	case Role_Merchant:
+		return "Merchant"
+	}
+	return fmt.Sprintf("Role(%d)", int(role))
+}
+
+// String.
+func (admission Admission_Type) String() string {
+	switch admission {
+	case Admission_Pending:
+		return "Pending"
+	case Admission_Approved:
+		return "Approved"
+	case Admission_Rejected:
+		return "Rejected"
+	}
+	return fmt.Sprintf("Admission(%d)", int(admission))
+}
+
+// String.
+func (status Status_Type) String() string {
+	switch status {
+	case Status_Unknown:
+		return "Unknown"
+	case Status_Pending:
+		return "Pending"
+	case Status_Issued:
+		return "Issued"
+	case Status_Refused:
+		return "Refused"
+	}
+	return fmt.Sprintf("Status(%d)", int(status))
+}
+
+// String.
+func (alert Alert_Type) String() string {
+	switch alert {
+	case Alert_Flagged:
+		return "Flagged"
+	case Alert_Rejected:
+		return "Rejected"
+	case Alert_Pending:
+		return "Pending"
+	case Alert_Approved:
+		return "Approved"
+	case Alert_Denied:
+		return "Denied"
+	case Alert_Released:
+		return "Released"
+	}
+	return fmt.Sprintf("Alert(%d)", int(alert))
+}
+
+// String.
+func (operation Operation_Type) String() string {
+	switch operation {
+	case Operation_Withdrawal:
+		return "Withdrawal"
+	case Operation_Payment:
+		return "Payment"
+	case Operation_Deposit:
+		return "Deposit"
+	case Operation_Exchange:
+		return "Exchange"
+	case Operation_Reclaim:
+		return "Reclaim"
+	case Operation_Change:
+		return "Change"
+	case Operation_Gift:
+		return "Gift"
+	case Operation_Closure:
+		return "Closure"
+	}
+	return fmt.Sprintf("Operation(%d)", int(operation))
+}
+
+// ZibaDir overrides the Ziba directory when set, e.g. by the --data-dir flag. The ZIBA_DIR environment variable
+// overrides it otherwise. (See GetZibaDir)
+var ZibaDir string
+
+// zibaDirEnv is the environment variable overriding the Ziba directory.
+const zibaDirEnv = "ZIBA_DIR"
+
+// defaultZibaDir returns the conventional data directory of the OS: %APPDATA%\ziba on Windows, ~/Library/Application
+// Support/ziba on macOS, and $XDG_DATA_HOME/ziba (~/.local/share/ziba) elsewhere.
+func defaultZibaDir(home string) string {
+	switch runtime.GOOS {
+	case "windows":
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "ziba")
+		}
+		return filepath.Join(home, "AppData", "Roaming", "ziba")
+	case "darwin", "ios":
+		return filepath.Join(home, "Library", "Application Support", "ziba")
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, "ziba")
+	}
+	return filepath.Join(home, ".local", "share", "ziba")
+}
+
+// migrateZibaDir moves the Ziba directory of earlier versions, ~/Documents/ziba-cli, to ziba unless ziba exists.
+// The legacy directory is kept in use if it can't be moved.
+func migrateZibaDir(home, ziba string) string {
+	legacy := filepath.Join(home, "Documents", "ziba-cli")
+	if _, err := os.Stat(legacy); err != nil {
+		return ziba
+	}
+	if _, err := os.Stat(ziba); err == nil {
+		return ziba
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ziba), 0755); err != nil { // rwx r-x r-x
+		log.Printf("failed to migrate Ziba directory, still using %s: %v", legacy, err)
+		return legacy
+	}
+	if err := os.Rename(legacy, ziba); err != nil {
+		log.Printf("failed to migrate Ziba directory, still using %s: %v", legacy, err)
+		return legacy
+	}
+	log.Printf("Ziba directory moved from %s to %s", legacy, ziba)
+	return ziba
+}
+
+// GetZibaDir returns the Ziba directory, creating it if needed: ZibaDir, ZIBA_DIR, or the OS's data directory.
+func GetZibaDir() (string, error) {
 	// Set Ziba directory.
-	ziba := filepath.Join(home, "Documents", "ziba-cli")
+	ziba := ZibaDir
+	if ziba == "" {
+		ziba = os.Getenv(zibaDirEnv)
+	}
+	if ziba == "" {
+		// Get user's home directory.
+		home, err := os.UserHomeDir()
+		if err != nil {
+			log.Printf("failed to get home directory: %v", err)
+			return "", err
+		}
+		ziba = migrateZibaDir(home, defaultZibaDir(home))
+	}
 
 	// Create if don't exist.
-	err = os.MkdirAll(ziba, 0755) // rwx r-x r-x
+	err := os.MkdirAll(ziba, 0755) // rwx r-x r-x
 	if err != nil {
 		log.Printf("failed to create Ziba directory: %v", err)
 		return "", err
 	}
+	if err := createLayout(ziba); err != nil {
+		log.Printf("failed to lay out Ziba directory: %v", err)
+		return "", err
+	}
 
 	return ziba, nil
 }
 
-// openDatabase.
-func openDatabase(dbPath string) (*sql.DB, error) {
-	// Open database connection.
-	db, err := sql.Open("sqlite", dbPath)
+// DefaultOptions are the database options of the one-shot commands.
+var DefaultOptions = DatabaseOptions{
+	MaxIdleConns: 2,
+	BusyTimeout:  5 * time.Second,
+}
+
+// ServerOptions are the database options of the long running servers, a pool of connections kept open between
+// requests and a longer wait for the writers.
+var ServerOptions = DatabaseOptions{
+	MaxIdleConns:    16,
+	ConnMaxIdleTime: 5 * time.Minute,
+	BusyTimeout:     30 * time.Second,
+}
+
+// defaultPragmas are the SQLite pragmas of every read-write connection, by name.
+var defaultPragmas = map[string]string{
+	"journal_mode":       "WAL",    // Enable WAL mode
+	"synchronous":        "NORMAL", // Balance between safety and speed
+	"cache_size":         "64000",  // 64MB cache size
+	"foreign_keys":       "ON",     // Enable foreign key constraints
+	"temp_store":         "MEMORY", // Store temp tables and indices in memory
+	"wal_autocheckpoint": "1000",   // Checkpoint WAL file every 1000 pages
+}
+
+// pragmaName and pragmaValue match the pragma overrides accepted in a DSN.
+var (
+	pragmaName  = regexp.MustCompile(`^[a-z_]+$`)
+	pragmaValue = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// ParsePragmas returns the pragma overrides of settings, each NAME=VALUE. Returns ErrDatabaseOption if malformed.
+func ParsePragmas(settings []string) (map[string]string, error) {
+	pragmas := make(map[string]string)
+	for _, setting := range settings {
+		name, value, found := strings.Cut(setting, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !found || !pragmaName.MatchString(name) || !pragmaValue.MatchString(value) {
+			return nil, fmt.Errorf("%w: pragma %q", ErrDatabaseOption, setting)
+		}
+		pragmas[name] = value
+	}
+	return pragmas, nil
+}
+
+// dsn returns the data source name of the database at dbPath, with the pragmas of options applied to every connection
+// of the pool.
+func (options *DatabaseOptions) dsn(dbPath string, readOnly bool) (string, error) {
+	pragmas := map[string]string{}
+	params := []string{}
+	if readOnly {
+		params = append(params, "mode=ro")
+		pragmas["query_only"] = "1"
+	} else {
+		maps.Copy(pragmas, defaultPragmas)
+	}
+	if options.BusyTimeout > 0 {
+		pragmas["busy_timeout"] = strconv.FormatInt(options.BusyTimeout.Milliseconds(), 10) // Wait when database is locked
+	}
+	for name, value := range options.Pragmas {
+		if !pragmaName.MatchString(name) || !pragmaValue.MatchString(value) {
+			return "", fmt.Errorf("%w: pragma %s=%s", ErrDatabaseOption, name, value)
+		}
+		pragmas[name] = value
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(pragmas)) {
+		params = append(params, fmt.Sprintf("_pragma=%s(%s)", name, pragmas[name]))
+	}
+	return fmt.Sprintf("file:%s?%s", filepath.ToSlash(dbPath), strings.Join(params, "&")), nil
+}
+
+// openDatabase opens the database at dbPath with options, DefaultOptions if nil. A read-only database is opened as is,
+// every connection rejecting writes.
+func openDatabase(dbPath string, readOnly bool, options *DatabaseOptions) (*sql.DB, error) {
+	if options == nil {
+		options = &DefaultOptions
+	}
+	dsn, err := options.dsn(dbPath, readOnly)
 	if err != nil {
-		log.Printf("failed to open database at %s: %v", dbPath, err)
 		return nil, err
 	}
 
-	// Configure SQLite.
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL",        // Enable WAL mode
-		"PRAGMA busy_timeout=5000",       // Wait up to 5 seconds when database is locked
-		"PRAGMA synchronous=NORMAL",      // Balance between safety and speed
-		"PRAGMA cache_size=64000",        // 64MB cache size
-		"PRAGMA foreign_keys=ON",         // Enable foreign key constraints
-		"PRAGMA temp_store=MEMORY",       // Store temp tables and indices in memory
-		"PRAGMA wal_autocheckpoint=1000", // Checkpoint WAL file every 1000 pages
+	// Open database connection. (Pragmas apply to every connection of the pool)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Printf("failed to open database at %s: %v", dbPath, err)
+		return nil, err
 	}
-	for _, pragma := range pragmas {
-		if _, err := db.Exec(pragma); err != nil {
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxIdleTime(options.ConnMaxIdleTime)
+
+	// Check the database opens.
+	if readOnly {
+		// Check schema version. (Returns ErrOutdatedSchema if the database wasn't upgraded to the current schema)
+		var version int
+		if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
 			db.Close()
-			log.Printf("failed to set pragma %s: %v", pragma, err)
 			return nil, err
 		}
+		if version < currentVersion {
+			db.Close()
+			return nil, ErrOutdatedSchema
+		}
+	} else if err := db.Ping(); err != nil {
+		db.Close()
+		log.Printf("failed to open database at %s: %v", dbPath, err)
+		return nil, err
 	}
 
 	return db, nil
 }
 
-// toString is used to translate big.Int types to string when writing to the database.
+// addColumn adds column to table using definition, only if the column doesn't previously exist.
+// Used to upgrade databases created before column was part of the schema.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	stmt := `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	err := tx.QueryRow(stmt, table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// createIndices creates every index of indices, by name, that doesn't exist yet using tx. Each index is on the columns
+// of a table, e.g. "Coin (client)".
+func createIndices(tx *sql.Tx, indices map[string]string) error {
+	for name, columns := range indices {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s`, name, columns))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schema versions.
+const (
+	blobVersion        = 1 // big.Int columns are stored as blobs rather than decimal text.
+	fingerprintVersion = 2 // Scheme parameters are stored along with their fingerprint.
+	subAccountVersion  = 3 // Wallet coins are partitioned into sub-accounts.
+	reservationVersion = 4 // Wallet coins can be reserved by pending transactions.
+	metaVersion        = 5 // Settings are kept in the Meta table.
+	certificateVersion = 6 // Wallets keep their TLS certificates in the Certificate table.
+	indexVersion       = 7 // Lookups by client and the report queries use explicit indices.
+
+	// currentVersion is the schema version of up-to-date databases.
+	currentVersion = indexVersion
+)
+
+// schemaVersion returns the schema version of the database using tx.
+func schemaVersion(tx *sql.Tx) (int, error) {
+	var version int
+	err := tx.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// upgradeSchemaVersion raises the schema version of the database to version using tx, once its tables were upgraded.
+func upgradeSchemaVersion(tx *sql.Tx, version int) error {
+	current, err := schemaVersion(tx)
+	if err != nil || current >= version {
+		return err
+	}
+	return setSchemaVersion(tx, version)
+}
+
+// setSchemaVersion sets the schema version of the database to version using tx.
+func setSchemaVersion(tx *sql.Tx, version int) error {
+	_, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version))
+	return err
+}
+
+// migrateBlobs converts the big.Int columns of every table in tables, written as decimal text, into blobs using tx.
+// Databases at blobVersion or later are left as is.
+func migrateBlobs(tx *sql.Tx, tables map[string][]string) error {
+	version, err := schemaVersion(tx)
+	if err != nil || version >= blobVersion {
+		return err
+	}
+
+	for table, columns := range tables {
+		for _, column := range columns {
+			// Grab the text values.
+			rows, err := tx.Query(fmt.Sprintf(`SELECT id, %s FROM %s WHERE typeof(%s) = 'text'`, column, table, column))
+			if err != nil {
+				return err
+			}
+			values := make(map[int64]string)
+			for rows.Next() {
+				var (
+					id    int64
+					value string
+				)
+				if err := rows.Scan(&id, &value); err != nil {
+					rows.Close()
+					return err
+				}
+				values[id] = value
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			// Rewrite them as blobs.
+			stmt := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ?`, table, column)
+			for id, value := range values {
+				if _, err := tx.Exec(stmt, toBlob(fromString(value)), id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return setSchemaVersion(tx, blobVersion)
+}
+
+// backfillFingerprints writes the fingerprint column of the rows of table written before it existed, computed from
+// their scheme columns q, p and alpha, using tx. Databases at fingerprintVersion or later are left as is.
+func backfillFingerprints(tx *sql.Tx, table, q, p, g string) error {
+	version, err := schemaVersion(tx)
+	if err != nil || version >= fingerprintVersion {
+		return err
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id, %s, %s, %s FROM %s WHERE fingerprint = ''`, q, p, g, table))
+	if err != nil {
+		return err
+	}
+	fingerprints := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		scanner := new(rowScanner).New(3)
+		if err := rows.Scan(append([]interface{}{&id}, scanner.dest...)...); err != nil {
+			rows.Close()
+			return err
+		}
+		vals := scanner.Strings()
+		scheme := core.SchemeParams{Q: fromBlob(vals[0]), P: fromBlob(vals[1]), G: fromBlob(vals[2])}
+		fingerprints[id] = scheme.Fingerprint()
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %s SET fingerprint = ? WHERE id = ?`, table)
+	for id, fingerprint := range fingerprints {
+		if _, err := tx.Exec(stmt, fingerprint, id); err != nil {
+			return err
+		}
+	}
+
+	return setSchemaVersion(tx, fingerprintVersion)
+}
+
+// checkFingerprint returns core.ErrSchemeMismatch unless scheme matches fingerprint, stored along with it. Empty
+// fingerprints are accepted.
+func checkFingerprint(scheme *core.SchemeParams, fingerprint string) error {
+	if fingerprint != "" && fingerprint != scheme.Fingerprint() {
+		return core.ErrSchemeMismatch
+	}
+	return nil
+}
+
+// toBlob is used to translate big.Int types to a blob when writing to the database: a sign byte (1 if negative)
+// followed by the big-endian magnitude. nil is an empty blob.
+func toBlob(z *big.Int) []byte {
+	if z == nil {
+		return []byte{}
+	}
+	sign := byte(0)
+	if z.Sign() < 0 {
+		sign = 1
+	}
+	return append([]byte{sign}, z.Bytes()...)
+}
+
+// fromBlob is used to translate a blob scanned from the database into a big.Int type.
+func fromBlob(b string) *big.Int {
+	if b == "" {
+		return nil
+	}
+	z := new(big.Int).SetBytes([]byte(b[1:]))
+	if b[0] == 1 {
+		z.Neg(z)
+	}
+	return z
+}
+
+// toString is used to translate big.Int types to decimal text. (Sealed secrets, and columns written before
+// blobVersion)
 func toString(z *big.Int) string {
 	if z == nil {
 		return ""
@@ -80,7 +580,7 @@ func toString(z *big.Int) string {
 	return z.String()
 }
 
-// fromString is used to translate text scanned from the database into a big.Int type.
+// fromString is used to translate decimal text into a big.Int type.
 func fromString(s string) *big.Int {
 	if s == "" {
 		return nil
@@ -91,6 +591,26 @@ func fromString(s string) *big.Int {
 	return nil
 }
 
+// toHashes is used to translate a list of hashes into text for the database.
+func toHashes(hashes []uint32) string {
+	fields := make([]string, len(hashes))
+	for i, hash := range hashes {
+		fields[i] = strconv.FormatUint(uint64(hash), 10)
+	}
+	return strings.Join(fields, " ")
+}
+
+// fromHashes is used to translate text scanned from the database into a list of hashes.
+func fromHashes(s string) []uint32 {
+	var hashes []uint32
+	for _, field := range strings.Fields(s) {
+		if hash, err := strconv.ParseUint(field, 10, 32); err == nil {
+			hashes = append(hashes, uint32(hash))
+		}
+	}
+	return hashes
+}
+
 // rowScanner is a helper type for scanning rows from the database.
 type rowScanner struct {
 	dest []interface{}
@@ -124,3 +644,282 @@ func (scanner *rowScanner) Strings() []string {
 
 	return res
 }
+
+// statementCache prepares each statement of a store once and reuses it across transactions, so that hot operations
+// don't re-parse the same SQL on every call.
+type statementCache struct {
+	db         *sql.DB
+	mutex      sync.Mutex
+	statements map[string]*sql.Stmt
+}
+
+// New.
+func (cache *statementCache) New(db *sql.DB) *statementCache {
+	cache.db = db
+	cache.statements = make(map[string]*sql.Stmt)
+	return cache
+}
+
+// prepare returns query prepared, preparing it on first use.
+func (cache *statementCache) prepare(query string) (*sql.Stmt, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if stmt, ok := cache.statements[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := cache.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	cache.statements[query] = stmt
+	return stmt, nil
+}
+
+// exec is like tx.Exec, with query prepared once.
+func (cache *statementCache) exec(tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := cache.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Stmt(stmt).Exec(args...)
+}
+
+// queryRow is like tx.QueryRow, with query prepared once. (A query failing to prepare fails the same way unprepared)
+func (cache *statementCache) queryRow(tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	stmt, err := cache.prepare(query)
+	if err != nil {
+		return tx.QueryRow(query, args...)
+	}
+	return tx.Stmt(stmt).QueryRow(args...)
+}
+
+// createMetaTable creates the Meta table of a store's database using tx.
+func createMetaTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS Meta (
+	-- keys
+	key TEXT PRIMARY KEY ON CONFLICT REPLACE,
+
+	-- Meta
+	value TEXT NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// Meta is a store's table of small persistent settings, a value per key. The keys are shared by the whole database,
+// callers scope them as needed. (e.g. "agent/<bank>/...")
+type Meta struct {
+	db *sql.DB
+}
+
+// readValue returns the value of key, ErrUnknownMeta if unset.
+func (meta *Meta) readValue(key string) (string, error) {
+	var value string
+	err := meta.db.QueryRow(`SELECT value FROM Meta WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrUnknownMeta
+	}
+	return value, err
+}
+
+// writeValue sets the value of key, replacing the previous one.
+func (meta *Meta) writeValue(key, value string) error {
+	_, err := meta.db.Exec(`INSERT INTO Meta (key, value) VALUES (?, ?)`, key, value)
+	return err
+}
+
+// ReadString returns the string value of key, ErrUnknownMeta if unset.
+func (meta *Meta) ReadString(key string) (string, error) {
+	return meta.readValue(key)
+}
+
+// WriteString sets key to value.
+func (meta *Meta) WriteString(key, value string) error {
+	return meta.writeValue(key, value)
+}
+
+// ReadInt returns the integer value of key, ErrUnknownMeta if unset.
+func (meta *Meta) ReadInt(key string) (int64, error) {
+	value, err := meta.readValue(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// WriteInt sets key to value.
+func (meta *Meta) WriteInt(key string, value int64) error {
+	return meta.writeValue(key, strconv.FormatInt(value, 10))
+}
+
+// ReadBool returns the boolean value of key, ErrUnknownMeta if unset.
+func (meta *Meta) ReadBool(key string) (bool, error) {
+	value, err := meta.readValue(key)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+// WriteBool sets key to value.
+func (meta *Meta) WriteBool(key string, value bool) error {
+	return meta.writeValue(key, strconv.FormatBool(value))
+}
+
+// ReadTime returns the date value of key, ErrUnknownMeta if unset.
+func (meta *Meta) ReadTime(key string) (time.Time, error) {
+	value, err := meta.readValue(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// WriteTime sets key to value, in UTC.
+func (meta *Meta) WriteTime(key string, value time.Time) error {
+	return meta.writeValue(key, value.UTC().Format(time.RFC3339Nano))
+}
+
+// ReadBinary decodes the value of key into value, ErrUnknownMeta if unset.
+func (meta *Meta) ReadBinary(key string, value encoding.BinaryUnmarshaler) error {
+	encoded, err := meta.readValue(key)
+	if err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return value.UnmarshalBinary(data)
+}
+
+// WriteBinary sets key to the encoding of value, in base64.
+func (meta *Meta) WriteBinary(key string, value encoding.BinaryMarshaler) error {
+	data, err := value.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return meta.writeValue(key, base64.StdEncoding.EncodeToString(data))
+}
+
+// Delete unsets key.
+func (meta *Meta) Delete(key string) error {
+	_, err := meta.db.Exec(`DELETE FROM Meta WHERE key = ?`, key)
+	return err
+}
+
+// scannable is a row that can be scanned, either a *sql.Row or the current row of *sql.Rows.
+type scannable interface {
+	Scan(dest ...interface{}) error
+}
+
+// inspectTable scans each row of query with scan, for inspecting a table.
+func inspectTable(tx *sql.Tx, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// fullColumns returns the blob columns to select after the others, only when inspecting the full database.
+func fullColumns(full bool, columns string) string {
+	if !full {
+		return ""
+	}
+	return ", " + columns
+}
+
+// scanInspected scans a row of an inspected table into dest, followed by its blob columns decoded into numbers when
+// full. (See fullColumns)
+func scanInspected(row scannable, full bool, dest []interface{}, numbers ...**big.Int) error {
+	if !full {
+		return row.Scan(dest...)
+	}
+
+	blobs := make([]string, len(numbers))
+	for i := range blobs {
+		dest = append(dest, &blobs[i])
+	}
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	for i, blob := range blobs {
+		*numbers[i] = fromBlob(blob)
+	}
+	return nil
+}
+
+// passphraseCipher returns the AES-256-GCM cipher keyed by passphrase and salt.
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives a 32 bytes key from passphrase and salt using PBKDF2-HMAC-SHA256.
+func deriveKey(passphrase string, salt []byte) []byte {
+	prf := hmac.New(sha256.New, []byte(passphrase))
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < passphraseIterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// seal encrypts plaintext using passphrase. The result is base64 encoded as: salt (16) | nonce (12) | ciphertext.
+func seal(plaintext []byte, passphrase string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := append(append(salt, nonce...), aead.Seal(nil, nonce, plaintext, nil)...)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unseal decrypts text produced by seal using passphrase. Returns ErrPassphrase if it can't be decrypted.
+func unseal(text string, passphrase string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(sealed) < 16 {
+		return nil, ErrPassphrase
+	}
+	aead, err := passphraseCipher(passphrase, sealed[:16])
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 16+aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	nonce := sealed[16 : 16+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[16+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return plaintext, nil
+}
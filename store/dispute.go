@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+	"ziba/core"
+)
+
+//
+// DISPUTES
+//
+
+// 1. A coin surrendered again, or refused as invalid, is recorded as a dispute: the core.Dispute, binary encoded, along
+//		with the operation and the client it was surrendered by.
+// 2. Disputes are exported as is, a third party decodes and verifies them with the core package alone.
+
+// createDisputeTable creates the Dispute table of a bank's database using tx.
+func createDisputeTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS Dispute (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash INTEGER NOT NULL, -- CoinProfile hash
+
+	-- Dispute
+	kind 			INTEGER NOT NULL,
+	operation INTEGER NOT NULL,
+	client 		INTEGER NOT NULL, -- ClientProfile hash
+	evidence 	BLOB NOT NULL, 		-- core.Dispute (binary)
+
+	date DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// WriteDispute records dispute, detected at operation of client, and returns its number.
+func (store *BankStore) WriteDispute(dispute *core.Dispute, operation Operation_Type, client *core.ClientProfile) (int64, error) {
+	evidence, err := dispute.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = retryBusy(func() error {
+		stmt := `INSERT INTO Dispute (hash, kind, operation, client, evidence, date) VALUES (?, ?, ?, ?, ?, ?)`
+		res, err := store.db.Exec(stmt, dispute.Coins[0].Hash(), dispute.Kind, operation, client.Hash(), evidence, dispute.Date.UTC())
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	return id, err
+}
+
+// ReadDispute returns the dispute numbered id, ErrUnknownDispute if there's none.
+func (store *BankStore) ReadDispute(id int64) (*DisputeRecord, error) {
+	stmt := `SELECT id, operation, client, evidence FROM Dispute WHERE id = ?`
+	record, err := scanDispute(store.db.QueryRow(stmt, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownDispute
+	}
+	return record, err
+}
+
+// ReadDisputes returns every dispute, by number.
+func (store *BankStore) ReadDisputes() ([]DisputeRecord, error) {
+	rows, err := store.db.Query(`SELECT id, operation, client, evidence FROM Dispute ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []DisputeRecord
+	for rows.Next() {
+		record, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *record)
+	}
+	return list, rows.Err()
+}
+
+// scanDispute scans the id, operation, client and evidence of a Dispute entry from row.
+func scanDispute(row scannable) (*DisputeRecord, error) {
+	var (
+		record   DisputeRecord
+		evidence []byte
+	)
+	if err := row.Scan(&record.ID, &record.Operation, &record.Client, &evidence); err != nil {
+		return nil, err
+	}
+	if err := record.Dispute.UnmarshalBinary(evidence); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
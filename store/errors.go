@@ -5,4 +5,37 @@ import "errors"
 var (
 	ErrExistingClient = errors.New("ziba/store: client already exists")
 	ErrExistingCoin   = errors.New("ziba/store: coin already exists")
+	ErrUnspentCoin    = errors.New("ziba/store: coin was never surrendered to the bank")
+	ErrUnknownClient  = errors.New("ziba/store: no account for client")
+	ErrClosedAccount  = errors.New("ziba/store: account is closed")
+
+	ErrInsufficientFunds = errors.New("ziba/store: insufficient funds")
+
+	ErrUnknownApplication = errors.New("ziba/store: no account application for client")
+	ErrRegistrationToken  = errors.New("ziba/store: unknown or used registration token")
+
+	ErrExistingIdentity = errors.New("ziba/store: identity already exists")
+	ErrIdentityBundle   = errors.New("ziba/store: malformed identity bundle")
+	ErrIdentityVersion  = errors.New("ziba/store: unsupported identity bundle version")
+	ErrIdentityChecksum = errors.New("ziba/store: identity bundle checksum mismatch")
+	ErrPassphrase       = errors.New("ziba/store: wrong passphrase or corrupted data")
+	ErrLockedBank       = errors.New("ziba/store: bank identity is locked")
+	ErrUnknownCurrency  = errors.New("ziba/store: no mint for currency")
+	ErrUnknownChange    = errors.New("ziba/store: no uncollected change")
+	ErrUnknownRate      = errors.New("ziba/store: no exchange rate between currencies")
+	ErrWalletInUse      = errors.New("ziba/store: wallet is in use")
+	ErrOutdatedSchema   = errors.New("ziba/store: database schema is outdated, open it read-write first")
+	ErrUnknownAccount   = errors.New("ziba/store: no such sub-account")
+	ErrSpendingLimit    = errors.New("ziba/store: sub-account's spending limit exceeded")
+	ErrReservedCoin     = errors.New("ziba/store: coin is reserved by a pending transaction")
+	ErrUnknownCoin      = errors.New("ziba/store: no such coin in the wallet")
+	ErrUnknownDispute   = errors.New("ziba/store: no such dispute")
+	ErrUnknownAlert     = errors.New("ziba/store: no such fraud alert, or not pending")
+	ErrPayerLimit       = errors.New("ziba/store: payer's daily auto-accept limit exceeded")
+	ErrUnknownMeta      = errors.New("ziba/store: no value for setting")
+	ErrNameCollision    = errors.New("ziba/store: name is taken by a user or bank of the other role")
+	ErrDatabaseOption   = errors.New("ziba/store: invalid database option")
+
+	ErrUnknownCertificate   = errors.New("ziba/store: no certificate for role and name")
+	ErrMalformedCertificate = errors.New("ziba/store: malformed PEM certificate")
 )
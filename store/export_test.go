@@ -0,0 +1,14 @@
+package store
+
+// ToBlob, FromBlob, ToString and FromString expose the encodings of big.Int columns: BLOB, and the former TEXT.
+var (
+	ToBlob     = toBlob
+	FromBlob   = fromBlob
+	ToString   = toString
+	FromString = fromString
+)
+
+// ReadGeneration returns the current CacheGeneration of the database.
+func (store *BankStore) ReadGeneration() (int64, error) {
+	return store.readGeneration()
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"ziba/core"
+)
+
+//
+// FRAUD ALERTS
+//
+
+// 1. The withdrawals and deposits matched by the bank's fraud rules raise alerts, with the action of the rule:
+//		Alert_Rejected operations were refused, Alert_Flagged ones were served.
+// 2. Operations requiring an admin's approval are refused while Alert_Pending. Once Alert_Approved, the same
+//		operation (rule, client, currency and value) is served the next time the client tries, and the alert is
+//		Alert_Released. An Alert_Denied (rejected by the admin) operation keeps being refused.
+
+// createFraudAlertTable creates the FraudAlert table of a bank's database using tx.
+func createFraudAlertTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS FraudAlert (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+	-- FraudAlert
+	rule 			TEXT NOT NULL,
+	status 		INTEGER NOT NULL, -- Alert_Type
+	operation INTEGER NOT NULL,
+	client 		INTEGER NOT NULL, -- ClientProfile hash
+	currency 	TEXT NOT NULL,
+	value 		INTEGER NOT NULL,
+
+	date DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// WriteFraudAlert records alert, dated now, and returns its number.
+func (store *BankStore) WriteFraudAlert(alert *FraudAlert) (int64, error) {
+	err := retryBusy(func() error {
+		return store.writeFraudAlert(alert)
+	})
+	return alert.ID, err
+}
+
+// writeFraudAlert is WriteFraudAlert, run once.
+func (store *BankStore) writeFraudAlert(alert *FraudAlert) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertFraudAlert(tx, alert); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertFraudAlert inserts alert, dated now, using tx and sets its number.
+func insertFraudAlert(tx *sql.Tx, alert *FraudAlert) error {
+	alert.Date = time.Now().UTC()
+	stmt := `INSERT INTO FraudAlert (rule, status, operation, client, currency, value, date) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.Exec(stmt, alert.Rule, alert.Status, alert.Operation, alert.Client,
+		core.NormalizeCurrency(alert.Currency), alert.Value, alert.Date)
+	if err != nil {
+		return err
+	}
+	alert.ID, err = res.LastInsertId()
+	return err
+}
+
+// RequestApproval returns the status of the approval of alert's operation: Alert_Released if an admin approved it,
+// Alert_Pending or Alert_Denied otherwise. alert is recorded as Alert_Pending the first time.
+func (store *BankStore) RequestApproval(alert *FraudAlert) (Alert_Type, error) {
+	var status Alert_Type
+	err := retryBusy(func() error {
+		var err error
+		status, err = store.requestApproval(alert)
+		return err
+	})
+	return status, err
+}
+
+// requestApproval is RequestApproval, run once.
+func (store *BankStore) requestApproval(alert *FraudAlert) (Alert_Type, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return Alert_Pending, err
+	}
+	defer tx.Rollback()
+
+	// The latest decision on the same operation.
+	var id int64
+	status := Alert_Pending
+	stmt := `SELECT id, status FROM FraudAlert WHERE rule = ? AND operation = ? AND client = ? AND currency = ? AND value = ?
+	AND status IN (?, ?, ?) ORDER BY id DESC LIMIT 1`
+	err = tx.QueryRow(stmt, alert.Rule, alert.Operation, alert.Client, core.NormalizeCurrency(alert.Currency), alert.Value,
+		Alert_Pending, Alert_Approved, Alert_Denied).Scan(&id, &status)
+	switch {
+	case err == sql.ErrNoRows:
+		alert.Status = Alert_Pending
+		if err := insertFraudAlert(tx, alert); err != nil {
+			return Alert_Pending, err
+		}
+	case err != nil:
+		return Alert_Pending, err
+	case status == Alert_Approved:
+		status = Alert_Released
+		if _, err := tx.Exec(`UPDATE FraudAlert SET status = ? WHERE id = ?`, status, id); err != nil {
+			return Alert_Pending, err
+		}
+		alert.ID = id
+	default:
+		alert.ID = id
+	}
+	return status, tx.Commit()
+}
+
+// UpdateFraudAlert sets the status of the pending alert numbered id, Alert_Approved or Alert_Denied. Returns
+// ErrUnknownAlert if there's no such pending alert.
+func (store *BankStore) UpdateFraudAlert(id int64, status Alert_Type) error {
+	res, err := store.db.Exec(`UPDATE FraudAlert SET status = ? WHERE id = ? AND status = ?`, status, id, Alert_Pending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUnknownAlert
+	}
+	return nil
+}
+
+// ReadFraudAlert returns the alert numbered id, ErrUnknownAlert if there's none.
+func (store *BankStore) ReadFraudAlert(id int64) (*FraudAlert, error) {
+	var alert FraudAlert
+	stmt := `SELECT id, rule, status, operation, client, currency, value, date FROM FraudAlert WHERE id = ?`
+	err := store.db.QueryRow(stmt, id).Scan(&alert.ID, &alert.Rule, &alert.Status, &alert.Operation, &alert.Client,
+		&alert.Currency, &alert.Value, &alert.Date)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownAlert
+	}
+	return &alert, err
+}
+
+// ReadFraudAlerts returns the alerts with status, oldest first.
+func (store *BankStore) ReadFraudAlerts(status Alert_Type) ([]FraudAlert, error) {
+	stmt := `SELECT id, rule, status, operation, client, currency, value, date FROM FraudAlert WHERE status = ? ORDER BY id`
+	rows, err := store.db.Query(stmt, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []FraudAlert
+	for rows.Next() {
+		var alert FraudAlert
+		err := rows.Scan(&alert.ID, &alert.Rule, &alert.Status, &alert.Operation, &alert.Client, &alert.Currency, &alert.Value, &alert.Date)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//
+// IDENTITY BUNDLE
+//
+// An identity bundle is laid out as:
+//
+//	magic (6) | version (1) | salt (16) | nonce (12) | ciphertext
+//
+// The ciphertext is the gob encoded Identity, sealed with AES-256-GCM using a key derived from a passphrase. The header is
+// authenticated as additional data.
+//
+
+const (
+	identityVersion  = 1
+	identitySaltSize = 16
+)
+
+var identityMagic = []byte("ZIBAID")
+
+// ExportIdentity reads user's database and certificates from the Ziba directory and returns them as an encrypted bundle.
+func ExportIdentity(user, passphrase string) ([]byte, error) {
+	// Get Ziba directory.
+	directory, err := GetZibaDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that database file exists.
+	dbPath := DatabasePath(directory, user)
+	dbName := filepath.Base(dbPath)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	// Snapshot database. (Includes the pages still in the WAL file)
+	data, err := snapshotDatabase(dbPath)
+	if err != nil {
+		log.Printf("failed to snapshot database: %v", err)
+		return nil, err
+	}
+
+	identity := &Identity{
+		Version: identityVersion,
+		User:    user,
+		Created: time.Now(),
+	}
+	identity.Files = append(identity.Files, IdentityFile{Name: dbName, Data: data, Checksum: sha256.Sum256(data)})
+
+	// Certificates. (Own private key and every known certificate)
+	names, err := filepath.Glob(filepath.Join(directory, certDir, "*_cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, KeyPath(directory, user))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		identity.Files = append(identity.Files, IdentityFile{Name: filepath.Base(name), Data: data, Checksum: sha256.Sum256(data)})
+	}
+
+	// Encode.
+	var plaintext bytes.Buffer
+	if err := gob.NewEncoder(&plaintext).Encode(identity); err != nil {
+		return nil, err
+	}
+
+	// Encrypt.
+	header := make([]byte, 0, len(identityMagic)+1+identitySaltSize)
+	header = append(header, identityMagic...)
+	header = append(header, identityVersion)
+	salt := make([]byte, identitySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	aead, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	bundle := append(header, nonce...)
+	return aead.Seal(bundle, nonce, plaintext.Bytes(), header), nil
+}
+
+// OpenIdentity decrypts bundle using passphrase and verifies its version and the checksum of every file.
+func OpenIdentity(bundle []byte, passphrase string) (*Identity, error) {
+	// Parse header.
+	headerSize := len(identityMagic) + 1 + identitySaltSize
+	if len(bundle) < headerSize || !bytes.Equal(bundle[:len(identityMagic)], identityMagic) {
+		return nil, ErrIdentityBundle
+	}
+	if bundle[len(identityMagic)] != identityVersion {
+		return nil, ErrIdentityVersion
+	}
+	header := bundle[:headerSize]
+	salt := header[len(identityMagic)+1:]
+
+	// Decrypt.
+	aead, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle) < headerSize+aead.NonceSize() {
+		return nil, ErrIdentityBundle
+	}
+	nonce := bundle[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, bundle[headerSize+aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+
+	// Decode.
+	var identity Identity
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&identity); err != nil {
+		return nil, ErrIdentityBundle
+	}
+	if identity.Version != identityVersion {
+		return nil, ErrIdentityVersion
+	}
+
+	// Verify checksums and file names.
+	for _, file := range identity.Files {
+		if file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+			return nil, ErrIdentityBundle
+		}
+		if sha256.Sum256(file.Data) != file.Checksum {
+			return nil, ErrIdentityChecksum
+		}
+	}
+
+	return &identity, nil
+}
+
+// ImportIdentity restores the identity contained in bundle into the Ziba directory.
+// Returns ErrExistingIdentity if a database already exists for the bundle's user. Certificates already present are kept.
+func ImportIdentity(bundle []byte, passphrase string) (*Identity, error) {
+	// Open bundle.
+	identity, err := OpenIdentity(bundle, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get Ziba directory.
+	directory, err := GetZibaDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that the user doesn't exist.
+	dbPath := DatabasePath(directory, identity.User)
+	dbName := filepath.Base(dbPath)
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, ErrExistingIdentity
+	}
+
+	// Write files. (Into their subdirectory, by name)
+	for _, file := range identity.Files {
+		path := filepath.Join(directory, layoutDir(file.Name), file.Name)
+		if file.Name != dbName {
+			if _, err := os.Stat(path); err == nil {
+				log.Printf("keeping existing file %s", file.Name)
+				continue
+			}
+		}
+		if err := WriteFileAtomic(path, file.Data, 0600); err != nil { // rw- --- ---
+			log.Printf("failed to write %s: %v", file.Name, err)
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+// snapshotDatabase returns a consistent copy of the database at dbPath.
+func snapshotDatabase(dbPath string) ([]byte, error) {
+	db, err := openDatabase(dbPath, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	tmpDir, err := os.MkdirTemp("", "ziba")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "snapshot.db")
+	if _, err := db.Exec(`VACUUM INTO ?`, tmpPath); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(tmpPath)
+}
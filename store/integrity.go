@@ -0,0 +1,233 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"ziba/core"
+)
+
+//
+// INTEGRITY
+//
+
+// 1. Every change of a client's balance is appended to the BalanceLog table, the bank's audit log: the amount changed
+//		and the resulting balance, dated.
+// 2. VerifyIntegrity re-verifies the stored coin profiles against the mints' keys, and their hashes. It then replays
+//		the audit log of each balance: each entry follows the balance of the previous one, and the last one is the
+//		stored balance. E.g. after restoring the database from a backup.
+// 3. Balances changed before the audit log existed are only checked from their first change since.
+
+// IntegrityReport is the outcome of VerifyIntegrity.
+type IntegrityReport struct {
+	Coins    int64    // Coin profiles verified.
+	Balances int64    // Balances checked against the audit log.
+	Problems []string // Inconsistencies found, none if empty.
+}
+
+// integrityBatch is the number of coin profiles verified at once by VerifyIntegrity.
+const integrityBatch = 1024
+
+// balanceKey is a client's balance in a currency.
+type balanceKey struct {
+	client   int64 // ClientProfile hash
+	currency string
+}
+
+// logBalance appends to the audit log, using tx, the change by amount of the balance in currency of the client of
+// hash, to balance.
+func (store *BankStore) logBalance(tx *sql.Tx, hash uint32, currency string, amount, balance int64) error {
+	stmt := `INSERT INTO BalanceLog (client, currency, amount, balance, date) VALUES (?, ?, ?, ?, ?)`
+	_, err := store.statements.exec(tx, stmt, hash, core.NormalizeCurrency(currency), amount, balance, time.Now().UTC())
+	return err
+}
+
+// VerifyIntegrity verifies the stored coin profiles and balances, and reports their inconsistencies.
+func (store *BankStore) VerifyIntegrity() (*IntegrityReport, error) {
+	profiles, err := store.ReadMints()
+	if err != nil {
+		return nil, err
+	}
+	mints := make(map[string]*core.BankProfile, len(profiles))
+	for i := range profiles {
+		mints[core.NormalizeCurrency(profiles[i].Currency)] = &profiles[i]
+	}
+
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := new(IntegrityReport)
+	if err := report.verifyCoins(tx, mints); err != nil {
+		return nil, err
+	}
+	if err := report.verifyBalances(tx); err != nil {
+		return nil, err
+	}
+	return report, tx.Commit()
+}
+
+// verifyCoins verifies the coin profiles stored in the database of tx against mints, by currency.
+func (report *IntegrityReport) verifyCoins(tx *sql.Tx, mints map[string]*core.BankProfile) error {
+	rows, err := tx.Query(`SELECT ` + coinProfileColumns + ` FROM CoinProfile ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		coins  []*core.CoinProfile
+		hashes []int64
+	)
+	for rows.Next() {
+		hash, coin, err := scanCoinProfile(rows)
+		if err != nil {
+			return err
+		}
+		coins, hashes = append(coins, coin), append(hashes, hash)
+		if len(coins) == integrityBatch {
+			report.verifyCoinBatch(mints, coins, hashes)
+			coins, hashes = coins[:0], hashes[:0]
+		}
+	}
+	report.verifyCoinBatch(mints, coins, hashes)
+	return rows.Err()
+}
+
+// verifyCoinBatch verifies coins, stored under hashes, against mints.
+func (report *IntegrityReport) verifyCoinBatch(mints map[string]*core.BankProfile, coins []*core.CoinProfile, hashes []int64) {
+	currencies := make(map[string][]int)
+	for i, coin := range coins {
+		if coin.Hash() != uint32(hashes[i]) {
+			report.Problems = append(report.Problems, fmt.Sprintf("coin %d: stored under hash %d", coin.Hash(), hashes[i]))
+		}
+		currency := core.NormalizeCurrency(coin.Currency)
+		currencies[currency] = append(currencies[currency], i)
+	}
+
+	for currency, indices := range currencies {
+		mint, ok := mints[currency]
+		if !ok {
+			for _, i := range indices {
+				report.Problems = append(report.Problems, fmt.Sprintf("coin %d: no mint for %s", hashes[i], currency))
+			}
+			continue
+		}
+		batch := make([]*core.CoinProfile, len(indices))
+		for j, i := range indices {
+			batch[j] = coins[i]
+		}
+		for j, valid := range core.VerifyCoinBatch(mint, batch) {
+			if !valid && !verifyRezoned(mint, batch[j]) {
+				report.Problems = append(report.Problems, fmt.Sprintf("coin %d: invalid signature", hashes[indices[j]]))
+			}
+		}
+	}
+	report.Coins += int64(len(coins))
+}
+
+// verifyRezoned verifies coin against mint with its expiration at offset zero the other way than read: the expiration
+// is digested along with its time zone, UTC and a local time zone of offset zero aren't told apart once stored.
+func verifyRezoned(mint *core.BankProfile, coin *core.CoinProfile) bool {
+	if _, offset := coin.Expiration.Zone(); offset != 0 {
+		return false
+	}
+	rezoned := *coin
+	if coin.Expiration.Location() == time.UTC {
+		rezoned.Expiration = coin.Expiration.In(time.FixedZone("", 0))
+	} else {
+		rezoned.Expiration = coin.Expiration.UTC()
+	}
+	return rezoned.VerifyProperties(mint)
+}
+
+// verifyBalances replays the audit log of the balances stored in the database of tx.
+func (report *IntegrityReport) verifyBalances(tx *sql.Tx) error {
+	// Stored balances.
+	balances := make(map[balanceKey]int64)
+	rows, err := tx.Query(`SELECT hash, balance FROM ClientInfo`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var client, balance int64
+		if err := rows.Scan(&client, &balance); err != nil {
+			rows.Close()
+			return err
+		}
+		balances[balanceKey{client, core.DefaultCurrency}] = balance
+	}
+	rows.Close()
+	rows, err = tx.Query(`SELECT client, currency, balance FROM ClientBalance`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			key     balanceKey
+			balance int64
+		)
+		if err := rows.Scan(&key.client, &key.currency, &balance); err != nil {
+			rows.Close()
+			return err
+		}
+		balances[key] = balance
+	}
+	rows.Close()
+
+	// Replay the audit log, by balance.
+	rows, err = tx.Query(`SELECT id, client, currency, amount, balance FROM BalanceLog ORDER BY client, currency, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		last    balanceKey
+		balance int64
+		started bool
+	)
+	for rows.Next() {
+		var (
+			id     int64
+			key    balanceKey
+			amount int64
+			logged int64
+		)
+		if err := rows.Scan(&id, &key.client, &key.currency, &amount, &logged); err != nil {
+			return err
+		}
+		if started && key == last && logged-amount != balance {
+			report.Problems = append(report.Problems, fmt.Sprintf("client %d, %s: audit log entry %d doesn't follow balance %d", key.client, key.currency, id, balance))
+		}
+		if started && key != last {
+			report.checkBalance(balances, last, balance)
+		}
+		last, balance, started = key, logged, true
+	}
+	if started {
+		report.checkBalance(balances, last, balance)
+	}
+	return rows.Err()
+}
+
+// checkBalance checks the balance of key in balances, as stored, is logged, the last one of its audit log.
+func (report *IntegrityReport) checkBalance(balances map[balanceKey]int64, key balanceKey, logged int64) {
+	report.Balances++
+	stored, ok := balances[key]
+	if !ok {
+		if _, known := balances[balanceKey{key.client, core.DefaultCurrency}]; !known {
+			report.Problems = append(report.Problems, fmt.Sprintf("client %d: audit log of an unknown client", key.client))
+			return
+		}
+		stored = initialBalance
+	}
+	if stored != logged {
+		report.Problems = append(report.Problems, fmt.Sprintf("client %d, %s: balance %d, audit log ends at %d", key.client, key.currency, stored, logged))
+	}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+//
+// OPERATION LIMITS
+//
+
+// 1. The bank limits the operations of each client within a rolling window, e.g. the coins withdrawn per day. Each
+//		operation served is recorded in the OperationCount table, along with the number of coins it counts for.
+// 2. The window slides: the operations recorded before it no longer count. They are deleted by
+//		DeleteOperationCounts once older than the longest window.
+
+// createOperationCountTable creates the OperationCount table of a bank's database using tx.
+func createOperationCountTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS OperationCount (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+	-- OperationCount
+	client 		INTEGER NOT NULL, -- ClientProfile hash
+	operation INTEGER NOT NULL, -- Operation_Type
+	count 		INTEGER NOT NULL,
+
+	date DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// WriteOperationCount records count operations of client (ClientProfile hash), dated now.
+func (store *BankStore) WriteOperationCount(client uint32, operation Operation_Type, count int) error {
+	return retryBusy(func() error {
+		stmt := `INSERT INTO OperationCount (client, operation, count, date) VALUES (?, ?, ?, ?)`
+		_, err := store.db.Exec(stmt, client, operation, count, time.Now().UTC())
+		return err
+	})
+}
+
+// ReadOperationCount returns the number of operations of client (ClientProfile hash) recorded since, and the date of
+// the oldest of them. (Zero if none)
+func (store *BankStore) ReadOperationCount(client uint32, operation Operation_Type, since time.Time) (int, time.Time, error) {
+	stmt := `SELECT count, date FROM OperationCount WHERE client = ? AND operation = ? AND date >= ? ORDER BY date`
+	rows, err := store.db.Query(stmt, client, operation, since.UTC())
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var (
+		total  int
+		oldest time.Time
+	)
+	for rows.Next() {
+		var (
+			count int
+			date  time.Time
+		)
+		if err := rows.Scan(&count, &date); err != nil {
+			return 0, time.Time{}, err
+		}
+		if oldest.IsZero() {
+			oldest = date
+		}
+		total += count
+	}
+	return total, oldest, rows.Err()
+}
+
+// DeleteOperationCounts deletes the operations recorded before date, and returns their number.
+func (store *BankStore) DeleteOperationCounts(before time.Time) (int64, error) {
+	res, err := store.db.Exec(`DELETE FROM OperationCount WHERE date < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
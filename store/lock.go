@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//
+// WALLET LOCK
+//
+
+// 1. A command modifying a wallet holds its advisory lock, a lock file next to the database holding the PID of the
+//		holding process, while it runs.
+// 2. A second process locking the same wallet fails with ErrWalletInUse, naming the holder, instead of interleaving its
+//		operations.
+// 3. A lock left behind by a process that no longer runs (e.g. killed) is taken over.
+
+// WalletLock is the advisory lock of a wallet, held until Unlock.
+type WalletLock struct {
+	// path is the lock file's path.
+	path string
+}
+
+// LockWallet acquires the lock of the wallet at dbPath. If another running process holds it, an error wrapping
+// ErrWalletInUse and naming its PID is returned.
+func LockWallet(dbPath string) (*WalletLock, error) {
+	path := dbPath + ".lock"
+
+	// Write this process' PID aside, so that the lock file is created along with its contents.
+	temp := fmt.Sprintf("%s.%d", path, os.Getpid())
+	if err := os.WriteFile(temp, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp)
+
+	for {
+		// Create the lock file, unless it exists.
+		err := os.Link(temp, path)
+		if err == nil {
+			return &WalletLock{path: path}, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+
+		// Check the holder.
+		pid, err := lockHolder(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil && running(pid) {
+			return nil, fmt.Errorf("%w by PID %d", ErrWalletInUse, pid)
+		}
+
+		// Take over a stale lock.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// Unlock releases the lock, if still held by this process.
+func (lock *WalletLock) Unlock() error {
+	if pid, err := lockHolder(lock.path); err != nil || pid != os.Getpid() {
+		return err
+	}
+	return os.Remove(lock.path)
+}
+
+// lockHolder returns the PID held by the lock file at path. A malformed lock file is held by no process.
+func lockHolder(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, nil
+}
+
+// running reports whether the process of pid is running.
+func running(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+//
+// OPERATION LOG
+//
+
+// 1. The bank records each protocol run it serves in the OperationLog table: the server's name, its duration and the
+//		bytes received and sent, dated.
+// 2. The log is rolling, runs older than the retention are deleted as new ones are written.
+// 3. Operators read it aggregated into time buckets, e.g. hourly throughput of each operation, to plan parameter and
+//		hardware changes.
+
+// OperationRun is a protocol run served by the bank, as kept in its operation log.
+type OperationRun struct {
+	Name     string
+	Duration time.Duration
+	Received int64 // Bytes.
+	Sent     int64 // Bytes.
+	Date     time.Time
+}
+
+// OperationStats are the runs of an operation within a time bucket.
+type OperationStats struct {
+	Start    time.Time // Start of the bucket.
+	Name     string
+	Runs     int64
+	Mean     time.Duration
+	Max      time.Duration
+	Received int64 // Bytes, in total.
+	Sent     int64 // Bytes, in total.
+}
+
+// WriteOperations writes runs into the operation log, deleting the runs older than retention.
+func (store *BankStore) WriteOperations(runs []OperationRun, retention time.Duration) error {
+	return retryBusy(func() error {
+		return store.writeOperations(runs, retention)
+	})
+}
+
+// writeOperations is WriteOperations, run once.
+func (store *BankStore) writeOperations(runs []OperationRun, retention time.Duration) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO OperationLog (operation, duration, received, sent, date) VALUES (?, ?, ?, ?, ?)`
+	for _, run := range runs {
+		_, err := store.statements.exec(tx, stmt, run.Name, int64(run.Duration), run.Received, run.Sent, run.Date.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM OperationLog WHERE date < ?`, time.Now().UTC().Add(-retention))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReadOperationStats returns the runs of the operation log since since, aggregated by operation into buckets of
+// bucket, oldest first.
+func (store *BankStore) ReadOperationStats(since time.Time, bucket time.Duration) ([]OperationStats, error) {
+	stmt := `SELECT operation, duration, received, sent, date FROM OperationLog WHERE date >= ? ORDER BY date, id`
+	rows, err := store.db.Query(stmt, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		start time.Time
+		name  string
+	}
+	buckets := make(map[key]*OperationStats)
+	for rows.Next() {
+		var (
+			run      OperationRun
+			duration int64
+		)
+		if err := rows.Scan(&run.Name, &duration, &run.Received, &run.Sent, &run.Date); err != nil {
+			return nil, err
+		}
+		run.Duration = time.Duration(duration)
+
+		k := key{start: run.Date.UTC().Truncate(bucket), name: run.Name}
+		stats, found := buckets[k]
+		if !found {
+			stats = &OperationStats{Start: k.start, Name: k.name}
+			buckets[k] = stats
+		}
+		stats.Runs++
+		stats.Mean += run.Duration // Summed until every run is read.
+		stats.Max = max(stats.Max, run.Duration)
+		stats.Received += run.Received
+		stats.Sent += run.Sent
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]OperationStats, 0, len(buckets))
+	for _, stats := range buckets {
+		stats.Mean /= time.Duration(stats.Runs)
+		list = append(list, *stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Start.Equal(list[j].Start) {
+			return list[i].Start.Before(list[j].Start)
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//
+// PATHS
+//
+
+// 1. The Ziba directory holds db/, the databases of users and banks (and their lock files), certs/, the TLS keys and
+//		certificates, and config/, the exported shares and other configuration files.
+// 2. Paths are only resolved here, from the name of a user, a bank or a server.
+// 3. Users and banks share db/, a database holding one role can't be opened as the other. (ErrNameCollision)
+// 4. The flat layout of earlier versions is moved into the subdirectories the first time the directory is used.
+
+// Subdirectories of the Ziba directory.
+const (
+	databaseDir = "db"
+	certDir     = "certs"
+	configDir   = "config"
+)
+
+// DatabasePath returns the path of the database of name, a user or a bank, in the Ziba directory at directory.
+func DatabasePath(directory, name string) string {
+	return filepath.Join(directory, databaseDir, fmt.Sprintf("%s.db", name))
+}
+
+// KeyPath returns the path of the TLS private key of name, a user or a bank, in the Ziba directory at directory.
+func KeyPath(directory, name string) string {
+	return filepath.Join(directory, certDir, fmt.Sprintf("%s_key.pem", name))
+}
+
+// CertPath returns the path of the TLS certificate of name, a user, a bank or a server's address, in the Ziba
+// directory at directory.
+func CertPath(directory, name string) string {
+	return filepath.Join(directory, certDir, fmt.Sprintf("%s_cert.pem", name))
+}
+
+// ACMEPath returns the path of the ACME certificate cache of bank, in the Ziba directory at directory.
+func ACMEPath(directory, bank string) string {
+	return filepath.Join(directory, certDir, fmt.Sprintf("%s_acme", bank))
+}
+
+// ConfigPath returns the path of the configuration file named file, in the Ziba directory at directory.
+func ConfigPath(directory, file string) string {
+	return filepath.Join(directory, configDir, file)
+}
+
+// WriteFileAtomic writes data to the file at path with perm, through a temporary file of the same directory renamed
+// over it once synced, so that the file is either left unchanged or completely written.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// layoutDir returns the subdirectory a file of the flat layout belongs to, or "" if it's left in place.
+func layoutDir(file string) string {
+	switch {
+	case strings.Contains(file, ".db"):
+		return databaseDir
+	case strings.HasSuffix(file, ".pem"), strings.HasSuffix(file, "_acme"):
+		return certDir
+	case strings.HasSuffix(file, ".json") && (strings.Contains(file, "_share_") || strings.Contains(file, "_threshold_")):
+		return configDir
+	}
+	return ""
+}
+
+// createLayout creates the subdirectories of the Ziba directory at directory, moving the files of the flat layout into
+// them. A file already present in its subdirectory is left in place.
+func createLayout(directory string) error {
+	for _, sub := range []string{databaseDir, certDir, configDir} {
+		if err := os.MkdirAll(filepath.Join(directory, sub), 0755); err != nil { // rwx r-x r-x
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		sub := layoutDir(entry.Name())
+		if sub == "" || (entry.IsDir() && sub != certDir) {
+			continue
+		}
+		target := filepath.Join(directory, sub, entry.Name())
+		if _, err := os.Lstat(target); err == nil {
+			log.Printf("keeping %s, %s already exists", entry.Name(), target)
+			continue
+		}
+		if err := os.Rename(filepath.Join(directory, entry.Name()), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRole returns ErrNameCollision if the database of db holds the other role than the one of table, "Client" for a
+// user's and "Bank" for a bank's.
+func checkRole(db *sql.DB, table string) error {
+	other := "Bank"
+	if table == "Bank" {
+		other = "Client"
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, other).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrNameCollision
+	}
+	return nil
+}
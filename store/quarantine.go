@@ -0,0 +1,119 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"log"
+	"time"
+	"ziba/core"
+)
+
+//
+// QUARANTINE
+//
+
+// 1. A coin failing the wallet's verification (invalid, of an unknown bank, or revoked) may be quarantined: moved out
+//		of the wallet, and of its local balance, into the QuarantinedCoin table along with the reason.
+// 2. Quarantined coins are never spent, they're kept as evidence, e.g. for a dispute with the bank.
+
+// createQuarantineTable creates the QuarantinedCoin table of a wallet's database using tx.
+func createQuarantineTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS QuarantinedCoin (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+	hash 	 INTEGER NOT NULL, -- CoinProfile hash
+
+	-- QuarantinedCoin
+	coin 		TEXT NOT NULL, -- Coin (binary, base64)
+	account TEXT NOT NULL,
+	reason 	TEXT NOT NULL,
+	date 		DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// QuarantineCoin moves coin out of the wallet into the QuarantinedCoin table, for reason.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) QuarantineCoin(coin *core.Coin, reason string) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Grab the value held by this client and the coin's sub-account.
+	value, _, account, err := store.coinHolding(tx, coin)
+	if err != nil {
+		return err
+	}
+
+	// Quarantine the coin.
+	data, err := coin.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO QuarantinedCoin (client, hash, coin, account, reason, date) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(stmt,
+		store.clientId,
+		coin.Profile().Hash(),
+		base64.StdEncoding.EncodeToString(data),
+		account,
+		reason,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM Coin WHERE hash = ? AND client = ?`, coin.Profile().Hash(), store.clientId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUnknownCoin
+	}
+
+	// The coin leaves the local balance.
+	if err := store.updateBalance(tx, coin.Params.Currency, -value, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReadQuarantinedCoins returns the quarantined coins of this client, oldest first.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadQuarantinedCoins() ([]QuarantinedCoin, error) {
+	stmt := `SELECT coin, account, reason, date FROM QuarantinedCoin WHERE client = ? ORDER BY date, id`
+	rows, err := store.db.Query(stmt, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quarantined []QuarantinedCoin
+	for rows.Next() {
+		var (
+			encoded string
+			coin    QuarantinedCoin
+		)
+		if err := rows.Scan(&encoded, &coin.Account, &coin.Reason, &coin.Date); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := coin.Coin.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		quarantined = append(quarantined, coin)
+	}
+	return quarantined, rows.Err()
+}
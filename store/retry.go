@@ -0,0 +1,55 @@
+package store
+
+import (
+	"errors"
+	mrand "math/rand/v2"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//
+// BUSY RETRIES
+//
+
+// 1. Under WAL, a write can still fail with SQLITE_BUSY (or SQLITE_LOCKED) once the busy timeout of its connection
+//		elapsed, while concurrent handlers hold the write lock.
+// 2. The write operations of the servers' hot paths run their whole transaction again, a bounded number of times,
+//		after an exponential backoff with jitter.
+// 3. Other errors, and the last busy error, are returned as is.
+
+// Busy retries.
+var (
+	// busyAttempts is the number of times a busy transaction is run.
+	busyAttempts = 5
+
+	// busyBackoff is the wait before the first retry, doubled after each attempt.
+	busyBackoff = 50 * time.Millisecond
+)
+
+// isBusy reports whether err is a busy or locked database error.
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff // Primary result code.
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// retryBusy runs op, a whole transaction rolled back when it fails, until it doesn't fail with a busy database, up to
+// busyAttempts times.
+func retryBusy(op func() error) error {
+	backoff := busyBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !isBusy(err) || attempt >= busyAttempts {
+			return err
+		}
+
+		// Wait between half and one and a half backoffs.
+		time.Sleep(backoff/2 + mrand.N(backoff))
+		backoff *= 2
+	}
+}
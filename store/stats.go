@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/base64"
+	"sort"
+	"time"
+	"ziba/core"
+)
+
+//
+// STATISTICS
+//
+
+// 1. A wallet's statistics are computed from its spent-coins archive and its receipts, as kept until purged.
+// 2. The spending per merchant is the sum of the receipts of each merchant's address, by currency.
+// 3. The spending per month is the sum of the coins spent by payments each month (UTC), by currency.
+// 4. The lifetime of a coin is the time from its issuance, derived from its expiration under the validity policy known
+//		to the wallet (see core.Validity), until it's spent by any operation. Coins exchanged count from the exchange.
+
+// WalletStats are the spending statistics of a client, as returned by Stats.
+type WalletStats struct {
+	Merchants  []MerchantStats
+	Months     []MonthStats
+	Currencies []CurrencyStats
+}
+
+// MerchantStats are the payments to a merchant in a currency.
+type MerchantStats struct {
+	Merchant string
+	Currency string
+	Payments int64
+	Amount   int64
+}
+
+// MonthStats are the payments of a month, e.g. "2024-05", in a currency.
+type MonthStats struct {
+	Month    string
+	Currency string
+	Payments int64
+	Amount   int64
+}
+
+// CurrencyStats are the spent coins of a currency, by any operation.
+type CurrencyStats struct {
+	Currency        string
+	Coins           int64
+	Amount          int64
+	AverageLifetime time.Duration
+}
+
+// Amount returns the paid amount of receipt, the spent part of a partially spent coin.
+func (receipt *Receipt) Amount() int64 {
+	if receipt.Memo.Change != nil {
+		return receipt.Memo.Change.Amount
+	}
+	return core.NormalizeValue(receipt.Coin.Params.Value)
+}
+
+// Stats returns the spending statistics of this client. Merchants and currencies are sorted by name, months in order.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) Stats() (*WalletStats, error) {
+	stats := &WalletStats{}
+
+	// Spending per merchant.
+	receipts, err := store.ReadReceipts()
+	if err != nil {
+		return nil, err
+	}
+	merchants := make(map[[2]string]*MerchantStats)
+	for i := range receipts {
+		receipt := &receipts[i]
+		currency := core.NormalizeCurrency(receipt.Coin.Params.Currency)
+		entry, ok := merchants[[2]string{receipt.Merchant, currency}]
+		if !ok {
+			entry = &MerchantStats{Merchant: receipt.Merchant, Currency: currency}
+			merchants[[2]string{receipt.Merchant, currency}] = entry
+		}
+		entry.Payments++
+		entry.Amount += receipt.Amount()
+	}
+	for _, entry := range merchants {
+		stats.Merchants = append(stats.Merchants, *entry)
+	}
+	sort.Slice(stats.Merchants, func(i, j int) bool {
+		a, b := stats.Merchants[i], stats.Merchants[j]
+		return a.Merchant < b.Merchant || (a.Merchant == b.Merchant && a.Currency < b.Currency)
+	})
+
+	// Spending per month and coin lifetimes.
+	stmt := `SELECT coin, operation, currency, value, date FROM SpentCoin WHERE client = ? ORDER BY date, id`
+	rows, err := store.db.Query(stmt, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	months := make(map[[2]string]*MonthStats)
+	currencies := make(map[string]*CurrencyStats)
+	lifetimes := make(map[string]time.Duration)
+	for rows.Next() {
+		var (
+			encoded, currency string
+			operation         Operation_Type
+			value             int64
+			date              time.Time
+			coin              core.Coin
+		)
+		if err := rows.Scan(&encoded, &operation, &currency, &value, &date); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := coin.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		if len(currency) == 0 { // Archived before the value columns.
+			currency, value = core.NormalizeCurrency(coin.Params.Currency), core.NormalizeValue(coin.Params.Value)
+		}
+
+		if operation == Operation_Payment {
+			month := date.UTC().Format("2006-01")
+			entry, ok := months[[2]string{month, currency}]
+			if !ok {
+				entry = &MonthStats{Month: month, Currency: currency}
+				months[[2]string{month, currency}] = entry
+			}
+			entry.Payments++
+			entry.Amount += value
+		}
+
+		entry, ok := currencies[currency]
+		if !ok {
+			entry = &CurrencyStats{Currency: currency}
+			currencies[currency] = entry
+		}
+		entry.Coins++
+		entry.Amount += value
+		lifetimes[currency] += date.Sub(core.Validity.Issuance(coin.Params.Expiration, coin.Params.Value))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range months {
+		stats.Months = append(stats.Months, *entry)
+	}
+	sort.Slice(stats.Months, func(i, j int) bool {
+		a, b := stats.Months[i], stats.Months[j]
+		return a.Month < b.Month || (a.Month == b.Month && a.Currency < b.Currency)
+	})
+	for currency, entry := range currencies {
+		entry.AverageLifetime = lifetimes[currency] / time.Duration(entry.Coins)
+		stats.Currencies = append(stats.Currencies, *entry)
+	}
+	sort.Slice(stats.Currencies, func(i, j int) bool {
+		return stats.Currencies[i].Currency < stats.Currencies[j].Currency
+	})
+
+	return stats, nil
+}
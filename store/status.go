@@ -0,0 +1,349 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"log"
+	"time"
+	"ziba/core"
+)
+
+//
+// OPERATION STATUS
+//
+
+// 1. A wallet sends each withdrawal along with an idempotency token, and keeps the coin request as a
+//		PendingWithdrawal until the coin is finished. A withdrawal cut short is resumed with the same token and request.
+// 2. The bank keeps the outcome of the withdrawals by token: held for review (Status_Pending), refused by its fraud
+//		rules (Status_Refused) or issued (Status_Issued), along with the coin response. An issued withdrawal is
+//		answered again instead of being debited twice.
+// 3. The balance is debited along with the issued status, a withdrawal unknown to the bank wasn't debited. The balance
+//		is read and debited at once, concurrent withdrawals of a wallet don't debit the same balance.
+// 4. Payments are kept as PaymentSessions by idempotency token, on both sides, at each step of their state machine. Once
+//		signed, the payer's coin is out of the wallet until the merchant accepts it (before, it's only reserved). The
+//		merchant keeps its sessions for paymentSessionRetention, to answer resumed payments again.
+
+// createWithdrawalStatusTable creates the WithdrawalStatus table of a bank's database using tx.
+func createWithdrawalStatusTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS WithdrawalStatus (
+	-- keys
+	token  TEXT PRIMARY KEY ON CONFLICT REPLACE,
+	client INTEGER NOT NULL, -- ClientProfile hash
+
+	-- WithdrawalStatus
+	status 		 INTEGER NOT NULL, -- Status_Type
+	currency 	 TEXT NOT NULL,
+	value 		 INTEGER NOT NULL,
+	alert 		 INTEGER NOT NULL,
+	expiration BLOB NOT NULL, -- time.Time (binary, as digested)
+	validity 	 INTEGER NOT NULL,
+	A1 				 BLOB NOT NULL,
+	C1 				 BLOB NOT NULL,
+
+	date DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// paymentSessionRetention is how long merchants keep their payment sessions. (See DeleteExpiredPaymentSessions)
+const paymentSessionRetention = 7 * 24 * time.Hour
+
+// createPaymentSessionTable creates the PaymentSession table of a wallet's database using tx.
+func createPaymentSessionTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS PaymentSession (
+	-- keys
+	token  TEXT NOT NULL,
+	payee  INTEGER NOT NULL, -- bool
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- PaymentSession
+	peer  TEXT NOT NULL,
+	coin  INTEGER NOT NULL, -- CoinProfile hash
+	state INTEGER NOT NULL, -- State_Type
+	data  BLOB NOT NULL,
+	date  DATETIME NOT NULL,
+
+	PRIMARY KEY (token, payee) ON CONFLICT REPLACE
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// createPendingWithdrawalTable creates the PendingWithdrawal table of a wallet's database using tx.
+func createPendingWithdrawalTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS PendingWithdrawal (
+	-- keys
+	token  TEXT PRIMARY KEY,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- PendingWithdrawal
+	currency TEXT NOT NULL,
+	value 	 INTEGER NOT NULL,
+	coin 		 TEXT NOT NULL, -- Coin (binary, base64)
+	date 		 DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// WriteWithdrawalStatus records status, dated now, replacing the one of its token. (Not for Status_Issued, see
+// IssueWithdrawal)
+func (store *BankStore) WriteWithdrawalStatus(status *WithdrawalStatus) error {
+	return retryBusy(func() error {
+		return store.writeWithdrawalStatus(status)
+	})
+}
+
+// writeWithdrawalStatus is WriteWithdrawalStatus, run once.
+func (store *BankStore) writeWithdrawalStatus(status *WithdrawalStatus) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertWithdrawalStatus(tx, status); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IssueWithdrawal debits value from the balance in currency of client, for the withdrawal of status, and records status
+// as Status_Issued. Either both are written or none is. Returns ErrInsufficientFunds if the balance is lower than value.
+func (store *BankStore) IssueWithdrawal(client *core.ClientProfile, currency string, value int64, status *WithdrawalStatus) error {
+	return retryBusy(func() error {
+		return store.issueWithdrawal(client, currency, value, status)
+	})
+}
+
+// issueWithdrawal is IssueWithdrawal, run once.
+func (store *BankStore) issueWithdrawal(client *core.ClientProfile, currency string, value int64, status *WithdrawalStatus) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.debit(tx, client, currency, value); err != nil {
+		return err
+	}
+	status.Status = Status_Issued
+	if err := insertWithdrawalStatus(tx, status); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertWithdrawalStatus inserts status, dated now, using tx.
+func insertWithdrawalStatus(tx *sql.Tx, status *WithdrawalStatus) error {
+	expiration, err := status.Expiration.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	status.Date = time.Now().UTC()
+
+	stmt := `INSERT INTO
+	WithdrawalStatus (token, client, status, currency, value, alert, expiration, validity, A1, C1, date)
+	VALUES 					 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(stmt,
+		status.Token,
+		status.Client,
+		status.Status,
+		core.NormalizeCurrency(status.Currency),
+		status.Value,
+		status.Alert,
+		expiration,
+		int64(status.Validity),
+		toBlob(status.A1),
+		toBlob(status.C1),
+		status.Date,
+	)
+	return err
+}
+
+// ReadWithdrawalStatus returns the status of the withdrawal of client under token, Status_Unknown if the bank
+// has none.
+func (store *BankStore) ReadWithdrawalStatus(client *core.ClientProfile, token string) (*WithdrawalStatus, error) {
+	status := &WithdrawalStatus{Token: token, Client: client.Hash()}
+	var (
+		expiration []byte
+		validity   int64
+		A1, C1     string
+	)
+	stmt := `SELECT status, currency, value, alert, expiration, validity, A1, C1, date FROM WithdrawalStatus
+	WHERE token = ? AND client = ?`
+	err := store.db.QueryRow(stmt, token, client.Hash()).Scan(&status.Status, &status.Currency, &status.Value,
+		&status.Alert, &expiration, &validity, &A1, &C1, &status.Date)
+	if err == sql.ErrNoRows {
+		return status, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := status.Expiration.UnmarshalBinary(expiration); err != nil {
+		return nil, err
+	}
+	status.Validity = time.Duration(validity)
+	status.A1 = fromBlob(A1)
+	status.C1 = fromBlob(C1)
+	return status, nil
+}
+
+// WritePendingWithdrawal keeps withdrawal, dated now, until its outcome is known.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WritePendingWithdrawal(withdrawal *PendingWithdrawal) error {
+	data, err := withdrawal.Coin.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	withdrawal.Date = time.Now()
+
+	stmt := `INSERT INTO PendingWithdrawal (token, client, currency, value, coin, date) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = store.db.Exec(stmt,
+		withdrawal.Token,
+		store.clientId,
+		core.NormalizeCurrency(withdrawal.Coin.Params.Currency),
+		core.NormalizeValue(withdrawal.Coin.Params.Value),
+		base64.StdEncoding.EncodeToString(data),
+		withdrawal.Date.UTC(),
+	)
+	return err
+}
+
+// ReadPendingWithdrawal returns the oldest pending withdrawal of value in currency, or nil if there is none.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadPendingWithdrawal(currency string, value int64) (*PendingWithdrawal, error) {
+	stmt := `SELECT token, coin, date FROM PendingWithdrawal WHERE client = ? AND currency = ? AND value = ?
+	ORDER BY date LIMIT 1`
+	rows, err := store.db.Query(stmt, store.clientId, core.NormalizeCurrency(currency), core.NormalizeValue(value))
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := scanPendingWithdrawals(rows)
+	if err != nil || len(withdrawals) == 0 {
+		return nil, err
+	}
+	return &withdrawals[0], nil
+}
+
+// ReadPendingWithdrawals returns the pending withdrawals, oldest first.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadPendingWithdrawals() ([]PendingWithdrawal, error) {
+	rows, err := store.db.Query(`SELECT token, coin, date FROM PendingWithdrawal WHERE client = ? ORDER BY date`, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	return scanPendingWithdrawals(rows)
+}
+
+// scanPendingWithdrawals returns the pending withdrawals of rows, closing them.
+func scanPendingWithdrawals(rows *sql.Rows) ([]PendingWithdrawal, error) {
+	defer rows.Close()
+
+	var withdrawals []PendingWithdrawal
+	for rows.Next() {
+		var (
+			withdrawal PendingWithdrawal
+			encoded    string
+		)
+		if err := rows.Scan(&withdrawal.Token, &encoded, &withdrawal.Date); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := withdrawal.Coin.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	return withdrawals, rows.Err()
+}
+
+// DeletePendingWithdrawal deletes the pending withdrawal of token, once its outcome is known.
+func (store *ClientStore) DeletePendingWithdrawal(token string) error {
+	_, err := store.db.Exec(`DELETE FROM PendingWithdrawal WHERE token = ?`, token)
+	return err
+}
+
+// WritePaymentSession records session, dated now, replacing the one of its token and side.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WritePaymentSession(session *PaymentSession) error {
+	session.Date = time.Now()
+
+	stmt := `INSERT INTO PaymentSession (token, payee, client, peer, coin, state, data, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := store.db.Exec(stmt,
+		session.Token,
+		session.Payee,
+		store.clientId,
+		session.Peer,
+		session.Coin,
+		session.State,
+		session.Data,
+		session.Date.UTC(),
+	)
+	return err
+}
+
+// ReadPaymentSession returns the payment session of token on the side of payee, or nil if there is none.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadPaymentSession(token string, payee bool) (*PaymentSession, error) {
+	stmt := `SELECT token, payee, peer, coin, state, data, date FROM PaymentSession
+	WHERE client = ? AND token = ? AND payee = ?`
+	rows, err := store.db.Query(stmt, store.clientId, token, payee)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := scanPaymentSessions(rows)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+// ReadPaymentSessions returns the payment sessions on the side of payee, oldest first.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadPaymentSessions(payee bool) ([]PaymentSession, error) {
+	stmt := `SELECT token, payee, peer, coin, state, data, date FROM PaymentSession
+	WHERE client = ? AND payee = ? ORDER BY date`
+	rows, err := store.db.Query(stmt, store.clientId, payee)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentSessions(rows)
+}
+
+// scanPaymentSessions returns the payment sessions of rows, closing them.
+func scanPaymentSessions(rows *sql.Rows) ([]PaymentSession, error) {
+	defer rows.Close()
+
+	var sessions []PaymentSession
+	for rows.Next() {
+		var session PaymentSession
+		err := rows.Scan(&session.Token, &session.Payee, &session.Peer, &session.Coin, &session.State, &session.Data,
+			&session.Date)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeletePaymentSession deletes the payment session of token on the side of payee, once it ended.
+func (store *ClientStore) DeletePaymentSession(token string, payee bool) error {
+	_, err := store.db.Exec(`DELETE FROM PaymentSession WHERE token = ? AND payee = ?`, token, payee)
+	return err
+}
+
+// DeleteExpiredPaymentSessions deletes the merchant's payment sessions older than paymentSessionRetention.
+func (store *ClientStore) DeleteExpiredPaymentSessions() error {
+	stmt := `DELETE FROM PaymentSession WHERE payee = ? AND date < ?`
+	_, err := store.db.Exec(stmt, true, time.Now().Add(-paymentSessionRetention).UTC())
+	return err
+}
@@ -1,9 +1,21 @@
 package store_test
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/pem"
+	"errors"
 	"log"
+	"math/big"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 	"ziba/core"
 	"ziba/store"
 )
@@ -26,23 +38,23 @@ func TestMain(m *testing.M) {
 	// SETUP
 
 	// Create bank.
-	bank = new(core.Bank).New(scheme)
+	bank = new(core.Bank).New(nil, scheme)
 	bankProfile := bank.Profile()
 
 	// ACCGEN
 
 	// Create client.
-	client = new(core.Client).New(bankProfile)
+	client = new(core.Client).New(nil, bankProfile)
 	clientProfile := client.Profile()
 
 	// Create client account.
-	clientInfo, _ = bank.NewClient(clientProfile)
+	clientInfo, _ = bank.NewClient(nil, clientProfile)
 	client.SetCredentials(clientInfo.Credential, clientInfo.Contract)
 
 	// WITHDRAWAL
 
 	// Create coin request.
-	coin = client.NewCoinRequest()
+	coin = client.NewCoinRequest(nil)
 
 	// Create coin response.
 	Expiration, A1, C1 := bank.NewCoinResponse(clientInfo, coin.Params.ALower, coin.Params.C)
@@ -61,7 +73,7 @@ const (
 
 func TestBankStore(t *testing.T) {
 	// Grab database path.
-	dbPath := filepath.Join(zibaDir, "bank.db")
+	dbPath := store.DatabasePath(zibaDir, "bank")
 
 	// New.
 	bankStore, err := new(store.BankStore).New(dbPath, identity)
@@ -97,6 +109,12 @@ func TestBankStore(t *testing.T) {
 	}
 	t.Log(clientInfo)
 
+	// UpdateClientInfo.
+	err = bankStore.UpdateClientInfo(clientInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// WriteCoinProfile.
 	err = bankStore.WriteCoinProfile(coin.Profile(), store.Operation_Deposit, &clientInfo.Profile)
 	if err != nil {
@@ -104,18 +122,27 @@ func TestBankStore(t *testing.T) {
 	}
 	t.Log(coin.Profile())
 
-	// ReadCoinProfile.
-	err = bankStore.ReadCoinProfile(coin.Profile())
-	if err == store.ErrExistingCoin {
-		t.Log("coin already exists")
-	} else if err != nil {
+	// CoinExists.
+	exists, err := bankStore.CoinExists(coin.Profile())
+	if err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Fatal("written coin doesn't exist")
+	}
+
+	// ReadCoinRecord.
+	record, err := bankStore.ReadCoinRecord(coin.Profile())
+	if err != nil {
 		t.Fatal(err)
 	}
+	if record.Hash != coin.Profile().Hash() || record.Operation != store.Operation_Deposit || record.Client != clientInfo.Profile.Hash() {
+		t.Fatalf("unexpected coin record: %+v", record)
+	}
 }
 
 func TestClientStore(t *testing.T) {
 	// Grab database path.
-	dbPath := filepath.Join(zibaDir, "client.db")
+	dbPath := store.DatabasePath(zibaDir, "client")
 
 	// New.
 	clientStore, err := new(store.ClientStore).New(dbPath)
@@ -137,11 +164,51 @@ func TestClientStore(t *testing.T) {
 	}
 	t.Log(client)
 
+	// WritePartialCoins.
+	err = clientStore.WritePartialCoins([]*core.Coin{client.NewPartialCoin(nil), client.NewPartialCoin(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CountPartialCoins.
+	count, err := clientStore.CountPartialCoins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 2 {
+		t.Fatalf("unexpected pool size: %d", count)
+	}
+
+	// TakePartialCoin.
+	partial, err := clientStore.TakePartialCoin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial == nil {
+		t.Fatal("empty pool")
+	}
+	client.CompleteCoinRequest(partial)
+	if left, _ := clientStore.CountPartialCoins(); left != count-1 {
+		t.Fatalf("unexpected pool size: %d", left)
+	}
+
+	// UpdateCredentials. (Clears the pool)
+	err = clientStore.UpdateCredentials(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := clientStore.CountPartialCoins(); left != 0 {
+		t.Fatalf("unexpected pool size: %d", left)
+	}
+
 	// WriteCoin.
 	err = clientStore.WriteCoin(coin, store.Operation_Withdrawal)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != store.ErrExistingCoin {
+		t.Fatalf("expected ErrExistingCoin, got %v", err)
+	}
 
 	// ReadCoins.
 	coins, err := clientStore.ReadCoins()
@@ -156,7 +223,7 @@ func TestClientStore(t *testing.T) {
 
 func TestStoreCoins(t *testing.T) {
 	directory, _ := store.GetZibaDir()
-	dbPath := filepath.Join(directory, "agus.db")
+	dbPath := store.DatabasePath(directory, "agus")
 	store, _ := new(store.ClientStore).New(dbPath)
 	store.BankName = "bancoco"
 	client, _ := store.ReadClient()
@@ -166,3 +233,2697 @@ func TestStoreCoins(t *testing.T) {
 		log.Printf("%v", valid)
 	}
 }
+
+func TestIdentity(t *testing.T) {
+	const passphrase = "correct horse battery staple"
+
+	// ExportIdentity.
+	bundle, err := store.ExportIdentity("client", passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// OpenIdentity.
+	identity, err := store.OpenIdentity(bundle, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.User != "client" || len(identity.Files) == 0 || identity.Files[0].Name != "client.db" {
+		t.Fatalf("unexpected identity contents: %s (%d files)", identity.User, len(identity.Files))
+	}
+
+	// Wrong passphrase.
+	_, err = store.OpenIdentity(bundle, "wrong")
+	if err != store.ErrPassphrase {
+		t.Fatalf("expected ErrPassphrase, got %v", err)
+	}
+
+	// Tampered bundle.
+	tampered := append([]byte(nil), bundle...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = store.OpenIdentity(tampered, passphrase)
+	if err != store.ErrPassphrase {
+		t.Fatalf("expected ErrPassphrase, got %v", err)
+	}
+
+	// ImportIdentity. (Existing users are not overwritten)
+	_, err = store.ImportIdentity(bundle, passphrase)
+	if err != store.ErrExistingIdentity {
+		t.Fatalf("expected ErrExistingIdentity, got %v", err)
+	}
+}
+
+func TestSealedBank(t *testing.T) {
+	// Grab database path.
+	dbPath := store.DatabasePath(zibaDir, "bank")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity+"-withdrawal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bankStore.DeleteBank()
+
+	// WriteSealedBank.
+	err = bankStore.WriteSealedBank(bank.WithdrawalCustody(), bankName, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadBank. (Locked)
+	_, err = bankStore.ReadBank()
+	if err != store.ErrLockedBank {
+		t.Fatalf("expected ErrLockedBank, got %v", err)
+	}
+
+	// Unlock.
+	err = bankStore.Unlock("wrong")
+	if err != store.ErrPassphrase {
+		t.Fatalf("expected ErrPassphrase, got %v", err)
+	}
+	err = bankStore.Unlock("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadBank. (Unlocked)
+	custody, err := bankStore.ReadBank()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if custody.Priv.Cmp(bank.Priv) != 0 || custody.Key.D.Cmp(bank.Key.D) != 0 || custody.Key.P != nil {
+		t.Fatal("unexpected withdrawal custody")
+	}
+}
+
+func TestMint(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadMint. (Unknown)
+	if _, err := bankStore.ReadMint("EUR"); err != store.ErrUnknownCurrency {
+		t.Fatalf("expected ErrUnknownCurrency, got %v", err)
+	}
+
+	// WriteMint.
+	mint, err := bank.NewMint(nil, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteMint(mint); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadMint.
+	read, err := bankStore.ReadMint("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Key.D.Cmp(mint.Key.D) != 0 || read.Priv.Cmp(bank.Priv) != 0 || read.Currency != "EUR" {
+		t.Fatal("unexpected mint")
+	}
+
+	// ReadMints.
+	profiles, err := bankStore.ReadMints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[0].Currency != core.DefaultCurrency || profiles[1].Currency != "EUR" {
+		t.Fatalf("unexpected mints: %v", profiles)
+	}
+
+	// ReadClientBalance & UpdateClientBalance.
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.UpdateClientBalance(&clientInfo.Profile, "EUR", 7); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := bankStore.ReadClientBalance(&clientInfo.Profile, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 7 {
+		t.Fatalf("expected 7, got %d", balance)
+	}
+
+	// ReadRate & WriteRate.
+	if _, err := bankStore.ReadRate("EUR", core.DefaultCurrency); err != store.ErrUnknownRate {
+		t.Fatalf("expected ErrUnknownRate, got %v", err)
+	}
+	rate, err := core.NewRate("EUR", core.DefaultCurrency, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteRate(rate); err != nil {
+		t.Fatal(err)
+	}
+	rate.Num = 5
+	if err := bankStore.WriteRate(rate); err != nil {
+		t.Fatal(err)
+	}
+	readRate, err := bankStore.ReadRate("EUR", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *readRate != *rate {
+		t.Fatalf("unexpected rate: %v", readRate)
+	}
+
+	// WriteCoinProfiles. (Either every coin is written or none is)
+	coinProfile := coin.Profile()
+	other := *coinProfile
+	other.R = big.NewInt(7)
+	if err := bankStore.WriteCoinProfile(coinProfile, store.Operation_Deposit, &clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+	coins := []*core.CoinProfile{&other, coinProfile}
+	if err := bankStore.WriteCoinProfiles(coins, store.Operation_Exchange, &clientInfo.Profile); err != store.ErrExistingCoin {
+		t.Fatalf("expected ErrExistingCoin, got %v", err)
+	}
+	if exists, err := bankStore.CoinExists(&other); err != nil || exists {
+		t.Fatalf("expected no coin, got %v, %v", exists, err)
+	}
+	if _, err := bankStore.ReadCoinRecord(&other); err != store.ErrUnspentCoin {
+		t.Fatalf("expected ErrUnspentCoin, got %v", err)
+	}
+}
+
+// benchmarkCoins is the number of coins written by the store benchmarks.
+const benchmarkCoins = 10000
+
+// newBenchmarkStore returns a new client store in a temporary directory, holding client's account.
+func newBenchmarkStore(b *testing.B) *store.ClientStore {
+	b.Helper()
+	clientStore, err := new(store.ClientStore).New(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		b.Fatal(err)
+	}
+	return clientStore
+}
+
+// newBenchmarkCoins returns n distinct copies of coin. (Only A2 changes, coins aren't valid)
+func newBenchmarkCoins(n int) []core.Coin {
+	coins := make([]core.Coin, n)
+	for i := range coins {
+		coins[i] = *coin
+		coins[i].Params.A2 = new(big.Int).Add(coin.Params.A2, big.NewInt(int64(i)))
+	}
+	return coins
+}
+
+func BenchmarkWriteCoin(b *testing.B) {
+	coins := newBenchmarkCoins(benchmarkCoins)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		clientStore := newBenchmarkStore(b)
+		b.StartTimer()
+		for j := range coins {
+			if err := clientStore.WriteCoin(&coins[j], store.Operation_Withdrawal); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkReadCoins(b *testing.B) {
+	clientStore := newBenchmarkStore(b)
+	coins := newBenchmarkCoins(benchmarkCoins)
+	for j := range coins {
+		if err := clientStore.WriteCoin(&coins[j], store.Operation_Withdrawal); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := clientStore.ReadCoins(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkNumbers returns the numbers stored for a bank, of every size written into the database.
+func benchmarkNumbers() []*big.Int {
+	return []*big.Int{bank.Priv, bank.Pub, bank.Key.N, bank.Key.D, bank.Key.E, bank.Scheme.P}
+}
+
+func BenchmarkToBlob(b *testing.B) {
+	numbers := benchmarkNumbers()
+	for i := 0; i < b.N; i++ {
+		for _, z := range numbers {
+			store.ToBlob(z)
+		}
+	}
+}
+
+func BenchmarkFromBlob(b *testing.B) {
+	var blobs []string
+	for _, z := range benchmarkNumbers() {
+		blobs = append(blobs, string(store.ToBlob(z)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, blob := range blobs {
+			store.FromBlob(blob)
+		}
+	}
+}
+
+// BenchmarkToString is the baseline of BenchmarkToBlob, numbers stored as decimal TEXT.
+func BenchmarkToString(b *testing.B) {
+	numbers := benchmarkNumbers()
+	for i := 0; i < b.N; i++ {
+		for _, z := range numbers {
+			store.ToString(z)
+		}
+	}
+}
+
+// BenchmarkFromString is the baseline of BenchmarkFromBlob, numbers stored as decimal TEXT.
+func BenchmarkFromString(b *testing.B) {
+	var texts []string
+	for _, z := range benchmarkNumbers() {
+		texts = append(texts, store.ToString(z))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, text := range texts {
+			store.FromString(text)
+		}
+	}
+}
+
+func TestChange(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadChange. (Unknown)
+	remainder := client.NewCoinRequest(nil).SetValue(2)
+	if _, err := bankStore.ReadChange(remainder.Params.ALower, remainder.Params.C); err != store.ErrUnknownChange {
+		t.Fatalf("expected ErrUnknownChange, got %v", err)
+	}
+
+	// WriteChange.
+	change, claim := core.NewChange(nil, 3, remainder)
+	pending := &store.PendingChange{Change: *change, Value: 2, Currency: core.DefaultCurrency}
+	if err := bankStore.WriteChange(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadChange.
+	read, err := bankStore.ReadChange(remainder.Params.ALower, remainder.Params.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Value != 2 || read.Change.Amount != 3 || read.Change.VerifyClaim(claim) != nil {
+		t.Fatal("unexpected change")
+	}
+
+	// CollectChange. (Once only)
+	if err := bankStore.CollectChange(read); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.CollectChange(read); err != store.ErrUnknownChange {
+		t.Fatalf("expected ErrUnknownChange, got %v", err)
+	}
+	if _, err := bankStore.ReadChange(remainder.Params.ALower, remainder.Params.C); err != store.ErrUnknownChange {
+		t.Fatalf("expected ErrUnknownChange, got %v", err)
+	}
+}
+
+func TestRevocations(t *testing.T) {
+	// Grab database paths.
+	bankPath := filepath.Join(t.TempDir(), "bank.db")
+	clientPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(bankPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+	clientStore, err := new(store.ClientStore).New(clientPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadRevocations. (None fetched)
+	if list, err := clientStore.ReadRevocations(); err != nil || list != nil {
+		t.Fatalf("expected no revocation list, got %v, %v", list, err)
+	}
+
+	// RevokeCoin and FreezeAccount. (Repeated revocations are ignored)
+	coinHash := coin.Profile().Hash()
+	accountHash := client.Profile().Hash()
+	for i := 0; i < 2; i++ {
+		if err := bankStore.RevokeCoin(coinHash, "stolen"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bankStore.FreezeAccount(accountHash, "court order"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadRevocations.
+	list, err := bankStore.ReadRevocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Coins) != 1 || len(list.Accounts) != 1 {
+		t.Fatalf("unexpected revocation list: %v", list)
+	}
+
+	// WriteRevocations and ReadRevocations. (Signature survives the round trip)
+	signed := bank.SignRevocations(nil, list)
+	if err := clientStore.WriteRevocations(signed); err != nil {
+		t.Fatal(err)
+	}
+	read, err := clientStore.ReadRevocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid := read.Verify(bank.Profile()); !valid {
+		t.Fatal("invalid revocation list signature after round trip")
+	}
+	if !read.Revoked(coin.Profile()) || !read.Frozen(client.Profile()) {
+		t.Fatal("coin or account not revoked")
+	}
+}
+
+func TestReport(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+
+	// FundAccount. (Unknown)
+	hash := clientInfo.Profile.Hash()
+	if _, err := bankStore.FundAccount(hash, "EUR", 5); err != store.ErrUnknownClient {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+
+	// FundAccount.
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := bankStore.FundAccount(hash, "EUR", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 105 {
+		t.Fatalf("expected 105, got %d", balance)
+	}
+	if balance, err = bankStore.FundAccount(hash, core.DefaultCurrency, -10); err != nil {
+		t.Fatal(err)
+	}
+	if balance != 90 {
+		t.Fatalf("expected 90, got %d", balance)
+	}
+
+	// Report.
+	if err := bankStore.WriteCoinProfile(coin.Profile(), store.Operation_Deposit, &clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.FreezeAccount(hash, ""); err != nil {
+		t.Fatal(err)
+	}
+	report, err := bankStore.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Clients != 1 || report.Balances[core.DefaultCurrency] != 90 || report.Balances["EUR"] != 105 {
+		t.Fatalf("unexpected balances: %v", report)
+	}
+	if report.Coins[store.Operation_Deposit] != 1 || report.Frozen != 1 || report.Revoked != 0 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+}
+
+func TestBankStats(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Issue two coins, deposit one of them twice.
+	profile, currency := coin.Profile(), core.NormalizeCurrency(coin.Params.Currency)
+	value := core.NormalizeValue(coin.Params.Value)
+	if err := bankStore.WriteIssuance(currency, 2, 2*value); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteDoubleSpend([]*core.CoinProfile{profile}, store.Operation_Deposit, &clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteCoinProfile(profile, store.Operation_Deposit, &clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteCoinProfile(profile, store.Operation_Deposit, &clientInfo.Profile); err != store.ErrExistingCoin {
+		t.Fatalf("expected ErrExistingCoin, got %v", err)
+	}
+	if err := bankStore.WriteDoubleSpend([]*core.CoinProfile{profile}, store.Operation_Deposit, &clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stats. (Only the second attempt was a double spend)
+	stats, err := bankStore.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Clients != 1 || stats.Outstanding[currency] != value {
+		t.Fatalf("unexpected outstanding coins: %v", stats)
+	}
+	if stats.DepositsToday != 1 || stats.DepositedToday[currency] != value || stats.DoubleSpends != 1 {
+		t.Fatalf("unexpected deposits: %v", stats)
+	}
+}
+
+func TestGraceExchange(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exchange an expired coin.
+	profile := coin.Profile()
+	if err := bankStore.WriteGraceExchange([]*core.CoinProfile{profile}, &clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stats.
+	stats, err := bankStore.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.GraceExchanges != 1 {
+		t.Fatalf("expected 1 grace exchange, got %d", stats.GraceExchanges)
+	}
+
+	// Inspect.
+	inspection, err := bankStore.Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspection.GraceExchanges) != 1 {
+		t.Fatalf("expected 1 grace exchange, got %d", len(inspection.GraceExchanges))
+	}
+	row := inspection.GraceExchanges[0]
+	if uint32(row.CoinHash) != profile.Hash() || uint32(row.ClientHash) != clientInfo.Profile.Hash() || row.Value != core.NormalizeValue(profile.Value) {
+		t.Fatalf("unexpected grace exchange: %+v", row)
+	}
+	if !row.Expiration.Equal(profile.Expiration) {
+		t.Fatalf("expected expiration %s, got %s", profile.Expiration, row.Expiration)
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// Issue a coin of a bank of its own. (The bank of the other tests is read back from TestBankStore's database)
+	issuer := new(core.Bank).New(nil, core.Params)
+	holder := new(core.Client).New(nil, issuer.Profile())
+	holderInfo, _ := issuer.NewClient(nil, holder.Profile())
+	holder.SetCredentials(holderInfo.Credential, holderInfo.Contract)
+	issued := holder.NewCoinRequest(nil)
+	Expiration, A1, C1 := issuer.NewCoinResponse(holderInfo, issued.Params.ALower, issued.Params.C)
+	holder.FinishCoin(issued, Expiration, A1, C1)
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(issuer, bankName); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteClientInfo(holderInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change balances, deposit the coin.
+	hash := holderInfo.Profile.Hash()
+	if _, err := bankStore.FundAccount(hash, "EUR", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.UpdateClientBalance(&holderInfo.Profile, core.DefaultCurrency, 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bankStore.FundAccount(hash, core.DefaultCurrency, -2); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteCoinProfile(issued.Profile(), store.Operation_Deposit, &holderInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+
+	// VerifyIntegrity.
+	report, err := bankStore.VerifyIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Coins != 1 || report.Balances != 2 || len(report.Problems) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	// Tamper with a balance.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE ClientInfo SET balance = balance + 7`); err != nil {
+		t.Fatal(err)
+	}
+
+	// VerifyIntegrity. (Inconsistent)
+	if report, err = bankStore.VerifyIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "audit log ends at 40") {
+		t.Fatalf("unexpected problems: %v", report.Problems)
+	}
+}
+
+func TestDisputes(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// Issue a coin of a bank of its own, and pay two merchants with it.
+	issuer := new(core.Bank).New(nil, core.Params)
+	issuerProfile := issuer.Profile()
+	holder := new(core.Client).New(nil, issuerProfile)
+	holderInfo, _ := issuer.NewClient(nil, holder.Profile())
+	holder.SetCredentials(holderInfo.Credential, holderInfo.Contract)
+	issued := holder.NewCoinRequest(nil)
+	Expiration, A1, C1 := issuer.NewCoinResponse(holderInfo, issued.Params.ALower, issued.Params.C)
+	holder.FinishCoin(issued, Expiration, A1, C1)
+	spent, attempt := issued.Profile(), issued.Profile()
+	spent.Second = holder.SignCoin(issued, spent.Stamp(issuerProfile, client.Profile()))
+	attempt.Second = holder.SignCoin(issued, attempt.Stamp(issuerProfile, holder.Profile()))
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadSpentCoin. (Unknown)
+	if _, err := bankStore.ReadSpentCoin(spent); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	// ReadSpentCoin.
+	if err := bankStore.WriteCoinProfile(spent, store.Operation_Deposit, &holderInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+	read, err := bankStore.ReadSpentCoin(attempt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Msg.Cmp(spent.Msg) != 0 || read.Second.Cmp(spent.Second) != 0 {
+		t.Fatal("unexpected spent coin")
+	}
+
+	// ReadDispute. (Unknown)
+	if _, err := bankStore.ReadDispute(1); err != store.ErrUnknownDispute {
+		t.Fatalf("expected ErrUnknownDispute, got %v", err)
+	}
+
+	// WriteDispute.
+	id, err := bankStore.WriteDispute(core.NewDoubleSpend(issuerProfile, read, attempt), store.Operation_Deposit, &holderInfo.Profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadDispute. (Verified as stored)
+	record, err := bankStore.ReadDispute(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Operation != store.Operation_Deposit || record.Client != holderInfo.Profile.Hash() {
+		t.Fatalf("unexpected dispute: %+v", record)
+	}
+	if err := record.Dispute.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadDisputes.
+	records, err := bankStore.ReadDisputes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != id {
+		t.Fatalf("unexpected disputes: %+v", records)
+	}
+}
+
+func TestFraudAlerts(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadAccountOpened. (Unknown)
+	if _, err := bankStore.ReadAccountOpened(client.Profile()); err != store.ErrUnknownClient {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+
+	// ReadAccountOpened.
+	clientInfo, _ := bank.NewClient(nil, client.Profile())
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := bankStore.ReadAccountOpened(client.Profile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(opened) > time.Minute {
+		t.Fatalf("unexpected opening date: %v", opened)
+	}
+
+	// WriteFraudAlert.
+	hash := client.Profile().Hash()
+	flagged := &store.FraudAlert{Rule: "amount(>100)", Status: store.Alert_Flagged, Operation: store.Operation_Deposit,
+		Client: hash, Currency: core.DefaultCurrency, Value: 500}
+	if _, err := bankStore.WriteFraudAlert(flagged); err != nil {
+		t.Fatal(err)
+	}
+
+	// RequestApproval. (Held until approved)
+	alert := func() *store.FraudAlert {
+		return &store.FraudAlert{Rule: "velocity(3/1h0m0s)", Operation: store.Operation_Withdrawal, Client: hash,
+			Currency: core.DefaultCurrency, Value: 8}
+	}
+	for i := 0; i < 2; i++ {
+		status, err := bankStore.RequestApproval(alert())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != store.Alert_Pending {
+			t.Fatalf("expected Pending, got %s", status)
+		}
+	}
+	pending, err := bankStore.ReadFraudAlerts(store.Alert_Pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Client != hash || pending[0].Value != 8 {
+		t.Fatalf("unexpected pending alerts: %+v", pending)
+	}
+
+	// UpdateFraudAlert.
+	if err := bankStore.UpdateFraudAlert(flagged.ID, store.Alert_Approved); err != store.ErrUnknownAlert {
+		t.Fatalf("expected ErrUnknownAlert, got %v", err)
+	}
+	if err := bankStore.UpdateFraudAlert(pending[0].ID, store.Alert_Approved); err != nil {
+		t.Fatal(err)
+	}
+
+	// RequestApproval. (Released once, then held again)
+	for _, expected := range []store.Alert_Type{store.Alert_Released, store.Alert_Pending} {
+		status, err := bankStore.RequestApproval(alert())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != expected {
+			t.Fatalf("expected %s, got %s", expected, status)
+		}
+	}
+
+	// ReadFraudAlert.
+	released, err := bankStore.ReadFraudAlert(pending[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released.Status != store.Alert_Released || released.Rule != pending[0].Rule {
+		t.Fatalf("unexpected alert: %+v", released)
+	}
+	if _, err := bankStore.ReadFraudAlert(released.ID + 100); err != store.ErrUnknownAlert {
+		t.Fatalf("expected ErrUnknownAlert, got %v", err)
+	}
+
+	// UpdateFraudAlert. (Denied)
+	pending, err = bankStore.ReadFraudAlerts(store.Alert_Pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.UpdateFraudAlert(pending[0].ID, store.Alert_Denied); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := bankStore.RequestApproval(alert()); err != nil || status != store.Alert_Denied {
+		t.Fatalf("expected Denied, got %s (%v)", status, err)
+	}
+}
+
+func TestOperationCounts(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadOperationCount. (None)
+	hash := client.Profile().Hash()
+	hour := time.Now().Add(-time.Hour)
+	count, oldest, err := bankStore.ReadOperationCount(hash, store.Operation_Withdrawal, hour)
+	if err != nil || count != 0 || !oldest.IsZero() {
+		t.Fatalf("expected no operations, got %d since %v (%v)", count, oldest, err)
+	}
+
+	// WriteOperationCount.
+	start := time.Now()
+	for _, n := range []int{1, 2} {
+		if err := bankStore.WriteOperationCount(hash, store.Operation_Withdrawal, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bankStore.WriteOperationCount(hash, store.Operation_Deposit, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteOperationCount(hash+1, store.Operation_Withdrawal, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadOperationCount. (By client and operation, within the window)
+	count, oldest, err = bankStore.ReadOperationCount(hash, store.Operation_Withdrawal, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || oldest.Before(start.Add(-time.Second)) || oldest.After(time.Now()) {
+		t.Fatalf("unexpected withdrawals: %d since %v", count, oldest)
+	}
+	if count, _, err := bankStore.ReadOperationCount(hash, store.Operation_Withdrawal, time.Now().Add(time.Minute)); err != nil || count != 0 {
+		t.Fatalf("expected no withdrawals beyond the window, got %d (%v)", count, err)
+	}
+
+	// DeleteOperationCounts.
+	if n, err := bankStore.DeleteOperationCounts(hour); err != nil || n != 0 {
+		t.Fatalf("expected no deletion, got %d (%v)", n, err)
+	}
+	if n, err := bankStore.DeleteOperationCounts(time.Now().Add(time.Minute)); err != nil || n != 4 {
+		t.Fatalf("expected 4 deletions, got %d (%v)", n, err)
+	}
+	if count, _, err := bankStore.ReadOperationCount(hash, store.Operation_Deposit, hour); err != nil || count != 0 {
+		t.Fatalf("expected no deposits, got %d (%v)", count, err)
+	}
+}
+
+func TestAccountClosure(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// Issue a coin of a bank of its own.
+	issuer := new(core.Bank).New(nil, core.Params)
+	holder := new(core.Client).New(nil, issuer.Profile())
+	holderInfo, _ := issuer.NewClient(nil, holder.Profile())
+	holder.SetCredentials(holderInfo.Credential, holderInfo.Contract)
+	issued := holder.NewCoinRequest(nil)
+	Expiration, A1, C1 := issuer.NewCoinResponse(holderInfo, issued.Params.ALower, issued.Params.C)
+	holder.FinishCoin(issued, Expiration, A1, C1)
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(issuer, bankName); err != nil {
+		t.Fatal(err)
+	}
+
+	// CloseAccount. (Unknown)
+	coins := []*core.CoinProfile{issued.Profile()}
+	if _, err := bankStore.CloseAccount(&holderInfo.Profile, coins); err != store.ErrUnknownClient {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+
+	// ReadAccountClosed. (Open)
+	if err := bankStore.WriteClientInfo(holderInfo); err != nil {
+		t.Fatal(err)
+	}
+	if closed, err := bankStore.ReadAccountClosed(&holderInfo.Profile); err != nil || !closed.IsZero() {
+		t.Fatalf("expected an open account, got %v (%v)", closed, err)
+	}
+	if err := bankStore.UpdateClientBalance(&holderInfo.Profile, core.DefaultCurrency, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bankStore.FundAccount(holderInfo.Profile.Hash(), "EUR", -100); err != nil {
+		t.Fatal(err)
+	}
+
+	// CloseAccount. (The coin is credited before the payout, the zero balances aren't paid out)
+	closure, err := bankStore.CloseAccount(&holderInfo.Profile, coins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closure.Balances) != 1 || closure.Balances[core.DefaultCurrency] != 11 {
+		t.Fatalf("unexpected payout: %v", closure.Balances)
+	}
+	if balance, err := bankStore.ReadClientBalance(&holderInfo.Profile, core.DefaultCurrency); err != nil || balance != 0 {
+		t.Fatalf("expected no balance left, got %d (%v)", balance, err)
+	}
+	if record, err := bankStore.ReadCoinRecord(coins[0]); err != nil || record.Operation != store.Operation_Closure {
+		t.Fatalf("expected a surrendered coin, got %+v (%v)", record, err)
+	}
+
+	// CloseAccount. (Already closed)
+	if _, err := bankStore.CloseAccount(&holderInfo.Profile, nil); err != store.ErrClosedAccount {
+		t.Fatalf("expected ErrClosedAccount, got %v", err)
+	}
+
+	// ReadAccountClosed and ReadAccountClosures.
+	closed, err := bankStore.ReadAccountClosed(&holderInfo.Profile)
+	if err != nil || closed.IsZero() {
+		t.Fatalf("expected a closed account, got %v (%v)", closed, err)
+	}
+	closures, err := bankStore.ReadAccountClosures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closures) != 1 || closures[0].Client != holderInfo.Profile.Hash() || closures[0].Balances[core.DefaultCurrency] != 11 {
+		t.Fatalf("unexpected closures: %+v", closures)
+	}
+
+	// VerifyIntegrity. (The payout is in the audit log)
+	report, err := bankStore.VerifyIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Coins != 1 || len(report.Problems) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestTransferBalance(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bank := new(core.Bank).New(nil, core.Params)
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+	payerInfo, _ := bank.NewClient(nil, new(core.Client).New(nil, bank.Profile()).Profile())
+	payeeInfo, _ := bank.NewClient(nil, new(core.Client).New(nil, bank.Profile()).Profile())
+	if err := bankStore.WriteClientInfo(payerInfo); err != nil {
+		t.Fatal(err)
+	}
+	payer, payee := &payerInfo.Profile, payeeInfo.Profile.Hash()
+
+	// TransferBalance. (Unknown payee, nothing is debited)
+	if _, err := bankStore.TransferBalance(payer, payee, core.DefaultCurrency, 30); err != store.ErrUnknownClient {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+	if balance, err := bankStore.ReadClientBalance(payer, core.DefaultCurrency); err != nil || balance != 100 {
+		t.Fatalf("expected a balance of 100, got %d (%v)", balance, err)
+	}
+
+	// TransferBalance, in both currencies.
+	if err := bankStore.WriteClientInfo(payeeInfo); err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := bankStore.TransferBalance(payer, payee, core.DefaultCurrency, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Payer != payer.Hash() || transfer.Payee != payee || transfer.Value != 30 {
+		t.Fatalf("unexpected transfer: %+v", transfer)
+	}
+	if _, err := bankStore.TransferBalance(payer, payee, "EUR", 5); err != nil {
+		t.Fatal(err)
+	}
+	balances := []struct {
+		client   *core.ClientProfile
+		currency string
+		balance  int64
+	}{
+		{payer, core.DefaultCurrency, 70},
+		{&payeeInfo.Profile, core.DefaultCurrency, 130},
+		{payer, "EUR", 95},
+		{&payeeInfo.Profile, "EUR", 105},
+	}
+	for _, expected := range balances {
+		if balance, err := bankStore.ReadClientBalance(expected.client, expected.currency); err != nil || balance != expected.balance {
+			t.Fatalf("expected a balance of %d %s, got %d (%v)", expected.balance, expected.currency, balance, err)
+		}
+	}
+
+	// TransferBalance. (Insufficient funds)
+	if _, err := bankStore.TransferBalance(payer, payee, core.DefaultCurrency, 71); err != store.ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+
+	// ReadTransfers. (Either side)
+	for _, hash := range []uint32{payer.Hash(), payee} {
+		transfers, err := bankStore.ReadTransfers(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transfers) != 2 || transfers[0].ID != transfer.ID || transfers[1].Currency != "EUR" {
+			t.Fatalf("unexpected transfers: %+v", transfers)
+		}
+	}
+
+	// TransferBalance. (Closed payee)
+	if _, err := bankStore.CloseAccount(&payeeInfo.Profile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bankStore.TransferBalance(payer, payee, core.DefaultCurrency, 10); err != store.ErrClosedAccount {
+		t.Fatalf("expected ErrClosedAccount, got %v", err)
+	}
+
+	// VerifyIntegrity. (Both sides are in the audit log)
+	report, err := bankStore.VerifyIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestWithdrawalStatus(t *testing.T) {
+	// Grab database paths.
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientInfo, _ := bank.NewClient(nil, client.Profile())
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadWithdrawalStatus. (Unknown)
+	status, err := bankStore.ReadWithdrawalStatus(client.Profile(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != store.Status_Unknown {
+		t.Fatalf("expected Unknown, got %s", status.Status)
+	}
+
+	// WriteWithdrawalStatus.
+	status = &store.WithdrawalStatus{Token: "token", Client: client.Profile().Hash(), Status: store.Status_Pending,
+		Currency: core.DefaultCurrency, Value: 2, Alert: 7}
+	if err := bankStore.WriteWithdrawalStatus(status); err != nil {
+		t.Fatal(err)
+	}
+	read, err := bankStore.ReadWithdrawalStatus(client.Profile(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Status != store.Status_Pending || read.Alert != 7 || read.Value != 2 {
+		t.Fatalf("unexpected status: %+v", read)
+	}
+
+	// IssueWithdrawal. (The expiration is kept as digested, time zone included)
+	balance, err := bankStore.ReadClientBalance(client.Profile(), core.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status.Expiration = time.Now().In(time.FixedZone("", 3600)).Add(time.Hour)
+	status.Validity = time.Hour
+	status.A1, status.C1 = big.NewInt(3), big.NewInt(5)
+	if err := bankStore.IssueWithdrawal(client.Profile(), core.DefaultCurrency, 2, status); err != nil {
+		t.Fatal(err)
+	}
+	read, err = bankStore.ReadWithdrawalStatus(client.Profile(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := status.Expiration.MarshalBinary()
+	if actual, _ := read.Expiration.MarshalBinary(); !bytes.Equal(actual, expected) {
+		t.Fatalf("expected expiration %v, got %v", status.Expiration, read.Expiration)
+	}
+	if read.Status != store.Status_Issued || read.A1.Int64() != 3 || read.C1.Int64() != 5 || read.Validity != time.Hour {
+		t.Fatalf("unexpected status: %+v", read)
+	}
+	if debited, err := bankStore.ReadClientBalance(client.Profile(), core.DefaultCurrency); err != nil || debited != balance-2 {
+		t.Fatalf("expected balance %d, got %d (%v)", balance-2, debited, err)
+	}
+
+	// IssueWithdrawal. (Insufficient funds, nothing written)
+	status.Token = "overdraft"
+	if err := bankStore.IssueWithdrawal(client.Profile(), core.DefaultCurrency, balance, status); err != store.ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	if read, err := bankStore.ReadWithdrawalStatus(client.Profile(), "overdraft"); err != nil || read.Status != store.Status_Unknown {
+		t.Fatalf("expected Unknown, got %+v (%v)", read, err)
+	}
+
+	// DebitClientBalance. (Concurrently, every debit counts and none overdraws)
+	var (
+		wg       sync.WaitGroup
+		debits   atomic.Int64
+		debitErr atomic.Value
+	)
+	for range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := bankStore.DebitClientBalance(client.Profile(), core.DefaultCurrency, 10)
+			if err == nil {
+				debits.Add(1)
+			} else if err != store.ErrInsufficientFunds {
+				debitErr.Store(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err, _ := debitErr.Load().(error); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, err := bankStore.ReadClientBalance(client.Profile(), core.DefaultCurrency); err != nil || remaining != balance-2-10*debits.Load() || remaining < 0 {
+		t.Fatalf("unexpected balance %d after %d debits (%v)", remaining, debits.Load(), err)
+	}
+
+	// CreditClientBalance. (Concurrently with debits, every credit and debit counts)
+	remaining, err := bankStore.ReadClientBalance(client.Profile(), core.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debits.Store(0)
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := bankStore.CreditClientBalance(client.Profile(), core.DefaultCurrency, 5); err != nil {
+				debitErr.Store(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := bankStore.DebitClientBalance(client.Profile(), core.DefaultCurrency, 1)
+			if err == nil {
+				debits.Add(1)
+			} else if err != store.ErrInsufficientFunds {
+				debitErr.Store(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err, _ := debitErr.Load().(error); err != nil {
+		t.Fatal(err)
+	}
+	if credited, err := bankStore.ReadClientBalance(client.Profile(), core.DefaultCurrency); err != nil || credited != remaining+8*5-debits.Load() {
+		t.Fatalf("unexpected balance %d after 8 credits and %d debits (%v)", credited, debits.Load(), err)
+	}
+	if _, err := bankStore.CreditClientBalance(new(core.Client).New(nil, bank.Profile()).Profile(), core.DefaultCurrency, 5); err != store.ErrUnknownClient {
+		t.Fatalf("expected ErrUnknownClient, got %v", err)
+	}
+
+	// ReadWithdrawalStatus. (Another client's)
+	other := new(core.Client).New(nil, bank.Profile())
+	if read, err := bankStore.ReadWithdrawalStatus(other.Profile(), "token"); err != nil || read.Status != store.Status_Unknown {
+		t.Fatalf("expected Unknown, got %+v (%v)", read, err)
+	}
+
+	// WritePendingWithdrawal.
+	clientStore, err := new(store.ClientStore).New(filepath.Join(dir, "client.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WritePendingWithdrawal(&store.PendingWithdrawal{Token: "token", Coin: *coin}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadPendingWithdrawal.
+	if pending, err := clientStore.ReadPendingWithdrawal(coin.Params.Currency, coin.Params.Value+1); err != nil || pending != nil {
+		t.Fatalf("unexpected pending withdrawal: %v (%v)", pending, err)
+	}
+	pending, err := clientStore.ReadPendingWithdrawal(coin.Params.Currency, coin.Params.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending == nil || pending.Token != "token" || pending.Coin.Profile().Hash() != coin.Profile().Hash() {
+		t.Fatalf("unexpected pending withdrawal: %v", pending)
+	}
+
+	// DeletePendingWithdrawal.
+	if err := clientStore.DeletePendingWithdrawal("token"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := clientStore.ReadPendingWithdrawals(); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected pending withdrawals: %v (%v)", list, err)
+	}
+}
+
+func TestOperationLog(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WriteOperations.
+	now := time.Now().UTC()
+	runs := []store.OperationRun{
+		{Name: "DepositServer", Duration: time.Millisecond, Received: 100, Sent: 10, Date: now},
+		{Name: "DepositServer", Duration: 3 * time.Millisecond, Received: 300, Sent: 30, Date: now},
+		{Name: "WithdrawalServer", Duration: time.Millisecond, Received: 50, Sent: 5, Date: now},
+		{Name: "DepositServer", Duration: time.Second, Date: now.Add(-48 * time.Hour)}, // Beyond the retention.
+	}
+	if err := bankStore.WriteOperations(runs, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadOperationStats.
+	stats, err := bankStore.ReadOperationStats(now.Add(-72*time.Hour), 72*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Name != "DepositServer" || stats[1].Name != "WithdrawalServer" {
+		t.Fatalf("unexpected operations: %+v", stats)
+	}
+	deposit := stats[0]
+	if deposit.Runs != 2 || deposit.Mean != 2*time.Millisecond || deposit.Max != 3*time.Millisecond ||
+		deposit.Received != 400 || deposit.Sent != 40 {
+		t.Fatalf("unexpected deposits: %+v", deposit)
+	}
+}
+
+func TestApplication(t *testing.T) {
+	// Grab database paths.
+	bankPath := filepath.Join(t.TempDir(), "bank.db")
+	clientPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(bankPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore, err := new(store.ClientStore).New(clientPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+
+	// ReadAdmission. (Unknown)
+	hash := client.Profile().Hash()
+	if _, err := bankStore.ReadAdmission(hash); err != store.ErrUnknownApplication {
+		t.Fatalf("expected ErrUnknownApplication, got %v", err)
+	}
+	if err := bankStore.UpdateAdmission(hash, store.Admission_Approved); err != store.ErrUnknownApplication {
+		t.Fatalf("expected ErrUnknownApplication, got %v", err)
+	}
+
+	// WriteApplication. (Repeated applications are ignored)
+	for i := 0; i < 2; i++ {
+		if err := bankStore.WriteApplication(client.Profile(), "passport"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	applications, err := bankStore.ReadApplications(store.Admission_Pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applications) != 1 || applications[0].Hash != hash || applications[0].Evidence != "passport" {
+		t.Fatalf("unexpected applications: %v", applications)
+	}
+
+	// UpdateAdmission & ReadAdmission.
+	if err := bankStore.UpdateAdmission(hash, store.Admission_Approved); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := bankStore.ReadAdmission(hash); err != nil || status != store.Admission_Approved {
+		t.Fatalf("expected Approved, got %v, %v", status, err)
+	}
+
+	// WritePendingClient & ReadPendingClient & DeletePendingClient.
+	if pending, err := clientStore.ReadPendingClient(); err != nil || pending != nil {
+		t.Fatalf("expected no pending client, got %v, %v", pending, err)
+	}
+	if err := clientStore.WritePendingClient(client); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := clientStore.ReadPendingClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Profile().Hash() != hash {
+		t.Fatal("unexpected pending client")
+	}
+	if err := clientStore.DeletePendingClient(); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := clientStore.ReadPendingClient(); err != nil || pending != nil {
+		t.Fatalf("expected no pending client, got %v, %v", pending, err)
+	}
+}
+
+func TestRegistrationToken(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ConsumeRegistrationToken. (Unknown)
+	if err := bankStore.ConsumeRegistrationToken("unknown", client.Profile()); err != store.ErrRegistrationToken {
+		t.Fatalf("expected ErrRegistrationToken, got %v", err)
+	}
+
+	// NewRegistrationToken & ConsumeRegistrationToken. (Reusable by the same client only)
+	token, err := bankStore.NewRegistrationToken("invite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := bankStore.ConsumeRegistrationToken(token, client.Profile()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := new(core.Client).New(nil, bank.Profile())
+	if err := bankStore.ConsumeRegistrationToken(token, other.Profile()); err != store.ErrRegistrationToken {
+		t.Fatalf("expected ErrRegistrationToken, got %v", err)
+	}
+}
+
+func TestReconcileBalances(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReconcileBalances. (Consistent)
+	drifts, err := clientStore.ReconcileBalances(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("unexpected drifts: %v", drifts)
+	}
+
+	// Drift the local balance.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE Client SET localBalance = localBalance + 7`); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReconcileBalances. (Reported, then fixed)
+	drifts, err = clientStore.ReconcileBalances(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Recorded-drifts[0].Actual != 7 {
+		t.Fatalf("unexpected drifts: %v", drifts)
+	}
+	if _, err := clientStore.ReconcileBalances(true); err != nil {
+		t.Fatal(err)
+	}
+	drifts, err = clientStore.ReconcileBalances(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("unexpected drifts after fix: %v", drifts)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadBanks.
+	banks, err := clientStore.ReadBanks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(banks) != 1 || banks[0] != bankName {
+		t.Fatalf("unexpected banks: %v", banks)
+	}
+
+	// Quarantine the coin, once.
+	if err := clientStore.QuarantineCoin(coin, "invalid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.QuarantineCoin(coin, "invalid"); err != store.ErrUnknownCoin {
+		t.Fatalf("expected ErrUnknownCoin, got %v", err)
+	}
+
+	// The coin left the wallet and its balance.
+	coins, err := clientStore.ReadCoins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coins) != 0 {
+		t.Fatalf("expected no coins, got %d", len(coins))
+	}
+	drifts, err := clientStore.ReconcileBalances(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("unexpected drifts: %v", drifts)
+	}
+
+	// ReadQuarantinedCoins.
+	quarantined, err := clientStore.ReadQuarantinedCoins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].Reason != "invalid" || quarantined[0].Coin.Profile().Hash() != coin.Profile().Hash() {
+		t.Fatalf("unexpected quarantined coins: %+v", quarantined)
+	}
+}
+
+func TestBlobMigration(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the entry as decimal text, as written before blobs.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stmt := `UPDATE ClientInfo SET K = ?, S = ?, Credential = ?, Contract = ?`
+	_, err = db.Exec(stmt, clientInfo.K.String(), clientInfo.S.String(), clientInfo.Credential.String(), clientInfo.Contract.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+
+	// New. (Migrates)
+	bankStore, err = new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := bankStore.ReadClientInfo(&clientInfo.Profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.K.Cmp(clientInfo.K) != 0 || read.Credential.Cmp(clientInfo.Credential) != 0 {
+		t.Fatal("unexpected ClientInfo after migration")
+	}
+	var kind string
+	if err := db.QueryRow(`SELECT typeof(K) FROM ClientInfo`).Scan(&kind); err != nil || kind != "blob" {
+		t.Fatalf("expected a blob, got %s (%v)", kind, err)
+	}
+}
+
+func BenchmarkReadClientInfo(b *testing.B) {
+	// Grab database path.
+	dbPath := filepath.Join(b.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := bankStore.ReadClientInfo(&clientInfo.Profile); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRecordCache(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cached reads are copies.
+	read, err := bankStore.ReadClientInfo(&clientInfo.Profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read.Expiration = time.Time{}
+	read.Credential.SetInt64(0)
+	read.Profile.N.SetInt64(0)
+	read, err = bankStore.ReadClientInfo(&clientInfo.Profile)
+	if err != nil || !read.Expiration.Equal(clientInfo.Expiration) {
+		t.Fatalf("cached entry changed: %s (%v)", read.Expiration, err)
+	}
+	if read.Credential.Cmp(clientInfo.Credential) != 0 || read.Profile.N.Cmp(clientInfo.Profile.N) != 0 {
+		t.Fatal("cached numbers changed")
+	}
+	cachedBank, err := bankStore.ReadBank()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedBank.Priv.SetInt64(0)
+	if cachedBank, _ = bankStore.ReadBank(); cachedBank.Priv.Cmp(bank.Priv) != 0 {
+		t.Fatal("cached bank numbers changed")
+	}
+
+	// Cached reads hold the stored profile, not the one looked up with.
+	lookup := clientInfo.Profile
+	lookup.N = new(big.Int).Set(clientInfo.Profile.N)
+	if read, _ = bankStore.ReadClientInfo(&lookup); read.Profile.N == lookup.N {
+		t.Fatal("cached entry holds the caller's profile")
+	}
+
+	// Changes of other processes are read at once.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	expiration := clientInfo.Expiration.Add(time.Hour)
+	if _, err := db.Exec(`UPDATE ClientInfo SET expiration = ?`, expiration); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE Bank SET currency = 'EUR'`); err != nil {
+		t.Fatal(err)
+	}
+	if read, _ := bankStore.ReadClientInfo(&clientInfo.Profile); !read.Expiration.Equal(expiration) {
+		t.Fatalf("expected the updated expiration, got %s", read.Expiration)
+	}
+	if read, err := bankStore.ReadBank(); err != nil || read.Currency != "EUR" {
+		t.Fatalf("expected the updated currency, got %s (%v)", read.Currency, err)
+	}
+
+	// Writes of the store drop the entries they change.
+	updated := *clientInfo
+	updated.Expiration = expiration.Add(time.Hour)
+	if err := bankStore.UpdateClientInfo(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if read, _ := bankStore.ReadClientInfo(&clientInfo.Profile); !read.Expiration.Equal(updated.Expiration) {
+		t.Fatalf("expected the written expiration, got %s", read.Expiration)
+	}
+
+	// Replicas sharing the database see each other's writes.
+	replica, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.ReadClientInfo(&clientInfo.Profile); err != nil {
+		t.Fatal(err)
+	}
+	updated.Expiration = updated.Expiration.Add(time.Hour)
+	if err := bankStore.UpdateClientInfo(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if read, _ := replica.ReadClientInfo(&clientInfo.Profile); !read.Expiration.Equal(updated.Expiration) {
+		t.Fatalf("expected the other replica's expiration, got %s", read.Expiration)
+	}
+
+	// Balances aren't cached, their changes keep the entries.
+	before, err := replica.ReadGeneration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bankStore.CreditClientBalance(&clientInfo.Profile, core.DefaultCurrency, 1); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := replica.ReadGeneration(); err != nil || after != before {
+		t.Fatalf("expected generation %d, got %d (%v)", before, after, err)
+	}
+
+	// Invalidated entries are read again.
+	bankStore.InvalidateCache()
+	if read, _ := bankStore.ReadClientInfo(&clientInfo.Profile); !read.Expiration.Equal(updated.Expiration) {
+		t.Fatalf("expected the written expiration, got %s", read.Expiration)
+	}
+}
+
+func TestBusyRetry(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New. (Failing at once on a locked database)
+	bankStore, err := new(store.BankStore).Options(store.DatabaseOptions{}).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteClientInfo(clientInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the write lock for a while from another connection.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), `BEGIN IMMEDIATE`); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.ExecContext(context.Background(), `COMMIT`)
+	}()
+
+	// The write is retried until the lock is released.
+	if err := bankStore.UpdateClientBalance(&clientInfo.Profile, core.DefaultCurrency, 42); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := bankStore.ReadClientBalance(&clientInfo.Profile, core.DefaultCurrency)
+	if err != nil || balance != 42 {
+		t.Fatalf("unexpected balance: %d (%v)", balance, err)
+	}
+}
+
+func TestSpentCoins(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// DeleteCoin. (Archived)
+	if err := clientStore.DeleteCoin(coin, store.Operation_Payment); err != nil {
+		t.Fatal(err)
+	}
+	coins, err := clientStore.ReadCoins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coins) != 0 {
+		t.Fatalf("unexpected coins: %d", len(coins))
+	}
+
+	// ReadSpentCoins.
+	spent, err := clientStore.ReadSpentCoins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spent) != 1 || spent[0].Operation != store.Operation_Payment || spent[0].Coin.Profile().Hash() != coin.Profile().Hash() {
+		t.Fatal("unexpected spent coins")
+	}
+
+	// PurgeSpentCoins.
+	if purged, err := clientStore.PurgeSpentCoins(spent[0].Date.Add(-time.Hour)); err != nil || purged != 0 {
+		t.Fatalf("unexpected purge: %d (%v)", purged, err)
+	}
+	if purged, err := clientStore.PurgeSpentCoins(time.Now().Add(time.Hour)); err != nil || purged != 1 {
+		t.Fatalf("unexpected purge: %d (%v)", purged, err)
+	}
+}
+
+func TestSubAccounts(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	coins := newBenchmarkCoins(2)
+	for i := range coins {
+		if err := clientStore.WriteCoin(&coins[i], store.Operation_Withdrawal); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// WriteSubAccount.
+	travel := &store.SubAccount{Name: "travel", Limit: 1, Period: time.Hour}
+	if err := clientStore.WriteSubAccount(travel); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := clientStore.ReadSubAccount("travel"); err != nil || *read != *travel {
+		t.Fatalf("unexpected sub-account: %v (%v)", read, err)
+	}
+	if _, err := clientStore.ReadSubAccount("household"); err != store.ErrUnknownAccount {
+		t.Fatalf("expected %v, got %v", store.ErrUnknownAccount, err)
+	}
+
+	// MoveCoin partitions the coins.
+	if err := clientStore.MoveCoin(&coins[0], "household"); err != store.ErrUnknownAccount {
+		t.Fatalf("expected %v, got %v", store.ErrUnknownAccount, err)
+	}
+	if err := clientStore.MoveCoin(&coins[0], "travel"); err != nil {
+		t.Fatal(err)
+	}
+	for account, count := range map[string]int{"": 1, "travel": 1} {
+		if read, err := clientStore.ReadAccountCoins(account); err != nil || len(read) != count {
+			t.Fatalf("unexpected coins in %q: %d (%v)", account, len(read), err)
+		}
+	}
+	if account, err := clientStore.ReadCoinAccount(&coins[0]); err != nil || account != "travel" {
+		t.Fatalf("unexpected sub-account of the moved coin: %q (%v)", account, err)
+	}
+
+	// CheckSpendingLimit counts the payments within the period.
+	if err := clientStore.CheckSpendingLimit("travel", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.DeleteCoin(&coins[0], store.Operation_Payment); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.CheckSpendingLimit("travel", "", 1); err != store.ErrSpendingLimit {
+		t.Fatalf("expected %v, got %v", store.ErrSpendingLimit, err)
+	}
+	if err := clientStore.CheckSpendingLimit("", "", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// DeleteSubAccount moves its coins back to the main account.
+	if err := clientStore.MoveCoin(&coins[1], "travel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.DeleteSubAccount("travel"); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := clientStore.ReadAccountCoins(""); err != nil || len(read) != 1 {
+		t.Fatalf("unexpected coins in the main account: %d (%v)", len(read), err)
+	}
+	if accounts, err := clientStore.ReadSubAccounts(); err != nil || len(accounts) != 0 {
+		t.Fatalf("unexpected sub-accounts: %v (%v)", accounts, err)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// AcquireCoin hides the coin from other operations.
+	if err := clientStore.AcquireCoin(coin, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.AcquireCoin(coin, time.Hour); err != store.ErrReservedCoin {
+		t.Fatalf("expected %v, got %v", store.ErrReservedCoin, err)
+	}
+	if coins, err := clientStore.ReadCoins(); err != nil || len(coins) != 0 {
+		t.Fatalf("unexpected coins: %d (%v)", len(coins), err)
+	}
+
+	// ReleaseCoin.
+	if err := clientStore.ReleaseCoin(coin); err != nil {
+		t.Fatal(err)
+	}
+	if coins, err := clientStore.ReadCoins(); err != nil || len(coins) != 1 {
+		t.Fatalf("unexpected coins: %d (%v)", len(coins), err)
+	}
+
+	// Expired reservations release the coin.
+	if err := clientStore.AcquireCoin(coin, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if coins, err := clientStore.ReadAccountCoins(""); err != nil || len(coins) != 1 {
+		t.Fatalf("unexpected coins: %d (%v)", len(coins), err)
+	}
+	if err := clientStore.AcquireCoin(coin, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPaymentSessions(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payer's session takes the coin out of the wallet once signed.
+	session := &store.PaymentSession{Token: "token", Peer: "merchant", Coin: coin.Profile().Hash(), Data: []byte{1}}
+	for _, state := range []store.State_Type{store.State_Started, store.State_Stamped, store.State_Signed} {
+		session.State = state
+		if err := clientStore.WritePaymentSession(session); err != nil {
+			t.Fatal(err)
+		}
+		expected := 1
+		if state == store.State_Signed {
+			expected = 0
+		}
+		if coins, err := clientStore.ReadAccountCoins(""); err != nil || len(coins) != expected {
+			t.Fatalf("unexpected coins at %s: %d (%v)", state, len(coins), err)
+		}
+	}
+	read, err := clientStore.ReadPaymentSession("token", false)
+	if err != nil || read == nil || read.State != store.State_Signed || read.Peer != "merchant" || len(read.Data) != 1 {
+		t.Fatalf("unexpected session: %+v (%v)", read, err)
+	}
+
+	// The merchant's sessions don't hide its coins, and expire.
+	merchant := &store.PaymentSession{Token: "token", Payee: true, Peer: "payer", Coin: coin.Profile().Hash(), State: store.State_Accepted, Data: []byte{}}
+	if err := clientStore.WritePaymentSession(merchant); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.DeleteExpiredPaymentSessions(); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err := clientStore.ReadPaymentSessions(true); err != nil || len(sessions) != 1 {
+		t.Fatalf("unexpected sessions: %d (%v)", len(sessions), err)
+	}
+
+	// Ending the payer's session returns the coin.
+	if err := clientStore.DeletePaymentSession("token", false); err != nil {
+		t.Fatal(err)
+	}
+	if coins, err := clientStore.ReadCoins(); err != nil || len(coins) != 1 {
+		t.Fatalf("unexpected coins: %d (%v)", len(coins), err)
+	}
+	if sessions, err := clientStore.ReadPaymentSessions(false); err != nil || len(sessions) != 0 {
+		t.Fatalf("unexpected sessions: %d (%v)", len(sessions), err)
+	}
+}
+
+func TestRefillPolicies(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	// WriteRefillPolicy replaces the policy of its currency.
+	for _, policy := range []store.RefillPolicy{
+		{Currency: "", Threshold: 2, Target: 5, Value: 1},
+		{Currency: "EUR", Threshold: 1, Target: 2, Value: 10},
+		{Currency: "", Threshold: 3, Target: 6, Value: 5},
+	} {
+		if err := clientStore.WriteRefillPolicy(&policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policies, err := clientStore.ReadRefillPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []store.RefillPolicy{
+		{Currency: "EUR", Threshold: 1, Target: 2, Value: 10},
+		{Currency: core.DefaultCurrency, Threshold: 3, Target: 6, Value: 5},
+	}
+	if len(policies) != len(expected) {
+		t.Fatalf("unexpected policies: %v", policies)
+	}
+	for i := range expected {
+		if policies[i] != expected[i] {
+			t.Fatalf("unexpected policy: %v, expected %v", policies[i], expected[i])
+		}
+	}
+
+	// DeleteRefillPolicy.
+	if err := clientStore.DeleteRefillPolicy("EUR"); err != nil {
+		t.Fatal(err)
+	}
+	if policies, err := clientStore.ReadRefillPolicies(); err != nil || len(policies) != 1 {
+		t.Fatalf("unexpected policies: %v (%v)", policies, err)
+	}
+}
+
+func TestLockWallet(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// LockWallet.
+	lock, err := store.LockWallet(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LockWallet(dbPath); !errors.Is(err, store.ErrWalletInUse) {
+		t.Fatalf("expected ErrWalletInUse, got %v", err)
+	}
+
+	// Unlock.
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = store.LockWallet(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock()
+
+	// LockWallet. (Stale lock)
+	if err := os.WriteFile(dbPath+".lock", []byte("2147483646\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = store.LockWallet(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock()
+}
+
+func TestReadOnly(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+
+	// NewReadOnly.
+	readOnly, err := new(store.BankStore).NewReadOnly(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readOnly.Name != bankName {
+		t.Fatalf("unexpected name: %s", readOnly.Name)
+	}
+	if _, err := readOnly.ReadBank(); err != nil {
+		t.Fatal(err)
+	}
+	if err := readOnly.WriteClientInfo(clientInfo); err == nil {
+		t.Fatal("expected write to fail")
+	}
+
+	// NewReadOnly. (Missing database)
+	if _, err := new(store.BankStore).NewReadOnly(filepath.Join(t.TempDir(), "missing.db"), identity); err == nil {
+		t.Fatal("expected open to fail")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inspect.
+	inspection, err := clientStore.Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspection.Clients) != 1 || inspection.Clients[0].Bank != bankName {
+		t.Fatalf("unexpected clients: %v", inspection.Clients)
+	}
+	if len(inspection.Coins) != 1 || inspection.Coins[0].CoinHash != int64(coin.Profile().Hash()) {
+		t.Fatalf("unexpected coins: %v", inspection.Coins)
+	}
+	if inspection.Clients[0].Pub != nil || inspection.CoinParams != nil {
+		t.Fatal("unexpected numbers in summary")
+	}
+
+	// InspectFull.
+	inspection, err = clientStore.InspectFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspection.Clients[0].Pub.Cmp(client.Pub) != 0 {
+		t.Fatalf("unexpected client pub: %v", inspection.Clients[0].Pub)
+	}
+	if len(inspection.CoinParams) != 1 || inspection.CoinParams[0].C1.Cmp(coin.Params.C1) != 0 {
+		t.Fatalf("unexpected coin params: %v", inspection.CoinParams)
+	}
+}
+
+func TestSchemeFingerprint(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "bank.db")
+
+	// New.
+	bankStore, err := new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteProvenance("built-in"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inspect.
+	inspection, err := bankStore.Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := inspection.Banks[0]; row.Fingerprint != bank.Scheme.Fingerprint() || row.Provenance != "built-in" {
+		t.Fatalf("unexpected fingerprint or provenance: %s, %s", row.Fingerprint, row.Provenance)
+	}
+
+	// Backfill. (Entries written before fingerprints)
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE Bank SET fingerprint = ''`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	bankStore, err = new(store.BankStore).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bankStore.ReadBank(); err != nil {
+		t.Fatal(err)
+	}
+	var fingerprint string
+	if err := db.QueryRow(`SELECT fingerprint FROM Bank`).Scan(&fingerprint); err != nil || fingerprint != bank.Scheme.Fingerprint() {
+		t.Fatalf("unexpected fingerprint after backfill: %s (%v)", fingerprint, err)
+	}
+
+	// ReadBank. (Switched scheme parameters)
+	other := new(big.Int).Add(bank.Scheme.G, big.NewInt(1))
+	if _, err := db.Exec(`UPDATE Bank SET scheme_G = ?`, append([]byte{0}, other.Bytes()...)); err != nil {
+		t.Fatal(err)
+	}
+	bankStore.InvalidateCache() // Changed behind the store's back.
+	if _, err := bankStore.ReadBank(); !errors.Is(err, core.ErrSchemeMismatch) {
+		t.Fatalf("got %v, want %v", err, core.ErrSchemeMismatch)
+	}
+}
+
+func TestPayerLimit(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limit holds per payer and currency.
+	if err := clientStore.WritePayerPayment("10.0.0.1", "", 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.CheckPayerLimit("10.0.0.1", core.DefaultCurrency, 4, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.CheckPayerLimit("10.0.0.1", "", 5, 10); err != store.ErrPayerLimit {
+		t.Fatalf("expected %v, got %v", store.ErrPayerLimit, err)
+	}
+	if err := clientStore.CheckPayerLimit("10.0.0.1", "EUR", 5, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.CheckPayerLimit("10.0.0.2", "", 10, 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReceipts(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	// WriteReceipt.
+	receipt := &store.Receipt{
+		Coin:        *coin,
+		Merchant:    "merchant.example",
+		Certificate: "c0ffee",
+		Memo:        core.Memo{Text: "order 42", Payee: big.NewInt(7), Date: time.Unix(1700000000, 0).UTC(), Account: big.NewInt(9)},
+		Date:        time.Now().UTC(),
+	}
+	if err := clientStore.WriteReceipt(receipt); err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadReceipts.
+	receipts, err := clientStore.ReadReceipts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 1 {
+		t.Fatalf("unexpected receipts: %d", len(receipts))
+	}
+	read := receipts[0]
+	if read.Hash != coin.Profile().Hash() || read.Coin.Profile().Hash() != read.Hash {
+		t.Fatalf("unexpected receipt's coin: %d", read.Hash)
+	}
+	if read.Merchant != receipt.Merchant || read.Certificate != receipt.Certificate {
+		t.Fatalf("unexpected receipt's merchant: %s (%s)", read.Merchant, read.Certificate)
+	}
+	memo := read.Memo
+	if memo.Text != receipt.Memo.Text || memo.Payee.Cmp(receipt.Memo.Payee) != 0 || !memo.Date.Equal(receipt.Memo.Date) ||
+		memo.Account.Cmp(receipt.Memo.Account) != 0 || memo.Change != nil {
+		t.Fatalf("unexpected receipt's memo: %+v", memo)
+	}
+}
+
+func TestExpiringCoins(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	// Count, read-only, before and after the coin's expiration.
+	readOnly, err := new(store.ClientStore).NewReadOnly(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	for _, check := range []struct {
+		deadline time.Time
+		count    int
+	}{
+		{coin.Params.Expiration.Add(-time.Hour), 0},
+		{coin.Params.Expiration.Add(time.Hour), 1},
+	} {
+		count, err := readOnly.CountExpiringCoins(check.deadline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != check.count {
+			t.Fatalf("expected %d expiring coins before %s, got %d", check.count, check.deadline, count)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	// Grab database path.
+	dbPath := filepath.Join(t.TempDir(), "client.db")
+
+	// New.
+	clientStore, err := new(store.ClientStore).New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore.BankName = bankName
+	if err := clientStore.WriteClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientStore.ReadClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pay the coin to a merchant.
+	if err := clientStore.WriteCoin(coin, store.Operation_Withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	receipt := &store.Receipt{Coin: *coin, Merchant: "merchant.example", Date: time.Now().UTC()}
+	if err := clientStore.WriteReceipt(receipt); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientStore.DeleteCoin(coin, store.Operation_Payment); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stats.
+	stats, err := clientStore.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, currency := core.NormalizeValue(coin.Params.Value), core.NormalizeCurrency(coin.Params.Currency)
+	if len(stats.Merchants) != 1 || stats.Merchants[0] != (store.MerchantStats{Merchant: "merchant.example", Currency: currency, Payments: 1, Amount: value}) {
+		t.Fatalf("unexpected merchants: %+v", stats.Merchants)
+	}
+	month := time.Now().UTC().Format("2006-01")
+	if len(stats.Months) != 1 || stats.Months[0] != (store.MonthStats{Month: month, Currency: currency, Payments: 1, Amount: value}) {
+		t.Fatalf("unexpected months: %+v", stats.Months)
+	}
+	if len(stats.Currencies) != 1 || stats.Currencies[0].Coins != 1 || stats.Currencies[0].Amount != value {
+		t.Fatalf("unexpected currencies: %+v", stats.Currencies)
+	}
+	issued := core.Validity.Issuance(coin.Params.Expiration, coin.Params.Value)
+	if lifetime := stats.Currencies[0].AverageLifetime; lifetime < 0 || lifetime > time.Since(issued) {
+		t.Fatalf("unexpected lifetime: %s", lifetime)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	// Grab database paths.
+	directory := t.TempDir()
+
+	// New.
+	bankStore, err := new(store.BankStore).New(filepath.Join(directory, "bank.db"), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientStore, err := new(store.ClientStore).New(filepath.Join(directory, "client.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2024, 5, 1, 12, 30, 0, 42, time.UTC)
+	for _, meta := range []*store.Meta{bankStore.Meta(), clientStore.Meta()} {
+		// Unset keys.
+		if _, err := meta.ReadString("policy"); err != store.ErrUnknownMeta {
+			t.Fatalf("expected %v, got %v", store.ErrUnknownMeta, err)
+		}
+
+		// Typed values.
+		if err := meta.WriteString("policy", "strict"); err != nil {
+			t.Fatal(err)
+		}
+		if err := meta.WriteInt("rounds", -3); err != nil {
+			t.Fatal(err)
+		}
+		if err := meta.WriteBool("paused", true); err != nil {
+			t.Fatal(err)
+		}
+		if err := meta.WriteTime("refilled", date.In(time.FixedZone("CET", 3600))); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := meta.ReadString("policy"); err != nil || value != "strict" {
+			t.Fatalf("unexpected string: %q (%v)", value, err)
+		}
+		if value, err := meta.ReadInt("rounds"); err != nil || value != -3 {
+			t.Fatalf("unexpected int: %d (%v)", value, err)
+		}
+		if value, err := meta.ReadBool("paused"); err != nil || !value {
+			t.Fatalf("unexpected bool: %t (%v)", value, err)
+		}
+		if value, err := meta.ReadTime("refilled"); err != nil || !value.Equal(date) {
+			t.Fatalf("unexpected time: %s (%v)", value, err)
+		}
+
+		// Write replaces, Delete unsets.
+		if err := meta.WriteInt("rounds", 4); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := meta.ReadInt("rounds"); err != nil || value != 4 {
+			t.Fatalf("unexpected int: %d (%v)", value, err)
+		}
+		if err := meta.Delete("rounds"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := meta.ReadInt("rounds"); err != store.ErrUnknownMeta {
+			t.Fatalf("expected %v, got %v", store.ErrUnknownMeta, err)
+		}
+
+		// Binary values.
+		bundle := core.SetupBundle{Name: "bank", Params: "params", R: big.NewInt(2), S: big.NewInt(3)}
+		if err := meta.WriteBinary("setup", &bundle); err != nil {
+			t.Fatal(err)
+		}
+		var read core.SetupBundle
+		if err := meta.ReadBinary("setup", &read); err != nil || read.Name != bundle.Name || read.S.Cmp(bundle.S) != 0 {
+			t.Fatalf("unexpected binary: %+v (%v)", read, err)
+		}
+	}
+}
+
+func TestDatabaseOptions(t *testing.T) {
+	directory := t.TempDir()
+
+	// ParsePragmas.
+	pragmas, err := store.ParsePragmas([]string{"synchronous=FULL", " Cache_Size = -2000 "})
+	if err != nil || pragmas["synchronous"] != "FULL" || pragmas["cache_size"] != "-2000" {
+		t.Fatalf("unexpected pragmas: %v (%v)", pragmas, err)
+	}
+	for _, setting := range []string{"synchronous", "synchronous=FULL&mode=rw", "journal mode=WAL", "=1"} {
+		if _, err := store.ParsePragmas([]string{setting}); !errors.Is(err, store.ErrDatabaseOption) {
+			t.Errorf("%q: got %v, want %v", setting, err, store.ErrDatabaseOption)
+		}
+	}
+
+	// New. (With overrides)
+	options := store.ServerOptions
+	options.Pragmas = pragmas
+	dbPath := filepath.Join(directory, "bank.db")
+	bankStore, err := new(store.BankStore).Options(options).New(dbPath, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bankStore.WriteBank(bank, bankName); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default pragmas still apply.
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var mode string
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("unexpected journal mode: %s (%v)", mode, err)
+	}
+
+	// Malformed overrides are rejected.
+	options.Pragmas = map[string]string{"synchronous": "FULL)&_pragma=query_only(1"}
+	if _, err := new(store.ClientStore).Options(options).New(filepath.Join(directory, "client.db")); !errors.Is(err, store.ErrDatabaseOption) {
+		t.Fatalf("got %v, want %v", err, store.ErrDatabaseOption)
+	}
+}
+
+func TestQueryPlans(t *testing.T) {
+	directory := t.TempDir()
+	bankPath := filepath.Join(directory, "bank.db")
+	if _, err := new(store.BankStore).New(bankPath, identity); err != nil {
+		t.Fatal(err)
+	}
+	clientPath := filepath.Join(directory, "client.db")
+	if _, err := new(store.ClientStore).New(clientPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each query is planned with its index rather than a scan of the table.
+	plans := []struct {
+		dbPath string
+		query  string
+		args   []interface{}
+		index  string
+	}{
+		{bankPath, `SELECT operation, COUNT(*) FROM CoinProfile GROUP BY operation`, nil, "CoinProfileOperation"},
+		{bankPath, `SELECT currency, COUNT(*), SUM(balance) FROM ClientBalance GROUP BY currency`, nil, "ClientBalanceCurrency"},
+		{bankPath, `SELECT COUNT(*) FROM Revocation WHERE kind = ?`, []interface{}{0}, "sqlite_autoindex_Revocation_1"},
+		{bankPath, `SELECT id FROM CoinProfile WHERE client = ? AND date >= ?`, []interface{}{1, time.Now()}, "CoinProfileClient"},
+		{bankPath, `SELECT K FROM ClientInfo WHERE hash = ?`, []interface{}{1}, "sqlite_autoindex_ClientInfo_1"},
+		{bankPath, `SELECT hash FROM Application WHERE status = ? ORDER BY id`, []interface{}{0}, "ApplicationStatus"},
+		{clientPath, `SELECT id FROM CoinChange WHERE client = ? ORDER BY id`, []interface{}{1}, "CoinChangeClient"},
+		{clientPath, `SELECT id FROM CoinPool WHERE client = ? ORDER BY id LIMIT 1`, []interface{}{1}, "CoinPoolClient"},
+		{clientPath, `SELECT coin FROM SpentCoin WHERE client = ? ORDER BY date, id`, []interface{}{1}, "SpentCoinClient"},
+		{clientPath, `SELECT coin FROM Receipt WHERE client = ? ORDER BY date, id`, []interface{}{1}, "ReceiptClient"},
+		{bankPath, `SELECT count, date FROM OperationCount WHERE client = ? AND operation = ? AND date >= ? ORDER BY date`, []interface{}{1, 0, time.Now()}, "OperationCountClient"},
+		{bankPath, `SELECT id FROM Transfer WHERE payer = ? OR payee = ? ORDER BY date, id`, []interface{}{1, 1}, "TransferPayer"},
+		{clientPath, `SELECT SUM(amount) FROM PayerPayment WHERE client = ? AND payer = ? AND currency = ? AND date >= ?`, []interface{}{1, "", "", time.Now()}, "PayerPaymentClient"},
+	}
+	for _, plan := range plans {
+		db, err := sql.Open("sqlite", plan.dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query(`EXPLAIN QUERY PLAN `+plan.query, plan.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var details []string
+		for rows.Next() {
+			var (
+				id, parent, unused int
+				detail             string
+			)
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			details = append(details, detail)
+		}
+		rows.Close()
+		db.Close()
+		if joined := strings.Join(details, "; "); !strings.Contains(joined, "INDEX "+plan.index) {
+			t.Errorf("%s: expected %s, got %s", plan.query, plan.index, joined)
+		}
+	}
+}
+
+func TestZibaDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG layout only")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+	t.Setenv("ZIBA_DIR", "")
+
+	// The legacy directory is moved to the data directory.
+	legacy := filepath.Join(home, "Documents", "ziba-cli")
+	if err := os.MkdirAll(legacy, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, "alice.db"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	directory, err := store.GetZibaDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(home, "data", "ziba"); directory != expected {
+		t.Fatalf("unexpected directory: %s, expected %s", directory, expected)
+	}
+	if _, err := os.Stat(store.DatabasePath(directory, "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("legacy directory wasn't moved: %v", err)
+	}
+
+	// ZIBA_DIR, then ZibaDir, override it.
+	t.Setenv("ZIBA_DIR", filepath.Join(home, "env"))
+	if directory, err := store.GetZibaDir(); err != nil || directory != filepath.Join(home, "env") {
+		t.Fatalf("unexpected directory: %s (%v)", directory, err)
+	}
+	store.ZibaDir = filepath.Join(home, "flag")
+	defer func() { store.ZibaDir = "" }()
+	if directory, err := store.GetZibaDir(); err != nil || directory != filepath.Join(home, "flag") {
+		t.Fatalf("unexpected directory: %s (%v)", directory, err)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	directory := t.TempDir()
+	t.Setenv("ZIBA_DIR", directory)
+
+	// The flat layout is moved into the subdirectories.
+	for _, name := range []string{"bancoco.db", "bancoco.db-wal", "bancoco_key.pem", "bancoco_cert.pem", "bancoco_share_1.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(directory, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.GetZibaDir(); err != nil {
+		t.Fatal(err)
+	}
+	moved := []string{
+		store.DatabasePath(directory, "bancoco"),
+		store.DatabasePath(directory, "bancoco") + "-wal",
+		store.KeyPath(directory, "bancoco"),
+		store.CertPath(directory, "bancoco"),
+		store.ConfigPath(directory, "bancoco_share_1.json"),
+		filepath.Join(directory, "notes.txt"),
+	}
+	for _, path := range moved {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A bank's database can't be opened as a user's, nor the other way around.
+	if _, err := new(store.BankStore).New(store.DatabasePath(directory, "bancoco"), identity); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := new(store.ClientStore).New(store.DatabasePath(directory, "bancoco")); err != store.ErrNameCollision {
+		t.Fatalf("expected ErrNameCollision, got %v", err)
+	}
+	if _, err := new(store.ClientStore).New(store.DatabasePath(directory, "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := new(store.BankStore).New(store.DatabasePath(directory, "alice"), identity); err != store.ErrNameCollision {
+		t.Fatalf("expected ErrNameCollision, got %v", err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "bank_cert.pem")
+
+	// Write, then replace.
+	for _, data := range []string{"first", "second"} {
+		if err := store.WriteFileAtomic(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		read, err := os.ReadFile(path)
+		if err != nil || string(read) != data {
+			t.Fatalf("unexpected contents: %q (%v)", read, err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("unexpected mode: %v (%v)", info.Mode(), err)
+		}
+	}
+
+	// No temporary file is left behind.
+	entries, err := os.ReadDir(directory)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected files: %v (%v)", entries, err)
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	directory := t.TempDir()
+	t.Setenv("ZIBA_DIR", directory)
+	clientStore, err := new(store.ClientStore).New(filepath.Join(t.TempDir(), "client.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := clientStore.Certificates()
+	bankCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("bank")})
+	merchantCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("merchant")})
+
+	// A bank and a merchant at the same address don't collide.
+	if err := certs.Write(&store.Certificate{Role: store.Role_Bank, Name: "localhost", Cert: bankCert}); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.Write(&store.Certificate{Role: store.Role_Merchant, Name: "localhost", Cert: merchantCert}); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := certs.Read(store.Role_Bank, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Cert) != string(bankCert) || cert.Fingerprint != store.CertificateFingerprint([]byte("bank")) {
+		t.Fatalf("unexpected certificate: %s %s", cert.Cert, cert.Fingerprint)
+	}
+	cert, err = certs.Read(store.Role_Merchant, "localhost")
+	if err != nil || cert.Fingerprint != store.CertificateFingerprint([]byte("merchant")) {
+		t.Fatalf("unexpected certificate: %v (%v)", cert, err)
+	}
+
+	// Publicly trusted certificates are empty, malformed ones rejected.
+	if err := certs.Write(&store.Certificate{Role: store.Role_Bank, Name: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := certs.Read(store.Role_Bank, "localhost"); err != nil || len(cert.Cert) != 0 || cert.Fingerprint != "" {
+		t.Fatalf("unexpected certificate: %v (%v)", cert, err)
+	}
+	if err := certs.Write(&store.Certificate{Role: store.Role_Bank, Name: "bad", Cert: []byte("bad")}); err != store.ErrMalformedCertificate {
+		t.Fatalf("expected ErrMalformedCertificate, got %v", err)
+	}
+
+	// Unknown certificates, unless a file of earlier versions is found.
+	if _, err := certs.Read(store.Role_Merchant, "shop:8080"); err != store.ErrUnknownCertificate {
+		t.Fatalf("expected ErrUnknownCertificate, got %v", err)
+	}
+	if _, err := store.GetZibaDir(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.CertPath(directory, "shop:8080"), merchantCert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := certs.Read(store.Role_Merchant, "shop:8080"); err != nil || string(cert.Cert) != string(merchantCert) {
+		t.Fatalf("unexpected certificate: %v (%v)", cert, err)
+	}
+
+	// ReadAll.
+	list, err := certs.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Role != store.Role_Bank || list[2].Name != "shop:8080" {
+		t.Fatalf("unexpected certificates: %v", list)
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"ziba/core"
+)
+
+//
+// TRANSFER
+//
+
+// 1. A client transfers part of its balance to another account, without handling coins: the payer's balance is
+//		debited and the payee's credited at once, both changes appended to the BalanceLog.
+// 2. Each transfer is recorded in the Transfer table, read back by either account.
+
+// createTransferTable creates the Transfer table of a bank's database using tx.
+func createTransferTable(tx *sql.Tx) error {
+	table := `CREATE TABLE IF NOT EXISTS Transfer (
+	-- keys
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+
+	-- Transfer
+	payer 		INTEGER NOT NULL, -- ClientProfile hash
+	payee 		INTEGER NOT NULL, -- ClientProfile hash
+	currency 	TEXT NOT NULL,
+	value 		INTEGER NOT NULL,
+
+	date DATETIME NOT NULL
+	);`
+	_, err := tx.Exec(table)
+	return err
+}
+
+// TransferBalance transfers value in currency from payer's balance to the balance of payee (ClientProfile hash), and
+// returns the transfer. Returns ErrInsufficientFunds if payer's balance is lower than value, ErrUnknownClient if payee
+// doesn't exist and ErrClosedAccount if either account is closed. (Nothing is written then)
+func (store *BankStore) TransferBalance(payer *core.ClientProfile, payee uint32, currency string, value int64) (*Transfer, error) {
+	var transfer *Transfer
+	err := retryBusy(func() (err error) {
+		transfer, err = store.transferBalance(payer, payee, currency, value)
+		return err
+	})
+	return transfer, err
+}
+
+// transferBalance is TransferBalance, run once.
+func (store *BankStore) transferBalance(payer *core.ClientProfile, payee uint32, currency string, value int64) (*Transfer, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	transfer := &Transfer{
+		Payer:    payer.Hash(),
+		Payee:    payee,
+		Currency: core.NormalizeCurrency(currency),
+		Value:    value,
+		Date:     time.Now().UTC(),
+	}
+
+	// Debit payer. (Writing first, against concurrent debits)
+	if err := store.debit(tx, payer, transfer.Currency, value); err != nil {
+		return nil, err
+	}
+
+	// Check both accounts are open.
+	for _, hash := range []uint32{transfer.Payer, transfer.Payee} {
+		var closed time.Time
+		err := tx.QueryRow(`SELECT closed FROM ClientInfo WHERE hash = ?`, hash).Scan(&closed)
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownClient
+		} else if err != nil {
+			return nil, err
+		}
+		if !closed.IsZero() {
+			return nil, ErrClosedAccount
+		}
+	}
+
+	// Credit payee.
+	if _, err := store.credit(tx, payee, transfer.Currency, value); err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO Transfer (payer, payee, currency, value, date) VALUES (?, ?, ?, ?, ?)`
+	res, err := tx.Exec(stmt, transfer.Payer, transfer.Payee, transfer.Currency, transfer.Value, transfer.Date)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	return transfer, tx.Commit()
+}
+
+// ReadTransfers returns the transfers paid or received by the client of hash (ClientProfile hash), oldest first.
+func (store *BankStore) ReadTransfers(hash uint32) ([]Transfer, error) {
+	stmt := `SELECT id, payer, payee, currency, value, date FROM Transfer WHERE payer = ? OR payee = ? ORDER BY date, id`
+	rows, err := store.db.Query(stmt, hash, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []Transfer
+	for rows.Next() {
+		var transfer Transfer
+		err := rows.Scan(&transfer.ID, &transfer.Payer, &transfer.Payee, &transfer.Currency, &transfer.Value, &transfer.Date)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, rows.Err()
+}
@@ -2,6 +2,9 @@ package store
 
 import (
 	"database/sql"
+	"math/big"
+	"time"
+	"ziba/core"
 )
 
 // ClientStore handles a client's local database operations. Allows for Writing/Reading a client identity for a certain bank and
@@ -10,16 +13,22 @@ type ClientStore struct {
 	// db represents an active database connection. Used for creating transactions on each operation.
 	db *sql.DB
 
+	// statements are the prepared statements of hot operations, reused across transactions.
+	statements *statementCache
+
+	// options are the database options set before New, DefaultOptions if nil.
+	options *DatabaseOptions
+
 	// clientId is the client's identity entry id on the database.
 	clientId int64
 
 	// BankName serves as the unique identifier for a bank.
 	BankName string
 
-	// LocalBalance keeps track of the local balance for this client.
+	// LocalBalance keeps track of the local balance for this client, in DefaultCurrency. (See ReadBalances)
 	LocalBalance int64
 
-	// RemoteBalance keeps track of the remote balance for this client.
+	// RemoteBalance keeps track of the remote balance for this client, in DefaultCurrency. (See ReadBalances)
 	RemoteBalance int64
 }
 
@@ -29,9 +38,377 @@ type BankStore struct {
 	// db represents an active database connection. Used for creating transactions on each operation.
 	db *sql.DB
 
+	// statements are the prepared statements of hot operations, reused across transactions.
+	statements *statementCache
+
+	// cache holds the Bank and ClientInfo entries read by the servers. (See recordCache)
+	cache *recordCache
+
+	// options are the database options set before New, DefaultOptions if nil.
+	options *DatabaseOptions
+
 	// Name is the Bank's public Name.
 	Name string
 
 	// identity serves as the unique identifier of a bank's identity.
 	identity string
+
+	// unlocked is the bank decrypted by Unlock. Returned by ReadBank instead of the database entry.
+	unlocked *core.Bank
+
+	// unlockedMints are the mint keys decrypted by Unlock, by currency.
+	unlockedMints map[string]core.RsaKey
+}
+
+// DatabaseOptions configures the connections of a store's database. (See DefaultOptions and ServerOptions)
+type DatabaseOptions struct {
+	// MaxOpenConns is the maximum number of open connections, unlimited if 0.
+	MaxOpenConns int
+
+	// MaxIdleConns is the number of idle connections kept open, none if 0.
+	MaxIdleConns int
+
+	// ConnMaxIdleTime closes the connections idle for longer, never if 0.
+	ConnMaxIdleTime time.Duration
+
+	// BusyTimeout is how long a connection waits for a locked database.
+	BusyTimeout time.Duration
+
+	// Pragmas override the SQLite pragmas of every connection, by name. (e.g. "synchronous": "FULL")
+	Pragmas map[string]string
+}
+
+// Balance is a client's balance in a currency.
+type Balance struct {
+	// Currency is the balance's currency.
+	Currency string
+
+	// Local is the value of the coins held by the client.
+	Local int64
+
+	// Remote is the balance of the client's account at the bank.
+	Remote int64
+}
+
+// BalanceDrift is a local balance that doesn't match the coins held by the client. (See ReconcileBalances)
+type BalanceDrift struct {
+	// Currency is the balance's currency.
+	Currency string
+
+	// Recorded is the local balance as recorded.
+	Recorded int64
+
+	// Actual is the value of the coins held by the client.
+	Actual int64
+}
+
+// EscrowCoin pairs a coin with the escrow conditions it is signed to and the escrow secrets known by this client.
+type EscrowCoin struct {
+	// Coin is the escrowed coin.
+	Coin core.Coin
+
+	// Escrow contains the escrow conditions of Coin.
+	Escrow core.Escrow
+
+	// Release is the release secret. Only known by the payer, or by the payee once revealed.
+	Release *big.Int
+
+	// Refund is the refund secret. Only known by the payer.
+	Refund *big.Int
+}
+
+// ChangeCoin pairs the remainder coin request of a partial payment with its claim secret, until the payer collects it.
+type ChangeCoin struct {
+	// Coin is the remainder coin request, its Random, Elgamal and request parameters.
+	Coin core.Coin
+
+	// Claim is the claim secret.
+	Claim *big.Int
+}
+
+// SubAccount is a partition of the client's wallet (e.g. "household", "travel"), with its own spending limit. Coins
+// in no sub-account belong to the main account, named "".
+type SubAccount struct {
+	// Name identifies the sub-account.
+	Name string
+
+	// Limit is the most the sub-account pays within Period, in each currency. No limit if 0.
+	Limit int64
+
+	// Period is the sliding window the limit applies to.
+	Period time.Duration
+}
+
+// RefillPolicy withdraws coins automatically whenever the wallet runs low on coins of a currency.
+type RefillPolicy struct {
+	// Currency is the currency of the refilled coins.
+	Currency string
+
+	// Threshold is the coin count below which the wallet is refilled.
+	Threshold int
+
+	// Target is the coin count the wallet is refilled up to.
+	Target int
+
+	// Value is the value of the withdrawn coins.
+	Value int64
+}
+
+// Certificate is a TLS certificate kept by a wallet, under its holder's role and name.
+type Certificate struct {
+	// Role is the role of the certificate's holder.
+	Role Role_Type
+
+	// Name is the holder's name: a user, or a server's address.
+	Name string
+
+	// Fingerprint is the SHA-256 fingerprint of the certificate, in hex. Empty if publicly trusted.
+	Fingerprint string
+
+	// Cert is the PEM encoded certificate. Empty if the server's certificate is publicly trusted.
+	Cert []byte
+
+	// Key is the PEM encoded private key, of Role_Own only.
+	Key []byte
+
+	// Date is the date the certificate was stored.
+	Date time.Time
+}
+
+// Receipt is the evidence of a payment kept by its payer: the signed coin and the merchant's stamp.
+type Receipt struct {
+	// Hash is the hash of the paid coin, as recorded.
+	Hash uint32
+
+	// Coin is the paid coin, signed with the stamp's message.
+	Coin core.Coin
+
+	// Merchant is the address of the merchant's server.
+	Merchant string
+
+	// Certificate is the fingerprint of the merchant's TLS certificate during the payment.
+	Certificate string
+
+	// Memo is the merchant's stamp.
+	Memo core.Memo
+
+	// Date is the date of the payment.
+	Date time.Time
+}
+
+// SpentCoin is a coin deleted from the client's wallet, kept in the spent-coins archive as evidence for disputes.
+type SpentCoin struct {
+	// Coin is the spent coin, along with its secrets.
+	Coin core.Coin
+
+	// Operation is the operation the coin was spent by.
+	Operation Operation_Type
+
+	// Memo is the text of the coin's memo, empty if it has none.
+	Memo string
+
+	// Date is the date the coin was spent.
+	Date time.Time
+}
+
+// QuarantinedCoin is a coin moved out of the client's wallet for failing its verification, never to be spent.
+type QuarantinedCoin struct {
+	// Coin is the quarantined coin, along with its secrets.
+	Coin core.Coin
+
+	// Account is the sub-account the coin was held in.
+	Account string
+
+	// Reason is why the coin failed its verification, e.g. "invalid".
+	Reason string
+
+	// Date is the date the coin was quarantined.
+	Date time.Time
+}
+
+// PendingChange is the remainder coin request of a partial payment kept by the bank, until its payer collects it.
+type PendingChange struct {
+	// Change contains the spent amount, the remainder coin request and the digest of the claim secret.
+	Change core.Change
+
+	// Value is the value of the remainder coin.
+	Value int64
+
+	// Currency is the currency of the remainder coin.
+	Currency string
+}
+
+// Identity is a portable copy of a user's local state: the database (client keys, bank profiles, credentials and coins)
+// and the certificates found in the Ziba directory.
+type Identity struct {
+	// Version is the bundle format version.
+	Version int
+
+	// User is the user's name the identity belongs to.
+	User string
+
+	// Created is the date the bundle was exported.
+	Created time.Time
+
+	// Files contains the exported files.
+	Files []IdentityFile
+}
+
+// IdentityFile is a single file inside an Identity bundle.
+type IdentityFile struct {
+	// Name is the file name relative to the Ziba directory.
+	Name string
+
+	// Data is the file's content.
+	Data []byte
+
+	// Checksum is the SHA256 sum of Data.
+	Checksum [32]byte
+}
+
+// CoinRecord is how a coin was surrendered to the bank, as returned by ReadCoinRecord.
+type CoinRecord struct {
+	// Hash is the hash of the coin's CoinProfile.
+	Hash uint32
+
+	// Operation is the operation the coin was surrendered by.
+	Operation Operation_Type
+
+	// Client is the hash of the ClientProfile of the client who surrendered the coin.
+	Client uint32
+
+	// Date is when the coin was surrendered.
+	Date time.Time
+}
+
+// DisputeRecord is a dispute kept by the bank, along with the operation and the client it was detected at.
+type DisputeRecord struct {
+	// ID is the dispute's number.
+	ID int64
+
+	// Operation is the operation the coin was surrendered by.
+	Operation Operation_Type
+
+	// Client is the hash of the client's ClientProfile.
+	Client uint32
+
+	// Dispute is the evidence, verified with core.Dispute.Verify.
+	Dispute core.Dispute
+}
+
+// FraudAlert is a withdrawal or a deposit matched by a fraud rule of the bank.
+type FraudAlert struct {
+	// ID is the alert's number.
+	ID int64
+
+	// Rule is the name of the rule matched.
+	Rule string
+
+	// Status is the action of the rule, or the admin's decision for approvals.
+	Status Alert_Type
+
+	// Operation is the operation matched, Operation_Withdrawal or Operation_Deposit.
+	Operation Operation_Type
+
+	// Client is the hash of the client's ClientProfile.
+	Client uint32
+
+	// Currency and Value are the operation's amount.
+	Currency string
+	Value    int64
+
+	// Date is the date the alert was raised.
+	Date time.Time
+}
+
+// Transfer is a transfer of balance between two clients' accounts.
+type Transfer struct {
+	ID int64
+
+	// Payer and Payee are the hashes of the debited and credited clients' ClientProfile.
+	Payer uint32
+	Payee uint32
+
+	// Currency and Value are the amount transferred.
+	Currency string
+	Value    int64
+
+	// Date is when the transfer was made.
+	Date time.Time
+}
+
+// AccountClosure is the closure of a client's account, along with the balances paid out.
+type AccountClosure struct {
+	// Client is the hash of the client's ClientProfile.
+	Client uint32
+
+	// Balances are the balances paid out, by currency. (Non-zero ones only)
+	Balances map[string]int64
+
+	// Date is when the account was closed.
+	Date time.Time
+}
+
+// WithdrawalStatus is the outcome of a withdrawal, kept by the bank under its idempotency token.
+type WithdrawalStatus struct {
+	// Token is the withdrawal's idempotency token, chosen by the client.
+	Token string
+
+	// Client is the hash of the client's ClientProfile.
+	Client uint32
+
+	// Status is the withdrawal's outcome, Status_Unknown if the bank has none.
+	Status Status_Type
+
+	// Currency and Value are the withdrawn amount.
+	Currency string
+	Value    int64
+
+	// Alert is the fraud alert holding the withdrawal, Status_Pending and Status_Refused only. (0 if none)
+	Alert int64
+
+	// Expiration, Validity, A1 and C1 are the coin response, Status_Issued only.
+	Expiration time.Time
+	Validity   time.Duration
+	A1         *big.Int `ziba:"optional"`
+	C1         *big.Int `ziba:"optional"`
+
+	// Date is the date of the outcome.
+	Date time.Time
+}
+
+// PendingWithdrawal is a withdrawal of a wallet whose outcome isn't known yet, kept along with its coin request.
+type PendingWithdrawal struct {
+	// Token is the withdrawal's idempotency token.
+	Token string
+
+	// Coin is the coin request, finished once the bank issues it.
+	Coin core.Coin
+
+	// Date is the date the withdrawal was first tried.
+	Date time.Time
+}
+
+// PaymentSession is the state of a payment in progress, by idempotency token, on the payer's or the merchant's side.
+type PaymentSession struct {
+	// Token is the payment's idempotency token.
+	Token string
+
+	// Payee is set on the merchant's side.
+	Payee bool
+
+	// Peer is the merchant's address on the payer's side, the payer's on the merchant's side.
+	Peer string
+
+	// Coin is the hash of the paid coin.
+	Coin uint32
+
+	// State is the last step reached.
+	State State_Type
+
+	// Data is the protocol's state, encoded by the network package.
+	Data []byte
+
+	// Date is the date of the last step.
+	Date time.Time
 }
@@ -2,8 +2,11 @@ package store
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/base64"
+	"encoding/json"
 	"log"
+	"math/big"
+	"sort"
 	"strconv"
 	"time"
 	"ziba/core"
@@ -11,15 +14,27 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+// Options sets the options of the database opened by New or NewReadOnly.
+func (store *ClientStore) Options(options DatabaseOptions) *ClientStore {
+	store.options = &options
+	return store
+}
+
 // New allocates and returns a new ClientStore for a bank identified by bankName.
 func (store *ClientStore) New(dbPath string) (*ClientStore, error) {
 	// Get database connection.
-	db, err := openDatabase(dbPath)
+	db, err := openDatabase(dbPath, false, store.options)
 	if err != nil {
 		log.Printf("failed to open database: %v", err)
 		return nil, err
 	}
+	if err := checkRole(db, "Client"); err != nil {
+		db.Close()
+		log.Printf("failed to open User's database at %s: %v", dbPath, err)
+		return nil, err
+	}
 	store.db = db
+	store.statements = new(statementCache).New(db)
 
 	// Init tables.
 	err = store.createTables()
@@ -32,6 +47,51 @@ func (store *ClientStore) New(dbPath string) (*ClientStore, error) {
 	return store, nil
 }
 
+// NewReadOnly is like New, but opens the existing database at dbPath read-only, for inspecting and reporting.
+func (store *ClientStore) NewReadOnly(dbPath string) (*ClientStore, error) {
+	// Get database connection.
+	db, err := openDatabase(dbPath, true, store.options)
+	if err != nil {
+		log.Printf("failed to open database: %v", err)
+		return nil, err
+	}
+	store.db = db
+	store.statements = new(statementCache).New(db)
+
+	// Create store.
+	return store, nil
+}
+
+// Close closes the database of the store.
+func (store *ClientStore) Close() error {
+	return store.db.Close()
+}
+
+// clientBlobColumns are the big.Int columns of a client's local database, by table.
+var clientBlobColumns = map[string][]string{
+	"Client":      {"TradeId", "Priv", "Pub", "Credential", "Contract"},
+	"BankProfile": {"Pub", "N", "E", "Q", "P", "G"},
+	"Mint":        {"N", "E"},
+	"RsaKey":      {"P", "Q", "D", "N", "E"},
+	"CoinPool":    {"E", "L", "LInv", "Beta1", "Beta1Inv", "Beta2", "Y", "YInv", "Priv", "Pub", "First"},
+	"CoinRandom":  {"E", "L", "LInv", "Beta1", "Beta1Inv", "Beta2", "Y", "YInv"},
+	"CoinElgamal": {"Priv", "Pub", "First", "Second", "Msg"},
+	"CoinParams":  {"A", "ALower", "C", "A1", "C1", "A2", "R"},
+	"CoinEscrow":  {"Release", "Refund", "Payee", "ReleaseSecret", "RefundSecret"},
+	"CoinMemo":    {"Payee", "Account", "ChangeALower", "ChangeC", "ChangeClaim"},
+	"CoinChange":  {"E", "L", "LInv", "Beta1", "Beta1Inv", "Beta2", "Y", "YInv", "Priv", "Pub", "First", "A", "ALower", "C", "Claim"},
+	"Revocations": {"R", "S"},
+}
+
+// clientIndices are the indices of a client's local database, by name, besides CoinClient.
+var clientIndices = map[string]string{
+	"CoinChangeClient":   "CoinChange (client)",
+	"CoinPoolClient":     "CoinPool (client)",
+	"SpentCoinClient":    "SpentCoin (client, date)",
+	"ReceiptClient":      "Receipt (client, date)",
+	"PayerPaymentClient": "PayerPayment (client, payer, currency, date)",
+}
+
 // CreateTables creates the database schema for a bank's local database.
 // Only creates the tables if they don't previously exist.
 func (store *ClientStore) createTables() error {
@@ -54,16 +114,21 @@ func (store *ClientStore) createTables() error {
 	Pub 			 TEXT NOT NULL,
 	Credential TEXT NOT NULL,
 	Contract 	 TEXT NOT NULL,
+	Expiration DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z',
 	---- BankProfile
 	---- RsaKey
 
-	localBalance  INTEGER NOT NULL,
+	localBalance  INTEGER NOT NULL, -- DefaultCurrency balances
 	remoteBalance INTEGER NOT NULL
 	);`
 	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
+	err = addColumn(tx, "Client", "Expiration", `DATETIME NOT NULL DEFAULT '0001-01-01T00:00:00Z'`)
+	if err != nil {
+		return err
+	}
 
 	table = `CREATE TABLE IF NOT EXISTS BankProfile (
 	-- keys
@@ -77,7 +142,52 @@ func (store *ClientStore) createTables() error {
 	---- SchemeParams
 	Q TEXT NOT NULL,
 	P TEXT NOT NULL,
-	G TEXT NOT NULL
+	G TEXT NOT NULL,
+
+	Currency TEXT NOT NULL DEFAULT '',
+
+	fingerprint TEXT NOT NULL DEFAULT '' -- SchemeParams fingerprint
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "BankProfile", "Currency", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "BankProfile", "fingerprint", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+
+	table = `CREATE TABLE IF NOT EXISTS Mint (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- BankProfile (The other fields are the ones of the client's BankProfile)
+	Currency TEXT NOT NULL,
+	N 			 TEXT NOT NULL,
+	E 			 TEXT NOT NULL,
+
+	UNIQUE (client, Currency) ON CONFLICT REPLACE
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
+	}
+
+	table = `CREATE TABLE IF NOT EXISTS ClientBalance (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	Currency 		  TEXT NOT NULL,
+	localBalance  INTEGER NOT NULL,
+	remoteBalance INTEGER NOT NULL,
+
+	UNIQUE (client, Currency)
 	);`
 	_, err = tx.Exec(table)
 	if err != nil {
@@ -116,6 +226,31 @@ func (store *ClientStore) createTables() error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS CoinClient ON Coin (client)`)
+	if err != nil {
+		return err
+	}
+	err = addColumn(tx, "Coin", "account", `TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+
+	table = `CREATE TABLE IF NOT EXISTS SubAccount (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- SubAccount
+	name 			 TEXT NOT NULL,
+	spendLimit INTEGER NOT NULL,
+	period 		 INTEGER NOT NULL, -- nanoseconds
+
+	UNIQUE (client, name) ON CONFLICT REPLACE
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
+	}
 
 	table = `CREATE TABLE IF NOT EXISTS CoinRandom (
 	-- keys
@@ -167,271 +302,1009 @@ func (store *ClientStore) createTables() error {
 	A1		 		 TEXT NOT NULL,
 	C1 				 TEXT NOT NULL,
 	A2 				 TEXT NOT NULL,
-	R 				 TEXT NOT NULL
+	R 				 TEXT NOT NULL,
+	Version 	 INTEGER NOT NULL DEFAULT 0,
+	Currency 	 TEXT NOT NULL DEFAULT '',
+	Value 		 INTEGER NOT NULL DEFAULT 0
 	);`
 	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
-
-	return tx.Commit()
-}
-
-// WriteClient attempts to write client into the local database.
-// If an entry exists for this ClientStore's bank nothing is written into the database.
-func (store *ClientStore) WriteClient(client *core.Client) error {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	err = addColumn(tx, "CoinParams", "Version", `INTEGER NOT NULL DEFAULT 0`)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
 		return err
 	}
-	defer tx.Rollback()
-
-	// Check if a client already exists for that bank.
-	var id int64
-	err = tx.QueryRow(`SELECT id FROM Client WHERE bank = ?`, store.BankName).Scan(&id)
-	if err != sql.ErrNoRows {
-		log.Printf("a client (id: %d) already exists for bank %s", id, store.BankName)
-		return nil
-	}
-
-	stmt := `INSERT INTO
-	Client (bank, TradeId, Priv, Pub, Credential, Contract, localBalance, remoteBalance)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
-	res, err := tx.Exec(stmt,
-		store.BankName,
-		toString(client.TradeId),
-		toString(client.Priv),
-		toString(client.Pub),
-		toString(client.Credential),
-		toString(client.Contract),
-		0,
-		100,
-	)
+	err = addColumn(tx, "CoinParams", "Currency", `TEXT NOT NULL DEFAULT ''`)
 	if err != nil {
 		return err
 	}
-	clientId, err := res.LastInsertId()
+	err = addColumn(tx, "CoinParams", "Value", `INTEGER NOT NULL DEFAULT 0`)
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO
-	BankProfile (client, Pub, N, E, Q, P, G)
-	VALUES 			(?, ?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		clientId,
-		toString(client.Bank.Pub),
-		toString(client.Bank.N),
-		toString(client.Bank.E),
-		toString(client.Bank.Scheme.Q),
-		toString(client.Bank.Scheme.P),
-		toString(client.Bank.Scheme.G),
-	)
+	table = `CREATE TABLE IF NOT EXISTS RefillPolicy (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- RefillPolicy
+	Currency 	TEXT NOT NULL,
+	threshold INTEGER NOT NULL,
+	target 		INTEGER NOT NULL,
+	value 		INTEGER NOT NULL,
+
+	UNIQUE (client, Currency) ON CONFLICT REPLACE
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO
-	RsaKey (client, P, Q, N, D, E)
-	VALUES (?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		clientId,
-		toString(client.Key.P),
-		toString(client.Key.Q),
-		toString(client.Key.N),
-		toString(client.Key.D),
-		toString(client.Key.E),
-	)
+	table = `CREATE TABLE IF NOT EXISTS Receipt (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+	hash 	 INTEGER NOT NULL, -- CoinProfile hash
+
+	-- Receipt
+	coin 				TEXT NOT NULL, -- Coin (binary, base64 encoded)
+	merchant 		TEXT NOT NULL,
+	certificate TEXT NOT NULL,
+	memo 				TEXT NOT NULL, -- Memo (JSON)
+	date 				DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS PayerPayment (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
 
-// ReadClient attempts to read the entry for this ClientStore's bank.
-// If no entry exists the return value is nil.
-func (store *ClientStore) ReadClient() (*core.Client, error) {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- PayerPayment
+	payer 		TEXT NOT NULL,
+	currency 	TEXT NOT NULL,
+	amount 		INTEGER NOT NULL,
+	date 			DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
-		return nil, err
+		return err
 	}
-	defer tx.Rollback()
 
-	stmt := `SELECT id, TradeId, Priv, Pub, Credential, Contract, localBalance, remoteBalance FROM Client WHERE bank = ?`
-	scanner := new(rowScanner).New(8)
-	err = tx.QueryRow(stmt, store.BankName).Scan(scanner.dest...)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-	vals := scanner.Strings()
-	client := &core.Client{
-		TradeId:    fromString(vals[1]),
-		Priv:       fromString(vals[2]),
-		Pub:        fromString(vals[3]),
-		Credential: fromString(vals[4]),
-		Contract:   fromString(vals[5]),
-	}
-	// Keep this client's id & balance.
-	store.clientId, _ = strconv.ParseInt(vals[0], 10, 64)
-	store.LocalBalance, _ = strconv.ParseInt(vals[6], 10, 64)
-	store.RemoteBalance, _ = strconv.ParseInt(vals[7], 10, 64)
+	table = `CREATE TABLE IF NOT EXISTS CoinReservation (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	coin INTEGER UNIQUE ON CONFLICT REPLACE REFERENCES Coin(id) ON DELETE CASCADE,
 
-	stmt = `SELECT P, Q, N, D, E FROM RsaKey WHERE client = ?`
-	scanner = new(rowScanner).New(5)
-	err = tx.QueryRow(stmt, store.clientId).Scan(scanner.dest...)
+	-- CoinReservation
+	expires DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		return nil, err
-	}
-	vals = scanner.Strings()
-	key := core.RsaKey{
-		P: fromString(vals[0]),
-		Q: fromString(vals[1]),
-		N: fromString(vals[2]),
-		D: fromString(vals[3]),
-		E: fromString(vals[4]),
+		return err
 	}
 
-	stmt = `SELECT Pub, N, E, Q, P, G FROM BankProfile WHERE client = ?`
-	scanner = new(rowScanner).New(6)
-	err = tx.QueryRow(stmt, store.clientId).Scan(scanner.dest...)
+	table = `CREATE TABLE IF NOT EXISTS CoinEscrow (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	coin INTEGER UNIQUE ON CONFLICT IGNORE REFERENCES Coin(id) ON DELETE CASCADE,
+
+	-- Escrow
+	Release TEXT NOT NULL,
+	Refund 	TEXT NOT NULL,
+	Timeout DATETIME NOT NULL,
+	Payee 	TEXT NOT NULL,
+	Date 		DATETIME NOT NULL,
+	---- Secrets
+	ReleaseSecret TEXT NOT NULL,
+	RefundSecret 	TEXT NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		return nil, err
-	}
-	vals = scanner.Strings()
-	bank := core.BankProfile{
-		Scheme: core.SchemeParams{
-			Q: fromString(vals[3]),
-			P: fromString(vals[4]),
-			G: fromString(vals[5]),
-		},
-		Pub: fromString(vals[0]),
-		N:   fromString(vals[1]),
-		E:   fromString(vals[2]),
+		return err
 	}
 
-	client.Key = key
-	client.Bank = bank
-
-	return client, tx.Commit()
-}
+	table = `CREATE TABLE IF NOT EXISTS CoinMemo (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	coin INTEGER UNIQUE ON CONFLICT IGNORE REFERENCES Coin(id) ON DELETE CASCADE,
 
-// WriteCoin writes coin into the local database.
-// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
-func (store *ClientStore) WriteCoin(coin *core.Coin, operation Operation_Type) error {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
+	-- Memo
+	Text    TEXT NOT NULL,
+	Payee   TEXT NOT NULL,
+	Date    DATETIME NOT NULL,
+	Account TEXT NOT NULL DEFAULT '',
+	---- Change
+	ChangeAmount INTEGER NOT NULL DEFAULT 0, -- 0 if the whole coin was spent
+	ChangeALower TEXT NOT NULL DEFAULT '',
+	ChangeC 		 TEXT NOT NULL DEFAULT '',
+	ChangeClaim  TEXT NOT NULL DEFAULT ''
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
-		log.Printf("failed to initiate transaction: %v", err)
 		return err
 	}
-	defer tx.Rollback()
-
-	stmt := `INSERT INTO
-	Coin 	 (client, hash)
-	VALUES (?, ?);`
-	res, err := tx.Exec(stmt, store.clientId, coin.Profile().Hash())
+	err = addColumn(tx, "CoinMemo", "Account", `TEXT NOT NULL DEFAULT ''`)
 	if err != nil {
 		return err
 	}
-	coinId, err := res.LastInsertId()
+	for _, col := range []string{"ChangeALower", "ChangeC", "ChangeClaim"} {
+		err = addColumn(tx, "CoinMemo", col, `TEXT NOT NULL DEFAULT ''`)
+		if err != nil {
+			return err
+		}
+	}
+	err = addColumn(tx, "CoinMemo", "ChangeAmount", `INTEGER NOT NULL DEFAULT 0`)
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO
-	CoinRandom (coin, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv)
-	VALUES		 (?, ?, ?, ?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		coinId,
-		toString(coin.Random.E),
-		toString(coin.Random.L),
-		toString(coin.Random.LInv),
-		toString(coin.Random.Beta1),
-		toString(coin.Random.Beta1Inv),
-		toString(coin.Random.Beta2),
-		toString(coin.Random.Y),
-		toString(coin.Random.YInv),
-	)
+	table = `CREATE TABLE IF NOT EXISTS CoinChange (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- CoinRandom
+	E 			 TEXT NOT NULL,
+	L 			 TEXT NOT NULL,
+	LInv   	 TEXT NOT NULL,
+	Beta1 	 TEXT NOT NULL,
+	Beta1Inv TEXT NOT NULL,
+	Beta2 	 TEXT NOT NULL,
+	Y 			 TEXT NOT NULL,
+	YInv 		 TEXT NOT NULL,
+	-- CoinElgamal
+	Priv 	TEXT NOT NULL,
+	Pub 	TEXT NOT NULL,
+	First TEXT NOT NULL,
+	-- CoinParams
+	A 			 TEXT NOT NULL,
+	ALower 	 TEXT UNIQUE ON CONFLICT IGNORE NOT NULL,
+	C 			 TEXT NOT NULL,
+	Version  INTEGER NOT NULL,
+	Currency TEXT NOT NULL,
+	Value 	 INTEGER NOT NULL,
+	---- Secret
+	Claim TEXT NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO
-	CoinElgamal (coin, Priv, Pub, First, Second, Msg)
-	VALUES 			(?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		coinId,
-		toString(coin.Elgamal.Priv),
-		toString(coin.Elgamal.Pub),
-		toString(coin.Elgamal.First),
-		toString(coin.Elgamal.Second),
-		toString(coin.Elgamal.Msg),
-	)
+	table = `CREATE TABLE IF NOT EXISTS Revocations (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- Revocations
+	Coins 	 TEXT NOT NULL,
+	Accounts TEXT NOT NULL,
+	Issued 	 DATETIME NOT NULL,
+	R 			 TEXT NOT NULL,
+	S 			 TEXT NOT NULL,
+
+	UNIQUE (client) ON CONFLICT REPLACE
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	stmt = `INSERT INTO
-	CoinParams (coin, A, ALower, C, Expiration, A1, C1, A2, R)
-	VALUES 		 (?, ?, ?, ?, ?, ?, ?, ?, ?);`
-	_, err = tx.Exec(stmt,
-		coinId,
-		toString(coin.Params.A),
-		toString(coin.Params.ALower),
-		toString(coin.Params.C),
-		coin.Params.Expiration,
-		toString(coin.Params.A1),
-		toString(coin.Params.C1),
-		toString(coin.Params.A2),
-		toString(coin.Params.R),
-	)
+	table = `CREATE TABLE IF NOT EXISTS PendingClient (
+	-- keys
+	id 	 INTEGER PRIMARY KEY AUTOINCREMENT,
+	bank TEXT UNIQUE ON CONFLICT REPLACE NOT NULL,
+
+	-- Client (binary, base64 encoded)
+	client TEXT NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
 
-	stmt = `UPDATE Client SET localBalance = localBalance + ? WHERE id = ?;`
-	_, err = tx.Exec(stmt, 1, store.clientId)
+	table = `CREATE TABLE IF NOT EXISTS SpentCoin (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+	hash 	 INTEGER NOT NULL, -- CoinProfile hash
+
+	-- SpentCoin
+	coin 			TEXT NOT NULL, -- Coin (binary, base64 encoded)
+	operation INTEGER NOT NULL,
+	memo 			TEXT NOT NULL,
+	date 			DATETIME NOT NULL
+	);`
+	_, err = tx.Exec(table)
 	if err != nil {
 		return err
 	}
-
-	// Update remote balance given the type of operation.
-	switch operation {
-	case Operation_Withdrawal:
-		stmt = `UPDATE Client Set remoteBalance = remoteBalance - ? WHERE id = ?`
-		_, err = tx.Exec(stmt, 1, store.clientId)
+	for _, col := range []string{"account", "currency"} {
+		err = addColumn(tx, "SpentCoin", col, `TEXT NOT NULL DEFAULT ''`)
 		if err != nil {
 			return err
 		}
-	case Operation_Payment:
-	case Operation_Deposit:
-	case Operation_Exchange:
-	default:
+	}
+	err = addColumn(tx, "SpentCoin", "value", `INTEGER NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
 	}
 
-	return tx.Commit()
-}
-
-// ReadCoins returns a tuple-like struct: a coin object paired with its database coin id.
+	table = `CREATE TABLE IF NOT EXISTS CoinPool (
+	-- keys
+	id 		 INTEGER PRIMARY KEY AUTOINCREMENT,
+	client INTEGER REFERENCES Client(id) ON DELETE CASCADE,
+
+	-- CoinRandom
+	E 			 TEXT NOT NULL,
+	L 			 TEXT NOT NULL,
+	LInv   	 TEXT NOT NULL,
+	Beta1 	 TEXT NOT NULL,
+	Beta1Inv TEXT NOT NULL,
+	Beta2 	 TEXT NOT NULL,
+	Y 			 TEXT NOT NULL,
+	YInv 		 TEXT NOT NULL,
+	-- CoinElgamal
+	Priv 	TEXT NOT NULL,
+	Pub 	TEXT NOT NULL,
+	First TEXT NOT NULL
+	);`
+	_, err = tx.Exec(table)
+	if err != nil {
+		return err
+	}
+
+	err = createMetaTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createCertificateTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createQuarantineTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createPendingWithdrawalTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createPaymentSessionTable(tx)
+	if err != nil {
+		return err
+	}
+
+	err = createIndices(tx, clientIndices)
+	if err != nil {
+		return err
+	}
+
+	// Migrate big.Int columns to blobs.
+	err = migrateBlobs(tx, clientBlobColumns)
+	if err != nil {
+		return err
+	}
+
+	// Fingerprint the scheme parameters of bank profiles written before.
+	err = backfillFingerprints(tx, "BankProfile", "Q", "P", "G")
+	if err != nil {
+		return err
+	}
+
+	// Sub-account columns, the reservations, Meta and Certificate tables and the indices were added above.
+	err = upgradeSchemaVersion(tx, indexVersion)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Meta returns the settings table of the wallet's database.
+func (store *ClientStore) Meta() *Meta {
+	return &Meta{db: store.db}
+}
+
+// Certificates returns the TLS certificates table of the wallet's database.
+func (store *ClientStore) Certificates() *Certificates {
+	return &Certificates{db: store.db}
+}
+
+// WriteClient attempts to write client into the local database.
+// If an entry exists for this ClientStore's bank nothing is written into the database.
+func (store *ClientStore) WriteClient(client *core.Client) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO
+	Client (bank, TradeId, Priv, Pub, Credential, Contract, Expiration, localBalance, remoteBalance)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	res, err := tx.Exec(stmt,
+		store.BankName,
+		toBlob(client.TradeId),
+		toBlob(client.Priv),
+		toBlob(client.Pub),
+		toBlob(client.Credential),
+		toBlob(client.Contract),
+		client.Expiration,
+		0,
+		initialBalance,
+	)
+	if err != nil {
+		return err
+	}
+	// Nothing is written if a client already exists for that bank. (bank is UNIQUE ON CONFLICT IGNORE)
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		log.Printf("a client already exists for bank %s", store.BankName)
+		return nil
+	}
+	clientId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO
+	BankProfile (client, Pub, N, E, Q, P, G, Currency, fingerprint)
+	VALUES 			(?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(stmt,
+		clientId,
+		toBlob(client.Bank.Pub),
+		toBlob(client.Bank.N),
+		toBlob(client.Bank.E),
+		toBlob(client.Bank.Scheme.Q),
+		toBlob(client.Bank.Scheme.P),
+		toBlob(client.Bank.Scheme.G),
+		core.NormalizeCurrency(client.Bank.Currency),
+		client.Bank.Scheme.Fingerprint(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO
+	RsaKey (client, P, Q, N, D, E)
+	VALUES (?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(stmt,
+		clientId,
+		toBlob(client.Key.P),
+		toBlob(client.Key.Q),
+		toBlob(client.Key.N),
+		toBlob(client.Key.D),
+		toBlob(client.Key.E),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReadClient attempts to read the entry for this ClientStore's bank.
+// If no entry exists the return value is nil.
+func (store *ClientStore) ReadClient() (*core.Client, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id, TradeId, Priv, Pub, Credential, Contract, localBalance, remoteBalance, Expiration FROM Client WHERE bank = ?`
+	scanner := new(rowScanner).New(8)
+	var expiration time.Time
+	err = tx.QueryRow(stmt, store.BankName).Scan(append(scanner.dest, &expiration)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	vals := scanner.Strings()
+	client := &core.Client{
+		TradeId:    fromBlob(vals[1]),
+		Priv:       fromBlob(vals[2]),
+		Pub:        fromBlob(vals[3]),
+		Credential: fromBlob(vals[4]),
+		Contract:   fromBlob(vals[5]),
+		Expiration: expiration,
+	}
+	// Keep this client's id & balance.
+	store.clientId, _ = strconv.ParseInt(vals[0], 10, 64)
+	store.LocalBalance, _ = strconv.ParseInt(vals[6], 10, 64)
+	store.RemoteBalance, _ = strconv.ParseInt(vals[7], 10, 64)
+
+	stmt = `SELECT P, Q, N, D, E FROM RsaKey WHERE client = ?`
+	scanner = new(rowScanner).New(5)
+	err = tx.QueryRow(stmt, store.clientId).Scan(scanner.dest...)
+	if err != nil {
+		return nil, err
+	}
+	vals = scanner.Strings()
+	key := core.RsaKey{
+		P: fromBlob(vals[0]),
+		Q: fromBlob(vals[1]),
+		N: fromBlob(vals[2]),
+		D: fromBlob(vals[3]),
+		E: fromBlob(vals[4]),
+	}
+
+	stmt = `SELECT Pub, N, E, Q, P, G, Currency, fingerprint FROM BankProfile WHERE client = ?`
+	scanner = new(rowScanner).New(8)
+	err = tx.QueryRow(stmt, store.clientId).Scan(scanner.dest...)
+	if err != nil {
+		return nil, err
+	}
+	vals = scanner.Strings()
+	bank := core.BankProfile{
+		Scheme: core.SchemeParams{
+			Q: fromBlob(vals[3]),
+			P: fromBlob(vals[4]),
+			G: fromBlob(vals[5]),
+		},
+		Pub:      fromBlob(vals[0]),
+		N:        fromBlob(vals[1]),
+		E:        fromBlob(vals[2]),
+		Currency: core.NormalizeCurrency(vals[6]),
+	}
+
+	// Check the scheme parameters weren't switched.
+	if err := checkFingerprint(&bank.Scheme, vals[7]); err != nil {
+		return nil, err
+	}
+
+	client.Key = key
+	client.Bank = bank
+
+	return client, tx.Commit()
+}
+
+// UpdateCredentials replaces the credentials of the entry for this ClientStore's bank.
+// Coins already written remain associated to the client.
+func (store *ClientStore) UpdateCredentials(client *core.Client) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE Client SET Credential = ?, Contract = ?, Expiration = ? WHERE bank = ?`
+	res, err := tx.Exec(stmt,
+		toBlob(client.Credential),
+		toBlob(client.Contract),
+		client.Expiration,
+		store.BankName,
+	)
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	// Pre-generated coins are bound to the previous contract.
+	stmt = `DELETE FROM CoinPool WHERE client = (SELECT id FROM Client WHERE bank = ?)`
+	_, err = tx.Exec(stmt, store.BankName)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// WritePartialCoins writes coins generated by core.Client.NewPartialCoin into the pool of pre-generated coins.
 // Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
-func (store *ClientStore) ReadCoins() ([]core.Coin, error) {
+func (store *ClientStore) WritePartialCoins(coins []*core.Coin) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO
+	CoinPool (client, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv, Priv, Pub, First)
+	VALUES 	 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	for _, coin := range coins {
+		_, err = tx.Exec(stmt,
+			store.clientId,
+			toBlob(coin.Random.E),
+			toBlob(coin.Random.L),
+			toBlob(coin.Random.LInv),
+			toBlob(coin.Random.Beta1),
+			toBlob(coin.Random.Beta1Inv),
+			toBlob(coin.Random.Beta2),
+			toBlob(coin.Random.Y),
+			toBlob(coin.Random.YInv),
+			toBlob(coin.Elgamal.Priv),
+			toBlob(coin.Elgamal.Pub),
+			toBlob(coin.Elgamal.First),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TakePartialCoin removes and returns a coin from the pool of pre-generated coins.
+// If the pool is empty the return value is nil.
+func (store *ClientStore) TakePartialCoin() (*core.Coin, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT id, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv, Priv, Pub, First FROM CoinPool WHERE client = ? ORDER BY id LIMIT 1`
+	scanner := new(rowScanner).New(12)
+	err = tx.QueryRow(stmt, store.clientId).Scan(scanner.dest...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	vals := scanner.Strings()
+
+	_, err = tx.Exec(`DELETE FROM CoinPool WHERE id = ?`, vals[0])
+	if err != nil {
+		return nil, err
+	}
+
+	coin := &core.Coin{
+		Random: core.CoinRandom{
+			E:        fromBlob(vals[1]),
+			L:        fromBlob(vals[2]),
+			LInv:     fromBlob(vals[3]),
+			Beta1:    fromBlob(vals[4]),
+			Beta1Inv: fromBlob(vals[5]),
+			Beta2:    fromBlob(vals[6]),
+			Y:        fromBlob(vals[7]),
+			YInv:     fromBlob(vals[8]),
+		},
+		Elgamal: core.CoinElgamal{
+			Priv:  fromBlob(vals[9]),
+			Pub:   fromBlob(vals[10]),
+			First: fromBlob(vals[11]),
+		},
+	}
+
+	return coin, tx.Commit()
+}
+
+// CountPartialCoins returns the number of coins in the pool of pre-generated coins.
+func (store *ClientStore) CountPartialCoins() (int, error) {
+	var count int
+	err := store.db.QueryRow(`SELECT COUNT(*) FROM CoinPool WHERE client = ?`, store.clientId).Scan(&count)
+	return count, err
+}
+
+// WriteCoin writes coin into the local database.
+// If an entry exists for the coin's profile hash, ErrExistingCoin is returned.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteCoin(coin *core.Coin, operation Operation_Type) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO
+	Coin 	 (client, hash)
+	VALUES (?, ?);`
+	res, err := store.statements.exec(tx, stmt, store.clientId, coin.Profile().Hash())
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrExistingCoin
+	}
+	coinId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO
+	CoinRandom (coin, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv)
+	VALUES		 (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = store.statements.exec(tx, stmt,
+		coinId,
+		toBlob(coin.Random.E),
+		toBlob(coin.Random.L),
+		toBlob(coin.Random.LInv),
+		toBlob(coin.Random.Beta1),
+		toBlob(coin.Random.Beta1Inv),
+		toBlob(coin.Random.Beta2),
+		toBlob(coin.Random.Y),
+		toBlob(coin.Random.YInv),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO
+	CoinElgamal (coin, Priv, Pub, First, Second, Msg)
+	VALUES 			(?, ?, ?, ?, ?, ?);`
+	_, err = store.statements.exec(tx, stmt,
+		coinId,
+		toBlob(coin.Elgamal.Priv),
+		toBlob(coin.Elgamal.Pub),
+		toBlob(coin.Elgamal.First),
+		toBlob(coin.Elgamal.Second),
+		toBlob(coin.Elgamal.Msg),
+	)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO
+	CoinParams (coin, A, ALower, C, Expiration, A1, C1, A2, R, Version, Currency, Value)
+	VALUES 		 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = store.statements.exec(tx, stmt,
+		coinId,
+		toBlob(coin.Params.A),
+		toBlob(coin.Params.ALower),
+		toBlob(coin.Params.C),
+		coin.Params.Expiration,
+		toBlob(coin.Params.A1),
+		toBlob(coin.Params.C1),
+		toBlob(coin.Params.A2),
+		toBlob(coin.Params.R),
+		coin.Params.Version,
+		core.NormalizeCurrency(coin.Params.Currency),
+		core.NormalizeValue(coin.Params.Value),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Update balances given the type of operation.
+	value := core.NormalizeValue(coin.Params.Value)
+	remote := int64(0)
+	if operation == Operation_Withdrawal {
+		remote = -value
+	}
+	err = store.updateBalance(tx, coin.Params.Currency, value, remote)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateBalance adds local and remote to this client's balances in currency using tx.
+func (store *ClientStore) updateBalance(tx *sql.Tx, currency string, local, remote int64) error {
+	if core.NormalizeCurrency(currency) == core.DefaultCurrency {
+		stmt := `UPDATE Client SET localBalance = localBalance + ?, remoteBalance = remoteBalance + ? WHERE id = ?`
+		_, err := store.statements.exec(tx, stmt, local, remote, store.clientId)
+		return err
+	}
+
+	// The remote balance starts at initialBalance, as the bank's.
+	stmt := `INSERT INTO ClientBalance (client, Currency, localBalance, remoteBalance) VALUES (?, ?, ?, ?)
+	ON CONFLICT (client, Currency) DO UPDATE SET localBalance = localBalance + ?, remoteBalance = remoteBalance + ?`
+	_, err := store.statements.exec(tx, stmt, store.clientId, currency, local, initialBalance+remote, local, remote)
+	return err
+}
+
+// DebitRemoteBalance subtracts value from this client's remote balance in currency, e.g. once transferred to another
+// account. Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) DebitRemoteBalance(currency string, value int64) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.updateBalance(tx, currency, 0, -value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReadBalances returns this client's balances in every currency it has used, starting with DefaultCurrency.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadBalances() ([]Balance, error) {
+	balances := []Balance{{Currency: core.DefaultCurrency}}
+	stmt := `SELECT localBalance, remoteBalance FROM Client WHERE id = ?`
+	err := store.db.QueryRow(stmt, store.clientId).Scan(&balances[0].Local, &balances[0].Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `SELECT Currency, localBalance, remoteBalance FROM ClientBalance WHERE client = ? ORDER BY Currency`
+	rows, err := store.db.Query(stmt, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var balance Balance
+		if err := rows.Scan(&balance.Currency, &balance.Local, &balance.Remote); err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+// ReconcileBalances recomputes this client's local balances from its coins, the value of every coin held (the spent
+// amount of a partially spent coin), and returns the balances that drifted from it. If fix is set, the drifted balances
+// are replaced by the recomputed ones, within the same transaction.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReconcileBalances(fix bool) ([]BalanceDrift, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Recompute local balances from coin rows.
+	actual := map[string]int64{core.DefaultCurrency: 0}
+	stmt := `SELECT CoinParams.Currency, SUM(COALESCE(
+		(SELECT CoinMemo.ChangeAmount FROM CoinMemo WHERE CoinMemo.coin = Coin.id AND CoinMemo.ChangeAmount > 0),
+		CASE WHEN CoinParams.Value = 0 THEN 1 ELSE CoinParams.Value END))
+	FROM Coin JOIN CoinParams ON CoinParams.coin = Coin.id WHERE Coin.client = ? GROUP BY CoinParams.Currency`
+	rows, err := tx.Query(stmt, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			currency string
+			value    int64
+		)
+		if err := rows.Scan(&currency, &value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		actual[core.NormalizeCurrency(currency)] += value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Read recorded local balances.
+	recorded := make(map[string]int64)
+	var local int64
+	err = tx.QueryRow(`SELECT localBalance FROM Client WHERE id = ?`, store.clientId).Scan(&local)
+	if err != nil {
+		return nil, err
+	}
+	recorded[core.DefaultCurrency] = local
+	rows, err = tx.Query(`SELECT Currency, localBalance FROM ClientBalance WHERE client = ?`, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency, &local); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		recorded[currency] = local
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Compare. (Currencies with no coins left must be back to 0)
+	for currency := range recorded {
+		if _, found := actual[currency]; !found {
+			actual[currency] = 0
+		}
+	}
+	var drifts []BalanceDrift
+	for currency, value := range actual {
+		if recorded[currency] != value {
+			drifts = append(drifts, BalanceDrift{Currency: currency, Recorded: recorded[currency], Actual: value})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Currency < drifts[j].Currency })
+	if !fix || len(drifts) == 0 {
+		return drifts, nil
+	}
+
+	// Fix drifted balances. (Adjusting local balances only, remote ones are the bank's)
+	for _, drift := range drifts {
+		err = store.updateBalance(tx, drift.Currency, drift.Actual-drift.Recorded, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return drifts, tx.Commit()
+}
+
+// WriteMints writes the mint profiles of this client's bank. Profiles already written are replaced.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteMints(mints []core.BankProfile) error {
 	// Begin a transaction.
 	tx, err := store.db.Begin()
 	if err != nil {
 		log.Printf("failed to initiate transaction: %v", err)
-		return nil, err
+		return err
 	}
 	defer tx.Rollback()
 
-	stmt := `SELECT id FROM Coin WHERE client = ?`
-	rows, err := tx.Query(stmt, store.clientId)
+	stmt := `INSERT INTO
+	Mint 	 (client, Currency, N, E)
+	VALUES (?, ?, ?, ?);`
+	for _, mint := range mints {
+		_, err = tx.Exec(stmt, store.clientId, core.NormalizeCurrency(mint.Currency), toBlob(mint.N), toBlob(mint.E))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReadMint returns the mint profile issuing coins in currency of client's bank. Returns ErrUnknownCurrency if no mint
+// profile was written for currency.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadMint(client *core.Client, currency string) (*core.BankProfile, error) {
+	currency = core.NormalizeCurrency(currency)
+	if currency == core.NormalizeCurrency(client.Bank.Currency) {
+		return &client.Bank, nil
+	}
+
+	var n, e string
+	stmt := `SELECT N, E FROM Mint WHERE client = ? AND Currency = ?`
+	err := store.db.QueryRow(stmt, store.clientId, currency).Scan(&n, &e)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownCurrency
+	} else if err != nil {
+		return nil, err
+	}
+
+	mint := client.Bank
+	mint.N = fromBlob(n)
+	mint.E = fromBlob(e)
+	mint.Currency = currency
+	return &mint, nil
+}
+
+// WriteRevocations writes list, the bank's latest revocation list, replacing the previous one.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteRevocations(list *core.Revocations) error {
+	stmt := `INSERT INTO
+	Revocations (client, Coins, Accounts, Issued, R, S)
+	VALUES 			(?, ?, ?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt,
+		store.clientId,
+		toHashes(list.Coins),
+		toHashes(list.Accounts),
+		list.Issued,
+		toBlob(list.R),
+		toBlob(list.S),
+	)
+	return err
+}
+
+// ReadBanks returns the names of the banks the wallet holds an account at.
+func (store *ClientStore) ReadBanks() ([]string, error) {
+	rows, err := store.db.Query(`SELECT bank FROM Client ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var banks []string
+	for rows.Next() {
+		var bank string
+		if err := rows.Scan(&bank); err != nil {
+			return nil, err
+		}
+		banks = append(banks, bank)
+	}
+	return banks, rows.Err()
+}
+
+// ReadRevocations returns the bank's latest revocation list, or nil if none was fetched.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadRevocations() (*core.Revocations, error) {
+	var coins, accounts, r, s string
+	list := new(core.Revocations)
+	stmt := `SELECT Coins, Accounts, Issued, R, S FROM Revocations WHERE client = ?`
+	err := store.db.QueryRow(stmt, store.clientId).Scan(&coins, &accounts, &list.Issued, &r, &s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list.Coins = fromHashes(coins)
+	list.Accounts = fromHashes(accounts)
+	list.R = fromBlob(r)
+	list.S = fromBlob(s)
+	return list, nil
+}
+
+// WritePendingClient keeps client, whose account application is pending the bank's approval, until approved.
+func (store *ClientStore) WritePendingClient(client *core.Client) error {
+	data, err := client.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO PendingClient (bank, client) VALUES (?, ?);`
+	_, err = store.db.Exec(stmt, store.BankName, base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+// ReadPendingClient returns the client whose account application is pending, or nil if there is none.
+func (store *ClientStore) ReadPendingClient() (*core.Client, error) {
+	var encoded string
+	err := store.db.QueryRow(`SELECT client FROM PendingClient WHERE bank = ?`, store.BankName).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var client core.Client
+	if err := client.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// DeletePendingClient deletes the pending client, once its application is decided.
+func (store *ClientStore) DeletePendingClient() error {
+	_, err := store.db.Exec(`DELETE FROM PendingClient WHERE bank = ?`, store.BankName)
+	return err
+}
+
+// ReadCoins returns a tuple-like struct: a coin object paired with its database coin id.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+// Escrowed coins are not returned, see ReadEscrows, nor reserved ones, nor those of signed payments in progress, see
+// PaymentSession.
+func (store *ClientStore) ReadCoins() ([]core.Coin, error) {
+	stmt := coinQuery + ` WHERE Coin.client = ? AND Coin.id NOT IN (SELECT coin FROM CoinEscrow)
+	AND Coin.id NOT IN (SELECT coin FROM CoinReservation WHERE expires > ?)
+	AND Coin.hash NOT IN (SELECT coin FROM PaymentSession WHERE client = Coin.client AND NOT payee AND state >= ?)
+	ORDER BY Coin.id`
+	rows, err := store.db.Query(stmt, store.clientId, time.Now().UTC(), State_Signed)
 	if err != nil {
 		return nil, err
 	}
@@ -440,80 +1313,476 @@ func (store *ClientStore) ReadCoins() ([]core.Coin, error) {
 	var coins []core.Coin
 
 	for rows.Next() {
-		var coinId int64
+		coin, err := scanCoin(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		coins = append(coins, *coin)
+	}
+
+	return coins, rows.Err()
+}
+
+// CountExpiringCoins returns the number of coins of the wallet, of any bank, expiring before deadline, those already
+// expired included. Escrowed coins are left out. (Only their expiration is read)
+func (store *ClientStore) CountExpiringCoins(deadline time.Time) (int, error) {
+	stmt := `SELECT CoinParams.Expiration FROM Coin JOIN CoinParams ON CoinParams.coin = Coin.id
+	WHERE Coin.id NOT IN (SELECT coin FROM CoinEscrow)`
+	rows, err := store.db.Query(stmt)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// Expirations are compared here, they may be stored in different time zones.
+	var count int
+	for rows.Next() {
+		var expiration time.Time
+		if err := rows.Scan(&expiration); err != nil {
+			return 0, err
+		}
+		if expiration.Before(deadline) {
+			count++
+		}
+	}
+	return count, rows.Err()
+}
 
-		err := rows.Scan(&coinId)
-		if err == sql.ErrNoRows {
-			return nil, nil
-		} else if err != nil {
+// ReadAccountCoins returns the coins of the sub-account named account, those of the main account if empty. Escrowed
+// and reserved coins, and those of signed payments in progress, are left out, as by ReadCoins.
+func (store *ClientStore) ReadAccountCoins(account string) ([]core.Coin, error) {
+	stmt := coinQuery + ` WHERE Coin.client = ? AND Coin.account = ? AND Coin.id NOT IN (SELECT coin FROM CoinEscrow)
+	AND Coin.id NOT IN (SELECT coin FROM CoinReservation WHERE expires > ?)
+	AND Coin.hash NOT IN (SELECT coin FROM PaymentSession WHERE client = Coin.client AND NOT payee AND state >= ?)
+	ORDER BY Coin.id`
+	rows, err := store.db.Query(stmt, store.clientId, account, time.Now().UTC(), State_Signed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coins []core.Coin
+	for rows.Next() {
+		coin, err := scanCoin(rows)
+		if err != nil {
 			return nil, err
 		}
+		coins = append(coins, *coin)
+	}
+
+	return coins, rows.Err()
+}
+
+// ReadCoinAccount returns the name of coin's sub-account, empty for the main account.
+func (store *ClientStore) ReadCoinAccount(coin *core.Coin) (string, error) {
+	var account string
+	stmt := `SELECT account FROM Coin WHERE client = ? AND hash = ?`
+	err := store.db.QueryRow(stmt, store.clientId, coin.Profile().Hash()).Scan(&account)
+	return account, err
+}
+
+// AcquireCoin reserves coin for a pending transaction, until released by ReleaseCoin or ttl elapses. Reserved coins
+// are skipped by ReadCoins and ReadAccountCoins. Returns ErrReservedCoin if coin is already reserved, sql.ErrNoRows if
+// it isn't in the wallet.
+func (store *ClientStore) AcquireCoin(coin *core.Coin, ttl time.Duration) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var coinId int64
+	stmt := `SELECT id FROM Coin WHERE client = ? AND hash = ?`
+	err = tx.QueryRow(stmt, store.clientId, coin.Profile().Hash()).Scan(&coinId)
+	if err != nil {
+		return err
+	}
+
+	// Expired reservations no longer hold.
+	now := time.Now().UTC()
+	_, err = tx.Exec(`DELETE FROM CoinReservation WHERE expires <= ?`, now)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM CoinReservation WHERE coin = ?`, coinId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrReservedCoin
+	}
+
+	_, err = tx.Exec(`INSERT INTO CoinReservation (coin, expires) VALUES (?, ?)`, coinId, now.Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseCoin releases the reservation of coin (if any). Deleting a coin releases it as well.
+func (store *ClientStore) ReleaseCoin(coin *core.Coin) error {
+	stmt := `DELETE FROM CoinReservation WHERE coin IN (SELECT id FROM Coin WHERE client = ? AND hash = ?)`
+	_, err := store.db.Exec(stmt, store.clientId, coin.Profile().Hash())
+	return err
+}
+
+// coinQuery selects coin entries along with their dependencies, in the order scanned by scanCoin.
+const coinQuery = `SELECT
+	CoinRandom.E, CoinRandom.L, CoinRandom.LInv, CoinRandom.Beta1, CoinRandom.Beta1Inv, CoinRandom.Beta2, CoinRandom.Y,
+	CoinRandom.YInv,
+	CoinElgamal.Priv, CoinElgamal.Pub, CoinElgamal.First, CoinElgamal.Second, CoinElgamal.Msg,
+	CoinParams.A, CoinParams.ALower, CoinParams.C, CoinParams.Expiration, CoinParams.A1, CoinParams.C1, CoinParams.A2,
+	CoinParams.R, CoinParams.Version, CoinParams.Currency, CoinParams.Value
+	FROM Coin
+	JOIN CoinRandom  ON CoinRandom.coin = Coin.id
+	JOIN CoinElgamal ON CoinElgamal.coin = Coin.id
+	JOIN CoinParams  ON CoinParams.coin = Coin.id`
+
+// readCoin reads the coin entry (and its dependencies) for coinId using tx.
+func readCoin(tx *sql.Tx, coinId int64) (*core.Coin, error) {
+	return scanCoin(tx.QueryRow(coinQuery+` WHERE Coin.id = ?`, coinId))
+}
+
+// scanCoin scans a coin from a row selected by coinQuery.
+func scanCoin(row scannable) (*core.Coin, error) {
+	scanner := new(rowScanner).New(21)
+	var (
+		version  int
+		currency string
+		value    int64
+	)
+	err := row.Scan(append(scanner.dest, &version, &currency, &value)...)
+	if err != nil {
+		return nil, err
+	}
+	vals := scanner.Strings()
+
+	random := core.CoinRandom{
+		E:        fromBlob(vals[0]),
+		L:        fromBlob(vals[1]),
+		LInv:     fromBlob(vals[2]),
+		Beta1:    fromBlob(vals[3]),
+		Beta1Inv: fromBlob(vals[4]),
+		Beta2:    fromBlob(vals[5]),
+		Y:        fromBlob(vals[6]),
+		YInv:     fromBlob(vals[7]),
+	}
+
+	elgamal := core.CoinElgamal{
+		Priv:   fromBlob(vals[8]),
+		Pub:    fromBlob(vals[9]),
+		First:  fromBlob(vals[10]),
+		Second: fromBlob(vals[11]),
+		Msg:    fromBlob(vals[12]),
+	}
+
+	expiration, _ := time.Parse(time.RFC3339, vals[16])
+	params := core.CoinParams{
+		A:          fromBlob(vals[13]),
+		ALower:     fromBlob(vals[14]),
+		C:          fromBlob(vals[15]),
+		Expiration: expiration,
+		A1:         fromBlob(vals[17]),
+		C1:         fromBlob(vals[18]),
+		A2:         fromBlob(vals[19]),
+		R:          fromBlob(vals[20]),
+		Version:    version,
+		Currency:   core.NormalizeCurrency(currency),
+		Value:      core.NormalizeValue(value),
+	}
+
+	coin := &core.Coin{
+		Random:  random,
+		Elgamal: elgamal,
+		Params:  params,
+	}
+
+	return coin, nil
+}
+
+// WriteEscrow writes the escrow conditions (and the known escrow secrets) for a coin previously written by WriteCoin.
+// Escrowed coins are no longer returned by ReadCoins.
+func (store *ClientStore) WriteEscrow(coin *core.Coin, escrow *core.Escrow, release *big.Int, refund *big.Int) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Grab coin's id.
+	var coinId int64
+	err = tx.QueryRow(`SELECT id FROM Coin WHERE hash = ? AND client = ?`, coin.Profile().Hash(), store.clientId).Scan(&coinId)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO
+	CoinEscrow (coin, Release, Refund, Timeout, Payee, Date, ReleaseSecret, RefundSecret)
+	VALUES 		 (?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(stmt,
+		coinId,
+		toBlob(escrow.Release),
+		toBlob(escrow.Refund),
+		escrow.Timeout,
+		toBlob(escrow.Payee),
+		escrow.Date,
+		toBlob(release),
+		toBlob(refund),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// WriteMemo writes the memo a coin previously written by WriteCoin was paid with.
+func (store *ClientStore) WriteMemo(coin *core.Coin, memo *core.Memo) error {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Grab coin's id.
+	var coinId int64
+	err = tx.QueryRow(`SELECT id FROM Coin WHERE hash = ? AND client = ?`, coin.Profile().Hash(), store.clientId).Scan(&coinId)
+	if err != nil {
+		return err
+	}
+
+	change := memo.Change
+	if change == nil {
+		change = new(core.Change)
+	}
+	stmt := `INSERT INTO
+	CoinMemo (coin, Text, Payee, Date, Account, ChangeAmount, ChangeALower, ChangeC, ChangeClaim)
+	VALUES 	 (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(stmt, coinId, memo.Text, toBlob(memo.Payee), memo.Date, toBlob(memo.Account),
+		change.Amount, toBlob(change.ALower), toBlob(change.C), toBlob(change.Claim))
+	if err != nil {
+		return err
+	}
+
+	// Only the spent amount of a partially spent coin is held by this client.
+	if memo.Change != nil {
+		err = store.updateBalance(tx, coin.Params.Currency, memo.Change.Amount-core.NormalizeValue(coin.Params.Value), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReadMemo returns the memo coin was paid with, or nil if it has none.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadMemo(coin *core.Coin) (*core.Memo, error) {
+	stmt := `SELECT CoinMemo.Text, CoinMemo.Payee, CoinMemo.Date, CoinMemo.Account, CoinMemo.ChangeAmount,
+	CoinMemo.ChangeALower, CoinMemo.ChangeC, CoinMemo.ChangeClaim
+	FROM Coin JOIN CoinMemo ON CoinMemo.coin = Coin.id WHERE Coin.hash = ? AND Coin.client = ?`
+
+	var (
+		memo           core.Memo
+		payee, account string
+		change         core.Change
+		values         [3]string
+	)
+	err := store.db.QueryRow(stmt, coin.Profile().Hash(), store.clientId).Scan(&memo.Text, &payee, &memo.Date, &account,
+		&change.Amount, &values[0], &values[1], &values[2])
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	memo.Payee = fromBlob(payee)
+	memo.Account = fromBlob(account)
+	if change.Amount > 0 {
+		change.ALower = fromBlob(values[0])
+		change.C = fromBlob(values[1])
+		change.Claim = fromBlob(values[2])
+		memo.Change = &change
+	}
+
+	return &memo, nil
+}
+
+// WriteChange writes the remainder coin request of a partial payment along with its claim secret, until it is collected.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteChange(coin *core.Coin, claim *big.Int) error {
+	stmt := `INSERT INTO
+	CoinChange (client, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv, Priv, Pub, First, A, ALower, C, Version, Currency, Value, Claim)
+	VALUES 		 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := store.db.Exec(stmt,
+		store.clientId,
+		toBlob(coin.Random.E),
+		toBlob(coin.Random.L),
+		toBlob(coin.Random.LInv),
+		toBlob(coin.Random.Beta1),
+		toBlob(coin.Random.Beta1Inv),
+		toBlob(coin.Random.Beta2),
+		toBlob(coin.Random.Y),
+		toBlob(coin.Random.YInv),
+		toBlob(coin.Elgamal.Priv),
+		toBlob(coin.Elgamal.Pub),
+		toBlob(coin.Elgamal.First),
+		toBlob(coin.Params.A),
+		toBlob(coin.Params.ALower),
+		toBlob(coin.Params.C),
+		coin.Params.Version,
+		core.NormalizeCurrency(coin.Params.Currency),
+		core.NormalizeValue(coin.Params.Value),
+		toBlob(claim),
+	)
+	return err
+}
+
+// ReadChanges returns the uncollected remainder coin requests of this client.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadChanges() ([]ChangeCoin, error) {
+	stmt := `SELECT E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv, Priv, Pub, First, A, ALower, C, Claim, Version, Currency, Value
+	FROM CoinChange WHERE client = ? ORDER BY id`
+	rows, err := store.db.Query(stmt, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		stmt = `SELECT E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv FROM CoinRandom WHERE coin = ?`
-		scanner := new(rowScanner).New(8)
-		err = tx.QueryRow(stmt, coinId).Scan(scanner.dest...)
+	var changes []ChangeCoin
+	for rows.Next() {
+		var (
+			version  int
+			currency string
+			value    int64
+		)
+		scanner := new(rowScanner).New(15)
+		err = rows.Scan(append(scanner.dest, &version, &currency, &value)...)
 		if err != nil {
 			return nil, err
 		}
 		vals := scanner.Strings()
-		random := core.CoinRandom{
-			E:        fromString(vals[0]),
-			L:        fromString(vals[1]),
-			LInv:     fromString(vals[2]),
-			Beta1:    fromString(vals[3]),
-			Beta1Inv: fromString(vals[4]),
-			Beta2:    fromString(vals[5]),
-			Y:        fromString(vals[6]),
-			YInv:     fromString(vals[7]),
-		}
-
-		stmt = `SELECT Priv, Pub, First, Second, Msg FROM CoinElgamal WHERE coin = ?`
-		scanner = new(rowScanner).New(5)
-		err = tx.QueryRow(stmt, coinId).Scan(scanner.dest...)
+
+		changes = append(changes, ChangeCoin{
+			Coin: core.Coin{
+				Random: core.CoinRandom{
+					E:        fromBlob(vals[0]),
+					L:        fromBlob(vals[1]),
+					LInv:     fromBlob(vals[2]),
+					Beta1:    fromBlob(vals[3]),
+					Beta1Inv: fromBlob(vals[4]),
+					Beta2:    fromBlob(vals[5]),
+					Y:        fromBlob(vals[6]),
+					YInv:     fromBlob(vals[7]),
+				},
+				Elgamal: core.CoinElgamal{
+					Priv:  fromBlob(vals[8]),
+					Pub:   fromBlob(vals[9]),
+					First: fromBlob(vals[10]),
+				},
+				Params: core.CoinParams{
+					A:        fromBlob(vals[11]),
+					ALower:   fromBlob(vals[12]),
+					C:        fromBlob(vals[13]),
+					Version:  version,
+					Currency: core.NormalizeCurrency(currency),
+					Value:    value,
+				},
+			},
+			Claim: fromBlob(vals[14]),
+		})
+	}
+
+	return changes, rows.Err()
+}
+
+// DeleteChange deletes the remainder coin request of coin, once collected.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) DeleteChange(coin *core.Coin) error {
+	stmt := `DELETE FROM CoinChange WHERE client = ? AND ALower = ?`
+	_, err := store.db.Exec(stmt, store.clientId, toBlob(coin.Params.ALower))
+	return err
+}
+
+// ReadEscrows returns all escrowed coins of this client.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadEscrows() ([]EscrowCoin, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
+	if err != nil {
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT Coin.id, CoinEscrow.Release, CoinEscrow.Refund, CoinEscrow.Timeout, CoinEscrow.Payee, CoinEscrow.Date,
+	CoinEscrow.ReleaseSecret, CoinEscrow.RefundSecret
+	FROM Coin JOIN CoinEscrow ON CoinEscrow.coin = Coin.id WHERE Coin.client = ?`
+	rows, err := tx.Query(stmt, store.clientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Scan escrows first, coins are read afterwards.
+	var (
+		ids     []int64
+		escrows []EscrowCoin
+	)
+	for rows.Next() {
+		// Scanner variables.
+		var (
+			coinId  int64
+			values  [3]string
+			secrets [2]string
+			timeout time.Time
+			date    time.Time
+		)
+
+		err = rows.Scan(&coinId, &values[0], &values[1], &timeout, &values[2], &date, &secrets[0], &secrets[1])
 		if err != nil {
 			return nil, err
 		}
-		vals = scanner.Strings()
-		elgamal := core.CoinElgamal{
-			Priv:   fromString(vals[0]),
-			Pub:    fromString(vals[1]),
-			First:  fromString(vals[2]),
-			Second: fromString(vals[3]),
-			Msg:    fromString(vals[4]),
-		}
 
-		stmt = `SELECT A, ALower, C, Expiration, A1, C1, A2, R FROM CoinParams WHERE coin = ?`
-		scanner = new(rowScanner).New(8)
-		err = tx.QueryRow(stmt, coinId).Scan(scanner.dest...)
+		ids = append(ids, coinId)
+		escrows = append(escrows, EscrowCoin{
+			Escrow: core.Escrow{
+				Release: fromBlob(values[0]),
+				Refund:  fromBlob(values[1]),
+				Timeout: timeout,
+				Payee:   fromBlob(values[2]),
+				Date:    date,
+			},
+			Release: fromBlob(secrets[0]),
+			Refund:  fromBlob(secrets[1]),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, coinId := range ids {
+		coin, err := readCoin(tx, coinId)
 		if err != nil {
 			return nil, err
 		}
-		vals = scanner.Strings()
-		expiration, _ := time.Parse(time.RFC3339, vals[3])
-		params := core.CoinParams{
-			A:          fromString(vals[0]),
-			ALower:     fromString(vals[1]),
-			C:          fromString(vals[2]),
-			Expiration: expiration,
-			A1:         fromString(vals[4]),
-			C1:         fromString(vals[5]),
-			A2:         fromString(vals[6]),
-			R:          fromString(vals[7]),
-		}
-
-		coin := core.Coin{
-			Random:  random,
-			Elgamal: elgamal,
-			Params:  params,
-		}
-
-		coins = append(coins, coin)
+		escrows[i].Coin = *coin
 	}
 
-	return coins, tx.Commit()
+	return escrows, tx.Commit()
 }
 
-// DeleteCoin deletes a coin entry (and its dependencies) given a coin id retrieved by a ReadCoins call.
+// DeleteCoin deletes a coin entry (and its dependencies) given a coin id retrieved by a ReadCoins call. The coin is
+// moved to the spent-coins archive, along with operation and its memo. (See ReadSpentCoins)
 func (store *ClientStore) DeleteCoin(coin *core.Coin, operation Operation_Type) error {
 	// Begin a transaction.
 	tx, err := store.db.Begin()
@@ -523,307 +1792,649 @@ func (store *ClientStore) DeleteCoin(coin *core.Coin, operation Operation_Type)
 	}
 	defer tx.Rollback()
 
-	stmt := `DELETE FROM Coin WHERE hash = ?`
-	_, err = tx.Exec(stmt, coin.Profile().Hash())
+	// Grab the value held by this client, the coin's memo and sub-account.
+	value, memo, account, err := store.coinHolding(tx, coin)
 	if err != nil {
 		return err
 	}
 
-	stmt = `UPDATE Client SET localBalance = localBalance - ? WHERE id = ?;`
-	_, err = tx.Exec(stmt, 1, store.clientId)
+	// Archive the coin. (Kept as evidence for disputes until purged, see PurgeSpentCoins)
+	data, err := coin.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO SpentCoin (client, hash, coin, operation, memo, date, account, currency, value)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = store.statements.exec(tx, stmt,
+		store.clientId,
+		coin.Profile().Hash(),
+		base64.StdEncoding.EncodeToString(data),
+		operation,
+		memo,
+		time.Now().UTC(),
+		account,
+		core.NormalizeCurrency(coin.Params.Currency),
+		value,
+	)
 	if err != nil {
 		return err
 	}
 
-	// Update remote balance given the type of operation.
-	switch operation {
-	case Operation_Withdrawal:
-	case Operation_Payment:
-	case Operation_Deposit:
-		stmt = `UPDATE Client Set remoteBalance = remoteBalance + ? WHERE id = ?`
-		_, err = tx.Exec(stmt, 1, store.clientId)
-		if err != nil {
-			return err
-		}
-	case Operation_Exchange:
-	default:
+	stmt = `DELETE FROM Coin WHERE hash = ?`
+	_, err = store.statements.exec(tx, stmt, coin.Profile().Hash())
+	if err != nil {
+		return err
+	}
+
+	// Update balances given the type of operation.
+	remote := int64(0)
+	if operation == Operation_Deposit {
+		remote = value
+	}
+	err = store.updateBalance(tx, coin.Params.Currency, -value, remote)
+	if err != nil {
+		return err
 	}
 
 	return tx.Commit()
 }
 
-// Inspect.
-func (store *ClientStore) Inspect() {
-	// Begin a transaction.
-	tx, err := store.db.Begin()
-	if err != nil {
-		log.Fatalf("failed to initiate transaction: %v", err)
+// coinHolding returns, using tx, the value of coin held by this client (the spent amount of a partially spent coin),
+// the text of its memo and its sub-account.
+func (store *ClientStore) coinHolding(tx *sql.Tx, coin *core.Coin) (value int64, memo string, account string, err error) {
+	value = core.NormalizeValue(coin.Params.Value)
+	var change int64
+	stmt := `SELECT CoinMemo.Text, CoinMemo.ChangeAmount FROM Coin JOIN CoinMemo ON CoinMemo.coin = Coin.id
+	WHERE Coin.hash = ?`
+	err = store.statements.queryRow(tx, stmt, coin.Profile().Hash()).Scan(&memo, &change)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, "", "", err
+	}
+	if change > 0 {
+		value = change
 	}
-	defer tx.Rollback()
 
-	// Client.
-	fmt.Printf("\nCLIENT\n")
-	rows, err := tx.Query(`SELECT id, bank, localBalance, remoteBalance FROM Client`)
+	stmt = `SELECT account FROM Coin WHERE hash = ?`
+	err = store.statements.queryRow(tx, stmt, coin.Profile().Hash()).Scan(&account)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, "", "", err
+	}
+	return value, memo, account, nil
+}
+
+// ReadSpentCoins returns the spent-coins archive of this client, oldest first.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadSpentCoins() ([]SpentCoin, error) {
+	stmt := `SELECT coin, operation, memo, date FROM SpentCoin WHERE client = ? ORDER BY date, id`
+	rows, err := store.db.Query(stmt, store.clientId)
 	if err != nil {
-		log.Fatalf("failed to query Client: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s\n", "ID", "Bank", "Local", "Remote")
+	defer rows.Close()
+
+	var spent []SpentCoin
 	for rows.Next() {
-		// Scanner variables.
 		var (
-			id       int64
-			bankName string
-			local    int64
-			remote   int64
+			encoded string
+			coin    SpentCoin
 		)
-
-		err = rows.Scan(&id, &bankName, &local, &remote)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+		if err := rows.Scan(&encoded, &coin.Operation, &coin.Memo, &coin.Date); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := coin.Coin.UnmarshalBinary(data); err != nil {
+			return nil, err
 		}
+		spent = append(spent, coin)
+	}
+
+	return spent, rows.Err()
+}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10s $%-9d $%-9d\n", id, bankName, local, remote)
+// PurgeSpentCoins deletes the coins spent before before from the spent-coins archive of this client. Returns the number
+// of coins deleted.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) PurgeSpentCoins(before time.Time) (int64, error) {
+	res, err := store.db.Exec(`DELETE FROM SpentCoin WHERE client = ? AND date < ?`, store.clientId, before.UTC())
+	if err != nil {
+		return 0, err
 	}
+	return res.RowsAffected()
+}
 
-	// Coin.
-	fmt.Printf("\nCOIN\n")
-	rows, err = tx.Query(`SELECT Coin.id, Coin.hash, Client.bank FROM Coin JOIN Client ON Coin.client = Client.id`)
+// WriteSubAccount creates the sub-account account, or updates its spending limit if it exists.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteSubAccount(account *SubAccount) error {
+	if account.Name == "" {
+		return ErrUnknownAccount
+	}
+	stmt := `INSERT INTO SubAccount (client, name, spendLimit, period) VALUES (?, ?, ?, ?)`
+	_, err := store.db.Exec(stmt, store.clientId, account.Name, account.Limit, int64(account.Period))
+	return err
+}
+
+// ReadSubAccount returns the sub-account named name, the main account (without a spending limit) if empty.
+// Returns ErrUnknownAccount if no such sub-account exists.
+func (store *ClientStore) ReadSubAccount(name string) (*SubAccount, error) {
+	account := &SubAccount{Name: name}
+	if name == "" {
+		return account, nil
+	}
+
+	var period int64
+	stmt := `SELECT spendLimit, period FROM SubAccount WHERE client = ? AND name = ?`
+	err := store.db.QueryRow(stmt, store.clientId, name).Scan(&account.Limit, &period)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownAccount
+	} else if err != nil {
+		return nil, err
+	}
+	account.Period = time.Duration(period)
+
+	return account, nil
+}
+
+// ReadSubAccounts returns the sub-accounts of this client, by name.
+func (store *ClientStore) ReadSubAccounts() ([]SubAccount, error) {
+	stmt := `SELECT name, spendLimit, period FROM SubAccount WHERE client = ? ORDER BY name`
+	rows, err := store.db.Query(stmt, store.clientId)
 	if err != nil {
-		log.Fatalf("failed to query Coin: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s\n", "ID", "CoinHash", "Bank")
+	defer rows.Close()
+
+	var accounts []SubAccount
 	for rows.Next() {
-		// Scanner variables.
 		var (
-			id       int64
-			coinHash int64
-			bankName string
+			account SubAccount
+			period  int64
 		)
-
-		err = rows.Scan(&id, &coinHash, &bankName)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+		if err := rows.Scan(&account.Name, &account.Limit, &period); err != nil {
+			return nil, err
 		}
-
-		// Print output row.
-		fmt.Printf("%-5d %-10.10d %-10s\n", id, coinHash, bankName)
+		account.Period = time.Duration(period)
+		accounts = append(accounts, account)
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("failed to commit transaction: %v", err)
-	}
+	return accounts, rows.Err()
 }
 
-// InspectFull.
-func (store *ClientStore) InspectFull() {
+// DeleteSubAccount deletes the sub-account named name. Its coins are moved back to the main account.
+func (store *ClientStore) DeleteSubAccount(name string) error {
 	// Begin a transaction.
 	tx, err := store.db.Begin()
 	if err != nil {
-		log.Fatalf("failed to initiate transaction: %v", err)
+		log.Printf("failed to initiate transaction: %v", err)
+		return err
 	}
 	defer tx.Rollback()
 
-	// Client.
-	fmt.Printf("\nCLIENT\n")
-	rows, err := tx.Query(`SELECT id, bank, localBalance, remoteBalance, TradeId, Priv, Pub, Credential, Contract FROM Client`)
+	res, err := tx.Exec(`DELETE FROM SubAccount WHERE client = ? AND name = ?`, store.clientId, name)
 	if err != nil {
-		log.Fatalf("failed to query Client: %v", err)
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrUnknownAccount
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "Bank", "Local", "Remote", "TradeId", "Priv", "Pub", "Credential", "Contract")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id            int64
-			bankName      string
-			localBalance  int64
-			remoteBalance int64
-			client        [5]string
-		)
 
-		err = rows.Scan(&id, &bankName, &localBalance, &remoteBalance, &client[0], &client[1], &client[2], &client[3], &client[4])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
-		}
+	_, err = tx.Exec(`UPDATE Coin SET account = '' WHERE client = ? AND account = ?`, store.clientId, name)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10s $%-9d $%-9d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, bankName, localBalance, remoteBalance, client[0], client[1], client[2], client[3], client[4])
+// MoveCoin moves coin into the sub-account named account, the main account if empty.
+// Returns ErrUnknownAccount if no such sub-account exists, sql.ErrNoRows if the coin isn't in the wallet.
+func (store *ClientStore) MoveCoin(coin *core.Coin, account string) error {
+	if _, err := store.ReadSubAccount(account); err != nil {
+		return err
 	}
 
-	// BankProfile.
-	fmt.Printf("\nBANK PROFILE\n")
-	rows, err = tx.Query(`SELECT id, client, Pub, N, E, Q, P, G FROM BankProfile`)
+	stmt := `UPDATE Coin SET account = ? WHERE client = ? AND hash = ?`
+	res, err := store.db.Exec(stmt, account, store.clientId, coin.Profile().Hash())
 	if err != nil {
-		log.Fatalf("failed to query BankProfile: %v", err)
+		return err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "ClientId", "Bank:Pub", "Bank:N", "Bank:E", "Scheme:Q", "Scheme:P", "Scheme:G")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id       int64
-			clientId int64
-			profile  [3]string
-			scheme   [3]string
-		)
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-		err = rows.Scan(&id, &clientId, &profile[0], &profile[1], &profile[2], &scheme[0], &scheme[1], &scheme[2])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
-		}
+// CheckSpendingLimit returns ErrSpendingLimit if paying amount in currency from the sub-account named account would
+// exceed its spending limit, counting the payments archived within its period.
+func (store *ClientStore) CheckSpendingLimit(account, currency string, amount int64) error {
+	subAccount, err := store.ReadSubAccount(account)
+	if err != nil {
+		return err
+	}
+	if subAccount.Limit == 0 {
+		return nil
+	}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, clientId, profile[0], profile[1], profile[2], scheme[0], scheme[1], scheme[2])
+	var spent int64
+	stmt := `SELECT COALESCE(SUM(value), 0) FROM SpentCoin
+	WHERE client = ? AND account = ? AND currency = ? AND operation = ? AND date >= ?`
+	since := time.Now().UTC().Add(-subAccount.Period)
+	err = store.db.QueryRow(stmt, store.clientId, account, core.NormalizeCurrency(currency), Operation_Payment, since).Scan(&spent)
+	if err != nil {
+		return err
 	}
+	if spent+amount > subAccount.Limit {
+		return ErrSpendingLimit
+	}
+	return nil
+}
 
-	// RsaKey.
-	fmt.Printf("\nRSA KEY\n")
-	rows, err = tx.Query(`SELECT id, client, P, Q, D, N, E FROM RsaKey`)
+// WriteRefillPolicy writes policy, replacing the refill policy of its currency.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteRefillPolicy(policy *RefillPolicy) error {
+	stmt := `INSERT INTO RefillPolicy (client, Currency, threshold, target, value) VALUES (?, ?, ?, ?, ?)`
+	_, err := store.db.Exec(stmt,
+		store.clientId,
+		core.NormalizeCurrency(policy.Currency),
+		policy.Threshold,
+		policy.Target,
+		core.NormalizeValue(policy.Value),
+	)
+	return err
+}
+
+// ReadRefillPolicies returns the refill policies of this client, by currency.
+func (store *ClientStore) ReadRefillPolicies() ([]RefillPolicy, error) {
+	stmt := `SELECT Currency, threshold, target, value FROM RefillPolicy WHERE client = ? ORDER BY Currency`
+	rows, err := store.db.Query(stmt, store.clientId)
 	if err != nil {
-		log.Fatalf("failed to query RsaKey: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "ClientId", "P", "Q", "D", "N", "E")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id       int64
-			clientId int64
-			rsaKey   [5]string
-		)
+	defer rows.Close()
 
-		err = rows.Scan(&id, &clientId, &rsaKey[0], &rsaKey[1], &rsaKey[2], &rsaKey[3], &rsaKey[4])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	var policies []RefillPolicy
+	for rows.Next() {
+		var policy RefillPolicy
+		if err := rows.Scan(&policy.Currency, &policy.Threshold, &policy.Target, &policy.Value); err != nil {
+			return nil, err
 		}
+		policies = append(policies, policy)
+	}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, clientId, rsaKey[0], rsaKey[1], rsaKey[2], rsaKey[3], rsaKey[4])
+	return policies, rows.Err()
+}
+
+// DeleteRefillPolicy deletes the refill policy of currency (if any).
+func (store *ClientStore) DeleteRefillPolicy(currency string) error {
+	stmt := `DELETE FROM RefillPolicy WHERE client = ? AND Currency = ?`
+	_, err := store.db.Exec(stmt, store.clientId, core.NormalizeCurrency(currency))
+	return err
+}
+
+// WriteReceipt writes receipt, with the hash of its coin.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WriteReceipt(receipt *Receipt) error {
+	data, err := receipt.Coin.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	memo, err := json.Marshal(&receipt.Memo)
+	if err != nil {
+		return err
 	}
+	stmt := `INSERT INTO Receipt (client, hash, coin, merchant, certificate, memo, date) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = store.db.Exec(stmt,
+		store.clientId,
+		receipt.Coin.Profile().Hash(),
+		base64.StdEncoding.EncodeToString(data),
+		receipt.Merchant,
+		receipt.Certificate,
+		string(memo),
+		receipt.Date.UTC(),
+	)
+	return err
+}
 
-	// Coin.
-	fmt.Printf("\nCOIN\n")
-	rows, err = tx.Query(`SELECT id, client, hash FROM Coin`)
+// ReadReceipts returns the receipts of this client, oldest first.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) ReadReceipts() ([]Receipt, error) {
+	stmt := `SELECT hash, coin, merchant, certificate, memo, date FROM Receipt WHERE client = ? ORDER BY date, id`
+	rows, err := store.db.Query(stmt, store.clientId)
 	if err != nil {
-		log.Fatalf("failed to query Coin: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s\n", "ID", "ClientId", "CoinHash")
+	defer rows.Close()
+
+	var receipts []Receipt
 	for rows.Next() {
-		// Scanner variables.
 		var (
-			id       int64
-			clientId int64
-			coinHash int64
+			encoded, memo string
+			receipt       Receipt
 		)
-
-		err = rows.Scan(&id, &clientId, &coinHash)
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+		if err := rows.Scan(&receipt.Hash, &encoded, &receipt.Merchant, &receipt.Certificate, &memo, &receipt.Date); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := receipt.Coin.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(memo), &receipt.Memo); err != nil {
+			return nil, err
 		}
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, rows.Err()
+}
+
+// WritePayerPayment records a received payment of amount in currency from payer, for CheckPayerLimit.
+// Only to be called after a ReadClient call to initialize the client's id of this ClientStore.
+func (store *ClientStore) WritePayerPayment(payer, currency string, amount int64) error {
+	stmt := `INSERT INTO PayerPayment (client, payer, currency, amount, date) VALUES (?, ?, ?, ?, ?)`
+	_, err := store.db.Exec(stmt, store.clientId, payer, core.NormalizeCurrency(currency), amount, time.Now().UTC())
+	return err
+}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10d %-10.10d\n", id, clientId, coinHash)
+// CheckPayerLimit returns ErrPayerLimit if payer's payments in currency received within the last day, plus amount,
+// exceed limit.
+func (store *ClientStore) CheckPayerLimit(payer, currency string, amount, limit int64) error {
+	var received int64
+	stmt := `SELECT COALESCE(SUM(amount), 0) FROM PayerPayment WHERE client = ? AND payer = ? AND currency = ? AND date >= ?`
+	since := time.Now().UTC().Add(-24 * time.Hour)
+	err := store.db.QueryRow(stmt, store.clientId, payer, core.NormalizeCurrency(currency), since).Scan(&received)
+	if err != nil {
+		return err
+	}
+	if received+amount > limit {
+		return ErrPayerLimit
 	}
+	return nil
+}
 
-	// CoinRandom.
-	fmt.Printf("\nCOIN RANDOM\n")
-	rows, err = tx.Query(`SELECT id, coin, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv FROM CoinRandom`)
+// ClientInspection is the contents of the client's database, as returned by Inspect and InspectFull. The tables and
+// columns tagged `inspect:"full"` are only set by InspectFull.
+type ClientInspection struct {
+	Clients      []ClientRow
+	Balances     []BalanceRow
+	BankProfiles []BankProfileRow `inspect:"full"`
+	RsaKeys      []RsaKeyRow      `inspect:"full"`
+	SubAccounts  []SubAccountRow
+	Coins        []CoinRow
+	CoinRandoms  []CoinRandomRow  `inspect:"full"`
+	CoinElgamals []CoinElgamalRow `inspect:"full"`
+	CoinParams   []CoinParamsRow  `inspect:"full"`
+}
+
+// ClientRow is an entry of the Client table.
+type ClientRow struct {
+	ID         int64
+	Bank       string
+	Local      int64
+	Remote     int64
+	TradeId    *big.Int `inspect:"full"`
+	Priv       *big.Int `inspect:"full"`
+	Pub        *big.Int `inspect:"full"`
+	Credential *big.Int `inspect:"full"`
+	Contract   *big.Int `inspect:"full"`
+}
+
+// BalanceRow is an entry of the ClientBalance table.
+type BalanceRow struct {
+	ID       int64
+	Bank     string
+	Currency string
+	Local    int64
+	Remote   int64
+}
+
+// BankProfileRow is an entry of the BankProfile table.
+type BankProfileRow struct {
+	ID          int64
+	ClientId    int64
+	Fingerprint string
+	Pub         *big.Int
+	N           *big.Int
+	E           *big.Int
+	SchemeQ     *big.Int
+	SchemeP     *big.Int
+	SchemeG     *big.Int
+}
+
+// RsaKeyRow is an entry of the RsaKey table.
+type RsaKeyRow struct {
+	ID       int64
+	ClientId int64
+	P        *big.Int
+	Q        *big.Int
+	D        *big.Int
+	N        *big.Int
+	E        *big.Int
+}
+
+// SubAccountRow is an entry of the SubAccount table.
+type SubAccountRow struct {
+	ID     int64
+	Bank   string
+	Name   string
+	Limit  int64
+	Period time.Duration
+}
+
+// CoinRow is an entry of the Coin table.
+type CoinRow struct {
+	ID       int64
+	CoinHash int64
+	Bank     string
+	Account  string
+}
+
+// CoinRandomRow is an entry of the CoinRandom table.
+type CoinRandomRow struct {
+	ID       int64
+	CoinId   int64
+	E        *big.Int
+	L        *big.Int
+	LInv     *big.Int
+	Beta1    *big.Int
+	Beta1Inv *big.Int
+	Beta2    *big.Int
+	Y        *big.Int
+	YInv     *big.Int
+}
+
+// CoinElgamalRow is an entry of the CoinElgamal table.
+type CoinElgamalRow struct {
+	ID     int64
+	CoinId int64
+	Priv   *big.Int
+	Pub    *big.Int
+	First  *big.Int
+	Second *big.Int
+	Msg    *big.Int
+}
+
+// CoinParamsRow is an entry of the CoinParams table.
+type CoinParamsRow struct {
+	ID         int64
+	CoinId     int64
+	Expiration time.Time
+	A          *big.Int
+	ALower     *big.Int
+	C          *big.Int
+	A1         *big.Int
+	C1         *big.Int
+	A2         *big.Int
+	R          *big.Int
+}
+
+// Inspect returns the contents of the client's database, without its numbers.
+func (store *ClientStore) Inspect() (*ClientInspection, error) {
+	return store.inspect(false)
+}
+
+// InspectFull returns the contents of the client's database, including its numbers.
+func (store *ClientStore) InspectFull() (*ClientInspection, error) {
+	return store.inspect(true)
+}
+
+// inspect returns the contents of the client's database, including its numbers if full.
+func (store *ClientStore) inspect(full bool) (*ClientInspection, error) {
+	// Begin a transaction.
+	tx, err := store.db.Begin()
 	if err != nil {
-		log.Fatalf("failed to query CoinRandom: %v", err)
+		log.Printf("failed to initiate transaction: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "CoinId", "E", "L", "LInv", "Beta1", "Beta1Inv", "Beta2", "Y", "YInv")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id     int64
-			coinId int64
-			random [8]string
-		)
+	defer tx.Rollback()
+
+	inspection := new(ClientInspection)
 
-		err = rows.Scan(&id, &coinId, &random[0], &random[1], &random[2], &random[3], &random[4], &random[5], &random[6], &random[7])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	// Client.
+	stmt := `SELECT id, bank, localBalance, remoteBalance` + fullColumns(full, `TradeId, Priv, Pub, Credential, Contract`) + ` FROM Client`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row ClientRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.Bank, &row.Local, &row.Remote}, &row.TradeId, &row.Priv, &row.Pub, &row.Credential, &row.Contract); err != nil {
+			return err
 		}
+		inspection.Clients = append(inspection.Clients, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query Client: %v", err)
+		return nil, err
+	}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, coinId, random[0], random[1], random[2], random[3], random[4], random[5], random[6], random[7])
+	// ClientBalance.
+	stmt = `SELECT ClientBalance.id, Client.bank, ClientBalance.Currency, ClientBalance.localBalance,
+	ClientBalance.remoteBalance FROM ClientBalance JOIN Client ON ClientBalance.client = Client.id`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row BalanceRow
+		if err := rows.Scan(&row.ID, &row.Bank, &row.Currency, &row.Local, &row.Remote); err != nil {
+			return err
+		}
+		inspection.Balances = append(inspection.Balances, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query ClientBalance: %v", err)
+		return nil, err
 	}
 
-	// CoinElgamal.
-	fmt.Printf("\nCOIN ELGAMAL\n")
-	rows, err = tx.Query(`SELECT id, coin, Priv, Pub, First, Second, Msg FROM CoinElgamal`)
+	// SubAccount.
+	stmt = `SELECT SubAccount.id, Client.bank, SubAccount.name, SubAccount.spendLimit, SubAccount.period
+	FROM SubAccount JOIN Client ON SubAccount.client = Client.id`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row SubAccountRow
+		if err := rows.Scan(&row.ID, &row.Bank, &row.Name, &row.Limit, &row.Period); err != nil {
+			return err
+		}
+		inspection.SubAccounts = append(inspection.SubAccounts, row)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("failed to query CoinElgamal: %v", err)
+		log.Printf("failed to query SubAccount: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-10s %-10s\n", "ID", "CoinId", "Priv", "Pub", "First", "Second", "Msg")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id      int64
-			coinId  int64
-			elgamal [5]string
-		)
 
-		err = rows.Scan(&id, &coinId, &elgamal[0], &elgamal[1], &elgamal[2], &elgamal[3], &elgamal[4])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	// Coin.
+	stmt = `SELECT Coin.id, Coin.hash, Client.bank, Coin.account FROM Coin JOIN Client ON Coin.client = Client.id`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row CoinRow
+		if err := rows.Scan(&row.ID, &row.CoinHash, &row.Bank, &row.Account); err != nil {
+			return err
 		}
+		inspection.Coins = append(inspection.Coins, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query Coin: %v", err)
+		return nil, err
+	}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10d %-10.10s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, coinId, elgamal[0], elgamal[1], elgamal[2], elgamal[3], elgamal[4])
+	// The remaining tables only hold numbers.
+	if !full {
+		return inspection, nil
 	}
 
-	// CoinParams.
-	fmt.Printf("\nCOIN PARAMS\n")
-	rows, err = tx.Query(`SELECT id, coin, A, ALower, C, Expiration, A1, C1, A2, R FROM CoinParams`)
+	// BankProfile.
+	err = inspectTable(tx, `SELECT id, client, fingerprint, Pub, N, E, Q, P, G FROM BankProfile`, func(rows *sql.Rows) error {
+		var row BankProfileRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.ClientId, &row.Fingerprint}, &row.Pub, &row.N, &row.E, &row.SchemeQ, &row.SchemeP, &row.SchemeG); err != nil {
+			return err
+		}
+		inspection.BankProfiles = append(inspection.BankProfiles, row)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("failed to query CoinParams: %v", err)
+		log.Printf("failed to query BankProfile: %v", err)
+		return nil, err
+	}
+
+	// RsaKey.
+	err = inspectTable(tx, `SELECT id, client, P, Q, D, N, E FROM RsaKey`, func(rows *sql.Rows) error {
+		var row RsaKeyRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.ClientId}, &row.P, &row.Q, &row.D, &row.N, &row.E); err != nil {
+			return err
+		}
+		inspection.RsaKeys = append(inspection.RsaKeys, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query RsaKey: %v", err)
+		return nil, err
 	}
-	// Print output header.
-	fmt.Printf("%-5s %-10s %-10s %-10s %-10s %-23s %-10s %-10s %-10s %-10s\n", "ID", "CoinId", "A", "ALower", "C", "Expiration", "A1", "C1", "A2", "R")
-	for rows.Next() {
-		// Scanner variables.
-		var (
-			id         int64
-			coinId     int64
-			params     [7]string
-			expiration time.Time
-		)
 
-		err = rows.Scan(&id, &coinId, &params[0], &params[1], &params[2], &expiration, &params[3], &params[4], &params[5], &params[6])
-		if err == sql.ErrNoRows {
-			break
-		} else if err != nil {
-			log.Fatalf("failed to scan: %v", err)
+	// CoinRandom.
+	err = inspectTable(tx, `SELECT id, coin, E, L, LInv, Beta1, Beta1Inv, Beta2, Y, YInv FROM CoinRandom`, func(rows *sql.Rows) error {
+		var row CoinRandomRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.CoinId}, &row.E, &row.L, &row.LInv, &row.Beta1, &row.Beta1Inv, &row.Beta2, &row.Y, &row.YInv); err != nil {
+			return err
 		}
+		inspection.CoinRandoms = append(inspection.CoinRandoms, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query CoinRandom: %v", err)
+		return nil, err
+	}
 
-		// Print output row.
-		fmt.Printf("%-5d %-10d %-10.10s %-10.10s %-10.10s %-23.23s %-10.10s %-10.10s %-10.10s %-10.10s\n", id, coinId, params[0], params[1], params[2], expiration.String(), params[3], params[4], params[5], params[6])
+	// CoinElgamal.
+	err = inspectTable(tx, `SELECT id, coin, Priv, Pub, First, Second, Msg FROM CoinElgamal`, func(rows *sql.Rows) error {
+		var row CoinElgamalRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.CoinId}, &row.Priv, &row.Pub, &row.First, &row.Second, &row.Msg); err != nil {
+			return err
+		}
+		inspection.CoinElgamals = append(inspection.CoinElgamals, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query CoinElgamal: %v", err)
+		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Fatalf("failed to commit transaction: %v", err)
+	// CoinParams.
+	stmt = `SELECT id, coin, Expiration, A, ALower, C, A1, C1, A2, R FROM CoinParams`
+	err = inspectTable(tx, stmt, func(rows *sql.Rows) error {
+		var row CoinParamsRow
+		if err := scanInspected(rows, full, []interface{}{&row.ID, &row.CoinId, &row.Expiration}, &row.A, &row.ALower, &row.C, &row.A1, &row.C1, &row.A2, &row.R); err != nil {
+			return err
+		}
+		inspection.CoinParams = append(inspection.CoinParams, row)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to query CoinParams: %v", err)
+		return nil, err
 	}
+
+	return inspection, nil
 }
@@ -0,0 +1,158 @@
+//go:build js && wasm
+
+// Command wasm is the client core of ziba compiled to WebAssembly, for browser wallets. It sets a global ziba object:
+//
+//	ziba.newCoinRequest(client, value)          Promise of a coin request, to withdraw.
+//	ziba.finishCoin(client, coin, response)     Promise of the coin finished using the bank's response.
+//	ziba.pay(url, client, coin, memo)           Promise of the payment of coin to the merchant at url.
+//
+// The client, coins and responses are JSON strings, as encoded by core. (A response is {"Expiration", "A1", "C1"})
+// The wallet is kept by the page: nothing is stored here. Payments are taken by merchants over WebSocket, see
+// network.PaymentWebSocketPath.
+//
+// Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o ziba.wasm ./wasm
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"syscall/js"
+	"time"
+	"ziba/core"
+)
+
+// main.
+func main() {
+	js.Global().Set("ziba", js.ValueOf(map[string]any{
+		"newCoinRequest": js.FuncOf(newCoinRequest),
+		"finishCoin":     js.FuncOf(finishCoin),
+		"pay":            js.FuncOf(pay),
+	}))
+
+	// Keep the functions alive.
+	select {}
+}
+
+// coinResponse is the bank's response to a coin request.
+type coinResponse struct {
+	Expiration time.Time
+	A1         string
+	C1         string
+}
+
+// newCoinRequest returns a Promise of a coin request of value (args[1]), for client (args[0]).
+func newCoinRequest(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("newCoinRequest(client, value)")
+		}
+		client, err := parseClient(args[0])
+		if err != nil {
+			return nil, err
+		}
+		coin := client.NewCoinRequest(nil).SetValue(int64(args[1].Int()))
+		return encode(coin)
+	})
+}
+
+// finishCoin returns a Promise of coin (args[1]) of client (args[0]) finished using the bank's response (args[2]).
+func finishCoin(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("finishCoin(client, coin, response)")
+		}
+		client, err := parseClient(args[0])
+		if err != nil {
+			return nil, err
+		}
+		coin, err := parseCoin(args[1])
+		if err != nil {
+			return nil, err
+		}
+		var response coinResponse
+		if err := json.Unmarshal([]byte(args[2].String()), &response); err != nil {
+			return nil, err
+		}
+		A1, ok := new(big.Int).SetString(response.A1, 10)
+		if !ok {
+			return nil, core.ErrEncoding
+		}
+		C1, ok := new(big.Int).SetString(response.C1, 10)
+		if !ok {
+			return nil, core.ErrEncoding
+		}
+		client.FinishCoin(coin, response.Expiration, A1, C1)
+		if !coin.Profile().VerifyProperties(&client.Bank) {
+			return nil, fmt.Errorf("invalid coin response")
+		}
+		return encode(coin)
+	})
+}
+
+// pay returns a Promise of whether the merchant at url (args[0]) accepted the payment of coin (args[2]) of client
+// (args[1]), stamped with memo (args[3]).
+func pay(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		if len(args) != 4 {
+			return nil, fmt.Errorf("pay(url, client, coin, memo)")
+		}
+		client, err := parseClient(args[1])
+		if err != nil {
+			return nil, err
+		}
+		coin, err := parseCoin(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return new(PaymentClient).New(args[0].String(), client).Memo(args[3].String()).Execute(coin)
+	})
+}
+
+// promise runs f in the background, and returns a Promise of its result.
+func promise(f func() (any, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+			result, err := f()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(executor)
+}
+
+// parseClient returns the client encoded by value.
+func parseClient(value js.Value) (*core.Client, error) {
+	client := new(core.Client)
+	if err := client.UnmarshalJSON([]byte(value.String())); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// parseCoin returns the coin encoded by value.
+func parseCoin(value js.Value) (*core.Coin, error) {
+	coin := new(core.Coin)
+	if err := coin.UnmarshalJSON([]byte(value.String())); err != nil {
+		return nil, err
+	}
+	return coin, nil
+}
+
+// encode returns the JSON encoding of data, as a string.
+func encode(data json.Marshaler) (any, error) {
+	encoded, err := data.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
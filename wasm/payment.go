@@ -0,0 +1,107 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math/big"
+	"ziba/core"
+)
+
+//
+// PAYMENT (4/6)
+//
+
+// 1. The minimal payment of network.PaymentClient: a whole coin, not escrowed, bound to the merchant's account by a
+//		(possibly empty) memo. The messages are the same, over WebSocket.
+// 2. The merchant is authenticated by the browser, along with the TLS certificate of its WebSocket.
+
+// PaymentClient.
+type PaymentClient struct {
+	url    string
+	client *core.Client
+	memo   string
+}
+
+// New.
+func (c *PaymentClient) New(url string, client *core.Client) *PaymentClient {
+	c.url = url
+	c.client = client
+	return c
+}
+
+// Memo attaches memo to the payment, the merchant stamps the coin with it and presents it to the bank at deposit.
+func (c *PaymentClient) Memo(memo string) *PaymentClient {
+	c.memo = memo
+	return c
+}
+
+// Execute pays coin, and reports whether the merchant accepted it.
+func (c *PaymentClient) Execute(coin *core.Coin) (bool, error) {
+	// Check memo.
+	if len(c.memo) > core.MaxMemoLength {
+		return false, core.ErrMemoLength
+	}
+
+	// Connect to server.
+	conn, err := dial(c.url)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+
+	// SEND CoinProfile.
+	coinProfile := coin.Profile()
+	if err := encoder.Encode(*coinProfile); err != nil {
+		return false, fmt.Errorf("failed to encode CoinProfile message: %v", err)
+	}
+
+	// SEND escrow request.
+	request := struct {
+		Escrow *core.Escrow
+		Memo   string
+		Change *core.Change
+	}{
+		Memo: c.memo,
+	}
+	if err := encoder.Encode(request); err != nil {
+		return false, fmt.Errorf("failed to encode Escrow request message: %v", err)
+	}
+
+	// RECV Elgamal's msg.
+	var stamp struct {
+		Msg    *big.Int
+		Escrow *core.Escrow
+		Memo   *core.Memo
+	}
+	if err := decoder.Decode(&stamp); err != nil {
+		return false, fmt.Errorf("failed to decode Elgamal's msg message: %v", err)
+	}
+	if err := core.CheckMessage(&stamp); err != nil {
+		return false, fmt.Errorf("failed to decode Elgamal's msg message: %v", err)
+	}
+
+	// Check the message binds the memo and the merchant's account before signing.
+	if stamp.Msg == nil || stamp.Memo == nil || stamp.Memo.Text != c.memo || stamp.Msg.Cmp(stamp.Memo.Msg(coinProfile)) != 0 {
+		return false, fmt.Errorf("merchant's message is not bound to the memo")
+	}
+
+	// Sign coin.
+	second := c.client.SignCoin(coin, stamp.Msg)
+
+	// SEND Elgamal's second.
+	if err := encoder.Encode(second); err != nil {
+		return false, fmt.Errorf("failed to encode Elgamal's second message: %v", err)
+	}
+
+	// RECV acceptance.
+	var accept bool
+	if err := decoder.Decode(&accept); err != nil {
+		return false, fmt.Errorf("failed to decode acceptance message: %v", err)
+	}
+	return accept, nil
+}
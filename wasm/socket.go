@@ -0,0 +1,119 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"syscall/js"
+)
+
+// socket is a WebSocket of the browser, as a stream: each write is sent as a binary frame, the frames received are
+// read in order. The callbacks of the browser never block: frames are queued until read.
+type socket struct {
+	ws        js.Value
+	listeners map[string]js.Func // By event type.
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	queue     []byte
+	open      bool
+	closed    bool
+}
+
+// dial opens a WebSocket to url, and returns it once connected.
+func dial(url string) (*socket, error) {
+	s := &socket{ws: js.Global().Get("WebSocket").New(url), listeners: make(map[string]js.Func)}
+	s.cond = sync.NewCond(&s.mutex)
+	s.ws.Set("binaryType", "arraybuffer")
+
+	s.on("open", func(event js.Value) {
+		s.open = true
+	})
+	s.on("message", func(event js.Value) {
+		data := js.Global().Get("Uint8Array").New(event.Get("data"))
+		frame := make([]byte, data.Get("length").Int())
+		js.CopyBytesToGo(frame, data)
+		s.queue = append(s.queue, frame...)
+	})
+	s.on("close", func(event js.Value) {
+		s.closed = true
+	})
+	s.on("error", func(event js.Value) {
+		s.closed = true
+	})
+
+	// Wait until connected.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for !s.open && !s.closed {
+		s.cond.Wait()
+	}
+	if !s.open {
+		s.release()
+		return nil, errors.New("failed to connect to " + url)
+	}
+	return s, nil
+}
+
+// on handles the events of type kind of the socket with handle, holding the mutex.
+func (s *socket) on(kind string, handle func(event js.Value)) {
+	f := js.FuncOf(func(this js.Value, args []js.Value) any {
+		s.mutex.Lock()
+		handle(args[0])
+		s.mutex.Unlock()
+		s.cond.Broadcast()
+		return nil
+	})
+	s.listeners[kind] = f
+	s.ws.Call("addEventListener", kind, f)
+}
+
+// Read reads the frames received, io.EOF once they are read and the socket closed.
+func (s *socket) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.queue)
+	s.queue = s.queue[n:]
+	return n, nil
+}
+
+// Write sends p as a binary frame.
+func (s *socket) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	data := js.Global().Get("Uint8Array").New(len(p))
+	js.CopyBytesToJS(data, p)
+	s.ws.Call("send", data)
+	return len(p), nil
+}
+
+// Close closes the socket, and releases its callbacks.
+func (s *socket) Close() error {
+	s.ws.Call("close")
+	s.mutex.Lock()
+	s.closed = true
+	s.release()
+	s.mutex.Unlock()
+	s.cond.Broadcast()
+	return nil
+}
+
+// release releases the callbacks of the socket.
+func (s *socket) release() {
+	for kind, f := range s.listeners {
+		s.ws.Call("removeEventListener", kind, f)
+		f.Release()
+		delete(s.listeners, kind)
+	}
+}